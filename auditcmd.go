@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runAudit implements "foldersync audit -dst <url>", which checks the
+// destination against its manifest, or a copy of it kept elsewhere,
+// without a source.
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL to audit (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	verifyKey := fs.String("verify-key", "", "Ed25519 public key (PEM) the destination's manifest must be signed with")
+	checksum := fs.Bool("checksum", false, "also download every object the manifest records a SHA-256 for and compare its content")
+	manifestFile := fs.String("manifest", "", "audit against this manifest, exported by an earlier audit, instead of the destination's")
+	export := fs.String("export", "", "write the manifest audited against to this file, to audit later runs' objects against")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync audit -dst <url> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *manifestFile != "" && *verifyKey != "" {
+		fmt.Fprintln(os.Stderr, "-verify-key checks the destination's manifest, and cannot be combined with -manifest")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := sync.AuditOptions{Checksum: *checksum}
+	var err error
+	if opts.Dst, err = openRestoreDst(ctx, *dstURL, *region); err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	if *manifestFile != "" {
+		opts.Manifest, err = readManifestFile(*manifestFile)
+	} else {
+		opts.Manifest, err = readDstManifest(ctx, opts.Dst, *verifyKey)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "manifest: %v\n", err)
+		return 1
+	}
+	if *export != "" {
+		if err := writeManifestFile(*export, opts.Manifest); err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			return 1
+		}
+	}
+
+	report, err := sync.Audit(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit failed: %v\n", err)
+		return 1
+	}
+	for _, m := range report.Mismatches {
+		fmt.Println(m)
+	}
+	fmt.Printf("audited %d files against the manifest of %s: %d differences, %d compared by content",
+		report.Files, report.Manifest.Local().Format(time.DateTime), len(report.Mismatches), report.Hashed)
+	if report.Archived > 0 {
+		fmt.Printf(", %d archived compared by metadata only", report.Archived)
+	}
+	fmt.Println()
+	if len(report.Mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readDstManifest reads the manifest of dst, which must be signed with
+// the key in the file at verifyKey if that is set.
+func readDstManifest(ctx context.Context, dst sync.Destination, verifyKey string) (*sync.Manifest, error) {
+	var pub ed25519.PublicKey
+	if verifyKey != "" {
+		var err error
+		if pub, err = sync.LoadVerifyKey(verifyKey); err != nil {
+			return nil, fmt.Errorf("verify key: %w", err)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: without -verify-key, a manifest altered along with the objects it lists is not noticed")
+	}
+	m, err := sync.ReadManifest(ctx, dst, pub)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("the destination has none; sync it with -manifest first")
+	}
+	return m, err
+}
+
+// readManifestFile reads a manifest written by writeManifestFile.
+func readManifestFile(path string) (*sync.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m sync.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// writeManifestFile writes m to the file at path, as JSON.
+func writeManifestFile(path string, m *sync.Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
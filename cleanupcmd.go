@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runCleanup implements "foldersync cleanup -dst <url> [-older-than <age>]".
+func runCleanup(args []string) int {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL whose incomplete multipart uploads to abort (required)")
+	olderThan := fs.String("older-than", "7d", "abort uploads started longer ago than this, e.g. 7d or 12h; keep it longer than any run, whose uploads are in progress")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	dryRun := fs.Bool("dry-run", false, "print the uploads that would be aborted without aborting them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync cleanup -dst <url> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	age, err := sync.ParseAge(*olderThan)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-older-than: %v\n", err)
+		return 2
+	}
+
+	ctx := context.Background()
+	rawURL, err := withParams(*dstURL, map[string]string{"region": *region})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	dst, err := sync.Open(ctx, rawURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+
+	aborted, err := sync.AbortStaleUploads(ctx, dst, age, *dryRun)
+	for _, u := range aborted {
+		fmt.Printf("abort %s (started %s)\n", u.Key, u.Started.Local().Format("2006-01-02 15:04"))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cleanup failed: %v\n", err)
+		return 1
+	}
+	if *dryRun {
+		fmt.Printf("%d incomplete upload(s) would be aborted\n", len(aborted))
+	} else {
+		fmt.Printf("%d incomplete upload(s) aborted\n", len(aborted))
+	}
+	return 0
+}
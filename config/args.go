@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// notFlags are the job keys that are not flags of a run.
+var notFlags = map[string]bool{"schedule": true, "jitter": true, "log-dir": true}
+
+// Args returns the command-line flags of a foldersync run that does what
+// the job describes. Only the keys set in the file are passed, so the
+// rest keep the defaults of the flags.
+func (j *Job) Args() []string {
+	var args []string
+	v := reflect.ValueOf(j).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("yaml")
+		if _, ok := j.fields[key]; !ok || notFlags[key] {
+			continue
+		}
+		switch val := v.Field(i).Interface().(type) {
+		case map[string]string: // tags, sse-context
+			name := key
+			if key == "tags" {
+				name = "tag"
+			}
+			for _, k := range slices.Sorted(maps.Keys(val)) {
+				args = append(args, "-"+name+"="+k+"="+val[k])
+			}
+		case []string: // quota-trim, notify-mail-to, notify-on
+			for _, s := range val {
+				args = append(args, "-"+key+"="+s)
+			}
+		case []Tier:
+			for _, t := range val {
+				args = append(args, "-tier="+t.arg())
+			}
+		case []MetadataRule:
+			for _, r := range val {
+				for _, a := range r.args() {
+					args = append(args, "-metadata="+a)
+				}
+			}
+		case []CompareRule:
+			for _, r := range val {
+				args = append(args, "-compare-rule="+r.Pattern+"="+r.Compare)
+			}
+		default:
+			args = append(args, fmt.Sprintf("-%s=%v", key, val))
+		}
+	}
+	return args
+}
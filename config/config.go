@@ -0,0 +1,424 @@
+// Package config loads foldersync job definitions from a YAML file.
+//
+// A configuration file holds named jobs, each of which mirrors the flags of
+// a single foldersync run, and may say how often to run it:
+//
+//	jobs:
+//	  photos:
+//	    src: /home/me/photos
+//	    dst: s3://my-backup-bucket/photos
+//	    storage-class: GLACIER_IR
+//	    delete: true
+//	    max-change: 20
+//	    schedule: "0 2 * * *"
+//
+// Values may refer to environment variables, as ${NAME}, and to secrets
+// kept elsewhere, as ${file:///path}, ${keyring://service/account} or
+// ${aws-sm://name}, so that none need be written in the file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a parsed configuration file.
+type Config struct {
+	Path string
+	Jobs []*Job // in file order
+}
+
+// Job is one named sync job.
+type Job struct {
+	Name string `yaml:"-"`
+	Line int    `yaml:"-"` // line the job is defined on
+
+	Src           string            `yaml:"src"`
+	Dst           string            `yaml:"dst"`
+	Region        string            `yaml:"region"`
+	AccountID     string            `yaml:"account-id"`
+	StorageClass  string            `yaml:"storage-class"`
+	SSE           string            `yaml:"sse"`
+	SSEKMSKeyID   string            `yaml:"sse-kms-key-id"`
+	SSEContext    map[string]string `yaml:"sse-context"`
+	EndpointURL   string            `yaml:"endpoint-url"`
+	PathStyle     bool              `yaml:"path-style"`
+	Compat        string            `yaml:"compat"`
+	TLSSkipVerify bool              `yaml:"tls-skip-verify"`
+	Accelerate    bool              `yaml:"accelerate"`
+	RequesterPays bool              `yaml:"requester-pays"`
+	DryRun        bool              `yaml:"dry-run"`
+	Itemize       bool              `yaml:"itemize"`
+	Quiet         bool              `yaml:"quiet"`
+	Verbose       bool              `yaml:"verbose"`
+	KeepGoing     bool              `yaml:"keep-going"`
+	FileTimeout   time.Duration     `yaml:"file-timeout"`
+	StallTimeout  time.Duration     `yaml:"stall-timeout"`
+	Delete        bool              `yaml:"delete"`
+	DeleteTo      string            `yaml:"delete-to"`
+	ReadOnly      bool              `yaml:"read-only"`
+	Isolate       bool              `yaml:"isolate"`
+	RemoteLock    bool              `yaml:"remote-lock"`
+	LockStale     time.Duration     `yaml:"lock-stale"`
+
+	Profile         string `yaml:"profile"`
+	RoleARN         string `yaml:"role-arn"`
+	ExternalID      string `yaml:"external-id"`
+	RoleSessionName string `yaml:"role-session-name"`
+
+	PartSizeMB          int  `yaml:"part-size-mb"`
+	UploadConcurrency   int  `yaml:"upload-concurrency"`
+	AdaptiveConcurrency bool `yaml:"adaptive-concurrency"`
+	LeavePartsOnError   bool `yaml:"leave-parts-on-error"`
+	ListConcurrency     int  `yaml:"list-concurrency"`
+	WalkConcurrency     int  `yaml:"walk-concurrency"`
+	StatConcurrency     int  `yaml:"stat-concurrency"`
+
+	CreateBucket          bool `yaml:"create-bucket"`
+	AbortUploadsAfterDays int  `yaml:"abort-uploads-after-days"`
+
+	DeleteExcluded   bool   `yaml:"delete-excluded"`
+	ExpireAfterDays  int    `yaml:"expire-after-days"`
+	ReportExtraneous string `yaml:"report-extraneous"`
+
+	PreservePOSIX bool   `yaml:"preserve-posix"`
+	Sparse        bool   `yaml:"sparse"`
+	Compress      string `yaml:"compress"`
+	Checksums     bool   `yaml:"checksums"`
+	AppendUploads bool   `yaml:"append-uploads"`
+	ContentType   string `yaml:"content-type"`
+	KeyLayout     string `yaml:"key-layout"`
+	NameKeyFile   string `yaml:"name-key-file"`
+	Unicode       string `yaml:"unicode"`
+
+	StripComponents int    `yaml:"strip-components"`
+	KeyTemplate     string `yaml:"key-template"`
+	LowercaseKeys   bool   `yaml:"lowercase-keys"`
+
+	CaseCollisions string `yaml:"case-collisions"`
+	OnWalkError    string `yaml:"on-walk-error"`
+
+	Tags  map[string]string `yaml:"tags"`
+	Tiers []Tier            `yaml:"tiers"`
+
+	Metadata []MetadataRule `yaml:"metadata"`
+
+	LockMode   string `yaml:"lock-mode"`
+	LockRetain string `yaml:"lock-retain"`
+	LegalHold  bool   `yaml:"legal-hold"`
+
+	MinSize        string `yaml:"min-size"`
+	MaxSize        string `yaml:"max-size"`
+	ModifiedAfter  string `yaml:"modified-after"`
+	ModifiedBefore string `yaml:"modified-before"`
+
+	SkipInFlight    bool          `yaml:"skip-in-flight"`
+	InFlightAge     time.Duration `yaml:"in-flight-age"`
+	InFlightPattern []string      `yaml:"in-flight-pattern"`
+
+	Compare        string        `yaml:"compare"`
+	MtimeWindow    time.Duration `yaml:"mtime-window"`
+	ReconcileEvery int           `yaml:"reconcile-every"`
+	NetworkSource  bool          `yaml:"network-source"`
+	CompareRules   []CompareRule `yaml:"compare-rules"`
+
+	MaxChange float64 `yaml:"max-change"`
+	Force     bool    `yaml:"force"`
+
+	MaxDstSize string   `yaml:"max-dst-size"`
+	QuotaTrim  []string `yaml:"quota-trim"`
+	QuotaFill  bool     `yaml:"quota-fill"`
+
+	Priority          string        `yaml:"priority"`
+	MaxDuration       time.Duration `yaml:"max-duration"`
+	MaxTransfer       string        `yaml:"max-transfer"`
+	MaxRequestsPerRun int           `yaml:"max-requests-per-run"`
+	RequestsPerSecond float64       `yaml:"requests-per-second"`
+
+	Manifest          bool   `yaml:"manifest"`
+	ManifestChecksums bool   `yaml:"manifest-checksums"`
+	SignKey           string `yaml:"sign-key"`
+	VerifyKey         string `yaml:"verify-key"`
+
+	VerifyAfterUpload bool `yaml:"verify-after-upload"`
+	StageUploads      bool `yaml:"stage-uploads"`
+
+	ScanSecrets bool `yaml:"scan-secrets"`
+
+	PreCmd  string `yaml:"pre-cmd"`
+	PostCmd string `yaml:"post-cmd"`
+
+	SkipUnchangedDirs bool    `yaml:"skip-unchanged-dirs"`
+	NoCache           bool    `yaml:"no-cache"`
+	HashCacheVerify   float64 `yaml:"hash-cache-verify"`
+
+	Incremental bool          `yaml:"incremental"`
+	FullEvery   time.Duration `yaml:"full-every"`
+	Checkpoint  time.Duration `yaml:"checkpoint"`
+	Snapshots   bool          `yaml:"snapshots"`
+
+	DetectRenames bool `yaml:"detect-renames"`
+	Dedupe        bool `yaml:"dedupe"`
+	HardLinks     bool `yaml:"hard-links"`
+	KeepEmptyDirs bool `yaml:"keep-empty-dirs"`
+
+	SourceSnapshot  string `yaml:"source-snapshot"`
+	LVMSnapshotSize string `yaml:"lvm-snapshot-size"`
+
+	AlsoDst      []string `yaml:"also-dst"`
+	ParallelDsts bool     `yaml:"parallel-dsts"`
+
+	MetaCacheAge time.Duration `yaml:"meta-cache-age"`
+
+	BundleThresholdKB int64 `yaml:"bundle-threshold-kb"`
+	BundleSizeMB      int64 `yaml:"bundle-size-mb"`
+
+	ChunkThresholdMB int64 `yaml:"chunk-threshold-mb"`
+	ChunkSizeKB      int   `yaml:"chunk-size-kb"`
+
+	TwoWay   bool   `yaml:"two-way"`
+	Conflict string `yaml:"conflict"`
+
+	Watch    bool          `yaml:"watch"`
+	Debounce time.Duration `yaml:"debounce"`
+
+	// Schedule is when the job runs in daemon mode: an interval or a cron
+	// expression; see ParseSchedule. Jitter, if positive, delays each run
+	// by a random time up to it. LogDir, if set, is a directory to write
+	// the output of each run to, in a file of its own.
+	Schedule string        `yaml:"schedule"`
+	Jitter   time.Duration `yaml:"jitter"`
+	LogDir   string        `yaml:"log-dir"`
+
+	MetricsAddr   string `yaml:"metrics-addr"`
+	ControlSocket string `yaml:"control-socket"`
+	Pushgateway   string `yaml:"pushgateway"`
+	PushJob       string `yaml:"push-job"`
+
+	NotifyWebhook  string   `yaml:"notify-webhook"`
+	NotifySlack    string   `yaml:"notify-slack"`
+	NotifySMTP     string   `yaml:"notify-smtp"`
+	NotifyMailFrom string   `yaml:"notify-mail-from"`
+	NotifyMailTo   []string `yaml:"notify-mail-to"`
+	NotifyOn       []string `yaml:"notify-on"`
+	NotifyChanges  int      `yaml:"notify-changes"`
+
+	fields map[string]int // line of each key, for error reporting
+}
+
+// Tier stores files matching Pattern, or every file if it is empty, in
+// Class once they were last modified at least After ago, as -tier does.
+type Tier struct {
+	Pattern string `yaml:"pattern"`
+	Class   string `yaml:"class"`
+	After   string `yaml:"after"`
+}
+
+// arg returns t as the value of a -tier flag.
+func (t Tier) arg() string {
+	s := t.Class + ":" + t.After
+	if t.Pattern != "" {
+		s = t.Pattern + "=" + s
+	}
+	return s
+}
+
+// MetadataRule sets HTTP headers and custom metadata on the uploads of
+// files matching Pattern, as -metadata does.
+type MetadataRule struct {
+	Pattern            string            `yaml:"pattern"`
+	CacheControl       string            `yaml:"cache-control"`
+	ContentDisposition string            `yaml:"content-disposition"`
+	ContentEncoding    string            `yaml:"content-encoding"`
+	Metadata           map[string]string `yaml:"metadata"`
+}
+
+// args returns r as the values of -metadata flags.
+func (r MetadataRule) args() []string {
+	var args []string
+	for _, h := range []struct{ name, value string }{
+		{"Cache-Control", r.CacheControl},
+		{"Content-Disposition", r.ContentDisposition},
+		{"Content-Encoding", r.ContentEncoding},
+	} {
+		if h.value != "" {
+			args = append(args, r.Pattern+":"+h.name+"="+h.value)
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(r.Metadata)) {
+		args = append(args, r.Pattern+":"+k+"="+r.Metadata[k])
+	}
+	return args
+}
+
+// CompareRule selects how files matching Pattern are compared, overriding
+// the job's compare mode.
+type CompareRule struct {
+	Pattern string `yaml:"pattern"`
+	Compare string `yaml:"compare"`
+}
+
+// Problem is an error tied to a position in a configuration file.
+type Problem struct {
+	Line    int    `json:"line,omitempty"`
+	Job     string `json:"job,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) Error() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", p.Line)
+	}
+	if p.Job != "" {
+		fmt.Fprintf(&b, "job %q: ", p.Job)
+	}
+	if p.Field != "" {
+		fmt.Fprintf(&b, "%s: ", p.Field)
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// Problems is a list of problems found in one file.
+type Problems []Problem
+
+func (ps Problems) Error() string {
+	msgs := make([]string, len(ps))
+	for i, p := range ps {
+		msgs[i] = p.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Load reads and parses the configuration file at path. Syntax errors,
+// unknown keys and values of the wrong type are reported as Problems.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Path = path
+	return cfg, nil
+}
+
+// Parse parses a configuration from YAML.
+func Parse(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, Problems{yamlProblem("", err.Error())}
+	}
+	if len(root.Content) == 0 {
+		return nil, Problems{{Message: "configuration is empty"}}
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, Problems{{Line: doc.Line, Message: "configuration must be a mapping"}}
+	}
+
+	cfg := &Config{}
+	var problems Problems
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, val := doc.Content[i], doc.Content[i+1]
+		if key.Value != "jobs" {
+			problems = append(problems, Problem{Line: key.Line, Field: key.Value, Message: "unknown key"})
+			continue
+		}
+		if val.Kind != yaml.MappingNode {
+			problems = append(problems, Problem{Line: val.Line, Field: "jobs", Message: "must be a mapping of job names to jobs"})
+			continue
+		}
+		for j := 0; j+1 < len(val.Content); j += 2 {
+			job, ps := parseJob(val.Content[j], val.Content[j+1])
+			problems = append(problems, ps...)
+			if job != nil {
+				cfg.Jobs = append(cfg.Jobs, job)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+func parseJob(name, node *yaml.Node) (*Job, Problems) {
+	if node.Kind != yaml.MappingNode {
+		return nil, Problems{{Line: node.Line, Job: name.Value, Message: "job must be a mapping"}}
+	}
+
+	job := &Job{Name: name.Value, Line: name.Line, fields: make(map[string]int)}
+	var problems Problems
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !jobKeys[key.Value] {
+			problems = append(problems, Problem{Line: key.Line, Job: job.Name, Field: key.Value, Message: "unknown key"})
+		}
+		job.fields[key.Value] = key.Line
+		if err := interpolate(node.Content[i+1]); err != nil {
+			problems = append(problems, Problem{Line: node.Content[i+1].Line, Job: job.Name, Field: key.Value, Message: err.Error()})
+		}
+	}
+	if err := node.Decode(job); err != nil {
+		var te *yaml.TypeError
+		if !errors.As(err, &te) {
+			return nil, append(problems, yamlProblem(job.Name, err.Error()))
+		}
+		for _, msg := range te.Errors {
+			problems = append(problems, yamlProblem(job.Name, msg))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return job, nil
+}
+
+// jobKeys is the set of keys a job may contain, taken from Job's yaml tags.
+var jobKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(Job{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("yaml"); tag != "" && tag != "-" {
+			keys[tag] = true
+		}
+	}
+	return keys
+}()
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlProblem turns a yaml error message such as "yaml: line 3: ..." into a
+// Problem carrying the line number.
+func yamlProblem(job, msg string) Problem {
+	p := Problem{Job: job, Message: strings.TrimPrefix(msg, "yaml: ")}
+	if m := yamlLine.FindStringSubmatch(msg); m != nil {
+		p.Line, _ = strconv.Atoi(m[1])
+		p.Message = m[2]
+	}
+	return p
+}
+
+// line returns the line of field in the job, or the job's own line if the
+// field is not set.
+func (j *Job) line(field string) int {
+	if l, ok := j.fields[field]; ok {
+		return l
+	}
+	return j.Line
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParse_jobs(t *testing.T) {
+	cfg, err := Parse([]byte(`
+jobs:
+  photos:
+    src: /home/me/photos
+    dst: s3://bucket/photos
+    delete: true
+    mtime-window: 2s
+  docs:
+    src: /home/me/docs
+    dst: gs://bucket/docs
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Jobs) != 2 || cfg.Jobs[0].Name != "photos" || cfg.Jobs[1].Name != "docs" {
+		t.Fatalf("unexpected jobs: %+v", cfg.Jobs)
+	}
+	j := cfg.Jobs[0]
+	if j.Line != 3 || !j.Delete || j.MtimeWindow.Seconds() != 2 {
+		t.Errorf("unexpected job: %+v", j)
+	}
+}
+
+func TestParse_reportsLines(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		line int
+		want string
+	}{
+		{"syntax", "jobs:\n  a: [\n", 2, "did not find expected"},
+		{"unknown key", "jobs:\n  a:\n    src: x\n    dest: y\n", 4, "unknown key"},
+		{"wrong type", "jobs:\n  a:\n    delete: maybe\n", 3, "cannot unmarshal"},
+		{"top-level key", "job:\n  a: {}\n", 1, "unknown key"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.yaml))
+		ps, ok := err.(Problems)
+		if !ok || len(ps) == 0 {
+			t.Errorf("%s: expected Problems, got %v", tt.name, err)
+			continue
+		}
+		if ps[0].Line != tt.line || !strings.Contains(ps[0].Message, tt.want) {
+			t.Errorf("%s: got %+v, want line %d containing %q", tt.name, ps[0], tt.line, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	src := t.TempDir()
+	cfg, err := Parse([]byte(`
+jobs:
+  good:
+    src: ` + src + `
+    dst: s3://bucket/{hostname}/{date:2006}/prefix
+    storage-class: STANDARD_IA
+  bad:
+    src: ` + src + `
+    dst: gs://bucket/prefix
+    storage-class: GLACIER_IR
+    compare: fuzzy
+    read-only: true
+    delete: true
+    force: true
+    debounce: 5s
+    expire-after-days: -1
+    sse: aws:kms
+    conflict: keep-both
+    bundle-size-mb: 32
+    compare-rules:
+      - pattern: "*.mp4"
+        compare: fast
+    tags: {backup: foldersync}
+    max-size: 4 parsecs
+    quota-trim: [logs]
+  missing:
+    dst: ftp://host/path
+  minio:
+    src: ` + src + `
+    dst: s3://bucket
+    endpoint-url: minio:9000
+    lock-retain: 30d
+    delete-to: trash
+    chunk-size-kb: 100
+    lock-stale: -1m
+    notify-on: [sometimes]
+    accelerate: true
+    hard-links: true
+    watch: true
+    part-size-mb: 4
+    key-layout: encrypted
+    tiers:
+      - class: COLD
+        after: 30d
+    walk-concurrency: -2
+    abort-uploads-after-days: 7
+    metadata:
+      - pattern: "*.css"
+        metadata: {Owner: web}
+    role-arn: backup
+    source-snapshot: btrfs
+    parallel-dsts: true
+    stall-timeout: -30s
+    case-collisions: rename
+  templated:
+    src: ` + src + `
+    dst: s3://bucket/{user}/prefix
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]int)
+	for _, p := range cfg.Validate() {
+		got[p.Job+"."+p.Field] = p.Line
+	}
+	want := map[string]int{
+		"bad.storage-class":     10,
+		"bad.compare":           11,
+		"bad.delete":            13,
+		"bad.force":             14,
+		"bad.debounce":          15,
+		"bad.expire-after-days": 16,
+		"bad.sse":               17,
+		"bad.conflict":          18,
+		"bad.bundle-size-mb":    19,
+		"bad.compare-rules":     20,
+		"bad.tags":              23,
+		"bad.max-size":          24,
+		"bad.quota-trim":        25,
+		"missing.src":           26,
+		"missing.dst":           27,
+		"minio.endpoint-url":    31,
+		"minio.lock-retain":     32,
+		"minio.delete-to":       33,
+		"minio.chunk-size-kb":   34,
+		"minio.lock-stale":      35,
+		"minio.notify-on":       36,
+		"minio.accelerate":      37,
+		"minio.hard-links":      38,
+		"minio.part-size-mb":    40,
+		"minio.key-layout":      41,
+		"minio.tiers":           42,
+
+		"minio.walk-concurrency":         45,
+		"minio.abort-uploads-after-days": 46,
+		"minio.metadata":                 47,
+		"minio.role-arn":                 50,
+		"minio.source-snapshot":          51,
+		"minio.parallel-dsts":            52,
+		"minio.stall-timeout":            53,
+		"minio.case-collisions":          54,
+		"templated.dst":                  57,
+	}
+	for k, line := range want {
+		if got[k] != line {
+			t.Errorf("%s: got line %d, want %d", k, got[k], line)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("unexpected problems: %v", got)
+	}
+}
+
+func TestJob_Args(t *testing.T) {
+	cfg, err := Parse([]byte(`
+jobs:
+  photos:
+    src: /home/me/photos
+    dst: s3://bucket/photos
+    sse-context: {job: photos, host: nas}
+    endpoint-url: https://minio.lan:9000
+    path-style: true
+    delete: true
+    profile: backup
+    role-arn: arn:aws:iam::111122223333:role/backup
+    max-change: 12.5
+    max-dst-size: 500GB
+    quota-trim: ["*.mp4", cache]
+    meta-cache-age: 15m
+    tags: {team: media, backup: foldersync}
+    tiers:
+      - pattern: "logs/*"
+        class: GLACIER
+        after: 30d
+      - class: DEEP_ARCHIVE
+        after: 365d
+    metadata:
+      - pattern: "*.html"
+        cache-control: no-cache
+        metadata: {owner: web}
+    compare-rules:
+      - pattern: "*.mp4"
+        compare: size
+    schedule: 6h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-src=/home/me/photos",
+		"-dst=s3://bucket/photos",
+		"-sse-context=host=nas",
+		"-sse-context=job=photos",
+		"-endpoint-url=https://minio.lan:9000",
+		"-path-style=true",
+		"-delete=true",
+		"-profile=backup",
+		"-role-arn=arn:aws:iam::111122223333:role/backup",
+		"-tag=backup=foldersync",
+		"-tag=team=media",
+		"-tier=logs/*=GLACIER:30d",
+		"-tier=DEEP_ARCHIVE:365d",
+		"-metadata=*.html:Cache-Control=no-cache",
+		"-metadata=*.html:owner=web",
+		"-compare-rule=*.mp4=size",
+		"-max-change=12.5",
+		"-max-dst-size=500GB",
+		"-quota-trim=*.mp4",
+		"-quota-trim=cache",
+		"-meta-cache-age=15m0s",
+	}
+	if got := cfg.Jobs[0].Args(); !slices.Equal(got, want) {
+		t.Errorf("Args() = %q, want %q", got, want)
+	}
+}
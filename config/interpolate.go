@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolate replaces the references in the string values under node, in
+// place:
+//
+//	${NAME}                      the environment variable NAME
+//	${file:///path}              the contents of a file, without a final newline
+//	${keyring://service/account} a password from the system keyring
+//	${aws-sm://name}             a secret from AWS Secrets Manager; with
+//	                             #key, one key of a JSON secret
+//
+// $${ stands for a literal ${. Values that were not quoted are parsed
+// again once replaced, so that "max-change: ${MAX_CHANGE}" is a number.
+func interpolate(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		v, err := expand(node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value = v
+		if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = "" // resolve it again
+		}
+	case yaml.MappingNode:
+		// Keys are names, not values.
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolate(node.Content[i]); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, n := range node.Content {
+			if err := interpolate(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expand replaces the references in s. See interpolate.
+func expand(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i]) // "$${" is "${"
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		ref, rest, ok := strings.Cut(s[i+2:], "}")
+		if !ok {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		v, err := resolve(ref)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(v)
+		s = rest
+	}
+}
+
+// resolve returns the value of the reference ref, written ${ref}.
+func resolve(ref string) (string, error) {
+	scheme, name, ok := strings.Cut(ref, "://")
+	if !ok {
+		v, ok := os.LookupEnv(ref)
+		if !ok || ref == "" {
+			return "", fmt.Errorf("environment variable %q is not set", ref)
+		}
+		return v, nil
+	}
+	lookup, ok := secretSchemes[scheme]
+	if !ok {
+		return "", fmt.Errorf("${%s}: unknown secret scheme %q (want file, keyring or aws-sm)", ref, scheme)
+	}
+	v, err := lookup(name)
+	if err != nil {
+		return "", fmt.Errorf("${%s}: %w", ref, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when a job runs.
+type Schedule interface {
+	// Next returns the first time the job runs after t, or the zero time
+	// if it never does.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a job's schedule: an interval such as "6h" or
+// "@every 6h", measured from the previous time the job was due, or a cron
+// expression such as "0 2 * * *" or "@daily", in local time.
+//
+// Cron expressions have five fields: minute, hour, day of month, month and
+// day of week (0 or 7 is Sunday). Each is "*", a number, a range such as
+// "1-5", either followed by a step such as "*/15", or a list of those
+// separated by commas. Months and days of the week may be given by their
+// first three letters, such as "jan" or "mon". As in cron, if neither the
+// day of month nor the day of week starts with "*", the job runs on days
+// matching either.
+func ParseSchedule(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+	if d, ok := strings.CutPrefix(s, "@every "); ok {
+		s = strings.TrimSpace(d)
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < time.Minute {
+			return nil, fmt.Errorf("schedule %q: interval must be at least a minute", s)
+		}
+		return interval(d), nil
+	}
+	if macro, ok := cronMacros[s]; ok {
+		s = macro
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: want an interval such as 6h or five cron fields", s)
+	}
+	var c cron
+	for i, f := range cronFields {
+		set, err := f.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %s: %v", s, f.name, err)
+		}
+		c.fields[i] = set
+	}
+	c.anyDay = strings.HasPrefix(fields[2], "*")
+	c.anyWeekday = strings.HasPrefix(fields[4], "*")
+	if c.fields[4]&(1<<7) != 0 {
+		c.fields[4] |= 1 // Sunday is 0 or 7
+	}
+	return c, nil
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type interval time.Duration
+
+func (d interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
+
+// cron is a parsed cron expression: a bit set of the values each field
+// matches.
+type cron struct {
+	fields             [5]uint64 // minute, hour, day, month, weekday
+	anyDay, anyWeekday bool      // the field starts with "*"
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names of min, min+1, ...
+}
+
+var cronFields = [5]cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// parse returns the set of values s matches.
+func (f cronField) parse(s string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(a); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(b); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max // "5/10" is "5-max/10"
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %q is backwards", rng)
+			}
+		}
+		n := 1
+		if hasStep {
+			var err error
+			if n, err = strconv.Atoi(step); err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step %q", step)
+			}
+		}
+		for v := lo; v <= hi; v += n {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%q is not between %d and %d", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (c cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every combination recurs within a few years; give up after that.
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case !c.has(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.has(1, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.has(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c cron) has(field, v int) bool {
+	return c.fields[field]&(1<<v) != 0
+}
+
+func (c cron) dayMatches(t time.Time) bool {
+	day, weekday := c.has(2, t.Day()), c.has(4, int(t.Weekday()))
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_next(t *testing.T) {
+	// 2024-03-13 is a Wednesday.
+	from := time.Date(2024, 3, 13, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		schedule string
+		want     string
+	}{
+		{"6h", "2024-03-13 16:07:30"},
+		{"@every 90m", "2024-03-13 11:37:30"},
+		{"0 2 * * *", "2024-03-14 02:00:00"},
+		{"@daily", "2024-03-14 00:00:00"},
+		{"@hourly", "2024-03-13 11:00:00"},
+		{"*/15 9-17 * * mon-fri", "2024-03-13 10:15:00"},
+		{"*/15 9-17 * * 6,7", "2024-03-16 09:00:00"},
+		{"30 4 1 * *", "2024-04-01 04:30:00"},
+		{"0 0 29 feb *", "2028-02-29 00:00:00"},
+		// Either the day of month or the day of week.
+		{"0 0 15 * fri", "2024-03-15 00:00:00"},
+		{"0 0 20 * mon", "2024-03-18 00:00:00"},
+		// Both, when one of them starts with "*".
+		{"0 0 */10 * fri", "2024-05-31 00:00:00"},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Errorf("%q: %v", tt.schedule, err)
+			continue
+		}
+		if got := s.Next(from).UTC().Format(time.DateTime); got != tt.want {
+			t.Errorf("%q: next = %s, want %s", tt.schedule, got, tt.want)
+		}
+	}
+}
+
+func TestParseSchedule_never(t *testing.T) {
+	s, err := ParseSchedule("0 0 31 feb *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("next = %v, want never", next)
+	}
+}
+
+func TestParseSchedule_errors(t *testing.T) {
+	tests := []struct {
+		schedule string
+		want     string
+	}{
+		{"30s", "at least a minute"},
+		{"daily", "five cron fields"},
+		{"0 2 * *", "five cron fields"},
+		{"60 * * * *", "minute"},
+		{"0 24 * * *", "hour"},
+		{"0 0 0 * *", "day of month"},
+		{"0 0 * smarch *", "month"},
+		{"0 0 * * 8", "day of week"},
+		{"0 17-9 * * *", "backwards"},
+		{"*/0 * * * *", "bad step"},
+	}
+	for _, tt := range tests {
+		_, err := ParseSchedule(tt.schedule)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: err = %v, want %q", tt.schedule, err, tt.want)
+		}
+	}
+}
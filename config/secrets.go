@@ -0,0 +1,111 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// secretSchemes look up the secrets referenced as ${scheme://name}.
+var secretSchemes = map[string]func(name string) (string, error){
+	"file":    fileSecret,
+	"keyring": keyringSecret,
+	"aws-sm":  awsSecret,
+}
+
+// fileSecret reads the file at path, as mounted by Docker or Kubernetes
+// secrets, dropping a final newline.
+func fileSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
+
+// keyringSecret looks up the password of account for service in the
+// system keyring: the login keychain on macOS, or the Secret Service, such
+// as GNOME Keyring or KWallet, elsewhere. name is "service/account".
+func keyringSecret(name string) (string, error) {
+	service, account, ok := strings.Cut(name, "/")
+	if !ok || service == "" || account == "" {
+		return "", errors.New("want keyring://service/account")
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "windows":
+		return "", errors.New("the system keyring is not supported on Windows")
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", cmd.Path, err, msg)
+		}
+		return "", fmt.Errorf("%s: %w", cmd.Path, err)
+	}
+	if len(out) == 0 {
+		return "", errors.New("not found in the keyring")
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// awsSecret reads the secret named name from AWS Secrets Manager, with the
+// credentials and region of the environment, or the region of an ARN.
+// "name#key" selects one key of a secret that is a JSON object.
+func awsSecret(name string) (string, error) {
+	name, key, hasKey := strings.Cut(name, "#")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if arn := strings.Split(name, ":"); len(arn) > 3 && arn[0] == "arn" {
+		opts = append(opts, awsconfig.WithRegion(arn[3]))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", errors.New("secret is binary")
+	}
+	if !hasKey {
+		return *out.SecretString, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	v, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no key %q", key)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,668 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// Validate checks every job for problems that would make a run fail or
+// behave unexpectedly: missing or unreadable paths, unknown destinations and
+// storage classes, invalid values and options that cannot be combined.
+func (c *Config) Validate() Problems {
+	var problems Problems
+	if len(c.Jobs) == 0 {
+		problems = append(problems, Problem{Message: "no jobs defined"})
+	}
+	for _, j := range c.Jobs {
+		problems = append(problems, j.validate()...)
+	}
+	return problems
+}
+
+func (j *Job) validate() Problems {
+	var problems Problems
+	add := func(field, msg string) {
+		problems = append(problems, Problem{Line: j.line(field), Job: j.Name, Field: field, Message: msg})
+	}
+
+	switch info, err := os.Stat(j.Src); {
+	case j.Src == "":
+		add("src", "required")
+	case err != nil:
+		add("src", err.Error())
+	case !info.IsDir():
+		add("src", "not a directory")
+	}
+
+	if j.Dst == "" {
+		add("dst", "required")
+	} else if err := sync.CheckURL(j.Dst); err != nil {
+		add("dst", err.Error())
+	} else if err := checkPrefix(j.Dst); err != nil {
+		add("dst", err.Error())
+	} else {
+		u, _ := url.Parse(j.Dst)
+		if j.StorageClass != "" {
+			if err := sync.CheckStorageClass(u.Scheme, j.StorageClass); err != nil {
+				add("storage-class", err.Error())
+			}
+		}
+		if j.SSE != "" || j.SSEKMSKeyID != "" || j.SSEContext != nil {
+			field := "sse"
+			if j.SSE == "" && j.SSEKMSKeyID != "" {
+				field = "sse-kms-key-id"
+			} else if j.SSE == "" {
+				field = "sse-context"
+			}
+			if u.Scheme != "s3" {
+				add(field, "only applies to s3:// destinations")
+			} else if err := sync.CheckServerSideEncryption(j.SSE, j.SSEKMSKeyID, j.SSEContext); err != nil {
+				add(field, err.Error())
+			}
+		}
+		if j.EndpointURL != "" {
+			if u.Scheme != "s3" {
+				add("endpoint-url", "only applies to s3:// destinations")
+			} else if err := sync.CheckS3Endpoint(j.EndpointURL); err != nil {
+				add("endpoint-url", err.Error())
+			}
+		}
+		if j.Profile != "" || j.RoleARN != "" || j.ExternalID != "" || j.RoleSessionName != "" {
+			field := "profile"
+			switch {
+			case j.RoleARN != "":
+				field = "role-arn"
+			case j.Profile == "" && j.ExternalID != "":
+				field = "external-id"
+			case j.Profile == "":
+				field = "role-session-name"
+			}
+			if u.Scheme != "s3" {
+				add(field, "only applies to s3:// destinations")
+			} else if err := sync.CheckAssumeRole(j.RoleARN, j.ExternalID, j.RoleSessionName); err != nil {
+				add(field, err.Error())
+			}
+		}
+		if j.AccountID != "" {
+			if u.Scheme != "r2" {
+				add("account-id", "only applies to r2:// destinations")
+			} else if err := sync.CheckR2AccountID(j.AccountID); err != nil {
+				add("account-id", err.Error())
+			}
+		}
+		if j.Compat != "" {
+			if u.Scheme != "s3" && u.Scheme != "r2" {
+				add("compat", "only applies to s3:// and r2:// destinations")
+			} else if _, err := sync.ParseS3Compat(j.Compat); err != nil {
+				add("compat", err.Error())
+			}
+		}
+		if (j.PathStyle || j.TLSSkipVerify) && u.Scheme != "s3" {
+			field := "path-style"
+			if !j.PathStyle {
+				field = "tls-skip-verify"
+			}
+			add(field, "only applies to s3:// destinations")
+		}
+		if (j.Accelerate || j.RequesterPays) && u.Scheme != "s3" {
+			field := "accelerate"
+			if !j.Accelerate {
+				field = "requester-pays"
+			}
+			add(field, "only applies to s3:// destinations")
+		} else if j.Accelerate && (j.EndpointURL != "" || j.PathStyle) {
+			add("accelerate", "cannot be combined with endpoint-url or path-style")
+		}
+		if j.LeavePartsOnError && u.Scheme != "s3" && u.Scheme != "r2" {
+			add("leave-parts-on-error", "only applies to s3:// and r2:// destinations")
+		}
+		if j.AdaptiveConcurrency && u.Scheme != "s3" && u.Scheme != "r2" {
+			add("adaptive-concurrency", "only applies to s3:// and r2:// destinations")
+		}
+		if j.CreateBucket && u.Scheme != "s3" {
+			add("create-bucket", "only applies to s3:// destinations")
+		}
+		if j.ListConcurrency != 0 && u.Scheme != "s3" && u.Scheme != "r2" {
+			add("list-concurrency", "only applies to s3:// and r2:// destinations")
+		} else if j.ListConcurrency < 0 {
+			add("list-concurrency", "must not be negative")
+		}
+		if (j.PartSizeMB != 0 || j.UploadConcurrency != 0) && u.Scheme != "s3" && u.Scheme != "b2" && u.Scheme != "r2" {
+			field := "part-size-mb"
+			if j.PartSizeMB == 0 {
+				field = "upload-concurrency"
+			}
+			add(field, "only applies to s3://, b2:// and r2:// destinations")
+		} else {
+			if j.PartSizeMB != 0 {
+				if err := sync.CheckS3PartSize(int64(j.PartSizeMB) << 20); err != nil {
+					add("part-size-mb", err.Error())
+				}
+			}
+			if j.UploadConcurrency < 0 {
+				add("upload-concurrency", "must not be negative")
+			}
+		}
+		if (j.LockMode != "" || j.LegalHold) && u.Scheme != "s3" {
+			field := "lock-mode"
+			if j.LockMode == "" {
+				field = "legal-hold"
+			}
+			add(field, "only applies to s3:// destinations")
+		}
+		if j.Tags != nil {
+			if u.Scheme != "s3" {
+				add("tags", "only apply to s3:// destinations")
+			} else if err := sync.CheckS3Tags(j.Tags); err != nil {
+				add("tags", err.Error())
+			}
+		}
+		if j.Tiers != nil && u.Scheme != "s3" && u.Scheme != "gs" {
+			add("tiers", "only apply to s3:// and gs:// destinations")
+		} else {
+			for _, t := range j.Tiers {
+				if _, err := sync.ParseStorageTier(t.arg()); err != nil {
+					add("tiers", err.Error())
+				} else if err := sync.CheckStorageClass(u.Scheme, t.Class); err != nil {
+					add("tiers", err.Error())
+				}
+			}
+		}
+		if j.Metadata != nil && u.Scheme != "s3" && u.Scheme != "gs" {
+			add("metadata", "only applies to s3:// and gs:// destinations")
+		}
+	}
+
+	if _, err := sync.ParseObjectLock(j.LockMode, j.LockRetain, j.LegalHold); err != nil {
+		field := "lock-mode"
+		if j.LockMode == "" {
+			field = "lock-retain"
+		}
+		add(field, err.Error())
+	}
+	if j.DetectRenames && (j.LockMode != "" || j.LegalHold) {
+		add("detect-renames", "cannot be combined with object lock")
+	}
+	if j.Dedupe && (j.LockMode != "" || j.LegalHold) {
+		add("dedupe", "cannot be combined with object lock")
+	}
+	if j.StageUploads && (j.LockMode != "" || j.LegalHold) {
+		add("stage-uploads", "cannot be combined with object lock")
+	}
+
+	if j.AbortUploadsAfterDays < 0 {
+		add("abort-uploads-after-days", "must not be negative")
+	} else if j.AbortUploadsAfterDays > 0 && !j.CreateBucket {
+		add("abort-uploads-after-days", "has no effect without create-bucket")
+	}
+	if j.OnWalkError != "" {
+		if _, err := sync.ParseWalkErrorPolicy(j.OnWalkError); err != nil {
+			add("on-walk-error", err.Error())
+		}
+	}
+	if j.WalkConcurrency < 0 {
+		add("walk-concurrency", "must not be negative")
+	}
+	if j.StatConcurrency < 0 {
+		add("stat-concurrency", "must not be negative")
+	}
+	if j.ExpireAfterDays < 0 {
+		add("expire-after-days", "must not be negative")
+	}
+	if j.ExpireAfterDays > 0 && !j.Delete {
+		add("expire-after-days", "has no effect without delete")
+	}
+	if j.Quiet && j.Verbose {
+		add("quiet", "cannot be combined with verbose")
+	}
+	if j.DeleteExcluded && !j.Delete {
+		add("delete-excluded", "has no effect without delete")
+	}
+	if j.DeleteExcluded && j.TwoWay {
+		add("delete-excluded", "cannot be combined with two-way")
+	}
+	if j.DeleteTo != "" {
+		if !j.Delete {
+			add("delete-to", "has no effect without delete")
+		}
+		if j.DeleteTo != "trash" {
+			if err := sync.CheckURL(j.DeleteTo); err != nil {
+				add("delete-to", err.Error())
+			}
+		}
+	}
+	if j.ReportExtraneous != "" && (j.Watch || j.TwoWay) {
+		add("report-extraneous", "cannot be combined with watch or two-way")
+	}
+
+	if j.ContentType != "" {
+		if _, err := sync.ParseContentTypeMode(j.ContentType); err != nil {
+			add("content-type", err.Error())
+		}
+	}
+
+	for _, r := range j.Metadata {
+		args := r.args()
+		if r.Pattern == "" || len(args) == 0 {
+			add("metadata", "each rule needs a pattern and something to set")
+		} else if _, err := sync.ParseMetadataRules(args); err != nil {
+			add("metadata", err.Error())
+		} else if r.ContentEncoding != "" && j.Compress != "" && j.Compress != "none" {
+			add("metadata", "a content-encoding cannot be combined with compress")
+		}
+	}
+	if j.Compress != "" {
+		if _, err := sync.ParseCompression(j.Compress); err != nil {
+			add("compress", err.Error())
+		}
+	}
+
+	if j.KeyLayout == "encrypted" {
+		if j.NameKeyFile == "" {
+			add("key-layout", "encrypted needs name-key-file")
+		} else if j.Watch || j.TwoWay {
+			add("key-layout", "cannot be combined with watch or two-way")
+		}
+	} else if j.KeyLayout != "" {
+		if _, err := sync.ParseKeyMapper(j.KeyLayout); err != nil {
+			add("key-layout", err.Error())
+		} else if j.KeyLayout != "identity" && (j.Watch || j.TwoWay) {
+			add("key-layout", "cannot be combined with watch or two-way")
+		}
+	}
+	if j.StripComponents < 0 {
+		add("strip-components", "must not be negative")
+	}
+	if j.KeyTemplate != "" {
+		if err := sync.CheckKeyTemplate(j.KeyTemplate); err != nil {
+			add("key-template", err.Error())
+		}
+	}
+	if j.Watch || j.TwoWay {
+		if j.StripComponents > 0 {
+			add("strip-components", "cannot be combined with watch or two-way")
+		}
+		if j.KeyTemplate != "" {
+			add("key-template", "cannot be combined with watch or two-way")
+		}
+		if j.LowercaseKeys {
+			add("lowercase-keys", "cannot be combined with watch or two-way")
+		}
+	}
+	if j.Unicode != "" {
+		if _, err := sync.ParseUnicodeForm(j.Unicode); err != nil {
+			add("unicode", err.Error())
+		}
+	}
+	if j.CaseCollisions != "" {
+		if policy, err := sync.ParseCaseCollisionPolicy(j.CaseCollisions); err != nil {
+			add("case-collisions", err.Error())
+		} else if policy == sync.CaseCollisionsRename && j.Watch {
+			add("case-collisions", "rename cannot be combined with watch")
+		}
+	}
+	if j.NameKeyFile != "" && j.KeyLayout != "encrypted" {
+		add("name-key-file", "only applies to key-layout encrypted")
+	}
+
+	switch j.Compare {
+	case "", "mtime", "size", "checksum", "etag":
+	default:
+		add("compare", `must be one of "mtime", "size", "checksum" or "etag"`)
+	}
+	if j.MinSize != "" {
+		if _, err := sync.ParseSize(j.MinSize); err != nil {
+			add("min-size", err.Error())
+		}
+	}
+	if j.MaxSize != "" {
+		if _, err := sync.ParseSize(j.MaxSize); err != nil {
+			add("max-size", err.Error())
+		}
+	}
+	if j.ModifiedAfter != "" {
+		if _, err := sync.ParseModTime(j.ModifiedAfter, time.Now()); err != nil {
+			add("modified-after", err.Error())
+		}
+	}
+	if j.ModifiedBefore != "" {
+		if _, err := sync.ParseModTime(j.ModifiedBefore, time.Now()); err != nil {
+			add("modified-before", err.Error())
+		}
+	}
+	if (j.MinSize != "" || j.MaxSize != "" || j.ModifiedAfter != "" || j.ModifiedBefore != "") && j.TwoWay {
+		add("two-way", "cannot be combined with size or age filters")
+	}
+	if j.SkipInFlight && (j.Watch || j.TwoWay) {
+		add("skip-in-flight", "cannot be combined with watch or two-way")
+	}
+	if j.InFlightAge != 0 && !j.SkipInFlight {
+		add("in-flight-age", "has no effect without skip-in-flight")
+	}
+	if len(j.InFlightPattern) > 0 && !j.SkipInFlight {
+		add("in-flight-pattern", "has no effect without skip-in-flight")
+	}
+	if j.InFlightAge < 0 {
+		add("in-flight-age", "must not be negative")
+	}
+	for _, p := range j.InFlightPattern {
+		if _, err := path.Match(p, ""); err != nil {
+			add("in-flight-pattern", fmt.Sprintf("%q: %v", p, err))
+		}
+	}
+
+	for _, r := range j.CompareRules {
+		switch {
+		case r.Pattern == "":
+			add("compare-rules", "each rule needs a pattern")
+		case r.Compare != "mtime" && r.Compare != "size" && r.Compare != "checksum" && r.Compare != "etag" && r.Compare != "always":
+			add("compare-rules", r.Pattern+`: compare must be one of "mtime", "size", "checksum", "etag" or "always"`)
+		}
+	}
+	if j.MtimeWindow < 0 {
+		add("mtime-window", "must not be negative")
+	}
+	if j.LockStale < 0 {
+		add("lock-stale", "must not be negative")
+	}
+	if j.FileTimeout < 0 {
+		add("file-timeout", "must not be negative")
+	}
+	if j.StallTimeout < 0 {
+		add("stall-timeout", "must not be negative")
+	}
+	if j.MtimeWindow != 0 && (j.Compare == "size" || j.Compare == "checksum" || j.NetworkSource) && !j.TwoWay {
+		add("mtime-window", "only applies when comparing by mtime")
+	}
+	if j.NetworkSource && j.Compare != "" {
+		add("network-source", "cannot be combined with compare")
+	}
+	if j.ReconcileEvery < 0 {
+		add("reconcile-every", "must not be negative")
+	}
+	if j.ReconcileEvery > 0 && j.Compare == "checksum" {
+		add("reconcile-every", "has no effect when comparing by checksum")
+	}
+
+	if j.MaxChange < 0 || j.MaxChange > 100 {
+		add("max-change", "must be a percentage between 0 and 100")
+	}
+	if j.Force && j.MaxChange == 0 {
+		add("force", "has no effect without max-change")
+	}
+	if j.MaxDstSize != "" {
+		if _, err := sync.ParseSize(j.MaxDstSize); err != nil {
+			add("max-dst-size", err.Error())
+		}
+	}
+	if len(j.QuotaTrim) > 0 && j.MaxDstSize == "" {
+		add("quota-trim", "has no effect without max-dst-size")
+	}
+	if j.QuotaFill && j.MaxDstSize == "" {
+		add("quota-fill", "has no effect without max-dst-size")
+	}
+
+	if j.Priority != "" {
+		if _, err := sync.ParsePriority(j.Priority); err != nil {
+			add("priority", err.Error())
+		}
+	}
+	if j.MaxDuration < 0 {
+		add("max-duration", "must not be negative")
+	}
+	if j.MaxTransfer != "" {
+		if _, err := sync.ParseSize(j.MaxTransfer); err != nil {
+			add("max-transfer", err.Error())
+		}
+	}
+	if (j.MaxDuration > 0 || j.MaxTransfer != "") && (j.Watch || j.TwoWay) {
+		field := "max-duration"
+		if j.MaxDuration == 0 {
+			field = "max-transfer"
+		}
+		add(field, "cannot be combined with watch or two-way")
+	}
+	if j.MaxRequestsPerRun < 0 {
+		add("max-requests-per-run", "must not be negative")
+	}
+	if j.MaxRequestsPerRun > 0 && j.Watch {
+		add("max-requests-per-run", "cannot be combined with watch")
+	}
+	if j.RequestsPerSecond < 0 {
+		add("requests-per-second", "must not be negative")
+	}
+
+	if j.Conflict != "" {
+		if _, err := sync.ParseConflictPolicy(j.Conflict); err != nil {
+			add("conflict", err.Error())
+		} else if !j.TwoWay {
+			add("conflict", "has no effect without two-way")
+		}
+	}
+	if j.TwoWay {
+		switch {
+		case j.Watch:
+			add("two-way", "cannot be combined with watch")
+		case j.NoCache:
+			add("two-way", "cannot be combined with no-cache: the cache records the last run")
+		case j.ReadOnly:
+			add("two-way", "cannot be combined with read-only")
+		}
+	}
+
+	if (j.PreCmd != "" || j.PostCmd != "") && (j.Watch || j.TwoWay) {
+		add("pre-cmd", "pre-cmd and post-cmd cannot be combined with watch or two-way")
+	}
+
+	if j.Incremental {
+		switch {
+		case j.NoCache:
+			add("incremental", "cannot be combined with no-cache: the cache is all it checks")
+		case j.Watch || j.TwoWay:
+			add("incremental", "cannot be combined with watch or two-way")
+		case j.ExpireAfterDays > 0:
+			add("incremental", "cannot be combined with expire-after-days")
+		case j.Tiers != nil:
+			add("incremental", "cannot be combined with tiers")
+		}
+	}
+	if j.FullEvery < 0 {
+		add("full-every", "must not be negative")
+	}
+	if j.FullEvery != 0 && !j.Incremental {
+		add("full-every", "has no effect without incremental")
+	}
+	if j.HashCacheVerify < 0 || j.HashCacheVerify > 100 {
+		add("hash-cache-verify", "must be a percentage between 0 and 100")
+	}
+	if j.HashCacheVerify != 0 && j.NoCache {
+		add("hash-cache-verify", "has no effect with no-cache")
+	}
+	if j.Checkpoint < 0 {
+		add("checkpoint", "must not be negative")
+	}
+	if j.Snapshots && (j.Watch || j.TwoWay || j.BundleThresholdKB > 0) {
+		add("snapshots", "cannot be combined with watch, two-way or bundle-threshold-kb")
+	}
+	if j.AppendUploads && !j.Checksums {
+		add("append-uploads", "needs checksums")
+	}
+	if j.ManifestChecksums && (j.NoCache || j.TwoWay) {
+		add("manifest-checksums", "cannot be combined with no-cache or two-way")
+	}
+	if j.DetectRenames && (j.NoCache || j.TwoWay) {
+		add("detect-renames", "cannot be combined with no-cache or two-way")
+	}
+	if j.Dedupe && (len(j.AlsoDst) > 0 || j.TwoWay || j.Sparse) {
+		add("dedupe", "cannot be combined with also-dst, two-way or sparse")
+	}
+	if j.HardLinks && (j.Watch || j.TwoWay || j.Snapshots) {
+		add("hard-links", "cannot be combined with watch, two-way or snapshots")
+	}
+	if j.KeepEmptyDirs && (j.Watch || j.TwoWay) {
+		add("keep-empty-dirs", "cannot be combined with watch or two-way")
+	}
+	if j.SourceSnapshot != "" {
+		if _, err := sync.ParseSnapshotter(j.SourceSnapshot, ""); err != nil {
+			add("source-snapshot", err.Error())
+		} else if j.Watch || j.TwoWay {
+			add("source-snapshot", "cannot be combined with watch or two-way")
+		}
+	}
+	if j.LVMSnapshotSize != "" {
+		if j.SourceSnapshot != "lvm" {
+			add("lvm-snapshot-size", "has no effect without source-snapshot: lvm")
+		} else if err := sync.CheckLVMSnapshotSize(j.LVMSnapshotSize); err != nil {
+			add("lvm-snapshot-size", err.Error())
+		}
+	}
+
+	if j.MetricsAddr != "" && !j.Watch {
+		add("metrics-addr", "has no effect without watch; use pushgateway for single runs")
+	}
+	if j.ControlSocket != "" && !j.Watch {
+		add("control-socket", "has no effect without watch")
+	}
+	if j.Pushgateway != "" {
+		if u, err := url.Parse(j.Pushgateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("pushgateway", "must be an http:// or https:// URL")
+		} else if j.Watch || j.TwoWay {
+			add("pushgateway", "cannot be combined with watch or two-way")
+		}
+	}
+	if j.PushJob != "" && j.Pushgateway == "" {
+		add("push-job", "has no effect without pushgateway")
+	}
+	notify := j.NotifyWebhook != "" || j.NotifySlack != "" || j.NotifySMTP != ""
+	for _, hook := range []struct{ key, url string }{{"notify-webhook", j.NotifyWebhook}, {"notify-slack", j.NotifySlack}} {
+		if hook.url == "" {
+			continue
+		}
+		if u, err := url.Parse(hook.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(hook.key, "must be an http:// or https:// URL")
+		}
+	}
+	if j.NotifySMTP != "" {
+		m := sync.Mail{Server: j.NotifySMTP, From: j.NotifyMailFrom, To: j.NotifyMailTo}
+		if err := m.Check(); err != nil {
+			add("notify-smtp", err.Error())
+		}
+	} else if j.NotifyMailFrom != "" || len(j.NotifyMailTo) > 0 {
+		add("notify-mail-to", "has no effect without notify-smtp")
+	}
+	if _, err := sync.ParseNotifyEvents(j.NotifyOn); err != nil {
+		add("notify-on", err.Error())
+	}
+	if j.NotifyChanges < 0 {
+		add("notify-changes", "must not be negative")
+	}
+	if notify && (j.Watch || j.TwoWay) {
+		add("notify-webhook", "notifications cannot be combined with watch or two-way")
+	}
+	if !notify && (len(j.NotifyOn) > 0 || j.NotifyChanges != 0) {
+		add("notify-on", "has no effect without notify-webhook, notify-slack or notify-smtp")
+	}
+
+	if j.MetaCacheAge < 0 {
+		add("meta-cache-age", "must not be negative")
+	}
+
+	if j.BundleThresholdKB < 0 {
+		add("bundle-threshold-kb", "must not be negative")
+	}
+	if j.BundleThresholdKB > 0 && (j.Watch || j.TwoWay) {
+		add("bundle-threshold-kb", "cannot be combined with watch or two-way")
+	}
+	if j.BundleSizeMB < 0 {
+		add("bundle-size-mb", "must not be negative")
+	}
+	if j.BundleSizeMB > 0 && j.BundleThresholdKB == 0 {
+		add("bundle-size-mb", "has no effect without bundle-threshold-kb")
+	}
+
+	if j.ChunkThresholdMB < 0 {
+		add("chunk-threshold-mb", "must not be negative")
+	}
+	for _, dst := range j.AlsoDst {
+		err := sync.CheckURL(dst)
+		if err == nil {
+			err = checkPrefix(dst)
+		}
+		if err != nil {
+			add("also-dst", err.Error())
+			break
+		}
+	}
+	if len(j.AlsoDst) > 0 && (j.TwoWay || j.Snapshots || j.DetectRenames || j.DeleteTo != "") {
+		add("also-dst", "cannot be combined with two-way, snapshots, detect-renames or delete-to")
+	}
+	if j.ParallelDsts && len(j.AlsoDst) == 0 {
+		add("parallel-dsts", "has no effect without also-dst")
+	}
+	if j.ChunkThresholdMB > 0 && (j.TwoWay || j.Snapshots || j.DetectRenames || j.DeleteTo != "") {
+		add("chunk-threshold-mb", "cannot be combined with two-way, snapshots, detect-renames or delete-to")
+	}
+	if j.ChunkSizeKB != 0 && (j.ChunkSizeKB < 64 || j.ChunkSizeKB&(j.ChunkSizeKB-1) != 0) {
+		add("chunk-size-kb", "must be a power of two of at least 64")
+	}
+	if j.ChunkSizeKB != 0 && j.ChunkThresholdMB == 0 {
+		add("chunk-size-kb", "has no effect without chunk-threshold-mb")
+	}
+
+	if j.Debounce < 0 {
+		add("debounce", "must not be negative")
+	}
+	if j.Debounce != 0 && !j.Watch {
+		add("debounce", "has no effect without watch")
+	}
+	if j.Schedule != "" {
+		if _, err := ParseSchedule(j.Schedule); err != nil {
+			add("schedule", err.Error())
+		} else if j.Watch {
+			add("schedule", "has no effect with watch: the job keeps running")
+		}
+	}
+	if j.Jitter < 0 {
+		add("jitter", "must not be negative")
+	}
+	if j.Jitter > 0 && j.Schedule == "" {
+		add("jitter", "has no effect without schedule")
+	}
+	if info, err := os.Stat(j.LogDir); j.LogDir != "" && err == nil && !info.IsDir() {
+		add("log-dir", "not a directory")
+	}
+
+	if j.ReadOnly {
+		if j.Delete {
+			add("delete", "cannot be combined with read-only")
+		}
+		if j.Manifest || j.ManifestChecksums || j.SignKey != "" {
+			add("manifest", "cannot be combined with read-only")
+		}
+	}
+	if j.SignKey != "" {
+		if _, err := sync.LoadSigningKey(j.SignKey); err != nil {
+			add("sign-key", err.Error())
+		}
+	}
+	if j.VerifyKey != "" {
+		if _, err := sync.LoadVerifyKey(j.VerifyKey); err != nil {
+			add("verify-key", err.Error())
+		} else if !j.Delete {
+			add("verify-key", "only checked before delete runs; has no effect without delete")
+		}
+	}
+	return problems
+}
+
+// checkPrefix checks the variables in the prefix of the destination URL
+// rawURL, which runs expand as they start.
+func checkPrefix(rawURL string) error {
+	_, err := sync.ExpandPrefix(rawURL, sync.PrefixVars{Hostname: "host", Profile: "default", Time: time.Now()})
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sandeepkandula/foldersync/config"
+)
+
+// runConfig implements "foldersync config validate [-json] <file>".
+func runConfig(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync config validate [-json] <file>")
+	}
+	if len(args) == 0 || args[0] != "validate" {
+		usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+
+	var problems config.Problems
+	cfg, err := config.Load(path)
+	switch {
+	case errors.As(err, &problems):
+	case err != nil:
+		problems = config.Problems{{Message: err.Error()}}
+	default:
+		problems = cfg.Validate()
+	}
+
+	if *asJSON {
+		if problems == nil {
+			problems = config.Problems{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			File     string           `json:"file"`
+			Valid    bool             `json:"valid"`
+			Problems []config.Problem `json:"problems"`
+		}{path, len(problems) == 0, problems})
+	} else {
+		for _, p := range problems {
+			fmt.Println(formatProblem(path, p))
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: %d jobs ok\n", path, len(cfg.Jobs))
+		}
+	}
+
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// formatProblem formats p, a problem of the file at path, as compilers do:
+// path:line: message, or path: message if it is not on a line.
+func formatProblem(path string, p config.Problem) string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", path, p.Error())
+	}
+	line := p.Line
+	p.Line = 0
+	return fmt.Sprintf("%s:%d: %s", path, line, p.Error())
+}
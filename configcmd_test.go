@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sandeepkandula/foldersync/config"
+)
+
+func TestFormatProblem(t *testing.T) {
+	for _, tt := range []struct {
+		p    config.Problem
+		want string
+	}{
+		{config.Problem{Line: 4, Job: "photos", Field: "dst", Message: "is required"}, `jobs.yaml:4: job "photos": dst: is required`},
+		{config.Problem{Message: "open jobs.yaml: no such file or directory"}, "jobs.yaml: open jobs.yaml: no such file or directory"},
+	} {
+		if got := formatProblem("jobs.yaml", tt.p); got != tt.want {
+			t.Errorf("formatProblem(%+v) = %q, want %q", tt.p, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// serveControl serves the API of c on a unix socket at path, which only
+// the user running foldersync may connect to. A socket left behind by a
+// run that did not stop cleanly is replaced; any other file is left alone.
+func serveControl(path string, c *sync.Control) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			fatalf("control socket %s: %v", path, errNotSocket)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			fatalf("control socket %s: another foldersync is listening on it", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := listenPrivate(path)
+	if err != nil {
+		fatalf("control socket: %v", err)
+	}
+	go func() {
+		log.Printf("control socket: %v", http.Serve(ln, c))
+	}()
+}
+
+// listenPrivate listens on a unix socket at path that only the user may
+// connect to. The socket is made in a directory only the user may enter,
+// and moved into place once it is private, so that no one else can connect
+// to it in between. It fails with errNotSocket rather than replace a file
+// at path that is not a socket.
+func listenPrivate(path string) (net.Listener, error) {
+	if err := checkSocketPath(path); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".foldersync-control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "socket")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false) // its name is gone
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := checkSocketPath(path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+var errNotSocket = errors.New("file exists and is not a socket")
+
+// checkSocketPath returns errNotSocket if something other than a socket is
+// at path, such as a file named by mistake.
+func checkSocketPath(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s: %w", path, errNotSocket)
+	}
+	return nil
+}
+
+// runControl implements "foldersync control -socket <path> <command>",
+// which steers a run with -watch -control-socket <path>.
+func runControl(args []string) int {
+	fs := flag.NewFlagSet("control", flag.ExitOnError)
+	socket := fs.String("socket", "", "the -control-socket of the watching run (required)")
+	n := fs.Int("n", 100, "with events, how many of the latest events to print")
+	follow := fs.Bool("f", false, "with events, keep printing events as they happen")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync control -socket <path> status|sync|pause|resume")
+		fmt.Fprintln(os.Stderr, "       foldersync control -socket <path> events [-n <count>] [-f]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *socket == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	method, path := http.MethodPost, "/"+fs.Arg(0)
+	switch fs.Arg(0) {
+	case "status":
+		method = http.MethodGet
+	case "events":
+		method = http.MethodGet
+		q := url.Values{"n": {fmt.Sprint(*n)}}
+		if *follow {
+			q.Set("follow", "1")
+		}
+		path += "?" + q.Encode()
+	case "sync", "pause", "resume":
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", *socket)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, method, "http://foldersync"+path, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "control: %v\n", err)
+		return 1
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "control: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "control: %s: %s", resp.Status, msg)
+		return 1
+	}
+	// The status, or the events, are printed as they come.
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "control: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenPrivate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "control.sock")
+	ln, err := listenPrivate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("socket mode %o, want 600", perm)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the socket", len(entries))
+	}
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("connect to the socket moved into place: %v", err)
+	}
+	conn.Close()
+}
+
+func TestListenPrivate_keepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("keep me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listenPrivate(path); !errors.Is(err, errNotSocket) {
+		if err == nil {
+			ln.Close()
+		}
+		t.Fatalf("err = %v, want errNotSocket", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "keep me" {
+		t.Errorf("file holds %q, %v; want it untouched", data, err)
+	}
+}
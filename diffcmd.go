@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runDiff implements "foldersync diff -src <dir> -dst <url>", which lists
+// every path as only-local, only-remote, differs or identical without
+// transferring anything. It exits 0 if every path is identical, 1 if any
+// is not, and exitFatal if the comparison could not be made.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	src := fs.String("src", "", "source directory (required)")
+	dstURL := fs.String("dst", "", "destination URL (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	compare := fs.String("compare", "mtime", "how to compare files: mtime (size and mtime), size, or checksum (downloads every object of the same size)")
+	mtimeWindow := fs.Duration("mtime-window", 0, "treat mtimes within this window as equal (-compare mtime)")
+	keyLayout := fs.String("key-layout", "identity", "the -key-layout the destination was synced with")
+	nameKeyFile := fs.String("name-key-file", "", "the -name-key-file of the -key-layout encrypted backup")
+	stripComponents := fs.Int("strip-components", 0, "the -strip-components the destination was synced with")
+	keyTemplate := fs.String("key-template", "", "the -key-template the destination was synced with")
+	lowercaseKeys := fs.Bool("lowercase-keys", false, "the -lowercase-keys the destination was synced with")
+	hideIdentical := fs.Bool("hide-identical", false, "leave identical paths out of the listing")
+	asJSON := fs.Bool("json", false, "print one JSON object per path instead of text")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync diff -src <dir> -dst <url> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *src == "" || *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	comparer, err := newComparer(*compare, 0, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	keys, err := parseKeyLayout(*keyLayout, *nameKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "key layout: %v\n", err)
+		return 2
+	}
+	if keys, err = shapeKeys(keys, *stripComponents, *keyTemplate, *lowercaseKeys); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	dst, err := openRestoreDst(ctx, *dstURL, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return exitFatal
+	}
+	opts := sync.Options{Src: *src, Dst: dst, Compare: comparer, ModTimeWindow: *mtimeWindow, Keys: keys, Warnings: os.Stderr}
+	report, err := sync.Diff(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff failed: %v\n", err)
+		return exitFatal
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, e := range report.Entries {
+		switch {
+		case e.Class == sync.Identical && *hideIdentical:
+		case *asJSON:
+			enc.Encode(e)
+		case e.Reason != "":
+			fmt.Printf("%-11s  %s (%s)\n", e.Class, e.Key, e.Reason)
+		default:
+			fmt.Printf("%-11s  %s\n", e.Class, e.Key)
+		}
+	}
+	if !*asJSON {
+		fmt.Printf("%d only local, %d only remote, %d differ, %d identical\n",
+			report.Count(sync.OnlyLocal), report.Count(sync.OnlyRemote), report.Count(sync.Differs), report.Count(sync.Identical))
+	}
+	if len(report.Entries) > report.Count(sync.Identical) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunDiff_exitStatus(t *testing.T) {
+	src, dst := t.TempDir(), "file://"+t.TempDir()
+	if code := runDiff([]string{"-src", src, "-dst", dst}); code != 0 {
+		t.Errorf("identical: exit %d, want 0", code)
+	}
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := runDiff([]string{"-src", src, "-dst", dst}); code != 1 {
+		t.Errorf("different: exit %d, want 1", code)
+	}
+	missing := filepath.Join(src, "missing")
+	if code := runDiff([]string{"-src", missing, "-dst", dst}); code != exitFatal {
+		t.Errorf("unreadable source: exit %d, want %d", code, exitFatal)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runDrill implements "foldersync drill -dst <url>", which test-restores a
+// random sample of the backup's files and records the result at the
+// destination.
+func runDrill(args []string) int {
+	fs := flag.NewFlagSet("drill", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL to drill (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	sample := fs.Int("sample", 10, "number of files to restore")
+	keyLayout := fs.String("key-layout", "identity", "the -key-layout the backup was made with")
+	nameKeyFile := fs.String("name-key-file", "", "the -name-key-file of the -key-layout encrypted backup")
+	verifyKey := fs.String("verify-key", "", "Ed25519 public key (PEM) the manifest must be signed with")
+	tempDir := fs.String("temp-dir", "", "directory to restore the files under (default: the system's temporary directory)")
+	noRecord := fs.Bool("no-record", false, "don't record the result in the destination's status")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync drill -dst <url> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *sample < 1 {
+		fmt.Fprintln(os.Stderr, "-sample must be positive")
+		return 2
+	}
+	keys, err := parseKeyLayout(*keyLayout, *nameKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "key layout: %v\n", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := sync.DrillOptions{Sample: *sample, Keys: keys, TempDir: *tempDir, NoRecord: *noRecord, Log: os.Stdout, Warnings: os.Stderr}
+	if opts.From, err = openRestoreDst(ctx, *dstURL, *region); err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	if *verifyKey != "" {
+		if opts.VerifyKey, err = sync.LoadVerifyKey(*verifyKey); err != nil {
+			fmt.Fprintf(os.Stderr, "verify key: %v\n", err)
+			return 1
+		}
+	}
+
+	res, err := sync.Drill(ctx, opts)
+	if res != nil {
+		for _, f := range res.Failures {
+			fmt.Printf("FAILED %s\n", f)
+		}
+		fmt.Printf("restored %d files: %d failed, %d verified by hash, %d by size only", res.Sampled, len(res.Failures),
+			res.Verified, res.Sampled-len(res.Failures)-res.Verified)
+		if res.Skipped > 0 {
+			fmt.Printf(", %d archived skipped", res.Skipped)
+		}
+		fmt.Println()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "drill failed: %v\n", err)
+		return 1
+	}
+	if !res.Passed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/sandeepkandula/foldersync/config"
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+const iamPolicyUsage = `usage: foldersync iam-policy -dst <url> [options]
+       foldersync iam-policy -config <file> -job <name>`
+
+// runIAMPolicy implements "foldersync iam-policy", which prints the least
+// IAM policy a sync job needs of its S3 bucket, for the options given as
+// flags of the run or set on a job of a config file.
+func runIAMPolicy(args []string) int {
+	fs := flag.NewFlagSet("iam-policy", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL of the sync job: s3://bucket/prefix")
+	configPath := fs.String("config", "", "take the options of a job of this config file instead")
+	jobName := fs.String("job", "", "with -config, the job (default: the only one)")
+	profile := fs.String("profile", "", "AWS shared config profile the job runs with, for {profile} in -dst")
+	readOnly := fs.Bool("read-only", false, "for credentials that only restore, verify, mount and diff")
+	del := fs.Bool("delete", false, "the job deletes objects absent from the source")
+	deleteTo := fs.String("delete-to", "", "the job moves deleted objects to the trash or this URL first")
+	snapshots := fs.Bool("snapshots", false, "the job keeps snapshots")
+	detectRenames := fs.Bool("detect-renames", false, "the job copies renamed files")
+	dedupe := fs.Bool("dedupe", false, "the job copies identical files")
+	stageUploads := fs.Bool("stage-uploads", false, "the job stages uploads")
+	var tags, tiers stringsFlag
+	fs.Var(&tags, "tag", "the job tags uploads, as key=value (repeatable)")
+	fs.Var(&tiers, "tier", "the job moves objects between storage tiers (repeatable)")
+	lockMode := fs.String("lock-mode", "", "the job locks uploads in this S3 Object Lock mode")
+	legalHold := fs.Bool("legal-hold", false, "the job places legal holds on uploads")
+	createBucket := fs.Bool("create-bucket", false, "the job creates the bucket")
+	versions := fs.Bool("versions", false, "the credentials also list object versions and, unless -read-only, restore and purge them")
+	sse := fs.String("sse", "", "S3 server-side encryption of the job: AES256 or aws:kms")
+	sseKMSKeyID := fs.String("sse-kms-key-id", "", "KMS key ID or ARN the job encrypts with; implies -sse aws:kms")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, iamPolicyUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || (*dstURL == "") == (*configPath == "") {
+		fs.Usage()
+		return 2
+	}
+
+	access := sync.S3Access{
+		ReadOnly:     *readOnly,
+		Delete:       *del,
+		Copy:         *snapshots || *deleteTo == "trash" || *detectRenames || *dedupe || *stageUploads || len(tiers) > 0,
+		Tags:         len(tags) > 0,
+		ObjectLock:   *lockMode != "" || *legalHold,
+		CreateBucket: *createBucket,
+		Versions:     *versions,
+		SSE:          *sse,
+		SSEKMSKeyID:  *sseKMSKeyID,
+	}
+	if *configPath != "" {
+		others := false
+		fs.Visit(func(f *flag.Flag) { others = others || (f.Name != "config" && f.Name != "job") })
+		if others {
+			fmt.Fprintln(os.Stderr, "-config takes no other flags than -job; set options in the file")
+			return 2
+		}
+		j, err := loadJob(*configPath, *jobName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+			return 1
+		}
+		*dstURL, *profile = j.Dst, j.Profile
+		access = sync.S3Access{
+			ReadOnly:     j.ReadOnly,
+			Delete:       j.Delete,
+			Copy:         j.Snapshots || j.DeleteTo == "trash" || j.DetectRenames || j.Dedupe || j.StageUploads || len(j.Tiers) > 0,
+			Tags:         len(j.Tags) > 0,
+			ObjectLock:   j.LockMode != "" || j.LegalHold,
+			CreateBucket: j.CreateBucket,
+			SSE:          j.SSE,
+			SSEKMSKeyID:  j.SSEKMSKeyID,
+		}
+	}
+	if access.SSEKMSKeyID != "" && access.SSE == "" {
+		access.SSE = "aws:kms"
+	}
+
+	rawURL, err := sync.ExpandPrefix(*dstURL, prefixVars(*profile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-dst: %v\n", err)
+		return 2
+	}
+	policy, err := sync.S3Policy(rawURL, access)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iam-policy: %v\n", err)
+		return 1
+	}
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "iam-policy: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+// loadJob returns the job of the config file at path named name, or its
+// only job if name is empty. The file must be valid.
+func loadJob(path, name string) (*config.Job, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if problems := cfg.Validate(); len(problems) > 0 {
+		return nil, problems
+	}
+	if name == "" {
+		if len(cfg.Jobs) != 1 {
+			return nil, fmt.Errorf("%d jobs; name one with -job", len(cfg.Jobs))
+		}
+		return cfg.Jobs[0], nil
+	}
+	i := slices.IndexFunc(cfg.Jobs, func(j *config.Job) bool { return j.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("no job %q", name)
+	}
+	return cfg.Jobs[i], nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runImportState implements "foldersync import-state -src <dir> -dst <url>",
+// which writes the state cache of a sync job from one listing of its
+// destination, or from an S3 Inventory report, instead of a request per file.
+func runImportState(args []string) int {
+	fs := flag.NewFlagSet("import-state", flag.ExitOnError)
+	src := fs.String("src", "", "source directory of the sync job (required)")
+	dstURL := fs.String("dst", "", "destination URL of the sync job (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	keyLayout := fs.String("key-layout", "identity", "the -key-layout of the sync job")
+	nameKeyFile := fs.String("name-key-file", "", "the -name-key-file of the sync job")
+	stripComponents := fs.Int("strip-components", 0, "the -strip-components of the sync job")
+	keyTemplate := fs.String("key-template", "", "the -key-template of the sync job")
+	lowercaseKeys := fs.Bool("lowercase-keys", false, "the -lowercase-keys of the sync job")
+	unicodeForm := fs.String("unicode", "nfc", "the -unicode of the sync job")
+	inventory := fs.String("inventory", "", "URL of the manifest.json of an S3 Inventory report of the destination bucket, "+
+		"e.g. s3://inventory-bucket/photos-bucket/daily/2024-03-01T01-00Z/manifest.json")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync import-state -src <dir> -dst <url> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *src == "" || *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	keys, err := parseKeyLayout(*keyLayout, *nameKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "key layout: %v\n", err)
+		return 2
+	}
+	if keys, err = shapeKeys(keys, *stripComponents, *keyTemplate, *lowercaseKeys); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	form, err := sync.ParseUnicodeForm(*unicodeForm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-unicode: %v\n", err)
+		return 2
+	}
+	var inv *url.URL
+	if *inventory != "" {
+		if inv, err = url.Parse(*inventory); err != nil || inv.Scheme != "s3" || !strings.HasPrefix(*dstURL, "s3://") {
+			fmt.Fprintln(os.Stderr, "-inventory must be an s3:// URL, and only applies to s3:// destinations")
+			return 2
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rawURL, err := withParams(*dstURL, map[string]string{"region": *region})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	opts := sync.ImportOptions{Src: *src, Keys: keys, UnicodeForm: form}
+	if opts.Dst, err = sync.Open(ctx, rawURL); err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	if opts.StateCache, err = cachePath("state", *src, rawURL); err != nil {
+		fmt.Fprintf(os.Stderr, "state cache: %v\n", err)
+		return 1
+	}
+	if inv != nil {
+		if opts.Inventory, err = readInventory(ctx, inv, *region); err != nil {
+			fmt.Fprintf(os.Stderr, "inventory: %v\n", err)
+			return 1
+		}
+		if u, _ := url.Parse(*dstURL); opts.Inventory.Bucket != u.Host {
+			fmt.Fprintf(os.Stderr, "inventory: the report lists bucket %s, not %s\n", opts.Inventory.Bucket, u.Host)
+			return 1
+		}
+		fmt.Printf("inventory of %s taken %s\n", opts.Inventory.Bucket, opts.Inventory.Created.Format(time.DateTime))
+	}
+
+	report, err := sync.ImportState(ctx, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import-state failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("recorded %d of %d files as up to date\n", report.Seeded, report.Files)
+	return 0
+}
+
+// readInventory reads the S3 Inventory report whose manifest.json is at u.
+func readInventory(ctx context.Context, u *url.URL, region string) (*sync.Inventory, error) {
+	bucketURL, err := withParams("s3://"+u.Host, map[string]string{"region": region})
+	if err != nil {
+		return nil, err
+	}
+	from, err := sync.Open(ctx, bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	return sync.ReadInventory(ctx, from, strings.TrimPrefix(u.Path, "/"))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	stdsync "sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/config"
+)
+
+// runJobs implements "foldersync -config <file> [-daemon] [-job <name>]...":
+// it runs the named jobs of the file, or all of them, each as a foldersync
+// run of its own.
+//
+// Without daemon, jobs that watch keep running alongside the others, which
+// run once each, one at a time, in the order named or else in file order.
+// It returns once every job has finished, with the most severe exit status
+// of their runs: exitChanged if any changed something, exitPartial if any
+// stopped partway, and exitFatal if any failed otherwise.
+//
+// With daemon, each job runs on its schedule until a signal stops it; see
+// jobRunner.daemon.
+func runJobs(path string, names []string, daemon bool) int {
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitFatal
+	}
+	if problems := cfg.Validate(); len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, p)
+		}
+		return exitFatal
+	}
+	jobs := cfg.Jobs
+	if len(names) > 0 {
+		jobs = nil
+		for _, name := range names {
+			i := slices.IndexFunc(cfg.Jobs, func(j *config.Job) bool { return j.Name == name })
+			if i < 0 {
+				fmt.Fprintf(os.Stderr, "%s: no job %q\n", path, name)
+				return exitFatal
+			}
+			jobs = append(jobs, cfg.Jobs[i])
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r := &jobRunner{exe: exe}
+	if daemon {
+		r.daemon(ctx, jobs)
+		return 0
+	}
+	var wg stdsync.WaitGroup
+	for _, j := range jobs {
+		if j.Watch {
+			wg.Go(func() { r.run(ctx, j) })
+		}
+	}
+	for _, j := range jobs {
+		if !j.Watch && ctx.Err() == nil {
+			r.run(ctx, j)
+		}
+	}
+	wg.Wait()
+
+	if len(r.failed) > 0 {
+		slices.Sort(r.failed)
+		log.Printf("%d jobs failed: %v", len(r.failed), r.failed)
+	}
+	return r.status
+}
+
+// jobRunner runs the jobs of a configuration file.
+type jobRunner struct {
+	exe string // the foldersync executable
+
+	mu     stdsync.Mutex
+	failed []string // names of the jobs whose runs failed
+	status int      // the most severe exit status of the runs
+}
+
+// daemon runs each of jobs on its schedule until ctx is done, and then
+// waits for the runs in progress to stop. Jobs that watch run all along,
+// and jobs without a schedule run once, at the start. A run due while the
+// job's previous run is still going is skipped.
+func (r *jobRunner) daemon(ctx context.Context, jobs []*config.Job) {
+	log.Printf("daemon: running %d jobs", len(jobs))
+	var wg stdsync.WaitGroup
+	for _, j := range jobs {
+		if j.Watch || j.Schedule == "" {
+			wg.Go(func() { r.run(ctx, j) })
+			continue
+		}
+		s, err := config.ParseSchedule(j.Schedule)
+		if err != nil {
+			fatal(err) // checked by Validate
+		}
+		wg.Go(func() { r.schedule(ctx, j, s) })
+	}
+	wg.Wait()
+	log.Printf("daemon: stopped")
+}
+
+// schedule runs j whenever s says, until ctx is done.
+func (r *jobRunner) schedule(ctx context.Context, j *config.Job, s config.Schedule) {
+	var (
+		running atomic.Bool
+		runs    stdsync.WaitGroup
+	)
+	defer runs.Wait()
+	due := time.Now()
+	for {
+		// After a pause, such as the machine sleeping, runs that were
+		// missed are not made up for.
+		if now := time.Now(); due.Before(now) {
+			due = now
+		}
+		due = s.Next(due)
+		if due.IsZero() {
+			log.Printf("job %s: schedule %q never comes due", j.Name, j.Schedule)
+			return
+		}
+		at := due
+		if j.Jitter > 0 {
+			at = at.Add(rand.N(j.Jitter))
+		}
+		log.Printf("job %s: next run at %s", j.Name, at.Format(time.DateTime))
+		t := time.NewTimer(time.Until(at))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		if !running.CompareAndSwap(false, true) {
+			log.Printf("job %s: skipping this run: the previous one is still going", j.Name)
+			continue
+		}
+		runs.Go(func() {
+			defer running.Store(false)
+			r.run(ctx, j)
+		})
+	}
+}
+
+// run runs j once, logging when it starts and how it ended. With j.LogDir
+// set, the output of the run goes to a file there, named for the job and
+// the time it started.
+func (r *jobRunner) run(ctx context.Context, j *config.Job) {
+	start := time.Now()
+	var out io.Writer = os.Stdout
+	logged := ""
+	if j.LogDir != "" {
+		name := strings.ReplaceAll(j.Name, string(filepath.Separator), "_") + "-" + start.Format("20060102-150405") + ".log"
+		path := filepath.Join(j.LogDir, name)
+		f, err := createLog(path)
+		if err != nil {
+			log.Printf("job %s: %v", j.Name, err)
+			r.fail(j, exitFatal)
+			return
+		}
+		defer f.Close()
+		out = f
+		logged = " (log: " + path + ")"
+	}
+	log.Printf("job %s: started: %s -> %s%s", j.Name, j.Src, j.Dst, logged)
+	err := runJob(ctx, r.exe, j, out)
+	took := time.Since(start).Round(time.Second)
+	code := exitFatal
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+		code = exitUpToDate
+	case errors.As(err, &ee) && ee.ExitCode() >= 0 && ee.ExitCode() <= exitFatal:
+		code = ee.ExitCode()
+	}
+	switch code {
+	case exitUpToDate, exitChanged:
+		log.Printf("job %s: finished in %s (%s)", j.Name, took, statusNames[code])
+		r.record(code)
+	case exitPartial:
+		log.Printf("job %s: stopped partway after %s; the rest is left for the next run%s", j.Name, took, logged)
+		r.record(code)
+	default:
+		log.Printf("job %s: failed after %s: %v%s", j.Name, took, err, logged)
+		r.fail(j, code)
+	}
+}
+
+// record notes a run of a job that ended with exit status code.
+func (r *jobRunner) record(code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = max(r.status, code)
+}
+
+// fail notes a failed run of j, which ended with exit status code.
+func (r *jobRunner) fail(j *config.Job, code int) {
+	r.record(code)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(r.failed, j.Name) {
+		r.failed = append(r.failed, j.Name)
+	}
+}
+
+// createLog creates the log file at path, and its directory if need be.
+func createLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// runJob runs foldersync with the flags of j, named for notifications by
+// the job's name, writing its output to out.
+// It is stopped when ctx is done, cleanly where interruptJob allows. The
+// run reads no input, so files flagged by scan-secrets are not uploaded.
+func runJob(ctx context.Context, exe string, j *config.Job, out io.Writer) error {
+	args := j.Args()
+	if j.NotifyWebhook != "" || j.NotifySlack != "" || j.NotifySMTP != "" {
+		args = append(args, "-notify-job="+j.Name)
+	}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdout, cmd.Stderr = out, out
+	interruptJob(cmd)
+	cmd.WaitDelay = time.Minute
+	return cmd.Run()
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package main
+
+import "os/exec"
+
+// interruptJob leaves cmd to be killed: runs cannot be interrupted here.
+func interruptJob(cmd *exec.Cmd) {}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// interruptJob makes cmd stop on SIGINT, as a run interrupted with Ctrl-C
+// does, instead of being killed. The run is kept out of the terminal's
+// process group, so that it is only sent the one signal, which is the
+// second that makes a run exit at once.
+func interruptJob(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runJournal implements "foldersync journal inspect -src <dir> -dst <url>".
+func runJournal(args []string) int {
+	if len(args) == 0 || args[0] != "inspect" {
+		fmt.Fprintln(os.Stderr, "usage: foldersync journal inspect -src <dir> -dst <url> [-also-dst <url>]... [-all]")
+		return 2
+	}
+	fs := flag.NewFlagSet("journal inspect", flag.ExitOnError)
+	src := fs.String("src", "", "source directory of the sync job (required)")
+	dstURL := fs.String("dst", "", "destination URL of the sync job (required)")
+	var alsoDsts stringsFlag
+	fs.Var(&alsoDsts, "also-dst", "further destination URL of the sync job; repeat for each")
+	all := fs.Bool("all", false, "also list the operations that completed")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync journal inspect -src <dir> -dst <url> [-also-dst <url>]... [-all]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if *src == "" || *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	path, err := cachePath("journal", *src, append([]string{*dstURL}, alsoDsts...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal: %v\n", err)
+		return 1
+	}
+	j, err := sync.ReadJournal(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("no interrupted run: the last run finished")
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "journal: %v\n", err)
+		return 1
+	}
+
+	pending := j.Pending()
+	fmt.Printf("run started %s did not finish: %d of %d operations done\n",
+		j.Started.Format(time.RFC3339), len(j.Entries)-len(pending), len(j.Entries))
+	if j.Truncated {
+		fmt.Println("the last journal entry was cut short")
+	}
+	for _, e := range j.Entries {
+		switch {
+		case !e.Done:
+			fmt.Printf("pending  %s %s\n", e.Op, e.Key)
+		case *all:
+			fmt.Printf("done     %s %s\n", e.Op, e.Key)
+		}
+	}
+	return 0
+}
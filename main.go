@@ -1,54 +1,1551 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/sandeepkandula/foldersync/sync"
 )
 
 func main() {
-	src := flag.String("src", "", "source directory (required)")
-	bucket := flag.String("bucket", "", "S3 destination bucket (required)")
-	prefix := flag.String("prefix", "", "key prefix within the bucket")
-	region := flag.String("region", "us-east-1", "AWS region")
-	storageClass := flag.String("storage-class", "GLACIER_IR",
-		"S3 storage class: GLACIER_IR (cheapest, instant access), STANDARD_IA, STANDARD")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "mount":
+			os.Exit(runMount(os.Args[2:]))
+		case "migrate-prefix":
+			os.Exit(runMigratePrefix(os.Args[2:]))
+		case "touch":
+			os.Exit(runTouch(os.Args[2:]))
+		case "journal":
+			os.Exit(runJournal(os.Args[2:]))
+		case "verify-replicas":
+			os.Exit(runVerifyReplicas(os.Args[2:]))
+		case "import-state":
+			os.Exit(runImportState(os.Args[2:]))
+		case "drill":
+			os.Exit(runDrill(os.Args[2:]))
+		case "purge":
+			os.Exit(runPurge(os.Args[2:]))
+		case "cleanup":
+			os.Exit(runCleanup(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "audit":
+			os.Exit(runAudit(os.Args[2:]))
+		case "control":
+			os.Exit(runControl(os.Args[2:]))
+		case "stats":
+			os.Exit(runStats(os.Args[2:]))
+		case "iam-policy":
+			os.Exit(runIAMPolicy(os.Args[2:]))
+		case "versions":
+			os.Exit(runVersions(os.Args[2:]))
+		case "plan":
+			runSync(os.Args[2:], &planRun{})
+		case "apply":
+			os.Exit(runApply(os.Args[2:]))
+		}
+	}
+	runSync(os.Args[1:], nil)
+}
+
+// runSync runs a sync with the flags args, or, if pr is set, makes or
+// applies its plan for "foldersync plan" and "foldersync apply".
+func runSync(args []string, pr *planRun) {
+	var srcs stringsFlag
+	flag.Var(&srcs, "src", "source directory (required); repeat to sync several, each as dir or prefix=dir, under the prefix or else the directory's name")
+	dstURL := flag.String("dst", "", "destination URL: s3://bucket/prefix, gs://bucket/prefix, b2://bucket/prefix, r2://bucket/prefix, webdavs://host/path, sftp://user@host/path, https://host/path or file:///path (required)")
+	var alsoDsts stringsFlag
+	flag.Var(&alsoDsts, "also-dst", "also upload to this destination URL in the same run, with its settings in URL parameters; repeat for each")
+	parallelDsts := flag.Bool("parallel-dsts", false, "with -also-dst, write to every destination at once rather than one after another")
+	region := flag.String("region", "", "AWS region for s3:// destinations (default: from the environment, else us-east-1)")
+	accountID := flag.String("account-id", "", "Cloudflare account ID of the bucket for r2:// destinations (default: $CLOUDFLARE_ACCOUNT_ID)")
+	profile := flag.String("profile", "", "AWS shared config profile to load credentials and settings from, including IAM Identity Center (SSO) profiles (default: from the environment)")
+	roleARN := flag.String("role-arn", "", "ARN of an IAM role to assume for s3:// destinations, with the credentials of -profile or the environment")
+	externalID := flag.String("external-id", "", "external ID the trust policy of -role-arn requires")
+	roleSessionName := flag.String("role-session-name", "", "name of the sessions of -role-arn, as CloudTrail shows them (default foldersync)")
+	storageClass := flag.String("storage-class", "",
+		"storage class; S3: GLACIER_IR (default, cheapest instant access), STANDARD_IA, STANDARD; "+
+			"GCS: NEARLINE (default), COLDLINE, ARCHIVE, STANDARD; R2: STANDARD (default), STANDARD_IA")
+	sse := flag.String("sse", "", "S3 server-side encryption: AES256 (SSE-S3) or aws:kms (SSE-KMS) (default: the bucket's default)")
+	sseKMSKeyID := flag.String("sse-kms-key-id", "", "KMS key ID or ARN to encrypt S3 objects with; implies -sse aws:kms")
+	var sseContext stringsFlag
+	flag.Var(&sseContext, "sse-context", "SSE-KMS encryption context pair to encrypt S3 objects with, as key=value (repeatable); implies -sse aws:kms")
+	endpointURL := flag.String("endpoint-url", "", "URL of an S3-compatible service, such as MinIO or Wasabi, to use instead of AWS for s3:// destinations")
+	pathStyle := flag.Bool("path-style", false, "with -endpoint-url, name the bucket in the path of request URLs rather than the host name")
+	compat := flag.String("compat", "", "how much of the S3 API the S3-compatible service supports: full (default; legacy for r2://) or legacy, without additional checksums and with foldersync's metadata in one header")
+	tlsSkipVerify := flag.Bool("tls-skip-verify", false, "accept any TLS certificate from the S3 endpoint, such as a self-signed one (insecure)")
+	accelerate := flag.Bool("accelerate", false, "send S3 requests through S3 Transfer Acceleration, which the bucket must have enabled")
+	requesterPays := flag.Bool("requester-pays", false, "pay for the requests to a Requester Pays S3 bucket")
+	partSizeMB := flag.Int("part-size-mb", 0, "size of the parts S3 and B2 upload larger files in, from 5 to 5120 MiB (default 5 for S3, 100 for B2)")
+	uploadConcurrency := flag.Int("upload-concurrency", 0, "parts of a file uploaded to S3 or B2 at once (default 5 for S3, 1 for B2)")
+	adaptiveConcurrency := flag.Bool("adaptive-concurrency", false, "adapt the parts of a file uploaded to S3 at once to how they fare, ramping up until S3 throttles them or they slow down, up to -upload-concurrency (default 16 with this)")
+	leaveParts := flag.Bool("leave-parts-on-error", false, "don't abort S3 multipart uploads that fail, leaving their parts stored and billed")
+	listConcurrency := flag.Int("list-concurrency", 0, "key prefixes of an S3 destination listed at once; 1 lists it in a single sequence of requests (default 8)")
+	createBucket := flag.Bool("create-bucket", false,
+		"create the S3 bucket if it does not exist, with versioning, default encryption (that of -sse, else AES256) and public access blocked")
+	abortUploadsDays := flag.Int("abort-uploads-after-days", 0,
+		"with -create-bucket, add a lifecycle rule to the new bucket aborting multipart uploads still incomplete after this many days")
+	walkConcurrency := flag.Int("walk-concurrency", 1, "source directories read at once while walking the source, for network filesystems and spinning disks")
+	onWalkError := flag.String("on-walk-error", "fail",
+		"what to do with source files and directories that cannot be read, such as those permissions deny: fail, skip (leave them out and list them) or retry (read them again a few times, then fail)")
+	statConcurrency := flag.Int("stat-concurrency", 8, "files looked up at the destination at once, ahead of the walk; 1 looks each up in turn")
 	dryRun := flag.Bool("dry-run", false, "print actions without making changes")
-	delete := flag.Bool("delete", false, "delete S3 objects absent from src")
-	flag.Parse()
+	estimate := flag.Bool("estimate", false,
+		"print the projected upload and the monthly storage and restore costs in each storage class instead of the actions, without making changes (implies -dry-run)")
+	filesFrom := flag.String("files-from", "",
+		"sync only the files and directories listed in this file, or stdin if -, as paths relative to -src, one per line or NUL-separated")
+	itemize := flag.Bool("itemize", false,
+		"print each change in the style of rsync -i, saying how each uploaded file differs from the destination's copy, and with -dry-run a summary of the changes")
+	interactive := flag.Bool("interactive", false, "ask before each upload and delete: y (yes), n (no), a (yes to all the rest) or q (quit without changing anything)")
+	delete := flag.Bool("delete", false, "delete destination objects absent from src")
+	deleteExcluded := flag.Bool("delete-excluded", false,
+		"with -delete, also delete the objects of paths .foldersyncignore files exclude, as rsync --delete-excluded does, and of keys that do not fit the -key-layout")
+	expireAfterDays := flag.Int("expire-after-days", 0,
+		"with -delete, leave objects at least this many days old to the destination's lifecycle expiry rule instead of deleting them")
+	deleteTo := flag.String("delete-to", "",
+		"with -delete, move objects to a trash instead of deleting them: \"trash\" for one kept at the destination, or the URL of another destination")
+	reportExtraneous := flag.String("report-extraneous", "",
+		"write the keys of destination objects absent from src to this file, one per line, with or without -delete")
+	keyLayout := flag.String("key-layout", "identity",
+		"how file paths map to destination keys: identity, sanitized (percent-encode unsafe characters), "+
+			"portable (sanitized, and valid file names on Windows), hashed (under a hash prefix), date (under the mtime's date) "+
+			"or encrypted (every name encrypted with -name-key-file)")
+	nameKeyFile := flag.String("name-key-file", "", "with -key-layout encrypted, file holding the secret (at least 16 bytes) names are encrypted with")
+	stripComponents := flag.Int("strip-components", 0, "drop this many leading directories from each path before it is mapped to a key, as tar --strip-components does")
+	keyTemplate := flag.String("key-template", "",
+		"rewrite each path before it is mapped to a key, with the variables {path}, {dir}, {name}, {stem}, {ext} and {date:layout}, e.g. {ext}/{path}")
+	lowercaseKeys := flag.Bool("lowercase-keys", false, "put each path in lower case before it is mapped to a key")
+	unicodeForm := flag.String("unicode", "nfc",
+		"Unicode normalization of file names in keys, so names written by macOS and Linux match: nfc, nfd or none; -two-way keeps names as they are")
+	caseCollisions := flag.String("case-collisions", "warn",
+		"what to do with files whose paths differ only in case, which collide when restored to macOS or Windows: ignore, warn, fail, or rename (store all but the first as name.case-N.ext)")
+	var tagFlags stringsFlag
+	flag.Var(&tagFlags, "tag", "S3 object tag to attach to uploaded files, as key=value (repeatable)")
+	var metadataFlags stringsFlag
+	flag.Var(&metadataFlags, "metadata",
+		"header or custom metadata to set on uploads of matching files, as pattern:name=value, where name is "+
+			"Cache-Control, Content-Disposition, Content-Encoding or custom; S3 and GCS only (repeatable, first matching pattern wins)")
+	var tierFlags stringsFlag
+	flag.Var(&tierFlags, "tier",
+		"store files last modified at least age ago in a storage class, as [pattern=]class:age, "+
+			"e.g. DEEP_ARCHIVE:90d; existing objects move when they reach it (repeatable, first match wins)")
+	lockMode := flag.String("lock-mode", "", "S3 Object Lock retention mode of uploaded files: governance or compliance; needs -lock-retain")
+	lockRetain := flag.String("lock-retain", "", "with -lock-mode, how long to retain each uploaded file from its upload, e.g. 90d")
+	legalHold := flag.Bool("legal-hold", false, "place an S3 Object Lock legal hold on uploaded files")
+	contentType := flag.String("content-type", "detect",
+		"how to set each object's Content-Type: detect (from the extension, else the content), extension, or none")
+	preservePOSIX := flag.Bool("preserve-posix", false, "record file permissions, ownership and extended attributes for restore")
+	sparse := flag.Bool("sparse", false, "upload only the data of files with holes, such as disk images, and recreate the holes on restore")
+	compress := flag.String("compress", "none", "compress each file before uploading it: none, gzip, or zstd")
+	checksums := flag.Bool("checksums", false,
+		"record each file's SHA-256 with its object, have S3 check uploads with a SHA-256 checksum, and check restored content against it")
+	appendUploads := flag.Bool("append-uploads", false,
+		"upload only what was appended to files that grew, such as logs, copying the rest of their S3 object server-side (needs -checksums)")
+	verifyAfterUpload := flag.Bool("verify-after-upload", false,
+		"read each object's metadata back after uploading it, and fail the file if its size or recorded SHA-256 is not what was sent")
+	stageUploads := flag.Bool("stage-uploads", false,
+		"upload each file under .foldersync/staging/ and move it to its key only once it is complete and, with -verify-after-upload, checked")
+	compare := flag.String("compare", "mtime", "how to detect changed files: mtime (size and mtime), size, checksum, or etag (MD5 against the object's ETag, else mtime)")
+	mtimeWindow := flag.Duration("mtime-window", 0, "treat mtimes within this window as equal, such as 2s for FAT32 and exFAT (-compare mtime and -two-way)")
+	var compareRules stringsFlag
+	flag.Var(&compareRules, "compare-rule",
+		"compare files matching a pattern differently, as pattern=mode with mode mtime, size, checksum or always; "+
+			"e.g. '*.mp4=size' (repeatable, first match wins)")
+	reconcileEvery := flag.Int("reconcile-every", 0,
+		"also verify 1/N of unchanged files by checksum each run, covering all files every N daily runs")
+	networkSource := flag.Bool("network-source", false,
+		"source is an NFS/SMB mount with unreliable mtimes; shorthand for -compare size -reconcile-every 30")
+	minSize := flag.String("min-size", "", "skip files smaller than this, e.g. 1KB")
+	maxSize := flag.String("max-size", "", "skip files larger than this, e.g. 4GB")
+	modifiedAfter := flag.String("modified-after", "",
+		"skip files last modified before this date (2024-03-01), RFC 3339 time or age (30d, 2w, 36h)")
+	modifiedBefore := flag.String("modified-before", "", "skip files last modified at or after this date, time or age")
+	skipInFlight := flag.Bool("skip-in-flight", false, "skip files that look to be in the middle of being written: temporary and lock files, files modified within -in-flight-age and, on Linux, files open for writing")
+	inFlightAge := flag.Duration("in-flight-age", time.Minute, "with -skip-in-flight, skip files modified less than this long ago; 0 skips none for their age")
+	var inFlightPatterns stringsFlag
+	flag.Var(&inFlightPatterns, "in-flight-pattern", "with -skip-in-flight, also skip files whose names match this pattern, such as '*.download'; repeat for each")
+	maxChange := flag.Float64("max-change", 0,
+		"refuse runs that would replace or delete more than this percentage of destination objects (0 = no limit)")
+	force := flag.Bool("force", false, "proceed even if -max-change is exceeded")
+	maxDstSize := flag.String("max-dst-size", "",
+		"refuse runs that would leave more than this much data at the destination, e.g. 500GB")
+	var quotaTrim stringsFlag
+	flag.Var(&quotaTrim, "quota-trim",
+		"with -max-dst-size, skip uploads matching this pattern to stay under the quota; repeat in the order to give them up")
+	quotaFill := flag.Bool("quota-fill", false,
+		"with -max-dst-size, upload files in order until the quota is reached and skip the rest, instead of failing the run")
+	skipUnchangedDirs := flag.Bool("skip-unchanged-dirs", false,
+		"don't check the destination for files in directories unchanged since the last run")
+	noCache := flag.Bool("no-cache", false, "don't use or update the local cache of synced files; check every file at the destination")
+	hashCacheVerify := flag.Float64("hash-cache-verify", 0,
+		"percentage of the files the local hash cache vouches for to hash again anyway, to check the cache")
+	checkpoint := flag.Duration("checkpoint", time.Minute,
+		"save the local cache of synced files this often during a run, so that a run that is killed resumes where it left off (0 = only at the end)")
+	incremental := flag.Bool("incremental", false,
+		"trust the local cache of synced files alone: upload changed files and delete removed ones without listing or checking the destination")
+	fullEvery := flag.Duration("full-every", 24*time.Hour,
+		"with -incremental, check the destination fully once this long has passed since the last full run (0 = only when the cache is lost)")
+	snapshots := flag.Bool("snapshots", false,
+		"keep every run restorable: copy each run's manifest and each replaced or deleted object server-side; implies -manifest")
+	detectRenames := flag.Bool("detect-renames", false,
+		"copy renamed and moved files server-side from their old objects instead of uploading them again")
+	dedupe := flag.Bool("dedupe", false,
+		"copy files identical to one uploaded earlier in the run server-side from its object instead of uploading them again")
+	hardLinks := flag.Bool("hard-links", false, "upload hard-linked files once and recreate the links on restore")
+	keepEmptyDirs := flag.Bool("keep-empty-dirs", false, "record empty directories and recreate them on restore")
+	sourceSnapshot := flag.String("source-snapshot", "",
+		"sync from a snapshot of the source's filesystem, taken before the run and deleted after it: zfs, lvm or vss")
+	lvmSnapshotSize := flag.String("lvm-snapshot-size", "", "with -source-snapshot lvm, the space set aside for blocks changed during the run (default 1G)")
+	metaCacheAge := flag.Duration("meta-cache-age", 0,
+		"reuse destination listings and metadata fetched by any command within this window instead of fetching them again (0 = off)")
+	bundleThreshold := flag.Int64("bundle-threshold-kb", 0,
+		"pack files smaller than this many KB into tar bundles instead of uploading each as an object (0 = off)")
+	bundleSize := flag.Int64("bundle-size-mb", 64, "with -bundle-threshold-kb, the size of each bundle in MB")
+	chunkThreshold := flag.Int64("chunk-threshold-mb", 0,
+		"split files of at least this many MB into content-defined chunks, uploading only the chunks not stored before (0 = off)")
+	chunkSize := flag.Int("chunk-size-kb", 1024, "with -chunk-threshold-mb, the average chunk size in KB, a power of two of at least 64")
+	twoWay := flag.Bool("two-way", false, "propagate changes in both directions, for sharing a folder between machines through dst")
+	conflict := flag.String("conflict", "fail",
+		"with -two-way, what to do with files changed on both sides: fail, newer-wins, or keep-both (rename the local copy)")
+	watch := flag.Bool("watch", false, "keep running and sync files as they change")
+	verify := flag.Bool("verify", false, "compare the destination to src without writing, report differences and exit non-zero if there are any")
+	debounce := flag.Duration("debounce", 2*time.Second, "with -watch, wait until files have been quiet this long before syncing them")
+	priority := flag.String("priority", "path-order",
+		"order to upload files in: path-order, smallest-first, largest-first or newest-first")
+	maxDuration := flag.Duration("max-duration", 0,
+		"start no more uploads once the run has taken this long, leaving the rest for the next run (0 = no limit)")
+	maxTransfer := flag.String("max-transfer", "",
+		"start no more uploads once the next would take the data uploaded past this, e.g. 20GB, leaving the rest for the next run")
+	maxRequests := flag.Int("max-requests-per-run", 0,
+		"stop after this many requests to the destination, leaving the rest for the next run (0 = no limit)")
+	requestRate := flag.Float64("requests-per-second", 0, "space requests to the destination out to at most this rate (0 = no limit)")
+	readOnly := flag.Bool("read-only", false, "refuse any write to the destination")
+	isolate := flag.Bool("isolate", false,
+		"mark the destination as this job's and refuse -delete or -two-way if another job's destination overlaps it")
+	remoteLock := flag.Bool("remote-lock", false, "also hold a lock object in the destination while running, to keep out runs on other machines")
+	lockStale := flag.Duration("lock-stale", 10*time.Minute, "take over locks that have not been refreshed for this long, left by runs that died (0 = never)")
+	forceUnlock := flag.Bool("force-unlock", false, "take the run locks even if another run holds them")
+	retries := flag.Int("retries", 2, "retries per failed destination operation")
+	keepGoing := flag.Bool("keep-going", false, "carry on past files that fail to upload, and fail the run once it is done, deleting nothing")
+	fileTimeout := flag.Duration("file-timeout", 0, "fail the upload of a file that takes longer than this (0 = no limit)")
+	stallTimeout := flag.Duration("stall-timeout", 0,
+		"abort and retry the upload of a file of which nothing has been sent for this long (0 = never)")
+	breakerThreshold := flag.Int("breaker-threshold", 5,
+		"consecutive destination failures before pausing and declaring it unavailable")
+	breakerCooldown := flag.Duration("breaker-cooldown", 30*time.Second, "pause before probing a failing destination again")
+	manifest := flag.Bool("manifest", false, "write a manifest of the source tree to the destination after each run")
+	manifestChecksums := flag.Bool("manifest-checksums", false,
+		"record each file's SHA-256 in the manifest, for 'foldersync drill' to check restores against; implies -manifest")
+	signKey := flag.String("sign-key", "", "Ed25519 private key (PKCS #8 PEM) used to sign the manifest")
+	verifyKey := flag.String("verify-key", "", "Ed25519 public key (PEM) the existing manifest must be signed with before -delete runs")
+	preCmdFlag := flag.String("pre-cmd", "", "shell command to run before syncing, e.g. to quiesce a database; the sync is skipped if it fails")
+	postCmdFlag := flag.String("post-cmd", "", "shell command to run after syncing, successfully or not, with a summary in FOLDERSYNC_* variables")
+	scanSecrets := flag.Bool("scan-secrets", false, "flag files that look like secrets and ask before uploading them")
+	configPath := flag.String("config", "", "run the jobs of this configuration file instead of the one given by flags")
+	var jobNames stringsFlag
+	flag.Var(&jobNames, "job", "with -config, run only this job (repeatable; default: every job)")
+	daemon := flag.Bool("daemon", false, "with -config, keep running and run each job on its schedule")
+	metricsAddr := flag.String("metrics-addr", "", "with -watch, serve Prometheus metrics at /metrics on this address, e.g. :9100")
+	controlSocket := flag.String("control-socket", "", "with -watch, serve an API to sync now, pause, resume and see status and events on this unix socket; see 'foldersync control'")
+	pushgateway := flag.String("pushgateway", "", "push Prometheus metrics of the run to this Pushgateway URL when it finishes")
+	pushJob := flag.String("push-job", "foldersync", "with -pushgateway, the job name to group the metrics under")
+	notifyWebhook := flag.String("notify-webhook", "", "when a run finishes, POST a JSON summary of it to this URL")
+	notifySlack := flag.String("notify-slack", "", "when a run finishes, post a summary of it to this Slack incoming webhook URL")
+	notifySMTP := flag.String("notify-smtp", "", "when a run finishes, mail a summary of it through this server, as smtp://[user:password@]host[:port]")
+	notifyFrom := flag.String("notify-mail-from", "", "with -notify-smtp, the sender of the mail")
+	var notifyTo, notifyOn stringsFlag
+	flag.Var(&notifyTo, "notify-mail-to", "with -notify-smtp, a recipient of the mail (repeatable)")
+	flag.Var(&notifyOn, "notify-on", "the runs to notify: success, failure or changes (repeatable; default failure)")
+	notifyChanges := flag.Int("notify-changes", 0, "with -notify-on changes, notify runs that uploaded or deleted more than this many files")
+	notifyJob := flag.String("notify-job", "", "the name of the run in notifications (default: the source)")
+	summary := flag.String("summary", "text", "print a summary of the run as its last line of output: text, json or none")
+	output := flag.String("output", "text",
+		"text, or jsonl to print each change and the summary as JSON lines on stdout, and everything else on stderr")
+	quiet := flag.Bool("quiet", false, "print only warnings, errors and output for scripts, such as -summary json; same as -v=0")
+	verbose := flag.Bool("verbose", false, "also print how long planning and applying took and what the plan holds; same as -v=2")
+	verbosity := flag.Int("v", 1,
+		"how much to print: 0 warnings and errors, 1 each change and the summary, 2 timings and the plan too, 3 every file found up to date as well")
+	var planOut *string
+	if pr != nil && pr.apply == nil {
+		planOut = flag.String("out", "", "file to write the plan to (required)")
+	}
+	if pr != nil && pr.apply != nil {
+		var err error
+		if args, err = pr.applyArgs(); err != nil {
+			fatal(err)
+		}
+	}
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(args); err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(exitFatal)
+	}
 
-	if *src == "" || *bucket == "" {
-		fmt.Fprintln(os.Stderr, "usage: foldersync -src <dir> -bucket <bucket> [options]")
+	if pr != nil {
+		if *configPath != "" || *watch || *twoWay || *verify || *estimate || *interactive {
+			fatal("plan cannot be combined with -config, -watch, -two-way, -verify, -estimate or -interactive")
+		}
+		if planOut != nil {
+			if *planOut == "" {
+				fatal("usage: foldersync plan -out <file> -src <dir> -dst <url> [options]")
+			}
+			pr.out = *planOut
+			pr.args = withoutFlag(args, "out")
+			*dryRun = true
+		} else {
+			// The plan already holds the files these chose.
+			*filesFrom, *reportExtraneous = "", ""
+		}
+	}
+	if *configPath != "" {
+		others := flag.NArg() > 0
+		flag.Visit(func(f *flag.Flag) { others = others || (f.Name != "config" && f.Name != "job" && f.Name != "daemon") })
+		if others {
+			fmt.Fprintln(os.Stderr, "-config takes no other flags than -job and -daemon; set options in the file")
+			os.Exit(exitFatal)
+		}
+		os.Exit(runJobs(*configPath, jobNames, *daemon))
+	}
+	if len(jobNames) > 0 || *daemon {
+		fatal("-job and -daemon need -config")
+	}
+	if len(srcs) == 0 || *dstURL == "" {
+		fmt.Fprintln(os.Stderr, "usage: foldersync -src <dir> -dst <url> [options]")
+		fmt.Fprintln(os.Stderr, "       foldersync -config <file> [-daemon] [-job <name>]...")
 		flag.PrintDefaults()
+		os.Exit(exitFatal)
+	}
+	// Expanded once, so that a run going past midnight keeps to one prefix.
+	vars := prefixVars(*profile)
+	expanded, err := sync.ExpandPrefix(*dstURL, vars)
+	if err != nil {
+		fatalf("-dst: %v", err)
+	}
+	*dstURL = expanded
+	for i, u := range alsoDsts {
+		expanded, err := sync.ExpandPrefix(u, vars)
+		if err != nil {
+			fatalf("-also-dst: %v", err)
+		}
+		alsoDsts[i] = expanded
+	}
+	if *summary != "text" && *summary != "json" && *summary != "none" {
+		fatalf("unknown -summary format %q (want text, json or none)", *summary)
+	}
+	levelSet := false
+	flag.Visit(func(f *flag.Flag) { levelSet = levelSet || f.Name == "v" })
+	switch {
+	case *quiet && (*verbose || levelSet), *verbose && levelSet:
+		fatal("give only one of -quiet, -verbose and -v")
+	case *quiet:
+		*verbosity = 0
+	case *verbose:
+		*verbosity = 2
+	case *verbosity < 0:
+		fatal("-v must not be negative")
+	}
+	// human receives output meant to be read rather than parsed.
+	var human io.Writer = os.Stdout
+	switch *output {
+	case "text":
+	case "jsonl":
+		if *verify {
+			fatal("-output jsonl cannot be combined with -verify")
+		}
+		human = os.Stderr
+		if *summary != "none" {
+			*summary = "json"
+		}
+	default:
+		fatalf("unknown -output format %q (want text or jsonl)", *output)
+	}
+	if *verbosity == 0 && *summary == "text" {
+		*summary = "none"
+	}
+	sources, err := parseSources(srcs)
+	if err != nil {
+		fatal(err)
+	}
+	src := srcs[0]
+	if sources != nil {
+		if *watch || *twoWay {
+			fatal("several -src cannot be combined with -watch or -two-way")
+		}
+		// The caches are kept for the set of sources.
+		src = sourcesLabel(sources)
+	}
+
+	if *estimate {
+		if *watch || *twoWay || *verify {
+			fatal("-estimate cannot be combined with -watch, -two-way or -verify")
+		}
+		*dryRun = true
+	}
+	if *interactive && (*watch || *twoWay || *verify) {
+		fatal("-interactive cannot be combined with -watch, -two-way or -verify")
+	}
+	if *filesFrom != "" && (*watch || *twoWay || *verify || sources != nil) {
+		fatal("-files-from cannot be combined with -watch, -two-way, -verify or several -src")
+	}
+	if *filesFrom == "-" && *interactive {
+		fatal("-files-from - cannot be combined with -interactive, which reads its answers from stdin")
+	}
+	if *verify && *watch {
+		fatal("-verify cannot be combined with -watch")
+	}
+	if *maxRequests > 0 && *watch {
+		fatal("-max-requests-per-run cannot be combined with -watch")
+	}
+	if *maxDuration < 0 {
+		fatal("-max-duration must not be negative")
+	}
+	var transferBudget int64
+	if *maxTransfer != "" {
+		if transferBudget, err = sync.ParseSize(*maxTransfer); err != nil {
+			fatalf("-max-transfer: %v", err)
+		}
+	}
+	if (*maxDuration > 0 || transferBudget > 0) && (*watch || *twoWay) {
+		fatal("-max-duration and -max-transfer cannot be combined with -watch or -two-way")
+	}
+	if *lockStale < 0 {
+		fatal("-lock-stale must not be negative")
+	}
+	if *hashCacheVerify < 0 || *hashCacheVerify > 100 {
+		fatal("-hash-cache-verify must be a percentage between 0 and 100")
+	}
+	if *checkpoint < 0 {
+		fatal("-checkpoint must not be negative")
+	}
+	if *fileTimeout < 0 || *stallTimeout < 0 {
+		fatal("-file-timeout and -stall-timeout must not be negative")
+	}
+	if *twoWay && (*watch || *verify || *noCache) {
+		fatal("-two-way cannot be combined with -watch, -verify or -no-cache")
+	}
+	if len(alsoDsts) > 0 && (*twoWay || *snapshots || *detectRenames || *deleteTo != "") {
+		fatal("-also-dst cannot be combined with -two-way, -snapshots, -detect-renames or -delete-to")
+	}
+	if *parallelDsts && len(alsoDsts) == 0 {
+		fatal("-parallel-dsts has no effect without -also-dst")
+	}
+	if (*preCmdFlag != "" || *postCmdFlag != "") && (*watch || *twoWay || *verify) {
+		fatal("-pre-cmd and -post-cmd cannot be combined with -watch, -two-way or -verify")
+	}
+	if *reportExtraneous != "" && (*watch || *twoWay) {
+		fatal("-report-extraneous cannot be combined with -watch or -two-way")
+	}
+	if *incremental && (*noCache || *watch || *twoWay || *verify || *expireAfterDays > 0) {
+		fatal("-incremental cannot be combined with -no-cache, -watch, -two-way, -verify or -expire-after-days")
+	}
+	if *snapshots && (*watch || *twoWay || *bundleThreshold > 0) {
+		fatal("-snapshots cannot be combined with -watch, -two-way or -bundle-threshold-kb")
+	}
+	if *appendUploads && !*checksums {
+		fatal("-append-uploads needs -checksums")
+	}
+	if *manifestChecksums && (*noCache || *twoWay) {
+		fatal("-manifest-checksums cannot be combined with -no-cache or -two-way")
+	}
+	if *detectRenames && (*noCache || *twoWay || *verify) {
+		fatal("-detect-renames cannot be combined with -no-cache, -two-way or -verify")
+	}
+	if *dedupe && (len(alsoDsts) > 0 || *twoWay || *verify || *sparse) {
+		fatal("-dedupe cannot be combined with -also-dst, -two-way, -verify or -sparse")
+	}
+	if *hardLinks && (*watch || *twoWay || *snapshots) {
+		fatal("-hard-links cannot be combined with -watch, -two-way or -snapshots")
+	}
+	if *keepEmptyDirs && (*watch || *twoWay || *filesFrom != "") {
+		fatal("-keep-empty-dirs cannot be combined with -watch, -two-way or -files-from")
+	}
+	var snapshotter sync.Snapshotter
+	if *sourceSnapshot != "" || *lvmSnapshotSize != "" {
+		if snapshotter, err = sync.ParseSnapshotter(*sourceSnapshot, *lvmSnapshotSize); err != nil {
+			fatalf("-source-snapshot: %v", err)
+		}
+		if *watch || *twoWay {
+			fatal("-source-snapshot cannot be combined with -watch or -two-way")
+		}
+	}
+	if *metricsAddr != "" && !*watch {
+		fatal("-metrics-addr needs -watch; use -pushgateway for single runs")
+	}
+	if *controlSocket != "" && !*watch {
+		fatal("-control-socket needs -watch")
+	}
+	if *pushgateway != "" && (*watch || *twoWay || *verify) {
+		fatal("-pushgateway cannot be combined with -watch, -two-way or -verify")
+	}
+	if *bundleThreshold < 0 || *bundleSize <= 0 {
+		fatal("-bundle-threshold-kb must not be negative and -bundle-size-mb must be positive")
+	}
+	if *bundleThreshold > 0 && (*watch || *twoWay) {
+		fatal("-bundle-threshold-kb cannot be combined with -watch or -two-way")
+	}
+	if *chunkThreshold < 0 {
+		fatal("-chunk-threshold-mb must not be negative")
+	}
+	if *chunkThreshold > 0 && (*twoWay || *snapshots || *detectRenames || *deleteTo != "") {
+		fatal("-chunk-threshold-mb cannot be combined with -two-way, -snapshots, -detect-renames or -delete-to")
+	}
+	conflictPolicy, err := sync.ParseConflictPolicy(*conflict)
+	if err != nil {
+		fatal(err)
+	}
+	keys, err := parseKeyLayout(*keyLayout, *nameKeyFile)
+	if err != nil {
+		fatal(err)
+	}
+	if *keyLayout != "identity" && (*watch || *twoWay) {
+		fatal("-key-layout cannot be combined with -watch or -two-way")
+	}
+	if keys, err = shapeKeys(keys, *stripComponents, *keyTemplate, *lowercaseKeys); err != nil {
+		fatal(err)
+	}
+	if _, shaped := keys.(sync.ShapedKeys); shaped && (*watch || *twoWay) {
+		fatal("-strip-components, -key-template and -lowercase-keys cannot be combined with -watch or -two-way")
+	}
+	form, err := sync.ParseUnicodeForm(*unicodeForm)
+	if err != nil {
+		fatalf("-unicode: %v", err)
+	}
+	casePolicy, err := sync.ParseCaseCollisionPolicy(*caseCollisions)
+	if err != nil {
+		fatal(err)
+	}
+	if casePolicy == sync.CaseCollisionsRename && *watch {
+		fatal("-case-collisions rename cannot be combined with -watch")
+	}
+	filters, err := parseFilters(*minSize, *maxSize, *modifiedAfter, *modifiedBefore)
+	if err != nil {
+		fatal(err)
+	}
+	if filters.set && *twoWay {
+		fatal("-min-size, -max-size, -modified-after and -modified-before cannot be combined with -two-way")
+	}
+	if *skipInFlight && (*watch || *twoWay) {
+		fatal("-skip-in-flight cannot be combined with -watch or -two-way")
+	}
+	if len(inFlightPatterns) > 0 && !*skipInFlight {
+		fatal("-in-flight-pattern needs -skip-in-flight")
+	}
+	if *inFlightAge < 0 {
+		fatal("-in-flight-age must not be negative")
+	}
+	var quota int64
+	if *maxDstSize != "" {
+		if quota, err = sync.ParseSize(*maxDstSize); err != nil {
+			fatalf("-max-dst-size: %v", err)
+		}
+	}
+	if len(quotaTrim) > 0 && quota == 0 {
+		fatal("-quota-trim needs -max-dst-size")
+	}
+	if *quotaFill && quota == 0 {
+		fatal("-quota-fill needs -max-dst-size")
+	}
+
+	if *networkSource {
+		*compare = "size"
+		if *reconcileEvery == 0 {
+			*reconcileEvery = 30
+		}
+	}
+	comparer, err := newComparer(*compare, *reconcileEvery, compareRules)
+	if err != nil {
+		fatal(err)
+	}
+	uploadPriority, err := sync.ParsePriority(*priority)
+	if err != nil {
+		fatalf("-priority: %v", err)
+	}
+	contentTypeMode, err := sync.ParseContentTypeMode(*contentType)
+	if err != nil {
+		fatal(err)
+	}
+	compression, err := sync.ParseCompression(*compress)
+	if err != nil {
+		fatal(err)
+	}
+
+	if (*sse != "" || *sseKMSKeyID != "" || len(sseContext) > 0) && !strings.HasPrefix(*dstURL, "s3://") {
+		fatal("-sse, -sse-kms-key-id and -sse-context only apply to s3:// destinations")
+	}
+	if (*endpointURL != "" || *pathStyle || *tlsSkipVerify || *accelerate || *requesterPays) && !strings.HasPrefix(*dstURL, "s3://") {
+		fatal("-endpoint-url, -path-style, -tls-skip-verify, -accelerate and -requester-pays only apply to s3:// destinations")
+	}
+	if *accountID != "" {
+		if !strings.HasPrefix(*dstURL, "r2://") {
+			fatal("-account-id only applies to r2:// destinations")
+		}
+		if err := sync.CheckR2AccountID(*accountID); err != nil {
+			fatalf("-account-id: %v", err)
+		}
+	}
+	if *compat != "" {
+		if !strings.HasPrefix(*dstURL, "s3://") && !strings.HasPrefix(*dstURL, "r2://") {
+			fatal("-compat only applies to s3:// and r2:// destinations")
+		}
+		if _, err := sync.ParseS3Compat(*compat); err != nil {
+			fatalf("-compat: %v", err)
+		}
+	}
+	if (*profile != "" || *roleARN != "" || *externalID != "" || *roleSessionName != "") && !strings.HasPrefix(*dstURL, "s3://") {
+		fatal("-profile, -role-arn, -external-id and -role-session-name only apply to s3:// destinations")
+	}
+	if err := sync.CheckAssumeRole(*roleARN, *externalID, *roleSessionName); err != nil {
+		fatalf("-role-arn: %v", err)
+	}
+	if (*partSizeMB != 0 || *uploadConcurrency != 0) && !strings.HasPrefix(*dstURL, "s3://") && !strings.HasPrefix(*dstURL, "b2://") && !strings.HasPrefix(*dstURL, "r2://") {
+		fatal("-part-size-mb and -upload-concurrency only apply to s3://, b2:// and r2:// destinations")
+	}
+	if *leaveParts && !strings.HasPrefix(*dstURL, "s3://") && !strings.HasPrefix(*dstURL, "r2://") {
+		fatal("-leave-parts-on-error only applies to s3:// and r2:// destinations")
+	}
+	if *adaptiveConcurrency && !strings.HasPrefix(*dstURL, "s3://") && !strings.HasPrefix(*dstURL, "r2://") {
+		fatal("-adaptive-concurrency only applies to s3:// and r2:// destinations")
+	}
+	if *createBucket && !strings.HasPrefix(*dstURL, "s3://") {
+		fatal("-create-bucket only applies to s3:// destinations")
+	}
+	if *abortUploadsDays != 0 && !*createBucket {
+		fatal("-abort-uploads-after-days has no effect without -create-bucket")
+	}
+	if *abortUploadsDays < 0 {
+		fatal("-abort-uploads-after-days must not be negative")
+	}
+	if *listConcurrency != 0 && !strings.HasPrefix(*dstURL, "s3://") && !strings.HasPrefix(*dstURL, "r2://") {
+		fatal("-list-concurrency only applies to s3:// and r2:// destinations")
+	}
+	if *listConcurrency < 0 {
+		fatal("-list-concurrency must not be negative")
+	}
+	if *partSizeMB != 0 {
+		if err := sync.CheckS3PartSize(int64(*partSizeMB) << 20); err != nil {
+			fatalf("-part-size-mb: %v", err)
+		}
+	}
+	if *uploadConcurrency < 0 {
+		fatal("-upload-concurrency must not be negative")
+	}
+	if *walkConcurrency < 1 {
+		fatal("-walk-concurrency must be at least 1")
+	}
+	walkErrors, err := sync.ParseWalkErrorPolicy(*onWalkError)
+	if err != nil {
+		fatalf("-on-walk-error: %v", err)
+	}
+	if *statConcurrency < 1 {
+		fatal("-stat-concurrency must be at least 1")
+	}
+	tags, err := parseTags(tagFlags)
+	if err != nil {
+		fatal(err)
+	}
+	if tags != nil && !strings.HasPrefix(*dstURL, "s3://") {
+		fatal("-tag only applies to s3:// destinations")
+	}
+	metadata, err := parseMetadata(metadataFlags, *dstURL)
+	if err != nil {
+		fatal(err)
+	}
+	tiers, err := parseTiers(tierFlags, *dstURL)
+	if err != nil {
+		fatal(err)
+	}
+	objectLock, err := sync.ParseObjectLock(*lockMode, *lockRetain, *legalHold)
+	if err != nil {
+		fatal(err)
+	}
+	if objectLock != nil && !strings.HasPrefix(*dstURL, "s3://") {
+		fatal("-lock-mode, -lock-retain and -legal-hold only apply to s3:// destinations")
+	}
+	if objectLock != nil && *stageUploads {
+		fatal("-stage-uploads cannot be combined with -lock-mode or -legal-hold")
+	}
+	notify, err := sync.ParseNotifyEvents(notifyOn)
+	if err != nil {
+		fatalf("-notify-on: %v", err)
+	}
+	notify.Job, notify.Webhook, notify.Slack, notify.Changes = *notifyJob, *notifyWebhook, *notifySlack, *notifyChanges
+	if *notifySMTP != "" {
+		notify.Mail = &sync.Mail{Server: *notifySMTP, From: *notifyFrom, To: notifyTo}
+		if err := notify.Mail.Check(); err != nil {
+			fatalf("-notify-smtp: %v", err)
+		}
+	}
+	notifying := notify.Webhook != "" || notify.Slack != "" || notify.Mail != nil
+	if notifying && (*watch || *twoWay || *verify) {
+		fatal("notifications cannot be combined with -watch, -two-way or -verify")
+	}
+	if *notifyChanges < 0 {
+		fatal("-notify-changes must not be negative")
+	}
+
+	// On SIGINT or SIGTERM, stop cleanly: finish recording what was done.
+	// A second signal exits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	rawURL, err := withParams(*dstURL, map[string]string{
+		"region":               *region,
+		"account-id":           *accountID,
+		"profile":              *profile,
+		"role-arn":             *roleARN,
+		"external-id":          *externalID,
+		"role-session-name":    *roleSessionName,
+		"storage-class":        *storageClass,
+		"sse":                  *sse,
+		"sse-kms-key-id":       *sseKMSKeyID,
+		"sse-context":          strings.Join(sseContext, ","),
+		"endpoint-url":         *endpointURL,
+		"path-style":           boolParam(*pathStyle),
+		"compat":               *compat,
+		"tls-skip-verify":      boolParam(*tlsSkipVerify),
+		"accelerate":           boolParam(*accelerate),
+		"request-payer":        requestPayer(*requesterPays),
+		"part-size-mb":         intParam(*partSizeMB),
+		"upload-concurrency":   intParam(*uploadConcurrency),
+		"adaptive-concurrency": boolParam(*adaptiveConcurrency),
+		"leave-parts-on-error": boolParam(*leaveParts),
+		"list-concurrency":     intParam(*listConcurrency),
+	})
+	if err != nil {
+		fatalf("destination: %v", err)
+	}
+	dst, err := sync.Open(ctx, rawURL)
+	if err != nil {
+		fatalf("destination: %v", err)
+	}
+	if *createBucket && !*dryRun {
+		ensureBucket(ctx, dst, objectLock != nil, *abortUploadsDays)
+	}
+	cacheDsts := []string{rawURL}
+	if len(alsoDsts) > 0 {
+		targets := []sync.FanOutTarget{{Name: redactURL(*dstURL), Dst: dst}}
+		for _, u := range alsoDsts {
+			also, err := sync.Open(ctx, u)
+			if err != nil {
+				fatalf("-also-dst: %v", err)
+			}
+			targets = append(targets, sync.FanOutTarget{Name: redactURL(u), Dst: also})
+		}
+		dst = sync.NewFanOut(*parallelDsts, targets...)
+		// The caches vouch for what every destination holds.
+		cacheDsts = append(cacheDsts, alsoDsts...)
+	}
+
+	if *deleteExcluded && !*delete {
+		fatal("-delete-excluded has no effect without -delete")
+	}
+	if *deleteExcluded && *twoWay {
+		fatal("-delete-excluded cannot be combined with -two-way")
+	}
+	var trash *sync.Trash
+	if *deleteTo != "" {
+		if !*delete {
+			fatal("-delete-to has no effect without -delete")
+		}
+		trash = new(sync.Trash)
+		if *deleteTo != "trash" {
+			if trash.Dst, err = sync.Open(ctx, *deleteTo); err != nil {
+				fatalf("trash: %v", err)
+			}
+		}
+	}
+
+	opts := sync.Options{
+		Src:            srcs[0],
+		Dst:            dst,
+		Warnings:       os.Stderr,
+		DryRun:         *dryRun,
+		Delete:         *delete,
+		DeleteExcluded: *deleteExcluded,
+
+		Estimate: *estimate,
+		Itemize:  *itemize,
+
+		DeleteTo:  trash,
+		KeepGoing: *keepGoing,
+
+		PerFileTimeout: *fileTimeout,
+		StallTimeout:   *stallTimeout,
+
+		ExpireAfter: time.Duration(*expireAfterDays) * 24 * time.Hour,
+
+		ReportExtraneous: *reportExtraneous,
+
+		Incremental: *incremental,
+		FullEvery:   *fullEvery,
+		Snapshots:   *snapshots,
+
+		DetectRenames: *detectRenames,
+		DedupeUploads: *dedupe,
+		HardLinks:     *hardLinks,
+		KeepEmptyDirs: *keepEmptyDirs,
+
+		PreservePOSIX: *preservePOSIX,
+		Sparse:        *sparse,
+		Compression:   compression,
+		Checksums:     *checksums,
+		AppendUploads: *appendUploads,
+		ContentType:   contentTypeMode,
+		Tags:          tags,
+		Tiers:         tiers,
+		Metadata:      metadata,
+		ObjectLock:    objectLock,
+
+		VerifyAfterUpload: *verifyAfterUpload,
+		StageUploads:      *stageUploads,
+
+		MinSize:        filters.minSize,
+		MaxSize:        filters.maxSize,
+		ModifiedAfter:  filters.after,
+		ModifiedBefore: filters.before,
+
+		SkipInFlight:     *skipInFlight,
+		InFlightPatterns: slices.Concat(sync.DefaultInFlightPatterns, inFlightPatterns),
+		InFlightAge:      *inFlightAge,
+
+		Compare:       comparer,
+		ModTimeWindow: *mtimeWindow,
+		Keys:          keys,
+		UnicodeForm:   form,
+
+		CaseCollisions: casePolicy,
+
+		WalkConcurrency: *walkConcurrency,
+		OnWalkError:     walkErrors,
+		StatConcurrency: *statConcurrency,
+
+		MaxChangeRatio: *maxChange / 100,
+		Force:          *force,
+		MaxDstSize:     quota,
+		QuotaTrim:      quotaTrim,
+		QuotaFill:      *quotaFill,
+
+		Priority:          uploadPriority,
+		MaxDuration:       *maxDuration,
+		MaxTransfer:       transferBudget,
+		MaxRequests:       *maxRequests,
+		RequestsPerSecond: *requestRate,
+
+		Conflicts: conflictPolicy,
+
+		ReadOnly: *readOnly,
+		Isolate:  *isolate,
+		Breaker: &sync.BreakerOptions{
+			Retries:   *retries,
+			Threshold: *breakerThreshold,
+			Cooldown:  *breakerCooldown,
+		},
+
+		ScanSecrets:    *scanSecrets,
+		ConfirmSecrets: confirmSecrets,
+		Confirm:        confirmEach(*interactive),
+
+		SourceSnapshot: snapshotter,
+
+		Manifest:          *manifest || *signKey != "" || *snapshots || *manifestChecksums,
+		ManifestChecksums: *manifestChecksums,
+	}
+	if sources != nil {
+		opts.Src, opts.Sources = "", sources
+	}
+	if *filesFrom != "" {
+		if opts.FileList, err = readFileList(*filesFrom); err != nil {
+			fatalf("-files-from: %v", err)
+		}
+	}
+	if pr != nil {
+		pr.configure(&opts, human)
+	}
+	if *verbosity > 0 {
+		opts.Log = human
+		opts.Verbose = *verbosity - 1
+	}
+	if *output == "jsonl" {
+		enc := json.NewEncoder(os.Stdout)
+		opts.OnEvent = func(e sync.Event) { enc.Encode(e) }
+	}
+	if *preCmdFlag != "" {
+		opts.PreSync = preCmd(*preCmdFlag, src, *dstURL, *dryRun, human)
+	}
+	if *postCmdFlag != "" {
+		opts.PostSync = postCmd(*postCmdFlag, *dstURL, human)
+	}
+	if *metricsAddr != "" || *pushgateway != "" {
+		opts.Metrics = new(sync.Metrics)
+	}
+	if notifying {
+		opts.Notify = &notify
+	}
+	if *bundleThreshold > 0 {
+		opts.Bundle = &sync.BundleOptions{Threshold: *bundleThreshold << 10, MaxSize: *bundleSize << 20}
+	}
+	if *chunkThreshold > 0 {
+		opts.Chunk = &sync.ChunkOptions{Threshold: *chunkThreshold << 20, AvgSize: *chunkSize << 10}
+	}
+	if *skipUnchangedDirs {
+		path, err := cachePath("dirs", src, cacheDsts...)
+		if err != nil {
+			fatalf("directory cache: %v", err)
+		}
+		opts.DirCache = path
+	}
+	if !*noCache {
+		path, err := cachePath("state", src, cacheDsts...)
+		if err != nil {
+			fatalf("state cache: %v", err)
+		}
+		opts.StateCache = path
+		opts.Checkpoint = *checkpoint
+		if opts.HashCache, err = cachePath("hashes", src); err != nil {
+			fatalf("hash cache: %v", err)
+		}
+		opts.HashCacheVerify = *hashCacheVerify / 100
+	}
+	if *metaCacheAge > 0 {
+		if opts.MetaCache, err = cachePath("meta", "", cacheDsts...); err != nil {
+			fatalf("metadata cache: %v", err)
+		}
+		opts.MetaCacheMaxAge = *metaCacheAge
+	}
+	if opts.Journal, err = cachePath("journal", src, cacheDsts...); err != nil {
+		fatalf("journal: %v", err)
+	}
+	if opts.History, err = cachePath("history", src, cacheDsts...); err != nil {
+		fatalf("history: %v", err)
+	}
+	lock := &sync.RunLock{Remote: *remoteLock, StaleAfter: *lockStale, Force: *forceUnlock}
+	if lock.File, err = cachePath("lock", src, cacheDsts...); err != nil {
+		fatalf("lock file: %v", err)
+	}
+	opts.Lock = lock
+	touched, err := cachePath("touch", src, cacheDsts...)
+	if err != nil {
+		fatalf("touch list: %v", err)
+	}
+	if opts.Reupload, err = loadTouched(touched); err != nil {
+		fatalf("touch list: %v", err)
+	}
+	if *signKey != "" {
+		key, err := sync.LoadSigningKey(*signKey)
+		if err != nil {
+			fatalf("sign key: %v", err)
+		}
+		opts.SigningKey = key
+	}
+	if *verifyKey != "" {
+		key, err := sync.LoadVerifyKey(*verifyKey)
+		if err != nil {
+			fatalf("verify key: %v", err)
+		}
+		opts.VerifyKey = key
+	}
+
+	if *verify {
+		verifyDst(ctx, opts)
+		return
+	}
+	var total runTotal
+	if *twoWay {
+		total.follow(&opts)
+		err := sync.TwoWay(ctx, opts)
+		if err != nil {
+			log.Printf("two-way sync failed: %v", err)
+		}
+		os.Exit(exitStatus(&total.Summary, err))
+	}
+	if *watch {
+		if *metricsAddr != "" {
+			serveMetrics(*metricsAddr, opts.Metrics)
+		}
+		if *controlSocket != "" {
+			opts.Control = new(sync.Control)
+			serveControl(*controlSocket, opts.Control)
+		}
+		total.follow(&opts)
+		err := sync.Watch(ctx, opts, *debounce)
+		if err != nil {
+			log.Printf("watch failed: %v", err)
+		}
+		if *controlSocket != "" {
+			os.Remove(*controlSocket)
+		}
+		os.Exit(exitStatus(&total.Summary, err))
+	}
+
+	res, err := syncAndPush(ctx, opts, *pushgateway, *pushJob)
+	for _, f := range res.Failed {
+		log.Printf("failed: %v", f)
+	}
+	for _, d := range res.Destinations {
+		log.Printf("%s: %d uploaded, %d deleted, %d failed", d.Name, d.Uploaded, d.Deleted, len(d.Failed))
+	}
+	switch {
+	case errors.Is(err, sync.ErrRequestLimit), errors.Is(err, sync.ErrBudget):
+		log.Printf("stopped early: %v", err)
+	case errors.Is(err, sync.ErrCanceled):
+		log.Printf("sync %v; run again to finish", err)
+	case err != nil:
+		log.Printf("sync failed: %v", err)
+	case !*dryRun && opts.FileList == nil:
+		// A file list may leave out the files touched.
+		if err := clearTouched(touched, opts.Reupload); err != nil {
+			log.Printf("warning: touch list: %v", err)
+		}
+	}
+	code := exitStatus(&res.Summary, err)
+	printSummary(*summary, &res.Summary, err, code)
+	os.Exit(code)
+}
+
+// serveMetrics serves m at /metrics on addr in the background.
+// ensureBucket creates the bucket of dst, an S3 destination, for
+// -create-bucket if it does not exist, Object Lock enabled if lock is set.
+func ensureBucket(ctx context.Context, dst sync.Destination, lock bool, abortUploadsDays int) {
+	d := dst.(*sync.S3Destination)
+	setup := sync.BucketSetup{
+		Versioning:            true,
+		Encryption:            d.ServerSideEncryption,
+		KMSKeyID:              d.SSEKMSKeyID,
+		BlockPublicAccess:     true,
+		ObjectLock:            lock,
+		AbortUploadsAfterDays: abortUploadsDays,
+	}
+	if setup.Encryption == "" {
+		setup.Encryption = "AES256" // SSE-S3
+	}
+	created, err := d.EnsureBucket(ctx, setup)
+	if err != nil {
+		fatalf("-create-bucket: %v", err)
+	}
+	if created {
+		fmt.Printf("created bucket %s\n", d.Bucket())
+	}
+}
+
+func serveMetrics(addr string, m *sync.Metrics) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatalf("metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		log.Printf("metrics: %v", http.Serve(ln, mux))
+	}()
+}
+
+// syncAndPush runs sync.Sync and, if gateway is set, pushes the metrics of
+// the run to it, whether the run succeeded or not; dry runs are not pushed.
+// A failed push is only a warning.
+func syncAndPush(ctx context.Context, opts sync.Options, gateway, job string) (*sync.Result, error) {
+	res, err := sync.Sync(ctx, opts)
+	if gateway != "" && !opts.DryRun {
+		pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if perr := opts.Metrics.Push(pctx, gateway, job); perr != nil {
+			log.Printf("warning: pushgateway: %v", perr)
+		}
+	}
+	return res, err
+}
+
+// verifyDst runs sync.Verify, printing each difference, and exits non-zero
+// if there are any.
+func verifyDst(ctx context.Context, opts sync.Options) {
+	report, err := sync.Verify(ctx, opts)
+	if err != nil {
+		fatalf("verify failed: %v", err)
+	}
+	for _, m := range report.Mismatches {
+		fmt.Println(m)
+	}
+	for _, key := range report.Archived {
+		fmt.Printf("%s: archived, content not checked without a restore\n", key)
+	}
+	fmt.Printf("verified %d files: %d differences", report.Files, len(report.Mismatches))
+	if n := len(report.Archived); n > 0 {
+		fmt.Printf(", %d archived objects compared by metadata only", n)
+	}
+	fmt.Println()
+	if len(report.Mismatches) > 0 {
 		os.Exit(1)
 	}
+}
+
+func newComparer(mode string, reconcileEvery int, rules []string) (sync.Comparer, error) {
+	if mode == "always" {
+		return nil, errors.New("-compare always would upload every file on every run; use it in -compare-rule")
+	}
+	c, err := baseComparer(mode)
+	if err != nil {
+		return nil, fmt.Errorf("unknown -compare mode %q", mode)
+	}
+	if reconcileEvery > 0 {
+		c = sync.Reconciler{Base: c, Every: reconcileEvery}
+	}
+	if len(rules) == 0 {
+		return c, nil
+	}
+	p := sync.PatternComparer{Default: c}
+	for _, r := range rules {
+		pattern, mode, ok := strings.Cut(r, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("-compare-rule %q: want pattern=mode", r)
+		}
+		rc, err := baseComparer(mode)
+		if err != nil {
+			return nil, fmt.Errorf("-compare-rule %q: unknown mode %q", r, mode)
+		}
+		p.Rules = append(p.Rules, sync.CompareRule{Pattern: pattern, Compare: rc})
+	}
+	return p, nil
+}
+
+// baseComparer returns the Comparer for a -compare or -compare-rule mode.
+func baseComparer(mode string) (sync.Comparer, error) {
+	switch mode {
+	case "mtime":
+		return sync.ModTimeComparer{}, nil
+	case "size":
+		return sync.SizeComparer{}, nil
+	case "checksum":
+		return sync.ChecksumComparer{}, nil
+	case "etag":
+		return sync.ETagComparer{}, nil
+	case "always":
+		return sync.AlwaysUpload{}, nil
+	}
+	return nil, fmt.Errorf("unknown mode %q", mode)
+}
+
+// fileFilters are the parsed -min-size, -max-size, -modified-after and
+// -modified-before flags.
+type fileFilters struct {
+	minSize, maxSize int64
+	after, before    time.Time
+	set              bool // any of them was given
+}
+
+func parseFilters(minSize, maxSize, after, before string) (fileFilters, error) {
+	var f fileFilters
+	var err error
+	if minSize != "" {
+		if f.minSize, err = sync.ParseSize(minSize); err != nil {
+			return f, fmt.Errorf("-min-size: %w", err)
+		}
+	}
+	if maxSize != "" {
+		if f.maxSize, err = sync.ParseSize(maxSize); err != nil {
+			return f, fmt.Errorf("-max-size: %w", err)
+		}
+	}
+	now := time.Now()
+	if after != "" {
+		if f.after, err = sync.ParseModTime(after, now); err != nil {
+			return f, fmt.Errorf("-modified-after: %w", err)
+		}
+	}
+	if before != "" {
+		if f.before, err = sync.ParseModTime(before, now); err != nil {
+			return f, fmt.Errorf("-modified-before: %w", err)
+		}
+	}
+	f.set = minSize != "" || maxSize != "" || after != "" || before != ""
+	return f, nil
+}
+
+// parseKeyLayout returns the KeyMapper for -key-layout, reading the name
+// key of the encrypted layout from nameKeyFile.
+func parseKeyLayout(layout, nameKeyFile string) (sync.KeyMapper, error) {
+	if layout != "encrypted" {
+		if nameKeyFile != "" {
+			return nil, errors.New("-name-key-file only applies to -key-layout encrypted")
+		}
+		return sync.ParseKeyMapper(layout)
+	}
+	if nameKeyFile == "" {
+		return nil, errors.New("-key-layout encrypted needs -name-key-file")
+	}
+	secret, err := sync.LoadNameKey(nameKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return sync.NewEncryptedKeys(secret)
+}
+
+// shapeKeys wraps keys in a sync.ShapedKeys for -strip-components,
+// -key-template and -lowercase-keys, if any of them is set.
+func shapeKeys(keys sync.KeyMapper, strip int, template string, lowercase bool) (sync.KeyMapper, error) {
+	if strip < 0 {
+		return nil, errors.New("-strip-components must not be negative")
+	}
+	if template != "" {
+		if err := sync.CheckKeyTemplate(template); err != nil {
+			return nil, fmt.Errorf("-key-template: %w", err)
+		}
+	}
+	if strip == 0 && template == "" && !lowercase {
+		return keys, nil
+	}
+	return sync.ShapedKeys{Layout: keys, StripComponents: strip, Template: template, Lowercase: lowercase}, nil
+}
+
+// parseTags parses -tag flags of the form key=value.
+func parseTags(flags []string) (map[string]string, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(flags))
+	for _, f := range flags {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("-tag %q: want key=value", f)
+		}
+		if _, dup := tags[k]; dup {
+			return nil, fmt.Errorf("-tag %q: tag %q given twice", f, k)
+		}
+		tags[k] = v
+	}
+	if err := sync.CheckS3Tags(tags); err != nil {
+		return nil, fmt.Errorf("-tag: %w", err)
+	}
+	return tags, nil
+}
+
+// parseTiers parses -tier flags of the form [pattern=]class:age, checking
+// each class against the destination's.
+func parseTiers(flags []string, dstURL string) ([]sync.StorageTier, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	scheme, _, _ := strings.Cut(dstURL, "://")
+	if scheme != "s3" && scheme != "gs" {
+		return nil, errors.New("-tier only applies to s3:// and gs:// destinations")
+	}
+	tiers := make([]sync.StorageTier, 0, len(flags))
+	for _, f := range flags {
+		t, err := sync.ParseStorageTier(f)
+		if err != nil {
+			return nil, fmt.Errorf("-%w", err)
+		}
+		if err := sync.CheckStorageClass(scheme, t.Class); err != nil {
+			return nil, fmt.Errorf("-tier %q: %w", f, err)
+		}
+		tiers = append(tiers, t)
+	}
+	return tiers, nil
+}
+
+// parseMetadata parses -metadata flags of the form pattern:name=value.
+func parseMetadata(flags []string, dstURL string) ([]sync.MetadataRule, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	if !strings.HasPrefix(dstURL, "s3://") && !strings.HasPrefix(dstURL, "gs://") {
+		return nil, errors.New("-metadata only applies to s3:// and gs:// destinations")
+	}
+	rules, err := sync.ParseMetadataRules(flags)
+	if err != nil {
+		return nil, fmt.Errorf("-%w", err)
+	}
+	return rules, nil
+}
+
+// stringsFlag is a flag that may be repeated, collecting each value.
+type stringsFlag []string
 
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(*region))
+func (f *stringsFlag) String() string { return strings.Join(*f, ", ") }
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// cachePath returns the path of a local cache file of the given kind for
+// syncing src to the destination URLs dsts, under the user's cache
+// directory. Query parameters of dsts are ignored: they select settings
+// such as the region or storage class, not a different destination. An
+// empty src gives a cache of the destinations alone, shared by every
+// source.
+func cachePath(kind, src string, dsts ...string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	var abs string
+	if src != "" {
+		if abs, err = filepath.Abs(src); err != nil {
+			return "", err
+		}
+	}
+	key := abs
+	for _, dst := range dsts {
+		u, err := url.Parse(dst)
+		if err != nil {
+			return "", err
+		}
+		u.RawQuery = ""
+		key += "\n" + u.String()
+	}
+	sum := sha256.Sum256([]byte(key))
+	name := fmt.Sprintf("%s-%s.json", kind, hex.EncodeToString(sum[:8]))
+	return filepath.Join(dir, "foldersync", name), nil
+}
+
+// parseSources parses repeated -src flags, each a directory or
+// prefix=dir, into sync.Options.Sources. A directory without a prefix is
+// stored under its base name. A single -src is a plain directory, for
+// which it returns nil.
+func parseSources(flags []string) ([]sync.SourceSpec, error) {
+	if len(flags) < 2 {
+		return nil, nil
+	}
+	var sources []sync.SourceSpec
+	for _, f := range flags {
+		prefix, dir, ok := strings.Cut(f, "=")
+		if !ok {
+			dir, prefix = f, filepath.Base(filepath.Clean(f))
+		}
+		if dir == "" || prefix == "" || prefix == "." || prefix == string(filepath.Separator) {
+			return nil, fmt.Errorf("-src %q: want dir or prefix=dir", f)
+		}
+		sources = append(sources, sync.SourceSpec{Dir: dir, Prefix: prefix})
+	}
+	return sources, nil
+}
+
+// sourcesLabel names a set of sources for cachePath: the absolute
+// directories with their prefixes.
+func sourcesLabel(sources []sync.SourceSpec) string {
+	parts := make([]string, len(sources))
+	for i, s := range sources {
+		dir, err := filepath.Abs(s.Dir)
+		if err != nil {
+			dir = s.Dir
+		}
+		parts[i] = dir + "=" + strings.Trim(s.Prefix, "/")
+	}
+	return strings.Join(parts, ",")
+}
+
+// withParams adds the non-empty values in params to the query of rawURL.
+// Parameters already present in the URL take precedence over flags.
+func withParams(rawURL string, params map[string]string) (string, error) {
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		log.Fatalf("load AWS config: %v", err)
+		return "", err
+	}
+	q := u.Query()
+	for k, v := range params {
+		if v != "" && !q.Has(k) {
+			q.Set(k, v)
+		}
 	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
 
-	dst := sync.NewS3Destination(
-		s3.NewFromConfig(cfg),
-		*bucket,
-		*prefix,
-		types.StorageClass(*storageClass),
-	)
+// boolParam formats on as a URL parameter for withParams, which leaves it
+// out if false.
+func boolParam(on bool) string {
+	if on {
+		return "true"
+	}
+	return ""
+}
 
-	if err := sync.Sync(ctx, sync.Options{
-		Src:    *src,
-		Dst:    dst,
-		DryRun: *dryRun,
-		Delete: *delete,
-	}); err != nil {
-		log.Fatalf("sync failed: %v", err)
+// requestPayer returns the request-payer parameter of an s3:// URL for the
+// -requester-pays flag.
+func requestPayer(on bool) string {
+	if on {
+		return "requester"
+	}
+	return ""
+}
+
+// intParam returns n as a URL parameter value, empty (leaving it out) if
+// it is zero.
+func intParam(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// stdin reads the answers to prompts, which are one to a line.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readFileList reads the -files-from list in the file at name, or stdin if
+// name is "-".
+func readFileList(name string) ([]string, error) {
+	if name == "-" {
+		return sync.ReadFileList(stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return sync.ReadFileList(f)
+}
+
+// errQuit is returned by the -interactive prompt when asked to quit.
+var errQuit = errors.New("quit at the prompt; nothing was changed")
+
+// confirmEach returns a sync.Options.Confirm that asks about each change
+// on the terminal, or nil unless interactive is set. "a" confirms the rest
+// without asking, and "q" or the end of the input stops the run.
+func confirmEach(interactive bool) func(sync.Event) (bool, error) {
+	if !interactive {
+		return nil
+	}
+	all := false
+	return func(e sync.Event) (bool, error) {
+		if all {
+			return true, nil
+		}
+		for {
+			fmt.Fprintf(os.Stderr, "%s? [y/n/a/q] ", e)
+			answer, err := stdin.ReadString('\n')
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "y", "yes":
+				return true, nil
+			case "n", "no":
+				return false, nil
+			case "a", "all":
+				all = true
+				return true, nil
+			case "q", "quit":
+				return false, errQuit
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr)
+				return false, errQuit
+			}
+			fmt.Fprintln(os.Stderr, "answer y, n, a or q")
+		}
+	}
+}
+
+// confirmSecrets lists the files flagged by the secret scanner and asks
+// whether to upload them anyway. Anything but "y" or "yes" excludes them.
+func confirmSecrets(matches []sync.SecretMatch) bool {
+	fmt.Fprintln(os.Stderr, "the following files look like they contain secrets:")
+	for _, m := range matches {
+		fmt.Fprintf(os.Stderr, "  %s (%s)\n", m.Key, m.Reason)
+	}
+	fmt.Fprint(os.Stderr, "upload them anyway? [y/N] ")
+
+	answer, _ := stdin.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// preCmd returns a sync.Options.PreSync hook that runs cmd with sh -c. The
+// command sees FOLDERSYNC_SRC, FOLDERSYNC_DST and FOLDERSYNC_DRY_RUN in its
+// environment. Its output goes to out and os.Stderr.
+func preCmd(cmd, src, dst string, dryRun bool, out io.Writer) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return runHookCmd(ctx, cmd, hookEnv(src, dst, dryRun), out)
+	}
+}
+
+// postCmd returns a sync.Options.PostSync hook that runs cmd with sh -c,
+// passing the summary of the run in its environment as well.
+func postCmd(cmd, dst string, out io.Writer) func(context.Context, sync.Summary) error {
+	return func(ctx context.Context, s sync.Summary) error {
+		status, msg := s.Status(), ""
+		if s.Err != nil {
+			msg = s.Err.Error()
+		}
+		env := append(hookEnv(s.Src, dst, s.DryRun),
+			"FOLDERSYNC_STATUS="+status,
+			"FOLDERSYNC_ERROR="+msg,
+			fmt.Sprintf("FOLDERSYNC_FILES=%d", s.Files),
+			fmt.Sprintf("FOLDERSYNC_UPLOADS=%d", s.Uploads),
+			fmt.Sprintf("FOLDERSYNC_UPLOADED=%d", s.Uploaded),
+			fmt.Sprintf("FOLDERSYNC_DELETES=%d", s.Deletes),
+			fmt.Sprintf("FOLDERSYNC_DELETED=%d", s.Deleted),
+			fmt.Sprintf("FOLDERSYNC_DURATION=%d", int(s.Duration.Seconds())),
+		)
+		return runHookCmd(ctx, cmd, env, out)
+	}
+}
+
+func hookEnv(src, dst string, dryRun bool) []string {
+	return []string{
+		"FOLDERSYNC_SRC=" + src,
+		"FOLDERSYNC_DST=" + dst,
+		fmt.Sprintf("FOLDERSYNC_DRY_RUN=%t", dryRun),
+	}
+}
+
+// runHookCmd runs cmd with sh -c and env added to the environment, passing
+// its standard output to out and its standard error through.
+func runHookCmd(ctx context.Context, cmd string, env []string, out io.Writer) error {
+	c := exec.CommandContext(ctx, "sh", "-c", cmd)
+	c.Env = append(os.Environ(), env...)
+	c.Stdout, c.Stderr = out, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%q: %w", cmd, err)
+	}
+	return nil
+}
+
+// redactURL returns rawURL with any password in it masked, to name a
+// destination in messages.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// prefixVars returns the values of the variables in destination prefixes,
+// for a run starting now with the AWS profile profile.
+func prefixVars(profile string) sync.PrefixVars {
+	host, _ := os.Hostname()
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
 	}
+	return sync.PrefixVars{Hostname: host, Profile: profile, Time: time.Now()}
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runMigratePrefix implements
+// "foldersync migrate-prefix -dst <url> -from <prefix> -to <prefix>".
+func runMigratePrefix(args []string) int {
+	fs := flag.NewFlagSet("migrate-prefix", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL holding both prefixes, e.g. s3://bucket (required)")
+	from := fs.String("from", "", "key prefix to move objects from (required)")
+	to := fs.String("to", "", "key prefix to move objects to (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	storageClass := fs.String("storage-class", "", "storage class of the copies (default: as for sync)")
+	sse := fs.String("sse", "", "S3 server-side encryption of the copies: AES256 or aws:kms (default: as the originals)")
+	sseKMSKeyID := fs.String("sse-kms-key-id", "", "KMS key ID or ARN to encrypt the copies with; implies -sse aws:kms")
+	var sseContext stringsFlag
+	fs.Var(&sseContext, "sse-context", "SSE-KMS encryption context pair of the copies, as key=value (repeatable; default: as the originals); implies -sse aws:kms")
+	deleteOld := fs.Bool("delete", false, "delete the originals once everything is copied")
+	dryRun := fs.Bool("dry-run", false, "print actions without making changes")
+	signKey := fs.String("sign-key", "", "Ed25519 private key (PKCS #8 PEM) to re-sign the manifest with")
+	verifyKey := fs.String("verify-key", "", "Ed25519 public key (PEM) the manifest at the destination must be signed with before anything is moved")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync migrate-prefix -dst <url> -from <prefix> -to <prefix> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || *from == "" || *to == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if (*sse != "" || *sseKMSKeyID != "" || len(sseContext) > 0) && !strings.HasPrefix(*dstURL, "s3://") {
+		fmt.Fprintln(os.Stderr, "-sse, -sse-kms-key-id and -sse-context only apply to s3:// destinations")
+		return 2
+	}
+
+	ctx := context.Background()
+	rawURL, err := withParams(*dstURL, map[string]string{
+		"region":         *region,
+		"storage-class":  *storageClass,
+		"sse":            *sse,
+		"sse-kms-key-id": *sseKMSKeyID,
+		"sse-context":    strings.Join(sseContext, ","),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	dst, err := sync.Open(ctx, rawURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+
+	opts := sync.MigrateOptions{
+		Dst:    dst,
+		From:   *from,
+		To:     *to,
+		Delete: *deleteOld,
+		DryRun: *dryRun,
+		Log:    os.Stdout,
+	}
+	if *signKey != "" {
+		key, err := sync.LoadSigningKey(*signKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sign key: %v\n", err)
+			return 1
+		}
+		opts.SigningKey = key
+	}
+	if *verifyKey != "" {
+		if opts.VerifyKey, err = sync.LoadVerifyKey(*verifyKey); err != nil {
+			fmt.Fprintf(os.Stderr, "verify key: %v\n", err)
+			return 1
+		}
+	}
+
+	if err := sync.MigratePrefix(ctx, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate-prefix failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
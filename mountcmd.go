@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runMount implements "foldersync mount -dst <url> <dir>", which serves the
+// backup as a read-only file system at dir until interrupted or unmounted.
+func runMount(args []string) int {
+	fs := flag.NewFlagSet("mount", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL to mount (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	keyLayout := fs.String("key-layout", "identity", "the -key-layout the backup was made with")
+	nameKeyFile := fs.String("name-key-file", "", "the -name-key-file of the -key-layout encrypted backup")
+	requesterPays := fs.Bool("requester-pays", false, "pay for the requests to, and downloads from, a Requester Pays S3 bucket")
+	snapshot := fs.String("snapshot", "", "show the files as of this snapshot, as listed by 'restore snapshots', instead of as they are now")
+	asOf := fs.String("as-of", "", "show the files as of the last snapshot made at or before this time, as a date, an RFC 3339 time or an age such as 7d")
+	var paths stringsFlag
+	fs.Var(&paths, "path", "show only the files matching this glob pattern, or under this directory, such as \"photos/2023/**\" (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync mount -dst <url> [options] <dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+	keys, err := parseKeyLayout(*keyLayout, *nameKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "key layout: %v\n", err)
+		return 2
+	}
+	var at time.Time
+	if *asOf != "" {
+		if *snapshot != "" {
+			fmt.Fprintln(os.Stderr, "-as-of and -snapshot cannot be combined")
+			return 2
+		}
+		if at, err = sync.ParseModTime(*asOf, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "-as-of: %v\n", err)
+			return 2
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rawURL, err := withParams(*dstURL, map[string]string{"request-payer": requestPayer(*requesterPays)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	dst, err := openRestoreDst(ctx, rawURL, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	fsys, err := sync.OpenBackupFS(ctx, sync.RestoreOptions{
+		From:     dst,
+		Keys:     keys,
+		Snapshot: *snapshot,
+		AsOf:     at,
+		Paths:    paths,
+		Warnings: os.Stderr,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup: %v\n", err)
+		return 1
+	}
+	fmt.Printf("mounted %s at %s; press Ctrl-C or run 'fusermount -u %s' to unmount\n", *dstURL, dir, dir)
+	if err := sync.Mount(ctx, fsys, dir, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "mount failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
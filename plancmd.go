@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// planFile is the file "foldersync plan" writes and "foldersync apply"
+// reads: the plan, with the flags of the run that made it, which the run
+// applying it takes.
+type planFile struct {
+	Args []string `json:"args"`
+	// Redacted names the flags whose secrets were masked in Args, which
+	// apply must be given again.
+	Redacted []string `json:"redacted,omitempty"`
+	*sync.SavedPlan
+}
+
+// secretFlags are the flags whose values are secrets: the tokens of webhook
+// URLs, and the external ID of a role. urlFlags are those whose URLs may
+// hold a password, or an external-id parameter.
+var (
+	secretFlags = []string{"external-id", "notify-webhook", "notify-slack"}
+	urlFlags    = []string{"dst", "also-dst", "endpoint-url", "pushgateway", "notify-smtp"}
+)
+
+// redactArgs returns args, parsed as flags of the command line, with the
+// values of secretFlags and the passwords and external IDs in urlFlags
+// masked, and the names of the flags it masked.
+func redactArgs(args []string) (out, redacted []string) {
+	out = slices.Clone(args)
+	for i := 0; i < len(out); i++ {
+		a := out[i]
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			break
+		}
+		n, v, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		at := -1 // the index of the value in out, if it is separate
+		if !hasValue && takesValue(n) && i+1 < len(out) {
+			at, v = i+1, out[i+1]
+		}
+		masked := v
+		switch {
+		case slices.Contains(secretFlags, n) && v != "":
+			masked = "xxxxx"
+		case slices.Contains(urlFlags, n):
+			masked = redactSecretURL(v)
+		}
+		if masked != v {
+			if at < 0 {
+				out[i] = a[:len(a)-len(v)] + masked
+			} else {
+				out[at] = masked
+			}
+			if !slices.Contains(redacted, n) {
+				redacted = append(redacted, n)
+			}
+		}
+		if at >= 0 {
+			i++
+		}
+	}
+	return out, redacted
+}
+
+// redactSecretURL returns rawURL with any password and external ID in it
+// masked.
+func redactSecretURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	if q.Get("external-id") == "" {
+		return redactURL(rawURL)
+	}
+	q.Set("external-id", "xxxxx")
+	u.RawQuery = q.Encode()
+	return u.Redacted()
+}
+
+// takesValue reports whether the flag of the command line named name takes
+// a value, rather than being a boolean.
+func takesValue(name string) bool {
+	f := flag.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// planRun is what a run of "foldersync plan" or "foldersync apply" does
+// other than sync.
+type planRun struct {
+	out   string    // plan: the file to write the plan to
+	args  []string  // plan: the flags of the run, recorded in the plan; apply: the flags given again
+	apply *planFile // apply: the plan to apply
+}
+
+// applyArgs returns the flags to apply pr.apply with: those recorded in
+// the plan, with the ones given again in pr.args in place of theirs. It
+// fails if a flag whose secrets the plan leaves out is not given again.
+func (pr *planRun) applyArgs() ([]string, error) {
+	given := flagNames(pr.args)
+	var missing []string
+	for _, n := range pr.apply.Redacted {
+		if !slices.Contains(given, n) {
+			missing = append(missing, "-"+n)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the plan leaves out the secrets of %s; give them again after -plan", strings.Join(missing, ", "))
+	}
+	args := pr.apply.Args
+	for _, n := range given {
+		args = withoutFlag(args, n)
+	}
+	return append(args, pr.args...), nil
+}
+
+// configure sets opts to save the plan to pr.out or to apply pr.apply.
+func (pr *planRun) configure(opts *sync.Options, human io.Writer) {
+	if pr.apply != nil {
+		opts.ApplyPlan = pr.apply.SavedPlan
+		return
+	}
+	opts.SavePlan = func(p *sync.SavedPlan) error {
+		args, redacted := redactArgs(pr.args)
+		data, err := json.MarshalIndent(planFile{Args: args, Redacted: redacted, SavedPlan: p}, "", "  ")
+		if err != nil {
+			return err
+		}
+		// The plan names every file and the destination, so it is kept
+		// as private as the state cache.
+		if err := os.WriteFile(pr.out, append(data, '\n'), 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(human, "plan of %d uploads and %d deletes written to %s; run foldersync apply -plan %s to apply it\n",
+			len(p.Uploads), len(p.Deletes), pr.out, pr.out)
+		return nil
+	}
+}
+
+// runApply implements "foldersync apply -plan <file>", which does exactly
+// what a plan written by "foldersync plan" says, with the flags of the run
+// that made it, failing without changing anything if a file it uploads has
+// changed since. Flags after the plan replace those recorded, and must
+// include those whose secrets the plan leaves out.
+func runApply(args []string) int {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	path := fs.String("plan", "", "plan file written by foldersync plan (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync apply -plan <file> [flags to give again]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *path == "" {
+		fs.Usage()
+		return 2
+	}
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var p planFile
+	if err := json.Unmarshal(data, &p); err != nil || p.SavedPlan == nil || len(p.Args) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: not a plan written by foldersync plan\n", *path)
+		return 1
+	}
+	runSync(nil, &planRun{apply: &p, args: fs.Args()})
+	return 0 // not reached: runSync exits
+}
+
+// withoutFlag returns args, parsed as flags of the command line, without
+// the flag named.
+func withoutFlag(args []string, name string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			return append(out, args[i:]...)
+		}
+		n, _, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		value := !hasValue && takesValue(n)
+		if n != name {
+			out = append(out, a)
+			if value && i+1 < len(args) {
+				out = append(out, args[i+1])
+			}
+		}
+		if value {
+			i++
+		}
+	}
+	return out
+}
+
+// flagNames returns the names of the flags in args, parsed as flags of the
+// command line, each once.
+func flagNames(args []string) []string {
+	var names []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			break
+		}
+		n, _, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !slices.Contains(names, n) {
+			names = append(names, n)
+		}
+		if !hasValue && takesValue(n) {
+			i++
+		}
+	}
+	return names
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runPurge implements "foldersync purge -dst <url> -older-than <age>".
+func runPurge(args []string) int {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL holding the trash, as given to -delete-to or, for \"trash\", -dst (required)")
+	olderThan := fs.String("older-than", "", "purge objects moved to the trash longer ago than this, e.g. 30d or 12h (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	dryRun := fs.Bool("dry-run", false, "print the objects that would be purged without deleting them")
+	verifyKey := fs.String("verify-key", "", "Ed25519 public key (PEM) the manifest at the destination must be signed with before anything is purged")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync purge -dst <url> -older-than <age> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || *olderThan == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	age, err := sync.ParseAge(*olderThan)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-older-than: %v\n", err)
+		return 2
+	}
+	opts := sync.PurgeTrashOptions{OlderThan: age, DryRun: *dryRun}
+	if *verifyKey != "" {
+		if opts.VerifyKey, err = sync.LoadVerifyKey(*verifyKey); err != nil {
+			fmt.Fprintf(os.Stderr, "verify key: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+	rawURL, err := withParams(*dstURL, map[string]string{"region": *region})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	dst, err := sync.Open(ctx, rawURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+
+	purged, err := sync.PurgeTrash(ctx, dst, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "purge failed: %v\n", err)
+		return 1
+	}
+	for _, key := range purged {
+		fmt.Printf("purge %s\n", key)
+	}
+	if *dryRun {
+		fmt.Printf("%d object(s) would be purged from the trash\n", len(purged))
+	} else {
+		fmt.Printf("%d object(s) purged from the trash\n", len(purged))
+	}
+	return 0
+}
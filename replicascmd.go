@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runVerifyReplicas implements "foldersync verify-replicas -a <url> -b <url>",
+// which compares two destinations holding copies of the same backup.
+func runVerifyReplicas(args []string) int {
+	fs := flag.NewFlagSet("verify-replicas", flag.ExitOnError)
+	aURL := fs.String("a", "", "URL of one replica, e.g. s3://bucket-east/photos?region=us-east-1 (required)")
+	bURL := fs.String("b", "", "URL of the other replica (required)")
+	checksum := fs.Bool("checksum", false, "also compare the content of objects whose size and mtime match, downloading both copies")
+	heal := fs.Bool("heal", false, "copy objects missing or stale at one replica from the other")
+	dryRun := fs.Bool("dry-run", false, "with -heal, print copies without making them")
+	verifyKey := fs.String("verify-key", "", "Ed25519 public key (PEM) the manifests must be signed with")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync verify-replicas -a <url> -b <url> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *aURL == "" || *bURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *dryRun && !*heal {
+		fmt.Fprintln(os.Stderr, "-dry-run only applies with -heal")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := sync.ReplicaOptions{Checksum: *checksum, Heal: *heal, DryRun: *dryRun, Log: os.Stdout}
+	var err error
+	if opts.A, err = sync.Open(ctx, *aURL); err != nil {
+		fmt.Fprintf(os.Stderr, "a: %v\n", err)
+		return 1
+	}
+	if opts.B, err = sync.Open(ctx, *bURL); err != nil {
+		fmt.Fprintf(os.Stderr, "b: %v\n", err)
+		return 1
+	}
+	if *verifyKey != "" {
+		if opts.VerifyKey, err = sync.LoadVerifyKey(*verifyKey); err != nil {
+			fmt.Fprintf(os.Stderr, "verify key: %v\n", err)
+			return 1
+		}
+	}
+
+	report, err := sync.VerifyReplicas(ctx, opts)
+	if report != nil {
+		for _, m := range report.Mismatches {
+			fmt.Println(m)
+		}
+		fmt.Printf("compared %d objects: %d differences, %d healed\n", report.Objects, len(report.Mismatches), report.Healed)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify-replicas failed: %v\n", err)
+		return 1
+	}
+	if len(report.Mismatches) > report.Healed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// runRestore implements "foldersync restore -dst <url> -to <dir>",
+// "foldersync restore status -dst <url>", "foldersync restore snapshots
+// -dst <url>" and "foldersync restore queue".
+func runRestore(args []string) int {
+	if len(args) > 0 && args[0] == "status" {
+		return runRestoreStatus(args[1:])
+	}
+	if len(args) > 0 && args[0] == "snapshots" {
+		return runRestoreSnapshots(args[1:])
+	}
+	if len(args) > 0 && args[0] == "queue" {
+		return runRestoreQueue(args[1:])
+	}
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL to restore from (required)")
+	to := fs.String("to", "", "directory to restore into (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	dryRun := fs.Bool("dry-run", false, "print actions without making changes")
+	tier := fs.String("tier", "Standard", "retrieval tier for archived objects: Bulk, Standard or Expedited")
+	days := fs.Int("days", 7, "days to keep restored copies of archived objects")
+	batch := fs.Int("batch", 0, "restore at most this many archived objects at a time (0 = all at once)")
+	poll := fs.Duration("poll", 15*time.Minute, "how often to check on restores of archived objects")
+	keyLayout := fs.String("key-layout", "identity", "the -key-layout the backup was made with")
+	nameKeyFile := fs.String("name-key-file", "", "the -name-key-file of the -key-layout encrypted backup")
+	noWait := fs.Bool("no-wait", false, "request restores of archived objects and exit without waiting for them")
+	var sseContext stringsFlag
+	fs.Var(&sseContext, "sse-context", "check that every object was encrypted with this SSE-KMS encryption context pair, as key=value (repeatable)")
+	requesterPays := fs.Bool("requester-pays", false, "pay for the requests to, and downloads from, a Requester Pays S3 bucket")
+	accelerate := fs.Bool("accelerate", false, "download through S3 Transfer Acceleration, which the bucket must have enabled")
+	snapshot := fs.String("snapshot", "", "restore the files as of this snapshot, as listed by 'restore snapshots', instead of as they are now")
+	asOf := fs.String("as-of", "", "restore the files as of the last snapshot made at or before this time, as a date, an RFC 3339 time or an age such as 7d")
+	var paths stringsFlag
+	fs.Var(&paths, "path", "restore only the files matching this glob pattern, or under this directory, such as \"photos/2023/**\" (repeatable)")
+	overwrite := fs.String("overwrite", "always", "what to do with files already in the directory: always, never or if-newer")
+	posix := fs.String("posix", "best-effort",
+		"what to do with recorded ownership and extended attributes the restore may not set, as when not run as root: best-effort (set the rest and warn), strict (fail) or ignore (set only permissions)")
+	ownershipScript := fs.String("ownership-script", "", "with -posix best-effort, write a shell script to this file that sets what could not be, when run as root")
+	concurrency := fs.Int("concurrency", 4, "download this many files at once")
+	partSizeMB := fs.Int("part-size-mb", 64, "download objects larger than this many MiB in ranged parts, resuming interrupted downloads from the parts already in (0 = whole objects)")
+	partConcurrency := fs.Int("part-concurrency", sync.DefaultPartConcurrency, "download this many parts of an object at once")
+	bandwidth := fs.String("bandwidth-limit", "", "limit downloads to this many bytes a second, such as 10MB (default no limit)")
+	verifyKey := fs.String("verify-key", "", "Ed25519 public key (PEM) the manifest at the destination must be signed with before anything is restored")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync restore -dst <url> -to <dir> [options]")
+		fmt.Fprintln(os.Stderr, "       foldersync restore status -dst <url> [-v]")
+		fmt.Fprintln(os.Stderr, "       foldersync restore snapshots -dst <url>")
+		fmt.Fprintln(os.Stderr, "       foldersync restore queue add|list|run -dst <url> ...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || *to == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	t, err := sync.ParseRestoreTier(*tier)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tier: %v\n", err)
+		return 2
+	}
+	keys, err := parseKeyLayout(*keyLayout, *nameKeyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "key layout: %v\n", err)
+		return 2
+	}
+	ec, err := sync.ParseEncryptionContext(strings.Join(sseContext, ","))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-sse-context: %v\n", err)
+		return 2
+	}
+	policy, err := sync.ParseOverwritePolicy(*overwrite)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-overwrite: %v\n", err)
+		return 2
+	}
+	posixPolicy, err := sync.ParsePOSIXPolicy(*posix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-posix: %v\n", err)
+		return 2
+	}
+	if *ownershipScript != "" && posixPolicy != sync.POSIXBestEffort {
+		fmt.Fprintln(os.Stderr, "-ownership-script needs -posix best-effort")
+		return 2
+	}
+	var at time.Time
+	if *asOf != "" {
+		if *snapshot != "" {
+			fmt.Fprintln(os.Stderr, "-as-of and -snapshot cannot be combined")
+			return 2
+		}
+		if at, err = sync.ParseModTime(*asOf, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "-as-of: %v\n", err)
+			return 2
+		}
+	}
+	if *days < 1 || *batch < 0 || *poll <= 0 {
+		fmt.Fprintln(os.Stderr, "-days and -poll must be positive and -batch must not be negative")
+		return 2
+	}
+	if *concurrency < 1 || *partConcurrency < 1 || *partSizeMB < 0 {
+		fmt.Fprintln(os.Stderr, "-concurrency and -part-concurrency must be positive and -part-size-mb must not be negative")
+		return 2
+	}
+	var bytesPerSecond int64
+	if *bandwidth != "" {
+		if bytesPerSecond, err = sync.ParseSize(*bandwidth); err != nil || bytesPerSecond <= 0 {
+			fmt.Fprintf(os.Stderr, "-bandwidth-limit: want a positive size such as 10MB\n")
+			return 2
+		}
+	}
+	var pub ed25519.PublicKey
+	if *verifyKey != "" {
+		if pub, err = sync.LoadVerifyKey(*verifyKey); err != nil {
+			fmt.Fprintf(os.Stderr, "verify key: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rawURL, err := withParams(*dstURL, map[string]string{
+		"request-payer": requestPayer(*requesterPays),
+		"accelerate":    boolParam(*accelerate),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	dst, err := openRestoreDst(ctx, rawURL, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+
+	err = sync.Restore(ctx, sync.RestoreOptions{
+		From:         dst,
+		To:           *to,
+		DryRun:       *dryRun,
+		Log:          os.Stdout,
+		Warnings:     os.Stderr,
+		Tier:         t,
+		Days:         *days,
+		BatchSize:    *batch,
+		PollInterval: *poll,
+		NoWait:       *noWait,
+		Keys:         keys,
+		Snapshot:     *snapshot,
+		AsOf:         at,
+		Paths:        paths,
+		Overwrite:    policy,
+
+		EncryptionContext: ec,
+		VerifyKey:         pub,
+
+		POSIX:           posixPolicy,
+		OwnershipScript: *ownershipScript,
+
+		Concurrency:     *concurrency,
+		PartSize:        int64(*partSizeMB) << 20,
+		PartConcurrency: *partConcurrency,
+		BytesPerSecond:  bytesPerSecond,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runRestoreSnapshots implements "foldersync restore snapshots -dst <url>",
+// which lists the snapshots kept by runs with -snapshots.
+func runRestoreSnapshots(args []string) int {
+	fs := flag.NewFlagSet("restore snapshots", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL to list (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync restore snapshots -dst <url>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx := context.Background()
+	dst, err := openRestoreDst(ctx, *dstURL, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	names, err := sync.ListSnapshots(ctx, dst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snapshots: %v\n", err)
+		return 1
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return 0
+}
+
+// runRestoreStatus implements "foldersync restore status -dst <url>",
+// which counts the objects under a destination by archive state.
+func runRestoreStatus(args []string) int {
+	fs := flag.NewFlagSet("restore status", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL to check (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	verbose := fs.Bool("v", false, "list every object that is not readable")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync restore status -dst <url> [-v]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx := context.Background()
+	dst, err := openRestoreDst(ctx, *dstURL, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	objects, err := sync.ArchiveReport(ctx, dst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: %v\n", err)
+		return 1
+	}
+
+	counts := make(map[sync.ArchiveState]int)
+	for _, o := range objects {
+		counts[o.State]++
+		if !*verbose {
+			continue
+		}
+		switch o.State {
+		case sync.Archived, sync.Restoring:
+			fmt.Printf("%-9s %s (%s)\n", o.State, o.Key, o.StorageClass)
+		case sync.Restored:
+			fmt.Printf("%-9s %s (until %s)\n", o.State, o.Key, o.Expiry.Format(time.RFC3339))
+		}
+	}
+	fmt.Printf("%d objects: %d readable, %d restored, %d restoring, %d archived\n", len(objects),
+		counts[sync.NotArchived], counts[sync.Restored], counts[sync.Restoring], counts[sync.Archived])
+	return 0
+}
+
+// openRestoreDst opens the destination to restore from.
+func openRestoreDst(ctx context.Context, dstURL, region string) (sync.Destination, error) {
+	rawURL, err := withParams(dstURL, map[string]string{"region": region})
+	if err != nil {
+		return nil, err
+	}
+	return sync.Open(ctx, rawURL)
+}
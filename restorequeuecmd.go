@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+const restoreQueueUsage = `usage: foldersync restore queue add -dst <url> -to <dir> [-tier Bulk] <pattern>...
+       foldersync restore queue list -dst <url>
+       foldersync restore queue run -dst <url> [-wait]`
+
+// runRestoreQueue implements "foldersync restore queue", which manages a
+// persistent queue of objects to restore from an archive storage class.
+func runRestoreQueue(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, restoreQueueUsage)
+		return 2
+	}
+	switch args[0] {
+	case "add":
+		return runRestoreQueueAdd(args[1:])
+	case "list":
+		return runRestoreQueueList(args[1:])
+	case "run":
+		return runRestoreQueueRun(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, restoreQueueUsage)
+	return 2
+}
+
+func runRestoreQueueAdd(args []string) int {
+	fs := flag.NewFlagSet("restore queue add", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL to restore from (required)")
+	to := fs.String("to", "", "directory to restore into (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	tier := fs.String("tier", "Bulk", "retrieval tier: Bulk, Standard or Expedited")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync restore queue add -dst <url> -to <dir> [-tier Bulk] <pattern>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || *to == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	t, err := sync.ParseRestoreTier(*tier)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tier: %v\n", err)
+		return 2
+	}
+	for _, p := range fs.Args() {
+		if _, err := path.Match(p, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "pattern %q: %v\n", p, err)
+			return 2
+		}
+	}
+	abs, err := filepath.Abs(*to) // the queue may be run from elsewhere
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-to: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	q, dst, code := openRestoreQueue(ctx, *dstURL, *region)
+	if q == nil {
+		return code
+	}
+	n, err := q.Add(ctx, dst, abs, t, fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queue: %v\n", err)
+		return 1
+	}
+	if err := q.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "queue: %v\n", err)
+		return 1
+	}
+	fmt.Printf("queued %d objects; %d in the queue\n", n, len(q.Items))
+	return 0
+}
+
+func runRestoreQueueList(args []string) int {
+	fs := flag.NewFlagSet("restore queue list", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL of the queue (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync restore queue list -dst <url>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	path, err := cachePath("restore-queue", "", *dstURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queue: %v\n", err)
+		return 1
+	}
+	q, err := sync.LoadRestoreQueue(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queue: %v\n", err)
+		return 1
+	}
+	for _, it := range q.Items {
+		state := it.State
+		if state == "" {
+			state = "queued"
+		}
+		requested := "-"
+		if !it.Requested.IsZero() {
+			requested = it.Requested.Format(time.RFC3339)
+		}
+		fmt.Printf("%-9s %-9s %-25s %s -> %s\n", state, it.Tier, requested, it.Key, it.To)
+	}
+	fmt.Printf("%d objects in the queue\n", len(q.Items))
+	return 0
+}
+
+func runRestoreQueueRun(args []string) int {
+	fs := flag.NewFlagSet("restore queue run", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL of the queue (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	days := fs.Int("days", 7, "days to keep restored copies")
+	wait := fs.Bool("wait", false, "keep running, checking every -poll, until the queue is empty")
+	poll := fs.Duration("poll", 15*time.Minute, "with -wait, how often to check on restores")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync restore queue run -dst <url> [-wait]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *days < 1 || *poll <= 0 {
+		fmt.Fprintln(os.Stderr, "-days and -poll must be positive")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	q, dst, code := openRestoreQueue(ctx, *dstURL, *region)
+	if q == nil {
+		return code
+	}
+	for {
+		p, err := q.Process(ctx, dst, *days)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "queue: %v\n", err)
+			return 1
+		}
+		fmt.Printf("requested %d restores, downloaded %d objects; %d restoring, %d in the queue\n",
+			p.Requested, p.Downloaded, p.Restoring, len(q.Items))
+		if !*wait || len(q.Items) == 0 {
+			return 0
+		}
+		select {
+		case <-time.After(*poll):
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// openRestoreQueue opens the destination and loads its restore queue. If
+// either fails it prints why and returns a nil queue and an exit code.
+func openRestoreQueue(ctx context.Context, dstURL, region string) (*sync.RestoreQueue, sync.Destination, int) {
+	path, err := cachePath("restore-queue", "", dstURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queue: %v\n", err)
+		return nil, nil, 1
+	}
+	q, err := sync.LoadRestoreQueue(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "queue: %v\n", err)
+		return nil, nil, 1
+	}
+	q.Log = os.Stdout
+	dst, err := openRestoreDst(ctx, dstURL, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return nil, nil, 1
+	}
+	return q, dst, 0
+}
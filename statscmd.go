@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+const statsUsage = "usage: foldersync stats -src <dir> -dst <url> [-also-dst <url>]... [-months n] [-json]"
+
+// runStats implements "foldersync stats", which prints the trends of the
+// runs of a sync job recorded in its history.
+func runStats(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	src := fs.String("src", "", "source directory of the sync job (required)")
+	dstURL := fs.String("dst", "", "destination URL of the sync job (required)")
+	var alsoDsts stringsFlag
+	fs.Var(&alsoDsts, "also-dst", "further destination URL of the sync job; repeat for each")
+	months := fs.Int("months", 12, "show this many of the latest months; 0 shows every month")
+	asJSON := fs.Bool("json", false, "print the trends as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, statsUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *src == "" || *dstURL == "" || fs.NArg() != 0 || *months < 0 {
+		fs.Usage()
+		return 2
+	}
+
+	path, err := cachePath("history", *src, append([]string{*dstURL}, alsoDsts...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stats: %v\n", err)
+		return 1
+	}
+	runs, err := sync.ReadHistory(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("no runs recorded yet")
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stats: %v\n", err)
+		return 1
+	}
+
+	t := sync.Trends(runs)
+	if *months > 0 && len(t.Months) > *months {
+		t.Months = t.Months[len(t.Months)-*months:]
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(t, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "stats: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	if err := t.WriteText(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "stats: %v\n", err)
+		return 1
+	}
+	return 0
+}
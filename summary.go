@@ -0,0 +1,177 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// Exit statuses of a sync run, for scripts and schedulers to act on.
+const (
+	exitUpToDate = 0 // nothing needed changing
+	exitChanged  = 1 // changes were made, or with -dry-run would have been
+	exitPartial  = 2 // the run stopped partway, leaving changes for the next one
+	exitFatal    = 3 // the run could not start, or failed before changing anything
+	exitCanceled = 130
+)
+
+// fatal logs v and exits with exitFatal.
+func fatal(v ...any) {
+	log.Print(v...)
+	os.Exit(exitFatal)
+}
+
+// fatalf logs a formatted message and exits with exitFatal.
+func fatalf(format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(exitFatal)
+}
+
+// exitStatus returns the exit status of a run that ended with err, which
+// was summarized by s if it got as far as planning.
+func exitStatus(s *sync.Summary, err error) int {
+	changed := s != nil && (s.Uploaded+s.Deleted > 0 || s.DryRun && s.Uploads+s.Deletes > 0)
+	switch {
+	case errors.Is(err, sync.ErrCanceled):
+		return exitCanceled
+	case errors.Is(err, sync.ErrRequestLimit), errors.Is(err, sync.ErrBudget):
+		return exitPartial
+	case err != nil && changed:
+		return exitPartial
+	case err != nil:
+		return exitFatal
+	case changed:
+		return exitChanged
+	}
+	return exitUpToDate
+}
+
+// runTotal adds up the summaries of the syncs of a watch or two-way run,
+// for its exit status.
+type runTotal struct {
+	sync.Summary
+}
+
+// follow makes opts pass the summary of each sync to t as well.
+func (t *runTotal) follow(opts *sync.Options) {
+	next := opts.OnRunEvent
+	opts.OnRunEvent = func(e sync.RunEvent) {
+		if rc, ok := e.(sync.RunComplete); ok {
+			t.add(rc.Summary)
+		}
+		if next != nil {
+			next(e)
+		}
+	}
+}
+
+func (t *runTotal) add(s sync.Summary) {
+	t.DryRun = s.DryRun
+	t.Uploads += s.Uploads
+	t.Uploaded += s.Uploaded
+	t.Deletes += s.Deletes
+	t.Deleted += s.Deleted
+}
+
+// statusNames describe the exit statuses in summaries.
+var statusNames = map[int]string{
+	exitUpToDate: "up-to-date",
+	exitChanged:  "changed",
+	exitPartial:  "partial",
+	exitFatal:    "failed",
+	exitCanceled: "canceled",
+}
+
+// runSummary is the JSON form of the summary of a run.
+type runSummary struct {
+	Status        string  `json:"status"`
+	ExitCode      int     `json:"exit_code"`
+	DryRun        bool    `json:"dry_run"`
+	Files         int     `json:"files"`
+	Skipped       int     `json:"skipped"`
+	Uploads       int     `json:"uploads"`
+	Uploaded      int     `json:"uploaded"`
+	Deletes       int     `json:"deletes"`
+	Deleted       int     `json:"deleted"`
+	Unreadable    int     `json:"unreadable,omitempty"`
+	UploadedBytes int64   `json:"uploaded_bytes"`
+	Duration      float64 `json:"duration_seconds"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// printSummary prints the summary of a run that ended with err and exit
+// status code, in format "text" or "json", as the last line of output.
+// s is nil if the run failed before planning.
+func printSummary(format string, s *sync.Summary, err error, code int) {
+	r := runSummary{Status: statusNames[code], ExitCode: code}
+	if s != nil {
+		r.DryRun = s.DryRun
+		r.Files, r.Skipped = s.Files, s.Skipped
+		r.Uploads, r.Uploaded = s.Uploads, s.Uploaded
+		r.Deletes, r.Deleted = s.Deletes, s.Deleted
+		r.Unreadable = s.Unreadable
+		r.UploadedBytes = s.UploadedBytes
+		r.Duration = s.Duration.Seconds()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	switch format {
+	case "json":
+		data, _ := json.Marshal(r)
+		fmt.Println(string(data))
+	case "text":
+		line := fmt.Sprintf("%s: %d files, uploaded %d of %d (%s), deleted %d of %d",
+			r.Status, r.Files, r.Uploaded, r.Uploads, localize(sync.FormatSize(r.UploadedBytes)), r.Deleted, r.Deletes)
+		if r.Unreadable > 0 {
+			line += fmt.Sprintf(", skipped %d unreadable", r.Unreadable)
+		}
+		if r.DryRun {
+			// Without the time taken, so that dry runs over the same
+			// files and objects print the same.
+			fmt.Printf("summary (dry run): %s\n", line)
+		} else {
+			d := time.Duration(r.Duration * float64(time.Second))
+			fmt.Printf("summary: %s, in %s\n", line, localize(sync.FormatDuration(d)))
+		}
+	}
+}
+
+// localize writes the decimal points of s, a size or duration formatted
+// for people, as the locale of the environment does.
+func localize(s string) string {
+	if decimalComma(cmp.Or(os.Getenv("LC_ALL"), os.Getenv("LC_NUMERIC"), os.Getenv("LANG"))) {
+		return strings.ReplaceAll(s, ".", ",")
+	}
+	return s
+}
+
+// decimalComma reports whether locale, such as "de_DE.UTF-8", writes a
+// decimal comma rather than a point.
+func decimalComma(locale string) bool {
+	locale, _, _ = strings.Cut(locale, ".")
+	locale, _, _ = strings.Cut(locale, "@")
+	lang, region, _ := strings.Cut(locale, "_")
+	switch region {
+	case "CH", "MX", "US", "GB", "IE":
+		return false // regions of these languages that write points
+	}
+	return commaLanguages[lang]
+}
+
+// commaLanguages are the languages whose locales write decimal commas.
+var commaLanguages = map[string]bool{
+	"bg": true, "ca": true, "cs": true, "da": true, "de": true, "el": true,
+	"es": true, "et": true, "fi": true, "fr": true, "hr": true, "hu": true,
+	"id": true, "it": true, "lt": true, "lv": true, "nb": true, "nl": true,
+	"nn": true, "pl": true, "pt": true, "ro": true, "ru": true, "sk": true,
+	"sl": true, "sr": true, "sv": true, "tr": true, "uk": true, "vi": true,
+}
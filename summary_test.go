@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+func TestExitStatus(t *testing.T) {
+	failed := errors.New("upload failed")
+	changed := &sync.Summary{Progress: sync.Progress{Uploads: 3, Uploaded: 1}}
+	for _, tt := range []struct {
+		name string
+		s    *sync.Summary
+		err  error
+		want int
+	}{
+		{"up to date", &sync.Summary{}, nil, exitUpToDate},
+		{"changed", changed, nil, exitChanged},
+		{"dry run", &sync.Summary{DryRun: true, Progress: sync.Progress{Deletes: 1}}, nil, exitChanged},
+		{"failed after changes", changed, failed, exitPartial},
+		{"request limit", &sync.Summary{}, sync.ErrRequestLimit, exitPartial},
+		{"budget", &sync.Summary{}, sync.ErrBudget, exitPartial},
+		{"failed before changes", &sync.Summary{Progress: sync.Progress{Uploads: 3}}, failed, exitFatal},
+		{"failed before planning", nil, failed, exitFatal},
+		{"canceled", changed, &sync.CanceledError{Planned: true, Err: failed}, exitCanceled},
+		{"canceled before planning", nil, &sync.CanceledError{Err: failed}, exitCanceled},
+	} {
+		if got := exitStatus(tt.s, tt.err); got != tt.want {
+			t.Errorf("%s: exitStatus = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExitStatus_requestLimit(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := sync.Options{
+		Src:         src,
+		Dst:         sync.NewLocalDestination(t.TempDir()),
+		MaxRequests: 6,
+		StateCache:  filepath.Join(t.TempDir(), "state.json"),
+	}
+	ctx := context.Background()
+
+	res, err := sync.Sync(ctx, opts)
+	if got := exitStatus(&res.Summary, err); got != exitPartial {
+		t.Fatalf("run stopped by the request limit: exit status %d (%v), want %d", got, err, exitPartial)
+	}
+	first := res.Uploaded
+	if first == 0 || first == 5 {
+		t.Fatalf("run stopped by the request limit uploaded %d of 5 files", first)
+	}
+
+	// The next run picks up from the state cache, checking again only the
+	// files the first did not get to.
+	opts.MaxRequests = 0
+	res, err = sync.Sync(ctx, opts)
+	if got := exitStatus(&res.Summary, err); got != exitChanged {
+		t.Fatalf("resumed run: exit status %d (%v), want %d", got, err, exitChanged)
+	}
+	if res.Uploaded != 5-first || res.Skipped != first {
+		t.Errorf("resumed run uploaded %d and skipped %d, want %d and %d", res.Uploaded, res.Skipped, 5-first, first)
+	}
+}
+
+func TestRunTotal(t *testing.T) {
+	var total runTotal
+	opts := sync.Options{}
+	total.follow(&opts)
+	opts.OnRunEvent(sync.RunComplete{Summary: sync.Summary{Progress: sync.Progress{Uploads: 2, Uploaded: 2}}})
+	opts.OnRunEvent(sync.FileDone{})
+	opts.OnRunEvent(sync.RunComplete{Summary: sync.Summary{Progress: sync.Progress{Deletes: 1}}})
+	if got := exitStatus(&total.Summary, nil); got != exitChanged {
+		t.Errorf("exit status of a watch that uploaded = %d, want %d", got, exitChanged)
+	}
+	if got := exitStatus(&total.Summary, errors.New("watch failed")); got != exitPartial {
+		t.Errorf("exit status of a watch that failed after uploading = %d, want %d", got, exitPartial)
+	}
+}
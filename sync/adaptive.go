@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"context"
+	gosync "sync"
+	"time"
+)
+
+// adaptiveLimit limits how many requests are made at once to a limit it
+// adapts to what their responses show. It starts at one, and doubles the
+// limit after each round of requests, one per request allowed at once,
+// until the first sign of trouble; from then on it raises it by one a
+// round instead. A throttled request halves the limit, and a round whose
+// requests took longer for their size than the tolerance allows over the
+// best round lowers it by a quarter: more requests at once no longer
+// made them faster overall.
+type adaptiveLimit struct {
+	max int
+
+	mu       gosync.Mutex
+	limit    int
+	inflight int
+	changed  chan struct{} // closed when inflight or limit change
+	slow     bool          // past the first doubling phase
+
+	// The round under way, and the best seen, as time per byte.
+	roundN     int
+	roundBytes int64
+	roundTime  time.Duration
+	best       float64
+}
+
+// adaptiveTolerance is how much slower per byte than the best round a
+// round may be before the limit is lowered.
+const adaptiveTolerance = 1.5
+
+func newAdaptiveLimit(n int) *adaptiveLimit {
+	return &adaptiveLimit{max: n, limit: 1, changed: make(chan struct{})}
+}
+
+// acquire waits for a request to be allowed.
+func (l *adaptiveLimit) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inflight < l.limit {
+			l.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		ch := l.changed
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// release records a request acquire allowed, of n bytes, that took d and
+// was throttled, or failed for another reason, or neither.
+func (l *adaptiveLimit) release(n int64, d time.Duration, throttled, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	defer l.signal()
+	switch {
+	case throttled:
+		l.slow = true
+		l.setLimit(l.limit / 2)
+		return
+	case failed || n <= 0:
+		return
+	}
+	l.roundN++
+	l.roundBytes += n
+	l.roundTime += d
+	if l.roundN < l.limit {
+		return
+	}
+	perByte := float64(l.roundTime) / float64(l.roundBytes)
+	l.roundN, l.roundBytes, l.roundTime = 0, 0, 0
+	switch {
+	case l.best == 0 || perByte < l.best:
+		l.best = perByte
+	case perByte > l.best*adaptiveTolerance:
+		l.slow = true
+		l.setLimit(l.limit - max(1, l.limit/4))
+		return
+	}
+	if l.slow {
+		l.setLimit(l.limit + 1)
+	} else {
+		l.setLimit(l.limit * 2)
+	}
+}
+
+// setLimit sets the limit to n, from 1 to l.max, and starts a new round.
+func (l *adaptiveLimit) setLimit(n int) {
+	l.limit = min(max(n, 1), l.max)
+	l.roundN, l.roundBytes, l.roundTime = 0, 0, 0
+}
+
+// signal wakes the requests waiting in acquire.
+func (l *adaptiveLimit) signal() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimit(t *testing.T) {
+	// A link that sends 4 parts at once as fast as one: past that, each
+	// part takes longer in proportion.
+	l := newAdaptiveLimit(64)
+	var seen []int
+	for range 30 {
+		n := l.limit
+		seen = append(seen, n)
+		for range n {
+			if err := l.acquire(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		d := time.Duration(max(n, 4)) * 25 * time.Millisecond
+		for range n {
+			l.release(1<<20, d, false, false)
+		}
+	}
+	if seen[1] != 2 || seen[2] != 4 {
+		t.Errorf("limits %v, want it doubled at first", seen)
+	}
+	if n := l.limit; n < 4 || n > 8 {
+		t.Errorf("limits %v, want them to settle from 4 to 8", seen)
+	}
+
+	before := l.limit
+	l.acquire(context.Background())
+	l.release(1<<20, time.Second, true, false)
+	if l.limit != before/2 {
+		t.Errorf("limit %d after throttling, want %d halved", l.limit, before)
+	}
+
+	// With the limit in use, a request waits for another to be released.
+	l = newAdaptiveLimit(1)
+	l.acquire(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire over the limit = %v, want it to wait", err)
+	}
+	go l.release(1, time.Millisecond, false, false)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Errorf("acquire after a release = %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+// uploadAppended uploads only the tail of u, open as f, that was added
+// since its object was uploaded, with meta, if opts.AppendUploads is set
+// and u grew by appending; see Options.AppendUploads. It reports whether
+// it did, leaving u to be uploaded whole if not.
+func uploadAppended(ctx context.Context, opts Options, u File, f *os.File, meta ObjectMeta) (bool, error) {
+	r := u.Remote
+	switch {
+	case !opts.AppendUploads || opts.StageUploads || opts.Sparse || opts.Compression != "" || opts.Transform != nil:
+		return false, nil
+	case r == nil || r.SHA256 == "" || r.Size <= 0 || r.Size >= u.Size:
+		return false, nil
+	case r.Sparse || r.Compression != "" || r.Transform != "" || r.Chunked || r.Archived:
+		return false, nil
+	}
+	if _, ok := opts.Dst.(Appender); !ok {
+		return false, nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, r.Size)); err != nil {
+		return false, err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != r.SHA256 {
+		return false, nil // rewritten, not appended to
+	}
+
+	size := u.Size - r.Size
+	wctx, tail, stop := opts.watchUpload(ctx, io.NewSectionReader(f, r.Size, size))
+	tail = opts.trackUpload(u.Key, tail, size)
+	err := stop(appendObject(wctx, opts.Dst, u.Key, r.Size, tail, meta))
+	if errors.Is(err, errors.ErrUnsupported) {
+		return false, nil
+	} else if err != nil {
+		return true, err
+	}
+	if err := settleHash(f, u, nil); err != nil {
+		return true, err
+	}
+	return true, verifyUpload(ctx, opts, u.Key, meta)
+}
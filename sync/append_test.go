@@ -0,0 +1,136 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// appendDest is a mockDest that can append to objects.
+type appendDest struct {
+	*mockDest
+	tails []string
+}
+
+func (d *appendDest) Append(_ context.Context, key string, size int64, tail io.Reader, meta ObjectMeta) error {
+	b, err := io.ReadAll(tail)
+	if err != nil {
+		return err
+	}
+	if int64(len(d.data[key])) != size {
+		return fmt.Errorf("append to %s: %w", key, errors.ErrUnsupported)
+	}
+	d.tails = append(d.tails, string(b))
+	d.data[key] = append(d.data[key], b...)
+	d.objects[key] = &meta
+	return nil
+}
+
+func TestSync_appendUploads(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "app.log", "started\n")
+	dst := &appendDest{mockDest: newMockDest()}
+	ctx := context.Background()
+	opts := Options{Src: src, Dst: dst, Checksums: true, AppendUploads: true}
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(src, "app.log"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("stopped\n")
+	f.Close()
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.putCalls, []string{"app.log"}) || !slices.Equal(dst.tails, []string{"stopped\n"}) {
+		t.Errorf("put %v and appended %q; want the tail alone appended", dst.putCalls, dst.tails)
+	}
+	if got := string(dst.data["app.log"]); got != "started\nstopped\n" {
+		t.Errorf("object holds %q", got)
+	}
+	if sum, _ := fileSHA256(filepath.Join(src, "app.log")); dst.objects["app.log"].SHA256 != fmt.Sprintf("%x", sum) {
+		t.Errorf("SHA256 = %s, want that of the whole file", dst.objects["app.log"].SHA256)
+	}
+
+	// Rewritten rather than appended to: uploaded whole.
+	writeFile(t, src, "app.log", "restarted\nstopped\n")
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 2 || len(dst.tails) != 1 {
+		t.Errorf("put %v and appended %q; want a rewritten file put", dst.putCalls, dst.tails)
+	}
+
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, AppendUploads: true}); err == nil {
+		t.Error("Sync with AppendUploads and without Checksums succeeded")
+	}
+}
+
+func TestS3Destination_Append(t *testing.T) {
+	var requests, ranges []string
+	var parts []int64
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				var out any
+				switch p := in.Parameters.(type) {
+				case *s3.HeadObjectInput:
+					out = &s3.HeadObjectOutput{ContentLength: aws.Int64(600 << 20), ETag: aws.String(`"old"`)}
+				case *s3.CreateMultipartUploadInput:
+					out = &s3.CreateMultipartUploadOutput{Key: p.Key, UploadId: aws.String("upload-1")}
+				case *s3.UploadPartCopyInput:
+					if aws.ToString(p.CopySourceIfMatch) != `"old"` {
+						return middleware.InitializeOutput{}, middleware.Metadata{}, errors.New("copied without If-Match")
+					}
+					ranges = append(ranges, aws.ToString(p.CopySourceRange))
+					out = &s3.UploadPartCopyOutput{CopyPartResult: &types.CopyPartResult{ETag: aws.String("copy")}}
+				case *s3.UploadPartInput:
+					parts = append(parts, aws.ToInt64(p.ContentLength))
+					out = &s3.UploadPartOutput{ETag: aws.String("part")}
+				case *s3.CompleteMultipartUploadInput:
+					out = &s3.CompleteMultipartUploadOutput{}
+				default:
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", p)
+				}
+				requests = append(requests, fmt.Sprintf("%T", in.Parameters))
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "backups", WithS3Middleware(fake), WithS3PartSize(8<<20))
+	ctx := context.Background()
+
+	tail := bytes.NewReader(make([]byte, 10<<20))
+	if err := d.Append(ctx, "app.log", 600<<20, tail, ObjectMeta{Size: 610 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	// 600 MiB is copied in two even parts, none of them under 5 MiB.
+	wantRanges := []string{"bytes=0-314572799", "bytes=314572800-629145599"}
+	if !slices.Equal(ranges, wantRanges) || !slices.Equal(parts, []int64{8 << 20, 2 << 20}) {
+		t.Errorf("copied %v and uploaded parts of %v, want %v and [8 MiB 2 MiB]", ranges, parts, wantRanges)
+	}
+	if requests[len(requests)-1] != "*s3.CompleteMultipartUploadInput" {
+		t.Errorf("requests = %v, want the upload completed", requests)
+	}
+
+	requests = nil
+	err := d.Append(ctx, "small.log", 1<<20, strings.NewReader("more"), ObjectMeta{Size: 1<<20 + 4})
+	if !errors.Is(err, errors.ErrUnsupported) || len(requests) != 0 {
+		t.Errorf("Append to a 1 MiB object: %v after %v, want errors.ErrUnsupported and no requests", err, requests)
+	}
+}
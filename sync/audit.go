@@ -0,0 +1,222 @@
+package sync
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AuditOptions configures an Audit.
+type AuditOptions struct {
+	Dst Destination // the backup to audit
+
+	// Manifest, if set, is the manifest to audit against, such as a copy
+	// exported from an earlier audit and kept apart from the destination.
+	// Otherwise the destination's own is read.
+	Manifest *Manifest
+	// VerifyKey, if set, is the key the destination's manifest must be
+	// signed with, so that one rewritten along with the objects it lists
+	// is not trusted.
+	VerifyKey ed25519.PublicKey
+
+	// Checksum downloads each object, and each bundle, holding a file the
+	// manifest records a SHA-256 for, and compares its content, which
+	// catches corruption its metadata does not show. Archived objects are
+	// compared by their metadata only.
+	Checksum bool
+}
+
+// AuditReport is the result of an Audit.
+type AuditReport struct {
+	Manifest time.Time // when the manifest audited against was written
+	Files    int       // files the manifest lists
+	Hashed   int       // of those, compared by content
+	// Archived is how many of them were compared by metadata only with
+	// AuditOptions.Checksum, since their objects cannot be read without a
+	// restore.
+	Archived   int
+	Mismatches []Mismatch // in key order
+}
+
+// Audit checks that opts.Dst still holds every file its manifest lists, as
+// the manifest describes it, without a source to compare to: each object
+// must exist and have the size and modification time recorded, and with
+// opts.Checksum the content hash too. Files stored in bundles are checked
+// against the bundle index and inside their bundles. It detects objects
+// deleted, replaced or corrupted since the manifest was written, and with
+// opts.VerifyKey, a manifest altered to match.
+//
+// Differences are returned in the report; the error is only set if the
+// audit could not be completed.
+func Audit(ctx context.Context, opts AuditOptions) (*AuditReport, error) {
+	m := opts.Manifest
+	if m == nil {
+		var err error
+		m, err = ReadManifest(ctx, opts.Dst, opts.VerifyKey)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no manifest to audit against: %w", err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	idx, err := ReadBundleIndex(ctx, opts.Dst)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{Manifest: m.Created, Files: len(m.Files)}
+	bundled := make(map[string][]ManifestEntry) // by bundle
+	for _, e := range m.Files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if b, ok := idx.Files[e.Key]; ok {
+			if reason := entryMismatch(b.Size, b.ModTime, e); reason != "" {
+				report.mismatch(e.Key, "bundle index lists "+reason)
+			} else {
+				bundled[b.Bundle] = append(bundled[b.Bundle], e)
+			}
+			continue
+		}
+		if err := auditObject(ctx, opts, report, e); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Key, err)
+		}
+	}
+	for _, bundle := range slices.Sorted(maps.Keys(bundled)) {
+		if err := auditBundle(ctx, opts, report, bundle, bundled[bundle]); err != nil {
+			return nil, fmt.Errorf("%s: %w", bundle, err)
+		}
+	}
+	slices.SortFunc(report.Mismatches, func(a, b Mismatch) int { return strings.Compare(a.Key, b.Key) })
+	return report, nil
+}
+
+func (r *AuditReport) mismatch(key, reason string) {
+	r.Mismatches = append(r.Mismatches, Mismatch{Key: key, Reason: reason})
+}
+
+// auditObject checks the object of the file e describes.
+func auditObject(ctx context.Context, opts AuditOptions, report *AuditReport, e ManifestEntry) error {
+	meta, err := opts.Dst.Stat(ctx, e.Key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		report.mismatch(e.Key, "missing")
+		return nil
+	}
+	if reason := entryMismatch(meta.Size, meta.ModTime, e); reason != "" {
+		report.mismatch(e.Key, reason)
+		return nil
+	}
+	if !opts.Checksum || e.SHA256 == "" {
+		return nil
+	}
+	st, err := archiveStatus(ctx, opts.Dst, e.Key)
+	if err != nil {
+		return err
+	}
+	if !st.readable() {
+		report.Archived++
+		return nil
+	}
+	sum, err := objectSHA256(ctx, opts.Dst, e.Key, meta)
+	if err != nil {
+		return err
+	}
+	report.Hashed++
+	if got := hex.EncodeToString(sum); !strings.EqualFold(got, e.SHA256) {
+		report.mismatch(e.Key, fmt.Sprintf("content hash %s, manifest lists %s", got, e.SHA256))
+	}
+	return nil
+}
+
+// auditBundle checks that bundle exists, and with opts.Checksum, the
+// content of the files in it entries describe.
+func auditBundle(ctx context.Context, opts AuditOptions, report *AuditReport, bundle string, entries []ManifestEntry) error {
+	meta, err := opts.Dst.Stat(ctx, bundle)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		for _, e := range entries {
+			report.mismatch(e.Key, "bundle "+bundle+" is missing")
+		}
+		return nil
+	}
+	hashed := make(map[string]ManifestEntry)
+	if opts.Checksum {
+		for _, e := range entries {
+			if e.SHA256 != "" {
+				hashed[e.Key] = e
+			}
+		}
+	}
+	if len(hashed) == 0 {
+		return nil
+	}
+	st, err := archiveStatus(ctx, opts.Dst, bundle)
+	if err != nil {
+		return err
+	}
+	if !st.readable() {
+		report.Archived += len(hashed)
+		return nil
+	}
+
+	rc, err := get(ctx, opts.Dst, bundle)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for len(hashed) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		e, ok := hashed[hdr.Name]
+		if !ok {
+			continue
+		}
+		delete(hashed, hdr.Name)
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return err
+		}
+		report.Hashed++
+		if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, e.SHA256) {
+			report.mismatch(e.Key, fmt.Sprintf("content hash %s in %s, manifest lists %s", got, bundle, e.SHA256))
+		}
+	}
+	for key := range hashed {
+		report.mismatch(key, "not found in "+bundle)
+	}
+	return nil
+}
+
+// entryMismatch describes how a file of size bytes modified at modTime
+// differs from e, or returns "" if it does not.
+func entryMismatch(size int64, modTime time.Time, e ManifestEntry) string {
+	switch {
+	case size != e.Size:
+		return fmt.Sprintf("size %d, manifest lists %d", size, e.Size)
+	case modTime.Unix() != e.ModTime.Unix():
+		return fmt.Sprintf("modified %s, manifest lists %s", modTime.UTC().Format(time.RFC3339), e.ModTime.UTC().Format(time.RFC3339))
+	}
+	return ""
+}
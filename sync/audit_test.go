@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "dir/b.txt", "bravo")
+	writeFile(t, src, "c.txt", "charlie")
+	writeFile(t, src, "d.txt", "delta")
+	writeFile(t, src, "s1.txt", "xq")
+	writeFile(t, src, "s2.txt", "yq")
+	dst := newMockDest()
+	opts := Options{
+		Src: src, Dst: dst, Manifest: true, ManifestChecksums: true, StateCache: filepath.Join(t.TempDir(), "state.json"),
+		Bundle: &BundleOptions{Threshold: 4, MaxSize: 1 << 20},
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	report, err := Audit(ctx, AuditOptions{Dst: dst, Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 6 || report.Hashed != 6 || len(report.Mismatches) != 0 {
+		t.Fatalf("untouched backup: %+v, want 6 files hashed and no differences", report)
+	}
+
+	// Same size, other content: only the hash tells.
+	dst.data["dir/b.txt"] = []byte("BRAVO")
+	delete(dst.objects, "c.txt")
+	dst.objects["d.txt"].Size = 4
+	for key, data := range dst.data {
+		if strings.HasPrefix(key, BundlePrefix) {
+			dst.data[key] = bytes.Replace(data, []byte("xq"), []byte("XQ"), 1)
+		}
+	}
+
+	report, err = Audit(ctx, AuditOptions{Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"c.txt: missing", "d.txt: size 4, manifest lists 5"}
+	if got := mismatchStrings(report.Mismatches); strings.Join(got, "\n") != strings.Join(want, "\n") || report.Hashed != 0 {
+		t.Errorf("by metadata: %q, %d hashed; want %q", got, report.Hashed, want)
+	}
+	report, err = Audit(ctx, AuditOptions{Dst: dst, Checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := mismatchStrings(report.Mismatches)
+	if len(got) != 4 || !strings.HasPrefix(got[2], "dir/b.txt: content hash") || !strings.HasPrefix(got[3], "s1.txt: content hash") {
+		t.Errorf("by content: %q, want dir/b.txt and s1.txt to fail their hashes as well", got)
+	}
+
+	if _, err := Audit(ctx, AuditOptions{Dst: newMockDest()}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("no manifest: got %v, want ErrNotExist", err)
+	}
+	// A manifest given is used instead of the destination's.
+	m := &Manifest{Files: []ManifestEntry{{Key: "gone.txt", Size: 1}}}
+	report, err = Audit(ctx, AuditOptions{Dst: dst, Manifest: m})
+	if err != nil || len(report.Mismatches) != 1 || report.Mismatches[0].Key != "gone.txt" {
+		t.Errorf("given manifest: %+v, %v; want gone.txt missing", report, err)
+	}
+}
+
+func mismatchStrings(ms []Mismatch) []string {
+	var s []string
+	for _, m := range ms {
+		s = append(s, m.String())
+	}
+	return s
+}
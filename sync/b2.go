@@ -0,0 +1,262 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Backblaze/blazer/b2"
+)
+
+func init() {
+	Register("b2", openB2URL)
+}
+
+// B2Destination uploads files to a Backblaze B2 bucket through the native
+// B2 API, whose uploads and deletes are free, rather than the S3-compatible
+// one. Files larger than the part size are uploaded as large files, in
+// parts.
+//
+// B2 keeps every version of a file. Overwriting a file uploads a new
+// version, and Delete hides the file rather than deleting its versions;
+// the bucket's lifecycle rules decide how long old and hidden versions are
+// kept and billed.
+type B2Destination struct {
+	bucket      *b2.Bucket
+	prefix      string
+	partSize    int // 0 for the client's default of 100 MB
+	concurrency int // parts of a large file uploaded at once
+}
+
+// NewB2Destination creates a new B2Destination. partSize is the size of
+// the parts of large files in bytes, or 0 for the default of 100 MB, and
+// concurrency how many of a file's parts are uploaded at once.
+func NewB2Destination(bucket *b2.Bucket, prefix string, partSize, concurrency int) *B2Destination {
+	return &B2Destination{bucket: bucket, prefix: prefix, partSize: partSize, concurrency: concurrency}
+}
+
+// openB2URL opens b2://bucket/prefix with the application key in the
+// B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY environment variables, as
+// the b2 command line tool does. Query parameters:
+//
+//	part-size-mb        size of the parts of large files, 5 to 5120 MiB (default 100 MB)
+//	upload-concurrency  parts of a large file uploaded at once (default 1)
+func openB2URL(ctx context.Context, u *url.URL) (Destination, error) {
+	bucket, prefix, err := bucketAndPrefix(u)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if q.Get("storage-class") != "" {
+		return nil, errors.New("b2:// destinations do not support storage classes")
+	}
+	partSizeMB, err := intParam(q, "part-size-mb")
+	if err != nil {
+		return nil, err
+	}
+	if partSizeMB != 0 { // B2 limits parts as S3 does
+		if err := CheckS3PartSize(int64(partSizeMB) << 20); err != nil {
+			return nil, fmt.Errorf("part-size-mb: %w", err)
+		}
+	}
+	concurrency, err := intParam(q, "upload-concurrency")
+	if err != nil {
+		return nil, err
+	}
+	if concurrency < 0 {
+		return nil, errors.New("upload-concurrency must not be negative")
+	}
+
+	keyID, key := os.Getenv("B2_APPLICATION_KEY_ID"), os.Getenv("B2_APPLICATION_KEY")
+	if keyID == "" || key == "" {
+		return nil, errors.New("set B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY to an application key for the bucket")
+	}
+	client, err := b2.NewClient(ctx, keyID, key, b2.UserAgent("foldersync"))
+	if err != nil {
+		return nil, fmt.Errorf("create B2 client: %w", err)
+	}
+	b, err := client.Bucket(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("open B2 bucket %s: %w", bucket, err)
+	}
+	return NewB2Destination(b, prefix, partSizeMB<<20, concurrency), nil
+}
+
+// RequestPrices implements RequestPricer with B2's list prices: uploads
+// and deletes are free, and downloads and metadata lookups (Class B) cost
+// $0.004 per 10,000. Listings (Class C, $0.004 per 1,000) are counted with
+// the free writes, so estimates for runs that list are a little low.
+func (d *B2Destination) RequestPrices() RequestPrices {
+	return RequestPrices{Write: 0, Read: 0.0004}
+}
+
+// StoragePrices implements StoragePricer with B2's list price. B2 has a
+// single storage class.
+func (d *B2Destination) StoragePrices() []StoragePrice {
+	return []StoragePrice{{
+		Class: "B2", GBMonth: 0.006, Requests: d.RequestPrices(),
+		Note: "downloads are free up to three times the data stored a month, and $0.01/GB beyond that",
+	}}
+}
+
+// uploadPartSize implements partSizer: larger files are uploaded as large
+// files, with a request to start one and one to finish it.
+func (d *B2Destination) uploadPartSize() (int64, int) {
+	if d.partSize == 0 {
+		return 100e6, 2
+	}
+	return int64(d.partSize), 2
+}
+
+func (d *B2Destination) Prefix() string {
+	return strings.Trim(d.prefix, "/")
+}
+
+func (d *B2Destination) WithPrefix(prefix string) Destination {
+	c := *d
+	c.prefix = prefix
+	return &c
+}
+
+func (d *B2Destination) object(rel string) *b2.Object {
+	return d.bucket.Object(joinKey(d.prefix, rel))
+}
+
+// Put uploads r, as a large file if it is larger than a part. Bodies that
+// are io.ReadSeekers are read a part at a time, without buffering.
+func (d *B2Destination) Put(ctx context.Context, rel string, r io.Reader, meta ObjectMeta) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	attrs := &b2.Attrs{ContentType: meta.ContentType, Info: objectMetadata(meta)}
+	w := d.object(rel).NewWriter(ctx,
+		b2.WithAttrsOption(attrs),
+		// A large file that fails is canceled, so that its parts are not
+		// left stored and billed, even if ctx has been.
+		b2.WithCancelOnError(func() context.Context { return context.WithoutCancel(ctx) }, nil),
+	)
+	w.ChunkSize = d.partSize
+	w.ConcurrentUploads = d.concurrency
+
+	if _, err := w.ReadFrom(r); err != nil {
+		// Closing the writer would upload what was read so far.
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (d *B2Destination) Stat(ctx context.Context, rel string) (*ObjectMeta, error) {
+	attrs, err := d.object(rel).Attrs(ctx)
+	if err != nil {
+		if b2.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	meta := parseMetadata(attrs.Size, attrs.Info)
+	meta.ContentType = attrs.ContentType
+	return meta, nil
+}
+
+// Get opens the object at rel. B2 reports an absent object when it is first
+// read, so Get reads the start of it before returning.
+func (d *B2Destination) Get(ctx context.Context, rel string) (io.ReadCloser, error) {
+	r := d.object(rel).NewReader(ctx)
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err != nil && err != io.EOF {
+		r.Close()
+		if b2.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, r}, nil
+}
+
+// List implements Destination a page of up to b2ListPage keys at a time,
+// which B2 returns in byte order.
+func (d *B2Destination) List(ctx context.Context, fn func(keys []string) error) error {
+	page := make([]string, 0, b2ListPage)
+	err := d.listObjects(ctx, func(o *b2.Object, _ *b2.Attrs) error {
+		page = append(page, splitKey(d.prefix, o.Name()))
+		if len(page) < b2ListPage {
+			return nil
+		}
+		err := fn(page)
+		page = make([]string, 0, b2ListPage)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(page) > 0 {
+		return fn(page)
+	}
+	return nil
+}
+
+// b2ListPage is the most keys a page of B2Destination.List holds.
+const b2ListPage = 1000
+
+// ListWritten implements WrittenLister using each file's upload time, which
+// B2 lifecycle rules are based on.
+func (d *B2Destination) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	written := make(map[string]time.Time)
+	err := d.listObjects(ctx, func(o *b2.Object, attrs *b2.Attrs) error {
+		written[splitKey(d.prefix, o.Name())] = attrs.UploadTimestamp
+		return nil
+	})
+	return written, err
+}
+
+// ListObjects implements ObjectLister from the same listing as List.
+func (d *B2Destination) ListObjects(ctx context.Context) (map[string]ListedObject, error) {
+	objects := make(map[string]ListedObject)
+	err := d.listObjects(ctx, func(o *b2.Object, attrs *b2.Attrs) error {
+		objects[splitKey(d.prefix, o.Name())] = ListedObject{Size: attrs.Size, Written: attrs.UploadTimestamp}
+		return nil
+	})
+	return objects, err
+}
+
+// listObjects calls fn for every file under the destination's prefix, in
+// byte order, with the attributes the listing reported for it. It stops at
+// the first error fn returns and returns it.
+func (d *B2Destination) listObjects(ctx context.Context, fn func(*b2.Object, *b2.Attrs) error) error {
+	it := d.bucket.List(ctx, b2.ListPrefix(listPrefix(d.prefix)), b2.ListPageSize(b2ListPage))
+	for it.Next() {
+		o := it.Object()
+		attrs, err := o.Attrs(ctx) // from the listing, without a request
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		if err := fn(o, attrs); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("list objects: %w", err)
+	}
+	return nil
+}
+
+// Delete hides the file at rel, which B2 then treats as absent. Its
+// versions are kept as the bucket's lifecycle rules say.
+func (d *B2Destination) Delete(ctx context.Context, rel string) error {
+	err := d.object(rel).Hide(ctx)
+	if b2.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package sync
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOpenB2URL_invalid(t *testing.T) {
+	t.Setenv("B2_APPLICATION_KEY_ID", "")
+	t.Setenv("B2_APPLICATION_KEY", "")
+	for rawURL, want := range map[string]string{
+		"b2:///photos":                            "missing bucket",
+		"b2://bucket?storage-class=STANDARD":      "do not support storage classes",
+		"b2://bucket?part-size-mb=1":              "want 5 to 5120 MiB",
+		"b2://bucket?part-size-mb=big":            "want a number",
+		"b2://bucket?upload-concurrency=-1":       "must not be negative",
+		"b2://bucket/photos?upload-concurrency=4": "B2_APPLICATION_KEY_ID",
+	} {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each fails before any request is made.
+		if _, err := openB2URL(context.Background(), u); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("openB2URL(%s) = %v, want an error containing %q", rawURL, err, want)
+		}
+	}
+}
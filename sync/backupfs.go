@@ -0,0 +1,446 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// BackupFS is a read-only view of the files of a backup, laid out as
+// Restore would restore them, for browsing and copying single files
+// without restoring the rest. It implements fs.FS, fs.StatFS and
+// fs.ReadDirFS, and its files are io.ReaderAts, so that it can be
+// mounted; see Mount.
+//
+// The tree is listed once, as the view is opened. Each file is looked up
+// at the destination as it is first opened or stat'ed, and its content
+// read as it is read: in ranges of the object from destinations that are
+// RangeGetters, where the object is stored as the file it holds, and
+// otherwise from the start, again for each read before the last.
+type BackupFS struct {
+	ctx      context.Context
+	from     Destination
+	root     *backupNode
+	warnings io.Writer // RestoreOptions.Warnings
+
+	mu    gosync.Mutex
+	metas map[string]*ObjectMeta // by key, as Stat reported them
+}
+
+// backupNode is a file or directory of a BackupFS.
+type backupNode struct {
+	key      string                 // of the object holding the file's content
+	bundled  *BundleEntry           // set for files packed into bundles
+	children map[string]*backupNode // nil for files
+}
+
+func (n *backupNode) dir() bool { return n.children != nil }
+
+// OpenBackupFS lists the files of the backup in opts.From that Restore
+// would restore with opts: opts.Keys, opts.Snapshot or opts.AsOf, and
+// opts.Paths apply, and the other options are ignored. Files stored as
+// hard links, and recorded empty directories, are there as Restore would
+// make them. Archived objects are listed, but cannot be read.
+//
+// ctx is that of the requests the view makes as it is read.
+func OpenBackupFS(ctx context.Context, opts RestoreOptions) (*BackupFS, error) {
+	opts.Keys = keyMapper(opts.Keys)
+	if opts.Snapshot == "" && !opts.AsOf.IsZero() {
+		name, err := snapshotAsOf(ctx, opts.From, opts.AsOf)
+		if err != nil {
+			return nil, err
+		}
+		opts.Snapshot = name
+	}
+	keys, err := restoreList(ctx, &opts)
+	if err != nil {
+		return nil, err
+	}
+	b := &BackupFS{ctx: ctx, from: opts.From, warnings: opts.Warnings, root: &backupNode{children: map[string]*backupNode{}}, metas: map[string]*ObjectMeta{}}
+	for _, key := range keys {
+		if strings.HasPrefix(key, BundlePrefix) {
+			continue // its files are added from the index
+		}
+		name, ok := opts.names[key]
+		if !ok {
+			name, _ = opts.Keys.Path(key)
+		}
+		b.add(name, &backupNode{key: key})
+	}
+	if opts.bundles != nil {
+		for _, key := range slices.Sorted(maps.Keys(opts.bundles.Files)) {
+			if name, ok := opts.Keys.Path(key); ok && opts.selected(name) {
+				e := opts.bundles.Files[key]
+				b.add(name, &backupNode{key: key, bundled: &e})
+			}
+		}
+	}
+	if opts.Snapshot != "" {
+		return b, nil // the indexes describe the latest run
+	}
+	links, err := ReadLinkIndex(ctx, opts.From)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range slices.Sorted(maps.Keys(links.Links)) {
+		name, ok := opts.Keys.Path(key)
+		if ok && opts.selected(name) && b.lookup(name) == nil {
+			b.add(name, &backupNode{key: links.Links[key]})
+		}
+	}
+	dirs, err := ReadDirIndex(ctx, opts.From)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range dirs.Dirs {
+		if name, ok := opts.Keys.Path(key); ok && opts.selected(name) && b.lookup(name) == nil {
+			b.add(name, &backupNode{children: map[string]*backupNode{}})
+		}
+	}
+	return b, nil
+}
+
+// add adds n to the tree at name, with the directories above it. A name
+// that a file already holds, where a directory is needed, or the other
+// way around, is left out with a warning.
+func (b *BackupFS) add(name string, n *backupNode) {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	dir := b.root
+	elems := strings.Split(name, "/")
+	for _, elem := range elems[:len(elems)-1] {
+		child := dir.children[elem]
+		if child == nil {
+			child = &backupNode{children: map[string]*backupNode{}}
+			dir.children[elem] = child
+		} else if !child.dir() {
+			warnf(b.warnings, "leaving out %s: %s is a file", name, child.key)
+			return
+		}
+		dir = child
+	}
+	if old := dir.children[elems[len(elems)-1]]; old != nil && old.dir() != n.dir() {
+		warnf(b.warnings, "leaving out %s: there is another file or directory of that name", name)
+		return
+	}
+	dir.children[elems[len(elems)-1]] = n
+}
+
+// lookup returns the node at name, a valid fs.FS path, or nil if there is
+// none.
+func (b *BackupFS) lookup(name string) *backupNode {
+	n := b.root
+	if name == "." {
+		return n
+	}
+	for elem := range strings.SplitSeq(name, "/") {
+		if !n.dir() {
+			return nil
+		}
+		if n = n.children[elem]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// node returns the node at name, failing as op on name as fs.FS methods
+// do if there is none.
+func (b *BackupFS) node(op, name string) (*backupNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n := b.lookup(name)
+	if n == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// meta returns the metadata of the file n, looking it up at the
+// destination the first time.
+func (b *BackupFS) meta(n *backupNode) (*ObjectMeta, error) {
+	if n.bundled != nil {
+		return n.bundled.meta(), nil
+	}
+	b.mu.Lock()
+	meta, ok := b.metas[n.key]
+	b.mu.Unlock()
+	if ok {
+		return meta, nil
+	}
+	meta, err := b.from.Stat(b.ctx, n.key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fs.ErrNotExist // deleted since it was listed
+	}
+	b.mu.Lock()
+	b.metas[n.key] = meta
+	b.mu.Unlock()
+	return meta, nil
+}
+
+func (b *BackupFS) info(name string, n *backupNode) (fs.FileInfo, error) {
+	if n.dir() {
+		return backupInfo{name: path.Base(name), mode: fs.ModeDir | 0555}, nil
+	}
+	meta, err := b.meta(n)
+	if err != nil {
+		return nil, err
+	}
+	mode := fs.FileMode(0444)
+	if meta.POSIX != nil {
+		mode = meta.POSIX.Mode.Perm() &^ 0222
+	}
+	return backupInfo{name: path.Base(name), size: meta.Size, mode: mode, modTime: meta.ModTime}, nil
+}
+
+// Stat implements fs.StatFS.
+func (b *BackupFS) Stat(name string) (fs.FileInfo, error) {
+	n, err := b.node("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.info(name, n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (b *BackupFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := b.node("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.dir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, 0, len(n.children))
+	for _, elem := range slices.Sorted(maps.Keys(n.children)) {
+		entries = append(entries, backupEntry{b: b, name: path.Join(name, elem), n: n.children[elem]})
+	}
+	return entries, nil
+}
+
+// Open implements fs.FS. Directories are read with ReadDir.
+func (b *BackupFS) Open(name string) (fs.File, error) {
+	n, err := b.node("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := b.info(name, n)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if n.dir() {
+		entries, _ := b.ReadDir(name)
+		return &backupDir{info: info, entries: entries}, nil
+	}
+	meta, _ := b.meta(n)
+	if meta.Archived {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("archived: restore it first")}
+	}
+	return &backupFile{b: b, name: name, n: n, meta: meta, info: info}, nil
+}
+
+// backupFile is an open file of a BackupFS.
+type backupFile struct {
+	b    *BackupFS
+	name string
+	n    *backupNode
+	meta *ObjectMeta
+	info fs.FileInfo
+
+	mu  gosync.Mutex
+	off int64         // of the next Read
+	rc  io.ReadCloser // the content from pos on, if open
+	pos int64
+}
+
+func (f *backupFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *backupFile) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	off := f.off
+	f.mu.Unlock()
+	n, err := f.ReadAt(p, off)
+	f.mu.Lock()
+	f.off += int64(n)
+	f.mu.Unlock()
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *backupFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.meta.Size {
+		return 0, io.EOF
+	}
+	want := min(int64(len(p)), f.meta.Size-off)
+	var n int
+	var err error
+	if rg, key, start, ok := f.ranged(); ok {
+		n, err = f.readRange(rg, key, p[:want], start+off)
+	} else {
+		n, err = f.readStream(p[:want], off)
+	}
+	if err == nil && int64(n) < int64(len(p)) {
+		err = io.EOF
+	}
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
+
+// ranged returns where the file's content may be read from in ranges: the
+// key of the object holding it, and where in the object it starts.
+func (f *backupFile) ranged() (RangeGetter, string, int64, bool) {
+	rg, ok := f.b.from.(RangeGetter)
+	switch {
+	case !ok:
+		return nil, "", 0, false
+	case f.n.bundled != nil:
+		return rg, f.n.bundled.Bundle, f.n.bundled.Offset, true
+	}
+	m := f.meta
+	return rg, f.n.key, 0, !m.Chunked && !m.Sparse && m.Compression == "" && m.Transform == ""
+}
+
+func (f *backupFile) readRange(rg RangeGetter, key string, p []byte, off int64) (int, error) {
+	rc, err := rg.GetRange(f.b.ctx, key, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	n, err := io.ReadFull(rc, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// readStream reads p at off from the content of the object as a stream,
+// which it opens again to go back. Bundled files are read from the
+// archive.
+func (f *backupFile) readStream(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rc != nil && off < f.pos {
+		f.rc.Close()
+		f.rc = nil
+	}
+	if f.rc == nil {
+		var rc io.ReadCloser
+		var err error
+		if e := f.n.bundled; e != nil {
+			// The content starts e.Offset bytes into the archive.
+			rc, err = get(f.b.ctx, f.b.from, e.Bundle)
+			f.pos = -e.Offset
+		} else {
+			rc, err = getContent(f.b.ctx, f.b.from, f.n.key, f.meta)
+			f.pos = 0
+		}
+		if err != nil {
+			return 0, err
+		}
+		f.rc = rc
+	}
+	if skip := off - f.pos; skip > 0 {
+		n, err := io.CopyN(io.Discard, f.rc, skip)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(f.rc, p)
+	f.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *backupFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rc != nil {
+		return f.rc.Close()
+	}
+	return nil
+}
+
+// backupDir is an open directory of a BackupFS.
+type backupDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry // not yet read
+}
+
+func (d *backupDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *backupDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *backupDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *backupDir) Close() error { return nil }
+
+// backupEntry is a fs.DirEntry of a BackupFS.
+type backupEntry struct {
+	b    *BackupFS
+	name string
+	n    *backupNode
+}
+
+func (e backupEntry) Name() string { return path.Base(e.name) }
+func (e backupEntry) IsDir() bool  { return e.n.dir() }
+
+func (e backupEntry) Type() fs.FileMode {
+	if e.n.dir() {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e backupEntry) Info() (fs.FileInfo, error) { return e.b.info(e.name, e.n) }
+
+// backupInfo is a fs.FileInfo of a BackupFS.
+type backupInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i backupInfo) Name() string       { return i.name }
+func (i backupInfo) Size() int64        { return i.size }
+func (i backupInfo) Mode() fs.FileMode  { return i.mode }
+func (i backupInfo) ModTime() time.Time { return i.modTime }
+func (i backupInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i backupInfo) Sys() any           { return nil }
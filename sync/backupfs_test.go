@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestBackupFS(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "docs/b.txt", strings.Repeat("b", 5000))
+	writeFile(t, src, "docs/c.log", strings.Repeat("c", 100))
+	writeFile(t, src, "small/d.txt", "d")
+	writeFile(t, src, "small/e.txt", "e")
+	if err := os.MkdirAll(filepath.Join(src, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	backup := t.TempDir()
+	dst := NewLocalDestination(backup)
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, KeepEmptyDirs: true, Bundle: &BundleOptions{Threshold: 10, MaxSize: 1 << 20}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, from := range []Destination{dst, newMockDest()} {
+		if from != dst {
+			// A destination that can only read objects from the start.
+			m := from.(*mockDest)
+			if err := dst.List(ctx, func(keys []string) error {
+				for _, key := range keys {
+					data, err := readObject(ctx, dst, key)
+					if err != nil {
+						return err
+					}
+					m.data[key] = data
+					m.objects[key], _ = dst.Stat(ctx, key)
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := OpenBackupFS(ctx, RestoreOptions{From: from})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fstest.TestFS(b, "a.txt", "docs/b.txt", "docs/c.log", "small/d.txt", "small/e.txt", "empty"); err != nil {
+			t.Errorf("%T: %v", from, err)
+		}
+
+		f, err := b.Open("docs/b.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, 10)
+		if n, err := f.(io.ReaderAt).ReadAt(p, 4995); n != 5 || err != io.EOF || string(p[:n]) != "bbbbb" {
+			t.Errorf("%T: ReadAt at 4995 = %d, %v, %q; want the last 5 bytes and EOF", from, n, err, p[:n])
+		}
+		f.Close()
+		info, err := fs.Stat(b, "small/e.txt")
+		if want, _ := os.Stat(filepath.Join(src, "small/e.txt")); err != nil || !info.ModTime().Equal(want.ModTime().Truncate(time.Second)) && !info.ModTime().Equal(want.ModTime()) {
+			t.Errorf("%T: Stat = %v, %v; want the mtime of the file", from, info, err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	stdsync "sync"
+	"time"
+)
+
+// ErrDestinationUnavailable is returned once a destination has failed
+// repeatedly and the circuit breaker refuses to keep trying.
+var ErrDestinationUnavailable = errors.New("destination unavailable")
+
+// BreakerOptions configures WithBreaker. Zero fields take the defaults
+// shown in parentheses.
+type BreakerOptions struct {
+	Retries   int           // retries per operation after the first attempt (2)
+	Backoff   time.Duration // delay before the first retry, doubled on each retry (1s)
+	Threshold int           // consecutive failures that open the circuit (5)
+	Cooldown  time.Duration // how long an open circuit pauses new operations (30s)
+}
+
+func (o BreakerOptions) withDefaults() BreakerOptions {
+	if o.Retries <= 0 {
+		o.Retries = 2
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+	if o.Threshold <= 0 {
+		o.Threshold = 5
+	}
+	if o.Cooldown <= 0 {
+		o.Cooldown = 30 * time.Second
+	}
+	return o
+}
+
+// WithBreaker wraps dst so that failed operations are retried with
+// exponential backoff, and so that after opts.Threshold consecutive
+// failures the destination is given opts.Cooldown to recover before a
+// single probe operation is let through, the others waiting for its
+// outcome. If the probe fails too, every operation fails fast with
+// ErrDestinationUnavailable instead of grinding through the remaining
+// files.
+func WithBreaker(dst Destination, opts BreakerOptions) Destination {
+	return &breakerDest{Destination: dst, opts: opts.withDefaults()}
+}
+
+type breakerDest struct {
+	Destination
+	opts BreakerOptions
+
+	// Guards the state of the circuit, for operations made from several
+	// goroutines at once, such as lookups with Options.StatConcurrency.
+	mu        stdsync.Mutex
+	failures  int           // consecutive failed attempts
+	openUntil time.Time     // zero while the circuit is closed
+	lastErr   error         // error that tripped the circuit
+	probing   chan struct{} // closed when the probe under way ends
+	dead      bool          // the post-cooldown probe failed
+}
+
+func (b *breakerDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	// A reader can only be consumed once, so a failed Put is retried only
+	// when it can be rewound.
+	seeker, _ := r.(io.Seeker)
+	rw, _ := r.(rewinder)
+	return b.do(ctx, seeker != nil || rw != nil, func() error {
+		switch {
+		case seeker != nil:
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		case rw != nil:
+			if err := rw.Rewind(); err != nil {
+				return err
+			}
+		}
+		return b.Destination.Put(ctx, key, r, meta)
+	})
+}
+
+// rewinder is implemented by bodies that cannot seek, as the uploader
+// would need to find their length, but can be read again from the start.
+type rewinder interface {
+	Rewind() error
+}
+
+func (b *breakerDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	var meta *ObjectMeta
+	err := b.do(ctx, true, func() (err error) {
+		meta, err = b.Destination.Stat(ctx, key)
+		return err
+	})
+	return meta, err
+}
+
+func (b *breakerDest) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	err := b.do(ctx, true, func() (err error) {
+		rc, err = get(ctx, b.Destination, key)
+		return err
+	})
+	return rc, err
+}
+
+func (b *breakerDest) Copy(ctx context.Context, src, dst string) error {
+	return b.do(ctx, true, func() error {
+		return copyObject(ctx, b.Destination, src, dst)
+	})
+}
+
+func (b *breakerDest) CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error {
+	return b.do(ctx, true, func() error {
+		return copyWithMeta(ctx, b.Destination, src, dst, meta)
+	})
+}
+
+// Append is retried, as Put is, only when tail can be rewound.
+func (b *breakerDest) Append(ctx context.Context, key string, size int64, tail io.Reader, meta ObjectMeta) error {
+	seeker, _ := tail.(io.Seeker)
+	return b.do(ctx, seeker != nil, func() error {
+		if seeker != nil {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		return appendObject(ctx, b.Destination, key, size, tail, meta)
+	})
+}
+
+func (b *breakerDest) Rename(ctx context.Context, src, dst string) error {
+	return b.do(ctx, true, func() error {
+		return renameObject(ctx, b.Destination, src, dst)
+	})
+}
+
+func (b *breakerDest) SetStorageClass(ctx context.Context, key, class string) error {
+	return b.do(ctx, true, func() error {
+		return setStorageClass(ctx, b.Destination, key, class)
+	})
+}
+
+// List retries a listing that fails partway from the start, passing on
+// only the keys after the last one fn was given. An error from fn is not
+// retried.
+func (b *breakerDest) List(ctx context.Context, fn func(keys []string) error) error {
+	var last string
+	var fnErr error
+	err := b.do(ctx, true, func() error {
+		err := b.Destination.List(ctx, func(keys []string) error {
+			i, _ := slices.BinarySearch(keys, last)
+			if last != "" && i < len(keys) && keys[i] == last {
+				i++
+			}
+			if keys = keys[i:]; len(keys) == 0 {
+				return nil
+			}
+			if fnErr = fn(keys); fnErr != nil {
+				return fnErr
+			}
+			last = keys[len(keys)-1]
+			return nil
+		})
+		if fnErr != nil {
+			return nil // not the destination's failure
+		}
+		return err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (b *breakerDest) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	var written map[string]time.Time
+	err := b.do(ctx, true, func() (err error) {
+		written, err = listWritten(ctx, b.Destination)
+		return err
+	})
+	return written, err
+}
+
+func (b *breakerDest) Delete(ctx context.Context, key string) error {
+	return b.do(ctx, true, func() error {
+		return b.Destination.Delete(ctx, key)
+	})
+}
+
+func (b *breakerDest) DeleteBatch(ctx context.Context, keys []string) ([]string, error) {
+	var deleted []string
+	err := b.do(ctx, true, func() (err error) {
+		// Deleting an absent object succeeds, so a retry may repeat keys.
+		deleted, err = deleteBatch(ctx, b.Destination, keys)
+		return err
+	})
+	return deleted, err
+}
+
+func (b *breakerDest) do(ctx context.Context, retryable bool, op func() error) error {
+	delay := b.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		probe, err := b.wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = op()
+		if err == nil {
+			b.mu.Lock()
+			b.failures = 0
+			b.openUntil = time.Time{}
+			b.endProbe(probe)
+			b.mu.Unlock()
+			return nil
+		}
+		if permanent(ctx, err) {
+			b.mu.Lock()
+			b.endProbe(probe) // the next operation probes instead
+			b.mu.Unlock()
+			return err
+		}
+
+		b.mu.Lock()
+		b.failures++
+		if probe {
+			// This was the probe after a cooldown; give up on the destination.
+			b.dead = true
+			b.lastErr = err
+			b.endProbe(probe)
+			err = b.unavailable()
+			b.mu.Unlock()
+			return err
+		}
+		if !b.openUntil.IsZero() {
+			// Another operation opened the circuit while this one ran.
+			b.mu.Unlock()
+			if !retryable {
+				return err
+			}
+			continue
+		}
+		if b.failures >= b.opts.Threshold {
+			b.openUntil = time.Now().Add(b.opts.Cooldown)
+			b.lastErr = err
+			b.mu.Unlock()
+			if !retryable {
+				return err // the probe is left to the next operation
+			}
+			continue
+		}
+		b.mu.Unlock()
+		if !retryable || attempt >= b.opts.Retries {
+			return err
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// wait blocks while the circuit is open, and fails fast once the
+// destination has been declared unavailable. Once the cooldown is over it
+// lets one operation through as the probe, reporting so, and holds the
+// others until the probe ends.
+func (b *breakerDest) wait(ctx context.Context) (probe bool, err error) {
+	for {
+		b.mu.Lock()
+		if b.dead {
+			defer b.mu.Unlock()
+			return false, b.unavailable()
+		}
+		until, probing := b.openUntil, b.probing
+		switch {
+		case until.IsZero():
+			b.mu.Unlock()
+			return false, nil
+		case probing != nil:
+			b.mu.Unlock()
+			select {
+			case <-probing:
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+		case time.Now().Before(until):
+			b.mu.Unlock()
+			if err := sleep(ctx, time.Until(until)); err != nil {
+				return false, err
+			}
+		default:
+			b.probing = make(chan struct{})
+			b.mu.Unlock()
+			return true, nil
+		}
+	}
+}
+
+// endProbe lets the operations waiting on the probe through, if probe is
+// set. b.mu must be held.
+func (b *breakerDest) endProbe(probe bool) {
+	if probe {
+		close(b.probing)
+		b.probing = nil
+	}
+}
+
+// unavailable returns the error operations fail with once the destination
+// is given up on. b.mu must be held.
+func (b *breakerDest) unavailable() error {
+	return fmt.Errorf("%w after %d consecutive failures: %v", ErrDestinationUnavailable, b.failures, b.lastErr)
+}
+
+// permanent reports whether retrying err is pointless.
+func permanent(ctx context.Context, err error) bool {
+	return ctx.Err() != nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, ErrReadOnly) ||
+		errors.Is(err, ErrObjectLocked) ||
+		errors.Is(err, errors.ErrUnsupported) ||
+		errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, fs.ErrPermission)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,275 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	stdsync "sync"
+	"testing"
+	"time"
+)
+
+// flakyDest fails the first n calls to any method, then delegates to mockDest.
+type flakyDest struct {
+	*mockDest
+	failures int
+	calls    int
+}
+
+var errFlaky = errors.New("connection reset")
+
+func (f *flakyDest) fail() error {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return errFlaky
+	}
+	return nil
+}
+
+func (f *flakyDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.mockDest.Stat(ctx, key)
+}
+
+// Put fails after reading some of r, as a dropped connection does.
+func (f *flakyDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := f.fail(); err != nil {
+		io.CopyN(io.Discard, r, 10)
+		return err
+	}
+	return f.mockDest.Put(ctx, key, r, meta)
+}
+
+func TestWithBreaker_retriesTransientFailures(t *testing.T) {
+	inner := &flakyDest{mockDest: newMockDest(), failures: 2}
+	dst := WithBreaker(inner, BreakerOptions{Retries: 3, Backoff: time.Millisecond})
+
+	if _, err := dst.Stat(context.Background(), "a.txt"); err != nil {
+		t.Fatalf("expected retries to succeed, got %v", err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", inner.calls)
+	}
+}
+
+func TestWithBreaker_opensAfterThreshold(t *testing.T) {
+	inner := &flakyDest{mockDest: newMockDest(), failures: 1000}
+	dst := WithBreaker(inner, BreakerOptions{
+		Retries:   10,
+		Backoff:   time.Millisecond,
+		Threshold: 3,
+		Cooldown:  time.Millisecond,
+	})
+	ctx := context.Background()
+
+	_, err := dst.Stat(ctx, "a.txt")
+	if !errors.Is(err, ErrDestinationUnavailable) {
+		t.Fatalf("expected ErrDestinationUnavailable, got %v", err)
+	}
+	if !strings.Contains(err.Error(), errFlaky.Error()) {
+		t.Errorf("expected error to mention the underlying failure, got %v", err)
+	}
+	// Three failures open the circuit, then one probe after the cooldown.
+	if inner.calls != 4 {
+		t.Errorf("expected 4 attempts, got %d", inner.calls)
+	}
+
+	before := inner.calls
+	if _, err := dst.Stat(ctx, "b.txt"); !errors.Is(err, ErrDestinationUnavailable) {
+		t.Errorf("expected subsequent calls to fail fast, got %v", err)
+	}
+	if inner.calls != before {
+		t.Errorf("expected no attempts once unavailable, got %d", inner.calls-before)
+	}
+}
+
+func TestWithBreaker_recoversAfterCooldown(t *testing.T) {
+	inner := &flakyDest{mockDest: newMockDest(), failures: 2}
+	dst := WithBreaker(inner, BreakerOptions{
+		Retries:   5,
+		Backoff:   time.Millisecond,
+		Threshold: 2,
+		Cooldown:  time.Millisecond,
+	})
+	ctx := context.Background()
+
+	// Two failures open the circuit; the probe after the cooldown succeeds.
+	if _, err := dst.Stat(ctx, "a.txt"); err != nil {
+		t.Fatalf("expected probe to succeed, got %v", err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", inner.calls)
+	}
+	if _, err := dst.Stat(ctx, "a.txt"); err != nil {
+		t.Errorf("expected circuit to be closed again, got %v", err)
+	}
+}
+
+func TestWithBreaker_permissionNotRetried(t *testing.T) {
+	inner := &flakyDest{mockDest: newMockDest()}
+	dst := WithBreaker(&forbiddenDest{inner}, BreakerOptions{Retries: 3, Backoff: time.Millisecond})
+
+	if _, err := dst.Stat(context.Background(), "a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected fs.ErrPermission, got %v", err)
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected 1 attempt, got %d", inner.calls)
+	}
+}
+
+// forbiddenDest refuses every lookup, counting them, as a bucket policy
+// might.
+type forbiddenDest struct{ *flakyDest }
+
+func (d *forbiddenDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	d.fail()
+	return nil, fs.ErrPermission
+}
+
+// probeDest fails its first lookup, then holds the others until release is
+// closed, counting how many are under way at once.
+type probeDest struct {
+	*mockDest
+	release chan struct{}
+
+	mu            stdsync.Mutex
+	calls, active int
+	maxActive     int
+}
+
+func (p *probeDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	p.mu.Lock()
+	p.calls++
+	if p.calls == 1 {
+		p.mu.Unlock()
+		return nil, errFlaky
+	}
+	p.active++
+	p.maxActive = max(p.maxActive, p.active)
+	p.mu.Unlock()
+	<-p.release
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	return p.mockDest.Stat(ctx, key)
+}
+
+func TestWithBreaker_singleProbe(t *testing.T) {
+	inner := &probeDest{mockDest: newMockDest(), release: make(chan struct{})}
+	dst := WithBreaker(inner, BreakerOptions{
+		Retries:   1,
+		Backoff:   time.Millisecond,
+		Threshold: 1,
+		Cooldown:  time.Millisecond,
+	})
+	ctx := context.Background()
+
+	// The first lookup opens the circuit and, after the cooldown, probes;
+	// the others arrive while the probe is held.
+	errs := make(chan error, 4)
+	for range 4 {
+		go func() {
+			_, err := dst.Stat(ctx, "a.txt")
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(inner.release)
+	for range 4 {
+		if err := <-errs; err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the lookups to go through after the probe, got %v", err)
+		}
+	}
+	if inner.maxActive != 1 {
+		t.Errorf("expected the probe alone under way, got %d lookups at once", inner.maxActive)
+	}
+}
+
+func TestWithBreaker_retriesCompressedUpload(t *testing.T) {
+	src := t.TempDir()
+	content := strings.Repeat("all work and no play makes jack a dull boy\n", 1000)
+	writeFile(t, src, "a.txt", content)
+	inner := &flakyDest{mockDest: newMockDest(), failures: 1}
+	opts := Options{
+		Dst:         WithBreaker(inner, BreakerOptions{Retries: 1, Backoff: time.Millisecond}),
+		Compression: CompressGzip,
+	}
+	info, err := os.Stat(filepath.Join(src, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := File{Key: "a.txt", Path: filepath.Join(src, "a.txt"), Size: info.Size(), ModTime: info.ModTime()}
+
+	if err := upload(context.Background(), opts, u, false); err != nil {
+		t.Fatalf("upload = %v, want it retried", err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("got %d attempts, want 2", inner.calls)
+	}
+	rc, err := decompress(CompressGzip, bytes.NewReader(inner.data["a.txt"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if got, err := io.ReadAll(rc); err != nil || string(got) != content {
+		t.Errorf("stored %d bytes, err %v; want the whole file compressed from the start", len(got), err)
+	}
+}
+
+func TestWithBreaker_keepsUnrewindableBody(t *testing.T) {
+	inner := &flakyDest{mockDest: newMockDest(), failures: 1}
+	dst := WithBreaker(inner, BreakerOptions{Threshold: 1, Cooldown: time.Millisecond})
+	body := io.MultiReader(strings.NewReader("abcdefghijklmnopqrst")) // not a Seeker
+	if err := dst.Put(context.Background(), "a.txt", body, ObjectMeta{Size: 20}); !errors.Is(err, errFlaky) {
+		t.Errorf("Put = %v, want the failure, as the body cannot be read again", err)
+	}
+	if data, ok := inner.data["a.txt"]; ok || inner.calls != 1 {
+		t.Errorf("stored %q after %d attempts, want nothing stored after 1", data, inner.calls)
+	}
+}
+
+// pagedDest lists a mockDest a key per page, failing once after the first
+// failAfter pages.
+type pagedDest struct {
+	*mockDest
+	failAfter int
+}
+
+func (d *pagedDest) List(ctx context.Context, fn func(keys []string) error) error {
+	keys, _ := listKeys(ctx, d.mockDest)
+	for i, key := range keys {
+		if i == d.failAfter {
+			d.failAfter = -1
+			return errFlaky
+		}
+		if err := fn([]string{key}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWithBreaker_listResumes(t *testing.T) {
+	inner := &pagedDest{mockDest: newMockDest(), failAfter: 2}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		inner.objects[key] = &ObjectMeta{}
+	}
+	dst := WithBreaker(inner, BreakerOptions{Backoff: time.Millisecond})
+
+	keys, err := listKeys(context.Background(), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c", "d"}; !slices.Equal(keys, want) {
+		t.Errorf("listed %v, want each key once: %v", keys, want)
+	}
+}
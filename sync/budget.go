@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBudget is returned once a run has used up Options.MaxDuration or
+// Options.MaxTransfer. Like ErrRequestLimit, Sync stops early, keeps what
+// it has done and leaves the rest for the next run.
+var ErrBudget = errors.New("run budget used up")
+
+// overBudget reports whether the run of plan is to start no more
+// uploads, before one of size bytes: whether it has run for
+// opts.MaxDuration, or the upload would take it past opts.MaxTransfer. The
+// first upload of a run is always started. If so, plan is marked
+// incomplete, recording which budget was used up.
+func overBudget(opts Options, plan *Plan, size int64) bool {
+	if opts.DryRun {
+		return false
+	}
+	switch {
+	case !opts.deadline.IsZero() && !time.Now().Before(opts.deadline):
+		plan.budget = fmt.Sprintf("ran for %s", opts.MaxDuration)
+	case opts.MaxTransfer > 0 && plan.uploaded > 0 && plan.uploadedBytes+size > opts.MaxTransfer:
+		plan.budget = fmt.Sprintf("uploaded %s of %s", formatBytes(plan.uploadedBytes), formatBytes(opts.MaxTransfer))
+	default:
+		return false
+	}
+	plan.Incomplete = true
+	return true
+}
+
+// checkBudget reports whether opts sets a valid budget.
+func checkBudget(opts Options) error {
+	if opts.MaxDuration < 0 || opts.MaxTransfer < 0 {
+		return errors.New("the run budget must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSync_maxTransfer(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, src, name, "1234")
+	}
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, MaxTransfer: 6, StateCache: filepath.Join(t.TempDir(), "state")}
+
+	// Each run makes its first upload, but not a second that would go
+	// past the budget.
+	for i, want := range [][]string{{"a.txt"}, {"b.txt"}, {"c.txt"}} {
+		dst.putCalls = nil
+		_, err := Sync(context.Background(), opts)
+		if i < 2 && !errors.Is(err, ErrBudget) {
+			t.Fatalf("run %d: err = %v, want ErrBudget", i, err)
+		} else if i == 2 && err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if got := dst.putCalls; !slices.Equal(got, want) {
+			t.Errorf("run %d uploaded %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSync_maxDuration(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	res, err := Sync(context.Background(), Options{Src: src, Dst: dst, MaxDuration: time.Nanosecond, Delete: true})
+	if !errors.Is(err, ErrBudget) {
+		t.Fatalf("err = %v, want ErrBudget", err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("uploaded %v after the budget was used up", dst.putCalls)
+	}
+	if s := res.Summary; s.Status() != "incomplete" {
+		t.Errorf("status = %q, want incomplete", s.Status())
+	}
+
+	// Dry runs are not budgeted.
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, MaxDuration: time.Nanosecond, DryRun: true}); err != nil {
+		t.Errorf("dry run: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package sync
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	// BundlePrefix is the key prefix bundle archives are stored under.
+	BundlePrefix = metaPrefix + "bundles/"
+	// BundleIndexKey is the key of the BundleIndex.
+	BundleIndexKey = BundlePrefix + "index.json"
+)
+
+// BundleOptions configures bundling: small files are packed into tar
+// archives instead of being uploaded one object each, which saves a PUT
+// request per file and, on storage classes with a minimum billable object
+// size such as GLACIER_IR (128 KB), the cost of that minimum.
+type BundleOptions struct {
+	// Threshold is the size below which files are bundled.
+	Threshold int64
+	// MaxSize caps the size of each archive. A file is never split, so an
+	// archive may exceed it by up to Threshold.
+	MaxSize int64
+}
+
+// BundleIndex maps the key of every bundled file to where it is stored.
+// It is kept at BundleIndexKey.
+type BundleIndex struct {
+	Files map[string]BundleEntry `json:"files"`
+}
+
+// BundleEntry locates a bundled file.
+type BundleEntry struct {
+	Bundle  string      `json:"bundle"` // key of the archive
+	Offset  int64       `json:"offset"` // of the file's content in the archive
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	POSIX   *POSIXAttrs `json:"posix,omitempty"`
+}
+
+func (e BundleEntry) meta() *ObjectMeta {
+	return &ObjectMeta{Size: e.Size, ModTime: e.ModTime, POSIX: e.POSIX}
+}
+
+// ReadBundleIndex reads the bundle index of dst. If there is none, it
+// returns an empty index.
+func ReadBundleIndex(ctx context.Context, dst Destination) (*BundleIndex, error) {
+	idx := &BundleIndex{Files: make(map[string]BundleEntry)}
+	rc, err := get(ctx, dst, BundleIndexKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read bundle index: %w", err)
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(idx); err != nil {
+		return nil, fmt.Errorf("read bundle index: %w", err)
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]BundleEntry)
+	}
+	return idx, nil
+}
+
+func writeBundleIndex(ctx context.Context, dst Destination, idx *BundleIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	meta := ObjectMeta{Size: int64(len(data)), ModTime: time.Now(), ContentType: "application/json"}
+	if err := dst.Put(ctx, BundleIndexKey, bytes.NewReader(data), meta); err != nil {
+		return fmt.Errorf("write bundle index: %w", err)
+	}
+	return nil
+}
+
+// bundles reports whether file is to be bundled.
+func (o *BundleOptions) bundles(file File) bool {
+	return o != nil && file.Size < o.Threshold
+}
+
+// planBundled adds file, which is to be bundled, to plan, and to
+// plan.Bundled unless the index holds an up to date copy.
+func planBundled(opts Options, plan *Plan, file File) error {
+	if e, ok := plan.bundles.Files[file.Key]; ok {
+		file.Remote = e.meta()
+	}
+	plan.Files = append(plan.Files, file)
+	r := file.Remote
+	if r != nil && !matchKey(opts.Reupload, file.Key) && r.Size == file.Size &&
+		sameModTime(r.ModTime, file.ModTime, opts.ModTimeWindow) && (!opts.PreservePOSIX || file.POSIX.Equal(r.POSIX)) {
+		return plan.state.record(file)
+	}
+	plan.Bundled = append(plan.Bundled, file)
+	return nil
+}
+
+// planUnbundle adds the bundled files that are to leave the index to plan:
+// those uploaded as objects of their own and, with opts.Delete, those
+// absent from the source.
+func planUnbundle(opts Options, plan *Plan) {
+	if plan.bundles == nil {
+		return
+	}
+	bundled := make(map[string]bool)
+	for _, f := range plan.Files {
+		bundled[f.Key] = opts.Bundle.bundles(f)
+	}
+	for _, key := range slices.Sorted(maps.Keys(plan.bundles.Files)) {
+		b, ok := bundled[key]
+		if ok && !b || !ok && opts.Delete {
+			plan.Unbundle = append(plan.Unbundle, key)
+		}
+	}
+}
+
+// applyBundles packs plan.Bundled into archives of up to opts.Bundle.MaxSize,
+// uploads them and updates the index after each, then drops plan.Unbundle
+// from the index and deletes archives that no longer hold any file.
+func applyBundles(ctx context.Context, opts Options, plan *Plan) error {
+	if len(plan.Bundled) == 0 && len(plan.Unbundle) == 0 {
+		return nil
+	}
+	for _, key := range plan.Unbundle {
+		opts.report(Event{Action: "unbundle", Key: key})
+	}
+	if opts.DryRun {
+		for _, f := range plan.Bundled {
+			opts.report(Event{Action: "bundle", Key: f.Key})
+		}
+		return nil
+	}
+
+	idx := plan.bundles
+	before := liveBundles(idx)
+	prefix := fmt.Sprintf("%s%d-", BundlePrefix, time.Now().UnixNano())
+	files := plan.Bundled
+	for n := 0; len(files) > 0; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if overBudget(opts, plan, 0) {
+			return nil
+		}
+		key := fmt.Sprintf("%s%d.tar", prefix, n)
+		packed, err := uploadBundle(ctx, opts, key, files, idx)
+		if errors.Is(err, ErrRequestLimit) {
+			plan.Incomplete = true
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("upload bundle %s: %w", key, err)
+		}
+		if err := writeBundleIndex(ctx, opts.Dst, idx); errors.Is(err, ErrRequestLimit) {
+			plan.Incomplete = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, f := range files[:packed] {
+			if err := plan.state.record(f); err != nil {
+				return err
+			}
+			plan.uploadedBytes += f.Size
+		}
+		plan.uploaded += packed
+		files = files[packed:]
+	}
+
+	if len(plan.Unbundle) > 0 {
+		for _, key := range plan.Unbundle {
+			delete(idx.Files, key)
+		}
+		if err := writeBundleIndex(ctx, opts.Dst, idx); err != nil {
+			return err
+		}
+	}
+	after := liveBundles(idx)
+	for _, key := range slices.Sorted(maps.Keys(before)) {
+		if after[key] {
+			continue
+		}
+		opts.report(Event{Action: "delete", Key: key})
+		if err := opts.Dst.Delete(ctx, key); err != nil {
+			return &FileError{Op: "delete", Key: key, Err: err}
+		}
+	}
+	return nil
+}
+
+// liveBundles returns the keys of the archives idx refers to.
+func liveBundles(idx *BundleIndex) map[string]bool {
+	live := make(map[string]bool)
+	for _, e := range idx.Files {
+		live[e.Bundle] = true
+	}
+	return live
+}
+
+// uploadBundle packs files, from the first, into an archive until it
+// reaches opts.Bundle.MaxSize, uploads it as key and records the files in
+// idx. It returns the number of files packed.
+func uploadBundle(ctx context.Context, opts Options, key string, files []File, idx *BundleIndex) (int, error) {
+	tmp, err := os.CreateTemp("", "foldersync-bundle-*.tar")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	cw := &countingWriter{w: tmp}
+	tw := tar.NewWriter(cw)
+	entries := make(map[string]BundleEntry)
+	n := 0
+	for _, f := range files {
+		if n > 0 && cw.n+f.Size > opts.Bundle.MaxSize {
+			break
+		}
+		opts.report(Event{Action: "bundle", Key: f.Key})
+		e, err := addToBundle(tw, cw, f)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", f.Key, err)
+		}
+		e.Bundle = key
+		entries[f.Key] = e
+		n++
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	meta := ObjectMeta{Size: cw.n, ModTime: time.Now(), ContentType: "application/x-tar", Tags: opts.Tags, Lock: opts.ObjectLock}
+	if err := opts.Dst.Put(ctx, key, tmp, meta); err != nil {
+		return 0, err
+	}
+	if err := verifyUpload(ctx, opts, key, meta); err != nil {
+		return 0, err
+	}
+	maps.Copy(idx.Files, entries)
+	return n, nil
+}
+
+// addToBundle writes f to tw, which writes to cw.
+func addToBundle(tw *tar.Writer, cw *countingWriter, f File) (BundleEntry, error) {
+	src, err := os.Open(f.Path)
+	if err != nil {
+		return BundleEntry{}, err
+	}
+	defer src.Close()
+
+	mode := int64(0644)
+	if f.POSIX != nil {
+		mode = int64(f.POSIX.Mode.Perm())
+	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     f.Key,
+		Size:     f.Size,
+		Mode:     mode,
+		ModTime:  f.ModTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return BundleEntry{}, err
+	}
+	e := BundleEntry{
+		Offset:  cw.n,
+		Size:    f.Size,
+		ModTime: f.ModTime.Truncate(time.Second),
+		POSIX:   f.POSIX,
+	}
+	// The file may have changed since it was planned; the archive records
+	// the size it had then.
+	if _, err := io.CopyN(tw, src, f.Size); err != nil {
+		return BundleEntry{}, err
+	}
+	return e, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// extractBundle downloads the archive bundle and extracts the files idx
+// places in it into to, at the paths keys maps them to, reapplying their
+// recorded metadata, and printing each to log. If want is set, only the
+// files it reports are wanted are extracted.
+func extractBundle(ctx context.Context, from Destination, to *LocalDestination, bundle string, idx *BundleIndex, keys KeyMapper, log, warnings io.Writer,
+	want func(key, name string, meta *ObjectMeta) (bool, error)) error {
+	rc, err := get(ctx, from, bundle)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Files re-bundled since, or deleted, are skipped.
+		e, ok := idx.Files[hdr.Name]
+		if !ok || e.Bundle != bundle {
+			continue
+		}
+		name, ok := keys.Path(hdr.Name)
+		if !ok {
+			continue
+		}
+		if want != nil {
+			if ok, err := want(hdr.Name, name, e.meta()); err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			} else if !ok {
+				continue
+			}
+		}
+		fmt.Fprintf(logTo(log), "restore %s\n", hdr.Name)
+		if err := to.Put(ctx, localName(name, warnings), tr, *e.meta()); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bundleKeysOf returns the bundle archives held by dst, in key order.
+func bundleKeysOf(dst *mockDest) []string {
+	var keys []string
+	for key := range dst.objects {
+		if strings.HasPrefix(key, BundlePrefix) && key != BundleIndexKey {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestSync_bundle(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "docs/b.txt", "bravo")
+	writeFile(t, src, "big.bin", strings.Repeat("x", 100))
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, Bundle: &BundleOptions{Threshold: 10, MaxSize: 1 << 20}}
+	ctx := context.Background()
+
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dst.objects["a.txt"]; ok {
+		t.Error("a.txt uploaded as an object of its own")
+	}
+	if _, ok := dst.objects["big.bin"]; !ok {
+		t.Error("big.bin not uploaded")
+	}
+	first := bundleKeysOf(dst)
+	if len(first) != 1 {
+		t.Fatalf("bundles = %v, want 1", first)
+	}
+	idx, err := ReadBundleIndex(ctx, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := idx.Files["docs/b.txt"]
+	if e.Bundle != first[0] || e.Size != 5 {
+		t.Fatalf("index entry for docs/b.txt = %+v", e)
+	}
+	if got := string(dst.data[e.Bundle][e.Offset : e.Offset+e.Size]); got != "bravo" {
+		t.Errorf("content at offset %d = %q, want %q", e.Offset, got, "bravo")
+	}
+
+	// Nothing changed: nothing is uploaded.
+	dst.putCalls = nil
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("unchanged run uploaded %v", dst.putCalls)
+	}
+
+	// A changed file goes into a new bundle; the old one still holds b.txt.
+	writeFile(t, src, "a.txt", "alpha2")
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if keys := bundleKeysOf(dst); len(keys) != 2 {
+		t.Fatalf("bundles = %v, want 2", keys)
+	}
+
+	// Once b.txt is gone, so is the first bundle.
+	os.Remove(filepath.Join(src, "docs/b.txt"))
+	opts.Delete = true
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if keys := bundleKeysOf(dst); len(keys) != 1 || keys[0] == first[0] {
+		t.Errorf("bundles = %v, want only the second", keys)
+	}
+	if idx, _ := ReadBundleIndex(ctx, dst); len(idx.Files) != 1 {
+		t.Errorf("index = %+v, want a.txt only", idx.Files)
+	}
+
+	out := t.TempDir()
+	if err := Restore(ctx, RestoreOptions{From: dst, To: out}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLocal(t, out, "a.txt"); got != "alpha2" {
+		t.Errorf("restored a.txt = %q", got)
+	}
+	if got := readLocal(t, out, "big.bin"); len(got) != 100 {
+		t.Errorf("restored big.bin has %d bytes", len(got))
+	}
+	if _, err := os.Stat(filepath.Join(out, "docs/b.txt")); !os.IsNotExist(err) {
+		t.Errorf("deleted docs/b.txt restored: %v", err)
+	}
+	srcInfo, _ := os.Stat(filepath.Join(src, "a.txt"))
+	outInfo, _ := os.Stat(filepath.Join(out, "a.txt"))
+	if !outInfo.ModTime().Equal(srcInfo.ModTime().Truncate(time.Second)) {
+		t.Errorf("restored mtime %v, want %v", outInfo.ModTime(), srcInfo.ModTime())
+	}
+}
+
+func TestSync_bundleMaxSize(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		writeFile(t, src, name, name)
+	}
+	dst := newMockDest()
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, Bundle: &BundleOptions{Threshold: 10, MaxSize: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A bundle always takes one file, however small MaxSize is.
+	if keys := bundleKeysOf(dst); len(keys) != 3 {
+		t.Errorf("bundles = %v, want one per file", keys)
+	}
+}
+
+func TestSync_bundleNeedsGetter(t *testing.T) {
+	dst := struct{ Destination }{newMockDest()}
+	_, err := Sync(context.Background(), Options{Src: t.TempDir(), Dst: dst, Bundle: &BundleOptions{Threshold: 10, MaxSize: 100}})
+	if err == nil {
+		t.Error("bundling to a destination without Get succeeded")
+	}
+}
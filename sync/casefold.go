@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrCaseCollision is returned by Sync with CaseCollisionsFail when the
+// paths of source files differ only in case.
+var ErrCaseCollision = errors.New("paths differ only in case")
+
+// CaseCollisionPolicy decides what Sync does with source files whose paths
+// differ only in case, such as Foo.txt and foo.txt, which a case-sensitive
+// file system holds side by side but a case-insensitive one, as macOS and
+// Windows use, cannot: restoring both there would write one over the
+// other.
+type CaseCollisionPolicy int
+
+const (
+	// CaseCollisionsIgnore does not look for them.
+	CaseCollisionsIgnore CaseCollisionPolicy = iota
+	// CaseCollisionsWarn uploads them as they are, with a warning to
+	// Options.Warnings.
+	CaseCollisionsWarn
+	// CaseCollisionsFail stops the run before anything is changed,
+	// listing them.
+	CaseCollisionsFail
+	// CaseCollisionsRename stores every file after the first, in walk
+	// order, under its name with ".case-N" added before the extension,
+	// e.g. "foo.case-2.txt", so that they restore side by side.
+	CaseCollisionsRename
+)
+
+// ParseCaseCollisionPolicy parses the names used on the command line:
+// ignore, warn, fail and rename.
+func ParseCaseCollisionPolicy(s string) (CaseCollisionPolicy, error) {
+	switch s {
+	case "ignore":
+		return CaseCollisionsIgnore, nil
+	case "warn":
+		return CaseCollisionsWarn, nil
+	case "fail":
+		return CaseCollisionsFail, nil
+	case "rename":
+		return CaseCollisionsRename, nil
+	}
+	return 0, fmt.Errorf("unknown case collision policy %q (valid: ignore, warn, fail, rename)", s)
+}
+
+// caseNames finds the source files of a run whose paths differ only in
+// case.
+type caseNames struct {
+	policy CaseCollisionPolicy
+	fold   cases.Caser
+	seen   map[string]string // folded paths to the paths walked
+
+	collisions []string // "a and b", with CaseCollisionsFail
+}
+
+// newCaseNames returns nil unless opts.CaseCollisions looks for collisions.
+func newCaseNames(opts Options) *caseNames {
+	if opts.CaseCollisions == CaseCollisionsIgnore {
+		return nil
+	}
+	return &caseNames{policy: opts.CaseCollisions, fold: cases.Fold(), seen: make(map[string]string)}
+}
+
+// folded returns name as a case-insensitive file system compares it. Names
+// composed and decomposed are folded alike, as macOS compares them.
+func (c *caseNames) folded(name string) string {
+	return norm.NFC.String(c.fold.String(name))
+}
+
+// check records the file at rel, inside the source stored under prefix,
+// and returns the path to store it under: rel itself, or with
+// CaseCollisionsRename, a new name if it collides with a file checked
+// before it.
+func (c *caseNames) check(opts Options, prefix, rel string) string {
+	if c == nil {
+		return rel
+	}
+	name := prefix + opts.UnicodeForm.normalize(rel)
+	folded := c.folded(name)
+	first, ok := c.seen[folded]
+	if !ok {
+		c.seen[folded] = name
+		return rel
+	}
+	switch c.policy {
+	case CaseCollisionsWarn:
+		opts.warnf("%s and %s differ only in case, and cannot both be restored to a case-insensitive file system", first, name)
+	case CaseCollisionsFail:
+		c.collisions = append(c.collisions, first+" and "+name)
+	case CaseCollisionsRename:
+		dir, base := path.Split(rel)
+		ext := path.Ext(base)
+		for n := 2; ; n++ {
+			renamed := dir + strings.TrimSuffix(base, ext) + ".case-" + strconv.Itoa(n) + ext
+			name := prefix + opts.UnicodeForm.normalize(renamed)
+			if folded := c.folded(name); c.seen[folded] == "" {
+				c.seen[folded] = name
+				return renamed
+			}
+		}
+	}
+	return rel
+}
+
+// err returns the collisions found with CaseCollisionsFail.
+func (c *caseNames) err() error {
+	if c == nil || len(c.collisions) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrCaseCollision, strings.Join(c.collisions, ", "))
+}
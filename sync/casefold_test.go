@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestSync_caseCollisions(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "Foo.txt", "upper")
+	writeFile(t, src, "foo.txt", "lower")
+	writeFile(t, src, "FOO.txt", "shout")
+	writeFile(t, src, "docs/a.txt", "a")
+	writeFile(t, src, "Docs/A.txt", "A")
+	writeFile(t, src, "bar.txt", "bar")
+	if entries, _ := os.ReadDir(src); len(entries) != 6 {
+		t.Skip("file system is not case-sensitive")
+	}
+
+	dst := newMockDest()
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, CaseCollisions: CaseCollisionsFail})
+	if !errors.Is(err, ErrCaseCollision) || len(dst.putCalls) != 0 {
+		t.Fatalf("fail: %v, uploaded %v; want ErrCaseCollision before uploading", err, dst.putCalls)
+	}
+
+	dst = newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, CaseCollisions: CaseCollisionsRename}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Docs/A.txt", "FOO.txt", "Foo.case-2.txt", "bar.txt", "docs/a.case-2.txt", "foo.case-3.txt"}
+	if got := slices.Sorted(maps.Keys(dst.data)); !slices.Equal(got, want) {
+		t.Errorf("rename: stored %v, want %v", got, want)
+	}
+	if string(dst.data["foo.case-3.txt"]) != "lower" {
+		t.Errorf("foo.case-3.txt holds %q", dst.data["foo.case-3.txt"])
+	}
+}
+
+func TestSync_caseCollisionsDelete(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "Foo.txt", "upper")
+	writeFile(t, src, "foo.txt", "lower")
+	if entries, _ := os.ReadDir(src); len(entries) != 2 {
+		t.Skip("file system is not case-sensitive")
+	}
+	keys := syncTwiceKeepsAll(t, Options{Src: src, CaseCollisions: CaseCollisionsRename})
+	if want := []string{"Foo.txt", "foo.case-2.txt"}; !slices.Equal(keys, want) {
+		t.Errorf("stored %q, want %q", keys, want)
+	}
+}
+
+func TestCaseNames_renamedCollides(t *testing.T) {
+	c := newCaseNames(Options{CaseCollisions: CaseCollisionsRename})
+	for _, rel := range []string{"a.case-2.txt", "A.txt"} {
+		if got := c.check(Options{}, "p/", rel); got != rel {
+			t.Errorf("check(%s) = %s", rel, got)
+		}
+	}
+	// The first new name is taken, by a file of that name.
+	if got := c.check(Options{}, "p/", "a.txt"); got != "a.case-3.txt" {
+		t.Errorf("check(a.txt) = %s, want a.case-3.txt", got)
+	}
+	// Names are compared composed.
+	if got := c.check(Options{UnicodeForm: UnicodeAsIs}, "p/", "Café"); got != "Café" {
+		t.Errorf("check(Café) = %s", got)
+	}
+	if got := c.check(Options{UnicodeForm: UnicodeAsIs}, "p/", "café"); got != "café.case-2" {
+		t.Errorf("check(café) = %q, want it renamed", got)
+	}
+}
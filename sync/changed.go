@@ -0,0 +1,57 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrFileChanged is returned when a source file is no longer the size or
+// modification time it was found with once its content has been read for
+// an upload, so that the object may mix its old content and new.
+var ErrFileChanged = errors.New("file changed while it was uploaded")
+
+// changeRetries is how many more times a file that changed while it was
+// uploaded is uploaded again before it is left as it is.
+const changeRetries = 2
+
+// checkUnchanged fails with ErrFileChanged unless f, opened for u, is
+// still the size and modification time of u.
+func checkUnchanged(f *os.File, u File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != u.Size || !info.ModTime().Equal(u.ModTime) {
+		return fmt.Errorf("%w: was %d bytes modified %s, now %d bytes modified %s", ErrFileChanged,
+			u.Size, u.ModTime.Format(time.RFC3339Nano), info.Size(), info.ModTime().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// uploadSettled uploads u, and uploads it again if it changed while it
+// was read, with the size and modification time it changed to, up to
+// changeRetries times. A file still changing after that is left with the
+// object of its last upload, added to plan.changed and forgotten, so that
+// the next run uploads it again. u is updated to the file as uploaded.
+func uploadSettled(ctx context.Context, opts Options, plan *Plan, u *File) error {
+	for retry := 0; ; retry++ {
+		err := uploadUnstalled(ctx, opts, *u, plan.state.wantsHash(u.Key))
+		if !errors.Is(err, ErrFileChanged) {
+			return err
+		}
+		if retry == changeRetries {
+			opts.warnf("%s: %v, %d times; its object may mix old and new content", u.Key, ErrFileChanged, retry+1)
+			plan.changed = append(plan.changed, u.Key)
+			plan.forget(opts, u.Key)
+			return nil
+		}
+		info, err := os.Stat(u.Path)
+		if err != nil {
+			return err
+		}
+		u.Size, u.ModTime, u.hash = info.Size(), info.ModTime(), new(fileHash)
+	}
+}
@@ -0,0 +1,71 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// changingDest is a mockDest that appends to a source file as the
+// next writes of it are uploaded.
+type changingDest struct {
+	*mockDest
+	path   string
+	writes int
+}
+
+func (d *changingDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := d.mockDest.Put(ctx, key, r, meta); err != nil {
+		return err
+	}
+	if d.writes == 0 {
+		return nil
+	}
+	d.writes--
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString("more\n")
+	return err
+}
+
+func TestSync_fileChangedDuringUpload(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "app.log", "start\n")
+	writeFile(t, src, "b.txt", "b")
+
+	// Uploaded again once it has stopped changing.
+	dst := &changingDest{mockDest: newMockDest(), path: filepath.Join(src, "app.log"), writes: 1}
+	res, err := Sync(context.Background(), Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(dst.data["app.log"]); got != "start\nmore\n" || dst.objects["app.log"].Size != 11 {
+		t.Errorf("object %q of %d bytes, want the file as it changed to", got, dst.objects["app.log"].Size)
+	}
+	if want := []string{"app.log", "app.log", "b.txt"}; !slices.Equal(dst.putCalls, want) || len(res.Changed) != 0 {
+		t.Errorf("uploads %v, changed %v; want %v and none changed", dst.putCalls, res.Changed, want)
+	}
+
+	// Left as it is, and flagged, once it has changed on every attempt.
+	dst = &changingDest{mockDest: newMockDest(), path: filepath.Join(src, "app.log"), writes: 10}
+	res, err = Sync(context.Background(), Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dst.putCalls) - 1; n != 1+changeRetries || !slices.Equal(res.Changed, []string{"app.log"}) {
+		t.Errorf("uploaded app.log %d times, changed %v; want %d times and it flagged", n, res.Changed, 1+changeRetries)
+	}
+	info, err := os.Stat(filepath.Join(src, "app.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.objects["app.log"].Size == info.Size() {
+		t.Error("object recorded with the file's current size, want the next run to upload it again")
+	}
+}
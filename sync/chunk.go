@@ -0,0 +1,302 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	// ChunkPrefix is the key prefix the chunks of chunked files are stored
+	// under, each named for the SHA-256 of its content.
+	ChunkPrefix = metaPrefix + "chunks/"
+	// ChunkIndexKey is the key of the ChunkIndex.
+	ChunkIndexKey = ChunkPrefix + "index.json"
+)
+
+// ChunkOptions configures chunking: large files are split into chunks at
+// boundaries found in their content, and each chunk is stored once however
+// many files, or versions of a file, hold it. A file that changes a little,
+// such as a database or a mailbox, then only uploads the chunks around the
+// changes.
+type ChunkOptions struct {
+	// Threshold is the size from which files are chunked.
+	Threshold int64
+	// AvgSize is the average size of a chunk, a power of two of at least
+	// 64 KiB. Chunks are between a quarter and four times this size.
+	AvgSize int
+}
+
+// chunks reports whether file is to be chunked.
+func (o *ChunkOptions) chunks(file File) bool {
+	return o != nil && file.Size >= o.Threshold
+}
+
+// ChunkIndex records the chunks stored under ChunkPrefix and the chunked
+// files referring to them, so that runs upload only new chunks and delete
+// those no file refers to any more. It is kept at ChunkIndexKey.
+type ChunkIndex struct {
+	Chunks map[string]ChunkEntry `json:"chunks"` // by SHA-256, in hex
+	// Files lists the chunks of each chunked file, by key. While a file
+	// is being replaced, it lists the chunks of both versions.
+	Files map[string][]string `json:"files"`
+}
+
+// ChunkEntry describes a stored chunk.
+type ChunkEntry struct {
+	Size        int64       `json:"size"` // before compressing
+	Compression Compression `json:"compression,omitempty"`
+}
+
+// chunkList is the content of the object of a chunked file: its chunks,
+// in order.
+type chunkList struct {
+	Chunks []chunkRef `json:"chunks"`
+}
+
+type chunkRef struct {
+	Hash string `json:"sha256"`
+	ChunkEntry
+}
+
+func chunkKey(hash string) string {
+	return ChunkPrefix + hash
+}
+
+// checkChunks reports whether opts.Chunk can be used with the rest of opts.
+func checkChunks(opts Options) error {
+	c := opts.Chunk
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.Threshold <= 0:
+		return errors.New("chunking: the threshold must be positive")
+	case c.AvgSize < 64<<10 || c.AvgSize&(c.AvgSize-1) != 0:
+		return errors.New("chunking: the average chunk size must be a power of two of at least 64 KiB")
+	case opts.Snapshots, opts.DetectRenames, opts.DeleteTo != nil:
+		// Each would keep a chunked file's object after its chunks are
+		// deleted.
+		return errors.New("chunking cannot be combined with snapshots, rename detection or a trash")
+	case opts.ObjectLock != nil:
+		return errors.New("chunking cannot be combined with object lock: unused chunks must be deleted")
+	case opts.twoWay:
+		return errors.New("chunking cannot be combined with two-way sync")
+	}
+	if _, ok := opts.Dst.(*LocalDestination); ok {
+		return fmt.Errorf("chunking: local destinations store files as they are: %w", errors.ErrUnsupported)
+	}
+	if _, ok := opts.Dst.(Getter); !ok {
+		return fmt.Errorf("chunking: destination cannot read objects back: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// ReadChunkIndex reads the chunk index of dst. If there is none, it
+// returns an empty index.
+func ReadChunkIndex(ctx context.Context, dst Destination) (*ChunkIndex, error) {
+	idx := &ChunkIndex{}
+	rc, err := get(ctx, dst, ChunkIndexKey)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read chunk index: %w", err)
+	}
+	if err == nil {
+		defer rc.Close()
+		if err := json.NewDecoder(rc).Decode(idx); err != nil {
+			return nil, fmt.Errorf("read chunk index: %w", err)
+		}
+	}
+	if idx.Chunks == nil {
+		idx.Chunks = make(map[string]ChunkEntry)
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string][]string)
+	}
+	return idx, nil
+}
+
+func writeChunkIndex(ctx context.Context, dst Destination, idx *ChunkIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	meta := ObjectMeta{Size: int64(len(data)), ModTime: time.Now(), ContentType: "application/json"}
+	if err := dst.Put(ctx, ChunkIndexKey, bytes.NewReader(data), meta); err != nil {
+		return fmt.Errorf("write chunk index: %w", err)
+	}
+	return nil
+}
+
+// uploadChunked splits u into chunks, uploads those idx does not hold and
+// then the list of them as the object of u. The index is written before
+// the list, recording the chunks of the old and the new version of u, so
+// that those of the object at the destination are kept whenever it stops.
+func uploadChunked(ctx context.Context, opts Options, idx *ChunkIndex, u File) error {
+	var f *os.File
+	err := retrySource(ctx, opts, func() (err error) { f, err = os.Open(u.Path); return err })
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var list chunkList
+	var size int64
+	c := newChunker(io.LimitReader(f, u.Size), opts.Chunk.AvgSize)
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		size += int64(len(data))
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		e, ok := idx.Chunks[hash]
+		if !ok {
+			if e, err = putChunk(ctx, opts, hash, data); err != nil {
+				return fmt.Errorf("chunk %s: %w", hash, err)
+			}
+			idx.Chunks[hash] = e
+		}
+		list.Chunks = append(list.Chunks, chunkRef{Hash: hash, ChunkEntry: e})
+	}
+	// The file may have changed since it was planned; the list records
+	// the size it had then.
+	if size != u.Size {
+		return fmt.Errorf("file shrank from %d to %d bytes while being read", u.Size, size)
+	}
+
+	hashes := make([]string, len(list.Chunks))
+	for i, r := range list.Chunks {
+		hashes[i] = r.Hash
+	}
+	idx.Files[u.Key] = slices.Concat(idx.Files[u.Key], hashes)
+	if err := writeChunkIndex(ctx, opts.Dst, idx); err != nil {
+		return err
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	meta := u.meta()
+	meta.Tags, meta.Chunked, meta.ContentType = opts.Tags, true, "application/json"
+	if err := opts.Dst.Put(ctx, u.Key, bytes.NewReader(data), meta); err != nil {
+		return err
+	}
+	if err := verifyUpload(ctx, opts, u.Key, meta); err != nil {
+		return err
+	}
+	idx.Files[u.Key] = hashes
+	return nil
+}
+
+// putChunk uploads the chunk data, compressed as set in opts.
+func putChunk(ctx context.Context, opts Options, hash string, data []byte) (ChunkEntry, error) {
+	e := ChunkEntry{Size: int64(len(data)), Compression: opts.Compression}
+	meta := ObjectMeta{Size: e.Size, ModTime: time.Now(), ContentType: "application/octet-stream", Tags: opts.Tags, Compression: e.Compression}
+	var body io.Reader = bytes.NewReader(data)
+	if e.Compression != "" {
+		rc := compressBody(e.Compression, bytes.NewReader(data), e.Size)
+		defer rc.Close()
+		body = rc
+	}
+	return e, opts.Dst.Put(ctx, chunkKey(hash), body, meta)
+}
+
+// collectChunks deletes the chunks no file in idx refers to any more, and
+// writes idx. Files uploaded whole, or deleted, must already be dropped
+// from idx.
+func collectChunks(ctx context.Context, opts Options, idx *ChunkIndex) error {
+	used := make(map[string]bool)
+	for _, hashes := range idx.Files {
+		for _, h := range hashes {
+			used[h] = true
+		}
+	}
+	var unused []string
+	for _, h := range slices.Sorted(maps.Keys(idx.Chunks)) {
+		if !used[h] {
+			unused = append(unused, h)
+		}
+	}
+	// Drop the chunks from the index first: one left behind by a failure
+	// is only stored again, where a lost one would break a file.
+	for _, h := range unused {
+		delete(idx.Chunks, h)
+	}
+	if err := writeChunkIndex(ctx, opts.Dst, idx); err != nil {
+		return err
+	}
+	for _, h := range unused {
+		opts.report(Event{Action: "delete", Key: chunkKey(h), Reason: "unused chunk"})
+		if err := opts.Dst.Delete(ctx, chunkKey(h)); err != nil {
+			return fmt.Errorf("delete %s: %w", chunkKey(h), err)
+		}
+	}
+	return nil
+}
+
+// openChunked reads the chunk list in rc and returns the content of the
+// file it describes, read from its chunks in dst, each checked against its
+// hash.
+func openChunked(ctx context.Context, dst Destination, rc io.ReadCloser) (io.ReadCloser, error) {
+	defer rc.Close()
+	var list chunkList
+	if err := json.NewDecoder(rc).Decode(&list); err != nil {
+		return nil, fmt.Errorf("read chunk list: %w", err)
+	}
+	return &chunkReader{ctx: ctx, dst: dst, chunks: list.Chunks}, nil
+}
+
+// chunkReader reads the chunks of a file, one after the other.
+type chunkReader struct {
+	ctx    context.Context
+	dst    Destination
+	chunks []chunkRef // yet to read
+	cur    *bytes.Reader
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.cur == nil || r.cur.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := readChunk(r.ctx, r.dst, r.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		r.cur, r.chunks = bytes.NewReader(data), r.chunks[1:]
+	}
+	return r.cur.Read(p)
+}
+
+func (r *chunkReader) Close() error { return nil }
+
+// readChunk downloads the chunk c from dst and checks it against its hash.
+func readChunk(ctx context.Context, dst Destination, c chunkRef) ([]byte, error) {
+	rc, err := getContent(ctx, dst, chunkKey(c.Hash), &ObjectMeta{Size: c.Size, Compression: c.Compression})
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", c.Hash, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %w", c.Hash, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != c.Hash || int64(len(data)) != c.Size {
+		return nil, fmt.Errorf("chunk %s is corrupt", c.Hash)
+	}
+	return data, nil
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// chunkPuts returns the chunks among keys.
+func chunkPuts(keys []string) []string {
+	var chunks []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, ChunkPrefix) && key != ChunkIndexKey {
+			chunks = append(chunks, key)
+		}
+	}
+	return chunks
+}
+
+func TestSync_chunked(t *testing.T) {
+	src := t.TempDir()
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeFile(t, src, "mail.mbox", string(data))
+	writeFile(t, src, "small.txt", "small")
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, Delete: true, Compression: CompressZstd,
+		Chunk: &ChunkOptions{Threshold: 1 << 20, AvgSize: 64 << 10}}
+	ctx := context.Background()
+
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	meta := dst.objects["mail.mbox"]
+	first := chunkPuts(dst.putCalls)
+	if !meta.Chunked || meta.Size != int64(len(data)) || len(first) < 8 {
+		t.Fatalf("stored %+v in %d chunks, want a chunked object", meta, len(first))
+	}
+	if dst.objects["small.txt"].Chunked {
+		t.Error("small.txt was chunked")
+	}
+
+	// A small change uploads a few chunks.
+	edited := append(bytes.Clone(data[:1<<20]), "a new message"...)
+	edited = append(edited, data[1<<20:]...)
+	writeFile(t, src, "mail.mbox", string(edited))
+	dst.putCalls = nil
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(chunkPuts(dst.putCalls)); n == 0 || n > 3 {
+		t.Errorf("an edit uploaded %d chunks, want 1 to 3 of %d", n, len(first))
+	}
+	if chunks := chunkPuts(dst.deleteCalls); len(chunks) == 0 {
+		t.Error("the chunks of the old version were not deleted")
+	}
+
+	opts.Compare = ChecksumComparer{}
+	dst.putCalls = nil
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("unchanged run put %v", dst.putCalls)
+	}
+
+	to := t.TempDir()
+	if err := Restore(ctx, RestoreOptions{From: dst, To: to}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(to, "mail.mbox")); err != nil || !bytes.Equal(got, edited) {
+		t.Errorf("restored %d bytes, err %v; want the edited file", len(got), err)
+	}
+
+	// Deleting the file deletes its chunks.
+	if err := os.Remove(filepath.Join(src, "mail.mbox")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if left := chunkPuts(slices.Collect(maps.Keys(dst.objects))); len(left) != 0 {
+		t.Errorf("%d chunks left after the file was deleted", len(left))
+	}
+}
+
+func TestSync_chunkedRejects(t *testing.T) {
+	src := t.TempDir()
+	chunk := &ChunkOptions{Threshold: 1 << 20, AvgSize: 1 << 20}
+	for _, opts := range []Options{
+		{Src: src, Dst: newMockDest(), Chunk: &ChunkOptions{Threshold: 1 << 20, AvgSize: 100 << 10}},
+		{Src: src, Dst: newMockDest(), Chunk: chunk, DeleteTo: &Trash{}},
+		{Src: src, Dst: NewLocalDestination(t.TempDir()), Chunk: chunk},
+	} {
+		if _, err := Sync(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "chunking") {
+			t.Errorf("Sync(%+v) = %v, want a chunking error", *opts.Chunk, err)
+		}
+	}
+}
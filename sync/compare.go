@@ -0,0 +1,303 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Comparer decides whether a source file matches its destination copy.
+// Equal is only called for files whose Remote metadata is non-nil.
+type Comparer interface {
+	Equal(ctx context.Context, dst Destination, f File) (bool, error)
+}
+
+// ModTimeComparer treats a file as unchanged when its size matches and its
+// modification time is within Window of the stored one. Destinations keep
+// whole seconds, so the local time is truncated before comparing. This is
+// the default Comparer.
+type ModTimeComparer struct {
+	Window time.Duration
+}
+
+func (c ModTimeComparer) Equal(_ context.Context, _ Destination, f File) (bool, error) {
+	return f.Size == f.Remote.Size && sameModTime(f.ModTime, f.Remote.ModTime, c.Window), nil
+}
+
+// sameModTime reports whether a and b, truncated to the second, are within
+// window of each other.
+func sameModTime(a, b time.Time, window time.Duration) bool {
+	diff := a.Truncate(time.Second).Sub(b.Truncate(time.Second))
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= window
+}
+
+// windowed returns c with window as the Window of the ModTimeComparers it
+// uses, including the default one, that do not have one of their own.
+func windowed(c Comparer, window time.Duration) Comparer {
+	switch c := c.(type) {
+	case nil:
+		return ModTimeComparer{Window: window}
+	case ModTimeComparer:
+		if c.Window == 0 {
+			c.Window = window
+		}
+		return c
+	case ETagComparer:
+		if c.Window == 0 {
+			c.Window = window
+		}
+		return c
+	case Reconciler:
+		c.Base = windowed(c.Base, window)
+		return c
+	case PatternComparer:
+		p := PatternComparer{Default: windowed(c.Default, window)}
+		for _, r := range c.Rules {
+			p.Rules = append(p.Rules, CompareRule{Pattern: r.Pattern, Compare: windowed(r.Compare, window)})
+		}
+		return p
+	}
+	return c
+}
+
+// SizeComparer ignores modification times entirely, for sources whose
+// mtimes cannot be trusted.
+type SizeComparer struct{}
+
+func (SizeComparer) Equal(_ context.Context, _ Destination, f File) (bool, error) {
+	return f.Size == f.Remote.Size, nil
+}
+
+// ChecksumComparer compares SHA-256 digests of the local file and the
+// destination copy. The destination must implement Getter; reading objects
+// back may incur retrieval fees on archive storage classes. Archived
+// objects, which cannot be read without restoring them, are compared to
+// the SHA-256 recorded with them instead, or without one, by size and
+// modification time.
+type ChecksumComparer struct{}
+
+func (ChecksumComparer) Equal(ctx context.Context, dst Destination, f File) (bool, error) {
+	if f.Size != f.Remote.Size {
+		return false, nil
+	}
+	if f.Remote.Archived {
+		return sameArchived(ctx, f)
+	}
+	return sameContent(ctx, dst, f)
+}
+
+// sameArchived compares f to its archived destination copy without
+// reading it. See ChecksumComparer.
+func sameArchived(ctx context.Context, f File) (bool, error) {
+	if f.Remote.SHA256 == "" {
+		return ModTimeComparer{}.Equal(ctx, nil, f)
+	}
+	local, err := f.sha256()
+	if err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(local) == f.Remote.SHA256, nil
+}
+
+// ETagComparer compares files by the MD5 of their content, where the
+// destination reports the MD5 of the object's: S3 as the ETag of objects
+// uploaded in a single part, and GCS. Unlike ModTimeComparer, it finds
+// objects uploaded by other tools, such as the AWS CLI or the S3 console,
+// up to date although they record no modification time. Objects without
+// an MD5, such as those uploaded in parts, or stored compressed, sparse,
+// chunked or transformed, are compared as ModTimeComparer with Window
+// compares them.
+type ETagComparer struct {
+	Window time.Duration
+}
+
+func (c ETagComparer) Equal(ctx context.Context, dst Destination, f File) (bool, error) {
+	if f.Size != f.Remote.Size {
+		return false, nil
+	}
+	r := f.Remote
+	if r.MD5 == "" || r.Sparse || r.Compression != "" || r.Transform != "" || r.Chunked {
+		return ModTimeComparer{Window: c.Window}.Equal(ctx, dst, f)
+	}
+	local, err := fileMD5(f.Path)
+	if err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(local) == r.MD5, nil
+}
+
+// etagMD5 returns the MD5 an S3 ETag holds, or "" if it is not one, as
+// for multipart uploads, whose ETags end in the number of parts.
+func etagMD5(etag string) string {
+	etag = strings.ToLower(strings.Trim(etag, `"`))
+	if len(etag) != 2*md5.Size {
+		return ""
+	}
+	if _, err := hex.DecodeString(etag); err != nil {
+		return ""
+	}
+	return etag
+}
+
+// readsContent reports whether c reads the destination's copy of key to
+// compare it on this run.
+func readsContent(c Comparer, key string) bool {
+	switch c := comparerFor(c, key).(type) {
+	case ChecksumComparer:
+		return true
+	case Reconciler:
+		return c.due(key)
+	}
+	return false
+}
+
+// Reconciler wraps a cheap Comparer with periodic checksum verification:
+// on each run, roughly one in Every of the files Base considers unchanged
+// are also compared by checksum, chosen so that a daily run covers every
+// file once every Every days. This catches changes a size-only or jittery
+// mtime comparison misses, without hashing the whole tree on every run.
+type Reconciler struct {
+	Base  Comparer
+	Every int
+
+	now func() time.Time // for tests; defaults to time.Now
+}
+
+func (r Reconciler) Equal(ctx context.Context, dst Destination, f File) (bool, error) {
+	equal, err := r.Base.Equal(ctx, dst, f)
+	if err != nil || !equal || !r.due(f.Key) {
+		return equal, err
+	}
+	return ChecksumComparer{}.Equal(ctx, dst, f)
+}
+
+// due reports whether key is verified by checksum on today's run.
+func (r Reconciler) due(key string) bool {
+	if r.Every <= 0 {
+		return false
+	}
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	day := uint32(now().Unix() / 86400)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()%uint32(r.Every) == day%uint32(r.Every)
+}
+
+// NetworkSourceComparer suits NFS and SMB mounts, whose mtimes jitter
+// between runs: files are compared by size, and a rolling 1/30th of them are
+// also verified by checksum on each run.
+func NetworkSourceComparer() Comparer {
+	return Reconciler{Base: SizeComparer{}, Every: 30}
+}
+
+// AlwaysUpload never considers a file up to date, so it is uploaded on
+// every run, for files such as database dumps whose size and mtime say
+// nothing about their content.
+type AlwaysUpload struct{}
+
+func (AlwaysUpload) Equal(context.Context, Destination, File) (bool, error) {
+	return false, nil
+}
+
+// PatternComparer compares each file with the Comparer of the first rule
+// whose pattern matches its key, or with Default if none does: for
+// example, by checksum for documents that keep their size and mtime when
+// edited, and by size alone for large media that never change.
+type PatternComparer struct {
+	Rules   []CompareRule
+	Default Comparer // nil means ModTimeComparer{}
+}
+
+// CompareRule is a rule of a PatternComparer. A Pattern without a slash,
+// such as "*.mp4", is matched against the file's name; one with a slash
+// against its key and the directories containing it, as for
+// Options.Reupload.
+type CompareRule struct {
+	Pattern string
+	Compare Comparer
+}
+
+func (c PatternComparer) Equal(ctx context.Context, dst Destination, f File) (bool, error) {
+	return comparerFor(c, f.Key).Equal(ctx, dst, f)
+}
+
+// comparerFor returns the Comparer c uses for key, looking through
+// PatternComparers.
+func comparerFor(c Comparer, key string) Comparer {
+	p, ok := c.(PatternComparer)
+	if !ok {
+		if c == nil {
+			return ModTimeComparer{}
+		}
+		return c
+	}
+	for _, r := range p.Rules {
+		if matchRule(r.Pattern, key) {
+			return comparerFor(r.Compare, key)
+		}
+	}
+	return comparerFor(p.Default, key)
+}
+
+// matchRule reports whether key matches pattern. See CompareRule.
+func matchRule(pattern, key string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(key))
+		return ok
+	}
+	return matchKey([]string{pattern}, key)
+}
+
+func sameContent(ctx context.Context, dst Destination, f File) (bool, error) {
+	local, err := f.sha256()
+	if err != nil {
+		return false, err
+	}
+
+	rc, err := getContent(ctx, dst, f.Key, f.Remote)
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return false, err
+	}
+	return bytes.Equal(local, h.Sum(nil)), nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	return hashFile(path, sha256.New())
+}
+
+func fileMD5(path string) ([]byte, error) {
+	return hashFile(path, md5.New())
+}
+
+// hashFile returns the sum h takes of the file at path.
+func hashFile(path string, h hash.Hash) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
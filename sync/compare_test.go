@@ -0,0 +1,243 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModTimeComparer_window(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	tests := []struct {
+		window time.Duration
+		local  time.Time
+		want   bool
+	}{
+		{0, base, true},
+		{0, base.Add(500 * time.Millisecond), true}, // sub-second part is truncated
+		{0, base.Add(time.Second), false},
+		{2 * time.Second, base.Add(2 * time.Second), true},
+		{2 * time.Second, base.Add(-2 * time.Second), true},
+		{2 * time.Second, base.Add(3 * time.Second), false},
+	}
+
+	for _, tt := range tests {
+		f := File{Size: 5, ModTime: tt.local, Remote: &ObjectMeta{Size: 5, ModTime: base}}
+		got, _ := ModTimeComparer{Window: tt.window}.Equal(context.Background(), nil, f)
+		if got != tt.want {
+			t.Errorf("window=%v local=%v: Equal = %v, want %v", tt.window, tt.local.Sub(base), got, tt.want)
+		}
+	}
+}
+
+func TestSync_sizeComparerIgnoresMtime(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "hello")
+
+	dst := newMockDest()
+	dst.objects["a.txt"] = &ObjectMeta{Size: info.Size(), ModTime: info.ModTime().Add(-time.Hour)}
+
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Compare: SizeComparer{}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("expected no uploads with size-only compare, got %v", dst.putCalls)
+	}
+}
+
+func TestChecksumComparer(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "hello")
+	f := File{
+		Key:    "a.txt",
+		Path:   filepath.Join(src, "a.txt"),
+		Size:   info.Size(),
+		Remote: &ObjectMeta{Size: info.Size()},
+	}
+
+	dst := newMockDest()
+	ctx := context.Background()
+
+	dst.data["a.txt"] = []byte("hello")
+	if equal, err := (ChecksumComparer{}).Equal(ctx, dst, f); err != nil || !equal {
+		t.Errorf("identical content: Equal = (%v, %v), want true", equal, err)
+	}
+
+	dst.data["a.txt"] = []byte("jello")
+	if equal, err := (ChecksumComparer{}).Equal(ctx, dst, f); err != nil || equal {
+		t.Errorf("same size, different content: Equal = (%v, %v), want false", equal, err)
+	}
+
+	// Archived objects are never read.
+	delete(dst.data, "a.txt")
+	f.Remote = &ObjectMeta{Size: info.Size(), Archived: true, SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}
+	if equal, err := (ChecksumComparer{}).Equal(ctx, dst, f); err != nil || !equal {
+		t.Errorf("archived, recorded SHA-256 matches: Equal = (%v, %v), want true", equal, err)
+	}
+	f.Remote.SHA256 = ""
+	f.ModTime = info.ModTime()
+	if equal, err := (ChecksumComparer{}).Equal(ctx, dst, f); err != nil || equal {
+		t.Errorf("archived, no SHA-256, older mtime: Equal = (%v, %v), want false", equal, err)
+	}
+}
+
+func TestReconciler_checksumsEveryFileOverCycle(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "hello")
+	f := File{
+		Key:    "a.txt",
+		Path:   filepath.Join(src, "a.txt"),
+		Size:   info.Size(),
+		Remote: &ObjectMeta{Size: info.Size()},
+	}
+	dst := newMockDest()
+	dst.data["a.txt"] = []byte("jello") // silently corrupted remote copy
+
+	caught := 0
+	for day := 0; day < 7; day++ {
+		r := Reconciler{
+			Base:  SizeComparer{},
+			Every: 7,
+			now:   func() time.Time { return time.Unix(int64(day)*86400, 0) },
+		}
+		equal, err := r.Equal(context.Background(), dst, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal {
+			caught++
+		}
+	}
+	if caught != 1 {
+		t.Errorf("expected the corruption to be caught on exactly 1 of 7 days, got %d", caught)
+	}
+}
+
+func TestPatternComparer_rules(t *testing.T) {
+	c := PatternComparer{
+		Rules: []CompareRule{
+			{Pattern: "*.docx", Compare: ChecksumComparer{}},
+			{Pattern: "*.mp4", Compare: SizeComparer{}},
+			{Pattern: "db/*.sql", Compare: AlwaysUpload{}},
+		},
+		Default: ModTimeComparer{Window: time.Second},
+	}
+	tests := []struct {
+		key  string
+		want Comparer
+	}{
+		{"report.docx", ChecksumComparer{}},
+		{"work/2024/report.docx", ChecksumComparer{}},
+		{"media/film.mp4", SizeComparer{}},
+		{"db/app.sql", AlwaysUpload{}},
+		{"old/db/app.sql", ModTimeComparer{Window: time.Second}},
+		{"notes.txt", ModTimeComparer{Window: time.Second}},
+	}
+	for _, tt := range tests {
+		if got := comparerFor(c, tt.key); got != tt.want {
+			t.Errorf("comparer for %s = %T, want %T", tt.key, got, tt.want)
+		}
+	}
+	if got := comparerFor(PatternComparer{}, "a"); got != (ModTimeComparer{}) {
+		t.Errorf("default comparer = %T, want ModTimeComparer", got)
+	}
+}
+
+func TestSync_patternComparer(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "dump.sql", "dump")
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	opts := Options{
+		Src: src, Dst: dst,
+		Compare:    PatternComparer{Rules: []CompareRule{{Pattern: "*.sql", Compare: AlwaysUpload{}}}},
+		StateCache: filepath.Join(t.TempDir(), "state.json"),
+		DirCache:   filepath.Join(t.TempDir(), "dirs.json"),
+	}
+	for range 2 {
+		if _, err := Sync(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dst.putCalls) != 3 || dst.putCalls[2] != "dump.sql" {
+		t.Errorf("uploads = %v, want dump.sql uploaded again", dst.putCalls)
+	}
+
+	report, err := Verify(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 0 {
+		t.Errorf("Verify reported %v", report.Mismatches)
+	}
+}
+
+func TestSync_modTimeWindow(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "hello")
+	writeFile(t, src, "b.mp4", "movie")
+	// As stored by a filesystem that keeps mtimes to 2 seconds.
+	dst := newMockDest()
+	for _, key := range []string{"a.txt", "b.mp4"} {
+		dst.objects[key] = &ObjectMeta{Size: 5, ModTime: info.ModTime().Truncate(time.Second).Add(-time.Second)}
+	}
+
+	for _, compare := range []Comparer{nil, PatternComparer{Rules: []CompareRule{{Pattern: "*.mp4", Compare: ModTimeComparer{}}}}} {
+		dst.putCalls = nil
+		opts := Options{Src: src, Dst: dst, Compare: compare, ModTimeWindow: 2 * time.Second}
+		if _, err := Sync(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		if len(dst.putCalls) != 0 {
+			t.Errorf("Compare %#v: uploaded %v within the window", compare, dst.putCalls)
+		}
+	}
+}
+
+func TestETagComparer(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "hello")
+	f := File{
+		Key:     "a.txt",
+		Path:    filepath.Join(src, "a.txt"),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		// Uploaded by another tool: no mtime recorded.
+		Remote: &ObjectMeta{Size: info.Size(), MD5: "5d41402abc4b2a76b9719d911017c592"},
+	}
+	ctx := context.Background()
+
+	if equal, err := (ETagComparer{}).Equal(ctx, nil, f); err != nil || !equal {
+		t.Errorf("MD5 matches: Equal = (%v, %v), want true", equal, err)
+	}
+	f.Remote.MD5 = "7d793037a0760186574b0282f2f435e7"
+	if equal, err := (ETagComparer{}).Equal(ctx, nil, f); err != nil || equal {
+		t.Errorf("MD5 differs: Equal = (%v, %v), want false", equal, err)
+	}
+
+	// Without an MD5, or with one of the stored rather than the file's
+	// content, the mtime decides.
+	f.Remote = &ObjectMeta{Size: info.Size(), ModTime: info.ModTime()}
+	if equal, err := (ETagComparer{}).Equal(ctx, nil, f); err != nil || !equal {
+		t.Errorf("no MD5, same mtime: Equal = (%v, %v), want true", equal, err)
+	}
+	f.Remote = &ObjectMeta{Size: info.Size(), MD5: "5d41402abc4b2a76b9719d911017c592", Compression: "zstd"}
+	if equal, err := (ETagComparer{}).Equal(ctx, nil, f); err != nil || equal {
+		t.Errorf("compressed, no mtime: Equal = (%v, %v), want false", equal, err)
+	}
+}
+
+func TestETagMD5(t *testing.T) {
+	for etag, want := range map[string]string{
+		`"5D41402ABC4B2A76B9719D911017C592"`:   "5d41402abc4b2a76b9719d911017c592",
+		"5d41402abc4b2a76b9719d911017c592":     "5d41402abc4b2a76b9719d911017c592",
+		`"5d41402abc4b2a76b9719d911017c592-2"`: "", // multipart
+		`"0x8DC1A2B3C4D5E6F"`:                  "",
+		"":                                     "",
+	} {
+		if got := etagMD5(etag); got != want {
+			t.Errorf("etagMD5(%s) = %q, want %q", etag, got, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package sync
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression selects how file contents are compressed before upload.
+// The zero value uploads them as they are.
+type Compression string
+
+const (
+	// CompressGzip compresses with gzip.
+	CompressGzip Compression = "gzip"
+	// CompressZstd compresses faster than CompressGzip, and smaller.
+	CompressZstd Compression = "zstd"
+)
+
+// ParseCompression parses the names used on the command line: none, gzip
+// and zstd.
+func ParseCompression(s string) (Compression, error) {
+	switch s {
+	case "none":
+		return "", nil
+	case "gzip":
+		return CompressGzip, nil
+	case "zstd":
+		return CompressZstd, nil
+	}
+	return "", fmt.Errorf("unknown compression %q (valid: none, gzip, zstd)", s)
+}
+
+// compressedBody is the first size bytes of r, compressed as they are
+// read. Unlike a stream, it can be read again from the start: the breaker
+// rewinds it to retry a failed upload.
+type compressedBody struct {
+	c    Compression
+	r    io.ReaderAt
+	size int64
+	pr   *io.PipeReader
+}
+
+// compressBody returns the first size bytes of r compressed with c, as
+// they are read. Closing it stops compressing.
+func compressBody(c Compression, r io.ReaderAt, size int64) *compressedBody {
+	return &compressedBody{c: c, r: r, size: size}
+}
+
+func (b *compressedBody) Read(p []byte) (int, error) {
+	if b.pr == nil {
+		b.pr = compress(b.c, io.NewSectionReader(b.r, 0, b.size))
+	}
+	return b.pr.Read(p)
+}
+
+// Rewind starts compressing again, for reading from the start.
+func (b *compressedBody) Rewind() error {
+	b.Close()
+	b.pr = nil
+	return nil
+}
+
+func (b *compressedBody) Close() error {
+	if b.pr != nil {
+		b.pr.Close()
+	}
+	return nil
+}
+
+// compress returns r compressed with c, as it is read. Closing it stops
+// compressing.
+func compress(c Compression, r io.Reader) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.WriteCloser
+		switch c {
+		case CompressGzip:
+			w = gzip.NewWriter(pw)
+		case CompressZstd:
+			enc, err := zstd.NewWriter(pw)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			w = enc
+		default:
+			pw.CloseWithError(fmt.Errorf("unknown compression %q", c))
+			return
+		}
+		_, err := io.Copy(w, r)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// decompress returns the content of r, compressed with c.
+func decompress(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case "":
+		return io.NopCloser(r), nil
+	case CompressGzip:
+		return gzip.NewReader(r)
+	case CompressZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("object compressed with unknown %q", c)
+}
@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSync_compression(t *testing.T) {
+	for _, c := range []Compression{CompressGzip, CompressZstd} {
+		t.Run(string(c), func(t *testing.T) {
+			src := t.TempDir()
+			text := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 1000)
+			writeFile(t, src, "notes.txt", text)
+			writeFile(t, src, "empty", "")
+			dst := newMockDest()
+			opts := Options{Src: src, Dst: dst, Compression: c, Compare: ChecksumComparer{}}
+			if _, err := Sync(context.Background(), opts); err != nil {
+				t.Fatal(err)
+			}
+			meta := dst.objects["notes.txt"]
+			if meta.Compression != c || meta.Size != int64(len(text)) || len(dst.data["notes.txt"]) >= len(text)/10 {
+				t.Fatalf("stored %d bytes with %+v, want %s-compressed %d bytes", len(dst.data["notes.txt"]), meta, c, len(text))
+			}
+
+			dst.putCalls = nil
+			if _, err := Sync(context.Background(), opts); err != nil {
+				t.Fatal(err)
+			}
+			if len(dst.putCalls) != 0 {
+				t.Errorf("second run put %v, want nothing", dst.putCalls)
+			}
+
+			to := t.TempDir()
+			if err := Restore(context.Background(), RestoreOptions{From: dst, To: to}); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]string{"notes.txt": text, "empty": ""} {
+				got, err := os.ReadFile(filepath.Join(to, name))
+				if err != nil || string(got) != want {
+					t.Errorf("restored %s: %d bytes, err %v; want %d bytes", name, len(got), err, len(want))
+				}
+			}
+		})
+	}
+}
+
+func TestSync_compressedSparse(t *testing.T) {
+	src := t.TempDir()
+	want := writeSparseFile(t, src, "disk.img", 4<<20, map[int64]string{1 << 20: strings.Repeat("a", 64<<10)})
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Sparse: true, Compression: CompressZstd}); err != nil {
+		t.Fatal(err)
+	}
+	if meta := dst.objects["disk.img"]; !meta.Sparse || meta.Compression != CompressZstd || len(dst.data["disk.img"]) >= 4<<10 {
+		t.Fatalf("stored %d bytes with %+v, want a compressed sparse object", len(dst.data["disk.img"]), meta)
+	}
+	to := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: to}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(to, "disk.img")); err != nil || string(got) != string(want) {
+		t.Errorf("restored %d bytes, err %v; want the source back", len(got), err)
+	}
+}
+
+func TestObjectMetadata_compression(t *testing.T) {
+	md := objectMetadata(ObjectMeta{Size: 1000, Compression: CompressZstd})
+	got := parseMetadata(120, md)
+	if got.Size != 1000 || got.Compression != CompressZstd {
+		t.Errorf("parsed %+v, want the file's size and zstd", got)
+	}
+	if got := parseMetadata(120, objectMetadata(ObjectMeta{Size: 120})); got.Compression != "" {
+		t.Errorf("parsed compression %q for an uncompressed object", got.Compression)
+	}
+}
@@ -0,0 +1,69 @@
+package sync
+
+import "slices"
+
+// confirmPlan asks opts.Confirm about each upload, bundled file and delete
+// in plan, and drops those it declines. If it fails, nothing is applied.
+func confirmPlan(opts Options, plan *Plan) error {
+	declined := make(map[string]bool)
+	ask := func(e Event) (bool, error) {
+		ok, err := opts.Confirm(e)
+		if err == nil && !ok {
+			declined[e.Key] = true
+		}
+		return ok, err
+	}
+
+	uploads := plan.Uploads[:0]
+	for _, u := range plan.Uploads {
+		e := Event{Action: "upload", Key: u.Key}
+		if from, ok := plan.renamed[u.Key]; ok {
+			e = Event{Action: "copy", Key: u.Key, From: from, Reason: "renamed"}
+		} else if from, ok := plan.duplicates[u.Key]; ok {
+			e = Event{Action: "copy", Key: u.Key, From: from, Reason: "duplicate"}
+		}
+		ok, err := ask(e)
+		if err != nil {
+			return err
+		}
+		if ok {
+			uploads = append(uploads, u)
+		}
+	}
+	plan.Uploads = uploads
+
+	bundled := plan.Bundled[:0]
+	for _, f := range plan.Bundled {
+		ok, err := ask(Event{Action: "bundle", Key: f.Key})
+		if err != nil {
+			return err
+		}
+		if ok {
+			bundled = append(bundled, f)
+		}
+	}
+	plan.Bundled = bundled
+
+	deletes := plan.Deletes[:0]
+	for _, key := range plan.Deletes {
+		ok, err := ask(Event{Action: "delete", Key: key, Reason: opts.deleteReason()})
+		if err != nil {
+			return err
+		}
+		if ok {
+			deletes = append(deletes, key)
+		}
+	}
+	plan.Deletes = deletes
+
+	// As for failed uploads, declined files are left out of the manifest
+	// and checked again by the next run.
+	plan.Files = slices.DeleteFunc(plan.Files, func(f File) bool {
+		if declined[f.Key] {
+			plan.forget(opts, f.Key)
+			return true
+		}
+		return false
+	})
+	return nil
+}
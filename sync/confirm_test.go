@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSync_confirm(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	dst := newMockDest()
+	putRemote(dst, "old.txt", "old", time.Now())
+	putRemote(dst, "gone.txt", "gone", time.Now())
+
+	var asked []string
+	opts := Options{Src: src, Dst: dst, Delete: true, Confirm: func(e Event) (bool, error) {
+		asked = append(asked, e.String())
+		return e.Key == "a.txt" || e.Key == "gone.txt", nil
+	}}
+	res, err := Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"upload a.txt", "upload b.txt", "delete gone.txt", "delete old.txt"}
+	slices.Sort(asked)
+	slices.Sort(want)
+	if !slices.Equal(asked, want) {
+		t.Errorf("asked about %q, want %q", asked, want)
+	}
+	if !slices.Equal(dst.putCalls, []string{"a.txt"}) || !slices.Equal(dst.deleteCalls, []string{"gone.txt"}) {
+		t.Errorf("put %v, deleted %v; want a.txt and gone.txt only", dst.putCalls, dst.deleteCalls)
+	}
+	if res.Uploads != 1 || res.Deletes != 1 {
+		t.Errorf("result counts %d uploads and %d deletes, want 1 and 1", res.Uploads, res.Deletes)
+	}
+
+	// Failing the confirmation stops the run before anything is changed.
+	dst.putCalls, dst.deleteCalls = nil, nil
+	quit := errors.New("quit")
+	opts.Confirm = func(e Event) (bool, error) {
+		if e.Action == "delete" {
+			return false, quit
+		}
+		return true, nil
+	}
+	if _, err := Sync(context.Background(), opts); !errors.Is(err, quit) {
+		t.Fatalf("err = %v, want quit", err)
+	}
+	if len(dst.putCalls)+len(dst.deleteCalls) != 0 {
+		t.Errorf("put %v, deleted %v after quitting", dst.putCalls, dst.deleteCalls)
+	}
+}
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// ContentTypeMode selects how the Content-Type of uploaded objects is
+// chosen. It matters when objects are served over HTTP or previewed in a
+// browser or cloud console.
+type ContentTypeMode int
+
+const (
+	// ContentTypeDetect uses the type registered for the file extension,
+	// or else sniffs the first 512 bytes of the file.
+	ContentTypeDetect ContentTypeMode = iota
+	// ContentTypeExtension uses the type registered for the file
+	// extension only; other files get the destination's default.
+	ContentTypeExtension
+	// ContentTypeNone leaves every object with the destination's default
+	// type, such as binary/octet-stream on S3.
+	ContentTypeNone
+)
+
+// ParseContentTypeMode parses the names used on the command line: detect,
+// extension and none.
+func ParseContentTypeMode(s string) (ContentTypeMode, error) {
+	switch s {
+	case "detect":
+		return ContentTypeDetect, nil
+	case "extension":
+		return ContentTypeExtension, nil
+	case "none":
+		return ContentTypeNone, nil
+	}
+	return 0, fmt.Errorf("unknown content type mode %q (valid: detect, extension, none)", s)
+}
+
+// contentType returns the Content-Type to upload key with, reading the
+// start of r if it needs to sniff it. r is left at its start.
+func contentType(mode ContentTypeMode, key string, r io.ReadSeeker) (string, error) {
+	if mode == ContentTypeNone {
+		return "", nil
+	}
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t, nil
+	}
+	if mode == ContentTypeExtension {
+		return "", nil
+	}
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", nil // nothing to go on
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestContentType(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 16)
+	tests := []struct {
+		mode    ContentTypeMode
+		key     string
+		content string
+		want    string
+	}{
+		{ContentTypeDetect, "photos/a.jpg", "", "image/jpeg"},
+		{ContentTypeDetect, "index.HTML", "", "text/html; charset=utf-8"},
+		{ContentTypeDetect, "scan", png, "image/png"},
+		{ContentTypeDetect, "notes", "plain words", "text/plain; charset=utf-8"},
+		{ContentTypeDetect, "empty", "", ""},
+		{ContentTypeExtension, "a.jpg", "", "image/jpeg"},
+		{ContentTypeExtension, "scan", png, ""},
+		{ContentTypeNone, "a.jpg", "", ""},
+	}
+	for _, tt := range tests {
+		r := strings.NewReader(tt.content)
+		got, err := contentType(tt.mode, tt.key, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("contentType(%d, %q) = %q, want %q", tt.mode, tt.key, got, tt.want)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != tt.content {
+			t.Errorf("%q: reader not rewound after sniffing", tt.key)
+		}
+	}
+}
+
+func TestSync_setsContentType(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "readme", "some text")
+
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.objects["readme"].ContentType; got != "text/plain; charset=utf-8" {
+		t.Errorf("ContentType = %q, want text/plain", got)
+	}
+	if got := string(dst.data["readme"]); got != "some text" {
+		t.Errorf("uploaded %q after sniffing, want the whole file", got)
+	}
+}
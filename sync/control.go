@@ -0,0 +1,279 @@
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	stdsync "sync"
+	"time"
+)
+
+// controlEvents is how many of the latest events a Control keeps.
+const controlEvents = 1000
+
+// Control steers Watch from outside as it runs, and shows what it is
+// doing; see Options.Control. It serves an HTTP API for a tray app or a
+// service manager to use, over a local socket:
+//
+//	GET  /status   the ControlStatus, as JSON
+//	POST /sync     sync the whole tree now, even while paused
+//	POST /pause    collect changes without syncing them
+//	POST /resume   sync the changes collected while paused, and go on
+//	GET  /events   the latest events as JSON lines: n of them (default
+//	               100), and with follow=1 those after as they happen
+//
+// The zero value is ready to use, and Control is safe for concurrent use.
+type Control struct {
+	mu      stdsync.Mutex
+	paused  bool
+	syncing bool
+	pending int
+	last    *ControlRun
+	syncNow bool          // a full sync was asked for
+	wake    chan struct{} // signaled when syncNow is set or syncing resumes
+
+	events  []Event       // the latest controlEvents, oldest first
+	seq     int           // events recorded in all
+	changed chan struct{} // closed when an event is recorded
+}
+
+// ControlStatus is what Watch is doing, as Control reports it.
+type ControlStatus struct {
+	Paused  bool        `json:"paused"`
+	Syncing bool        `json:"syncing"`
+	Pending int         `json:"pending"` // changed paths waiting to be synced
+	LastRun *ControlRun `json:"last_run,omitempty"`
+}
+
+// ControlRun describes the last sync Watch finished, with the fields of
+// the summary line of -summary json.
+type ControlRun struct {
+	Finished      time.Time `json:"finished"`
+	Status        string    `json:"status"`
+	Uploaded      int       `json:"uploaded"`
+	Deleted       int       `json:"deleted"`
+	UploadedBytes int64     `json:"uploaded_bytes"`
+	Duration      float64   `json:"duration_seconds"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Status returns what Watch is doing.
+func (c *Control) Status() ControlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ControlStatus{Paused: c.paused, Syncing: c.syncing, Pending: c.pending, LastRun: c.last}
+}
+
+// SyncNow has Watch sync the whole tree as soon as it is done with the
+// sync in progress, if any, whether or not it is paused.
+func (c *Control) SyncNow() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncNow = true
+	c.signal()
+}
+
+// Pause stops Watch syncing the changes it sees, once it is done with the
+// sync in progress; it collects them until Resume is called.
+func (c *Control) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+}
+
+// Resume has Watch sync the changes it collected while paused, and those
+// it sees after as usual.
+func (c *Control) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	c.signal()
+}
+
+// Events returns up to n of the latest events, oldest first, and the
+// number of events recorded in all, to pass to EventsAfter.
+func (c *Control) Events(n int) ([]Event, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n = min(max(n, 0), len(c.events))
+	return append([]Event(nil), c.events[len(c.events)-n:]...), c.seq
+}
+
+// EventsAfter returns the events recorded after the first seq, as far as
+// they are kept, with the number recorded in all, and a channel closed
+// once there are more.
+func (c *Control) EventsAfter(seq int) ([]Event, int, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changed == nil {
+		c.changed = make(chan struct{})
+	}
+	n := min(max(c.seq-seq, 0), len(c.events))
+	return append([]Event(nil), c.events[len(c.events)-n:]...), c.seq, c.changed
+}
+
+// signal wakes Watch up. c.mu must be held.
+func (c *Control) signal() {
+	if c.wake == nil {
+		c.wake = make(chan struct{}, 1)
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// wakeups returns the channel Watch is woken up on, which is nil, and so
+// never ready, if c is.
+func (c *Control) wakeups() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.wake == nil {
+		c.wake = make(chan struct{}, 1)
+	}
+	return c.wake
+}
+
+// take returns, and clears, whether a full sync was asked for, and whether
+// syncing is paused.
+func (c *Control) take() (syncNow, paused bool) {
+	if c == nil {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	syncNow, c.syncNow = c.syncNow, false
+	return syncNow, c.paused
+}
+
+// setPending records how many changed paths are waiting to be synced.
+func (c *Control) setPending(n int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = n
+}
+
+// setSyncing records whether a sync is in progress.
+func (c *Control) setSyncing(syncing bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncing = syncing
+}
+
+// finished records the sync s describes.
+func (c *Control) finished(s Summary) {
+	if c == nil {
+		return
+	}
+	run := &ControlRun{
+		Finished:      time.Now(),
+		Status:        s.Status(),
+		Uploaded:      s.Uploaded,
+		Deleted:       s.Deleted,
+		UploadedBytes: s.UploadedBytes,
+		Duration:      s.Duration.Seconds(),
+	}
+	if s.Err != nil {
+		run.Error = s.Err.Error()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = run
+}
+
+// onEvent returns a function for Options.OnEvent that records each event
+// in c, and passes it on to fn if it is set.
+func (c *Control) onEvent(fn func(Event)) func(Event) {
+	if c == nil {
+		return fn
+	}
+	return func(e Event) {
+		c.record(e)
+		if fn != nil {
+			fn(e)
+		}
+	}
+}
+
+func (c *Control) record(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.events) == controlEvents {
+		c.events = append(c.events[:0], c.events[1:]...)
+	}
+	c.events = append(c.events, e)
+	c.seq++
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
+
+// ServeHTTP serves the API described on Control.
+func (c *Control) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action := map[string]func(){"/sync": c.SyncNow, "/pause": c.Pause, "/resume": c.Resume}
+	switch {
+	case r.URL.Path == "/status" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Status())
+	case action[r.URL.Path] != nil && r.Method == http.MethodPost:
+		action[r.URL.Path]()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Status())
+	case r.URL.Path == "/events" && r.Method == http.MethodGet:
+		c.serveEvents(w, r)
+	case r.URL.Path == "/status" || r.URL.Path == "/events" || action[r.URL.Path] != nil:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveEvents writes the latest events, and with follow those after them
+// until the client goes away.
+func (c *Control) serveEvents(w http.ResponseWriter, r *http.Request) {
+	n := 100
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "n must be a count of events", http.StatusBadRequest)
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "1"
+	w.Header().Set("Content-Type", "application/jsonl")
+	enc := json.NewEncoder(w)
+	events, seq := c.Events(n)
+	for {
+		for _, e := range events {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+		if !follow {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		var more <-chan struct{}
+		if events, seq, more = c.EventsAfter(seq); len(events) > 0 {
+			continue
+		}
+		select {
+		case <-more:
+			events, seq, _ = c.EventsAfter(seq)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch_control(t *testing.T) {
+	src := t.TempDir()
+	out := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+
+	c := new(Control)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, Options{Src: src, Dst: NewLocalDestination(out), Control: c}, 20*time.Millisecond)
+	}()
+	waitForFile(t, filepath.Join(out, "a.txt"))
+
+	c.Pause()
+	writeFile(t, src, "b.txt", "b")
+	waitFor(t, "the change to be collected", func() bool { return c.Status().Pending > 0 })
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(filepath.Join(out, "b.txt")); err == nil {
+		t.Fatal("b.txt was synced while paused")
+	}
+
+	c.Resume()
+	waitForFile(t, filepath.Join(out, "b.txt"))
+	waitFor(t, "the run to be recorded", func() bool {
+		s := c.Status()
+		return s.Pending == 0 && s.LastRun != nil && s.LastRun.Uploaded == 1
+	})
+
+	// A file removed from the destination behind Watch's back comes back
+	// with a full sync.
+	c.Pause()
+	if err := os.Remove(filepath.Join(out, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	c.SyncNow()
+	waitForFile(t, filepath.Join(out, "a.txt"))
+
+	events, _ := c.Events(10)
+	if len(events) != 3 || events[0].Key != "a.txt" || events[1].Key != "b.txt" || events[2].Key != "a.txt" {
+		t.Errorf("events = %v, want the uploads of a.txt, b.txt and a.txt", events)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControl_serveHTTP(t *testing.T) {
+	c := new(Control)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/pause", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st ControlStatus
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if err != nil || !st.Paused {
+		t.Fatalf("POST /pause = %+v, %v; want paused", st, err)
+	}
+	if resp, err := http.Get(srv.URL + "/pause"); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /pause = %v, %v; want 405", resp.Status, err)
+	}
+
+	c.record(Event{Action: "upload", Key: "a.txt"})
+	c.record(Event{Action: "delete", Key: "b.txt"})
+	resp, err = http.Get(srv.URL + "/events?n=1&follow=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	next := func() Event {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("events ended: %v", lines.Err())
+		}
+		var e Event
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	if e := next(); e.Key != "b.txt" {
+		t.Errorf("first event = %v, want the latest, delete b.txt", e)
+	}
+	c.record(Event{Action: "upload", Key: "c.txt"})
+	if e := next(); e.Key != "c.txt" {
+		t.Errorf("followed event = %v, want upload c.txt", e)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
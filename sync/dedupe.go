@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// checkDedupe reports whether opts.DedupeUploads can be used with the
+// rest of opts.
+func checkDedupe(opts Options) error {
+	if !opts.DedupeUploads {
+		return nil
+	}
+	switch {
+	case opts.Sparse:
+		return errors.New("deduplicating uploads cannot be combined with sparse uploads")
+	case opts.Transform != nil:
+		return errors.New("deduplicating uploads cannot be combined with a transform")
+	case opts.ObjectLock != nil:
+		return errors.New("deduplicating uploads cannot be combined with object lock")
+	}
+	if _, ok := opts.Dst.(MetaCopier); !ok {
+		return fmt.Errorf("deduplicating uploads: destination cannot copy objects with new metadata: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// planDuplicates finds the uploads of plan with the same content as an
+// upload before them, and has them copied from its object instead. Only
+// files of a size another upload shares are hashed. See
+// Options.DedupeUploads.
+func planDuplicates(opts Options, plan *Plan) error {
+	if !opts.DedupeUploads || len(plan.Uploads) < 2 {
+		return nil
+	}
+	dedupable := func(u File) bool {
+		_, renamed := plan.renamed[u.Key]
+		return u.Size > 0 && !renamed && !opts.Chunk.chunks(u)
+	}
+	sizes := make(map[int64]int)
+	for _, u := range plan.Uploads {
+		if dedupable(u) {
+			sizes[u.Size]++
+		}
+	}
+
+	first := make(map[string]string) // content hash to the key of the first upload with it
+	for _, u := range plan.Uploads {
+		if sizes[u.Size] < 2 || !dedupable(u) {
+			continue
+		}
+		sum, err := u.sha256()
+		if err != nil {
+			return err
+		}
+		from, ok := first[string(sum)]
+		if !ok {
+			first[string(sum)] = u.Key
+			continue
+		}
+		if plan.duplicates == nil {
+			plan.duplicates = make(map[string]string)
+			plan.originals = make(map[string]bool)
+		}
+		plan.duplicates[u.Key] = from
+		plan.originals[from] = false
+	}
+	return nil
+}
+
+// copyDuplicate copies the object at from, uploaded earlier in the run
+// with the same content as u, to the key of u with the metadata uploading
+// u would give it. It fails with ErrFileChanged if u has changed since it
+// was hashed.
+func copyDuplicate(ctx context.Context, opts Options, u File, from string) error {
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := checkUnchanged(f, u); err != nil {
+		return err
+	}
+	meta, err := uploadMeta(opts, u, f)
+	if err != nil {
+		return err
+	}
+	meta.Compression = opts.Compression // as the object at from was stored
+	if err := copyWithMeta(ctx, opts.Dst, from, u.Key, meta); err != nil {
+		return err
+	}
+	return verifyUpload(ctx, opts, u.Key, meta)
+}
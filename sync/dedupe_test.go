@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// metaCopyDest is a mockDest that can copy objects with new metadata.
+type metaCopyDest struct {
+	*mockDest
+	metaCopies []string
+}
+
+func (d *metaCopyDest) CopyWithMeta(_ context.Context, src, dst string, meta ObjectMeta) error {
+	if _, ok := d.objects[src]; !ok {
+		return fs.ErrNotExist
+	}
+	d.metaCopies = append(d.metaCopies, src+" -> "+dst)
+	meta.ModTime = meta.ModTime.Truncate(time.Second)
+	d.objects[dst] = &meta
+	d.data[dst] = d.data[src]
+	return nil
+}
+
+func TestSync_dedupeUploads(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a/photo.jpg", "same bytes")
+	writeFile(t, src, "b/photo.jpg", "same bytes")
+	writeFile(t, src, "c/copy.jpg", "same bytes")
+	writeFile(t, src, "d/other.jpg", "other byte") // same size, other content
+	writeFile(t, src, "e/empty", "")
+	writeFile(t, src, "f/empty", "")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(filepath.Join(src, "c/copy.jpg"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &metaCopyDest{mockDest: newMockDest()}
+	var events []Event
+	opts := Options{Src: src, Dst: dst, DedupeUploads: true, OnEvent: func(e Event) { events = append(events, e) }}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dst.putCalls)
+	if want := []string{"a/photo.jpg", "d/other.jpg", "e/empty", "f/empty"}; !slices.Equal(dst.putCalls, want) {
+		t.Errorf("uploaded %v, want %v", dst.putCalls, want)
+	}
+	if want := []string{"a/photo.jpg -> b/photo.jpg", "a/photo.jpg -> c/copy.jpg"}; !slices.Equal(dst.metaCopies, want) {
+		t.Errorf("copied %v, want %v", dst.metaCopies, want)
+	}
+	if got := string(dst.data["c/copy.jpg"]); got != "same bytes" {
+		t.Errorf("c/copy.jpg holds %q", got)
+	}
+	if got := dst.objects["c/copy.jpg"].ModTime; !got.Equal(old) {
+		t.Errorf("c/copy.jpg recorded modified %v, want its own time %v", got, old)
+	}
+	if !slices.Contains(events, Event{Action: "copy", Key: "b/photo.jpg", From: "a/photo.jpg", Reason: "duplicate"}) {
+		t.Errorf("events %v, want b/photo.jpg reported as a duplicate copy", events)
+	}
+
+	// A duplicate of a file that failed to upload is uploaded itself.
+	src = t.TempDir()
+	writeFile(t, src, "a.txt", "same")
+	writeFile(t, src, "b.txt", "same")
+	dst = &metaCopyDest{mockDest: newMockDest()}
+	opts.Src, opts.Dst, opts.KeepGoing = src, failingMetaCopyPut{dst, "a.txt"}, true
+	if _, err := Sync(context.Background(), opts); err == nil {
+		t.Fatal("Sync succeeded despite the failed upload")
+	}
+	if len(dst.metaCopies) != 0 || !slices.Equal(dst.putCalls, []string{"b.txt"}) {
+		t.Errorf("uploaded %v and copied %v, want b.txt uploaded", dst.putCalls, dst.metaCopies)
+	}
+
+	_, err := Sync(context.Background(), Options{Src: src, Dst: newMockDest(), DedupeUploads: true})
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Sync to a destination that cannot copy = %v, want ErrUnsupported", err)
+	}
+}
+
+// failingMetaCopyPut is a metaCopyDest whose uploads of key fail.
+type failingMetaCopyPut struct {
+	*metaCopyDest
+	key string
+}
+
+func (d failingMetaCopyPut) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if key == d.key {
+		return errors.New("upload failed")
+	}
+	return d.metaCopyDest.Put(ctx, key, r, meta)
+}
@@ -2,24 +2,474 @@ package sync
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrChecksumMismatch is returned when content read back from a
+// destination does not match the SHA-256 recorded with it. See
+// Options.Checksums.
+var ErrChecksumMismatch = errors.New("content does not match its recorded SHA-256")
+
+// ErrUploadMismatch is returned when an object read back after its upload
+// does not match the file uploaded. See Options.VerifyAfterUpload.
+var ErrUploadMismatch = errors.New("uploaded object does not match the file")
+
 // ObjectMeta holds metadata about a stored object.
 type ObjectMeta struct {
 	Size    int64
 	ModTime time.Time
+
+	// POSIX holds the permissions, ownership and extended attributes of
+	// the source file, if they were recorded. See Options.PreservePOSIX.
+	POSIX *POSIXAttrs
+	// ContentType is the object's MIME type. If empty when uploading,
+	// the destination's default applies. See Options.ContentType.
+	ContentType string
+	// StorageClass is the object's storage class, as reported by
+	// destinations that have them. If empty when uploading, the
+	// destination's default applies. See Options.Tiers.
+	StorageClass string
+	// Tags are attached to the object when uploading, on destinations
+	// that support object tags; others ignore them. See Options.Tags.
+	Tags map[string]string
+	// Lock, if set, locks the object when uploading, on destinations
+	// that are Lockers. See Options.ObjectLock.
+	Lock *ObjectLock
+	// EncryptionContext is the SSE-KMS encryption context the object was
+	// encrypted with, as reported by destinations that record it. See
+	// S3Destination.SSEKMSEncryptionContext.
+	EncryptionContext map[string]string
+	// Sparse is set for objects holding only the data of a file with
+	// holes, whose Size is that of the file. See Options.Sparse.
+	Sparse bool
+	// Compression is how the object's content is compressed, for objects
+	// whose Size is that of the file before compressing. See
+	// Options.Compression.
+	Compression Compression
+	// Transform names the transform the object's content was rewritten
+	// with, for objects whose Size is that of the file before it. See
+	// Options.Transform.
+	Transform string
+	// Chunked is set for objects listing the chunks a file was split
+	// into, whose Size is that of the file. See Options.Chunk.
+	Chunked bool
+	// SHA256 is the hex-encoded hash of the file's content, recorded when
+	// it was uploaded, or empty. See Options.Checksums.
+	SHA256 string
+	// MD5 is the hex-encoded MD5 of the object's content as stored, set by
+	// Stat on destinations that report one: S3 in the ETag of objects
+	// uploaded in a single part without SSE-KMS or SSE-C, and GCS for
+	// objects not composed from others. See ETagComparer.
+	MD5 string
+	// Archived is set by Stat for objects in an archive storage class
+	// that must be restored before their content can be read. Comparers
+	// never read it; see ChecksumComparer.
+	Archived bool
+
+	// CacheControl, ContentDisposition and ContentEncoding are the HTTP
+	// headers served with the object, set when uploading on destinations
+	// that serve them, S3 and GCS; others ignore them. UserMetadata is
+	// custom metadata stored with the object, on destinations that store
+	// metadata. Stat does not report them. See Options.Metadata.
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	UserMetadata       map[string]string
 }
 
 // Destination is a write target for synced files.
 type Destination interface {
-	// Put uploads a file to the destination at the given relative key.
-	Put(ctx context.Context, key string, r io.Reader, size int64, modTime time.Time) error
+	// Put uploads a file to the destination at the given relative key,
+	// recording meta so that Stat can report it back. If r is also an
+	// io.ReaderAt and io.Seeker, as a file is, the destination may read
+	// it in parts, and read them again to retry them, instead of
+	// buffering them; destinations that wrap others pass r on as it is.
+	Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error
 	// Stat returns metadata for an existing object, or (nil, nil) if absent.
 	Stat(ctx context.Context, key string) (*ObjectMeta, error)
-	// List returns all keys currently held by the destination.
-	List(ctx context.Context) ([]string, error)
+	// List calls fn with the keys currently held by the destination, a
+	// page at a time and in byte order, so that trees of any size can be
+	// listed without holding every key. It stops at the first error fn
+	// returns and returns it.
+	List(ctx context.Context, fn func(keys []string) error) error
 	// Delete removes an object by key.
 	Delete(ctx context.Context, key string) error
 }
+
+// Getter is implemented by destinations that can read objects back.
+type Getter interface {
+	// Get opens the object stored at key. If the object is absent the
+	// error wraps fs.ErrNotExist.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// get reads key from dst, or fails with errors.ErrUnsupported if dst
+// cannot read objects back.
+func get(ctx context.Context, dst Destination, key string) (io.ReadCloser, error) {
+	g, ok := dst.(Getter)
+	if !ok {
+		return nil, fmt.Errorf("read %s: %w", key, errors.ErrUnsupported)
+	}
+	return g.Get(ctx, key)
+}
+
+// RangeGetter is implemented by Getters that can read part of an object,
+// which Restore uses to download large objects in parts. See
+// RestoreOptions.PartSize.
+type RangeGetter interface {
+	// GetRange opens the n bytes of the object at key from offset off. If
+	// the object is absent the error wraps fs.ErrNotExist.
+	GetRange(ctx context.Context, key string, off, n int64) (io.ReadCloser, error)
+}
+
+// getContent opens the object at key in dst, described by meta, as the
+// file it holds.
+func getContent(ctx context.Context, dst Destination, key string, meta *ObjectMeta) (io.ReadCloser, error) {
+	rc, err := get(ctx, dst, key)
+	if err == nil && meta != nil && meta.Chunked {
+		return openChunked(ctx, dst, rc)
+	}
+	if err != nil || meta == nil || !meta.Sparse && meta.Compression == "" {
+		return rc, err
+	}
+	dc, err := decompress(meta.Compression, rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	var r io.Reader = dc
+	if meta.Sparse {
+		r = newDenseReader(dc, meta.Size)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, closers{dc, rc}}, nil
+}
+
+// writeContent writes the file held by r, an object described by meta, to
+// f.
+func writeContent(f *os.File, r io.Reader, meta ObjectMeta) error {
+	dc, err := decompress(meta.Compression, r)
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	if meta.Sparse {
+		return writeSparse(f, dc, meta.Size)
+	}
+	_, err = io.Copy(f, dc)
+	return err
+}
+
+// checkSHA256 fails with ErrChecksumMismatch unless the content of f, a
+// file written from an object, hashes to want.
+func checkSHA256(f *os.File, want string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%w: hash %s, recorded %s", ErrChecksumMismatch, got, want)
+	}
+	return nil
+}
+
+// closers closes each of its elements, returning the first error.
+type closers []io.Closer
+
+func (cs closers) Close() error {
+	var first error
+	for _, c := range cs {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Copier is implemented by destinations that can copy an object to another
+// key without downloading it.
+type Copier interface {
+	// Copy copies the object at src, with its metadata, to dst. If src is
+	// absent the error wraps fs.ErrNotExist.
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// copyObject copies src to dst within d, or fails with
+// errors.ErrUnsupported if d cannot copy objects.
+func copyObject(ctx context.Context, d Destination, src, dst string) error {
+	c, ok := d.(Copier)
+	if !ok {
+		return fmt.Errorf("copy %s: %w", src, errors.ErrUnsupported)
+	}
+	return c.Copy(ctx, src, dst)
+}
+
+// MetaCopier is implemented by destinations that can copy an object to
+// another key without downloading it, storing it with new metadata.
+type MetaCopier interface {
+	// CopyWithMeta copies the content of the object at src to dst, with
+	// meta in place of src's metadata, as if it had been put with meta.
+	// If src is absent the error wraps fs.ErrNotExist.
+	CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error
+}
+
+// copyWithMeta copies src to dst within d with meta, or fails with
+// errors.ErrUnsupported if d cannot.
+func copyWithMeta(ctx context.Context, d Destination, src, dst string, meta ObjectMeta) error {
+	c, ok := d.(MetaCopier)
+	if !ok {
+		return fmt.Errorf("copy %s: %w", src, errors.ErrUnsupported)
+	}
+	return c.CopyWithMeta(ctx, src, dst, meta)
+}
+
+// Appender is implemented by destinations that can add to the end of an
+// object without uploading what it already holds. See Options.AppendUploads.
+type Appender interface {
+	// Append replaces the object at key, size bytes long, with its
+	// content followed by the meta.Size-size bytes of tail, stored with
+	// meta as if it had been put. It fails with errors.ErrUnsupported,
+	// leaving the object as it was, if it cannot append to it.
+	Append(ctx context.Context, key string, size int64, tail io.Reader, meta ObjectMeta) error
+}
+
+// appendObject appends tail to key, size bytes long, within d, or fails
+// with errors.ErrUnsupported if d cannot append to objects.
+func appendObject(ctx context.Context, d Destination, key string, size int64, tail io.Reader, meta ObjectMeta) error {
+	a, ok := d.(Appender)
+	if !ok {
+		return fmt.Errorf("append to %s: %w", key, errors.ErrUnsupported)
+	}
+	return a.Append(ctx, key, size, tail, meta)
+}
+
+// Renamer is implemented by destinations that can move an object to
+// another key in a single step.
+type Renamer interface {
+	// Rename moves the object at src, with its metadata, to dst,
+	// replacing any object there. If src is absent the error wraps
+	// fs.ErrNotExist.
+	Rename(ctx context.Context, src, dst string) error
+}
+
+// renameObject moves src to dst within d, copying and then deleting it if d
+// cannot rename objects.
+func renameObject(ctx context.Context, d Destination, src, dst string) error {
+	if r, ok := d.(Renamer); ok {
+		return r.Rename(ctx, src, dst)
+	}
+	if err := copyObject(ctx, d, src, dst); err != nil {
+		return err
+	}
+	return d.Delete(ctx, src)
+}
+
+// CrossCopier is implemented by destinations that can copy objects from
+// another destination server-side, without downloading them.
+type CrossCopier interface {
+	// CopyFrom copies the object at src in from to dst, preserving the
+	// metadata Stat reports. It fails with errors.ErrUnsupported if from
+	// is not a destination it can copy from.
+	CopyFrom(ctx context.Context, from Destination, src, dst string) error
+}
+
+// WrittenLister is implemented by destinations that can report when each
+// object was written, the time lifecycle rules measure an object's age from.
+type WrittenLister interface {
+	// ListWritten returns the same keys as List, each with the time its
+	// object was last written.
+	ListWritten(ctx context.Context) (map[string]time.Time, error)
+}
+
+// BatchDeleter is implemented by destinations that can delete many objects
+// in one request.
+type BatchDeleter interface {
+	// DeleteBatch deletes the objects at keys and returns the keys it
+	// deleted, which on error may be only some of them.
+	DeleteBatch(ctx context.Context, keys []string) ([]string, error)
+}
+
+// deleteBatch deletes keys from d in as few requests as it can, or fails
+// with errors.ErrUnsupported if d cannot delete more than one at a time.
+func deleteBatch(ctx context.Context, d Destination, keys []string) ([]string, error) {
+	b, ok := d.(BatchDeleter)
+	if !ok {
+		return nil, fmt.Errorf("delete objects: %w", errors.ErrUnsupported)
+	}
+	return b.DeleteBatch(ctx, keys)
+}
+
+// ListedObject is what a listing reports about an object, without reading
+// the metadata Stat returns.
+type ListedObject struct {
+	Size    int64
+	Written time.Time // when the object was last written
+}
+
+// ObjectLister is implemented by destinations whose listings report the
+// size and write time of each object.
+type ObjectLister interface {
+	// ListObjects returns the same keys as List, each with what the
+	// listing reports about its object.
+	ListObjects(ctx context.Context) (map[string]ListedObject, error)
+}
+
+// ObjectVersion is a version of an object kept by a Versioner.
+type ObjectVersion struct {
+	Key          string    `json:"key"`
+	VersionID    string    `json:"version_id"`
+	Size         int64     `json:"size"`
+	Written      time.Time `json:"written"`                 // when the version was written
+	Latest       bool      `json:"latest,omitempty"`        // the current version of its key
+	DeleteMarker bool      `json:"delete_marker,omitempty"` // left by a delete, rather than holding content
+}
+
+// Versioner is implemented by destinations that keep the versions of the
+// objects replaced and deleted, as S3 buckets with versioning enabled do.
+type Versioner interface {
+	// ListVersions calls fn with the versions of the objects whose keys
+	// begin with prefix, delete markers included, a page at a time, in
+	// key order and, for each key, newest first.
+	ListVersions(ctx context.Context, prefix string, fn func([]ObjectVersion) error) error
+	// RestoreVersion makes version id of the object at key its current
+	// version again, copying it over what is there. If there is no such
+	// version the error wraps fs.ErrNotExist.
+	RestoreVersion(ctx context.Context, key, id string) error
+	// DeleteVersions deletes versions for good and returns those it
+	// deleted, which on error may be only some of them.
+	DeleteVersions(ctx context.Context, versions []ObjectVersion) ([]ObjectVersion, error)
+}
+
+// listKeys returns every key at dst, in byte order, for callers that need
+// them all at once.
+func listKeys(ctx context.Context, dst Destination) ([]string, error) {
+	var all []string
+	err := dst.List(ctx, func(keys []string) error {
+		all = append(all, keys...)
+		return nil
+	})
+	return all, err
+}
+
+// listWritten lists the objects in dst with their write times, or fails
+// with errors.ErrUnsupported if dst cannot report them.
+func listWritten(ctx context.Context, dst Destination) (map[string]time.Time, error) {
+	l, ok := dst.(WrittenLister)
+	if !ok {
+		return nil, fmt.Errorf("list write times: %w", errors.ErrUnsupported)
+	}
+	return l.ListWritten(ctx)
+}
+
+// Archiver is implemented by destinations whose objects may sit in an
+// archive storage class that must be restored before they can be read.
+type Archiver interface {
+	// ArchiveStatus reports whether the object at key is archived and the
+	// progress of any restore.
+	ArchiveStatus(ctx context.Context, key string) (ArchiveStatus, error)
+	// RequestRestore asks for a readable copy of the archived object at
+	// key, retrieved at tier and kept for days. Asking again while a
+	// restore is in progress is not an error.
+	RequestRestore(ctx context.Context, key string, tier RestoreTier, days int) error
+}
+
+// Prefixed is implemented by destinations that store their objects under a
+// key prefix of a bucket other jobs may share.
+type Prefixed interface {
+	// Prefix returns the destination's key prefix, without slashes at
+	// either end; "" is the whole bucket.
+	Prefix() string
+	// WithPrefix returns a destination for another prefix of the same
+	// bucket, with the same settings.
+	WithPrefix(prefix string) Destination
+}
+
+// joinKey returns the object key for rel under prefix.
+func joinKey(prefix, rel string) string {
+	rel = strings.TrimPrefix(rel, "/")
+	if prefix == "" {
+		return rel
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + rel
+}
+
+// splitKey is the inverse of joinKey.
+func splitKey(prefix, full string) string {
+	if prefix == "" {
+		return full
+	}
+	return strings.TrimPrefix(full, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// listPrefix returns the prefix to list under, with a trailing slash so a
+// prefix of "a" does not match keys under "ab/".
+func listPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return strings.TrimSuffix(prefix, "/") + "/"
+}
+
+// objectMetadata is the user metadata stored alongside every object so that
+// Stat can report the source file's modification time and, if recorded,
+// its POSIX attributes.
+func objectMetadata(meta ObjectMeta) map[string]string {
+	md := maps.Clone(meta.UserMetadata) // checked not to use the names below
+	if md == nil {
+		md = make(map[string]string)
+	}
+	md["mtime"] = strconv.FormatInt(meta.ModTime.Unix(), 10)
+	md["size"] = strconv.FormatInt(meta.Size, 10)
+	meta.POSIX.encode(md)
+	if meta.Sparse {
+		md["sparse"] = "1"
+	}
+	if meta.Compression != "" {
+		md["compression"] = string(meta.Compression)
+	}
+	if meta.Transform != "" {
+		md["transform"] = meta.Transform
+	}
+	if meta.Chunked {
+		md["chunked"] = "1"
+	}
+	if meta.SHA256 != "" {
+		md["sha256"] = meta.SHA256
+	}
+	return md
+}
+
+// parseMetadata is the inverse of objectMetadata. size is the object's
+// stored size, as reported by the backend.
+func parseMetadata(size int64, md map[string]string) *ObjectMeta {
+	meta := &ObjectMeta{Size: size, POSIX: decodePOSIX(md)}
+	meta.Sparse = md["sparse"] == "1"
+	meta.Compression = Compression(md["compression"])
+	meta.Transform = md["transform"]
+	meta.Chunked = md["chunked"] == "1"
+	meta.SHA256 = md["sha256"]
+	if meta.Sparse || meta.Compression != "" || meta.Transform != "" || meta.Chunked {
+		// The stored size is not the file's.
+		meta.Size, _ = strconv.ParseInt(md["size"], 10, 64)
+	}
+	if v, ok := md["mtime"]; ok {
+		if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+			meta.ModTime = time.Unix(ts, 0)
+		}
+	}
+	return meta
+}
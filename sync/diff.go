@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// DiffClass classifies a path in a Diff.
+type DiffClass string
+
+const (
+	OnlyLocal  DiffClass = "only-local"  // in the source, not at the destination
+	OnlyRemote DiffClass = "only-remote" // at the destination, not in the source
+	Differs    DiffClass = "differs"     // a sync run would upload it again
+	Identical  DiffClass = "identical"
+)
+
+// DiffEntry is the classification of one path by Diff.
+type DiffEntry struct {
+	Key    string    `json:"key"`
+	Class  DiffClass `json:"class"`
+	Reason string    `json:"reason,omitempty"` // how it differs, for Differs
+}
+
+// DiffReport is the result of Diff.
+type DiffReport struct {
+	Entries []DiffEntry // in key order
+}
+
+// Count returns the number of entries of class c.
+func (r *DiffReport) Count(c DiffClass) int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.Class == c {
+			n++
+		}
+	}
+	return n
+}
+
+// Diff classifies every path of opts.Src and opts.Dst without writing
+// anything, comparing files as Verify does: a file is Identical if a sync
+// run would leave it alone. Objects absent from the source are reported as
+// OnlyRemote whether or not opts.Delete is set, so that a Diff shows what
+// turning it on would delete; foldersync's own objects are left out.
+func Diff(ctx context.Context, opts Options) (*DiffReport, error) {
+	opts.Delete = true
+	opts.ExpireAfter = 0
+	opts.DeleteTo = nil
+	opts, plan, err := auditPlan(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DiffReport{}
+	stale := make(map[string]bool, len(plan.Uploads)+len(plan.Bundled))
+	for _, f := range slices.Concat(plan.Uploads, plan.Bundled) {
+		stale[f.Key] = true
+		if f.Remote == nil {
+			report.Entries = append(report.Entries, DiffEntry{Key: f.Key, Class: OnlyLocal})
+		} else {
+			report.Entries = append(report.Entries, DiffEntry{Key: f.Key, Class: Differs, Reason: mismatchReason(opts, f)})
+		}
+	}
+	for _, f := range plan.Files {
+		if !stale[f.Key] {
+			report.Entries = append(report.Entries, DiffEntry{Key: f.Key, Class: Identical})
+		}
+	}
+	for _, key := range extraneous(plan) {
+		report.Entries = append(report.Entries, DiffEntry{Key: key, Class: OnlyRemote})
+	}
+	slices.SortFunc(report.Entries, func(a, b DiffEntry) int { return strings.Compare(a.Key, b.Key) })
+	return report, nil
+}
@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	src := t.TempDir()
+	same := writeFile(t, src, "same.txt", "same")
+	writeFile(t, src, "new.txt", "new")
+	writeFile(t, src, "changed.txt", "changed")
+
+	dst := newMockDest()
+	putRemote(dst, "same.txt", "same", same.ModTime())
+	putRemote(dst, "changed.txt", "old", time.Now())
+	putRemote(dst, "extra.txt", "extra", time.Now())
+	putRemote(dst, ManifestKey, "{}", time.Now())
+
+	report, err := Diff(context.Background(), Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffEntry{
+		{Key: "changed.txt", Class: Differs, Reason: "size 3, want 7"},
+		{Key: "extra.txt", Class: OnlyRemote},
+		{Key: "new.txt", Class: OnlyLocal},
+		{Key: "same.txt", Class: Identical},
+	}
+	if !slices.Equal(report.Entries, want) {
+		t.Errorf("entries = %v, want %v", report.Entries, want)
+	}
+	if n := report.Count(Identical); n != 1 {
+		t.Errorf("Count(Identical) = %d, want 1", n)
+	}
+	if len(dst.putCalls)+len(dst.deleteCalls) != 0 {
+		t.Errorf("diff put %v and deleted %v", dst.putCalls, dst.deleteCalls)
+	}
+}
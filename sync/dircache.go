@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// dirCache holds a signature for each source directory, as of the last
+// successful run. A directory whose signature is unchanged contains exactly
+// the files that run left at the destination, so they need not be checked
+// again. See Options.DirCache.
+type dirCache struct {
+	path string
+	old  map[string]string // signatures from the last successful run
+	new  map[string]string // signatures seen by this run
+}
+
+type dirCacheFile struct {
+	Dirs map[string]string `json:"dirs"`
+}
+
+// loadDirCache reads the cache at path. A missing or unreadable cache is
+// treated as empty: the run checks every file and writes a fresh one.
+func loadDirCache(path string, warnings io.Writer) *dirCache {
+	var f dirCacheFile
+	readCacheFile(path, "directory cache", &f, warnings)
+	return &dirCache{path: path, old: f.Dirs, new: make(map[string]string)}
+}
+
+// check records the signature of the source directory dir, found at path,
+// and reports whether it is unchanged since the last run.
+func (c *dirCache) check(dir, path string) (bool, error) {
+	sig, err := dirSignature(path)
+	if err != nil {
+		return false, err
+	}
+	c.new[dir] = sig
+	return c.old[dir] == sig, nil
+}
+
+// forget drops dir from the cache written by this run, so the next run
+// checks its files again. It is used for directories with files that were
+// deliberately not uploaded.
+func (c *dirCache) forget(dir string) {
+	if c != nil {
+		delete(c.new, dir)
+	}
+}
+
+// save writes the signatures seen by this run, replacing the old cache.
+func (c *dirCache) save() error {
+	return writeCacheFile(c.path, dirCacheFile{Dirs: c.new})
+}
+
+// dirSignature summarizes the files directly inside the directory at path:
+// their names, permissions, sizes and modification times. It changes
+// whenever one of them is added, removed, renamed or rewritten.
+// Subdirectories are not included; each has its own signature.
+func dirSignature(path string) (string, error) {
+	entries, err := os.ReadDir(path) // sorted by name
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%q %o %d %d\n", e.Name(), info.Mode(), info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readCacheFile decodes the JSON cache at path into v. A missing cache
+// leaves v untouched, and an unreadable one is reported to warnings: a
+// cache only saves work, so losing one is never fatal.
+func readCacheFile(path, what string, v any, warnings io.Writer) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			warnf(warnings, "%s: %v", what, err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		warnf(warnings, "%s %s: %v", what, path, err)
+	}
+}
+
+// writeCacheFile atomically replaces the cache at path with v.
+func writeCacheFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSync_dirCacheSkipsUnchangedDirectories(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "sub/b.txt", "b")
+	writeFile(t, src, "sub/deep/c.txt", "c")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, DirCache: filepath.Join(t.TempDir(), "dirs.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 3 {
+		t.Fatalf("first run: expected 3 uploads, got %v", dst.putCalls)
+	}
+
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.statCalls) != 0 || len(dst.putCalls) != 0 {
+		t.Errorf("unchanged tree: stat %v, put %v; want neither", dst.statCalls, dst.putCalls)
+	}
+}
+
+func TestSync_dirCacheNoticesDeepChange(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "sub/deep/c.txt", "c")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, DirCache: filepath.Join(t.TempDir(), "dirs.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewriting a file in place leaves the mtimes of its ancestors alone.
+	path := filepath.Join(src, "sub/deep/c.txt")
+	if err := os.WriteFile(path, []byte("cc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 1 || dst.putCalls[0] != "sub/deep/c.txt" {
+		t.Errorf("expected sub/deep/c.txt to be re-uploaded, got %v", dst.putCalls)
+	}
+	if len(dst.statCalls) != 1 {
+		t.Errorf("expected only the changed directory to be checked, got stat %v", dst.statCalls)
+	}
+}
+
+func TestSync_dirCacheNotWrittenOnDryRun(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	cache := filepath.Join(t.TempDir(), "dirs.json")
+
+	_, err := Sync(context.Background(), Options{Src: src, Dst: newMockDest(), DryRun: true, DirCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the directory cache: %v", err)
+	}
+}
+
+func TestSync_dirCacheRechecksExcludedSecrets(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, ".env", "SECRET=1")
+	writeFile(t, src, "sub/a.txt", "a")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, ScanSecrets: true, DirCache: filepath.Join(t.TempDir(), "dirs.json")}
+	for range 2 {
+		if _, err := Sync(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := dst.statCalls[len(dst.statCalls)-1]; got != ".env" {
+		t.Errorf("second run: expected .env to be checked again, last stat was %q", got)
+	}
+}
@@ -0,0 +1,333 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	gosync "sync"
+	"time"
+)
+
+// DefaultPartConcurrency is how many parts of an object Restore downloads
+// at once if RestoreOptions.PartConcurrency is not set.
+const DefaultPartConcurrency = 4
+
+// transfer is how objects are downloaded; see RestoreOptions.PartSize,
+// PartConcurrency and BytesPerSecond. The zero transfer downloads each
+// object in one piece, as fast as it comes.
+type transfer struct {
+	partSize int64
+	parts    int
+	bw       *bandwidth
+	log      io.Writer // RestoreOptions.Log
+}
+
+func newTransfer(opts RestoreOptions) transfer {
+	t := transfer{partSize: opts.PartSize, parts: opts.PartConcurrency, bw: newBandwidth(opts.BytesPerSecond), log: opts.Log}
+	if t.parts <= 0 {
+		t.parts = DefaultPartConcurrency
+	}
+	return t
+}
+
+// ranged reports whether the object meta describes is downloaded from
+// from in parts: it must be larger than a part, and stored as the file it
+// holds, so that a range of the object is the same range of the file.
+func (t transfer) ranged(from Destination, meta *ObjectMeta) (RangeGetter, bool) {
+	rg, ok := from.(RangeGetter)
+	if !ok || t.partSize <= 0 || meta.Size <= t.partSize {
+		return nil, false
+	}
+	return rg, !meta.Chunked && !meta.Sparse && meta.Compression == "" && meta.Transform == ""
+}
+
+// partialDownload records the parts of an object downloaded so far into
+// its partial file, so that a restore interrupted part way can resume.
+// The object is identified by what its metadata records: an object
+// uploaded again since has another size, modification time or hash.
+type partialDownload struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	SHA256   string    `json:"sha256,omitempty"`
+	PartSize int64     `json:"part_size"`
+	Done     []bool    `json:"done"` // for each part
+}
+
+// resumes reports whether p, read back from an earlier download, is of
+// the same object in the same parts as q.
+func (p *partialDownload) resumes(q *partialDownload) bool {
+	return p.Key == q.Key && p.Size == q.Size && p.ModTime.Equal(q.ModTime) &&
+		p.SHA256 == q.SHA256 && p.PartSize == q.PartSize && len(p.Done) == len(q.Done)
+}
+
+// partialPaths returns the paths of the partial file a download into path
+// writes, next to it, and of the record of its parts.
+func partialPaths(path string) (partial, record string) {
+	partial = filepath.Join(filepath.Dir(path), ".foldersync-"+filepath.Base(path)+".partial")
+	return partial, partial + ".json"
+}
+
+// readPartial reads the record at path, or returns nil if there is none
+// that can be read.
+func readPartial(path string) *partialDownload {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var p partialDownload
+	if json.Unmarshal(data, &p) != nil {
+		return nil
+	}
+	return &p
+}
+
+func writePartial(path string, p *partialDownload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// downloadRanges downloads key, described by meta, from rg into the file
+// name in to, in parts of t.partSize, up to t.parts at once. The parts
+// are written into a partial file next to it, which is renamed into place
+// once every part is in and its content checks out against meta.SHA256.
+// If the download fails, the partial file is left for the next download
+// of the same object to resume from.
+func downloadRanges(ctx context.Context, rg RangeGetter, to *LocalDestination, key, name string, meta ObjectMeta, t transfer) error {
+	path, err := to.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	partial, record := partialPaths(path)
+	want := &partialDownload{
+		Key: key, Size: meta.Size, ModTime: meta.ModTime, SHA256: meta.SHA256,
+		PartSize: t.partSize, Done: make([]bool, (meta.Size+t.partSize-1)/t.partSize),
+	}
+	p := readPartial(record)
+	flag := os.O_RDWR | os.O_CREATE
+	if p == nil || !p.resumes(want) {
+		p = want
+		flag |= os.O_TRUNC
+	} else if done := countTrue(p.Done); done > 0 {
+		fmt.Fprintf(logTo(t.log), "resume %s: %d of %d parts downloaded\n", key, done, len(p.Done))
+	}
+	f, err := os.OpenFile(partial, flag, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(meta.Size); err != nil {
+		f.Close()
+		return err
+	}
+	if err := writePartial(record, p); err != nil {
+		f.Close()
+		return err
+	}
+
+	var mu gosync.Mutex // guards p.Done and writes of record
+	w := newWorkers(ctx, t.parts)
+	for i, done := range p.Done {
+		if done {
+			continue
+		}
+		off := int64(i) * t.partSize
+		n := min(t.partSize, meta.Size-off)
+		w.run(func(ctx context.Context) error {
+			if err := downloadPart(ctx, rg, key, f, off, n, t.bw); err != nil {
+				return fmt.Errorf("bytes %d-%d: %w", off, off+n-1, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			p.Done[i] = true
+			return writePartial(record, p)
+		})
+	}
+	if err := w.wait(); err != nil {
+		f.Close()
+		return err
+	}
+
+	err = to.place(name, path, f, meta)
+	if err == nil || errors.Is(err, ErrChecksumMismatch) {
+		// Start over next time rather than keep corrupt parts.
+		os.Remove(partial)
+		os.Remove(record)
+	}
+	return err
+}
+
+// downloadPart writes the n bytes of key from off into f at the same
+// offset.
+func downloadPart(ctx context.Context, rg RangeGetter, key string, f *os.File, off, n int64, bw *bandwidth) error {
+	rc, err := rg.GetRange(ctx, key, off, n)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	got, err := io.Copy(io.NewOffsetWriter(f, off), bw.reader(ctx, io.LimitReader(rc, n)))
+	if err == nil && got < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func countTrue(bs []bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// workers runs functions with up to n of them at once, and stops
+// starting them at the first that fails. With n of 1 or less, each runs
+// before run returns.
+type workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	sem    chan struct{} // a token for each function running
+	wg     gosync.WaitGroup
+
+	mu  gosync.Mutex
+	err error // the first error
+}
+
+func newWorkers(ctx context.Context, n int) *workers {
+	ctx, cancel := context.WithCancel(ctx)
+	return &workers{ctx: ctx, cancel: cancel, sem: make(chan struct{}, max(n, 1))}
+}
+
+// run starts fn once one of the n may run, unless one has failed, and
+// cancels the context of the others if fn fails.
+func (w *workers) run(fn func(ctx context.Context) error) {
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		w.fail(w.ctx.Err())
+		return
+	}
+	if w.failed() {
+		<-w.sem
+		return
+	}
+	w.wg.Add(1)
+	call := func() {
+		defer w.wg.Done()
+		defer func() { <-w.sem }()
+		if err := fn(w.ctx); err != nil {
+			w.fail(err)
+		}
+	}
+	if cap(w.sem) == 1 {
+		call()
+		return
+	}
+	go call()
+}
+
+func (w *workers) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.cancel()
+	}
+}
+
+func (w *workers) failed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
+}
+
+// wait waits for the functions started to return, and returns the first
+// error.
+func (w *workers) wait() error {
+	w.wg.Wait()
+	w.cancel()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// bandwidth spaces reads out to at most a number of bytes a second across
+// all the readers that share it. A nil bandwidth sets no limit.
+type bandwidth struct {
+	perByte float64 // nanoseconds
+
+	mu   gosync.Mutex
+	next time.Time // when the bytes read so far are paid for
+}
+
+func newBandwidth(bytesPerSecond int64) *bandwidth {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidth{perByte: float64(time.Second) / float64(bytesPerSecond)}
+}
+
+// wait waits until n more bytes may be read.
+func (b *bandwidth) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	at := b.next
+	if at.Before(now) {
+		at = now
+	}
+	b.next = at.Add(time.Duration(float64(n) * b.perByte))
+	b.mu.Unlock()
+
+	if wait := at.Sub(now); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// reader returns r, limited to b.
+func (b *bandwidth) reader(ctx context.Context, r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, b: b}
+}
+
+// limitedReader reads from r in pieces of at most limitedChunk bytes,
+// waiting on b for each.
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	b   *bandwidth
+}
+
+const limitedChunk = 32 << 10
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedChunk {
+		p = p[:limitedChunk]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.b.wait(l.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,126 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+// rangeDest records the ranges read from its LocalDestination, and fails
+// the read at failAt, if set, once.
+type rangeDest struct {
+	*LocalDestination
+	failAt int64
+
+	mu   gosync.Mutex
+	offs []int64
+}
+
+var errPartFailed = errors.New("connection reset")
+
+func (d *rangeDest) GetRange(ctx context.Context, key string, off, n int64) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failAt > 0 && off == d.failAt {
+		d.failAt = 0
+		return nil, errPartFailed
+	}
+	d.offs = append(d.offs, off)
+	return d.LocalDestination.GetRange(ctx, key, off, n)
+}
+
+func TestRestore_rangedResume(t *testing.T) {
+	backup := t.TempDir()
+	content := strings.Repeat("0123456789", 1000)
+	writeFile(t, backup, "disk.img", content)
+	writeFile(t, backup, "small.txt", "small")
+	from := &rangeDest{LocalDestination: NewLocalDestination(backup), failAt: 5000}
+
+	out := t.TempDir()
+	opts := RestoreOptions{From: from, To: out, PartSize: 1000, PartConcurrency: 3, Concurrency: 2}
+	if err := Restore(context.Background(), opts); !errors.Is(err, errPartFailed) {
+		t.Fatalf("Restore = %v, want %v", err, errPartFailed)
+	}
+	if _, err := os.Stat(filepath.Join(out, "disk.img")); !os.IsNotExist(err) {
+		t.Errorf("disk.img restored despite a failed part: %v", err)
+	}
+	partial, record := partialPaths(filepath.Join(out, "disk.img"))
+	p := readPartial(record)
+	if p == nil || p.Done[5] {
+		t.Fatalf("record = %+v, want one without part 5", p)
+	}
+	var missing []int64
+	for i, done := range p.Done {
+		if !done {
+			missing = append(missing, int64(i)*1000)
+		}
+	}
+
+	from.offs = nil
+	if err := Restore(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(from.offs)
+	if !slices.Equal(from.offs, missing) {
+		t.Errorf("resumed download read parts at %v, want only the missing %v", from.offs, missing)
+	}
+	if got, err := os.ReadFile(filepath.Join(out, "disk.img")); err != nil || string(got) != content {
+		t.Errorf("disk.img = %d bytes, %v; want the %d backed up", len(got), err, len(content))
+	}
+	if got, err := os.ReadFile(filepath.Join(out, "small.txt")); err != nil || string(got) != "small" {
+		t.Errorf("small.txt = %q, %v", got, err)
+	}
+	for _, path := range []string{partial, record} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestRestore_rangedChangedObject(t *testing.T) {
+	backup := t.TempDir()
+	writeFile(t, backup, "a.bin", strings.Repeat("a", 3000))
+	from := &rangeDest{LocalDestination: NewLocalDestination(backup)}
+	out := t.TempDir()
+
+	// A record left by a download of an earlier version of the object.
+	partial, record := partialPaths(filepath.Join(out, "a.bin"))
+	writeFile(t, out, filepath.Base(partial), strings.Repeat("b", 3000))
+	if err := writePartial(record, &partialDownload{Key: "a.bin", Size: 3000, PartSize: 1000, Done: []bool{true, true, true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(context.Background(), RestoreOptions{From: from, To: out, PartSize: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	if len(from.offs) != 3 {
+		t.Errorf("read parts at %v, want all 3 again", from.offs)
+	}
+	if got, _ := os.ReadFile(filepath.Join(out, "a.bin")); string(got) != strings.Repeat("a", 3000) {
+		t.Errorf("a.bin holds the parts of the earlier version")
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	b := newBandwidth(1 << 20)
+	start := time.Now()
+	n, err := io.Copy(io.Discard, b.reader(context.Background(), strings.NewReader(strings.Repeat("x", 100<<10))))
+	if err != nil || n != 100<<10 {
+		t.Fatalf("Copy = %d, %v", n, err)
+	}
+	// Each piece waits for those before it to be paid for.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("read 100 KiB at 1 MiB/s in %v", elapsed)
+	}
+
+	if r := newBandwidth(0).reader(context.Background(), strings.NewReader("")); r == nil {
+		t.Error("reader without a limit is nil")
+	}
+}
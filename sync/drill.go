@@ -0,0 +1,211 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StatusKey is the key of the Status kept at a destination.
+const StatusKey = metaPrefix + "status.json"
+
+// Status records the outcome of checks made of a destination, for
+// monitoring to read.
+type Status struct {
+	Drill *DrillResult `json:"drill,omitempty"` // the last drill
+}
+
+// ReadStatus reads the status of dst. If there is none, it returns an
+// empty Status.
+func ReadStatus(ctx context.Context, dst Destination) (*Status, error) {
+	data, err := readObject(ctx, dst, StatusKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Status{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read status: %w", err)
+	}
+	var s Status
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse status: %w", err)
+	}
+	return &s, nil
+}
+
+func writeStatus(ctx context.Context, dst Destination, s *Status) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := dst.Put(ctx, StatusKey, bytes.NewReader(data), ObjectMeta{Size: int64(len(data)), ModTime: time.Now(), ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("write status: %w", err)
+	}
+	return nil
+}
+
+// DrillOptions configures a Drill.
+type DrillOptions struct {
+	From Destination // where the backup is stored
+	// Sample is how many files to restore. The default is 10.
+	Sample int
+	// Keys maps keys back to paths. It must be the mapper the backup was
+	// made with; see Options.Keys. Nil means IdentityKeys{}.
+	Keys KeyMapper
+	// VerifyKey, if set, is the key the manifest must be signed with.
+	VerifyKey ed25519.PublicKey
+	// TempDir is the directory the files are restored under. The default
+	// is os.TempDir().
+	TempDir string
+	// NoRecord, if true, leaves the status at From untouched.
+	NoRecord bool
+	// Log, if set, receives the name of each file as it is restored.
+	Log io.Writer
+	// Warnings, if set, receives the warnings of the drill, one to a line.
+	Warnings io.Writer
+}
+
+// DrillResult is the outcome of a Drill.
+type DrillResult struct {
+	Time     time.Time `json:"time"`
+	Manifest time.Time `json:"manifest"` // when the manifest checked against was written
+	Passed   bool      `json:"passed"`   // whether every file sampled was restored intact
+	Sampled  int       `json:"sampled"`
+	// Verified is how many of them matched the content hash the manifest
+	// records; the others it has none for were checked by size.
+	Verified int `json:"verified"`
+	// Skipped is how many archived files were passed over, since
+	// restoring them from the archive takes hours.
+	Skipped  int      `json:"skipped,omitempty"`
+	Failures []string `json:"failures,omitempty"` // "key: reason"
+}
+
+// Drill restores a random sample of the files listed by the manifest of
+// opts.From into a temporary directory, checks each against the size and,
+// if it is recorded, the content hash the manifest lists for it, and
+// removes the copies. Unless opts.NoRecord is set, the result is then
+// recorded in the destination's Status.
+//
+// Files that cannot be restored or do not match are listed in the
+// result's Failures; the error is for a drill that could not be made.
+func Drill(ctx context.Context, opts DrillOptions) (*DrillResult, error) {
+	if opts.Sample <= 0 {
+		opts.Sample = 10
+	}
+	opts.Keys = keyMapper(opts.Keys)
+	m, err := ReadManifest(ctx, opts.From, opts.VerifyKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no manifest to drill against: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	bundles, err := ReadBundleIndex(ctx, opts.From)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(opts.TempDir, "foldersync-drill-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	res := &DrillResult{Time: time.Now().UTC(), Manifest: m.Created}
+	to := NewLocalDestination(dir)
+	extracted := make(map[string]error) // by bundle
+	for _, i := range rand.Perm(len(m.Files)) {
+		if res.Sampled == opts.Sample {
+			break
+		}
+		e := m.Files[i]
+		key := e.Key
+		b, bundled := bundles.Files[e.Key]
+		if bundled {
+			key = b.Bundle
+		}
+		st, err := archiveStatus(ctx, opts.From, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if !st.readable() {
+			res.Skipped++
+			continue
+		}
+
+		res.Sampled++
+		name, ok := opts.Keys.Path(e.Key)
+		if !ok {
+			res.Failures = append(res.Failures, e.Key+": not a key of the layout")
+			continue
+		}
+		name = localName(name, opts.Warnings)
+		if bundled {
+			err, ok = extracted[b.Bundle]
+			if !ok {
+				err = extractBundle(ctx, opts.From, to, b.Bundle, bundles, opts.Keys, opts.Log, opts.Warnings, nil)
+				extracted[b.Bundle] = err
+			}
+		} else {
+			fmt.Fprintf(logTo(opts.Log), "restore %s\n", e.Key)
+			err = restoreFile(ctx, opts.From, to, e.Key, name, nil, nil, transfer{})
+		}
+		if err == nil {
+			err = checkDrilled(filepath.Join(dir, filepath.FromSlash(name)), e)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			res.Failures = append(res.Failures, fmt.Sprintf("%s: %v", e.Key, err))
+			continue
+		}
+		if e.SHA256 != "" {
+			res.Verified++
+		}
+	}
+	res.Passed = len(res.Failures) == 0
+
+	if !opts.NoRecord {
+		s, err := ReadStatus(ctx, opts.From)
+		if err != nil {
+			return res, err
+		}
+		s.Drill = res
+		if err := writeStatus(ctx, opts.From, s); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// checkDrilled checks the restored file at path against e.
+func checkDrilled(path string, e ManifestEntry) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != e.Size {
+		return fmt.Errorf("restored %d bytes, manifest lists %d", info.Size(), e.Size)
+	}
+	if e.SHA256 == "" {
+		return nil
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(sum); !strings.EqualFold(got, e.SHA256) {
+		return fmt.Errorf("content hash %s, manifest lists %s", got, e.SHA256)
+	}
+	return nil
+}
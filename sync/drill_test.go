@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDrill(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "dir/b.txt", "bravo")
+	writeFile(t, src, "c.txt", "charlie")
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, Manifest: true, ManifestChecksums: true, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same size, other content: only the hash tells.
+	dst.data["dir/b.txt"] = []byte("BRAVO")
+	tmp := t.TempDir()
+	res, err := Drill(context.Background(), DrillOptions{From: dst, Sample: 5, TempDir: tmp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Passed || res.Sampled != 3 || res.Verified != 2 || len(res.Failures) != 1 || !strings.HasPrefix(res.Failures[0], "dir/b.txt: content hash") {
+		t.Errorf("result = %+v, want dir/b.txt to fail its hash", res)
+	}
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("left %d entries in the temporary directory", len(entries))
+	}
+
+	st, err := ReadStatus(context.Background(), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Drill == nil || st.Drill.Passed || st.Drill.Sampled != 3 {
+		t.Errorf("recorded drill = %+v", st.Drill)
+	}
+}
+
+func TestDrill_sample(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"1", "2", "3", "4", "5", "6"} {
+		writeFile(t, src, name+".txt", name)
+	}
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Drill(context.Background(), DrillOptions{From: dst, Sample: 2, NoRecord: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without hashes in the manifest, sizes are checked.
+	if !res.Passed || res.Sampled != 2 || res.Verified != 0 {
+		t.Errorf("result = %+v, want 2 files checked by size", res)
+	}
+	if _, ok := dst.objects[StatusKey]; ok {
+		t.Error("status written with NoRecord")
+	}
+}
+
+func TestDrill_noManifest(t *testing.T) {
+	_, err := Drill(context.Background(), DrillOptions{From: newMockDest()})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err = %v, want ErrNotExist", err)
+	}
+}
+
+func TestSync_manifestChecksumsNeedsStateCache(t *testing.T) {
+	_, err := Sync(context.Background(), Options{Src: t.TempDir(), Dst: newMockDest(), Manifest: true, ManifestChecksums: true})
+	if err == nil {
+		t.Error("no error without a state cache")
+	}
+}
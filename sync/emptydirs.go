@@ -0,0 +1,152 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// DirIndexKey is the key of the DirIndex.
+const DirIndexKey = metaPrefix + "dirs.json"
+
+// DirIndex records the empty directories of the source, which have no
+// objects to stand for them. See Options.KeepEmptyDirs.
+type DirIndex struct {
+	// Dirs holds the key of each empty directory, as the key of a file
+	// with its path would be, in order.
+	Dirs []string `json:"dirs"`
+}
+
+// ReadDirIndex reads the directory index of dst. If there is none, it
+// returns an empty index.
+func ReadDirIndex(ctx context.Context, dst Destination) (*DirIndex, error) {
+	idx := &DirIndex{}
+	data, err := readObject(ctx, dst, DirIndexKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read directory index: %w", err)
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("read directory index: %w", err)
+	}
+	return idx, nil
+}
+
+func writeDirIndex(ctx context.Context, dst Destination, idx *DirIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	meta := ObjectMeta{Size: int64(len(data)), ModTime: time.Now(), ContentType: "application/json"}
+	if err := dst.Put(ctx, DirIndexKey, bytes.NewReader(data), meta); err != nil {
+		return fmt.Errorf("write directory index: %w", err)
+	}
+	return nil
+}
+
+// emptyDirs tracks, as a source is walked, the directories in it that
+// nothing synced has been found in yet, by path relative to the source,
+// with their keys. It is nil unless Options.KeepEmptyDirs is set.
+type emptyDirs map[string]string
+
+func newEmptyDirs(opts Options) emptyDirs {
+	if !opts.KeepEmptyDirs {
+		return nil
+	}
+	return make(emptyDirs)
+}
+
+// dir records the directory at rel, stored under key, which is not empty
+// for its parent.
+func (e emptyDirs) dir(rel, key string) {
+	if e == nil {
+		return
+	}
+	delete(e, dirOf(rel))
+	if rel != "." {
+		e[rel] = key
+	}
+}
+
+// file records a file synced at rel, whose directory is then not empty.
+func (e emptyDirs) file(rel string) {
+	delete(e, dirOf(rel))
+}
+
+// keys returns the keys of the directories left empty once the walk is
+// over.
+func (e emptyDirs) keys() []string {
+	return slices.Collect(maps.Values(e))
+}
+
+// applyEmptyDirs reports the empty directories that are new since the last
+// run, and replaces the directory index with plan.emptyDirs if they
+// differ.
+func applyEmptyDirs(ctx context.Context, opts Options, plan *Plan) error {
+	if !opts.KeepEmptyDirs {
+		return nil
+	}
+	old, err := ReadDirIndex(ctx, opts.Dst)
+	if err != nil {
+		return err
+	}
+	dirs := plan.emptyDirs
+	if dirs == nil {
+		dirs = []string{}
+	}
+	slices.Sort(dirs)
+	if slices.Equal(old.Dirs, dirs) {
+		return nil
+	}
+	for _, key := range dirs {
+		if _, ok := slices.BinarySearch(old.Dirs, key); !ok {
+			opts.report(Event{Action: "mkdir", Key: key + "/", Reason: "empty directory"})
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return writeDirIndex(ctx, opts.Dst, &DirIndex{Dirs: dirs})
+}
+
+// restoreEmptyDirs creates in to the empty directories recorded in the
+// directory index of opts.From that opts selects.
+func restoreEmptyDirs(ctx context.Context, opts RestoreOptions, to *LocalDestination) error {
+	if opts.Snapshot != "" {
+		return nil // the index describes the latest run
+	}
+	idx, err := ReadDirIndex(ctx, opts.From)
+	if err != nil {
+		return err
+	}
+	for _, key := range idx.Dirs {
+		name, ok := opts.Keys.Path(key)
+		if !ok || !opts.selected(name) {
+			continue
+		}
+		path, err := to.path(localName(name, opts.Warnings))
+		if err != nil {
+			return fmt.Errorf("restore %s/: %w", key, err)
+		}
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		opts.logf("mkdir %s/", name)
+		if opts.DryRun {
+			continue
+		}
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("restore %s/: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSync_keepEmptyDirs(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a/f.txt", "f")
+	writeFile(t, src, "c/.keep.tmp", "ignored")
+	writeFile(t, src, ".foldersyncignore", "*.tmp\n")
+	for _, dir := range []string{"a/empty", "b/x/y", "c"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, KeepEmptyDirs: true}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadDirIndex(context.Background(), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/empty", "b/x/y", "c"}; !slices.Equal(idx.Dirs, want) {
+		t.Errorf("dirs = %v, want %v", idx.Dirs, want)
+	}
+
+	dst.putCalls = nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("second run put %v, want nothing", dst.putCalls)
+	}
+
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, Paths: []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"a/empty", "b/x/y"} {
+		if info, err := os.Stat(filepath.Join(out, dir)); err != nil || !info.IsDir() {
+			t.Errorf("%s not restored as a directory: %v", dir, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "c")); !os.IsNotExist(err) {
+		t.Errorf("c restored outside the paths asked for: %v", err)
+	}
+}
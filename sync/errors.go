@@ -0,0 +1,137 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/aws/smithy-go"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrSourceMissing is returned by runs whose source directory does not
+// exist or is not a directory, as when a drive is not mounted.
+var ErrSourceMissing = errors.New("source directory missing")
+
+// ErrDestinationUnreachable is matched, with errors.Is, by the errors of
+// runs that failed because the destination could not be reached: its name
+// did not resolve, connections to it failed or timed out, or a gateway in
+// front of it answered that it was unavailable. Such failures are usually
+// transient, and worth retrying later.
+var ErrDestinationUnreachable = errors.New("destination unreachable")
+
+// ErrAccessDenied is matched, with errors.Is, by the errors of runs that
+// failed because the destination refused the credentials they were made
+// with, or did not permit what they did. Retrying will not help until the
+// credentials or the permissions are fixed.
+var ErrAccessDenied = errors.New("access denied")
+
+// FailedError is the error of a run that kept going past files that
+// failed, with Options.KeepGoing. Like the errors errors.Join returns, it
+// wraps every failure, so that errors.Is and errors.As look through all of
+// them: a run whose failures were all ErrDestinationUnreachable can tell
+// so with errors.Is on each of Failed.
+type FailedError struct {
+	Uploads int          // files the run set out to upload
+	Failed  []*FileError // in the order they failed, as in the Result
+}
+
+func (e *FailedError) Error() string {
+	return fmt.Sprintf("%d of %d uploads failed, nothing was deleted; the first: %v", len(e.Failed), e.Uploads, e.Failed[0])
+}
+
+func (e *FailedError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f
+	}
+	return errs
+}
+
+// classified is an error that also matches kind, without changing its
+// message.
+type classified struct {
+	err  error
+	kind error
+}
+
+func (e *classified) Error() string { return e.err.Error() }
+
+func (e *classified) Unwrap() []error { return []error{e.err, e.kind} }
+
+// classify returns err, made to match ErrAccessDenied or
+// ErrDestinationUnreachable if it is such a failure.
+func classify(err error) error {
+	if err == nil || errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrDestinationUnreachable) {
+		return err
+	}
+	if kind := failureKind(err); kind != nil {
+		return &classified{err: err, kind: kind}
+	}
+	return err
+}
+
+// authCodes are the error codes of S3 and compatible services for requests
+// refused for their credentials or permissions.
+var authCodes = map[string]bool{
+	"AccessDenied":          true,
+	"AllAccessDisabled":     true,
+	"ExpiredToken":          true,
+	"InvalidAccessKeyId":    true,
+	"InvalidToken":          true,
+	"SignatureDoesNotMatch": true,
+	"TokenRefreshRequired":  true,
+}
+
+// failureKind returns ErrAccessDenied or ErrDestinationUnreachable if err
+// is such a failure, or else nil.
+func failureKind(err error) error {
+	switch {
+	case errors.Is(err, ErrObjectLocked):
+		// S3 denies access to delete locked objects.
+		return nil
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// The run's own doing, not the destination's.
+		return nil
+	}
+	var ae smithy.APIError
+	if errors.As(err, &ae) && authCodes[ae.ErrorCode()] {
+		return ErrAccessDenied
+	}
+	switch httpStatus(err) {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrAccessDenied
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrDestinationUnreachable
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr), errors.As(err, &opErr),
+		errors.As(err, &netErr) && netErr.Timeout(),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrDestinationUnreachable
+	}
+	return nil
+}
+
+// httpStatus returns the status of the HTTP response err reports, from any
+// of the destinations, or 0 if it reports none.
+func httpStatus(err error) int {
+	var re interface{ HTTPStatusCode() int }
+	var ge *googleapi.Error
+	switch {
+	case errors.As(err, &re):
+		return re.HTTPStatusCode()
+	case errors.As(err, &ge):
+		return ge.Code
+	case httpDestStatus(err) != 0:
+		return httpDestStatus(err)
+	}
+	return webdavStatus(err)
+}
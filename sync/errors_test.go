@@ -0,0 +1,93 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	for _, tt := range []struct {
+		err  error
+		want error
+	}{
+		{refused, ErrDestinationUnreachable},
+		{&net.DNSError{Err: "no such host", Name: "bucket.example", IsNotFound: true}, ErrDestinationUnreachable},
+		{&httpDestError{Method: "PUT", Key: "a", StatusCode: http.StatusServiceUnavailable}, ErrDestinationUnreachable},
+		{&httpDestError{Method: "PUT", Key: "a", StatusCode: http.StatusForbidden}, ErrAccessDenied},
+		{&webdavError{Method: "PUT", Key: "a", StatusCode: http.StatusUnauthorized}, ErrAccessDenied},
+		{&httpDestError{Method: "PUT", Key: "a", StatusCode: http.StatusInternalServerError}, nil},
+		{fmt.Errorf("%w: %w", ErrObjectLocked, &webdavError{StatusCode: http.StatusForbidden}), nil},
+		{context.DeadlineExceeded, nil},
+		{io.ErrUnexpectedEOF, nil},
+	} {
+		err := classify(&FileError{Op: "upload", Key: "a", Err: tt.err})
+		for _, kind := range []error{ErrDestinationUnreachable, ErrAccessDenied} {
+			if errors.Is(err, kind) != (kind == tt.want) {
+				t.Errorf("classify(%v) matches %v: %v, want %v", tt.err, kind, errors.Is(err, kind), kind == tt.want)
+			}
+		}
+		if want := "upload a: " + tt.err.Error(); err.Error() != want {
+			t.Errorf("classify(%v) = %q, want the message unchanged", tt.err, err)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("classify(%v) no longer wraps it", tt.err)
+		}
+	}
+}
+
+// unreachablePut fails to upload key as if its destination could not be
+// connected to.
+type unreachablePut struct {
+	*mockDest
+	key string
+}
+
+func (d unreachablePut) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if key == d.key {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	}
+	return d.mockDest.Put(ctx, key, r, meta)
+}
+
+func TestSync_errors(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	dst := unreachablePut{newMockDest(), "b.txt"}
+
+	res, err := Sync(context.Background(), Options{Src: src, Dst: dst, KeepGoing: true})
+	var failed *FailedError
+	if !errors.As(err, &failed) || len(failed.Failed) != 1 || failed.Uploads != 2 {
+		t.Fatalf("Sync = %v, want a *FailedError for b.txt", err)
+	}
+	if !errors.Is(err, ErrDestinationUnreachable) || !errors.Is(res.Summary.Err, ErrDestinationUnreachable) {
+		t.Errorf("Sync = %v, want it to match ErrDestinationUnreachable", err)
+	}
+	if f := res.Failed[0]; f.Op != "upload" || f.Key != "b.txt" || !errors.Is(f, ErrDestinationUnreachable) {
+		t.Errorf("Failed = %v, want the upload of b.txt, unreachable", f)
+	}
+
+	// Without KeepGoing, the run fails at the file.
+	_, err = Sync(context.Background(), Options{Src: src, Dst: dst})
+	var fe *FileError
+	if !errors.As(err, &fe) || fe.Key != "b.txt" || !errors.Is(err, ErrDestinationUnreachable) {
+		t.Errorf("Sync = %v, want a *FileError for b.txt, unreachable", err)
+	}
+
+	_, err = Sync(context.Background(), Options{Src: filepath.Join(src, "gone"), Dst: dst})
+	if !errors.Is(err, ErrSourceMissing) {
+		t.Errorf("Sync of a missing source = %v, want ErrSourceMissing", err)
+	}
+	_, err = Sync(context.Background(), Options{Src: filepath.Join(src, "a.txt"), Dst: dst})
+	if !errors.Is(err, ErrSourceMissing) {
+		t.Errorf("Sync of a file = %v, want ErrSourceMissing", err)
+	}
+}
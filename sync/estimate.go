@@ -0,0 +1,177 @@
+package sync
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// StoragePrice is what keeping data in a storage class costs, at a
+// destination's list prices in US dollars.
+type StoragePrice struct {
+	Class       string
+	GBMonth     float64 // storing a GiB for a month
+	RetrievalGB float64 // reading a GiB back, besides transferring it out
+	Requests    RequestPrices
+
+	// MinDays is the fewest days an object is billed for: replacing or
+	// deleting it sooner costs the rest.
+	MinDays int
+	// MinObjectSize is the size smaller objects are billed as.
+	MinObjectSize int64
+	// ObjectOverhead is what is billed for each object besides its data,
+	// such as the index S3 keeps of archived objects.
+	ObjectOverhead int64
+
+	// Note says how reading the data back works, if not as reading an
+	// object usually does.
+	Note string
+}
+
+// StoragePricer is implemented by destinations that know what storing data
+// costs, for the projection printed by Options.Estimate.
+type StoragePricer interface {
+	// StoragePrices returns the prices of the storage classes the
+	// destination can store objects in, the one it stores them in first.
+	StoragePrices() []StoragePrice
+}
+
+// partSizer is implemented by destinations that upload files larger than
+// a part in several requests.
+type partSizer interface {
+	// uploadPartSize returns the size of the parts files larger than one
+	// are uploaded in, and how many requests besides the parts such an
+	// upload makes.
+	uploadPartSize() (size int64, extra int)
+}
+
+// uploadRequests returns the number of requests uploading a file of size
+// bytes makes to a destination that uploads in parts of parts, if not nil.
+func uploadRequests(parts partSizer, size int64) int {
+	if parts == nil {
+		return 1
+	}
+	part, extra := parts.uploadPartSize()
+	if part <= 0 || size <= part {
+		return 1
+	}
+	// S3 and B2 raise the part size of files that would need more than
+	// 10,000 parts.
+	return int(min((size+part-1)/part, 10000)) + extra
+}
+
+// putRequests returns the number of requests uploading the files of plan
+// makes: a request per part of the uploads, one per copy of a renamed or
+// duplicate file, and one per bundle.
+func putRequests(opts Options, plan *Plan) int {
+	n := 0
+	for _, f := range plan.Uploads {
+		_, renamed := plan.renamed[f.Key]
+		if _, duplicate := plan.duplicates[f.Key]; renamed || duplicate {
+			n++
+		} else {
+			n += uploadRequests(opts.parts, f.Size)
+		}
+	}
+	var bundled int64
+	for _, f := range plan.Bundled {
+		bundled += f.Size
+	}
+	if bundled > 0 {
+		bundles := int64(1)
+		if opts.Bundle.MaxSize > 0 {
+			bundles = (bundled + opts.Bundle.MaxSize - 1) / opts.Bundle.MaxSize
+		}
+		n += int(bundles)
+	}
+	return n
+}
+
+// printProjection prints what applying plan would upload and, if Dst is a
+// StoragePricer, what storing the source would then cost in each of its
+// storage classes.
+func printProjection(opts Options, plan *Plan) {
+	w := opts.log()
+	var upload int64
+	for _, f := range slices.Concat(plan.Uploads, plan.Bundled) {
+		upload += f.Size
+	}
+	fmt.Fprintf(w, "projected upload: %d files, %s, in %d PUT requests\n",
+		len(plan.Uploads)+len(plan.Bundled), formatBytes(upload), putRequests(opts, plan))
+
+	var stored int64
+	for _, f := range plan.Files {
+		stored += f.Size
+	}
+	fmt.Fprintf(w, "projected storage: %d files, %s\n", len(plan.Files), formatBytes(stored))
+
+	prices := opts.storagePrices
+	if len(prices) == 0 {
+		fmt.Fprintln(w, "storage prices are not known for this destination")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "class\t$/GB-month\tmonthly\tupload\tretrieval $/GB\tfull restore\tminimum")
+	for i, sp := range prices {
+		billed := billedBytes(plan.Files, sp)
+		gb := float64(billed) / (1 << 30)
+		class := sp.Class
+		if i == 0 {
+			class += " (this)"
+		}
+		restore := gb*sp.RetrievalGB + float64(len(plan.Files))*sp.Requests.Read/1000
+		fmt.Fprintf(tw, "%s\t%.5f\t$%.2f\t$%.2f\t%.3f\t$%.2f\t%s\n", class, sp.GBMonth, gb*sp.GBMonth,
+			float64(putRequests(opts, plan))*sp.Requests.Write/1000, sp.RetrievalGB, restore, minimums(sp))
+	}
+	tw.Flush()
+	for _, sp := range prices {
+		if sp.Note != "" {
+			fmt.Fprintf(w, "%s: %s\n", sp.Class, sp.Note)
+		}
+	}
+	fmt.Fprintln(w, "monthly is for the files of the source as separate objects, before compression; "+
+		"upload is the PUT requests; full restore is retrieval and GET requests, without data transfer out")
+}
+
+// billedBytes returns how many bytes storing files as objects in the
+// storage class sp prices is billed for.
+func billedBytes(files []File, sp StoragePrice) int64 {
+	var n int64
+	for _, f := range files {
+		n += max(f.Size, sp.MinObjectSize) + sp.ObjectOverhead
+	}
+	return n
+}
+
+// minimums describes the minimum storage duration and object size of sp.
+func minimums(sp StoragePrice) string {
+	var s []string
+	if sp.MinDays > 0 {
+		s = append(s, fmt.Sprintf("%d days", sp.MinDays))
+	}
+	if sp.MinObjectSize > 0 {
+		s = append(s, formatBytes(sp.MinObjectSize)+" objects")
+	}
+	if len(s) == 0 {
+		return "-"
+	}
+	return strings.Join(s, ", ")
+}
+
+// formatBytes formats n in the largest binary unit it is at least one of.
+func formatBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if v == float64(int64(v)) {
+		return fmt.Sprintf("%d %ciB", int64(v), units[i])
+	}
+	return fmt.Sprintf("%.1f %ciB", v, units[i])
+}
@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// pricedDest is a mockDest with the storage prices of two classes that
+// uploads files larger than 4 bytes in parts of 4.
+type pricedDest struct {
+	*mockDest
+}
+
+func (pricedDest) StoragePrices() []StoragePrice {
+	return []StoragePrice{
+		{Class: "COLD", GBMonth: 0.004, RetrievalGB: 0.03, Requests: RequestPrices{Write: 0.02, Read: 0.01}, MinDays: 90, MinObjectSize: 128 << 10},
+		{Class: "HOT", GBMonth: 0.023, Requests: RequestPrices{Write: 0.005, Read: 0.0004}},
+	}
+}
+
+func (pricedDest) uploadPartSize() (int64, int) { return 4, 2 }
+
+func TestSync_estimate(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")     // 2 parts, and 2 more requests
+	writeFile(t, src, "b.txt", "hi")        // 1 request
+	writeFile(t, src, "c.txt", "as it was") // already uploaded
+	dst := pricedDest{newMockDest()}
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	dst.Delete(ctx, "a.txt")
+	dst.Delete(ctx, "b.txt")
+	dst.putCalls = nil
+
+	var log bytes.Buffer
+	res, err := Sync(ctx, Options{Src: src, Dst: dst, DryRun: true, Estimate: true, Log: &log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 || len(res.Changes) != 0 {
+		t.Errorf("an estimate uploaded %v and reported %v", dst.putCalls, res.Changes)
+	}
+	out := log.String()
+	for _, want := range []string{
+		"projected upload: 2 files, 7 B, in 5 PUT requests\n",
+		"projected storage: 3 files, 16 B\n",
+		"COLD (this)", "HOT ", "90 days, 128 KiB objects",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("estimate does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "upload a.txt") {
+		t.Errorf("estimate lists the changes:\n%s", out)
+	}
+}
+
+func TestBilledBytes(t *testing.T) {
+	files := []File{{Size: 1 << 20}, {Size: 10}}
+	if got, want := billedBytes(files, StoragePrice{MinObjectSize: 128 << 10}), int64(1<<20+128<<10); got != want {
+		t.Errorf("billedBytes with a minimum object size = %d, want %d", got, want)
+	}
+	if got, want := billedBytes(files, StoragePrice{ObjectOverhead: 40 << 10}), int64(1<<20+10+80<<10); got != want {
+		t.Errorf("billedBytes with an overhead per object = %d, want %d", got, want)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:          "0 B",
+		1023:       "1023 B",
+		128 << 10:  "128 KiB",
+		1536 << 20: "1.5 GiB",
+		2 << 40:    "2 TiB",
+	} {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"io"
+	stdsync "sync"
+	"sync/atomic"
+)
+
+// RunEvent is an event of a run as it goes, passed to Options.OnRunEvent:
+// a WalkStarted, FileQueued, UploadProgress, FileDone, DeleteDone or
+// RunComplete.
+type RunEvent interface {
+	runEvent()
+}
+
+// WalkStarted is sent as a run starts walking a source directory.
+type WalkStarted struct {
+	Dir    string
+	Prefix string // the key prefix of its files; see Options.Sources
+}
+
+// FileQueued is sent for each file a run is to upload, or copy from
+// another object, once it has planned its changes. Dry runs send it too.
+type FileQueued struct {
+	Key  string
+	Size int64
+}
+
+// UploadProgress is sent as the content of a queued file is read to
+// upload it. It is not sent for chunked files. See Options.Chunk.
+type UploadProgress struct {
+	Key  string
+	Sent int64 // bytes read so far
+	Size int64 // bytes to read: only the data of a sparse file
+}
+
+// FileDone is sent once a queued file has been uploaded, or has failed
+// to be.
+type FileDone struct {
+	Key string
+	Err error // nil if the file was uploaded
+}
+
+// DeleteDone is sent for each object a run deletes.
+type DeleteDone struct {
+	Key string
+}
+
+// RunComplete is sent last, once a run, or a batch of changes Watch
+// syncs, has finished.
+type RunComplete struct {
+	Summary Summary
+}
+
+func (WalkStarted) runEvent()    {}
+func (FileQueued) runEvent()     {}
+func (UploadProgress) runEvent() {}
+func (FileDone) runEvent()       {}
+func (DeleteDone) runEvent()     {}
+func (RunComplete) runEvent()    {}
+
+// eventStream passes the events of a run to Options.OnRunEvent one at a
+// time, although the parts of a file may be read for upload from several
+// goroutines at once.
+type eventStream struct {
+	mu stdsync.Mutex
+	fn func(RunEvent)
+}
+
+// newEventStream returns a stream calling fn, or nil if fn is.
+func newEventStream(fn func(RunEvent)) *eventStream {
+	if fn == nil {
+		return nil
+	}
+	return &eventStream{fn: fn}
+}
+
+// emit passes e to opts.OnRunEvent, if it is set.
+func (opts Options) emit(e RunEvent) {
+	s := opts.events
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fn(e)
+}
+
+// uploadBody is the content of a file read to upload it: the file itself
+// or the data of a sparse file.
+type uploadBody interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// trackUpload returns body, of size bytes, sending UploadProgress for key
+// as it is read if opts.OnRunEvent is set.
+func (opts Options) trackUpload(key string, body uploadBody, size int64) uploadBody {
+	if opts.events == nil {
+		return body
+	}
+	return &progressBody{uploadBody: body, opts: opts, key: key, size: size}
+}
+
+// progressBody counts the bytes read from an uploadBody. Destinations may
+// read parts at once from several goroutines, and read them again to retry
+// them, so the count is capped at the size and Sent only ever grows.
+type progressBody struct {
+	uploadBody
+	opts Options
+	key  string
+	size int64
+
+	read atomic.Int64
+	mu   stdsync.Mutex
+	sent int64 // guarded by mu
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.uploadBody.Read(p)
+	b.count(n)
+	return n, err
+}
+
+func (b *progressBody) ReadAt(p []byte, off int64) (int, error) {
+	n, err := b.uploadBody.ReadAt(p, off)
+	b.count(n)
+	return n, err
+}
+
+func (b *progressBody) count(n int) {
+	if n <= 0 {
+		return
+	}
+	read := min(b.read.Add(int64(n)), b.size)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if read > b.sent {
+		b.sent = read
+		b.opts.emit(UploadProgress{Key: b.key, Sent: read, Size: b.size})
+	}
+}
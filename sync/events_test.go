@@ -0,0 +1,89 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	stdsync "sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSync_onRunEvent(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	dst := newMockDest()
+	dst.objects["old.txt"] = &ObjectMeta{Size: 3}
+
+	var events []RunEvent
+	opts := Options{Src: src, Dst: dst, Delete: true, OnRunEvent: func(e RunEvent) { events = append(events, e) }}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	want := []RunEvent{
+		WalkStarted{Dir: src},
+		FileQueued{Key: "a.txt", Size: 5},
+		UploadProgress{Key: "a.txt", Sent: 5, Size: 5},
+		FileDone{Key: "a.txt"},
+		DeleteDone{Key: "old.txt"},
+	}
+	if len(events) != len(want)+1 {
+		t.Fatalf("events %+v, want %+v and RunComplete", events, want)
+	}
+	for i, e := range want {
+		if events[i] != e {
+			t.Errorf("event %d: %+v, want %+v", i, events[i], e)
+		}
+	}
+	if rc, ok := events[len(want)].(RunComplete); !ok || rc.Summary.Uploaded != 1 || rc.Summary.Deleted != 1 {
+		t.Errorf("last event %+v, want RunComplete of one upload and one delete", events[len(want)])
+	}
+}
+
+func TestTrackUpload_concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big")
+	const size, parts = 1 << 16, 16
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var busy atomic.Bool
+	var sent []int64
+	opts := Options{}
+	opts.events = newEventStream(func(e RunEvent) {
+		if !busy.CompareAndSwap(false, true) {
+			t.Error("OnRunEvent called concurrently")
+		}
+		sent = append(sent, e.(UploadProgress).Sent)
+		busy.Store(false)
+	})
+	body := opts.trackUpload("big", f, size)
+
+	// Read every part twice, as a destination retrying them would.
+	var wg stdsync.WaitGroup
+	for i := range 2 * parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := make([]byte, size/parts)
+			if _, err := body.ReadAt(p, int64(i%parts)*size/parts); err != nil && err != io.EOF {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 1; i < len(sent); i++ {
+		if sent[i] <= sent[i-1] {
+			t.Fatalf("progress went from %d to %d", sent[i-1], sent[i])
+		}
+	}
+	if got := sent[len(sent)-1]; got != size {
+		t.Errorf("progress ended at %d bytes, want %d", got, size)
+	}
+}
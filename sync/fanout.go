@@ -0,0 +1,291 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	gosync "sync"
+)
+
+// FanOutTarget is one of the destinations of a FanOut.
+type FanOutTarget struct {
+	Name string // for errors and results, such as the destination's URL
+	Dst  Destination
+}
+
+// DestinationResult describes what a run did at one of the destinations
+// of a FanOut.
+type DestinationResult struct {
+	Name     string
+	Uploaded int      // objects written
+	Deleted  int      // objects deleted
+	Failed   []string // keys that failed to be written or deleted there
+}
+
+// FanOut is a Destination writing to several destinations at once, such
+// as a bucket and a USB drive, so that a run walks, compares and reads
+// each source file once however many copies it makes. Sync reports what
+// it did at each in Result.Destinations.
+//
+// An object is up to date only if every destination holds the same copy
+// of it: Stat reports it missing if any does not, and the file is
+// uploaded to all of them again. A Put or Delete that fails at any
+// destination fails, naming it, so that the file is retried by the next
+// run, or with Options.KeepGoing is recorded as failed. List lists the
+// keys held by any of them, all at once. Get reads from the first
+// destination that can read objects back. FanOut implements no other
+// optional interface, so features needing a Copier, such as
+// Options.Snapshots and Options.DetectRenames, cannot be used.
+type FanOut struct {
+	targets  []FanOutTarget
+	parallel bool
+
+	mu      gosync.Mutex
+	results []DestinationResult
+}
+
+// NewFanOut creates a FanOut writing to targets, one after another, or if
+// parallel is set, to all of them at once.
+func NewFanOut(parallel bool, targets ...FanOutTarget) *FanOut {
+	f := &FanOut{targets: targets, parallel: parallel}
+	f.reset()
+	return f
+}
+
+// reset clears the results, at the start of a run.
+func (f *FanOut) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results = make([]DestinationResult, len(f.targets))
+	for i, t := range f.targets {
+		f.results[i].Name = t.Name
+	}
+}
+
+// Results returns what each destination was sent since the run began, in
+// the order of the targets.
+func (f *FanOut) Results() []DestinationResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	results := slices.Clone(f.results)
+	for i := range results {
+		results[i].Failed = slices.Clone(results[i].Failed)
+	}
+	return results
+}
+
+// record counts the outcome of writing, or deleting, key at target i.
+func (f *FanOut) record(i int, key string, deleted bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := &f.results[i]
+	switch {
+	case err != nil:
+		r.Failed = append(r.Failed, key)
+	case deleted:
+		r.Deleted++
+	default:
+		r.Uploaded++
+	}
+}
+
+// each calls fn for every target, at once if parallel is set, and returns
+// the errors, each naming its target.
+func (f *FanOut) each(parallel bool, fn func(i int, t FanOutTarget) error) error {
+	errs := make([]error, len(f.targets))
+	call := func(i int) {
+		if err := fn(i, f.targets[i]); err != nil {
+			errs[i] = fmt.Errorf("%s: %w", f.targets[i].Name, err)
+		}
+	}
+	if !parallel {
+		for i := range f.targets {
+			call(i)
+		}
+		return errors.Join(errs...)
+	}
+	var wg gosync.WaitGroup
+	for i := range f.targets {
+		wg.Go(func() { call(i) })
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Put writes r to every destination. A body that can be read again, as
+// files can, is read again for each, from a section of its own when they
+// are written in parallel. Any other is read once and streamed to all of
+// them at once, whether f is parallel or not.
+func (f *FanOut) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	bodies, err := f.bodies(r)
+	if err != nil {
+		return err
+	}
+	if bodies == nil {
+		return f.stream(ctx, key, r, meta)
+	}
+	return f.each(f.parallel, func(i int, t FanOutTarget) error {
+		body, err := bodies(i)
+		if err == nil {
+			err = t.Dst.Put(ctx, key, body, meta)
+		}
+		f.record(i, key, false, err)
+		return err
+	})
+}
+
+// bodies returns a function giving the body to put at each target, or nil
+// if r can only be read once.
+func (f *FanOut) bodies(r io.Reader) (func(i int) (io.Reader, error), error) {
+	seeker, _ := r.(io.Seeker)
+	rw, _ := r.(rewinder)
+	ra, _ := r.(io.ReaderAt)
+	switch {
+	case f.parallel && seeker != nil && ra != nil:
+		size, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		return func(int) (io.Reader, error) { return io.NewSectionReader(ra, 0, size), nil }, nil
+	case !f.parallel && seeker != nil:
+		return func(int) (io.Reader, error) {
+			_, err := seeker.Seek(0, io.SeekStart)
+			return r, err
+		}, nil
+	case !f.parallel && rw != nil:
+		return func(i int) (io.Reader, error) {
+			if i == 0 {
+				return r, nil
+			}
+			return r, rw.Rewind()
+		}, nil
+	}
+	return nil, nil
+}
+
+// errFanOutStopped fails the writes to a destination that stopped reading
+// the body streamed to it.
+var errFanOutStopped = errors.New("upload stopped")
+
+// stream reads r once, writing what it reads to every destination at
+// once. A destination that fails stops being written to; the others go on.
+func (f *FanOut) stream(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	pws := make([]*io.PipeWriter, len(f.targets))
+	prs := make([]*io.PipeReader, len(f.targets))
+	for i := range f.targets {
+		prs[i], pws[i] = io.Pipe()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f.each(true, func(i int, t FanOutTarget) error {
+			err := t.Dst.Put(ctx, key, prs[i], meta)
+			prs[i].CloseWithError(errFanOutStopped)
+			f.record(i, key, false, err)
+			return err
+		})
+	}()
+	buf := make([]byte, 32<<10)
+	var readErr error
+	for live := len(pws); live > 0; {
+		n, err := r.Read(buf)
+		for i, pw := range pws {
+			if pw == nil || n == 0 {
+				continue
+			}
+			if _, werr := pw.Write(buf[:n]); werr != nil {
+				pws[i] = nil
+				live--
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
+			}
+			break
+		}
+	}
+	for _, pw := range pws {
+		if pw != nil {
+			pw.CloseWithError(readErr)
+		}
+	}
+	return errors.Join(readErr, <-done)
+}
+
+// Stat reports the metadata of key if every destination holds the same
+// copy of it, and nil otherwise.
+func (f *FanOut) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	metas := make([]*ObjectMeta, len(f.targets))
+	err := f.each(f.parallel, func(i int, t FanOutTarget) (err error) {
+		metas[i], err = t.Dst.Stat(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range metas {
+		if m == nil || !sameCopy(m, metas[0]) {
+			return nil, nil
+		}
+	}
+	return metas[0], nil
+}
+
+// sameCopy reports whether a and b describe copies of the same file,
+// uploaded the same way.
+func sameCopy(a, b *ObjectMeta) bool {
+	return a.Size == b.Size && a.ModTime.Unix() == b.ModTime.Unix() && a.SHA256 == b.SHA256 &&
+		a.Compression == b.Compression && a.Transform == b.Transform && a.Sparse == b.Sparse && a.Chunked == b.Chunked &&
+		a.POSIX.Equal(b.POSIX)
+}
+
+// Get reads key from the first destination that can read objects back.
+func (f *FanOut) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	for _, t := range f.targets {
+		if g, ok := t.Dst.(Getter); ok {
+			return g.Get(ctx, key)
+		}
+	}
+	return nil, fmt.Errorf("read %s: %w", key, errors.ErrUnsupported)
+}
+
+// fanOutListPage is the most keys a page of FanOut.List holds.
+const fanOutListPage = 1000
+
+// List lists every destination, and then passes on the keys any of them
+// holds, in pages of up to fanOutListPage keys.
+func (f *FanOut) List(ctx context.Context, fn func(keys []string) error) error {
+	var mu gosync.Mutex
+	held := make(map[string]bool)
+	err := f.each(f.parallel, func(i int, t FanOutTarget) error {
+		return t.Dst.List(ctx, func(keys []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, k := range keys {
+				held[k] = true
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for page := range slices.Chunk(slices.Sorted(maps.Keys(held)), fanOutListPage) {
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete deletes key from every destination.
+func (f *FanOut) Delete(ctx context.Context, key string) error {
+	return f.each(f.parallel, func(i int, t FanOutTarget) error {
+		err := t.Dst.Delete(ctx, key)
+		f.record(i, key, true, err)
+		return err
+	})
+}
@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// keyFailingDest is a mockDest that fails to write one key.
+type keyFailingDest struct {
+	*mockDest
+	key string
+}
+
+func (d *keyFailingDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if key == d.key {
+		io.Copy(io.Discard, io.LimitReader(r, 1))
+		return errors.New("disk full")
+	}
+	return d.mockDest.Put(ctx, key, r, meta)
+}
+
+func TestSync_fanOut(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		src := t.TempDir()
+		writeFile(t, src, "a.txt", "alpha")
+		writeFile(t, src, "b.txt", "bravo")
+		s3, usb := newMockDest(), &keyFailingDest{mockDest: newMockDest(), key: "b.txt"}
+		dst := NewFanOut(parallel, FanOutTarget{Name: "s3", Dst: s3}, FanOutTarget{Name: "usb", Dst: usb})
+
+		res, err := Sync(context.Background(), Options{Src: src, Dst: dst, KeepGoing: true})
+		if err == nil || len(res.Failed) != 1 || !strings.Contains(res.Failed[0].Error(), "usb: disk full") {
+			t.Fatalf("parallel %v: %v, failed %v; want b.txt failed at usb", parallel, err, res.Failed)
+		}
+		want := []DestinationResult{{Name: "s3", Uploaded: 2}, {Name: "usb", Uploaded: 1, Failed: []string{"b.txt"}}}
+		if !slices.EqualFunc(res.Destinations, want, func(a, b DestinationResult) bool {
+			return a.Name == b.Name && a.Uploaded == b.Uploaded && slices.Equal(a.Failed, b.Failed)
+		}) {
+			t.Errorf("parallel %v: destinations %+v, want %+v", parallel, res.Destinations, want)
+		}
+
+		// Only the file missing at one of them is uploaded again, to both.
+		usb.key = ""
+		s3.putCalls, usb.putCalls = nil, nil
+		res, err = Sync(context.Background(), Options{Src: src, Dst: dst})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(s3.putCalls, []string{"b.txt"}) || !slices.Equal(usb.putCalls, []string{"b.txt"}) {
+			t.Errorf("parallel %v: uploaded %v and %v, want b.txt to both", parallel, s3.putCalls, usb.putCalls)
+		}
+		if res.Destinations[1].Uploaded != 1 || len(res.Destinations[1].Failed) != 0 {
+			t.Errorf("parallel %v: usb %+v, want the counts of this run only", parallel, res.Destinations[1])
+		}
+		if string(usb.data["b.txt"]) != "bravo" {
+			t.Errorf("parallel %v: usb holds %q", parallel, usb.data["b.txt"])
+		}
+	}
+}
+
+func TestFanOut_streamsOnce(t *testing.T) {
+	a, b := newMockDest(), &keyFailingDest{mockDest: newMockDest(), key: "x"}
+	dst := NewFanOut(false, FanOutTarget{Name: "a", Dst: a}, FanOutTarget{Name: "b", Dst: b})
+	ctx := context.Background()
+
+	// A body that cannot be read again is streamed to both at once.
+	body := strings.Repeat("data", 50000)
+	if err := dst.Put(ctx, "y", io.MultiReader(strings.NewReader(body)), ObjectMeta{Size: int64(len(body))}); err != nil {
+		t.Fatal(err)
+	}
+	if string(a.data["y"]) != body || string(b.data["y"]) != body {
+		t.Errorf("got %d and %d bytes, want %d at both", len(a.data["y"]), len(b.data["y"]), len(body))
+	}
+	// One failing does not stop the other.
+	err := dst.Put(ctx, "x", io.MultiReader(strings.NewReader(body)), ObjectMeta{Size: int64(len(body))})
+	if err == nil || string(a.data["x"]) != body {
+		t.Errorf("got %v, %d bytes at a; want b's error and a written", err, len(a.data["x"]))
+	}
+
+	b.mockDest.data["z"], b.mockDest.objects["z"] = []byte("z"), &ObjectMeta{Size: 1}
+	var keys []string
+	if err := dst.List(ctx, func(page []string) error { keys = append(keys, page...); return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x", "y", "z"}; !slices.Equal(keys, want) {
+		t.Errorf("List = %v, want the keys at either, %v", keys, want)
+	}
+	if meta, err := dst.Stat(ctx, "z"); meta != nil || err != nil {
+		t.Errorf("Stat(z) = %v, %v; want it missing, as a lacks it", meta, err)
+	}
+}
@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"bytes"
+	"io"
+	"math/bits"
+)
+
+// gear is the table of random values FastCDC rolls its hash with. It is
+// generated from a fixed seed, since changing it would move every chunk
+// boundary and so defeat deduplication against chunks already stored.
+var gear = func() (t [256]uint64) {
+	x := uint64(0x666f6c64657273) // splitmix64, seeded with "folders"
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		t[i] = z ^ z>>31
+	}
+	return t
+}()
+
+// chunker splits a stream into content-defined chunks with FastCDC: a
+// boundary is placed where a rolling hash of the last bytes matches a
+// mask, so an insertion or deletion only changes the chunks around it.
+// Chunks are between a quarter and four times the average size, and the
+// hash is normalized to keep most of them near the average.
+type chunker struct {
+	r        io.Reader
+	buf      []byte
+	n        int // bytes of buf holding data
+	eof      bool
+	min, avg int
+	small    uint64 // mask before the average size: harder to match
+	large    uint64 // mask after it: easier
+}
+
+// newChunker returns a chunker of r into chunks of avg bytes on average,
+// which must be a power of two of at least 256.
+func newChunker(r io.Reader, avg int) *chunker {
+	b := bits.Len(uint(avg)) - 1
+	return &chunker{
+		r:     r,
+		buf:   make([]byte, 4*avg),
+		min:   avg / 4,
+		avg:   avg,
+		small: mask(b + 1),
+		large: mask(b - 1),
+	}
+}
+
+// mask returns a mask of n bits, taken from the top of the hash, which
+// depend on the most bytes.
+func mask(n int) uint64 {
+	return (1<<n - 1) << (64 - n)
+}
+
+// next returns the next chunk, or io.EOF after the last.
+func (c *chunker) next() ([]byte, error) {
+	for !c.eof && c.n < len(c.buf) {
+		m, err := c.r.Read(c.buf[c.n:])
+		c.n += m
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+	cut := c.cut(c.buf[:c.n])
+	chunk := bytes.Clone(c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+// cut returns the length of the chunk at the start of b, which holds at
+// most the largest chunk size.
+func (c *chunker) cut(b []byte) int {
+	if len(b) <= c.min {
+		return len(b)
+	}
+	normal := min(c.avg, len(b))
+	var h uint64
+	i := c.min
+	for ; i < normal; i++ {
+		h = h<<1 + gear[b[i]]
+		if h&c.small == 0 {
+			return i + 1
+		}
+	}
+	for ; i < len(b); i++ {
+		h = h<<1 + gear[b[i]]
+		if h&c.large == 0 {
+			return i + 1
+		}
+	}
+	return len(b)
+}
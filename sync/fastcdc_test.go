@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// chunks splits data with a chunker of avg bytes on average.
+func chunks(t *testing.T, data []byte, avg int) [][]byte {
+	t.Helper()
+	c := newChunker(bytes.NewReader(data), avg)
+	var all [][]byte
+	for {
+		chunk, err := c.next()
+		if errors.Is(err, io.EOF) {
+			return all
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, chunk)
+	}
+}
+
+func TestChunker(t *testing.T) {
+	const avg = 4 << 10
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	before := chunks(t, data, avg)
+	if got := bytes.Join(before, nil); !bytes.Equal(got, data) {
+		t.Fatal("chunks do not add up to the input")
+	}
+	for i, c := range before[:len(before)-1] {
+		if len(c) < avg/4 || len(c) > 4*avg {
+			t.Errorf("chunk %d is %d bytes, want %d to %d", i, len(c), avg/4, 4*avg)
+		}
+	}
+	if n := len(before); n < len(data)/avg/2 || n > 2*len(data)/avg {
+		t.Errorf("%d chunks of %d bytes on average, want about %d", n, len(data)/n, len(data)/avg)
+	}
+
+	// An insertion changes only the chunks around it.
+	edited := bytes.Clone(data[:len(data)/2])
+	edited = append(edited, "inserted"...)
+	edited = append(edited, data[len(data)/2:]...)
+	seen := make(map[string]bool)
+	for _, c := range before {
+		seen[string(c)] = true
+	}
+	after := chunks(t, edited, avg)
+	changed := 0
+	for _, c := range after {
+		if !seen[string(c)] {
+			changed++
+		}
+	}
+	if changed > 3 {
+		t.Errorf("%d of %d chunks changed after one insertion", changed, len(after))
+	}
+}
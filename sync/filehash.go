@@ -0,0 +1,131 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"hash"
+	stdsync "sync"
+)
+
+// fileHash holds the SHA-256 of a file's content once it is known. The
+// copies of a File share it, so that comparing the file, uploading it and
+// recording it in the state cache read it to hash it once between them.
+type fileHash struct {
+	mu  stdsync.Mutex
+	sum []byte
+}
+
+// sha256 returns the SHA-256 of f's content, reading the file unless it
+// has been hashed already.
+func (f File) sha256() ([]byte, error) {
+	if f.hash != nil {
+		f.hash.mu.Lock()
+		defer f.hash.mu.Unlock()
+		if f.hash.sum != nil {
+			return f.hash.sum, nil
+		}
+	}
+	sum, err := fileSHA256(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	if f.hash != nil {
+		f.hash.sum = sum
+	}
+	return sum, nil
+}
+
+// hashed reports whether the SHA-256 of f's content is known.
+func (f File) hashed() bool {
+	if f.hash == nil {
+		return false
+	}
+	f.hash.mu.Lock()
+	defer f.hash.mu.Unlock()
+	return f.hash.sum != nil
+}
+
+// setSHA256 records sum as the SHA-256 of f's content.
+func (f File) setSHA256(sum []byte) {
+	if f.hash == nil {
+		return
+	}
+	f.hash.mu.Lock()
+	defer f.hash.mu.Unlock()
+	f.hash.sum = sum
+}
+
+// hashingBody hashes an uploadBody as it is read for an upload, so that
+// a file need not be read again to be hashed afterwards. Only a body read
+// from start to end in order gives a hash: destinations that read parts
+// at once from several goroutines leave the file to be hashed on its own.
+// Parts read again to retry them, and reads after a seek back to the
+// start, are fine.
+type hashingBody struct {
+	uploadBody
+	size int64
+
+	mu   stdsync.Mutex
+	h    hash.Hash
+	pos  int64 // offset Read reads from
+	next int64 // offset the hash has reached
+	gap  bool  // a read skipped ahead of next
+}
+
+func newHashingBody(body uploadBody, size int64) *hashingBody {
+	return &hashingBody{uploadBody: body, size: size, h: sha256.New()}
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.uploadBody.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(p[:n], b.pos)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *hashingBody) ReadAt(p []byte, off int64) (int, error) {
+	n, err := b.uploadBody.ReadAt(p, off)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(p[:n], off)
+	return n, err
+}
+
+func (b *hashingBody) Seek(offset int64, whence int) (int64, error) {
+	pos, err := b.uploadBody.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pos = pos
+	if pos == 0 {
+		// Read again from the start, perhaps to retry a failed upload.
+		b.h.Reset()
+		b.next, b.gap = 0, false
+	}
+	return pos, nil
+}
+
+// add hashes p, read at off, if it continues what has been hashed.
+func (b *hashingBody) add(p []byte, off int64) {
+	end := off + int64(len(p))
+	switch {
+	case off > b.next:
+		b.gap = true
+	case end > b.next:
+		b.h.Write(p[b.next-off:])
+		b.next = end
+	}
+}
+
+// sum returns the SHA-256 of the body, if it was read whole in order.
+func (b *hashingBody) sum() ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.gap || b.next != b.size {
+		return nil, false
+	}
+	return b.h.Sum(nil), true
+}
@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashingBody(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+	want := sha256.Sum256([]byte(content))
+	newBody := func() *hashingBody {
+		return newHashingBody(bytes.NewReader([]byte(content)), int64(len(content)))
+	}
+
+	b := newBody()
+	b.Read(make([]byte, 100)) // a first attempt, given up on
+	b.Seek(0, io.SeekEnd)
+	b.Seek(0, io.SeekStart)
+	io.Copy(io.Discard, b)
+	if sum, ok := b.sum(); !ok || !bytes.Equal(sum, want[:]) {
+		t.Errorf("read again from the start: sum %x, %v; want %x", sum, ok, want)
+	}
+
+	// Parts read in order, one of them twice.
+	b = newBody()
+	p := make([]byte, 4000)
+	for _, off := range []int64{0, 4000, 4000, 8000} {
+		b.ReadAt(p, off)
+	}
+	if sum, ok := b.sum(); !ok || !bytes.Equal(sum, want[:]) {
+		t.Errorf("parts in order: sum %x, %v; want %x", sum, ok, want)
+	}
+
+	b = newBody()
+	for _, off := range []int64{4000, 0, 8000} {
+		b.ReadAt(p, off)
+	}
+	if _, ok := b.sum(); ok {
+		t.Error("parts out of order: got a sum")
+	}
+	b = newBody()
+	b.Read(p)
+	if _, ok := b.sum(); ok {
+		t.Error("read in part: got a sum")
+	}
+}
+
+func TestUpload_hashesAsItReads(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "alpha")
+	want := sha256.Sum256([]byte("alpha"))
+	dst := newMockDest()
+	u := File{Key: "a.txt", Path: filepath.Join(src, "a.txt"), Size: info.Size(), ModTime: info.ModTime(), hash: new(fileHash)}
+	if err := upload(context.Background(), Options{Dst: dst}, u, true); err != nil {
+		t.Fatal(err)
+	}
+	if !u.hashed() {
+		t.Fatal("no hash taken while uploading")
+	}
+	// Taken from the upload: the file is gone, so it was not read again.
+	if err := os.Remove(u.Path); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := u.sha256(); err != nil || !bytes.Equal(sum, want[:]) {
+		t.Errorf("sha256() = %x, %v; want %x", sum, err, want)
+	}
+
+	// With Checksums, the hash comparing took is sent.
+	info = writeFile(t, src, "b.txt", "bravo")
+	u = File{Key: "b.txt", Path: filepath.Join(src, "b.txt"), Size: info.Size(), ModTime: info.ModTime(), hash: new(fileHash)}
+	u.setSHA256([]byte("known"))
+	if err := upload(context.Background(), Options{Dst: dst, Checksums: true}, u, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.objects["b.txt"].SHA256; got != hex.EncodeToString([]byte("known")) {
+		t.Errorf("SHA256 %s sent, want the one known", got)
+	}
+}
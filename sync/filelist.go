@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ReadFileList reads a list of paths for Options.FileList, one per line,
+// or separated by NUL bytes if there are any, as find -print0 and git's
+// -z options write them. Blank entries are skipped, and with lines, a
+// trailing carriage return is dropped.
+func ReadFileList(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sep := []byte("\n")
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = []byte{0}
+	}
+	var names []string
+	for entry := range bytes.SplitSeq(data, sep) {
+		if sep[0] == '\n' {
+			entry = bytes.TrimSuffix(entry, []byte("\r"))
+		}
+		if len(entry) > 0 {
+			names = append(names, string(entry))
+		}
+	}
+	return names, nil
+}
+
+// checkFileList reports whether opts.FileList can be used with the rest of
+// opts: the features that need every file of the source cannot.
+func checkFileList(opts Options) error {
+	if opts.FileList == nil {
+		return nil
+	}
+	switch {
+	case len(opts.Sources) > 0:
+		return errors.New("a file list cannot be combined with several sources")
+	case opts.twoWay:
+		return errors.New("a file list cannot be used in two-way sync")
+	case opts.Manifest:
+		return errors.New("a file list cannot be combined with a manifest, which lists every file")
+	case opts.Incremental:
+		return errors.New("a file list cannot be used in incremental runs")
+	case opts.Bundle != nil:
+		return errors.New("a file list cannot be combined with bundling")
+	case opts.DetectRenames || opts.HardLinks:
+		return errors.New("a file list cannot be combined with rename detection or hard links")
+	case opts.KeepEmptyDirs:
+		return errors.New("a file list cannot be combined with keeping empty directories")
+	case opts.ReportExtraneous != "":
+		return errors.New("extraneous objects cannot be reported with a file list")
+	case opts.Delete && mapsKeys(opts.Keys):
+		return errors.New("a file list cannot delete files stored under a key layout")
+	}
+	return nil
+}
+
+// planFileList adds the files opts.FileList names inside src, and those
+// below the ones that are directories, to plan, in key order. With
+// opts.Delete, the files named that are gone from the source are deleted
+// from the destination, if it has them, but for those the IgnoreFiles
+// exclude, which are deleted whether gone or not with opts.DeleteExcluded. Entries for files not named are
+// kept in the state cache.
+func planFileList(ctx context.Context, opts Options, plan *Plan, src SourceSpec) error {
+	rels := make([]string, 0, len(opts.FileList))
+	for _, name := range opts.FileList {
+		rel := path.Clean(filepath.ToSlash(name))
+		if !fs.ValidPath(rel) {
+			return fmt.Errorf("file list: %q is not a path inside the source", name)
+		}
+		rels = append(rels, rel)
+	}
+	slices.Sort(rels)
+	rels = slices.Compact(rels)
+
+	var walked string // last directory planned in full
+	for _, rel := range rels {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if walked != "" && strings.HasPrefix(rel, walked+"/") {
+			continue
+		}
+		if rel == "." || strings.HasPrefix(rel+"/", metaPrefix) {
+			continue
+		}
+		p := filepath.Join(src.Dir, filepath.FromSlash(rel))
+		info, err := os.Lstat(p)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			skip, err := plan.ignore.ignored(rel, info != nil && info.IsDir())
+			if err != nil {
+				return err
+			}
+			if skip {
+				if opts.Delete && opts.DeleteExcluded && (info == nil || !info.IsDir()) {
+					if err := planListedDelete(ctx, opts, plan, src.Prefix+rel); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if opts.Delete {
+				if err := planListedDelete(ctx, opts, plan, src.Prefix+rel); err != nil {
+					return err
+				}
+			}
+			continue
+		case err != nil:
+			return err
+		case info.IsDir():
+			if err := planUploads(ctx, opts, plan, src, p); err != nil {
+				return err
+			}
+			walked = rel
+			continue
+		}
+		if reason := opts.skipReason(plan, rel, info); reason != "" {
+			key := src.Prefix + fileKey(opts, rel, info.ModTime())
+			plan.Filtered = append(plan.Filtered, File{Key: key, Path: p, Size: info.Size(), ModTime: info.ModTime(), skipped: reason})
+			continue
+		}
+		file, err := newFile(opts, p, rel, info)
+		if err != nil {
+			return err
+		}
+		file.Key = src.Prefix + file.Key
+		if err := planFile(ctx, opts, plan, file); err != nil {
+			return err
+		}
+		plan.state.visited(file.Key)
+		if err := plan.state.checkpoint(); err != nil {
+			return err
+		}
+	}
+	if plan.state != nil {
+		plan.state.keepUnvisited()
+	}
+	return nil
+}
+
+// planListedDelete adds key, named by the file list but gone from the
+// source, to plan.Deletes if the destination has it.
+func planListedDelete(ctx context.Context, opts Options, plan *Plan, key string) error {
+	meta, err := opts.Dst.Stat(ctx, key)
+	if err != nil {
+		return &FileError{Op: "stat", Key: key, Err: err}
+	}
+	if meta != nil {
+		plan.Deletes = append(plan.Deletes, key)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadFileList(t *testing.T) {
+	for in, want := range map[string][]string{
+		"a.txt\nsub/b.txt\n":        {"a.txt", "sub/b.txt"},
+		"a.txt\r\n\r\nb c.txt":      {"a.txt", "b c.txt"},
+		"a.txt\x00new\nline\x00":    {"a.txt", "new\nline"},
+		"":                          nil,
+		"\x00\x00sub/b.txt\x00\x00": {"sub/b.txt"},
+	} {
+		got, err := ReadFileList(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("ReadFileList(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSync_fileList(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "dir/c.txt", "dir/d.txt", "other/e.txt"} {
+		writeFile(t, src, name, name)
+	}
+	dst := newMockDest()
+	state := filepath.Join(t.TempDir(), "state.json")
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, StateCache: state}); err != nil {
+		t.Fatal(err)
+	}
+	dst.objects["gone.txt"] = &ObjectMeta{Size: 1}
+	dst.objects["unlisted.txt"] = &ObjectMeta{Size: 1}
+
+	writeFile(t, src, "a.txt", "changed")
+	writeFile(t, src, "b.txt", "changed")
+	writeFile(t, src, "dir/c.txt", "changed")
+	dst.putCalls, dst.statCalls = nil, nil
+	list := []string{"dir", "./a.txt", "gone.txt", "dir/c.txt", "missing.txt"}
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Delete: true, FileList: list, StateCache: state}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.txt", "dir/c.txt"}; !slices.Equal(dst.putCalls, want) {
+		t.Errorf("uploaded %v, want %v", dst.putCalls, want)
+	}
+	if want := []string{"a.txt", "dir/c.txt", "gone.txt", "missing.txt"}; !slices.Equal(dst.statCalls, want) {
+		t.Errorf("checked %v, want %v", dst.statCalls, want)
+	}
+	if _, ok := dst.objects["gone.txt"]; ok {
+		t.Error("gone.txt was not deleted")
+	}
+	if _, ok := dst.objects["unlisted.txt"]; !ok {
+		t.Error("unlisted.txt was deleted")
+	}
+
+	// The state cache still vouches for the files not listed.
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, StateCache: state}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b.txt"}; !slices.Equal(dst.putCalls, want) || !slices.Equal(dst.statCalls, want) {
+		t.Errorf("next run checked %v and uploaded %v, want only %v", dst.statCalls, dst.putCalls, want)
+	}
+
+	for _, name := range []string{"../outside.txt", "/etc/passwd"} {
+		if _, err := Sync(ctx, Options{Src: src, Dst: dst, FileList: []string{name}}); err == nil {
+			t.Errorf("listing %s: Sync succeeded", name)
+		}
+	}
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, FileList: list, Manifest: true}); err == nil {
+		t.Error("a file list with a manifest: Sync succeeded")
+	}
+}
@@ -0,0 +1,134 @@
+package sync
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filtering reports whether opts has any size or age filter set.
+func (opts Options) filtering() bool {
+	return opts.MinSize > 0 || opts.MaxSize > 0 || !opts.ModifiedAfter.IsZero() || !opts.ModifiedBefore.IsZero()
+}
+
+// filterReason returns why the size and age filters of opts skip a file
+// of size bytes last modified at modTime, or "" if they do not.
+func (opts Options) filterReason(size int64, modTime time.Time) string {
+	switch {
+	case opts.MinSize > 0 && size < opts.MinSize:
+		return "smaller than " + FormatSize(opts.MinSize)
+	case opts.MaxSize > 0 && size > opts.MaxSize:
+		return "larger than " + FormatSize(opts.MaxSize)
+	case !opts.ModifiedAfter.IsZero() && modTime.Before(opts.ModifiedAfter):
+		return "modified before " + opts.ModifiedAfter.Format(time.DateTime)
+	case !opts.ModifiedBefore.IsZero() && !modTime.Before(opts.ModifiedBefore):
+		return "modified after " + opts.ModifiedBefore.Format(time.DateTime)
+	}
+	return ""
+}
+
+var sizeUnits = []string{"B", "KB", "MB", "GB", "TB", "PB"}
+
+// ParseSize parses a size such as "512", "100MB" or "1.5 GB". Units are
+// powers of 1024, and may also be written K, M, G, T, P or KiB, MiB and so
+// on; a bare number is in bytes.
+func ParseSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	num := strings.TrimRight(t, "KMGTPIB ")
+	unit := strings.TrimSpace(t[len(num):])
+	unit = strings.Replace(unit, "IB", "B", 1)
+	if unit != "" && !strings.HasSuffix(unit, "B") {
+		unit += "B"
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	for i, u := range sizeUnits {
+		if unit == u || unit == "" && i == 0 {
+			// float64(math.MaxInt64) rounds up to 1<<63, which is out of range.
+			if n *= float64(int64(1) << (10 * i)); n >= float64(math.MaxInt64) {
+				return 0, fmt.Errorf("invalid size %q: too large", s)
+			}
+			return int64(n), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q: unknown unit", s)
+}
+
+// FormatSize formats n bytes with the largest unit ParseSize accepts that
+// keeps the number at least 1, such as "1.5 GB".
+func FormatSize(n int64) string {
+	i := 0
+	for i < len(sizeUnits)-1 && n >= int64(1)<<(10*(i+1)) {
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := strconv.FormatFloat(float64(n)/float64(int64(1)<<(10*i)), 'f', 1, 64)
+	return strings.TrimSuffix(v, ".0") + " " + sizeUnits[i]
+}
+
+// ParseAge parses a duration such as "30d", "2w" or any duration
+// time.ParseDuration accepts, such as "36h". A day is 24 hours.
+func ParseAge(s string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return d, nil
+	}
+	n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return time.Duration(n * float64(unit)), nil
+}
+
+// FormatDuration formats d for people, to the precision that matters at
+// its length: "850ms", "12.3s", "4m05s", "2h03m" or "3d04h", in days as
+// ParseAge counts them.
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return strconv.FormatFloat(d.Seconds(), 'f', 1, 64) + "s"
+	case d < time.Hour:
+		d = d.Round(time.Second)
+		return fmt.Sprintf("%dm%02ds", d/time.Minute, d%time.Minute/time.Second)
+	case d < 24*time.Hour:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%dh%02dm", d/time.Hour, d%time.Hour/time.Minute)
+	}
+	d = d.Round(time.Hour)
+	return fmt.Sprintf("%dd%02dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+}
+
+// ParseModTime parses a point in time for Options.ModifiedAfter and
+// ModifiedBefore: a date such as "2024-03-01", a time in RFC 3339 format,
+// or an age accepted by ParseAge, such as "30d", counted back from now.
+// Dates are midnight in the local time zone.
+func ParseModTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	age, err := ParseAge(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want a date such as 2024-03-01, an RFC 3339 time or an age such as 30d", s)
+	}
+	return now.Add(-age), nil
+}
@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"0", 0},
+		{"10B", 10},
+		{"100MB", 100 << 20},
+		{"100mb", 100 << 20},
+		{"1.5 GB", 3 << 29},
+		{"4K", 4 << 10},
+		{"2GiB", 2 << 30},
+		{"1TB", 1 << 40},
+		{"8191PB", 8191 << 50},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "MB", "-1MB", "10XB", "10 bytes", "NaN", "Inf", "+Inf", "1e400", "8192PB", "9223372036854775807", "1e19"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) succeeded", in)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1 << 10, "1 KB"},
+		{3 << 29, "1.5 GB"},
+		{100 << 20, "100 MB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.n); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{850 * time.Millisecond, "850ms"},
+		{12340 * time.Millisecond, "12.3s"},
+		{4*time.Minute + 5*time.Second, "4m05s"},
+		{2*time.Hour + 3*time.Minute + 20*time.Second, "2h03m"},
+		{76 * time.Hour, "3d04h"},
+	}
+	for _, tt := range tests {
+		if got := FormatDuration(tt.d); got != tt.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestParseModTime(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"30d", now.AddDate(0, 0, -30)},
+		{"2w", now.AddDate(0, 0, -14)},
+		{"36h", now.Add(-36 * time.Hour)},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)},
+		{"2024-03-01T10:00:00Z", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := ParseModTime(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseModTime(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "d", "-3d", "yesterday", "2024-13-01"} {
+		if _, err := ParseModTime(in, now); err == nil {
+			t.Errorf("ParseModTime(%q) succeeded", in)
+		}
+	}
+}
+
+func TestSync_filters(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "small.txt", "s")
+	writeFile(t, src, "ok.txt", "just right")
+	writeFile(t, src, "big.img", "far too large for the filter")
+	writeFile(t, src, "old.txt", "old enough")
+	old := time.Now().AddDate(-1, 0, 0)
+	if err := os.Chtimes(filepath.Join(src, "old.txt"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMockDest()
+	dst.objects["big.img"] = &ObjectMeta{Size: 3}  // skipped, but still in the source
+	dst.objects["gone.txt"] = &ObjectMeta{Size: 3} // absent from the source
+	opts := Options{
+		Src: src, Dst: dst, Delete: true,
+		MinSize: 2, MaxSize: 20,
+		ModifiedAfter: time.Now().AddDate(0, 0, -30),
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.putCalls, []string{"ok.txt"}) {
+		t.Errorf("uploaded %v, want [ok.txt]", dst.putCalls)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"gone.txt"}) {
+		t.Errorf("deleted %v, want [gone.txt]", dst.deleteCalls)
+	}
+
+	if err := TwoWay(context.Background(), Options{Src: src, Dst: dst, StateCache: filepath.Join(t.TempDir(), "state"), MaxSize: 20}); err == nil {
+		t.Error("TwoWay accepted a size filter")
+	}
+}
@@ -0,0 +1,323 @@
+package sync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+func init() {
+	Register("gs", openGCSURL)
+	RegisterStorageClasses("gs", "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE")
+}
+
+// GCSDestination uploads files to a Google Cloud Storage bucket using the
+// specified storage class.
+//
+// Storage classes for infrequent access (cheapest first):
+//
+//	ARCHIVE   – accessed less than once a year (365-day minimum storage)
+//	COLDLINE  – accessed less than once a quarter (90-day minimum storage)
+//	NEARLINE  – accessed less than once a month (30-day minimum storage)
+//	STANDARD  – frequently accessed, no minimum
+//
+// An empty storage class leaves objects in the bucket's default class.
+type GCSDestination struct {
+	client       *storage.Client
+	bucket       string
+	prefix       string
+	storageClass string
+}
+
+// NewGCSDestination creates a new GCSDestination.
+func NewGCSDestination(client *storage.Client, bucket, prefix, storageClass string) *GCSDestination {
+	return &GCSDestination{
+		client:       client,
+		bucket:       bucket,
+		prefix:       prefix,
+		storageClass: storageClass,
+	}
+}
+
+// openGCSURL opens gs://bucket/prefix using Application Default
+// Credentials. Query parameters:
+//
+//	storage-class  GCS storage class (default NEARLINE)
+func openGCSURL(ctx context.Context, u *url.URL) (Destination, error) {
+	bucket, prefix, err := bucketAndPrefix(u)
+	if err != nil {
+		return nil, err
+	}
+	storageClass := u.Query().Get("storage-class")
+	if storageClass == "" {
+		storageClass = "NEARLINE"
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create GCS client: %w", err)
+	}
+	return NewGCSDestination(client, bucket, prefix, storageClass), nil
+}
+
+// RequestPrices implements RequestPricer with the list prices of Class A
+// (write) and Class B (read) operations for the destination's storage
+// class in US regions.
+func (d *GCSDestination) RequestPrices() RequestPrices {
+	return gcsStoragePrice(d.storageClass).Requests
+}
+
+// StoragePrices implements StoragePricer with the list prices of the
+// storage classes in US regions, such as us-central1.
+func (d *GCSDestination) StoragePrices() []StoragePrice {
+	prices := []StoragePrice{gcsStoragePrice(d.storageClass)}
+	for _, p := range gcsStoragePrices {
+		if p.Class != prices[0].Class {
+			prices = append(prices, p)
+		}
+	}
+	return prices
+}
+
+// gcsStoragePrice returns the prices of class, or those of STANDARD if
+// it is not one of gcsStoragePrices.
+func gcsStoragePrice(class string) StoragePrice {
+	for _, p := range gcsStoragePrices {
+		if p.Class == class {
+			return p
+		}
+	}
+	return gcsStoragePrices[0]
+}
+
+// gcsStoragePrices are the list prices of GCS's storage classes in US
+// regions.
+var gcsStoragePrices = []StoragePrice{
+	{Class: "STANDARD", GBMonth: 0.02, Requests: RequestPrices{Write: 0.005, Read: 0.0004}},
+	{Class: "NEARLINE", GBMonth: 0.01, RetrievalGB: 0.01, Requests: RequestPrices{Write: 0.01, Read: 0.001}, MinDays: 30},
+	{Class: "COLDLINE", GBMonth: 0.004, RetrievalGB: 0.02, Requests: RequestPrices{Write: 0.02, Read: 0.01}, MinDays: 90},
+	{Class: "ARCHIVE", GBMonth: 0.0012, RetrievalGB: 0.05, Requests: RequestPrices{Write: 0.05, Read: 0.05}, MinDays: 365},
+}
+
+func (d *GCSDestination) Prefix() string {
+	return strings.Trim(d.prefix, "/")
+}
+
+func (d *GCSDestination) WithPrefix(prefix string) Destination {
+	c := *d
+	c.prefix = prefix
+	return &c
+}
+
+func (d *GCSDestination) object(rel string) *storage.ObjectHandle {
+	return d.client.Bucket(d.bucket).Object(joinKey(d.prefix, rel))
+}
+
+func (d *GCSDestination) Put(ctx context.Context, rel string, r io.Reader, meta ObjectMeta) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := d.object(rel).NewWriter(ctx)
+	w.StorageClass = d.storageClass
+	if meta.StorageClass != "" {
+		w.StorageClass = meta.StorageClass
+	}
+	w.Metadata = objectMetadata(meta)
+	w.ContentType = meta.ContentType // if empty, GCS sniffs the content itself
+	w.CacheControl = meta.CacheControl
+	w.ContentDisposition = meta.ContentDisposition
+	w.ContentEncoding = meta.ContentEncoding
+
+	if _, err := io.Copy(w, r); err != nil {
+		// Closing the writer would upload what was read so far.
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (d *GCSDestination) Stat(ctx context.Context, rel string) (*ObjectMeta, error) {
+	attrs, err := d.object(rel).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, nil
+		}
+		if httpStatus(err) == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: %w", fs.ErrPermission, err)
+		}
+		return nil, err
+	}
+	meta := parseMetadata(attrs.Size, attrs.Metadata)
+	meta.ContentType = attrs.ContentType
+	meta.StorageClass = attrs.StorageClass
+	if len(attrs.MD5) == md5.Size { // composite objects have none
+		meta.MD5 = hex.EncodeToString(attrs.MD5)
+	}
+	return meta, nil
+}
+
+func (d *GCSDestination) Get(ctx context.Context, rel string) (io.ReadCloser, error) {
+	r, err := d.object(rel).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+	}
+	return r, err
+}
+
+// GetRange implements RangeGetter.
+func (d *GCSDestination) GetRange(ctx context.Context, rel string, off, n int64) (io.ReadCloser, error) {
+	r, err := d.object(rel).NewRangeReader(ctx, off, n)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+	}
+	return r, err
+}
+
+// Copy copies an object within the bucket, server-side.
+func (d *GCSDestination) Copy(ctx context.Context, src, dst string) error {
+	return d.copyFrom(ctx, d, src, dst, d.storageClass)
+}
+
+// CopyWithMeta implements MetaCopier by copying an object within the
+// bucket, server-side, with the metadata Put would give it.
+func (d *GCSDestination) CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error {
+	c := d.object(dst).CopierFrom(d.object(src))
+	c.StorageClass = d.storageClass
+	if meta.StorageClass != "" {
+		c.StorageClass = meta.StorageClass
+	}
+	c.Metadata = objectMetadata(meta)
+	c.ContentType = meta.ContentType
+	c.CacheControl = meta.CacheControl
+	c.ContentDisposition = meta.ContentDisposition
+	c.ContentEncoding = meta.ContentEncoding
+	_, err := c.Run(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	}
+	return err
+}
+
+// SetStorageClass implements Reclassifier by rewriting the object at rel
+// in class.
+func (d *GCSDestination) SetStorageClass(ctx context.Context, rel, class string) error {
+	return d.copyFrom(ctx, d, rel, rel, class)
+}
+
+// CopyFrom implements CrossCopier for GCS sources, copying server-side as
+// Copy does.
+func (d *GCSDestination) CopyFrom(ctx context.Context, from Destination, src, dst string) error {
+	s, ok := from.(*GCSDestination)
+	if !ok {
+		return fmt.Errorf("copy %s: %w", src, errors.ErrUnsupported)
+	}
+	return d.copyFrom(ctx, s, src, dst, d.storageClass)
+}
+
+// copyFrom copies src in s to dst in d, in storage class class.
+func (d *GCSDestination) copyFrom(ctx context.Context, s *GCSDestination, src, dst, class string) error {
+	from := s.object(src)
+	attrs, err := from.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	}
+	if err != nil {
+		return err
+	}
+	c := d.object(dst).CopierFrom(from)
+	c.StorageClass = class
+	c.ContentType = attrs.ContentType
+	c.CacheControl = attrs.CacheControl
+	c.ContentDisposition = attrs.ContentDisposition
+	c.ContentEncoding = attrs.ContentEncoding
+	c.Metadata = attrs.Metadata
+	_, err = c.Run(ctx)
+	return err
+}
+
+// List implements Destination a page of up to gcsListPage keys at a time,
+// which GCS returns in byte order.
+func (d *GCSDestination) List(ctx context.Context, fn func(keys []string) error) error {
+	pager := iterator.NewPager(d.objects(ctx), gcsListPage, "")
+	for {
+		var page []*storage.ObjectAttrs
+		next, err := pager.NextPage(&page)
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		keys := make([]string, len(page))
+		for i, attrs := range page {
+			keys[i] = splitKey(d.prefix, attrs.Name)
+		}
+		if err := fn(keys); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+	}
+}
+
+// gcsListPage is the most keys a page of GCSDestination.List holds.
+const gcsListPage = 1000
+
+// ListWritten implements WrittenLister using each object's creation time,
+// which the Age condition of GCS lifecycle rules is based on.
+func (d *GCSDestination) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	written := make(map[string]time.Time)
+	err := d.listObjects(ctx, func(attrs *storage.ObjectAttrs) {
+		written[splitKey(d.prefix, attrs.Name)] = attrs.Created
+	})
+	return written, err
+}
+
+// ListObjects implements ObjectLister from the same listing as List.
+func (d *GCSDestination) ListObjects(ctx context.Context) (map[string]ListedObject, error) {
+	objects := make(map[string]ListedObject)
+	err := d.listObjects(ctx, func(attrs *storage.ObjectAttrs) {
+		objects[splitKey(d.prefix, attrs.Name)] = ListedObject{Size: attrs.Size, Written: attrs.Created}
+	})
+	return objects, err
+}
+
+// listObjects calls fn for every object under the destination's prefix.
+func (d *GCSDestination) listObjects(ctx context.Context, fn func(*storage.ObjectAttrs)) error {
+	it := d.objects(ctx)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		fn(attrs)
+	}
+}
+
+// objects returns an iterator over the objects under the destination's
+// prefix.
+func (d *GCSDestination) objects(ctx context.Context) *storage.ObjectIterator {
+	return d.client.Bucket(d.bucket).Objects(ctx, &storage.Query{
+		Prefix: listPrefix(d.prefix),
+	})
+}
+
+func (d *GCSDestination) Delete(ctx context.Context, rel string) error {
+	err := d.object(rel).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package sync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	stdsync "sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsObject is an object of the JSON API, as fakeGCS stores it.
+type gcsObject struct {
+	Name         string            `json:"name"`
+	Bucket       string            `json:"bucket"`
+	Size         string            `json:"size"`
+	ContentType  string            `json:"contentType,omitempty"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	MD5Hash      []byte            `json:"md5Hash,omitempty"`
+	TimeCreated  time.Time         `json:"timeCreated"`
+}
+
+// fakeGCS serves the parts of the GCS JSON API that GCSDestination uses:
+// single-request uploads, and getting, listing and deleting objects. With
+// denyGet, it refuses to get objects, as a bucket policy without
+// storage.objects.get does.
+type fakeGCS struct {
+	mu      stdsync.Mutex
+	objects map[string]*gcsObject
+	data    map[string][]byte
+	denyGet bool
+}
+
+func newFakeGCS(t *testing.T) (*fakeGCS, *storage.Client) {
+	f := &fakeGCS{objects: make(map[string]*gcsObject), data: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return f, client
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	const objects = "/storage/v1/b/bucket/o"
+	switch name, named := strings.CutPrefix(r.URL.Path, objects+"/"); {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/upload"+objects):
+		f.upload(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == objects:
+		f.list(w, r)
+	case named && r.Method == http.MethodGet && f.denyGet:
+		http.Error(w, `{"error": {"code": 403, "message": "Access denied"}}`, http.StatusForbidden)
+	case named && f.objects[name] == nil:
+		http.Error(w, `{"error": {"code": 404, "message": "No such object"}}`, http.StatusNotFound)
+	case named && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(f.objects[name])
+	case named && r.Method == http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+// upload stores an object sent in a multipart upload: its resource, then
+// its content.
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || r.URL.Query().Get("uploadType") != "multipart" {
+		http.Error(w, "want a multipart upload", http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var obj gcsObject
+	part, err := mr.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&obj)
+	}
+	var data []byte
+	if err == nil {
+		if part, err = mr.NextPart(); err == nil {
+			data, err = io.ReadAll(part)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sum := md5.Sum(data)
+	obj.Bucket, obj.Size, obj.MD5Hash, obj.TimeCreated = "bucket", strconv.Itoa(len(data)), sum[:], time.Now()
+	f.objects[obj.Name], f.data[obj.Name] = &obj, data
+	json.NewEncoder(w).Encode(&obj)
+}
+
+// list lists the objects under the prefix asked for, in name order, two
+// to a page.
+func (f *fakeGCS) list(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	end := min(start+2, len(names))
+	page := struct {
+		Items         []*gcsObject `json:"items"`
+		NextPageToken string       `json:"nextPageToken,omitempty"`
+	}{}
+	for _, name := range names[start:end] {
+		page.Items = append(page.Items, f.objects[name])
+	}
+	if end < len(names) {
+		page.NextPageToken = strconv.Itoa(end)
+	}
+	json.NewEncoder(w).Encode(&page)
+}
+
+func TestGCSDestination(t *testing.T) {
+	fake, client := newFakeGCS(t)
+	d := NewGCSDestination(client, "bucket", "backups", "NEARLINE")
+	ctx := context.Background()
+
+	mtime := time.Unix(1700000000, 0)
+	meta := ObjectMeta{Size: 5, ModTime: mtime, ContentType: "text/plain", SHA256: "abc"}
+	if err := d.Put(ctx, "docs/a.txt", strings.NewReader("hello"), meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fake.data["backups/docs/a.txt"]); got != "hello" {
+		t.Errorf("stored %q, want hello", got)
+	}
+	got, err := d.Stat(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Size != 5 || !got.ModTime.Equal(mtime) || got.SHA256 != "abc" ||
+		got.ContentType != "text/plain" || got.StorageClass != "NEARLINE" || got.MD5 != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("Stat = %+v", got)
+	}
+	if got, err := d.Stat(ctx, "missing.txt"); got != nil || err != nil {
+		t.Errorf("Stat of a missing object = %v, %v; want nil, nil", got, err)
+	}
+
+	for _, key := range []string{"b.txt", "c.txt", "docs/d.txt"} {
+		if err := d.Put(ctx, key, strings.NewReader(key), ObjectMeta{Size: int64(len(key))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.objects["other/e.txt"] = &gcsObject{Name: "other/e.txt", Size: "0"} // outside the prefix
+	keys, err := listKeys(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b.txt", "c.txt", "docs/a.txt", "docs/d.txt"}; !slices.Equal(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	if err := d.Delete(ctx, "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, "b.txt"); err != nil {
+		t.Errorf("Delete of a missing object = %v, want nil", err)
+	}
+	if _, ok := fake.objects["backups/b.txt"]; ok {
+		t.Error("b.txt was not deleted")
+	}
+}
+
+func TestGCSDestination_Stat_denied(t *testing.T) {
+	fake, client := newFakeGCS(t)
+	d := NewGCSDestination(client, "bucket", "", "")
+	src := t.TempDir()
+	writeFile(t, src, "same.txt", "a")
+	writeFile(t, src, "grown.txt", "b")
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: d}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.denyGet = true
+	if _, err := d.Stat(ctx, "same.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("Stat = %v, want fs.ErrPermission", err)
+	}
+	// Lookups fall back to the listing, which shows grown.txt has grown.
+	writeFile(t, src, "grown.txt", "bb")
+	res, err := Sync(ctx, Options{Src: src, Dst: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Uploaded != 1 || string(fake.data["grown.txt"]) != "bb" {
+		t.Errorf("uploaded %d, grown.txt holds %q; want only grown.txt uploaded", res.Uploaded, fake.data["grown.txt"])
+	}
+}
+
+// failingReader returns its content, and then err instead of io.EOF.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		err = f.err
+	}
+	return n, err
+}
+
+func TestGCSDestination_Put_failedBody(t *testing.T) {
+	fake, client := newFakeGCS(t)
+	d := NewGCSDestination(client, "bucket", "", "")
+	errRead := errors.New("read failed")
+	body := &failingReader{r: strings.NewReader("abcdefghij"), err: errRead}
+	if err := d.Put(context.Background(), "a.txt", body, ObjectMeta{Size: 20}); !errors.Is(err, errRead) {
+		t.Errorf("Put = %v, want the read error", err)
+	}
+	if data, ok := fake.data["a.txt"]; ok {
+		t.Errorf("stored %q, want nothing stored for a body that failed", data)
+	}
+}
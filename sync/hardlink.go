@@ -0,0 +1,201 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// LinkIndexKey is the key of the LinkIndex.
+const LinkIndexKey = metaPrefix + "links.json"
+
+// LinkIndex records the files stored as hard links to other files instead
+// of as objects of their own. See Options.HardLinks.
+type LinkIndex struct {
+	// Links maps the key of each such file to the key of the file whose
+	// object holds its content.
+	Links map[string]string `json:"links"`
+}
+
+// inode identifies a file on the machine running the sync.
+type inode struct {
+	dev, ino uint64
+}
+
+// ReadLinkIndex reads the link index of dst. If there is none, it returns
+// an empty index.
+func ReadLinkIndex(ctx context.Context, dst Destination) (*LinkIndex, error) {
+	idx := &LinkIndex{Links: make(map[string]string)}
+	data, err := readObject(ctx, dst, LinkIndexKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read link index: %w", err)
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("read link index: %w", err)
+	}
+	if idx.Links == nil {
+		idx.Links = make(map[string]string)
+	}
+	return idx, nil
+}
+
+func writeLinkIndex(ctx context.Context, dst Destination, idx *LinkIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	meta := ObjectMeta{Size: int64(len(data)), ModTime: time.Now(), ContentType: "application/json"}
+	if err := dst.Put(ctx, LinkIndexKey, bytes.NewReader(data), meta); err != nil {
+		return fmt.Errorf("write link index: %w", err)
+	}
+	return nil
+}
+
+// planLink adds file, whose info is given, to plan.links if it is a hard
+// link to a file planned before it, and reports whether it did.
+func planLink(opts Options, plan *Plan, file File, info fs.FileInfo) bool {
+	if !opts.HardLinks {
+		return false
+	}
+	id, ok := linkID(info)
+	if !ok {
+		return false
+	}
+	if plan.inodes == nil {
+		plan.inodes = make(map[inode]string)
+		plan.links = make(map[string]string)
+	}
+	if target, ok := plan.inodes[id]; ok {
+		plan.links[file.Key] = target
+		return true
+	}
+	plan.inodes[id] = file.Key
+	return false
+}
+
+// planLinkObjects adds the objects stored at the keys of files that have
+// become hard links since the last run to plan.Deletes: the link index
+// stands for them now.
+func planLinkObjects(ctx context.Context, opts Options, plan *Plan) error {
+	if !opts.HardLinks {
+		return nil
+	}
+	idx, err := ReadLinkIndex(ctx, opts.Dst)
+	if err != nil {
+		return err
+	}
+	plan.linkIndex = idx
+	for _, key := range slices.Sorted(maps.Keys(plan.links)) {
+		if _, ok := idx.Links[key]; ok {
+			continue
+		}
+		meta, err := opts.Dst.Stat(ctx, key)
+		if err != nil {
+			return &FileError{Op: "stat", Key: key, Err: err}
+		}
+		if meta != nil && !slices.Contains(plan.Deletes, key) {
+			plan.Deletes = append(plan.Deletes, key)
+		}
+	}
+	return nil
+}
+
+// applyLinks reports the hard links that are new or changed since the last
+// run, and replaces the link index with plan.links if they differ.
+func applyLinks(ctx context.Context, opts Options, plan *Plan) error {
+	if !opts.HardLinks || plan.linkIndex == nil || maps.Equal(plan.linkIndex.Links, plan.links) {
+		return nil
+	}
+	for _, key := range slices.Sorted(maps.Keys(plan.links)) {
+		if target := plan.links[key]; plan.linkIndex.Links[key] != target {
+			opts.report(Event{Action: "link", Key: key, From: target, Reason: "hard link"})
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
+	links := plan.links
+	if links == nil {
+		links = make(map[string]string)
+	}
+	return writeLinkIndex(ctx, opts.Dst, &LinkIndex{Links: links})
+}
+
+// restoreLinks recreates in to the hard links recorded in the link index
+// of opts.From, for the files opts selects that have no object of their
+// own. The file a link is to is linked to if it is there, and otherwise
+// downloaded, as it is if the link cannot be made.
+func restoreLinks(ctx context.Context, opts RestoreOptions, to *LocalDestination) error {
+	if opts.Snapshot != "" {
+		return nil // the index describes the latest run
+	}
+	idx, err := ReadLinkIndex(ctx, opts.From)
+	if err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(idx.Links)) {
+		target := idx.Links[key]
+		name, ok := opts.Keys.Path(key)
+		if !ok || !opts.selected(name) {
+			continue
+		}
+		if meta, err := opts.From.Stat(ctx, key); err != nil {
+			return &FileError{Op: "restore", Key: key, Err: err}
+		} else if meta != nil {
+			continue // uploaded as a file since, and restored as one
+		}
+		targetName, ok := opts.Keys.Path(target)
+		if !ok {
+			return fmt.Errorf("restore %s: link to %s: not a key of the layout", key, target)
+		}
+		if keep, err := opts.keepLocal(ctx, to, target, localName(name, opts.Warnings), nil); err != nil {
+			return &FileError{Op: "restore", Key: key, Err: err}
+		} else if keep {
+			continue
+		}
+		opts.logf("link %s -> %s", targetName, name)
+		if opts.DryRun {
+			continue
+		}
+		if err := link(to, localName(targetName, opts.Warnings), localName(name, opts.Warnings)); err != nil {
+			if err := restoreFile(ctx, opts.From, to, target, localName(name, opts.Warnings), opts.EncryptionContext, opts.Untransform, opts.transfer); err != nil {
+				return &FileError{Op: "restore", Key: key, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// link makes the file at name in to a hard link to the file at target,
+// replacing whatever is there.
+func link(to *LocalDestination, target, name string) error {
+	old, err := to.path(target)
+	if err != nil {
+		return err
+	}
+	path, err := to.path(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(old); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Link(old, path)
+}
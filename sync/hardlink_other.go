@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package sync
+
+import "io/fs"
+
+// Hard links are not detected on this platform, so each is uploaded as a
+// file of its own.
+
+func linkID(fs.FileInfo) (inode, bool) { return inode{}, false }
+
+// Nor are files identified by device and inode: the hash cache knows them
+// by their paths.
+
+func fileID(fs.FileInfo) (inode, bool) { return inode{}, false }
@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSync_hardLinks(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "shared")
+	writeFile(t, src, "c.txt", "c")
+	if err := os.Link(filepath.Join(src, "a.txt"), filepath.Join(src, "b.txt")); err != nil {
+		t.Skipf("no hard links: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(src, "b.txt")); err != nil {
+		t.Fatal(err)
+	} else if _, ok := linkID(info); !ok {
+		t.Skip("hard links are not detected on this platform")
+	}
+
+	// Uploaded by a run that did not look for hard links.
+	dst := newMockDest()
+	putRemote(dst, "b.txt", "shared", time.Now())
+
+	opts := Options{Src: src, Dst: dst, HardLinks: true}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dst.putCalls)
+	if want := []string{LinkIndexKey, "a.txt", "c.txt"}; !slices.Equal(dst.putCalls, want) {
+		t.Errorf("put %v, want %v", dst.putCalls, want)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"b.txt"}) {
+		t.Errorf("deleted %v, want the object of b.txt, now a link", dst.deleteCalls)
+	}
+	idx, err := ReadLinkIndex(context.Background(), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Links["b.txt"] != "a.txt" || len(idx.Links) != 1 {
+		t.Errorf("links = %v, want b.txt -> a.txt", idx.Links)
+	}
+
+	dst.putCalls, dst.deleteCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls)+len(dst.deleteCalls) != 0 {
+		t.Errorf("second run put %v and deleted %v, want nothing", dst.putCalls, dst.deleteCalls)
+	}
+
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out}); err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.Stat(filepath.Join(out, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.Stat(filepath.Join(out, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("b.txt restored, but not as a hard link to a.txt")
+	}
+
+	// A link restored without the file it is to gets its content.
+	out = t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, Paths: []string{"b.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "b.txt")); err != nil || string(data) != "shared" {
+		t.Errorf("b.txt = %q, %v; want shared", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("a.txt restored too: %v", err)
+	}
+}
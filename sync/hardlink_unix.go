@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package sync
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileID returns the identity of the file info describes.
+func fileID(info fs.FileInfo) (inode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inode{}, false
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// linkID returns the identity of the file info describes if it has other
+// hard links.
+func linkID(info fs.FileInfo) (inode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return inode{}, false
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,116 @@
+package sync
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	stdsync "sync"
+	"time"
+)
+
+// hashCache remembers the SHA-256 of source files by their device, inode,
+// size and modification time, so that files unchanged since a run hashed
+// them are not read again to hash them. See Options.HashCache.
+type hashCache struct {
+	path     string
+	sample   float64   // Options.HashCacheVerify
+	warnings io.Writer // Options.Warnings
+	started  time.Time // when the cache was loaded
+
+	mu    stdsync.Mutex
+	old   map[string]hashCacheEntry // as loaded, by file identity
+	files map[string]hashCacheRef   // the files looked up since, by identity
+}
+
+type hashCacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // in nanoseconds since the epoch
+	SHA256  string `json:"sha256"`
+}
+
+// hashCacheRef is a file looked up in the cache, whose hash is recorded by
+// the next save if the run has learned it.
+type hashCacheRef struct {
+	path   string
+	entry  hashCacheEntry // without SHA256 unless the cache had it
+	hash   *fileHash
+	verify bool // picked for Options.HashCacheVerify: its hash is checked against entry
+}
+
+type hashCacheFile struct {
+	Files map[string]hashCacheEntry `json:"files"`
+}
+
+// loadHashCache reads the cache at path. A missing or unreadable cache is
+// treated as empty.
+func loadHashCache(path string, sample float64, warnings io.Writer) *hashCache {
+	var f hashCacheFile
+	readCacheFile(path, "hash cache", &f, warnings)
+	c := &hashCache{path: path, sample: sample, warnings: warnings, started: time.Now(), old: f.Files, files: make(map[string]hashCacheRef)}
+	if c.old == nil {
+		c.old = make(map[string]hashCacheEntry)
+	}
+	return c
+}
+
+// lookup gives file, described by info, the hash the cache records for it
+// if it has not changed since, unless it is picked to be hashed again to
+// verify the cache. A nil cache does nothing.
+func (c *hashCache) lookup(file File, info fs.FileInfo) {
+	if c == nil || file.hash == nil || !info.Mode().IsRegular() {
+		return
+	}
+	id := file.Path
+	if ino, ok := fileID(info); ok {
+		id = fmt.Sprintf("%d:%d", ino.dev, ino.ino)
+	}
+	ref := hashCacheRef{
+		path:  file.Path,
+		entry: hashCacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano()},
+		hash:  file.hash,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.old[id]; ok && old.Size == ref.entry.Size && old.ModTime == ref.entry.ModTime {
+		ref.entry.SHA256 = old.SHA256
+		ref.verify = c.sample > 0 && rand.Float64() < c.sample
+		if sum, err := hex.DecodeString(old.SHA256); err == nil && !ref.verify {
+			file.setSHA256(sum)
+		}
+	}
+	c.files[id] = ref
+}
+
+// save writes the hashes of the files looked up that are known, dropping
+// the entries of files no longer found. Files modified within two seconds
+// of the cache being loaded are left out: a file hashed in the same tick
+// of its file system's clock as it was written could be written again
+// without its modification time changing. A file picked for verification
+// whose content no longer matches its entry is warned about.
+func (c *hashCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	racy := c.started.Add(-2 * time.Second).UnixNano()
+	f := hashCacheFile{Files: make(map[string]hashCacheEntry, len(c.files))}
+	for id, ref := range c.files {
+		e := ref.entry
+		ref.hash.mu.Lock()
+		if sum := ref.hash.sum; sum != nil {
+			fresh := hex.EncodeToString(sum)
+			if ref.verify && e.SHA256 != "" && fresh != e.SHA256 {
+				warnf(c.warnings, "%s: content changed without its size or modification time changing", ref.path)
+			}
+			e.SHA256 = fresh
+		}
+		ref.hash.mu.Unlock()
+		if e.SHA256 != "" && e.ModTime < racy {
+			f.Files[id] = e
+		}
+	}
+	return writeCacheFile(c.path, f)
+}
@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSync_hashCache(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "aaaa")
+	path := filepath.Join(src, "a.txt")
+	// Old enough not to be left out of the cache as racy.
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMockDest()
+	opts := Options{
+		Src:       src,
+		Dst:       dst,
+		Checksums: true,
+		Compare:   ChecksumComparer{},
+		HashCache: filepath.Join(t.TempDir(), "hashes.json"),
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	var f hashCacheFile
+	readCacheFile(opts.HashCache, "hash cache", &f, nil)
+	if len(f.Files) != 1 {
+		t.Fatalf("hash cache holds %v, want a.txt", f.Files)
+	}
+
+	// Rewritten in place without its size or modification time changing,
+	// the file is trusted to be unchanged, as the cache is not read again.
+	if err := os.WriteFile(path, []byte("bbbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	dst.putCalls = nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("with the hash cache, put %v; want nothing", dst.putCalls)
+	}
+
+	// Verifying every file hashes it again.
+	opts.HashCacheVerify = 1
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 1 {
+		t.Errorf("verifying the hash cache, put %v; want a.txt", dst.putCalls)
+	}
+
+	// A file modified just before the run is not cached.
+	writeFile(t, src, "a.txt", "cccc")
+	opts.HashCacheVerify = 0
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	f = hashCacheFile{}
+	readCacheFile(opts.HashCache, "hash cache", &f, nil)
+	if len(f.Files) != 0 {
+		t.Errorf("hash cache holds %v, want nothing for a file just modified", f.Files)
+	}
+}
@@ -0,0 +1,265 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// HistoryRun is a run recorded in a history file. See Options.History.
+type HistoryRun struct {
+	Time          time.Time `json:"time"` // when the run finished
+	Src           string    `json:"src"`
+	Status        string    `json:"status"` // as Summary.Status returns it
+	Files         int       `json:"files"`
+	SourceBytes   int64     `json:"source_bytes"` // size of the files considered
+	Uploaded      int       `json:"uploaded"`
+	UploadedBytes int64     `json:"uploaded_bytes"`
+	Deleted       int       `json:"deleted"`
+	Failed        int       `json:"failed,omitempty"` // uploads that failed; see Options.KeepGoing
+	Duration      float64   `json:"duration_seconds"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// historyRun describes the run of plan, which may be nil, that s
+// summarizes.
+func historyRun(plan *Plan, s Summary) HistoryRun {
+	r := HistoryRun{
+		Time:          time.Now(),
+		Src:           s.Src,
+		Status:        s.Status(),
+		Files:         s.Files,
+		Uploaded:      s.Uploaded,
+		UploadedBytes: s.UploadedBytes,
+		Deleted:       s.Deleted,
+		Duration:      s.Duration.Seconds(),
+	}
+	if plan != nil {
+		for _, f := range plan.Files {
+			r.SourceBytes += f.Size
+		}
+		r.Failed = len(plan.failed)
+	}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+	}
+	return r
+}
+
+// recordHistory appends the run of plan that s summarizes to
+// opts.History, if it is set and the run was not a dry run. A history that
+// cannot be written is warned about, but does not fail the run.
+func recordHistory(opts Options, plan *Plan, s Summary) {
+	if opts.History == "" || s.DryRun {
+		return
+	}
+	if err := appendHistory(opts.History, historyRun(plan, s)); err != nil {
+		opts.warnf("history: %v", err)
+	}
+}
+
+// appendHistory appends r to the history file at path as a JSON line,
+// after a line break if the last line was cut short.
+func appendHistory(path string, r HistoryRun) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if n := info.Size(); n > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, n-1); err != nil && err != io.EOF {
+			return err
+		}
+		if last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// ReadHistory reads the runs recorded in the history file at path, oldest
+// first. Lines cut short by a crash are skipped. If there is no history,
+// the error wraps os.ErrNotExist.
+func ReadHistory(path string) ([]HistoryRun, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var runs []HistoryRun
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		var r HistoryRun
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			if !json.Valid(sc.Bytes()) {
+				continue // cut short
+			}
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, sc.Err()
+}
+
+// HistoryTrends summarizes the runs of a history, as "foldersync stats"
+// prints them.
+type HistoryTrends struct {
+	Runs int `json:"runs"`
+	// Failures counts the runs that failed, leaving out those canceled
+	// and those stopped at the request limit.
+	Failures int `json:"failures"`
+
+	// AverageDuration is the average time the runs that succeeded took,
+	// in seconds.
+	AverageDuration float64 `json:"average_duration_seconds"`
+	// LastSuccess and LastFailure are the latest run that succeeded and
+	// the latest that failed, or nil if there is none.
+	LastSuccess *HistoryRun `json:"last_success,omitempty"`
+	LastFailure *HistoryRun `json:"last_failure,omitempty"`
+
+	Months []HistoryMonth `json:"months"` // the months with runs, oldest first
+}
+
+// HistoryMonth summarizes the runs of a calendar month.
+type HistoryMonth struct {
+	Month         string `json:"month"` // as "2006-01", in local time
+	Runs          int    `json:"runs"`
+	Succeeded     int    `json:"succeeded"`
+	Failures      int    `json:"failures"`
+	Uploaded      int    `json:"uploaded"`
+	UploadedBytes int64  `json:"uploaded_bytes"`
+
+	// SourceBytes is the size of the source at the last run of the month
+	// that succeeded, or 0 if none did. Growth is how much it grew, or
+	// shrank, since the last month with such a run; it is 0 for the first.
+	SourceBytes int64 `json:"source_bytes"`
+	Growth      int64 `json:"growth_bytes"`
+
+	AverageDuration float64 `json:"average_duration_seconds"` // of the runs that succeeded
+}
+
+// Trends summarizes runs, which are oldest first, by month.
+func Trends(runs []HistoryRun) HistoryTrends {
+	var t HistoryTrends
+	var total, monthTotal float64
+	var succeeded int
+	var month *HistoryMonth
+	var lastSize int64 = -1
+	endMonth := func() {
+		if month == nil {
+			return
+		}
+		if month.Succeeded > 0 {
+			month.AverageDuration = monthTotal / float64(month.Succeeded)
+			if lastSize >= 0 {
+				month.Growth = month.SourceBytes - lastSize
+			}
+			lastSize = month.SourceBytes
+		}
+		t.Months = append(t.Months, *month)
+	}
+	for i := range runs {
+		r := &runs[i]
+		if name := r.Time.Local().Format("2006-01"); month == nil || month.Month != name {
+			endMonth()
+			month = &HistoryMonth{Month: name}
+			monthTotal = 0
+		}
+		t.Runs++
+		month.Runs++
+		month.Uploaded += r.Uploaded
+		month.UploadedBytes += r.UploadedBytes
+		switch r.Status {
+		case "ok":
+			total += r.Duration
+			succeeded++
+			monthTotal += r.Duration
+			month.Succeeded++
+			month.SourceBytes = r.SourceBytes
+			t.LastSuccess = r
+		case "failed":
+			t.Failures++
+			month.Failures++
+			t.LastFailure = r
+		}
+	}
+	endMonth()
+	if succeeded > 0 {
+		t.AverageDuration = total / float64(succeeded)
+	}
+	return t
+}
+
+// WriteText writes t to w as a table of the months, after a line for the
+// runs overall and one each for the last success and failure.
+func (t HistoryTrends) WriteText(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d runs, %d failed", t.Runs, t.Failures)
+	if t.LastSuccess != nil {
+		fmt.Fprintf(&b, "; successful runs took %s on average", seconds(t.AverageDuration))
+	}
+	b.WriteByte('\n')
+	if r := t.LastSuccess; r != nil {
+		fmt.Fprintf(&b, "last success: %s, %d files (%s), uploaded %d (%s)\n",
+			r.Time.Local().Format(time.DateTime), r.Files, formatBytes(r.SourceBytes), r.Uploaded, formatBytes(r.UploadedBytes))
+	}
+	if r := t.LastFailure; r != nil {
+		fmt.Fprintf(&b, "last failure: %s: %s\n", r.Time.Local().Format(time.DateTime), r.Error)
+	}
+	if len(t.Months) > 0 {
+		tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "month\truns\tfailed\tuploaded\tsource\tgrowth\tavg run\t")
+		for _, m := range t.Months {
+			source, growth, avg := "-", "-", "-"
+			if m.Succeeded > 0 {
+				source = formatBytes(m.SourceBytes)
+				avg = seconds(m.AverageDuration).String()
+			}
+			if m.Growth != 0 {
+				growth = formatBytes(abs(m.Growth))
+				if m.Growth > 0 {
+					growth = "+" + growth
+				} else {
+					growth = "-" + growth
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n",
+				m.Month, m.Runs, m.Failures, formatBytes(m.UploadedBytes), source, growth, avg)
+		}
+		tw.Flush()
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// seconds returns s seconds as a duration, to the second.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSync_history(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "aaa")
+	writeFile(t, src, "b.txt", "bb")
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	opts := Options{Src: src, Dst: newMockDest(), History: path}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	// Cut short by a crash, then run again.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2026-`)
+	f.Close()
+	opts.DryRun = true // not recorded
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.DryRun = false
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := ReadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("%d runs recorded, want 2: %+v", len(runs), runs)
+	}
+	if r := runs[0]; r.Status != "ok" || r.Files != 2 || r.SourceBytes != 5 || r.Uploaded != 2 || r.UploadedBytes != 5 {
+		t.Errorf("first run = %+v, want 2 files of 5 bytes uploaded", r)
+	}
+	if r := runs[1]; r.Status != "ok" || r.Files != 2 || r.Uploaded != 0 {
+		t.Errorf("second run = %+v, want 2 files and nothing uploaded", r)
+	}
+}
+
+func TestTrends(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Add(2 * time.Hour)
+	}
+	runs := []HistoryRun{
+		{Time: day("2026-08-01"), Status: "ok", SourceBytes: 1000, UploadedBytes: 1000, Duration: 30},
+		{Time: day("2026-08-02"), Status: "ok", SourceBytes: 1200, UploadedBytes: 200, Duration: 10},
+		{Time: day("2026-09-01"), Status: "failed", Error: "boom", Duration: 1},
+		{Time: day("2026-09-02"), Status: "canceled", Duration: 5},
+		{Time: day("2026-10-01"), Status: "ok", SourceBytes: 1100, Duration: 20},
+	}
+	tr := Trends(runs)
+	if tr.Runs != 5 || tr.Failures != 1 || tr.AverageDuration != 20 {
+		t.Errorf("runs %d, failures %d, average %v; want 5, 1, 20", tr.Runs, tr.Failures, tr.AverageDuration)
+	}
+	if tr.LastSuccess != &runs[4] || tr.LastFailure != &runs[2] {
+		t.Errorf("last success %+v, last failure %+v", tr.LastSuccess, tr.LastFailure)
+	}
+	want := []HistoryMonth{
+		{Month: "2026-08", Runs: 2, Succeeded: 2, UploadedBytes: 1200, SourceBytes: 1200, AverageDuration: 20},
+		{Month: "2026-09", Runs: 2, Failures: 1},
+		{Month: "2026-10", Runs: 1, Succeeded: 1, SourceBytes: 1100, Growth: -100, AverageDuration: 20},
+	}
+	if len(tr.Months) != len(want) {
+		t.Fatalf("months = %+v, want %+v", tr.Months, want)
+	}
+	for i := range want {
+		if tr.Months[i] != want[i] {
+			t.Errorf("month %d = %+v, want %+v", i, tr.Months[i], want[i])
+		}
+	}
+
+	var b strings.Builder
+	if err := tr.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"5 runs, 1 failed; successful runs took 20s on average", ": boom\n", "2026-10", "-100 B"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("text lacks %q:\n%s", s, b.String())
+		}
+	}
+}
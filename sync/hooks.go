@@ -0,0 +1,147 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Result describes what a Sync run did. Sync returns it even if the run
+// failed, with as much as it got through.
+type Result struct {
+	Summary
+	// Changes lists each change the run made, or with DryRun would have
+	// made, in order: the events also passed to Options.OnEvent.
+	Changes []Event
+	// Failed lists the files that failed to upload in a run that kept
+	// going past them. See Options.KeepGoing.
+	Failed []*FileError
+	// Changed lists the files that kept changing while they were uploaded,
+	// whose objects may mix their old content and new. The next run
+	// uploads them again. See ErrFileChanged.
+	Changed []string
+	// Unreadable lists the source files and directories left out of the
+	// run because they could not be read. See Options.OnWalkError.
+	Unreadable []UnreadablePath
+	// Destinations describes what the run did at each destination, if
+	// Options.Dst is a FanOut.
+	Destinations []DestinationResult
+}
+
+// FileError is the failure of an operation on one file or object, such
+// as its upload, download or deletion. Runs that fail at a file return
+// one, and runs that keep going past files list them in their Result.
+type FileError struct {
+	Op  string // "upload", "download", "restore", "stat", "delete", ...
+	Key string // of the file's object
+	Err error
+}
+
+func (e *FileError) Error() string { return e.Op + " " + e.Key + ": " + e.Err.Error() }
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// Summary describes a finished Sync run, for Options.PostSync.
+type Summary struct {
+	Src    string
+	DryRun bool
+
+	Progress
+
+	Duration time.Duration
+	// Err is the error Sync returns before PostSync is called: nil if the
+	// run succeeded.
+	Err error
+}
+
+// Status describes how the run ended: "ok", "incomplete" if it stopped at
+// the request limit or its budget, "canceled" or "failed".
+func (s Summary) Status() string {
+	switch {
+	case s.Err == nil:
+		return "ok"
+	case errors.Is(s.Err, ErrRequestLimit), errors.Is(s.Err, ErrBudget):
+		return "incomplete"
+	case errors.Is(s.Err, ErrCanceled):
+		return "canceled"
+	}
+	return "failed"
+}
+
+// Progress counts what a run planned and what it did of that.
+type Progress struct {
+	Files    int // source files considered
+	Skipped  int // files left alone: up to date, or filtered out
+	Uploads  int // files found missing or stale at the destination
+	Uploaded int // of those, files uploaded
+	Deletes  int // destination objects found absent from the source
+	Deleted  int // of those, objects deleted
+
+	Unreadable int // source files and directories left out unread
+
+	UploadedBytes int64 // size of the files uploaded
+}
+
+// progress counts the progress of plan, which may be nil.
+func progress(plan *Plan) Progress {
+	if plan == nil {
+		return Progress{}
+	}
+	return Progress{
+		Files:         len(plan.Files),
+		Skipped:       len(plan.Files) - len(plan.Uploads) - len(plan.Bundled) + len(plan.Filtered),
+		Uploads:       len(plan.Uploads) + len(plan.Bundled),
+		Uploaded:      plan.uploaded,
+		Deletes:       len(plan.Deletes),
+		Deleted:       plan.deleted,
+		Unreadable:    len(plan.unreadable),
+		UploadedBytes: plan.uploadedBytes,
+	}
+}
+
+// runHooks calls run between opts.PreSync and opts.PostSync, describing
+// the run in res. A failing PreSync stops the run before it starts, and
+// PostSync is not called. An error from PostSync is returned if run
+// succeeded. The run is recorded in opts.Metrics and opts.History.
+func runHooks(ctx context.Context, opts Options, res *Result, run func() (*Plan, error)) error {
+	start := time.Now()
+	if opts.PreSync != nil {
+		if err := opts.PreSync(ctx); err != nil {
+			err = fmt.Errorf("pre-sync hook: %w", err)
+			res.Summary = summarize(opts, nil, start, err)
+			opts.Notify.send(ctx, res.Summary, opts.Warnings)
+			opts.emit(RunComplete{Summary: res.Summary})
+			return err
+		}
+	}
+	plan, err := run()
+	if plan != nil {
+		for _, f := range plan.failed {
+			f.Err = classify(f.Err)
+		}
+		res.Failed, res.Changed, res.Unreadable = plan.failed, plan.changed, plan.unreadable
+	}
+	err = classify(err)
+	s := summarize(opts, plan, start, err)
+	res.Summary = s
+	opts.Metrics.record(s)
+	recordHistory(opts, plan, s)
+	if opts.PostSync != nil {
+		// Run it even if the run was canceled, to undo what PreSync did.
+		if herr := opts.PostSync(context.WithoutCancel(ctx), s); herr != nil && err == nil {
+			err = fmt.Errorf("post-sync hook: %w", herr)
+			s.Err = err
+		}
+	}
+	opts.Notify.send(ctx, s, opts.Warnings)
+	opts.emit(RunComplete{Summary: s})
+	return err
+}
+
+// summarize describes the run of plan, which may be nil, started at start
+// and finished with err.
+func summarize(opts Options, plan *Plan, start time.Time, err error) Summary {
+	return Summary{Src: strings.Join(sourceDirs(opts), ","), DryRun: opts.DryRun, Progress: progress(plan), Duration: time.Since(start), Err: err}
+}
@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSync_hooks(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	dst := newMockDest()
+	dst.objects["gone.txt"] = &ObjectMeta{}
+
+	var calls []string
+	var got Summary
+	opts := Options{
+		Src: src, Dst: dst, Delete: true,
+		PreSync: func(context.Context) error {
+			calls = append(calls, "pre")
+			return nil
+		},
+		PostSync: func(_ context.Context, s Summary) error {
+			calls = append(calls, "post")
+			got = s
+			return nil
+		},
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "pre" || calls[1] != "post" {
+		t.Errorf("calls = %v", calls)
+	}
+	if got.Files != 2 || got.Uploads != 2 || got.Uploaded != 2 || got.Deletes != 1 || got.Deleted != 1 || got.Err != nil {
+		t.Errorf("summary = %+v", got)
+	}
+}
+
+func TestSync_preSyncFails(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	stop := errors.New("database busy")
+	posted := false
+	opts := Options{
+		Src: src, Dst: dst,
+		PreSync:  func(context.Context) error { return stop },
+		PostSync: func(context.Context, Summary) error { posted = true; return nil },
+	}
+	if _, err := Sync(context.Background(), opts); !errors.Is(err, stop) {
+		t.Fatalf("Sync = %v, want the hook's error", err)
+	}
+	if len(dst.putCalls) != 0 || posted {
+		t.Errorf("run went ahead: uploaded %v, post-sync called %v", dst.putCalls, posted)
+	}
+}
+
+func TestSync_postSyncSeesFailure(t *testing.T) {
+	var got Summary
+	notify := errors.New("notification failed")
+	opts := Options{
+		Src: "/nonexistent", Dst: newMockDest(),
+		PostSync: func(_ context.Context, s Summary) error { got = s; return notify },
+	}
+	_, err := Sync(context.Background(), opts)
+	if err == nil || errors.Is(err, notify) {
+		t.Fatalf("Sync = %v, want the run's own error", err)
+	}
+	if got.Err != err {
+		t.Errorf("summary error = %v, want %v", got.Err, err)
+	}
+}
+
+func TestSync_result(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "bb")
+	dst := newMockDest()
+	dst.objects["gone.txt"] = &ObjectMeta{}
+	opts := Options{Src: src, Dst: dst, Delete: true}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, src, "c.txt", "ccc")
+	res, err := Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Files != 3 || res.Skipped != 2 || res.Uploaded != 1 || res.UploadedBytes != 3 || len(res.Failed) != 0 {
+		t.Errorf("result = %+v", res)
+	}
+	if len(res.Changes) != 1 || res.Changes[0] != (Event{Action: "upload", Key: "c.txt"}) {
+		t.Errorf("changes = %v, want the upload of c.txt", res.Changes)
+	}
+}
+
+func TestSync_keepGoing(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, src, name, name)
+	}
+	dst := newMockDest()
+	dst.objects["gone.txt"] = &ObjectMeta{}
+	opts := Options{Src: src, Dst: failingPut{dst, "b.txt"}, Delete: true, KeepGoing: true, Manifest: true}
+
+	res, err := Sync(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 uploads failed") {
+		t.Fatalf("Sync = %v, want the failure reported", err)
+	}
+	if len(res.Failed) != 1 || res.Failed[0].Key != "b.txt" || res.Uploaded != 2 {
+		t.Errorf("failed %v after uploading %d, want b.txt alone", res.Failed, res.Uploaded)
+	}
+	if dst.objects["gone.txt"] == nil {
+		t.Error("deleted gone.txt in a run with failures")
+	}
+	m, err := ReadManifest(context.Background(), dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range m.Files {
+		if e.Key == "b.txt" {
+			t.Error("manifest lists the file that failed to upload")
+		}
+	}
+
+	// Without KeepGoing the run stops at the failure.
+	opts.KeepGoing = false
+	if _, err := Sync(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "upload b.txt") {
+		t.Errorf("Sync = %v, want the upload of b.txt to fail it", err)
+	}
+}
@@ -0,0 +1,302 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+func init() {
+	Register("http", openHTTPURL)
+	Register("https", openHTTPURL)
+}
+
+// httpMetaHeader is the header HTTPDestination sends each file's metadata
+// in, URL-encoded, and expects HEAD to return it in.
+const httpMetaHeader = "X-Foldersync-Meta"
+
+// HTTPDestination uploads files to a plain HTTP server, an object per key
+// under a root URL, for self-hosted servers that do not emulate S3. The
+// server must:
+//
+//   - store the body of PUT root/key, along with the X-Foldersync-Meta
+//     header, and answer 200, 201 or 204;
+//   - answer HEAD and GET root/key with the stored object, its
+//     Content-Length and X-Foldersync-Meta, or 404 if there is none;
+//   - answer DELETE root/key with 200, 202, 204 or 404;
+//   - answer GET root/ with a JSON page of keys in byte order,
+//     {"keys": [...], "next": "..."}, where next, if not empty, is passed
+//     back in the cursor parameter for the next page.
+//
+// Keys are sent as URL paths, each segment escaped. Objects stored without
+// the metadata header are reported with their Content-Length and
+// Last-Modified.
+type HTTPDestination struct {
+	client *http.Client
+	root   *url.URL
+	auth   func(*http.Request)
+}
+
+// NewHTTPDestination creates a new HTTPDestination storing objects under
+// root, an http or https URL. If token is not empty, requests carry it as
+// a bearer token; otherwise, if user is not empty, they are authenticated
+// with it and password.
+func NewHTTPDestination(client *http.Client, root *url.URL, user, password, token string) *HTTPDestination {
+	r := *root
+	r.User = nil
+	r.Path = strings.TrimSuffix(r.Path, "/")
+	r.RawPath = ""
+	r.RawQuery = ""
+	d := &HTTPDestination{client: client, root: &r, auth: func(*http.Request) {}}
+	switch {
+	case token != "":
+		d.auth = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	case user != "":
+		d.auth = func(req *http.Request) { req.SetBasicAuth(user, password) }
+	}
+	return d
+}
+
+// openHTTPURL opens http://host/path or https://host/path, with the bearer
+// token in the FOLDERSYNC_HTTP_TOKEN environment variable, or the user and
+// password in the URL or in FOLDERSYNC_HTTP_USERNAME and
+// FOLDERSYNC_HTTP_PASSWORD.
+func openHTTPURL(ctx context.Context, u *url.URL) (Destination, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s:// URL must have a host", u.Scheme)
+	}
+	if u.Query().Get("storage-class") != "" {
+		return nil, fmt.Errorf("%s:// destinations do not support storage classes", u.Scheme)
+	}
+	user, password := os.Getenv("FOLDERSYNC_HTTP_USERNAME"), os.Getenv("FOLDERSYNC_HTTP_PASSWORD")
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+	return NewHTTPDestination(&http.Client{}, u, user, password, os.Getenv("FOLDERSYNC_HTTP_TOKEN")), nil
+}
+
+// url returns the URL of the object at key, or of the listing for "".
+func (d *HTTPDestination) url(key string) *url.URL {
+	u := *d.root
+	u.Path += "/" + key
+	return &u
+}
+
+// do sends req, made for key, and returns the response if its status is
+// one of ok. Otherwise it closes the body and returns a
+// *httpDestError.
+func (d *HTTPDestination) do(req *http.Request, key string, ok ...int) (*http.Response, error) {
+	d.auth(req)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(ok, resp.StatusCode) {
+		resp.Body.Close()
+		return nil, &httpDestError{Method: req.Method, Key: key, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, nil
+}
+
+func (d *HTTPDestination) request(ctx context.Context, method, key string, body io.Reader, ok ...int) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.url(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	return d.do(req, key, ok...)
+}
+
+// httpDestError is an unexpected response status from the server of an
+// HTTPDestination.
+type httpDestError struct {
+	Method     string
+	Key        string
+	StatusCode int
+	Status     string
+}
+
+func (e *httpDestError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Key, e.Status)
+}
+
+// httpDestStatus returns the status of the response err reports, or 0 if
+// it is not an *httpDestError.
+func httpDestStatus(err error) int {
+	var e *httpDestError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// Put uploads r with PUT, sending its length if r is an io.Seeker, and
+// meta in the X-Foldersync-Meta header.
+func (d *HTTPDestination) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := checkURLKey(key); err != nil {
+		return err
+	}
+	// The client closes request bodies, which are the caller's to close.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, d.url(key).String(), io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	md := make(url.Values)
+	for k, v := range objectMetadata(meta) {
+		md.Set(k, v)
+	}
+	req.Header.Set(httpMetaHeader, md.Encode())
+	if meta.ContentType != "" {
+		req.Header.Set("Content-Type", meta.ContentType)
+	}
+	if s, ok := r.(io.Seeker); ok {
+		n, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		req.ContentLength = n
+		if n == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	resp, err := d.do(req, key, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stat reports the metadata of the object at key from a HEAD request, or
+// nil if there is no object there.
+func (d *HTTPDestination) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	if err := checkURLKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := d.request(ctx, http.MethodHead, key, nil, http.StatusOK)
+	if httpDestStatus(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return httpObjectMeta(resp.ContentLength, resp.Header), nil
+}
+
+// httpObjectMeta returns the metadata of the object of size bytes whose
+// headers are h.
+func httpObjectMeta(size int64, h http.Header) *ObjectMeta {
+	q, err := url.ParseQuery(h.Get(httpMetaHeader))
+	if err != nil || len(q) == 0 {
+		// Not uploaded by foldersync.
+		meta := &ObjectMeta{Size: size, ContentType: h.Get("Content-Type")}
+		meta.ModTime, _ = http.ParseTime(h.Get("Last-Modified"))
+		return meta
+	}
+	md := make(map[string]string, len(q))
+	for k := range q {
+		md[k] = q.Get(k)
+	}
+	meta := parseMetadata(size, md)
+	meta.ContentType = h.Get("Content-Type")
+	return meta
+}
+
+func (d *HTTPDestination) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkURLKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := d.request(ctx, http.MethodGet, key, nil, http.StatusOK)
+	if httpDestStatus(err) == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// httpListPage is a page of the listing of an HTTPDestination.
+type httpListPage struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next"`
+}
+
+// List implements Destination, passing on the pages the server returns.
+// It fails if the server lists keys out of byte order.
+func (d *HTTPDestination) List(ctx context.Context, fn func(keys []string) error) error {
+	var cursor, last string
+	for {
+		u := d.url("")
+		if cursor != "" {
+			u.RawQuery = url.Values{"cursor": {cursor}}.Encode()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := d.do(req, "", http.StatusOK)
+		if httpDestStatus(err) == http.StatusNotFound {
+			return nil // nothing has been uploaded yet
+		}
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		var page httpListPage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		for _, k := range page.Keys {
+			if k <= last && last != "" {
+				return fmt.Errorf("list objects: server listed %q after %q, out of order", k, last)
+			}
+			last = k
+		}
+		if len(page.Keys) > 0 {
+			if err := fn(page.Keys); err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		if page.Next == cursor {
+			return fmt.Errorf("list objects: server returned cursor %q again", cursor)
+		}
+		cursor = page.Next
+	}
+}
+
+// Delete deletes the object at key. Deleting an absent object is not an
+// error.
+func (d *HTTPDestination) Delete(ctx context.Context, key string) error {
+	if err := checkURLKey(key); err != nil {
+		return err
+	}
+	resp, err := d.request(ctx, http.MethodDelete, key, nil, http.StatusOK, http.StatusAccepted, http.StatusNoContent)
+	if httpDestStatus(err) == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,191 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+// httpObjectServer is an in-memory server of the protocol HTTPDestination
+// speaks, listing pageSize keys at a time.
+type httpObjectServer struct {
+	mu       gosync.Mutex
+	data     map[string][]byte
+	header   map[string]http.Header
+	pageSize int
+	token    string
+}
+
+func (s *httpObjectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := strings.CutPrefix(r.URL.Path, "/backup/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if key == "" && r.Method == http.MethodGet {
+		keys := slices.Sorted(maps.Keys(s.data))
+		if c := r.URL.Query().Get("cursor"); c != "" {
+			i, _ := slices.BinarySearch(keys, c)
+			keys = keys[i:]
+		}
+		var page httpListPage
+		page.Keys = keys[:min(len(keys), s.pageSize)]
+		if len(keys) > s.pageSize {
+			page.Next = keys[s.pageSize]
+		}
+		json.NewEncoder(w).Encode(page)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.data[key] = data
+		s.header[key] = http.Header{httpMetaHeader: r.Header.Values(httpMetaHeader), "Content-Type": r.Header.Values("Content-Type")}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead, http.MethodGet:
+		data, ok := s.data[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		maps.Copy(w.Header(), s.header[key])
+		w.Header().Set("Last-Modified", time.Unix(1600000000, 0).UTC().Format(http.TimeFormat))
+		w.Write(data)
+	case http.MethodDelete:
+		if _, ok := s.data[key]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.data, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newHTTPObjectServer(t *testing.T, token string) (*httpObjectServer, *HTTPDestination) {
+	t.Helper()
+	s := &httpObjectServer{data: make(map[string][]byte), header: make(map[string]http.Header), pageSize: 2, token: token}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	root, err := url.Parse(srv.URL + "/backup/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, NewHTTPDestination(srv.Client(), root, "", "", token)
+}
+
+func TestHTTPDestination_roundTrip(t *testing.T) {
+	srv, dst := newHTTPObjectServer(t, "s3cret")
+	ctx := context.Background()
+	mtime := time.Unix(1700000000, 0)
+	meta := ObjectMeta{Size: 5, ModTime: mtime, Compression: "gzip", ContentType: "text/plain", SHA256: "abc"}
+	if err := dst.Put(ctx, "a b/c?d.txt", strings.NewReader("hello"), meta); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.data["a b/c?d.txt"]; !ok {
+		t.Fatalf("server holds %v, want the key as it was put", slices.Collect(maps.Keys(srv.data)))
+	}
+
+	got, err := dst.Stat(ctx, "a b/c?d.txt")
+	if err != nil || got == nil {
+		t.Fatalf("Stat = %v, %v", got, err)
+	}
+	if got.Size != 5 || !got.ModTime.Equal(mtime) || got.Compression != "gzip" || got.SHA256 != "abc" || got.ContentType != "text/plain" {
+		t.Errorf("Stat = %+v, want the metadata that was put", got)
+	}
+	rc, err := dst.Get(ctx, "a b/c?d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello" {
+		t.Errorf("Get = %q, want hello", data)
+	}
+
+	// Objects put by something else are reported from their headers.
+	srv.data["other"], srv.header["other"] = []byte("xyz"), http.Header{}
+	if got, err := dst.Stat(ctx, "other"); err != nil || got.Size != 3 || got.ModTime.Unix() != 1600000000 {
+		t.Errorf("Stat(other) = %+v, %v; want its Content-Length and Last-Modified", got, err)
+	}
+
+	if got, err := dst.Stat(ctx, "missing"); got != nil || err != nil {
+		t.Errorf("Stat(missing) = %v, %v; want nil, nil", got, err)
+	}
+	if _, err := dst.Get(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get(missing): got %v, want ErrNotExist", err)
+	}
+	if err := dst.Put(ctx, "../escape", strings.NewReader(""), ObjectMeta{}); err == nil {
+		t.Error("Put(../escape): got no error")
+	}
+	if err := dst.Delete(ctx, "a b/c?d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Delete(ctx, "a b/c?d.txt"); err != nil {
+		t.Errorf("deleting an absent object: %v", err)
+	}
+
+	_, unauthorized := newHTTPObjectServer(t, "other")
+	unauthorized.auth = func(*http.Request) {}
+	if err := unauthorized.Put(ctx, "x", strings.NewReader("x"), ObjectMeta{}); httpDestStatus(err) != http.StatusUnauthorized {
+		t.Errorf("without the token: got %v, want 401", err)
+	}
+}
+
+func TestHTTPDestination_list(t *testing.T) {
+	srv, dst := newHTTPObjectServer(t, "")
+	ctx := context.Background()
+	var pages [][]string
+	list := func(keys []string) error {
+		pages = append(pages, keys)
+		return nil
+	}
+	if err := dst.List(ctx, list); err != nil || len(pages) != 0 {
+		t.Fatalf("empty: %v, %v", pages, err)
+	}
+	for _, k := range []string{"e", "a", "d/x", "b", "c"} {
+		srv.data[k] = []byte(k)
+	}
+	if err := dst.List(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"a", "b"}, {"c", "d/x"}, {"e"}}; !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Errorf("pages %q, want %q", pages, want)
+	}
+}
+
+func TestSync_httpDestination(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "dir/b.txt", "bravo")
+	srv, dst := newHTTPObjectServer(t, "")
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Sync(ctx, Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Summary.Progress.Uploaded != 0 || len(srv.data) != 2 {
+		t.Errorf("second run uploaded %d files, server holds %d; want nothing uploaded again", res.Summary.Progress.Uploaded, len(srv.data))
+	}
+}
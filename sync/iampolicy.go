@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// S3Access is what a job does with its S3 destination, from which
+// S3Policy works out the permissions it needs.
+type S3Access struct {
+	// ReadOnly jobs only read the bucket, as Restore, Verify and Mount
+	// do, restoring archived objects first: they write nothing.
+	ReadOnly bool
+
+	// Delete deletes objects absent from the source: Options.Delete.
+	Delete bool
+	// Copy copies objects within the bucket, as Options.Snapshots,
+	// DeleteTo, DetectRenames, DedupeUploads, StageUploads and Tiers do.
+	Copy bool
+	// Tags tags the objects uploaded: Options.Tags.
+	Tags bool
+	// ObjectLock locks the objects uploaded: Options.ObjectLock.
+	ObjectLock bool
+	// CreateBucket creates and configures the bucket: EnsureBucket.
+	CreateBucket bool
+	// Versions lists object versions and, unless ReadOnly, restores and
+	// purges them: ListVersions, RestoreVersion and PurgeVersions.
+	Versions bool
+
+	// SSE and SSEKMSKeyID are how objects are encrypted, as
+	// WithS3Encryption takes them. SSE-KMS needs the use of the key.
+	SSE         string
+	SSEKMSKeyID string
+}
+
+// IAMPolicy is an AWS IAM policy document, which encodes to the JSON IAM
+// takes.
+type IAMPolicy struct {
+	Version   string
+	Statement []IAMStatement
+}
+
+// IAMStatement is a statement of an IAMPolicy.
+type IAMStatement struct {
+	Sid       string
+	Effect    string
+	Action    []string
+	Resource  []string
+	Condition map[string]map[string][]string `json:",omitempty"`
+}
+
+// S3Policy returns the least IAM policy that lets a job doing what a
+// describes use the bucket of the s3:// URL rawURL: listing only below its
+// prefix, acting only on the objects there, and deleting them only if a
+// deletes, beyond the bookkeeping objects of the destination, such as the
+// run lock.
+func S3Policy(rawURL string, a S3Access) (*IAMPolicy, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" {
+		return nil, fmt.Errorf("IAM policies are for s3:// destinations, not %s://", u.Scheme)
+	}
+	bucket, prefix, err := bucketAndPrefix(u)
+	if err != nil {
+		return nil, err
+	}
+	bucketARN := "arn:aws:s3:::" + bucket
+	objects := bucketARN + "/" + listPrefix(prefix) + "*"
+
+	list := IAMStatement{
+		Sid:      "List",
+		Effect:   "Allow",
+		Action:   []string{"s3:ListBucket"},
+		Resource: []string{bucketARN},
+	}
+	if prefix != "" {
+		list.Condition = map[string]map[string][]string{
+			"StringLike": {"s3:prefix": {listPrefix(prefix), listPrefix(prefix) + "*"}},
+		}
+	}
+	if a.Versions {
+		list.Action = append(list.Action, "s3:ListBucketVersions")
+	}
+	p := &IAMPolicy{Version: "2012-10-17", Statement: []IAMStatement{list}}
+	allow := func(sid string, resource string, actions ...string) {
+		p.Statement = append(p.Statement, IAMStatement{
+			Sid:      sid,
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: []string{resource},
+		})
+	}
+
+	if a.ReadOnly {
+		allow("Read", objects, "s3:GetObject", "s3:RestoreObject")
+	} else {
+		write := []string{"s3:GetObject", "s3:PutObject", "s3:AbortMultipartUpload"}
+		if a.Tags {
+			write = append(write, "s3:PutObjectTagging")
+			if a.Copy {
+				write = append(write, "s3:GetObjectTagging")
+			}
+		}
+		if a.ObjectLock {
+			write = append(write, "s3:PutObjectRetention", "s3:PutObjectLegalHold")
+		}
+		allow("ReadWrite", objects, write...)
+		if a.Delete {
+			allow("Delete", objects, "s3:DeleteObject")
+		} else {
+			allow("DeleteBookkeeping", bucketARN+"/"+joinKey(prefix, metaPrefix)+"*", "s3:DeleteObject")
+		}
+		if a.Versions {
+			allow("Versions", objects, "s3:GetObjectVersion", "s3:DeleteObjectVersion")
+		}
+	}
+
+	var bucketActions []string
+	if a.ObjectLock && !a.ReadOnly {
+		bucketActions = append(bucketActions, "s3:GetBucketObjectLockConfiguration")
+	}
+	if a.CreateBucket && !a.ReadOnly {
+		bucketActions = append(bucketActions, "s3:CreateBucket", "s3:PutBucketVersioning", "s3:PutBucketEncryption",
+			"s3:PutBucketPublicAccessBlock", "s3:PutLifecycleConfiguration")
+		if a.ObjectLock {
+			bucketActions = append(bucketActions, "s3:PutBucketObjectLockConfiguration")
+		}
+	}
+	if len(bucketActions) > 0 {
+		allow("Bucket", bucketARN, bucketActions...)
+	}
+
+	if strings.HasPrefix(a.SSE, "aws:kms") {
+		kms := IAMStatement{
+			Sid:      "KMS",
+			Effect:   "Allow",
+			Action:   []string{"kms:Decrypt"},
+			Resource: []string{kmsKeyARN(a.SSEKMSKeyID)},
+		}
+		if !a.ReadOnly {
+			kms.Action = append(kms.Action, "kms:GenerateDataKey")
+		}
+		if kms.Resource[0] == "*" {
+			// Whatever key it is, only through S3.
+			kms.Condition = map[string]map[string][]string{
+				"StringLike": {"kms:ViaService": {"s3.*.amazonaws.com"}},
+			}
+		}
+		p.Statement = append(p.Statement, kms)
+	}
+	return p, nil
+}
+
+// kmsKeyARN returns the resource of an IAM policy naming the KMS key id,
+// given as an ARN or key ID; an alias, which policies cannot name a key
+// by, or none, the bucket's, is any key.
+func kmsKeyARN(id string) string {
+	switch {
+	case strings.HasPrefix(id, "arn:"):
+		return id
+	case id == "" || strings.HasPrefix(id, "alias/"):
+		return "*"
+	}
+	return "arn:aws:kms:*:*:key/" + id
+}
@@ -0,0 +1,67 @@
+package sync
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestS3Policy(t *testing.T) {
+	p, err := S3Policy("s3://bucket/photos/?region=eu-west-1", S3Access{SSEKMSKeyID: "1234abcd", SSE: "aws:kms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements := make(map[string]IAMStatement)
+	for _, s := range p.Statement {
+		statements[s.Sid] = s
+	}
+	list := statements["List"]
+	if list.Resource[0] != "arn:aws:s3:::bucket" || !slices.Equal(list.Condition["StringLike"]["s3:prefix"], []string{"photos/", "photos/*"}) {
+		t.Errorf("List = %+v, want listing below photos/", list)
+	}
+	if rw := statements["ReadWrite"]; rw.Resource[0] != "arn:aws:s3:::bucket/photos/*" || !slices.Contains(rw.Action, "s3:PutObject") {
+		t.Errorf("ReadWrite = %+v, want writes to photos/*", rw)
+	}
+	if _, ok := statements["Delete"]; ok {
+		t.Error("policy allows deletes without Delete")
+	}
+	if d := statements["DeleteBookkeeping"]; d.Resource[0] != "arn:aws:s3:::bucket/photos/.foldersync/*" {
+		t.Errorf("DeleteBookkeeping = %+v, want deletes of photos/.foldersync/*", d)
+	}
+	if kms := statements["KMS"]; kms.Resource[0] != "arn:aws:kms:*:*:key/1234abcd" || !slices.Contains(kms.Action, "kms:GenerateDataKey") {
+		t.Errorf("KMS = %+v, want the use of key 1234abcd", kms)
+	}
+
+	p, err = S3Policy("s3://bucket", S3Access{Delete: true, Tags: true, Copy: true, ObjectLock: true, Versions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, s := range p.Statement {
+		if s.Sid == "List" && s.Condition != nil {
+			t.Errorf("List = %+v, want the whole bucket listed", s)
+		}
+		actions = append(actions, s.Action...)
+	}
+	for _, a := range []string{"s3:DeleteObject", "s3:PutObjectTagging", "s3:GetObjectTagging", "s3:PutObjectRetention", "s3:GetBucketObjectLockConfiguration",
+		"s3:ListBucketVersions", "s3:DeleteObjectVersion"} {
+		if !slices.Contains(actions, a) {
+			t.Errorf("actions %v, want %s", actions, a)
+		}
+	}
+
+	p, err = S3Policy("s3://bucket/photos", S3Access{ReadOnly: true, Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range p.Statement {
+		for _, a := range s.Action {
+			if a == "s3:PutObject" || a == "s3:DeleteObject" {
+				t.Errorf("read-only policy allows %s", a)
+			}
+		}
+	}
+
+	if _, err := S3Policy("gs://bucket/photos", S3Access{}); err == nil {
+		t.Error("S3Policy of a gs:// URL succeeded")
+	}
+}
@@ -0,0 +1,188 @@
+package sync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is the name of the files that exclude paths from a sync. Each
+// holds patterns in .gitignore syntax, relative to the directory it is in,
+// and applies to everything below that directory:
+//
+//	# comments and blank lines are skipped
+//	*.tmp          files or directories named *.tmp, at any depth
+//	build/         directories named build, at any depth
+//	/cache         cache in this directory only
+//	docs/**/*.pdf  PDFs anywhere under docs
+//	!keep.tmp      re-include keep.tmp
+//
+// The last matching pattern decides, and patterns in deeper directories
+// override those above them. As with git, a file inside an ignored
+// directory cannot be re-included. Ignored files are not uploaded, and
+// their objects are not deleted from the destination, even once the files
+// are gone, unless Options.DeleteExcluded is set. The ignore files
+// themselves are synced like any other file.
+const IgnoreFile = ".foldersyncignore"
+
+// ignorePattern is one line of an IgnoreFile.
+type ignorePattern struct {
+	segments []string // slash-separated parts, "**" matching any number
+	negate   bool     // re-includes what it matches
+	dirOnly  bool     // matches directories only
+}
+
+// ignorer decides which paths under a source directory are ignored,
+// reading the IgnoreFile of each directory the first time it is needed.
+type ignorer struct {
+	src   string
+	rules map[string][]ignorePattern // by directory, "." for src
+}
+
+func newIgnorer(src string) *ignorer {
+	return &ignorer{src: src, rules: make(map[string][]ignorePattern)}
+}
+
+// ignored reports whether rel, a slash-separated path inside the source,
+// is ignored, either itself or because a directory containing it is.
+func (ig *ignorer) ignored(rel string, isDir bool) (bool, error) {
+	for i := 0; i < len(rel); i++ {
+		if rel[i] != '/' {
+			continue
+		}
+		if skip, err := ig.match(rel[:i], true); err != nil || skip {
+			return skip, err
+		}
+	}
+	return ig.match(rel, isDir)
+}
+
+// match reports whether the patterns in effect for rel ignore it, without
+// looking at the directories containing it. It is used while walking the
+// source, where those have been looked at already. A nil ignorer ignores
+// nothing.
+func (ig *ignorer) match(rel string, isDir bool) (bool, error) {
+	if ig == nil || rel == "." {
+		return false, nil
+	}
+	skip := false
+	// Apply the rules of each directory above rel, outermost first.
+	dir := "."
+	for {
+		rules, err := ig.load(dir)
+		if err != nil {
+			return false, err
+		}
+		sub := rel
+		if dir != "." {
+			sub = rel[len(dir)+1:]
+		}
+		for _, p := range rules {
+			if p.matches(sub, isDir) {
+				skip = !p.negate
+			}
+		}
+		next, _, ok := strings.Cut(sub, "/")
+		if !ok {
+			return skip, nil
+		}
+		dir = path.Join(dir, next)
+	}
+}
+
+// load returns the patterns of the IgnoreFile in dir, if there is one.
+func (ig *ignorer) load(dir string) ([]ignorePattern, error) {
+	if rules, ok := ig.rules[dir]; ok {
+		return rules, nil
+	}
+	name := filepath.Join(ig.src, filepath.FromSlash(dir), IgnoreFile)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		ig.rules[dir] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignorePattern
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if p, ok := parseIgnorePattern(sc.Text()); ok {
+			rules = append(rules, p)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	ig.rules[dir] = rules
+	return rules, nil
+}
+
+// parseIgnorePattern parses a line of an IgnoreFile, reporting false for
+// blank lines and comments.
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+	if line == "" || line[0] == '#' {
+		return ignorePattern{}, false
+	}
+	var p ignorePattern
+	switch {
+	case line[0] == '!':
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+	// A pattern with a slash other than at the end is anchored to the
+	// directory of the IgnoreFile; one without matches at any depth.
+	if !strings.Contains(line, "/") {
+		line = "**/" + line
+	}
+	p.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	return p, true
+}
+
+// matches reports whether p matches rel, relative to the directory of its
+// IgnoreFile.
+func (p ignorePattern) matches(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(p.segments, strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
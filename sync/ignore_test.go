@@ -0,0 +1,180 @@
+package sync
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestIgnorePattern_matches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.tmp", "a.tmp", false, true},
+		{"*.tmp", "sub/deep/a.tmp", false, true},
+		{"*.tmp", "a.tmp.txt", false, false},
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "src/build", true, true},
+		{"/cache", "cache", true, true},
+		{"/cache", "sub/cache", true, false},
+		{"docs/*.pdf", "docs/a.pdf", false, true},
+		{"docs/*.pdf", "docs/sub/a.pdf", false, false},
+		{"docs/**/*.pdf", "docs/sub/deep/a.pdf", false, true},
+		{"docs/**/*.pdf", "docs/a.pdf", false, true},
+		{"**/logs", "a/b/logs", true, true},
+		{`\#notes`, "#notes", false, true},
+		{"trailing   ", "trailing", false, true},
+	}
+	for _, tt := range tests {
+		p, ok := parseIgnorePattern(tt.pattern)
+		if !ok {
+			t.Errorf("%q: not parsed", tt.pattern)
+			continue
+		}
+		if got := p.matches(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("%q matches %q (dir %v) = %v, want %v", tt.pattern, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if _, ok := parseIgnorePattern(line); ok {
+			t.Errorf("%q parsed as a pattern", line)
+		}
+	}
+}
+
+func TestSync_ignoreFiles(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, IgnoreFile, "*.tmp\nnode_modules/\n!keep.tmp\n")
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "a.tmp", "a")
+	writeFile(t, src, "keep.tmp", "k")
+	writeFile(t, src, "app/node_modules/lib.js", "lib")
+	writeFile(t, src, "app/main.js", "main")
+	writeFile(t, src, "app/cache/"+IgnoreFile, "*\n!"+IgnoreFile+"\n")
+	writeFile(t, src, "app/cache/blob", "blob")
+	writeFile(t, src, "app/sub/"+IgnoreFile, "!*.tmp\n")
+	writeFile(t, src, "app/sub/b.tmp", "b")
+
+	dst := newMockDest()
+	dst.objects["old.tmp"] = &ObjectMeta{}  // ignored, still in the source
+	dst.objects["gone.txt"] = &ObjectMeta{} // absent from the source
+	writeFile(t, src, "old.tmp", "old")
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(dst.putCalls)
+	want := []string{IgnoreFile, "a.txt", "app/cache/" + IgnoreFile, "app/main.js", "app/sub/" + IgnoreFile, "app/sub/b.tmp", "keep.tmp"}
+	if !slices.Equal(dst.putCalls, want) {
+		t.Errorf("uploaded %v, want %v", dst.putCalls, want)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"gone.txt"}) {
+		t.Errorf("deleted %v, want [gone.txt]", dst.deleteCalls)
+	}
+}
+
+func TestSync_deleteExcluded(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, IgnoreFile, "*.tmp\ncache/\n")
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "a.tmp", "a")
+	newDst := func() *mockDest {
+		dst := newMockDest()
+		for _, key := range []string{"a.tmp", "gone.tmp", "cache/blob", "gone.txt"} {
+			dst.objects[key] = &ObjectMeta{}
+		}
+		return dst
+	}
+
+	// Excluded paths are left alone, whether or not their files exist.
+	dst := newDst()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"gone.txt"}) {
+		t.Errorf("deleted %v, want [gone.txt]", dst.deleteCalls)
+	}
+
+	dst = newDst()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true, DeleteExcluded: true}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dst.deleteCalls)
+	if want := []string{"a.tmp", "cache/blob", "gone.tmp", "gone.txt"}; !slices.Equal(dst.deleteCalls, want) {
+		t.Errorf("deleted %v with DeleteExcluded, want %v", dst.deleteCalls, want)
+	}
+
+	dst = newDst()
+	opts := Options{Src: src, Dst: dst, Delete: true, FileList: []string{"a.tmp", "gone.tmp", "gone.txt"}}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"gone.txt"}) {
+		t.Errorf("deleted %v from a file list, want [gone.txt]", dst.deleteCalls)
+	}
+	dst.deleteCalls = nil
+	opts.DeleteExcluded = true
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dst.deleteCalls)
+	if want := []string{"a.tmp", "gone.tmp"}; !slices.Equal(dst.deleteCalls, want) {
+		t.Errorf("deleted %v from a file list with DeleteExcluded, want %v", dst.deleteCalls, want)
+	}
+}
+
+func TestSync_deleteExcludedUnmappable(t *testing.T) {
+	src := t.TempDir()
+	dst := newMockDest()
+	for _, key := range []string{"2024/03/01/gone.txt", "stray.txt"} {
+		dst.objects[key] = &ObjectMeta{}
+	}
+
+	// stray.txt fits no date, so the layout maps it to no path.
+	opts := Options{Src: src, Dst: dst, Keys: DateKeys{}, Delete: true}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"2024/03/01/gone.txt"}) {
+		t.Errorf("deleted %v, want [2024/03/01/gone.txt]", dst.deleteCalls)
+	}
+
+	dst.deleteCalls = nil
+	opts.DeleteExcluded = true
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"stray.txt"}) {
+		t.Errorf("deleted %v with DeleteExcluded, want [stray.txt]", dst.deleteCalls)
+	}
+}
+
+func TestIgnorer_ignored(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, IgnoreFile, "build/\n")
+	writeFile(t, src, "docs/"+IgnoreFile, "*.pdf\n")
+	ig := newIgnorer(src)
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"build/out/a.o", true}, // under an ignored directory
+		{"docs/a.pdf", true},
+		{"docs/sub/a.pdf", true},
+		{"a.pdf", false}, // above the ignore file
+		{"missing/dir/a.txt", false},
+	}
+	for _, tt := range tests {
+		got, err := ig.ignored(tt.rel, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
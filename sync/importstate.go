@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImportOptions configures ImportState.
+type ImportOptions struct {
+	Src  string
+	Dst  Destination
+	Keys KeyMapper // as for the jobs syncing Src to Dst
+
+	UnicodeForm UnicodeForm // as for the jobs syncing Src to Dst
+
+	// StateCache is the path of the cache to write, as for
+	// Options.StateCache. A cache already there is replaced.
+	StateCache string
+
+	// Inventory, if non-nil, is used instead of listing Dst. Objects
+	// outside Dst's prefix are ignored.
+	Inventory *Inventory
+}
+
+// ImportReport is the result of ImportState.
+type ImportReport struct {
+	Files  int // source files considered
+	Seeded int // of those, files recorded as up to date
+}
+
+// ImportState writes a state cache for syncing opts.Src to opts.Dst from a
+// single listing of Dst, or from opts.Inventory, so that the first run on a
+// new machine against an existing backup need not ask Dst about every file.
+//
+// Listings do not carry the modification time recorded with each object,
+// so a file is recorded as up to date if an object of the same size was
+// written to its key no earlier than the file was last modified. A
+// destination that does not implement ObjectLister is asked about each file
+// instead, and its recorded size and mtime must match. Entries carry no
+// content hash or POSIX attributes, so they only save requests for keys
+// compared without ChecksumComparer and for runs without
+// Options.PreservePOSIX; other files are checked as usual.
+func ImportState(ctx context.Context, opts ImportOptions) (*ImportReport, error) {
+	objects, err := importObjects(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &stateCache{path: opts.StateCache, new: make(map[string]stateEntry)}
+	meta, err := opts.Dst.Stat(ctx, ManifestKey)
+	if err != nil {
+		return nil, &FileError{Op: "stat", Key: ManifestKey, Err: err}
+	}
+	if meta != nil {
+		c.manifest = meta.ModTime.Unix()
+	}
+
+	report := &ImportReport{}
+	mapper := keyMapper(opts.Keys)
+	err = filepath.WalkDir(opts.Src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := sourceKey(opts.Src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel+"/" == metaPrefix {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		report.Files++
+		file := File{Key: mapper.Key(opts.UnicodeForm.normalize(rel), info.ModTime()), Path: path, Size: info.Size(), ModTime: info.ModTime()}
+		ok, err := importedUpToDate(ctx, opts.Dst, objects, file)
+		if err != nil || !ok {
+			return err
+		}
+		c.new[file.Key] = newStateEntry(file)
+		report.Seeded++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, c.save()
+}
+
+// importObjects returns the objects of opts.Dst, from opts.Inventory or a
+// listing, or nil if Dst cannot list them with their sizes.
+func importObjects(ctx context.Context, opts ImportOptions) (map[string]ListedObject, error) {
+	if opts.Inventory == nil {
+		if l, ok := opts.Dst.(ObjectLister); ok {
+			return l.ListObjects(ctx)
+		}
+		return nil, nil
+	}
+	var prefix string
+	if p, ok := opts.Dst.(Prefixed); ok {
+		prefix = p.Prefix()
+	}
+	objects := make(map[string]ListedObject)
+	for key, obj := range opts.Inventory.Objects {
+		if strings.HasPrefix(key, listPrefix(prefix)) {
+			objects[splitKey(prefix, key)] = obj
+		}
+	}
+	return objects, nil
+}
+
+// importedUpToDate reports whether the destination's copy of file is up
+// to date, judged from objects, or by asking dst if objects is nil.
+func importedUpToDate(ctx context.Context, dst Destination, objects map[string]ListedObject, file File) (bool, error) {
+	if objects == nil {
+		meta, err := dst.Stat(ctx, file.Key)
+		if err != nil {
+			return false, &FileError{Op: "stat", Key: file.Key, Err: err}
+		}
+		return meta != nil && meta.Size == file.Size && meta.ModTime.Unix() == file.ModTime.Unix(), nil
+	}
+	obj, ok := objects[file.Key]
+	return ok && obj.Size == file.Size && !obj.Written.Before(file.ModTime.Truncate(time.Second)), nil
+}
@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// listingDest is a mockDest whose listings report sizes and write times.
+type listingDest struct {
+	*mockDest
+}
+
+func (d listingDest) ListObjects(context.Context) (map[string]ListedObject, error) {
+	objects := make(map[string]ListedObject)
+	for key, meta := range d.objects {
+		objects[key] = ListedObject{Size: meta.Size, Written: d.written[key]}
+	}
+	return objects, nil
+}
+
+func TestImportState_listing(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "sub/b.txt", "b")
+	writeFile(t, src, "new.txt", "new")
+	mock := newMockDest()
+	ctx := context.Background()
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		info, _ := os.Stat(filepath.Join(src, name))
+		mock.objects[name] = &ObjectMeta{Size: info.Size(), ModTime: info.ModTime()}
+		mock.written[name] = time.Now()
+	}
+	// Modified since it was uploaded.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "sub/b.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	state := filepath.Join(t.TempDir(), "state.json")
+	report, err := ImportState(ctx, ImportOptions{Src: src, Dst: listingDest{mock}, StateCache: state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 3 || report.Seeded != 1 {
+		t.Errorf("report = %+v, want 3 files, 1 seeded", *report)
+	}
+	if len(mock.statCalls) != 1 || mock.statCalls[0] != ManifestKey {
+		t.Errorf("stat calls = %v, want only the manifest", mock.statCalls)
+	}
+
+	mock.statCalls = nil
+	if _, err := Sync(ctx, Options{Src: src, Dst: mock, StateCache: state}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(mock.statCalls)
+	if want := []string{"new.txt", "sub/b.txt"}; !slices.Equal(mock.statCalls, want) {
+		t.Errorf("sync stat calls = %v, want %v", mock.statCalls, want)
+	}
+}
+
+func TestImportState_statsWithoutListing(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	mock := newMockDest()
+	mock.objects["a.txt"] = &ObjectMeta{Size: info.Size(), ModTime: info.ModTime()}
+	mock.objects["b.txt"] = &ObjectMeta{Size: 1, ModTime: info.ModTime().Add(-time.Hour)}
+
+	report, err := ImportState(context.Background(), ImportOptions{
+		Src: src, Dst: mock, StateCache: filepath.Join(t.TempDir(), "state.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Files != 2 || report.Seeded != 1 {
+		t.Errorf("report = %+v, want 2 files, 1 seeded", *report)
+	}
+}
+
+func TestImportState_inventory(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	mock := newMockDest()
+	inv := &Inventory{Objects: map[string]ListedObject{
+		"a.txt": {Size: info.Size(), Written: info.ModTime().Add(time.Minute)},
+		"b.txt": {Size: 1, Written: info.ModTime().Add(-time.Hour)}, // uploaded before the change
+	}}
+
+	report, err := ImportState(context.Background(), ImportOptions{
+		Src: src, Dst: mock, Inventory: inv, StateCache: filepath.Join(t.TempDir(), "state.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Seeded != 1 {
+		t.Errorf("seeded %d files, want 1", report.Seeded)
+	}
+	if len(mock.statCalls) != 1 {
+		t.Errorf("stat calls = %v, want only the manifest", mock.statCalls)
+	}
+}
@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// DefaultInFlightPatterns are the names of the files Options.SkipInFlight
+// takes for temporary or lock files when Options.InFlightPatterns is nil:
+// those of office suites, editors, browsers and download managers.
+var DefaultInFlightPatterns = []string{
+	"~$*", ".~lock.*#", ".#*", // Office, LibreOffice and Emacs lock files
+	"*.swp", "*.swo", "*.swx", // Vim swap files
+	"*.part", "*.partial", "*.crdownload", "*.download", // partial downloads
+	"*.tmp", "*.lck",
+}
+
+// skipReason returns why the filters of opts skip the file at rel, which
+// info describes, or "" if they do not.
+func (opts Options) skipReason(plan *Plan, rel string, info fs.FileInfo) string {
+	if reason := opts.filterReason(info.Size(), info.ModTime()); reason != "" {
+		return reason
+	}
+	return opts.inFlightReason(plan, rel, info)
+}
+
+// inFlightReason returns why Options.SkipInFlight takes the file at rel,
+// which info describes, to be in the middle of being written, or "" if it
+// does not.
+func (opts Options) inFlightReason(plan *Plan, rel string, info fs.FileInfo) string {
+	if !opts.SkipInFlight {
+		return ""
+	}
+	patterns := opts.InFlightPatterns
+	if patterns == nil {
+		patterns = DefaultInFlightPatterns
+	}
+	name := path.Base(rel)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return "temporary file"
+		}
+	}
+	if opts.InFlightAge > 0 && time.Since(info.ModTime()) < opts.InFlightAge {
+		return "modified less than " + opts.InFlightAge.String() + " ago"
+	}
+	if id, ok := fileID(info); ok && plan.writing[id] {
+		return "open for writing"
+	}
+	return ""
+}
+
+// checkInFlightPatterns checks that the patterns of Options.InFlightPatterns
+// are well formed.
+func checkInFlightPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("in-flight pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
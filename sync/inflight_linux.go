@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// openForWriting returns the files that processes have open for writing,
+// as far as /proc shows them: those of other users only to root. The
+// process running the sync is left out.
+func openForWriting() map[inode]bool {
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	self := strconv.Itoa(os.Getpid())
+	writing := make(map[inode]bool)
+	for _, p := range procs {
+		if _, err := strconv.Atoi(p.Name()); err != nil || p.Name() == self {
+			continue
+		}
+		dir := filepath.Join("/proc", p.Name())
+		fds, err := os.ReadDir(filepath.Join(dir, "fdinfo"))
+		if err != nil {
+			continue // exited, or not ours to see
+		}
+		for _, fd := range fds {
+			if !openedForWriting(filepath.Join(dir, "fdinfo", fd.Name())) {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(dir, "fd", fd.Name()))
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if id, ok := fileID(info); ok {
+				writing[id] = true
+			}
+		}
+	}
+	return writing
+}
+
+// openedForWriting reports whether the fdinfo file at path describes a
+// file descriptor open for writing.
+func openedForWriting(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "flags:"); ok {
+			flags, err := strconv.ParseUint(strings.TrimSpace(v), 8, 64)
+			return err == nil && flags&syscall.O_ACCMODE != syscall.O_RDONLY
+		}
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sync
+
+// Files open for writing are not detected on this platform; the other
+// checks of Options.SkipInFlight still apply.
+
+func openForWriting() map[inode]bool { return nil }
@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSync_skipInFlight(t *testing.T) {
+	src := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"a.txt", ".a.txt.swp", "~$report.docx", "open.log"} {
+		writeFile(t, src, name, name)
+		if err := os.Chtimes(filepath.Join(src, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, src, "fresh.txt", "still being written")
+
+	want := map[string]string{
+		".a.txt.swp":    "temporary file",
+		"~$report.docx": "temporary file",
+		"fresh.txt":     "modified less than 1h0m0s ago",
+	}
+	if runtime.GOOS == "linux" {
+		// Held open for writing by another process.
+		f, err := os.OpenFile(filepath.Join(src, "open.log"), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command("sleep", "60")
+		cmd.ExtraFiles = []*os.File{f}
+		if err := cmd.Start(); err != nil {
+			t.Skipf("cannot start a process to hold the file open: %v", err)
+		}
+		f.Close()
+		defer cmd.Wait()
+		defer cmd.Process.Kill()
+		want["open.log"] = "open for writing"
+	}
+
+	dst := newMockDest()
+	skipped := make(map[string]string)
+	opts := Options{Src: src, Dst: dst, SkipInFlight: true, InFlightAge: time.Hour, DryRun: true,
+		OnEvent: func(e Event) {
+			if e.Action == "skip" {
+				skipped[e.Key] = e.Reason
+			}
+		}}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != len(want) {
+		t.Errorf("skipped %v, want %v", skipped, want)
+	}
+	for key, reason := range want {
+		if skipped[key] != reason {
+			t.Errorf("%s skipped for %q, want %q", key, skipped[key], reason)
+		}
+	}
+
+	opts.DryRun, opts.OnEvent = false, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	var wantPut []string
+	for _, name := range []string{"a.txt", "open.log"} {
+		if _, ok := want[name]; !ok {
+			wantPut = append(wantPut, name)
+		}
+	}
+	slices.Sort(dst.putCalls)
+	if !slices.Equal(dst.putCalls, wantPut) {
+		t.Errorf("put %v, want %v", dst.putCalls, wantPut)
+	}
+}
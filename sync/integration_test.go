@@ -0,0 +1,301 @@
+//go:build integration
+
+package sync_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	stdsync "sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+
+	"github.com/sandeepkandula/foldersync/sync"
+	"github.com/sandeepkandula/foldersync/sync/s3test"
+)
+
+// server is the S3 server the tests run against, nil if there is none.
+var server *s3test.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	server, err = s3test.Start(context.Background())
+	if err != nil && !errors.Is(err, s3test.ErrNoServer) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	if server != nil {
+		server.Close()
+	}
+	os.Exit(code)
+}
+
+// newBucket returns a new bucket on the server, skipping t without one.
+func newBucket(t *testing.T) string {
+	t.Helper()
+	if server == nil {
+		t.Skip(s3test.ErrNoServer)
+	}
+	return server.NewBucket(t)
+}
+
+// requests counts the requests a destination makes, by operation.
+type requests struct {
+	mu stdsync.Mutex
+	n  map[string]int
+}
+
+func (r *requests) option() sync.S3Option {
+	r.n = make(map[string]int)
+	return sync.WithS3Middleware(func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("count",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				r.mu.Lock()
+				r.n[awsmiddleware.GetOperationName(ctx)]++
+				r.mu.Unlock()
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	})
+}
+
+func (r *requests) count(op string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n[op]
+}
+
+func writeTestFile(t *testing.T, dir, name string, content []byte) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntegration_multipart(t *testing.T) {
+	bucket := newBucket(t)
+	src := t.TempDir()
+	big := make([]byte, 12<<20)
+	rand.Read(big)
+	writeTestFile(t, src, "disk.img", big)
+
+	var reqs requests
+	dst := server.Destination(bucket, "backup", sync.WithS3PartSize(5<<20), reqs.option())
+	if _, err := sync.Sync(context.Background(), sync.Options{Src: src, Dst: dst, Checksums: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := reqs.count("UploadPart"); n != 3 {
+		t.Errorf("uploaded in %d parts, want 3 of 5 MiB", n)
+	}
+	head, err := server.Client().HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("backup/disk.img"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag := aws.ToString(head.ETag); !strings.HasSuffix(etag, `-3"`) {
+		t.Errorf("ETag %s, want that of a multipart upload of 3 parts", etag)
+	}
+
+	to := t.TempDir()
+	if err := sync.Restore(context.Background(), sync.RestoreOptions{From: dst, To: to}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(to, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Error("restored disk.img differs from the file uploaded")
+	}
+}
+
+func TestIntegration_paginationAndDeletes(t *testing.T) {
+	bucket := newBucket(t)
+	src := t.TempDir()
+	const files = 1500 // more than a page of a listing, and a delete batch
+	for i := range files {
+		writeTestFile(t, src, fmt.Sprintf("d%d/f%04d.txt", i%7, i), []byte(fmt.Sprint(i)))
+	}
+
+	var reqs requests
+	dst := server.Destination(bucket, "many", reqs.option())
+	res, err := sync.Sync(context.Background(), sync.Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Uploaded != files {
+		t.Fatalf("uploaded %d files, want %d", res.Uploaded, files)
+	}
+
+	// A second run lists every object, over two pages, and finds them all.
+	res, err = sync.Sync(context.Background(), sync.Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Uploads != 0 || res.Skipped != files {
+		t.Errorf("second run found %d uploads and %d files up to date, want 0 and %d", res.Uploads, res.Skipped, files)
+	}
+	if n := reqs.count("ListObjectsV2"); n < 2 {
+		t.Errorf("listed in %d requests, want several pages", n)
+	}
+
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	res, err = sync.Sync(context.Background(), sync.Options{Src: src, Dst: dst, Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Deleted != files {
+		t.Errorf("deleted %d objects, want %d", res.Deleted, files)
+	}
+	if n := reqs.count("DeleteObjects"); n != 2 {
+		t.Errorf("deleted in %d batches, want 2", n)
+	}
+	var left int
+	err = dst.List(context.Background(), func(keys []string) error {
+		for _, k := range keys {
+			if !strings.HasPrefix(k, ".foldersync/") {
+				left++
+			}
+		}
+		return nil
+	})
+	if err != nil || left != 0 {
+		t.Errorf("%d objects left, %v; want none", left, err)
+	}
+}
+
+func TestIntegration_metadata(t *testing.T) {
+	bucket := newBucket(t)
+	src := t.TempDir()
+	writeTestFile(t, src, "site/index.html", []byte("<!doctype html><p>hello</p>"))
+	writeTestFile(t, src, "notes.txt", []byte("notes"))
+	mtime := time.Date(2021, 5, 4, 3, 2, 1, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "notes.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "notes.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := server.Destination(bucket, "meta")
+	opts := sync.Options{Src: src, Dst: dst, Checksums: true, PreservePOSIX: true, Tags: map[string]string{"backup": "foldersync"}}
+	if _, err := sync.Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := dst.Stat(context.Background(), "notes.txt")
+	if err != nil || meta == nil {
+		t.Fatalf("Stat(notes.txt) = %v, %v", meta, err)
+	}
+	if !meta.ModTime.Equal(mtime) || meta.Size != 5 || meta.SHA256 == "" || meta.POSIX == nil {
+		t.Errorf("Stat(notes.txt) = %+v, want its mtime, size, SHA-256 and POSIX attributes", meta)
+	}
+	if meta, err := dst.Stat(context.Background(), "site/index.html"); err != nil || meta == nil || !strings.HasPrefix(meta.ContentType, "text/html") {
+		t.Errorf("Stat(site/index.html) = %+v, %v; want text/html", meta, err)
+	}
+	tags, err := server.Client().GetObjectTagging(context.Background(), &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("meta/notes.txt"),
+	})
+	if err != nil || len(tags.TagSet) != 1 || aws.ToString(tags.TagSet[0].Key) != "backup" {
+		t.Errorf("tags of notes.txt = %+v, %v; want backup=foldersync", tags, err)
+	}
+
+	// Unchanged files are found up to date from what the server kept.
+	res, err := sync.Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Uploads != 0 {
+		t.Errorf("second run found %d uploads, want none", res.Uploads)
+	}
+
+	to := t.TempDir()
+	if err := sync.Restore(context.Background(), sync.RestoreOptions{From: dst, To: to}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(to, "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) || info.Mode().Perm() != 0600 {
+		t.Errorf("restored notes.txt modified %v with mode %v, want %v and 0600", info.ModTime(), info.Mode(), mtime)
+	}
+}
+
+func TestIntegration_versions(t *testing.T) {
+	bucket := newBucket(t)
+	_, err := server.Client().PutBucketVersioning(context.Background(), &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(bucket),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := t.TempDir()
+	dst := server.Destination(bucket, "versioned")
+	for _, content := range []string{"one", "two", "three"} {
+		writeTestFile(t, src, "a.txt", []byte(content))
+		if _, err := sync.Sync(context.Background(), sync.Options{Src: src, Dst: dst, Compare: sync.ChecksumComparer{}, Checksums: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := sync.ListVersions(context.Background(), dst, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].Latest || versions[2].Size != 3 {
+		t.Fatalf("versions %+v, want 3, newest first", versions)
+	}
+	if err := sync.RestoreVersion(context.Background(), dst, "a.txt", versions[2].VersionID); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := dst.Get(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "one" {
+		t.Errorf("restored version holds %q, want %q", got, "one")
+	}
+
+	purged, err := sync.PurgeVersions(context.Background(), dst, sync.PurgeVersionsOptions{Prefix: "a.txt", Keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 2 {
+		t.Errorf("purged %d versions, want all but the current one and the newest noncurrent one", len(purged))
+	}
+	versions, err = sync.ListVersions(context.Background(), dst, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := sync.CountVersions(versions); len(counts) != 1 || counts[0].Versions != 2 {
+		t.Errorf("counts %+v, want 2 versions of a.txt left", counts)
+	}
+}
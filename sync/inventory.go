@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inventory is an S3 Inventory report: a listing of a bucket that S3
+// writes daily or weekly, which can be read in a few requests however many
+// objects the bucket holds.
+type Inventory struct {
+	Bucket  string                  // the bucket it lists
+	Created time.Time               // when S3 began writing it
+	Objects map[string]ListedObject // by full key within Bucket
+}
+
+// inventoryManifest is the manifest.json S3 writes with each report.
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	CreationTimestamp string `json:"creationTimestamp"` // Unix milliseconds
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// ReadInventory reads the S3 Inventory report whose manifest.json is at
+// manifestKey in from, the destination the report was delivered to. It
+// must implement Getter. Only CSV reports are supported, and they must
+// include the Size and LastModifiedDate fields. In reports of a versioned
+// bucket, only current versions are kept.
+func ReadInventory(ctx context.Context, from Destination, manifestKey string) (*Inventory, error) {
+	rc, err := get(ctx, from, manifestKey)
+	if err != nil {
+		return nil, err
+	}
+	var m inventoryManifest
+	err = json.NewDecoder(rc).Decode(&m)
+	rc.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestKey, err)
+	}
+	if m.FileFormat != "CSV" {
+		return nil, fmt.Errorf("%s: %s inventory reports are not supported, only CSV", manifestKey, m.FileFormat)
+	}
+	columns := make(map[string]int)
+	for i, name := range strings.Split(m.FileSchema, ",") {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"Key", "Size", "LastModifiedDate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s: the report has no %s field", manifestKey, name)
+		}
+	}
+
+	inv := &Inventory{Bucket: m.SourceBucket, Objects: make(map[string]ListedObject)}
+	if ms, err := strconv.ParseInt(m.CreationTimestamp, 10, 64); err == nil {
+		inv.Created = time.UnixMilli(ms)
+	}
+	// Data files are listed by their key in the bucket.
+	var prefix string
+	if p, ok := from.(Prefixed); ok {
+		prefix = p.Prefix()
+	}
+	for _, f := range m.Files {
+		if err := readInventoryFile(ctx, from, splitKey(prefix, f.Key), columns, inv); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// readInventoryFile adds the objects listed in the gzipped CSV file at key
+// to inv.
+func readInventoryFile(ctx context.Context, from Destination, key string, columns map[string]int, inv *Inventory) error {
+	rc, err := get(ctx, from, key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	r := csv.NewReader(zr)
+	r.FieldsPerRecord = len(columns)
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if field(record, "IsLatest") == "false" || field(record, "IsDeleteMarker") == "true" {
+			continue
+		}
+		name, err := url.QueryUnescape(field(record, "Key"))
+		if err != nil {
+			return fmt.Errorf("%s: key %q: %w", key, field(record, "Key"), err)
+		}
+		size, err := strconv.ParseInt(field(record, "Size"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: size of %s: %w", key, name, err)
+		}
+		written, err := time.Parse(time.RFC3339, field(record, "LastModifiedDate"))
+		if err != nil {
+			return fmt.Errorf("%s: last modified date of %s: %w", key, name, err)
+		}
+		inv.Objects[name] = ListedObject{Size: size, Written: written}
+	}
+}
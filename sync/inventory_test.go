@@ -0,0 +1,77 @@
+package sync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeInventory(t *testing.T, dir, manifest, data string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{
+		"inv/manifest.json": []byte(manifest),
+		"inv/data/1.csv.gz": buf.Bytes(),
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadInventory(t *testing.T) {
+	dir := t.TempDir()
+	writeInventory(t, dir, `{
+		"sourceBucket": "photos-bucket",
+		"fileFormat": "CSV",
+		"fileSchema": "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate",
+		"creationTimestamp": "1709251200000",
+		"files": [{"key": "inv/data/1.csv.gz"}]
+	}`, strings.Join([]string{
+		`"photos-bucket","photos/a+b%2Bc.jpg","v2","true","false","12","2024-03-01T10:00:00.000Z"`,
+		`"photos-bucket","photos/a+b%2Bc.jpg","v1","false","false","10","2024-02-01T10:00:00.000Z"`,
+		`"photos-bucket","photos/gone.jpg","v3","true","true","","2024-03-01T11:00:00.000Z"`,
+	}, "\n"))
+
+	inv, err := ReadInventory(context.Background(), NewLocalDestination(dir), "inv/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Bucket != "photos-bucket" || !inv.Created.Equal(time.UnixMilli(1709251200000)) {
+		t.Errorf("bucket %q, created %v", inv.Bucket, inv.Created)
+	}
+	want := ListedObject{Size: 12, Written: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	if len(inv.Objects) != 1 || !inv.Objects["photos/a b+c.jpg"].Written.Equal(want.Written) || inv.Objects["photos/a b+c.jpg"].Size != want.Size {
+		t.Errorf("objects = %v, want only the current version of photos/a b+c.jpg", inv.Objects)
+	}
+}
+
+func TestReadInventory_unsupported(t *testing.T) {
+	for _, tc := range []struct{ name, manifest, want string }{
+		{"parquet", `{"fileFormat": "Parquet", "fileSchema": "message s3.inventory {}"}`, "only CSV"},
+		{"no size", `{"fileFormat": "CSV", "fileSchema": "Bucket, Key, LastModifiedDate"}`, "no Size field"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeInventory(t, dir, tc.manifest, "")
+			_, err := ReadInventory(context.Background(), NewLocalDestination(dir), "inv/manifest.json")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("ReadInventory = %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+}
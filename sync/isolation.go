@@ -0,0 +1,155 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrPrefixOverlap is returned by runs that may delete objects when their
+// destination overlaps another job's. See Options.Isolate.
+var ErrPrefixOverlap = errors.New("destination overlaps another job's")
+
+// JobMarkerKey is the key of the JobMarker kept at a destination by runs
+// with Options.Isolate set.
+const JobMarkerKey = metaPrefix + "job.json"
+
+// JobMarker names the job syncing to a destination.
+type JobMarker struct {
+	Host    string    `json:"host"`
+	Src     string    `json:"src"` // absolute path of the source directory, or a comma-separated list
+	Created time.Time `json:"created"`
+}
+
+func (m JobMarker) String() string {
+	return m.Host + ":" + m.Src
+}
+
+// localJob returns the marker of a job syncing dirs from this machine.
+func localJob(dirs []string) (JobMarker, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return JobMarker{}, err
+	}
+	abs := make([]string, len(dirs))
+	for i, dir := range dirs {
+		if abs[i], err = filepath.Abs(dir); err != nil {
+			return JobMarker{}, err
+		}
+	}
+	return JobMarker{Host: host, Src: strings.Join(abs, ","), Created: time.Now()}, nil
+}
+
+// readJobMarker reads the marker stored at key in dst, returning nil if
+// there is none.
+func readJobMarker(ctx context.Context, dst Destination, key string) (*JobMarker, error) {
+	rc, err := get(ctx, dst, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read job marker: %w", err)
+	}
+	defer rc.Close()
+	var m JobMarker
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, fmt.Errorf("read job marker %s: %w", key, err)
+	}
+	return &m, nil
+}
+
+// checkIsolation looks for the markers of other jobs at opts.Dst, at the
+// prefixes enclosing base if it is Prefixed and, if the run is
+// destructive, under opts.Dst. base is opts.Dst before prepare wrapped it.
+// Overlaps fail the run with ErrPrefixOverlap if it is destructive and are
+// warned about otherwise. Unless the run is a dry run or read-only, this
+// job's marker is then written if there is none.
+func checkIsolation(ctx context.Context, opts Options, base Destination, destructive bool) error {
+	self, err := localJob(sourceDirs(opts))
+	if err != nil {
+		return err
+	}
+
+	var overlaps []string
+	own, err := readJobMarker(ctx, opts.Dst, JobMarkerKey)
+	if err != nil {
+		return err
+	}
+	if own != nil && (own.Host != self.Host || own.Src != self.Src) {
+		overlaps = append(overlaps, fmt.Sprintf("%s syncs to the same destination", own))
+	}
+	if p, ok := base.(Prefixed); ok {
+		for _, prefix := range enclosingPrefixes(p.Prefix()) {
+			m, err := readJobMarker(ctx, p.WithPrefix(prefix), JobMarkerKey)
+			if err != nil {
+				return err
+			}
+			if m != nil {
+				overlaps = append(overlaps, fmt.Sprintf("%s syncs to the enclosing prefix %q", m, prefix))
+			}
+		}
+	}
+	if destructive {
+		err := opts.Dst.List(ctx, func(keys []string) error {
+			for _, key := range keys {
+				dir, ok := strings.CutSuffix(key, "/"+JobMarkerKey)
+				if !ok {
+					continue
+				}
+				m, err := readJobMarker(ctx, opts.Dst, key)
+				if err != nil {
+					return err
+				}
+				if m != nil {
+					overlaps = append(overlaps, fmt.Sprintf("%s syncs to %s/ inside this destination", m, dir))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(overlaps) > 0 {
+		if destructive {
+			return fmt.Errorf("%w: %s; refusing to run with deletes", ErrPrefixOverlap, strings.Join(overlaps, "; "))
+		}
+		for _, o := range overlaps {
+			opts.warnf("%s", o)
+		}
+	}
+	if own != nil || opts.DryRun || opts.ReadOnly {
+		return nil
+	}
+	data, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	meta := ObjectMeta{Size: int64(len(data)), ModTime: self.Created, ContentType: "application/json"}
+	if err := opts.Dst.Put(ctx, JobMarkerKey, bytes.NewReader(data), meta); err != nil {
+		return fmt.Errorf("write job marker: %w", err)
+	}
+	return nil
+}
+
+// enclosingPrefixes returns the prefixes enclosing prefix, nearest first,
+// ending with the bucket root "".
+func enclosingPrefixes(prefix string) []string {
+	var out []string
+	for p := prefix; p != ""; {
+		if p = path.Dir(p); p == "." {
+			p = ""
+		}
+		out = append(out, p)
+	}
+	return out
+}
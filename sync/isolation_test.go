@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// bucketDest is a view of a shared mockDest, a bucket, under a prefix.
+type bucketDest struct {
+	bucket *mockDest
+	prefix string
+}
+
+func (d bucketDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	return d.bucket.Put(ctx, joinKey(d.prefix, key), r, meta)
+}
+
+func (d bucketDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	return d.bucket.Stat(ctx, joinKey(d.prefix, key))
+}
+
+func (d bucketDest) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return d.bucket.Get(ctx, joinKey(d.prefix, key))
+}
+
+func (d bucketDest) List(ctx context.Context, fn func(keys []string) error) error {
+	all, _ := listKeys(ctx, d.bucket)
+	var keys []string
+	for _, k := range all {
+		if strings.HasPrefix(k, listPrefix(d.prefix)) {
+			keys = append(keys, splitKey(d.prefix, k))
+		}
+	}
+	return fn(keys)
+}
+
+func (d bucketDest) Delete(ctx context.Context, key string) error {
+	return d.bucket.Delete(ctx, joinKey(d.prefix, key))
+}
+
+func (d bucketDest) Prefix() string { return d.prefix }
+
+func (d bucketDest) WithPrefix(prefix string) Destination { return bucketDest{d.bucket, prefix} }
+
+func TestSync_isolate(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, then string // prefixes of the two jobs, in the order they run
+	}{
+		{"same prefix", "backups", "backups"},
+		{"enclosing prefix", "backups", "backups/photos"},
+		{"nested prefix", "backups/photos", "backups"},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		bucket := newMockDest()
+		first := Options{Src: t.TempDir(), Dst: bucketDest{bucket, tt.first}, Isolate: true}
+		writeFile(t, first.Src, "a.txt", "a")
+		if _, err := Sync(ctx, first); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, ok := bucket.objects[joinKey(tt.first, JobMarkerKey)]; !ok {
+			t.Fatalf("%s: no marker written", tt.name)
+		}
+
+		then := Options{Src: t.TempDir(), Dst: bucketDest{bucket, tt.then}, Isolate: true}
+		if _, err := Sync(ctx, then); err != nil {
+			t.Errorf("%s: run without deletes failed: %v", tt.name, err)
+		}
+		then.Delete = true
+		if _, err := Sync(ctx, then); !errors.Is(err, ErrPrefixOverlap) {
+			t.Errorf("%s: run with deletes = %v, want ErrPrefixOverlap", tt.name, err)
+		}
+		if len(bucket.deleteCalls) != 0 {
+			t.Errorf("%s: deleted %v", tt.name, bucket.deleteCalls)
+		}
+	}
+}
+
+func TestSync_isolateSameJob(t *testing.T) {
+	bucket := newMockDest()
+	opts := Options{Src: t.TempDir(), Dst: bucketDest{bucket, "backups"}, Isolate: true, Delete: true}
+	for range 2 {
+		if _, err := Sync(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Separate prefixes do not overlap, however alike their names.
+	other := Options{Src: t.TempDir(), Dst: bucketDest{bucket, "backups2"}, Isolate: true, Delete: true}
+	if _, err := Sync(context.Background(), other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnclosingPrefixes(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", nil},
+		{"a", []string{""}},
+		{"a/b/c", []string{"a/b", "a", ""}},
+	}
+	for _, tt := range tests {
+		if got := enclosingPrefixes(tt.prefix); !slices.Equal(got, tt.want) {
+			t.Errorf("enclosingPrefixes(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	stdsync "sync"
+	"time"
+)
+
+// journal records the progress of a run in a local file, so that a run
+// that is killed or crashes leaves a record of what it had and had not
+// done. See Options.Journal.
+//
+// The file holds one JSON line for the start of the run, one for each
+// planned operation and one for each completed operation. Each line is
+// written with a single append under a lock, so lines from concurrent
+// workers never interleave, and ReadJournal ignores a last line cut short
+// by a crash.
+type journal struct {
+	mu   stdsync.Mutex
+	f    *os.File
+	path string
+}
+
+type journalLine struct {
+	Started time.Time `json:"started,omitzero"`
+	Src     string    `json:"src,omitempty"`
+
+	Op   string `json:"op,omitempty"` // "upload" or "delete"
+	Key  string `json:"key,omitempty"`
+	Done bool   `json:"done,omitempty"`
+}
+
+// Journal is the progress of a run, as read back by ReadJournal.
+type Journal struct {
+	Started time.Time
+	Src     string
+	Entries []JournalEntry // every planned operation, in order
+
+	// Truncated reports that the last line of the journal was cut short,
+	// because the run stopped in the middle of writing it.
+	Truncated bool
+}
+
+// JournalEntry is an operation planned by a run.
+type JournalEntry struct {
+	Op   string // "upload" or "delete"
+	Key  string
+	Done bool // completed before the run stopped
+}
+
+// Pending returns the entries that were not completed.
+func (j *Journal) Pending() []JournalEntry {
+	var pending []JournalEntry
+	for _, e := range j.Entries {
+		if !e.Done {
+			pending = append(pending, e)
+		}
+	}
+	return pending
+}
+
+// openJournal starts a journal at path for applying plan. A journal left
+// there by an earlier run means that run was interrupted; it is reported
+// to warnings and replaced.
+func openJournal(path, src string, plan *Plan, warnings io.Writer) (*journal, error) {
+	if old, err := ReadJournal(path); err == nil {
+		warnf(warnings, "the run started %s did not finish: %d of %d operations done",
+			old.Started.Format(time.RFC3339), len(old.Entries)-len(old.Pending()), len(old.Entries))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		warnf(warnings, "journal: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j := &journal{f: f, path: path}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(journalLine{Started: time.Now(), Src: src})
+	for _, u := range plan.Uploads {
+		enc.Encode(journalLine{Op: "upload", Key: u.Key})
+	}
+	for _, key := range plan.Deletes {
+		enc.Encode(journalLine{Op: "delete", Key: key})
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// The plan must be on disk before any of it is carried out.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// done records that op on key has completed. It is safe for concurrent use.
+func (j *journal) done(op, key string) error {
+	if j == nil {
+		return nil
+	}
+	line, err := json.Marshal(journalLine{Op: op, Key: key, Done: true})
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+	return nil
+}
+
+// finish removes the journal of a run that completed.
+func (j *journal) finish() error {
+	if j == nil {
+		return nil
+	}
+	j.f.Close()
+	return os.Remove(j.path)
+}
+
+// close closes the journal of a run that failed, leaving it in place.
+func (j *journal) close() {
+	if j != nil {
+		j.f.Sync()
+		j.f.Close()
+	}
+}
+
+// ReadJournal reads the journal at path, left by a run that did not
+// finish. If there is none, the error wraps os.ErrNotExist.
+func ReadJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{}
+	if i := bytes.LastIndexByte(data, '\n'); i+1 < len(data) {
+		data = data[:i+1] // drop the last line, cut short
+		j.Truncated = true
+	}
+
+	index := make(map[string]int) // op and key -> index in j.Entries
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		var line journalLine
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		switch {
+		case n == 1:
+			j.Started, j.Src = line.Started, line.Src
+		case line.Done:
+			if i, ok := index[line.Op+"\x00"+line.Key]; ok {
+				j.Entries[i].Done = true
+			}
+		default:
+			index[line.Op+"\x00"+line.Key] = len(j.Entries)
+			j.Entries = append(j.Entries, JournalEntry{Op: line.Op, Key: line.Key})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return j, nil
+}
@@ -0,0 +1,122 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	stdsync "sync"
+	"testing"
+)
+
+func TestJournal_concurrentDone(t *testing.T) {
+	plan := &Plan{}
+	for i := range 200 {
+		plan.Uploads = append(plan.Uploads, File{Key: fmt.Sprintf("f%03d", i)})
+	}
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j, err := openJournal(path, "/src", plan, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg stdsync.WaitGroup
+	for w := range 8 {
+		wg.Go(func() {
+			for i := w; i < len(plan.Uploads); i += 8 {
+				if err := j.done("upload", plan.Uploads[i].Key); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	j.close() // crash before finishing
+
+	got, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 200 || len(got.Pending()) != 0 || got.Truncated {
+		t.Errorf("got %d entries, %d pending, truncated %v; want 200, 0, false",
+			len(got.Entries), len(got.Pending()), got.Truncated)
+	}
+}
+
+func TestReadJournal_truncatedLine(t *testing.T) {
+	plan := &Plan{
+		Uploads: []File{{Key: "a"}, {Key: "b"}},
+		Deletes: []string{"c"},
+	}
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j, err := openJournal(path, "/src", plan, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.done("upload", "a")
+	j.close()
+
+	// The process died while writing the next line.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"upload","key":"b","do`)
+	f.Close()
+
+	got, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Truncated || got.Src != "/src" {
+		t.Errorf("truncated %v, src %q; want true, /src", got.Truncated, got.Src)
+	}
+	want := []JournalEntry{{Op: "upload", Key: "b"}, {Op: "delete", Key: "c"}}
+	if pending := got.Pending(); fmt.Sprint(pending) != fmt.Sprint(want) {
+		t.Errorf("pending = %v, want %v", pending, want)
+	}
+}
+
+// failingPut fails to upload one key.
+type failingPut struct {
+	*mockDest
+	key string
+}
+
+func (f failingPut) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if key == f.key {
+		return errors.New("connection reset")
+	}
+	return f.mockDest.Put(ctx, key, r, meta)
+}
+
+func TestSync_journalLeftByFailedRun(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: failingPut{dst, "b.txt"}, Journal: path}
+	if _, err := Sync(context.Background(), opts); err == nil {
+		t.Fatal("expected the upload of b.txt to fail")
+	}
+	got, err := ReadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []JournalEntry{{Op: "upload", Key: "a.txt", Done: true}, {Op: "upload", Key: "b.txt"}}
+	if fmt.Sprint(got.Entries) != fmt.Sprint(want) {
+		t.Errorf("entries = %v, want %v", got.Entries, want)
+	}
+
+	opts.Dst = dst
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadJournal(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("journal not removed after a successful run: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// KeyMapper maps the path of a source file, relative to the source
+// directory and slash-separated, to the key it is stored under at the
+// destination, and back. See Options.Keys.
+type KeyMapper interface {
+	// Key returns the key to store the file at path, last modified at
+	// modTime, under.
+	Key(path string, modTime time.Time) string
+	// Path returns the path of the file stored under key, or false if key
+	// is not one Key returns.
+	Path(key string) (string, bool)
+}
+
+// IdentityKeys stores each file under its path.
+type IdentityKeys struct{}
+
+func (IdentityKeys) Key(path string, _ time.Time) string { return path }
+
+func (IdentityKeys) Path(key string) (string, bool) { return key, true }
+
+// SanitizedKeys percent-encodes the bytes of each path that are unsafe in
+// object keys: control characters, non-ASCII bytes and the characters S3
+// recommends avoiding, such as \, {, ^ and #. Slashes are kept, so the
+// directory structure is too.
+type SanitizedKeys struct {
+	// Portable also encodes what Windows does not allow in file names, so
+	// that every key is one: the characters :, * and ?, spaces and dots
+	// ending a name, and the first letter of device names such as CON,
+	// NUL and COM1, with or without an extension.
+	Portable bool
+}
+
+func (k SanitizedKeys) Key(path string, _ time.Time) string {
+	unsafe := func(c byte) bool {
+		return c < 0x20 || c >= 0x7f || strings.IndexByte(`\{}^%[]~<>#|"`+"`", c) >= 0 ||
+			k.Portable && strings.IndexByte(":*?", c) >= 0
+	}
+	return escapePath(path, unsafe, k.Portable)
+}
+
+func (k SanitizedKeys) Path(key string) (string, bool) {
+	path, err := url.PathUnescape(key)
+	if err != nil || k.Key(path, time.Time{}) != key {
+		return "", false
+	}
+	return path, true
+}
+
+// escapePath percent-encodes the bytes of path that are unsafe, and with
+// windows also those that make a name invalid on Windows.
+func escapePath(path string, unsafe func(byte) bool, windows bool) string {
+	var b strings.Builder
+	for i, name := range strings.Split(path, "/") {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		trailing := len(name)
+		if windows {
+			trailing = len(strings.TrimRight(name, " ."))
+		}
+		for j := 0; j < len(name); j++ {
+			c := name[j]
+			if unsafe(c) || j >= trailing || j == 0 && windows && reservedName(name) {
+				fmt.Fprintf(&b, "%%%02X", c)
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// reservedName reports whether name is one Windows reserves for a device,
+// such as CON or lpt1.txt.
+func reservedName(name string) bool {
+	base, _, _ := strings.Cut(name, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) &&
+		base[3] >= '0' && base[3] <= '9'
+}
+
+// windowsName returns path with the bytes Windows does not allow in file
+// names percent-encoded, as SanitizedKeys{Portable: true} does, leaving
+// other bytes, and percent signs, as they are.
+func windowsName(path string) string {
+	unsafe := func(c byte) bool { return c < 0x20 || strings.IndexByte(`<>:"\|?*`, c) >= 0 }
+	return escapePath(path, unsafe, true)
+}
+
+// HashedKeys stores each file under a directory named for the first two
+// hex digits of the SHA-256 of its path, such as "3f/photos/a.jpg". This
+// spreads keys evenly over 256 prefixes, each of which S3 can serve at its
+// full request rate.
+type HashedKeys struct{}
+
+func (HashedKeys) Key(path string, _ time.Time) string {
+	return pathHash(path) + "/" + path
+}
+
+func (HashedKeys) Path(key string) (string, bool) {
+	hash, path, ok := strings.Cut(key, "/")
+	if !ok || hash != pathHash(path) {
+		return "", false
+	}
+	return path, true
+}
+
+func pathHash(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:1])
+}
+
+// DateKeys stores each file under a directory for the UTC date it was last
+// modified, such as "2024/03/01/photos/a.jpg", so that lifecycle rules and
+// listings can select files by date. A modified file moves to the key for
+// its new date; with Options.Delete, the old key is deleted.
+type DateKeys struct{}
+
+const dateKeyLayout = "2006/01/02"
+
+func (DateKeys) Key(path string, modTime time.Time) string {
+	return modTime.UTC().Format(dateKeyLayout) + "/" + path
+}
+
+func (DateKeys) Path(key string) (string, bool) {
+	if len(key) <= len(dateKeyLayout)+1 || key[len(dateKeyLayout)] != '/' {
+		return "", false
+	}
+	if _, err := time.Parse(dateKeyLayout, key[:len(dateKeyLayout)]); err != nil {
+		return "", false
+	}
+	return key[len(dateKeyLayout)+1:], true
+}
+
+// EncryptedKeys stores each file under its path with every name in it
+// encrypted, so that keys reveal how deep files are and which share a
+// directory, but not what they are called. The same path always gets the
+// same key, so unchanged files are not uploaded again, and the key decrypts
+// back to the path, so restoring needs nothing but the name key.
+//
+// Each name is encrypted with AES-256 in CTR mode, with the first 16 bytes
+// of its HMAC-SHA256 as the IV, and stored with the IV in lower-case base32
+// (RFC 4648 "extended hex"); Path rejects keys whose names do not
+// authenticate. A name of n bytes becomes one of (16+n)*8/5 characters,
+// rounded up; Sync fails with ErrKeyTooLong at a file whose key is then too
+// long to store.
+type EncryptedKeys struct {
+	block  cipher.Block
+	macKey []byte
+}
+
+// NewEncryptedKeys creates an EncryptedKeys whose keys are derived from
+// secret, such as the content of a file LoadNameKey reads.
+func NewEncryptedKeys(secret []byte) (*EncryptedKeys, error) {
+	keys, err := hkdf.Key(sha256.New, secret, nil, "foldersync name encryption", 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedKeys{block: block, macKey: keys[32:]}, nil
+}
+
+// ErrKeyTooLong is returned by Sync when EncryptedKeys lengthen the key of
+// a file past what the destination can store: a name in it past the 255
+// bytes local file systems allow, or the key past the 1024 bytes S3 allows.
+var ErrKeyTooLong = errors.New("encrypted key too long")
+
+const (
+	maxNameBytes = 255  // in a file name, on most local file systems
+	maxKeyBytes  = 1024 // in an S3 object key
+)
+
+// checkKeyLength returns an error wrapping ErrKeyTooLong, naming file, if
+// opts encrypts its key past the limits of opts.Dst. Other layouts keep
+// names as long as the source's, so their keys are not checked.
+func checkKeyLength(opts Options, file File) error {
+	if !encryptsKeys(opts.Keys) {
+		return nil
+	}
+	if _, ok := opts.Dst.(*LocalDestination); ok {
+		for _, name := range strings.Split(file.Key, "/") {
+			if len(name) > maxNameBytes {
+				return fmt.Errorf("%s: %w: a name in it encrypts to %d bytes, more than the %d a file name may have",
+					file.Path, ErrKeyTooLong, len(name), maxNameBytes)
+			}
+		}
+	}
+	if len(file.Key) > maxKeyBytes {
+		return fmt.Errorf("%s: %w: its key is %d bytes, more than the %d an S3 key may have",
+			file.Path, ErrKeyTooLong, len(file.Key), maxKeyBytes)
+	}
+	return nil
+}
+
+// encryptsKeys reports whether keys encrypts names, itself or as the
+// Layout of ShapedKeys.
+func encryptsKeys(keys KeyMapper) bool {
+	switch k := keys.(type) {
+	case *EncryptedKeys:
+		return true
+	case ShapedKeys:
+		return encryptsKeys(k.Layout)
+	case *ShapedKeys:
+		return k != nil && encryptsKeys(k.Layout)
+	}
+	return false
+}
+
+// nameEncoding encodes encrypted names in characters that are safe in
+// object keys and file names, and distinct on case-insensitive file
+// systems.
+var nameEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+func (k *EncryptedKeys) Key(path string, _ time.Time) string {
+	names := strings.Split(path, "/")
+	for i, name := range names {
+		iv := k.nameMAC(name)
+		data := make([]byte, len(iv)+len(name))
+		copy(data, iv)
+		cipher.NewCTR(k.block, iv).XORKeyStream(data[len(iv):], []byte(name))
+		names[i] = nameEncoding.EncodeToString(data)
+	}
+	return strings.Join(names, "/")
+}
+
+func (k *EncryptedKeys) Path(key string) (string, bool) {
+	names := strings.Split(key, "/")
+	for i, enc := range names {
+		data, err := nameEncoding.DecodeString(enc)
+		if err != nil || len(data) < aes.BlockSize || nameEncoding.EncodeToString(data) != enc {
+			return "", false
+		}
+		iv, name := data[:aes.BlockSize], data[aes.BlockSize:]
+		cipher.NewCTR(k.block, iv).XORKeyStream(name, name)
+		if !hmac.Equal(iv, k.nameMAC(string(name))) {
+			return "", false
+		}
+		names[i] = string(name)
+	}
+	return strings.Join(names, "/"), true
+}
+
+// nameMAC returns the IV name is encrypted with.
+func (k *EncryptedKeys) nameMAC(name string) []byte {
+	mac := hmac.New(sha256.New, k.macKey)
+	mac.Write([]byte(name))
+	return mac.Sum(nil)[:aes.BlockSize]
+}
+
+// LoadNameKey reads the secret of an EncryptedKeys from the file at path,
+// without a trailing newline. It must be at least 16 bytes long; random
+// bytes, such as those "openssl rand -base64 32" prints, are best.
+func LoadNameKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\r\n")
+	if len(data) < 16 {
+		return nil, fmt.Errorf("%s: name key must be at least 16 bytes", path)
+	}
+	return data, nil
+}
+
+// ParseKeyMapper returns the built-in KeyMapper named s: identity,
+// sanitized, portable (SanitizedKeys{Portable: true}), hashed or date.
+// The encrypted layout needs a key, and is made by NewEncryptedKeys.
+func ParseKeyMapper(s string) (KeyMapper, error) {
+	switch s {
+	case "identity":
+		return IdentityKeys{}, nil
+	case "sanitized":
+		return SanitizedKeys{}, nil
+	case "portable":
+		return SanitizedKeys{Portable: true}, nil
+	case "hashed":
+		return HashedKeys{}, nil
+	case "date":
+		return DateKeys{}, nil
+	case "encrypted":
+		return nil, errors.New("the encrypted key layout needs a name key")
+	}
+	return nil, fmt.Errorf("unknown key layout %q (want identity, sanitized, portable, hashed or date)", s)
+}
+
+// keyMapper returns m, or IdentityKeys if m is nil.
+func keyMapper(m KeyMapper) KeyMapper {
+	if m == nil {
+		return IdentityKeys{}
+	}
+	return m
+}
+
+// mapsKeys reports whether m stores files under anything but their paths.
+func mapsKeys(m KeyMapper) bool {
+	_, identity := keyMapper(m).(IdentityKeys)
+	return !identity
+}
+
+// UnicodeForm is the Unicode normalization form file paths are put in
+// before they are mapped to keys, so that a name stored decomposed, as
+// macOS has stored names, and the same name stored composed, as Linux and
+// Windows store them, are one key. See Options.UnicodeForm.
+type UnicodeForm string
+
+const (
+	NFC         UnicodeForm = "nfc"  // composed: the default
+	NFD         UnicodeForm = "nfd"  // decomposed
+	UnicodeAsIs UnicodeForm = "none" // not normalized: keys keep the bytes of names
+)
+
+// ParseUnicodeForm parses a normalization form given on the command
+// line: nfc, nfd or none.
+func ParseUnicodeForm(s string) (UnicodeForm, error) {
+	switch f := UnicodeForm(strings.ToLower(s)); f {
+	case NFC, NFD, UnicodeAsIs:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown Unicode normalization %q (want nfc, nfd or none)", s)
+}
+
+// normalize returns rel in form f. The empty form is NFC.
+func (f UnicodeForm) normalize(rel string) string {
+	switch f {
+	case UnicodeAsIs:
+		return rel
+	case NFD:
+		return norm.NFD.String(rel)
+	}
+	return norm.NFC.String(rel)
+}
+
+// fileKey returns the key opts stores the file at rel, relative to its
+// source, last modified at modTime, under.
+func fileKey(opts Options, rel string, modTime time.Time) string {
+	return keyMapper(opts.Keys).Key(opts.UnicodeForm.normalize(rel), modTime)
+}
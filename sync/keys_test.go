@@ -0,0 +1,269 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyMappers(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 23, 30, 0, 0, time.FixedZone("", -5*3600))
+	tests := []struct {
+		mapper KeyMapper
+		path   string
+		key    string
+	}{
+		{IdentityKeys{}, "photos/a b.jpg", "photos/a b.jpg"},
+		{SanitizedKeys{}, "notes/50% {draft}#1.txt", "notes/50%25 %7Bdraft%7D%231.txt"},
+		{SanitizedKeys{}, "café.txt", "caf%C3%A9.txt"},
+		{SanitizedKeys{}, "CON/a: b .txt ", "CON/a: b .txt "},
+		{SanitizedKeys{Portable: true}, "CON/a: b .txt ", "%43ON/a%3A b .txt%20"},
+		{SanitizedKeys{Portable: true}, "notes./nul.tar.gz/com1/console/why?.txt..", "notes%2E/%6Eul.tar.gz/%63om1/console/why%3F.txt%2E%2E"},
+		{HashedKeys{}, "photos/a.jpg", pathHash("photos/a.jpg") + "/photos/a.jpg"},
+		{DateKeys{}, "photos/a.jpg", "2024/03/02/photos/a.jpg"}, // by UTC date
+	}
+	for _, tt := range tests {
+		key := tt.mapper.Key(tt.path, mtime)
+		if key != tt.key {
+			t.Errorf("%T.Key(%q) = %q, want %q", tt.mapper, tt.path, key, tt.key)
+		}
+		if path, ok := tt.mapper.Path(key); !ok || path != tt.path {
+			t.Errorf("%T.Path(%q) = %q, %v, want %q", tt.mapper, key, path, ok, tt.path)
+		}
+	}
+
+	// Keys the layouts do not produce.
+	foreign := []struct {
+		mapper KeyMapper
+		key    string
+	}{
+		{SanitizedKeys{}, "a#b.txt"},
+		{SanitizedKeys{}, "50%.txt"},
+		{SanitizedKeys{Portable: true}, "CON/a.txt"},
+		{SanitizedKeys{Portable: true}, "a:b"},
+		{HashedKeys{}, "zz/photos/a.jpg"},
+		{HashedKeys{}, "a.jpg"},
+		{DateKeys{}, "photos/a.jpg"},
+		{DateKeys{}, "2024/13/01/a.jpg"},
+	}
+	for _, tt := range foreign {
+		if path, ok := tt.mapper.Path(tt.key); ok {
+			t.Errorf("%T.Path(%q) = %q, want no path", tt.mapper, tt.key, path)
+		}
+	}
+}
+
+func TestWindowsName(t *testing.T) {
+	for path, want := range map[string]string{
+		"photos/café 100%.jpg": "photos/café 100%.jpg",
+		"aux.c/a<b>:c.txt":     "%61ux.c/a%3Cb%3E%3Ac.txt",
+		"notes /draft.":        "notes%20/draft%2E",
+	} {
+		if got := windowsName(path); got != want {
+			t.Errorf("windowsName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestSync_dateKeys(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "docs/a.txt", "one")
+	day1 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(src, "docs/a.txt"), day1, day1)
+	dst := newMockDest()
+	dst.objects["stray.txt"] = &ObjectMeta{}
+	opts := Options{Src: src, Dst: dst, Keys: DateKeys{}, Delete: true}
+	ctx := context.Background()
+
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	// stray.txt fits no date, so it is no file's: kept, as the objects of
+	// excluded paths are.
+	keys, _ := listKeys(ctx, dst)
+	if !slices.Equal(keys, []string{"2024/03/01/docs/a.txt", "stray.txt"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	// A modified file moves to its new date.
+	writeFile(t, src, "docs/a.txt", "two")
+	day2 := day1.AddDate(0, 0, 1)
+	os.Chtimes(filepath.Join(src, "docs/a.txt"), day2, day2)
+	opts.DeleteExcluded = true
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = listKeys(ctx, dst)
+	if !slices.Equal(keys, []string{"2024/03/02/docs/a.txt"}) {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	out := t.TempDir()
+	if err := Restore(ctx, RestoreOptions{From: dst, To: out, Keys: DateKeys{}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLocal(t, out, "docs/a.txt"); got != "two" {
+		t.Errorf("restored docs/a.txt = %q", got)
+	}
+}
+
+func TestWatch_rejectsKeyLayout(t *testing.T) {
+	opts := Options{Src: t.TempDir(), Dst: newMockDest(), Keys: HashedKeys{}}
+	if err := Watch(context.Background(), opts, time.Millisecond); err == nil {
+		t.Error("Watch accepted a key layout")
+	}
+}
+
+func TestEncryptedKeys(t *testing.T) {
+	keys, err := NewEncryptedKeys([]byte("correct horse battery staple"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "photos/2024/Café 100%.jpg"
+	key := keys.Key(path, time.Time{})
+	if key != keys.Key(path, time.Now()) {
+		t.Errorf("Key(%q) changed between calls", path)
+	}
+	if strings.Count(key, "/") != 2 || strings.ContainsAny(key, "CéÉ%. ") || strings.Contains(key, "photos") {
+		t.Errorf("Key(%q) = %q, want three encrypted names", path, key)
+	}
+	// Files in the same directory share its encrypted name.
+	if dir := key[:strings.LastIndex(key, "/")]; !strings.HasPrefix(keys.Key("photos/2024/b.jpg", time.Time{}), dir+"/") {
+		t.Errorf("photos/2024/b.jpg is not under %s", dir)
+	}
+	if got, ok := keys.Path(key); !ok || got != path {
+		t.Errorf("Path(%q) = %q, %v, want %q", key, got, ok, path)
+	}
+
+	other, err := NewEncryptedKeys([]byte("another secret of enough bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := []byte(key)
+	tampered[len(tampered)-1] ^= 1
+	for _, k := range []string{"photos/a.jpg", string(tampered), other.Key(path, time.Time{}), "0123"} {
+		if got, ok := keys.Path(k); ok {
+			t.Errorf("Path(%q) = %q, want no path", k, got)
+		}
+	}
+}
+
+func TestLoadNameKey(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "name.key", "0123456789abcdef\n")
+	writeFile(t, dir, "short.key", "hunter2\n")
+	if key, err := LoadNameKey(filepath.Join(dir, "name.key")); err != nil || string(key) != "0123456789abcdef" {
+		t.Errorf("LoadNameKey = %q, %v", key, err)
+	}
+	if _, err := LoadNameKey(filepath.Join(dir, "short.key")); err == nil {
+		t.Error("LoadNameKey accepted a 7-byte key")
+	}
+}
+
+func TestSync_encryptedKeys(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "docs/secret plans.txt", "one")
+	keys, err := NewEncryptedKeys([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := newMockDest()
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Keys: keys, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	listed, _ := listKeys(ctx, dst)
+	for _, key := range listed {
+		if strings.Contains(key, "secret") || strings.Contains(key, "docs") {
+			t.Errorf("key %q reveals the file's name", key)
+		}
+	}
+
+	out := t.TempDir()
+	if err := Restore(ctx, RestoreOptions{From: dst, To: out, Keys: keys}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLocal(t, out, "docs/secret plans.txt"); got != "one" {
+		t.Errorf("restored docs/secret plans.txt = %q", got)
+	}
+}
+
+func TestSync_encryptedKeyTooLong(t *testing.T) {
+	keys, err := NewEncryptedKeys([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A 150-byte name encrypts to 266 characters: too long for a file
+	// name, but not for an S3 key.
+	long := strings.Repeat("n", 150) + ".txt"
+	src := t.TempDir()
+	writeFile(t, src, long, "x")
+	_, err = Sync(ctx, Options{Src: src, Dst: NewLocalDestination(t.TempDir()), Keys: keys})
+	if !errors.Is(err, ErrKeyTooLong) || !strings.Contains(err.Error(), long) {
+		t.Errorf("local: err = %v, want ErrKeyTooLong naming the file", err)
+	}
+	if _, err := Sync(ctx, Options{Src: src, Dst: newMockDest(), Keys: keys}); err != nil {
+		t.Errorf("S3: %v", err)
+	}
+
+	// Six 100-byte names encrypt to 1121 characters, past S3's limit.
+	deep := strings.Repeat(strings.Repeat("d", 100)+"/", 5) + strings.Repeat("f", 100)
+	src = t.TempDir()
+	writeFile(t, src, deep, "x")
+	dst := newMockDest()
+	_, err = Sync(ctx, Options{Src: src, Dst: dst, Keys: keys})
+	if !errors.Is(err, ErrKeyTooLong) || len(dst.putCalls) != 0 {
+		t.Errorf("S3: err = %v, put %d; want ErrKeyTooLong and nothing put", err, len(dst.putCalls))
+	}
+}
+
+func TestSync_unicodeFormDelete(t *testing.T) {
+	src := t.TempDir()
+	// The walk reaches a\u0304 first, but its composed key sorts after
+	// \u00e9's, whose composed path is not on disk.
+	writeFile(t, src, "a\u0304.txt", "a")
+	writeFile(t, src, "e\u0301.txt", "e")
+	keys := syncTwiceKeepsAll(t, Options{Src: src})
+	if want := []string{"\u00e9.txt", "\u0101.txt"}; !slices.Equal(keys, want) {
+		t.Errorf("stored %q, want %q", keys, want)
+	}
+}
+
+func TestSync_unicodeForm(t *testing.T) {
+	const nfc, nfd = "caf\u00e9.txt", "cafe\u0301.txt"
+	src := t.TempDir()
+	writeFile(t, src, nfd, "x") // as macOS wrote it
+
+	// An earlier run stored it decomposed; it moves to the composed key.
+	dst := newMockDest()
+	dst.objects[nfd] = &ObjectMeta{Size: 1}
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := slices.Sorted(maps.Keys(dst.objects)); !slices.Equal(keys, []string{nfc}) {
+		t.Errorf("NFC: destination holds %q, want %q", keys, nfc)
+	}
+
+	dst = newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true, UnicodeForm: UnicodeAsIs}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := slices.Sorted(maps.Keys(dst.objects)); !slices.Equal(keys, []string{nfd}) {
+		t.Errorf("as is: destination holds %q, want %q", keys, nfd)
+	}
+
+	if f, err := ParseUnicodeForm("NFD"); err != nil || f != NFD {
+		t.Errorf("ParseUnicodeForm(NFD) = %q, %v", f, err)
+	}
+	if _, err := ParseUnicodeForm("nfkc"); err == nil {
+		t.Error("ParseUnicodeForm(nfkc) succeeded")
+	}
+}
@@ -0,0 +1,156 @@
+package sync
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrKeyCollision is returned by Sync when ShapedKeys reshape the paths of
+// two source files into the same key, where each would replace the other.
+var ErrKeyCollision = errors.New("files share a key")
+
+// ShapedKeys reshapes the path of each file before Layout maps it to a
+// key, to change how a tree lands in the bucket without moving its files:
+// it drops the first StripComponents directories of the path, as tar
+// --strip-components does, then rewrites it with Template, if set, and
+// then puts it in lower case with Lowercase. A file in fewer directories
+// than StripComponents keeps its name.
+//
+// Reshaping cannot be undone: Path returns the reshaped path, so restores
+// recreate the tree as the bucket lays it out, and need only Layout.
+// Sync fails with ErrKeyCollision if two files are reshaped into one key;
+// with Lowercase, CaseCollisionsRename keeps those differing only in case
+// apart.
+type ShapedKeys struct {
+	Layout          KeyMapper // maps the reshaped paths; nil for IdentityKeys
+	StripComponents int
+	Template        string // see CheckKeyTemplate
+	Lowercase       bool
+}
+
+func (k ShapedKeys) Key(p string, modTime time.Time) string {
+	names := strings.Split(p, "/")
+	p = strings.Join(names[min(max(k.StripComponents, 0), len(names)-1):], "/")
+	if k.Template != "" {
+		p, _ = expandKeyTemplate(k.Template, p, modTime)
+	}
+	if k.Lowercase {
+		p = strings.ToLower(p)
+	}
+	return keyMapper(k.Layout).Key(p, modTime)
+}
+
+func (k ShapedKeys) Path(key string) (string, bool) {
+	return keyMapper(k.Layout).Path(key)
+}
+
+// CheckKeyTemplate reports whether template is one ShapedKeys can rewrite
+// paths with. Its variables are those of the path of each file, after
+// StripComponents:
+//
+//	{path}         the path, as photos/2024/a.jpg
+//	{dir}          its directory, photos/2024, or nothing at the top
+//	{name}         its file name, a.jpg
+//	{stem}         the file name without its extension, a
+//	{ext}          the extension, with its dot, .jpg, or nothing
+//	{date}         the UTC date it was last modified, as 2024-03-01
+//	{date:layout}  that time in the Go time layout, as {date:2006/01} for 2024/03
+//
+// {{ stands for a literal {. The template must hold {path}, {name} or
+// {stem}, so that files are told apart. Empty, "." and ".." names in the
+// rewritten path are dropped, so that {dir}/{name} is a.jpg at the top.
+func CheckKeyTemplate(template string) error {
+	if _, err := expandKeyTemplate(template, "a/b.c", time.Now()); err != nil {
+		return err
+	}
+	for _, v := range []string{"{path}", "{name}", "{stem}"} {
+		if strings.Contains(strings.ReplaceAll(template, "{{", ""), v) {
+			return nil
+		}
+	}
+	return fmt.Errorf("key template %q holds none of {path}, {name} and {stem}", template)
+}
+
+// expandKeyTemplate rewrites p, last modified at modTime, with template.
+// See CheckKeyTemplate. An unknown variable is an error, and is kept as it
+// is in the path returned.
+func expandKeyTemplate(template, p string, modTime time.Time) (string, error) {
+	dir, name := path.Split(p)
+	ext := path.Ext(name)
+	var b strings.Builder
+	var err error
+	for s := template; ; {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		if strings.HasPrefix(s[i:], "{{") {
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		ref, rest, ok := strings.Cut(s[i+1:], "}")
+		if !ok {
+			b.WriteString(s[i:])
+			err = fmt.Errorf("unterminated { in key template %q", template)
+			break
+		}
+		s = rest
+		switch v, layout, _ := strings.Cut(ref, ":"); {
+		case v == "date":
+			b.WriteString(modTime.UTC().Format(cmp.Or(layout, DefaultDateLayout)))
+			continue
+		case layout != "":
+			err = fmt.Errorf("{%s}: %s takes no layout", ref, v)
+		}
+		switch ref {
+		case "path":
+			b.WriteString(p)
+		case "dir":
+			b.WriteString(strings.TrimSuffix(dir, "/"))
+		case "name":
+			b.WriteString(name)
+		case "stem":
+			b.WriteString(strings.TrimSuffix(name, ext))
+		case "ext":
+			b.WriteString(ext)
+		default:
+			b.WriteString("{" + ref + "}")
+			if err == nil {
+				err = fmt.Errorf("{%s}: unknown variable in key template (want path, dir, name, stem, ext or date)", ref)
+			}
+		}
+	}
+	names := strings.Split(b.String(), "/")
+	names = slices.DeleteFunc(names, func(n string) bool { return n == "" || n == "." || n == ".." })
+	if len(names) == 0 {
+		return p, err // as for {stem} of .profile
+	}
+	return strings.Join(names, "/"), err
+}
+
+// claimKey records that the file at path is stored under key, failing
+// with ErrKeyCollision if a file walked before it is too. Only ShapedKeys
+// store two files under one key, so only their keys are recorded.
+func (p *Plan) claimKey(opts Options, key, path string) error {
+	switch opts.Keys.(type) {
+	case ShapedKeys, *ShapedKeys:
+	default:
+		return nil
+	}
+	if p.keyPaths == nil {
+		p.keyPaths = make(map[string]string)
+	}
+	if other, ok := p.keyPaths[key]; ok {
+		return fmt.Errorf("%w: %s and %s are both stored as %s", ErrKeyCollision, other, path, key)
+	}
+	p.keyPaths[key] = path
+	return nil
+}
@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShapedKeys(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 23, 30, 0, 0, time.FixedZone("", -5*3600))
+	tests := []struct {
+		keys ShapedKeys
+		path string
+		key  string
+	}{
+		{ShapedKeys{}, "home/me/a.txt", "home/me/a.txt"},
+		{ShapedKeys{StripComponents: 2}, "home/me/docs/a.txt", "docs/a.txt"},
+		{ShapedKeys{StripComponents: 2}, "home/a.txt", "a.txt"},
+		{ShapedKeys{Lowercase: true}, "Photos/IMG_1.JPG", "photos/img_1.jpg"},
+		{ShapedKeys{Template: "{ext}/{dir}/{stem}-{date:2006}{ext}"}, "photos/a.jpg", ".jpg/photos/a-2024.jpg"},
+		{ShapedKeys{Template: "{dir}/{date}/{name}"}, "a.jpg", "2024-03-02/a.jpg"}, // by UTC date
+		{ShapedKeys{Template: "{stem}"}, ".profile", ".profile"},
+		{ShapedKeys{StripComponents: 1, Template: "by-name/{name}", Lowercase: true, Layout: HashedKeys{}}, "Docs/A.txt",
+			pathHash("by-name/a.txt") + "/by-name/a.txt"},
+	}
+	for _, tt := range tests {
+		key := tt.keys.Key(tt.path, mtime)
+		if key != tt.key {
+			t.Errorf("%+v.Key(%q) = %q, want %q", tt.keys, tt.path, key, tt.key)
+		}
+	}
+	if path, ok := (ShapedKeys{Lowercase: true, Layout: DateKeys{}}).Path("2024/03/02/photos/a.jpg"); !ok || path != "photos/a.jpg" {
+		t.Errorf("Path = %q, %v, want the path of the layout", path, ok)
+	}
+
+	for _, template := range []string{"{dir}", "{name:x}", "{size}/{name}", "{name"} {
+		if err := CheckKeyTemplate(template); err == nil {
+			t.Errorf("CheckKeyTemplate(%q) succeeded, want an error", template)
+		}
+	}
+	if err := CheckKeyTemplate("{{x}/{path}"); err != nil {
+		t.Errorf("CheckKeyTemplate: %v", err)
+	}
+}
+
+func TestSync_shapedKeysDelete(t *testing.T) {
+	for _, keys := range []ShapedKeys{
+		{StripComponents: 1},
+		{Lowercase: true},
+		{Template: "{ext}/{path}"},
+	} {
+		src := t.TempDir()
+		writeFile(t, src, "docs/B.txt", "b")
+		writeFile(t, src, "docs/c.txt", "c")
+		stored := syncTwiceKeepsAll(t, Options{Src: src, Keys: keys})
+		if len(stored) != 2 {
+			t.Errorf("%+v: stored %q, want both files", keys, stored)
+		}
+	}
+}
+
+func TestSync_shapedKeyCollision(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a/notes.txt", "a")
+	writeFile(t, src, "b/notes.txt", "b")
+	dst := newMockDest()
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, Keys: ShapedKeys{StripComponents: 1}})
+	if !errors.Is(err, ErrKeyCollision) || len(dst.putCalls) != 0 {
+		t.Errorf("Sync = %v, put %v; want ErrKeyCollision and nothing put", err, dst.putCalls)
+	}
+}
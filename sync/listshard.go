@@ -0,0 +1,140 @@
+package sync
+
+import (
+	"context"
+	stdsync "sync"
+)
+
+// shardLister is implemented by destinations whose listings can be split
+// by key prefix and listed in parallel by listSharded. T is what a
+// listing returns for each object.
+type shardLister[T any] interface {
+	// listLevel makes a single request for the objects directly under
+	// prefix and the common prefixes, ending in "/", of those further
+	// down, and reports whether there were more than one page holds.
+	listLevel(ctx context.Context, prefix string) (objs []T, prefixes []string, more bool, err error)
+	// listUnder calls fn with each page of the objects under prefix, in
+	// byte order, stopping at the first error it returns. Pages must not
+	// be reused.
+	listUnder(ctx context.Context, prefix string, fn func([]T) error) error
+	// objectKey returns the full key of obj.
+	objectKey(obj T) string
+}
+
+// Limits of listSharded.
+const (
+	// shardDepth is how many levels of a single common prefix, such as
+	// "backups/2024/", listSharded descends through looking for several.
+	shardDepth = 4
+	// shardPages is how many pages a shard lists ahead of the caller.
+	shardPages = 4
+)
+
+// listSharded calls fn with each page of the objects under prefix, in byte
+// order, as listUnder does, but lists the common prefixes under prefix at
+// up to concurrency at once. It finds them with a delimited request for
+// each level; if a level holds more than a page, or no more than one
+// prefix, it lists prefix in one go instead.
+func listSharded[T any](ctx context.Context, l shardLister[T], prefix string, concurrency int, fn func([]T) error) error {
+	if concurrency < 2 {
+		return l.listUnder(ctx, prefix, fn)
+	}
+	var objs []T
+	var prefixes []string
+	for depth := 0; ; depth++ {
+		var more bool
+		var err error
+		objs, prefixes, more, err = l.listLevel(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		if more {
+			return l.listUnder(ctx, prefix, fn)
+		}
+		if len(objs) > 0 || len(prefixes) != 1 || depth == shardDepth {
+			break
+		}
+		prefix = prefixes[0]
+	}
+	if len(prefixes) < 2 {
+		return l.listUnder(ctx, prefix, fn)
+	}
+
+	// The shards are stopped, and then waited for, when the caller is done.
+	var wg stdsync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	shards := make([]*listShard[T], len(prefixes))
+	for i, p := range prefixes {
+		shards[i] = &listShard[T]{prefix: p, pages: make(chan []T, shardPages)}
+	}
+	// Shards start in order, so that each the caller waits for holds a
+	// slot or gets the next free one.
+	slots := make(chan struct{}, concurrency)
+	wg.Go(func() {
+		for _, s := range shards {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				s.err = ctx.Err()
+				close(s.pages)
+				continue
+			}
+			wg.Go(func() {
+				defer func() { <-slots }()
+				s.list(ctx, l)
+			})
+		}
+	})
+
+	// The objects of the level and the shards, merged in byte order: the
+	// keys under a prefix sort after it and before any key that follows
+	// it without starting with it.
+	next := 0
+	flush := func(upTo string) error {
+		end := next
+		for end < len(objs) && (upTo == "" || l.objectKey(objs[end]) < upTo) {
+			end++
+		}
+		if end == next {
+			return nil
+		}
+		page := objs[next:end]
+		next = end
+		return fn(page)
+	}
+	for _, s := range shards {
+		if err := flush(s.prefix); err != nil {
+			return err
+		}
+		for page := range s.pages {
+			if err := fn(page); err != nil {
+				return err
+			}
+		}
+		if s.err != nil {
+			return s.err
+		}
+	}
+	return flush("")
+}
+
+// listShard lists the objects under a prefix for listSharded.
+type listShard[T any] struct {
+	prefix string
+	pages  chan []T
+	err    error // set before pages is closed
+}
+
+func (s *listShard[T]) list(ctx context.Context, l shardLister[T]) {
+	defer close(s.pages)
+	s.err = l.listUnder(ctx, s.prefix, func(page []T) error {
+		select {
+		case s.pages <- page:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
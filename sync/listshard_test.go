@@ -0,0 +1,140 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	stdsync "sync"
+	"testing"
+)
+
+// fakeShardLister lists keys, which must be sorted, as a bucket with pages
+// of pageSize keys would, and levels of up to levelSize entries, if set.
+type fakeShardLister struct {
+	keys      []string
+	pageSize  int
+	levelSize int
+
+	mu     stdsync.Mutex
+	levels []string // prefixes listLevel was called for
+	unders []string // prefixes listUnder was called for
+}
+
+func (l *fakeShardLister) listLevel(_ context.Context, prefix string) ([]string, []string, bool, error) {
+	l.mu.Lock()
+	l.levels = append(l.levels, prefix)
+	l.mu.Unlock()
+	var objs, prefixes []string
+	for _, k := range l.keys {
+		rest, ok := strings.CutPrefix(k, prefix)
+		if !ok {
+			continue
+		}
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			if p := prefix + rest[:i+1]; !slices.Contains(prefixes, p) {
+				prefixes = append(prefixes, p)
+			}
+		} else {
+			objs = append(objs, k)
+		}
+	}
+	return objs, prefixes, l.levelSize > 0 && len(objs)+len(prefixes) > l.levelSize, nil
+}
+
+func (l *fakeShardLister) listUnder(ctx context.Context, prefix string, fn func([]string) error) error {
+	l.mu.Lock()
+	l.unders = append(l.unders, prefix)
+	l.mu.Unlock()
+	var page []string
+	for _, k := range l.keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page = append(page, k)
+		if len(page) == l.pageSize {
+			if err := fn(page); err != nil {
+				return err
+			}
+			page = nil
+		}
+	}
+	if len(page) > 0 {
+		return fn(page)
+	}
+	return nil
+}
+
+func (l *fakeShardLister) objectKey(k string) string { return k }
+
+func TestListSharded(t *testing.T) {
+	keys := []string{
+		"a-b", "a/1", "a/2", "a/3", "a0", "b/x/1", "b/y/2", "c", "d/1", "d/2/3",
+	}
+	ctx := context.Background()
+	for _, concurrency := range []int{1, 2, 8} {
+		l := &fakeShardLister{keys: keys, pageSize: 2}
+		var got []string
+		err := listSharded(ctx, l, "", concurrency, func(page []string) error {
+			got = append(got, page...)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, keys) {
+			t.Errorf("concurrency %d: listed %v, want %v", concurrency, got, keys)
+		}
+	}
+
+	// The prefixes of a level are listed on their own, and a level of
+	// more than a page in one go.
+	l := &fakeShardLister{keys: keys, pageSize: 2}
+	listSharded(ctx, l, "", 8, func([]string) error { return nil })
+	if slices.Sort(l.unders); !slices.Equal(l.unders, []string{"a/", "b/", "d/"}) {
+		t.Errorf("listed %v, want the prefixes a/, b/ and d/", l.unders)
+	}
+	l = &fakeShardLister{keys: keys, pageSize: 2, levelSize: 5}
+	listSharded(ctx, l, "", 8, func([]string) error { return nil })
+	if !slices.Equal(l.unders, []string{""}) {
+		t.Errorf("listed %v, want a single listing", l.unders)
+	}
+
+	// A single prefix is descended through.
+	l = &fakeShardLister{keys: []string{"backup/2024/a", "backup/2024/b/c", "backup/2025/d"}, pageSize: 2}
+	var got []string
+	listSharded(ctx, l, "", 8, func(page []string) error {
+		got = append(got, page...)
+		return nil
+	})
+	if want := []string{"", "backup/"}; !slices.Equal(l.levels, want) {
+		t.Errorf("levels listed: %v, want %v", l.levels, want)
+	}
+	if !slices.Equal(got, l.keys) {
+		t.Errorf("listed %v, want %v", got, l.keys)
+	}
+}
+
+func TestListSharded_stops(t *testing.T) {
+	var keys []string
+	for _, p := range []string{"a", "b", "c", "d", "e", "f"} {
+		for _, k := range []string{"1", "2", "3", "4", "5"} {
+			keys = append(keys, p+"/"+k)
+		}
+	}
+	l := &fakeShardLister{keys: keys, pageSize: 1}
+	stop := errors.New("stop")
+	n := 0
+	err := listSharded(context.Background(), l, "", 2, func(page []string) error {
+		if n++; n == 7 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || n != 7 {
+		t.Errorf("listSharded = %v after %d pages, want the error of the 7th", err, n)
+	}
+}
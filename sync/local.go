@@ -0,0 +1,270 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("file", openLocalURL)
+}
+
+// LocalDestination mirrors files into a directory on a locally mounted
+// filesystem, such as an external drive or a network share.
+type LocalDestination struct {
+	root string
+
+	// Set by Restore: how POSIX attributes are applied, and where those
+	// that could not be are noted.
+	posix  POSIXPolicy
+	denied *posixReport
+}
+
+// NewLocalDestination creates a new LocalDestination rooted at dir.
+func NewLocalDestination(dir string) *LocalDestination {
+	return &LocalDestination{root: longPath(dir)}
+}
+
+// openLocalURL accepts file:///abs/path as well as file://rel/path.
+func openLocalURL(_ context.Context, u *url.URL) (Destination, error) {
+	dir := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		dir = u.Host + u.Path
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("missing directory")
+	}
+	return NewLocalDestination(filepath.FromSlash(dir)), nil
+}
+
+func (d *LocalDestination) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("key %q escapes the destination directory", key)
+	}
+	return filepath.Join(d.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file in the target directory and renames it
+// into place, so a partially written file never replaces a good one. POSIX
+// attributes in meta are applied to the file itself. Compressed objects are
+// decompressed, and sparse ones written as files with holes.
+func (d *LocalDestination) Put(_ context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".foldersync-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if err := writeContent(tmp, r, meta); err != nil {
+		tmp.Close()
+		return err
+	}
+	return d.place(key, path, tmp, meta)
+}
+
+// place moves tmp, a file written with the content of key, to path with
+// the attributes in meta, once its content checks out against the
+// SHA-256 recorded in meta, if any. It closes tmp.
+func (d *LocalDestination) place(key, path string, tmp *os.File, meta ObjectMeta) error {
+	if meta.SHA256 != "" {
+		// Keep whatever is at path if the content came back corrupted.
+		if err := checkSHA256(tmp, meta.SHA256); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := d.applyPOSIX(key, tmp.Name(), meta.POSIX); err != nil {
+		return err
+	}
+	mtime := meta.ModTime.Truncate(time.Second)
+	if err := os.Chtimes(tmp.Name(), mtime, mtime); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d *LocalDestination) Stat(_ context.Context, key string) (*ObjectMeta, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := readPOSIX(path, info)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectMeta{Size: info.Size(), ModTime: info.ModTime(), POSIX: attrs}, nil
+}
+
+func (d *LocalDestination) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// GetRange implements RangeGetter.
+func (d *LocalDestination) GetRange(_ context.Context, key string, off, n int64) (io.ReadCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, off, n), f}, nil
+}
+
+// Copy copies the file for src, with its modification time and POSIX
+// attributes, to dst.
+func (d *LocalDestination) Copy(ctx context.Context, src, dst string) error {
+	meta, err := d.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	}
+	f, err := d.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.Put(ctx, dst, f, *meta)
+}
+
+// CopyWithMeta implements MetaCopier by writing the content of the file
+// for src to dst as Put does. The file holds content Put has already
+// decompressed.
+func (d *LocalDestination) CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error {
+	f, err := d.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	meta.Compression, meta.Sparse = "", false
+	return d.Put(ctx, dst, f, meta)
+}
+
+// Rename implements Renamer with a rename of the file, which is atomic
+// within a filesystem.
+func (d *LocalDestination) Rename(_ context.Context, src, dst string) error {
+	from, err := d.path(src)
+	if err != nil {
+		return err
+	}
+	to, err := d.path(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// List implements Destination a page of up to localListPage keys at a
+// time, reading one directory at a time.
+func (d *LocalDestination) List(ctx context.Context, fn func(keys []string) error) error {
+	var page []string
+	err := walkKeys(ctx, d.root, "", func(key string) error {
+		page = append(page, key)
+		if len(page) < localListPage {
+			return nil
+		}
+		err := fn(page)
+		page = nil
+		return err
+	})
+	if os.IsNotExist(err) {
+		if _, serr := os.Stat(d.root); os.IsNotExist(serr) {
+			return nil // nothing synced yet
+		}
+	}
+	if err != nil || len(page) == 0 {
+		return err
+	}
+	return fn(page)
+}
+
+// localListPage is the most keys a page of LocalDestination.List holds.
+const localListPage = 1000
+
+// walkKeys calls fn with the key of each file under dir, whose key starts
+// with prefix, in byte order of the keys rather than of the names in each
+// directory: "a-b" comes before "a/b".
+func walkKeys(ctx context.Context, dir, prefix string, fn func(key string) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	name := func(e fs.DirEntry) string {
+		if e.IsDir() {
+			return e.Name() + "/"
+		}
+		return e.Name()
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(name(a), name(b)) })
+	for _, e := range entries {
+		var err error
+		if e.IsDir() {
+			err = walkKeys(ctx, filepath.Join(dir, e.Name()), prefix+e.Name()+"/", fn)
+		} else {
+			err = fn(prefix + e.Name())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the file for key, then any directories left empty by it.
+func (d *LocalDestination) Delete(_ context.Context, key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != d.root && len(dir) > len(d.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // not empty
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalDestination_roundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	writeFile(t, src, "sub/b.txt", "world")
+
+	dst := NewLocalDestination(t.TempDir())
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst.root, "sub", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "world" {
+		t.Errorf("sub/b.txt = %q, want %q", data, "world")
+	}
+
+	// A second run finds everything up to date.
+	plan, err := buildPlan(ctx, Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Uploads) != 0 {
+		t.Errorf("expected no uploads on second run, got %v", plan.Uploads)
+	}
+}
+
+func TestLocalDestination_deletePrunesEmptyDirs(t *testing.T) {
+	dst := NewLocalDestination(t.TempDir())
+	ctx := context.Background()
+	if err := dst.Put(ctx, "a/b/c.txt", strings.NewReader("x"), ObjectMeta{Size: 1, ModTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Delete(ctx, "a/b/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dst.root, "a")); !os.IsNotExist(err) {
+		t.Errorf("expected empty directories to be removed, got %v", err)
+	}
+	if _, err := os.Stat(dst.root); err != nil {
+		t.Errorf("root should survive: %v", err)
+	}
+}
+
+func TestLocalDestination_rejectsEscapingKeys(t *testing.T) {
+	dst := NewLocalDestination(t.TempDir())
+	err := dst.Put(context.Background(), "../evil.txt", strings.NewReader("x"), ObjectMeta{Size: 1, ModTime: time.Now()})
+	if err == nil {
+		t.Error("expected error for key escaping the root, got nil")
+	}
+}
+
+func TestLocalDestination_copy(t *testing.T) {
+	dst := NewLocalDestination(t.TempDir())
+	ctx := context.Background()
+	mtime := time.Unix(1700000000, 0)
+	if err := dst.Put(ctx, "a/x.txt", strings.NewReader("x"), ObjectMeta{Size: 1, ModTime: mtime}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dst.Copy(ctx, "a/x.txt", "b/x.txt"); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := dst.Stat(ctx, "b/x.txt")
+	if err != nil || meta == nil {
+		t.Fatalf("Stat after Copy: %v, %v", meta, err)
+	}
+	if !meta.ModTime.Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", meta.ModTime, mtime)
+	}
+	if err := dst.Copy(ctx, "missing.txt", "c.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Copy of a missing key: got %v, want fs.ErrNotExist", err)
+	}
+
+	if err := dst.Rename(ctx, "b/x.txt", "c/d/x.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := dst.Stat(ctx, "c/d/x.txt"); err != nil || meta == nil || !meta.ModTime.Equal(mtime) {
+		t.Errorf("Stat after Rename = %v, %v, want mtime %v", meta, err, mtime)
+	}
+	if meta, err := dst.Stat(ctx, "b/x.txt"); err != nil || meta != nil {
+		t.Errorf("Stat of the renamed key = %v, %v, want nil", meta, err)
+	}
+	if err := dst.Rename(ctx, "missing.txt", "c.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Rename of a missing key: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestLocalDestination_list(t *testing.T) {
+	root := t.TempDir()
+	dst := NewLocalDestination(filepath.Join(root, "backup"))
+	ctx := context.Background()
+	if keys, err := listKeys(ctx, dst); err != nil || len(keys) != 0 {
+		t.Fatalf("listed %v, %v before anything was synced", keys, err)
+	}
+	for _, key := range []string{"b", "a/c/d", "a-b", "a/b"} {
+		if err := dst.Put(ctx, key, strings.NewReader("x"), ObjectMeta{Size: 1, ModTime: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := listKeys(ctx, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a-b", "a/b", "a/c/d", "b"}; !slices.Equal(keys, want) {
+		t.Errorf("listed %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrObjectLocked is returned when an object cannot be deleted or
+// overwritten because it is locked. See Options.ObjectLock.
+var ErrObjectLocked = errors.New("object is locked")
+
+// LockMode is the retention mode of a locked object.
+type LockMode string
+
+const (
+	// LockGovernance retains objects against anyone without the
+	// s3:BypassGovernanceRetention permission.
+	LockGovernance LockMode = "GOVERNANCE"
+	// LockCompliance retains objects against everyone, the account's
+	// root user included, until their retention ends.
+	LockCompliance LockMode = "COMPLIANCE"
+)
+
+// ParseLockMode parses the names used on the command line: governance
+// and compliance, in any case.
+func ParseLockMode(s string) (LockMode, error) {
+	switch m := LockMode(strings.ToUpper(s)); m {
+	case LockGovernance, LockCompliance:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown lock mode %q (valid: governance, compliance)", s)
+}
+
+// ObjectLock describes how uploaded objects are locked against deletion
+// and overwriting. See Options.ObjectLock.
+type ObjectLock struct {
+	// Mode and Retain set the retention of each object: it is locked in
+	// Mode until Retain after it is uploaded. An empty Mode sets no
+	// retention.
+	Mode   LockMode
+	Retain time.Duration
+	// LegalHold locks each object until the hold is removed, however
+	// long that is.
+	LegalHold bool
+}
+
+// Check reports whether l is complete: a mode needs a retention period,
+// and a retention period a mode.
+func (l ObjectLock) Check() error {
+	switch {
+	case l.Mode == "" && l.Retain > 0:
+		return errors.New("object lock: a retention period needs a mode")
+	case l.Mode != "" && l.Retain <= 0:
+		return errors.New("object lock: a mode needs a retention period")
+	case l.Mode == "" && !l.LegalHold:
+		return errors.New("object lock: set a retention or a legal hold")
+	}
+	return nil
+}
+
+// ParseObjectLock parses the lock given on the command line: a mode
+// accepted by ParseLockMode, a retention period accepted by ParseAge, such
+// as "90d", and whether to set a legal hold. It returns nil if none is
+// given.
+func ParseObjectLock(mode, retain string, legalHold bool) (*ObjectLock, error) {
+	if mode == "" && retain == "" && !legalHold {
+		return nil, nil
+	}
+	l := &ObjectLock{LegalHold: legalHold}
+	var err error
+	if mode != "" {
+		if l.Mode, err = ParseLockMode(mode); err != nil {
+			return nil, err
+		}
+	}
+	if retain != "" {
+		if l.Retain, err = ParseAge(retain); err != nil {
+			return nil, err
+		}
+	}
+	return l, l.Check()
+}
+
+// Locker is implemented by destinations that can lock the objects they
+// store, honoring ObjectMeta.Lock.
+type Locker interface {
+	// CheckObjectLock reports whether objects can be locked, such as
+	// whether an S3 bucket has Object Lock enabled.
+	CheckObjectLock(ctx context.Context) error
+}
+
+// checkObjectLock checks that opts.Dst can apply opts.ObjectLock.
+func checkObjectLock(ctx context.Context, opts Options) error {
+	if opts.ObjectLock == nil {
+		return nil
+	}
+	if err := opts.ObjectLock.Check(); err != nil {
+		return err
+	}
+	if opts.DetectRenames {
+		return errors.New("detecting renames cannot be combined with object lock: copies are not locked")
+	}
+	l, ok := opts.Dst.(Locker)
+	if !ok {
+		return fmt.Errorf("object lock: %w", errors.ErrUnsupported)
+	}
+	if err := l.CheckObjectLock(ctx); err != nil {
+		return fmt.Errorf("object lock: %w", err)
+	}
+	return nil
+}
+
+// LockedError describes an object that could not be deleted because it
+// is locked, as far as the destination reports it. It wraps
+// ErrObjectLocked.
+type LockedError struct {
+	Key       string
+	Mode      LockMode  // empty if the object has no retention
+	Until     time.Time // end of the retention
+	LegalHold bool
+	Err       error // the destination's error
+}
+
+func (e *LockedError) Error() string {
+	s := ErrObjectLocked.Error()
+	if e.Mode != "" {
+		s += fmt.Sprintf(" in %s mode until %s", e.Mode, e.Until.UTC().Format(time.RFC3339))
+	}
+	if e.LegalHold {
+		s += " under a legal hold"
+	}
+	return s
+}
+
+func (e *LockedError) Unwrap() []error { return []error{ErrObjectLocked, e.Err} }
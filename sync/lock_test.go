@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// lockingDest is a mockDest that can lock objects.
+type lockingDest struct {
+	*mockDest
+	enabled bool
+}
+
+func (d lockingDest) CheckObjectLock(context.Context) error {
+	if !d.enabled {
+		return errors.New("bucket does not have Object Lock enabled")
+	}
+	return nil
+}
+
+func TestSync_objectLock(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	lock := &ObjectLock{Mode: LockCompliance, Retain: 30 * 24 * time.Hour}
+	ctx := context.Background()
+
+	dst := newMockDest()
+	if _, err := Sync(ctx, Options{Src: src, Dst: lockingDest{dst, true}, ObjectLock: lock}); err != nil {
+		t.Fatal(err)
+	}
+	if got := dst.objects["a.txt"].Lock; got != lock {
+		t.Errorf("uploaded with lock %+v, want %+v", got, lock)
+	}
+
+	for _, opts := range []Options{
+		{Src: src, Dst: newMockDest(), ObjectLock: lock},
+		{Src: src, Dst: lockingDest{newMockDest(), false}, ObjectLock: lock},
+		{Src: src, Dst: lockingDest{newMockDest(), true}, ObjectLock: &ObjectLock{Mode: LockGovernance}},
+	} {
+		if _, err := Sync(ctx, opts); err == nil || !strings.Contains(err.Error(), "object lock") {
+			t.Errorf("Sync(%T, %+v) = %v, want an object lock error", opts.Dst, *opts.ObjectLock, err)
+		}
+	}
+}
+
+func TestParseLockMode(t *testing.T) {
+	for s, want := range map[string]LockMode{"governance": LockGovernance, "COMPLIANCE": LockCompliance} {
+		if got, err := ParseLockMode(s); err != nil || got != want {
+			t.Errorf("ParseLockMode(%q) = %q, %v; want %q", s, got, err, want)
+		}
+	}
+	if _, err := ParseLockMode("legal-hold"); err == nil {
+		t.Error("parsed legal-hold as a mode")
+	}
+}
+
+func TestS3Destination_objectLock(t *testing.T) {
+	until := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	var put *s3.PutObjectInput
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				var out any
+				switch p := in.Parameters.(type) {
+				case *s3.GetObjectLockConfigurationInput:
+					out = &s3.GetObjectLockConfigurationOutput{ObjectLockConfiguration: &types.ObjectLockConfiguration{
+						ObjectLockEnabled: types.ObjectLockEnabledEnabled,
+					}}
+				case *s3.PutObjectInput:
+					put = p
+					out = &s3.PutObjectOutput{}
+				case *s3.DeleteObjectsInput:
+					out = &s3.DeleteObjectsOutput{Errors: []types.Error{{
+						Key:     aws.String("locked.txt"),
+						Code:    aws.String("AccessDenied"),
+						Message: aws.String("Access Denied because object protected by object lock."),
+					}}}
+				case *s3.HeadObjectInput:
+					out = &s3.HeadObjectOutput{ObjectLockMode: types.ObjectLockModeCompliance, ObjectLockRetainUntilDate: &until}
+				default:
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", p)
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+	ctx := context.Background()
+
+	if err := d.CheckObjectLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lock := &ObjectLock{Mode: LockGovernance, Retain: 24 * time.Hour, LegalHold: true}
+	if err := d.Put(ctx, "a.txt", strings.NewReader("a"), ObjectMeta{Size: 1, Lock: lock}); err != nil {
+		t.Fatal(err)
+	}
+	retain := aws.ToTime(put.ObjectLockRetainUntilDate)
+	if put.ObjectLockMode != types.ObjectLockModeGovernance || time.Until(retain) < 23*time.Hour ||
+		put.ObjectLockLegalHoldStatus != types.ObjectLockLegalHoldStatusOn || put.ChecksumAlgorithm == "" {
+		t.Errorf("put with mode %q until %v, hold %q, checksum %q", put.ObjectLockMode, retain, put.ObjectLockLegalHoldStatus, put.ChecksumAlgorithm)
+	}
+
+	_, err := d.DeleteBatch(ctx, []string{"locked.txt"})
+	var le *LockedError
+	if !errors.Is(err, ErrObjectLocked) || !errors.As(err, &le) || le.Key != "locked.txt" {
+		t.Fatalf("DeleteBatch = %v, want a LockedError", err)
+	}
+	if want := "delete locked.txt: object is locked in COMPLIANCE mode until 2030-01-02T03:04:05Z (and 0 more)"; err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
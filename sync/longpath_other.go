@@ -0,0 +1,8 @@
+//go:build !windows
+
+package sync
+
+// longPath returns dir: only Windows limits the length of paths.
+func longPath(dir string) string {
+	return dir
+}
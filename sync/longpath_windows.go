@@ -0,0 +1,25 @@
+package sync
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPath returns dir in its extended-length form, \\?\C:\dir or
+// \\?\UNC\server\share\dir, so that the paths of the files below it are
+// not limited to 260 characters, however deep they are. Paths already in
+// that form, and those that cannot be made absolute, are returned as
+// they are.
+func longPath(dir string) string {
+	if strings.HasPrefix(dir, `\\?\`) {
+		return dir
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
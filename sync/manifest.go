@@ -0,0 +1,189 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// metaPrefix is the key prefix reserved for foldersync's own objects. It is
+// never walked in the source and never deleted from the destination.
+const metaPrefix = ".foldersync/"
+
+const (
+	// ManifestKey is the destination key the manifest is stored under.
+	ManifestKey = metaPrefix + "manifest.json"
+	// ManifestSigKey holds the detached Ed25519 signature of ManifestKey.
+	ManifestSigKey = ManifestKey + ".sig"
+)
+
+// ErrBadSignature is returned when a manifest is unsigned or its signature
+// does not match the verification key.
+var ErrBadSignature = errors.New("manifest signature does not verify")
+
+// Manifest lists every source file as of the end of a sync run.
+type Manifest struct {
+	Created time.Time       `json:"created"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry records one file in a Manifest.
+type ManifestEntry struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// SHA256 is the hex-encoded hash of the file's content, if the run
+	// that wrote the manifest knew it. See Options.ManifestChecksums.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// newManifest lists files, with the content hashes state recorded for
+// them, if any.
+func newManifest(files []File, state *stateCache) *Manifest {
+	m := &Manifest{Created: time.Now().UTC()}
+	for _, f := range files {
+		e := ManifestEntry{
+			Key:     f.Key,
+			Size:    f.Size,
+			ModTime: f.ModTime.UTC().Truncate(time.Second),
+		}
+		if state != nil {
+			if s, ok := state.new[f.Key]; ok && s.Size == f.Size && s.ModTime == f.ModTime.UnixNano() {
+				e.SHA256 = s.SHA256
+			}
+		}
+		m.Files = append(m.Files, e)
+	}
+	return m
+}
+
+// WriteManifest stores m in dst. If key is non-nil a detached signature is
+// stored next to it; otherwise any stale signature is removed so that it
+// cannot vouch for the new content.
+func WriteManifest(ctx context.Context, dst Destination, m *Manifest, key ed25519.PrivateKey) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := dst.Put(ctx, ManifestKey, bytes.NewReader(data), ObjectMeta{Size: int64(len(data)), ModTime: m.Created, ContentType: "application/json"}); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	if key == nil {
+		if err := dst.Delete(ctx, ManifestSigKey); err != nil {
+			return fmt.Errorf("remove manifest signature: %w", err)
+		}
+		return nil
+	}
+	sig := ed25519.Sign(key, data)
+	if err := dst.Put(ctx, ManifestSigKey, bytes.NewReader(sig), ObjectMeta{Size: int64(len(sig)), ModTime: m.Created}); err != nil {
+		return fmt.Errorf("write manifest signature: %w", err)
+	}
+	return nil
+}
+
+// ReadManifest loads the manifest from dst. If pub is non-nil the manifest
+// must carry a valid signature from the matching private key, or
+// ErrBadSignature is returned. A missing manifest yields an error wrapping
+// fs.ErrNotExist.
+func ReadManifest(ctx context.Context, dst Destination, pub ed25519.PublicKey) (*Manifest, error) {
+	data, err := readObject(ctx, dst, ManifestKey)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	if pub != nil {
+		sig, err := readObject(ctx, dst, ManifestSigKey)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: manifest is unsigned", ErrBadSignature)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read manifest signature: %w", err)
+		}
+		if !ed25519.Verify(pub, data, sig) {
+			return nil, ErrBadSignature
+		}
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func readObject(ctx context.Context, dst Destination, key string) ([]byte, error) {
+	rc, err := get(ctx, dst, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// verifyManifest checks the signature of the existing manifest, if there is
+// one, before a run that may delete data.
+func verifyManifest(ctx context.Context, dst Destination, pub ed25519.PublicKey) error {
+	_, err := ReadManifest(ctx, dst, pub)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // nothing recorded yet
+	}
+	return err
+}
+
+// LoadSigningKey reads a PEM-encoded PKCS #8 Ed25519 private key, as written
+// by "openssl genpkey -algorithm ed25519".
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	return priv, nil
+}
+
+// LoadVerifyKey reads a PEM-encoded PKIX Ed25519 public key, as written by
+// "openssl pkey -pubout".
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", path)
+	}
+	return pub, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSync_writesSignedManifest(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	writeFile(t, src, "b/c.txt", "world")
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMockDest()
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Manifest: true, SigningKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadManifest(ctx, dst, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 2 || m.Files[0].Key != "a.txt" || m.Files[1].Key != "b/c.txt" {
+		t.Errorf("unexpected manifest entries: %+v", m.Files)
+	}
+}
+
+func TestReadManifest_rejectsTampering(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := newMockDest()
+	ctx := context.Background()
+	if err := WriteManifest(ctx, dst, &Manifest{Files: []ManifestEntry{{Key: "a.txt", Size: 1}}}, priv); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.data[ManifestKey] = []byte(`{"files":[]}`)
+	if _, err := ReadManifest(ctx, dst, pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for tampered manifest, got %v", err)
+	}
+
+	delete(dst.data, ManifestSigKey)
+	if _, err := ReadManifest(ctx, dst, pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for unsigned manifest, got %v", err)
+	}
+}
+
+func TestSync_deleteRefusesTamperedManifest(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := newMockDest()
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Manifest: true, SigningKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	dst.data[ManifestKey] = []byte(`{"files":[]}`)
+	dst.objects["extra.txt"] = &ObjectMeta{}
+
+	_, err = Sync(ctx, Options{Src: src, Dst: dst, Delete: true, VerifyKey: pub})
+	if !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected ErrBadSignature, got %v", err)
+	}
+	if len(dst.deleteCalls) != 0 {
+		t.Errorf("expected no deletes, got %v", dst.deleteCalls)
+	}
+}
+
+func TestSync_deleteKeepsMetadataObjects(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+
+	dst := newMockDest()
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range dst.deleteCalls {
+		if key == ManifestKey {
+			t.Errorf("delete pass removed the manifest")
+		}
+	}
+}
+
+// tamperedDest returns a destination holding a.txt and a trashed and a
+// noncurrent copy of it, whose signed manifest has been altered, and the
+// key it was signed with.
+func tamperedDest(t *testing.T) (*mockVersions, ed25519.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := &mockVersions{mockDest: newMockDest(), versions: []ObjectVersion{
+		{Key: "a.txt", VersionID: "a2", Size: 5, Written: time.Now(), Latest: true},
+		{Key: "a.txt", VersionID: "a1", Size: 3, Written: time.Now().AddDate(-1, 0, 0)},
+	}}
+	ctx := context.Background()
+	if err := WriteManifest(ctx, dst, &Manifest{Files: []ManifestEntry{{Key: "a.txt", Size: 5}}}, priv); err != nil {
+		t.Fatal(err)
+	}
+	trashed := trashKey(time.Now().AddDate(-1, 0, 0), "b.txt")
+	for key, data := range map[string]string{"a.txt": "hello", trashed: "old"} {
+		dst.objects[key] = &ObjectMeta{Size: int64(len(data))}
+		dst.data[key] = []byte(data)
+	}
+	dst.data[ManifestKey] = []byte(`{"files":[]}`)
+	return dst, pub
+}
+
+func TestDestructiveCommandsRefuseTamperedManifest(t *testing.T) {
+	ctx := context.Background()
+	for name, run := range map[string]func(*mockVersions, ed25519.PublicKey) error{
+		"Restore": func(dst *mockVersions, pub ed25519.PublicKey) error {
+			out := t.TempDir()
+			err := Restore(ctx, RestoreOptions{From: dst, To: out, VerifyKey: pub})
+			if entries, _ := os.ReadDir(out); len(entries) != 0 {
+				t.Errorf("Restore restored %d files", len(entries))
+			}
+			return err
+		},
+		"PurgeTrash": func(dst *mockVersions, pub ed25519.PublicKey) error {
+			_, err := PurgeTrash(ctx, dst, PurgeTrashOptions{VerifyKey: pub})
+			return err
+		},
+		"PurgeVersions": func(dst *mockVersions, pub ed25519.PublicKey) error {
+			_, err := PurgeVersions(ctx, dst, PurgeVersionsOptions{VerifyKey: pub})
+			if len(dst.deleted) != 0 {
+				t.Errorf("PurgeVersions purged %v", dst.deleted)
+			}
+			return err
+		},
+		"MigratePrefix": func(dst *mockVersions, pub ed25519.PublicKey) error {
+			dst.objects["old/c.txt"], dst.data["old/c.txt"] = &ObjectMeta{Size: 1}, []byte("c")
+			err := MigratePrefix(ctx, MigrateOptions{Dst: dst, From: "old", To: "new", Delete: true, VerifyKey: pub})
+			if len(dst.copyCalls) != 0 {
+				t.Errorf("MigratePrefix copied %v", dst.copyCalls)
+			}
+			return err
+		},
+	} {
+		dst, pub := tamperedDest(t)
+		if err := run(dst, pub); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s = %v, want ErrBadSignature", name, err)
+		}
+		if len(dst.deleteCalls) != 0 {
+			t.Errorf("%s deleted %v", name, dst.deleteCalls)
+		}
+	}
+}
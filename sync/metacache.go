@@ -0,0 +1,229 @@
+package sync
+
+import (
+	"context"
+	"io"
+	"maps"
+	"slices"
+	stdsync "sync"
+	"time"
+)
+
+// metaCache remembers what a destination returned for List and Stat, so
+// that commands run one after another, such as a dry run followed by the
+// real run, do not list and stat the same objects again. Answers younger
+// than maxAge are reused; writes made through the cache update it. See
+// Options.MetaCache.
+type metaCache struct {
+	path   string
+	maxAge time.Duration
+
+	mu      stdsync.Mutex
+	listed  time.Time // when keys was listed, zero if never
+	keys    map[string]bool
+	objects map[string]metaCacheEntry
+}
+
+type metaCacheEntry struct {
+	Meta    *ObjectMeta `json:"meta,omitempty"` // nil if the object was absent
+	Fetched time.Time   `json:"fetched"`
+}
+
+type metaCacheFile struct {
+	Listed  time.Time                 `json:"listed,omitzero"`
+	Keys    []string                  `json:"keys,omitempty"`
+	Objects map[string]metaCacheEntry `json:"objects"`
+}
+
+// loadMetaCache reads the cache at path. A missing or unreadable cache is
+// treated as empty.
+func loadMetaCache(path string, maxAge time.Duration, warnings io.Writer) *metaCache {
+	var f metaCacheFile
+	readCacheFile(path, "metadata cache", &f, warnings)
+	c := &metaCache{
+		path:    path,
+		maxAge:  maxAge,
+		listed:  f.Listed,
+		keys:    make(map[string]bool, len(f.Keys)),
+		objects: f.Objects,
+	}
+	for _, key := range f.Keys {
+		c.keys[key] = true
+	}
+	if c.objects == nil {
+		c.objects = make(map[string]metaCacheEntry)
+	}
+	return c
+}
+
+func (c *metaCache) fresh(t time.Time) bool {
+	return !t.IsZero() && time.Since(t) < c.maxAge
+}
+
+// set records the metadata of key, nil if it is absent.
+func (c *metaCache) set(key string, meta *ObjectMeta) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects[key] = metaCacheEntry{Meta: meta, Fetched: time.Now()}
+	if meta != nil {
+		c.keys[key] = true
+	} else {
+		delete(c.keys, key)
+	}
+}
+
+// save writes the cache, dropping answers that have gone stale.
+func (c *metaCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := metaCacheFile{Objects: make(map[string]metaCacheEntry)}
+	if c.fresh(c.listed) {
+		f.Listed = c.listed
+		for key := range c.keys {
+			f.Keys = append(f.Keys, key)
+		}
+		slices.Sort(f.Keys)
+	}
+	for key, e := range c.objects {
+		if c.fresh(e.Fetched) {
+			f.Objects[key] = e
+		}
+	}
+	return writeCacheFile(c.path, f)
+}
+
+// metaCacheDest answers List and Stat from its cache when it can.
+type metaCacheDest struct {
+	Destination
+	c *metaCache
+}
+
+func (d metaCacheDest) List(ctx context.Context, fn func(keys []string) error) error {
+	d.c.mu.Lock()
+	if d.c.fresh(d.c.listed) {
+		keys := slices.Sorted(maps.Keys(d.c.keys))
+		d.c.mu.Unlock()
+		return fn(keys)
+	}
+	d.c.mu.Unlock()
+
+	listed := make(map[string]bool)
+	err := d.Destination.List(ctx, func(keys []string) error {
+		for _, key := range keys {
+			listed[key] = true
+		}
+		return fn(keys)
+	})
+	if err != nil {
+		return err
+	}
+	d.c.mu.Lock()
+	defer d.c.mu.Unlock()
+	d.c.listed = time.Now()
+	d.c.keys = listed
+	return nil
+}
+
+func (d metaCacheDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	d.c.mu.Lock()
+	e, ok := d.c.objects[key]
+	d.c.mu.Unlock()
+	if ok && d.c.fresh(e.Fetched) {
+		return e.Meta, nil
+	}
+
+	meta, err := d.Destination.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	d.c.set(key, meta)
+	return meta, nil
+}
+
+func (d metaCacheDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := d.Destination.Put(ctx, key, r, meta); err != nil {
+		return err
+	}
+	// Destinations keep modification times to the second.
+	meta.ModTime = meta.ModTime.Truncate(time.Second)
+	d.c.set(key, &meta)
+	return nil
+}
+
+func (d metaCacheDest) Delete(ctx context.Context, key string) error {
+	if err := d.Destination.Delete(ctx, key); err != nil {
+		return err
+	}
+	d.c.set(key, nil)
+	return nil
+}
+
+func (d metaCacheDest) DeleteBatch(ctx context.Context, keys []string) ([]string, error) {
+	deleted, err := deleteBatch(ctx, d.Destination, keys)
+	for _, key := range deleted {
+		d.c.set(key, nil)
+	}
+	return deleted, err
+}
+
+func (d metaCacheDest) Copy(ctx context.Context, src, dst string) error {
+	if err := copyObject(ctx, d.Destination, src, dst); err != nil {
+		return err
+	}
+	d.c.mu.Lock()
+	defer d.c.mu.Unlock()
+	delete(d.c.objects, dst) // stat it again when needed
+	d.c.keys[dst] = true
+	return nil
+}
+
+func (d metaCacheDest) CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error {
+	if err := copyWithMeta(ctx, d.Destination, src, dst, meta); err != nil {
+		return err
+	}
+	meta.ModTime = meta.ModTime.Truncate(time.Second)
+	d.c.set(dst, &meta)
+	return nil
+}
+
+func (d metaCacheDest) Append(ctx context.Context, key string, size int64, tail io.Reader, meta ObjectMeta) error {
+	if err := appendObject(ctx, d.Destination, key, size, tail, meta); err != nil {
+		return err
+	}
+	meta.ModTime = meta.ModTime.Truncate(time.Second)
+	d.c.set(key, &meta)
+	return nil
+}
+
+func (d metaCacheDest) Rename(ctx context.Context, src, dst string) error {
+	if err := renameObject(ctx, d.Destination, src, dst); err != nil {
+		return err
+	}
+	d.c.set(src, nil)
+	d.c.mu.Lock()
+	defer d.c.mu.Unlock()
+	delete(d.c.objects, dst) // stat it again when needed
+	d.c.keys[dst] = true
+	return nil
+}
+
+func (d metaCacheDest) SetStorageClass(ctx context.Context, key, class string) error {
+	if err := setStorageClass(ctx, d.Destination, key, class); err != nil {
+		return err
+	}
+	d.c.mu.Lock()
+	defer d.c.mu.Unlock()
+	delete(d.c.objects, key) // stat it again when needed
+	return nil
+}
+
+func (d metaCacheDest) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return get(ctx, d.Destination, key)
+}
+
+func (d metaCacheDest) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	return listWritten(ctx, d.Destination)
+}
@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetaCache_sharedBetweenRuns(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	dst := newMockDest()
+	opts := Options{
+		Src:             src,
+		Dst:             dst,
+		DryRun:          true,
+		MetaCache:       filepath.Join(t.TempDir(), "meta.json"),
+		MetaCacheMaxAge: time.Hour,
+	}
+
+	// A dry run stats both files; the real run that follows reuses that.
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.statCalls) != 2 {
+		t.Fatalf("dry run made %d stat calls, want 2", len(dst.statCalls))
+	}
+	opts.DryRun = false
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.statCalls) != 2 || len(dst.putCalls) != 2 {
+		t.Errorf("sync made %d stat calls and %d uploads, want 2 and 2", len(dst.statCalls), len(dst.putCalls))
+	}
+
+	// The uploads were recorded, so the files are now up to date.
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.statCalls) != 2 || len(dst.putCalls) != 2 {
+		t.Errorf("third run made %d stat calls and %d uploads, want none", len(dst.statCalls)-2, len(dst.putCalls)-2)
+	}
+}
+
+func TestMetaCache_expires(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	opts := Options{
+		Src:             src,
+		Dst:             dst,
+		DryRun:          true,
+		MetaCache:       filepath.Join(t.TempDir(), "meta.json"),
+		MetaCacheMaxAge: time.Nanosecond,
+	}
+	for range 2 {
+		if _, err := Sync(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dst.statCalls) != 2 {
+		t.Errorf("made %d stat calls, want 2: the cached answer is stale", len(dst.statCalls))
+	}
+}
+
+func TestMetaCache_list(t *testing.T) {
+	dst := newMockDest()
+	dst.objects["a"] = &ObjectMeta{Size: 1}
+	c := loadMetaCache(filepath.Join(t.TempDir(), "meta.json"), time.Hour, nil)
+	d := metaCacheDest{dst, c}
+	ctx := context.Background()
+
+	if _, err := listKeys(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	dst.objects["b"] = &ObjectMeta{Size: 1} // written by someone else
+	if err := d.Put(ctx, "c", strings.NewReader(""), ObjectMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.c = loadMetaCache(c.path, time.Hour, nil)
+	keys, err := listKeys(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("List = %v, want [c] from the cache", keys)
+	}
+}
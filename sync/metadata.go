@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MetadataRule sets HTTP headers and custom metadata on the objects of the
+// files matching Pattern, as they are uploaded. See Options.Metadata.
+type MetadataRule struct {
+	Pattern string // as for CompareRule
+
+	CacheControl       string
+	ContentDisposition string
+	// ContentEncoding says how the file itself is encoded, such as "gzip"
+	// for a file compressed ahead of time; objects are stored as they are.
+	ContentEncoding string
+
+	// Metadata is custom metadata stored with each object, by names of
+	// lowercase letters, digits and hyphens.
+	Metadata map[string]string
+}
+
+// ParseMetadataRules parses metadata given on the command line, each as
+// pattern:name=value, where name is Cache-Control, Content-Disposition,
+// Content-Encoding or else that of custom metadata: for example
+// "*.html:Cache-Control=no-cache". The settings for a pattern make one
+// rule, and the rules are in the order their patterns first appear.
+func ParseMetadataRules(settings []string) ([]MetadataRule, error) {
+	var rules []MetadataRule
+	index := make(map[string]int) // of each pattern's rule
+	for _, s := range settings {
+		pattern, setting, ok := strings.Cut(s, ":")
+		name, value, ok2 := strings.Cut(setting, "=")
+		if !ok || !ok2 || pattern == "" || name == "" {
+			return nil, fmt.Errorf("metadata %q: want pattern:name=value", s)
+		}
+		i, ok := index[pattern]
+		if !ok {
+			i = len(rules)
+			index[pattern] = i
+			rules = append(rules, MetadataRule{Pattern: pattern})
+		}
+		r := &rules[i]
+		switch strings.ToLower(name) {
+		case "cache-control":
+			r.CacheControl = value
+		case "content-disposition":
+			r.ContentDisposition = value
+		case "content-encoding":
+			r.ContentEncoding = value
+		default:
+			if err := checkMetadataName(name); err != nil {
+				return nil, fmt.Errorf("metadata %q: %w", s, err)
+			}
+			if r.Metadata == nil {
+				r.Metadata = make(map[string]string)
+			}
+			r.Metadata[name] = value
+		}
+	}
+	return rules, nil
+}
+
+// metadataName matches the names custom metadata may have, which S3
+// lowercases.
+var metadataName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// reservedMetadata are the metadata names foldersync stores itself. See
+// objectMetadata.
+var reservedMetadata = map[string]bool{
+	"mtime": true, "size": true, "sparse": true, "compression": true, "transform": true, "chunked": true, "sha256": true,
+	"mode": true, "uid": true, "gid": true, "xattrs": true, sseContextKey: true,
+}
+
+// checkMetadata reports whether opts.Metadata can be used with the rest of
+// opts.
+func checkMetadata(opts Options) error {
+	for _, r := range opts.Metadata {
+		if r.Pattern == "" {
+			return errors.New("metadata rule without a pattern")
+		}
+		if r.ContentEncoding != "" && opts.Compression != "" {
+			return fmt.Errorf("metadata for %s: a Content-Encoding cannot be combined with compression, which stores objects in a format of its own", r.Pattern)
+		}
+		for name := range r.Metadata {
+			if err := checkMetadataName(name); err != nil {
+				return fmt.Errorf("metadata for %s: %w", r.Pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkMetadataName reports whether custom metadata can be called name.
+func checkMetadataName(name string) error {
+	if !metadataName.MatchString(name) {
+		return fmt.Errorf("name %q: want lowercase letters, digits and hyphens", name)
+	}
+	if reservedMetadata[name] {
+		return fmt.Errorf("name %q is reserved for foldersync's own", name)
+	}
+	return nil
+}
+
+// applyMetadata sets the headers and metadata of the first of rules that
+// matches key on meta.
+func applyMetadata(rules []MetadataRule, key string, meta *ObjectMeta) {
+	for _, r := range rules {
+		if matchRule(r.Pattern, key) {
+			meta.CacheControl = r.CacheControl
+			meta.ContentDisposition = r.ContentDisposition
+			meta.ContentEncoding = r.ContentEncoding
+			meta.UserMetadata = r.Metadata
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+)
+
+func TestParseMetadataRules(t *testing.T) {
+	rules, err := ParseMetadataRules([]string{
+		"*.html:Cache-Control=no-cache",
+		"assets/*:Cache-Control=max-age=31536000, immutable",
+		"*.html:owner=web",
+		"*.gz:Content-Encoding=gzip",
+		"*.html:content-disposition=inline",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MetadataRule{
+		{Pattern: "*.html", CacheControl: "no-cache", ContentDisposition: "inline", Metadata: map[string]string{"owner": "web"}},
+		{Pattern: "assets/*", CacheControl: "max-age=31536000, immutable"},
+		{Pattern: "*.gz", ContentEncoding: "gzip"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules %+v, want %+v", rules, want)
+	}
+	for _, s := range []string{"*.html", "Cache-Control=no-cache", ":Cache-Control=no-cache", "*.html:=x", "*:Owner=web", "*:sha256=x"} {
+		if _, err := ParseMetadataRules([]string{s}); err == nil {
+			t.Errorf("ParseMetadataRules(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSync_metadata(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "index.html", "<html>")
+	writeFile(t, src, "app.js.gz", "js")
+	writeFile(t, src, "photo.jpg", "jpg")
+	rules := []MetadataRule{
+		{Pattern: "*.html", CacheControl: "no-cache", Metadata: map[string]string{"owner": "web"}},
+		{Pattern: "*.gz", CacheControl: "max-age=3600", ContentEncoding: "gzip"},
+		{Pattern: "*", CacheControl: "max-age=60"},
+	}
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Metadata: rules}); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]ObjectMeta{
+		"index.html": {CacheControl: "no-cache", UserMetadata: map[string]string{"owner": "web"}},
+		"app.js.gz":  {CacheControl: "max-age=3600", ContentEncoding: "gzip"},
+		"photo.jpg":  {CacheControl: "max-age=60"},
+	} {
+		got := dst.objects[key]
+		if got.CacheControl != want.CacheControl || got.ContentEncoding != want.ContentEncoding || !maps.Equal(got.UserMetadata, want.UserMetadata) {
+			t.Errorf("%s: uploaded with %q, %q, %v; want %q, %q, %v", key,
+				got.CacheControl, got.ContentEncoding, got.UserMetadata, want.CacheControl, want.ContentEncoding, want.UserMetadata)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+	}{
+		{"reserved name", Options{Metadata: []MetadataRule{{Pattern: "*", Metadata: map[string]string{"mtime": "0"}}}}},
+		{"uppercase name", Options{Metadata: []MetadataRule{{Pattern: "*", Metadata: map[string]string{"Owner": "web"}}}}},
+		{"encoding with compression", Options{Metadata: []MetadataRule{{Pattern: "*.gz", ContentEncoding: "gzip"}}, Compression: CompressGzip}},
+	} {
+		tc.opts.Src, tc.opts.Dst = src, newMockDest()
+		if _, err := Sync(context.Background(), tc.opts); err == nil {
+			t.Errorf("%s: Sync succeeded", tc.name)
+		}
+	}
+}
+
+func TestS3Destination_Put_metadata(t *testing.T) {
+	var put *s3.PutObjectInput
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				p, ok := in.Parameters.(*s3.PutObjectInput)
+				if !ok {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", in.Parameters)
+				}
+				put = p
+				return middleware.InitializeOutput{Result: &s3.PutObjectOutput{}}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+
+	meta := ObjectMeta{Size: 1, CacheControl: "no-cache", ContentDisposition: "attachment", UserMetadata: map[string]string{"owner": "web"}}
+	if err := d.Put(context.Background(), "a.txt", strings.NewReader("a"), meta); err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(put.CacheControl) != "no-cache" || aws.ToString(put.ContentDisposition) != "attachment" || put.ContentEncoding != nil {
+		t.Errorf("headers %q, %q, %v; want no-cache, attachment and none", aws.ToString(put.CacheControl), aws.ToString(put.ContentDisposition), put.ContentEncoding)
+	}
+	if put.Metadata["owner"] != "web" || put.Metadata["size"] != "1" {
+		t.Errorf("metadata %v, want owner=web besides foldersync's own", put.Metadata)
+	}
+}
@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	stdsync "sync"
+	"time"
+)
+
+// Metrics collects statistics of the runs it is given through
+// Options.Metrics, for monitoring: it serves them over HTTP in the
+// Prometheus text format, and can push them to a Prometheus Pushgateway.
+// Dry runs are not counted. The zero value is ready to use, and Metrics is
+// safe for concurrent use.
+type Metrics struct {
+	mu           stdsync.Mutex
+	runs         int
+	failures     int
+	uploaded     int
+	deleted      int
+	bytes        int64
+	lastSuccess  time.Time
+	lastDuration time.Duration
+}
+
+// record adds the run described by s.
+func (m *Metrics) record(s Summary) {
+	if m == nil || s.DryRun {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs++
+	m.uploaded += s.Uploaded
+	m.deleted += s.Deleted
+	m.bytes += s.UploadedBytes
+	m.lastDuration = s.Duration
+	if s.Err != nil {
+		m.failures++
+	} else {
+		m.lastSuccess = time.Now()
+	}
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b bytes.Buffer
+	metric := func(name, kind, help string, v any) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+	}
+	metric("foldersync_runs_total", "counter", "Runs finished, successfully or not.", m.runs)
+	metric("foldersync_errors_total", "counter", "Runs that failed.", m.failures)
+	metric("foldersync_files_uploaded_total", "counter", "Files uploaded to the destination.", m.uploaded)
+	metric("foldersync_objects_deleted_total", "counter", "Objects deleted from the destination.", m.deleted)
+	metric("foldersync_bytes_uploaded_total", "counter", "Bytes of files uploaded to the destination.", m.bytes)
+	metric("foldersync_last_run_duration_seconds", "gauge", "How long the last run took.", m.lastDuration.Seconds())
+	// Left out until a run succeeds, so that a push after a failed run
+	// keeps the time the Pushgateway has.
+	if !m.lastSuccess.IsZero() {
+		metric("foldersync_last_success_timestamp_seconds", "gauge", "When the last successful run finished.", m.lastSuccess.Unix())
+	}
+	return b.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics, for Prometheus to scrape.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// Push sends the metrics to the Pushgateway at gateway, such as
+// "http://pushgateway:9091", grouped under job. Metrics of the group that
+// this push leaves out, such as the last success after a failed run, keep
+// their previous values.
+func (m *Metrics) Push(ctx context.Context, gateway, job string) error {
+	var body bytes.Buffer
+	m.WriteTo(&body)
+	u := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push to %s: %s: %s", gateway, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
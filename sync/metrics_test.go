@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_recordsRuns(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	writeFile(t, src, "b.txt", "world!")
+
+	m := new(Metrics)
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: newMockDest(), Metrics: m}); err != nil {
+		t.Fatal(err)
+	}
+	Sync(context.Background(), Options{Src: src, Dst: failingDest{newMockDest()}, Metrics: m})
+	Sync(context.Background(), Options{Src: src, Dst: newMockDest(), Metrics: m, DryRun: true})
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"foldersync_runs_total 2\n",
+		"foldersync_errors_total 1\n",
+		"foldersync_files_uploaded_total 2\n",
+		"foldersync_bytes_uploaded_total 11\n",
+		"# TYPE foldersync_last_success_timestamp_seconds gauge\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, b.String())
+		}
+	}
+}
+
+// failingDest is a mockDest whose uploads fail.
+type failingDest struct{ *mockDest }
+
+func (failingDest) Put(context.Context, string, io.Reader, ObjectMeta) error {
+	return errors.New("put failed")
+}
+
+func TestMetrics_push(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		path, body = r.URL.Path, string(data)
+	}))
+	defer srv.Close()
+
+	// Without a successful run, the last success is left to the gateway.
+	m := new(Metrics)
+	m.record(Summary{Err: errors.New("failed")})
+	if err := m.Push(context.Background(), srv.URL+"/", "nightly photos"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/metrics/job/nightly photos" {
+		t.Errorf("pushed to %s", path)
+	}
+	if !strings.Contains(body, "foldersync_errors_total 1\n") || strings.Contains(body, "last_success") {
+		t.Errorf("pushed:\n%s", body)
+	}
+}
+
+func TestMetrics_pushRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	if err := new(Metrics).Push(context.Background(), srv.URL, "foldersync"); err == nil || !strings.Contains(err.Error(), "bad metrics") {
+		t.Errorf("err = %v, want the gateway's message", err)
+	}
+}
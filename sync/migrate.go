@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// MigrateOptions configures MigratePrefix.
+type MigrateOptions struct {
+	Dst    Destination
+	From   string // key prefix to move objects from
+	To     string // key prefix to move them to
+	Delete bool   // if true, delete the originals once every object is copied
+	DryRun bool   // if true, print actions without making changes
+
+	// Log, if set, receives each copy and delete as it is made.
+	Log io.Writer
+
+	// SigningKey re-signs the manifest at the root of Dst if the migration
+	// changes it. It is required if that manifest is signed.
+	SigningKey ed25519.PrivateKey
+	// VerifyKey, if set, is the key that manifest must be signed with;
+	// nothing is moved if it does not verify.
+	VerifyKey ed25519.PublicKey
+}
+
+// MigratePrefix moves every object under opts.From to the same relative key
+// under opts.To using server-side copies, so nothing is downloaded or
+// uploaded again. Manifests stored under opts.From move with the objects
+// they describe; entries under opts.From in the manifest at the root of
+// opts.Dst are rewritten. Objects already copied by an earlier, interrupted
+// migration are not copied again.
+func MigratePrefix(ctx context.Context, opts MigrateOptions) error {
+	from, to := strings.Trim(opts.From, "/"), strings.Trim(opts.To, "/")
+	if from == "" || to == "" {
+		return errors.New("both prefixes are required")
+	}
+	if from == to || strings.HasPrefix(to+"/", from+"/") || strings.HasPrefix(from+"/", to+"/") {
+		return fmt.Errorf("prefixes %q and %q overlap", from, to)
+	}
+	if strings.HasPrefix(from+"/", metaPrefix) || strings.HasPrefix(to+"/", metaPrefix) {
+		return fmt.Errorf("prefixes under %s are reserved", metaPrefix)
+	}
+
+	manifest, err := migrateManifest(ctx, opts, from, to)
+	if err != nil {
+		return err
+	}
+
+	keys, err := listKeys(ctx, opts.Dst)
+	if err != nil {
+		return err
+	}
+	slices.Sort(keys)
+	var moved []string
+	for _, key := range keys {
+		if !strings.HasPrefix(key, from+"/") {
+			continue
+		}
+		newKey := to + strings.TrimPrefix(key, from)
+		moved = append(moved, key)
+
+		fmt.Fprintf(logTo(opts.Log), "copy %s -> %s\n", key, newKey)
+		if opts.DryRun {
+			continue
+		}
+		if err := migrateObject(ctx, opts.Dst, key, newKey); err != nil {
+			return &FileError{Op: "copy", Key: key, Err: err}
+		}
+	}
+	if len(moved) == 0 {
+		return fmt.Errorf("no objects under %s/", from)
+	}
+
+	if manifest != nil && !opts.DryRun {
+		if err := WriteManifest(ctx, opts.Dst, manifest, opts.SigningKey); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Delete {
+		return nil
+	}
+	for _, key := range moved {
+		fmt.Fprintf(logTo(opts.Log), "delete %s\n", key)
+		if opts.DryRun {
+			continue
+		}
+		if err := opts.Dst.Delete(ctx, key); err != nil {
+			return &FileError{Op: "delete", Key: key, Err: err}
+		}
+	}
+	return nil
+}
+
+// migrateObject copies key to newKey unless an earlier run already did.
+func migrateObject(ctx context.Context, dst Destination, key, newKey string) error {
+	src, err := dst.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	existing, err := dst.Stat(ctx, newKey)
+	if err != nil {
+		return err
+	}
+	if existing != nil && src != nil && existing.Size == src.Size && existing.ModTime.Equal(src.ModTime) {
+		return nil
+	}
+	return copyObject(ctx, dst, key, newKey)
+}
+
+// migrateManifest returns the root manifest of opts.Dst with entries under
+// from moved to to, or nil if there is no manifest or it has no such
+// entries. It fails before anything is copied if the manifest does not
+// verify with opts.VerifyKey, or if it is signed and opts.SigningKey is not
+// set, since writing it unsigned would make every later verification fail.
+func migrateManifest(ctx context.Context, opts MigrateOptions, from, to string) (*Manifest, error) {
+	m, err := ReadManifest(ctx, opts.Dst, opts.VerifyKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for i, e := range m.Files {
+		if strings.HasPrefix(e.Key, from+"/") {
+			m.Files[i].Key = to + strings.TrimPrefix(e.Key, from)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	slices.SortFunc(m.Files, func(a, b ManifestEntry) int { return strings.Compare(a.Key, b.Key) })
+
+	if opts.SigningKey == nil {
+		sig, err := opts.Dst.Stat(ctx, ManifestSigKey)
+		if err != nil {
+			return nil, err
+		}
+		if sig != nil {
+			return nil, errors.New("the manifest is signed; a signing key is needed to update it")
+		}
+	}
+	return m, nil
+}
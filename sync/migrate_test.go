@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMigratePrefix(t *testing.T) {
+	dst := newMockDest()
+	for _, key := range []string{"old/a.txt", "old/sub/b.txt", "older/c.txt", "other/d.txt"} {
+		dst.objects[key] = &ObjectMeta{Size: 1}
+		dst.data[key] = []byte("x")
+	}
+
+	err := MigratePrefix(context.Background(), MigrateOptions{Dst: dst, From: "old/", To: "new/", Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for k := range dst.objects {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	want := []string{"new/a.txt", "new/sub/b.txt", "older/c.txt", "other/d.txt"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("objects were uploaded instead of copied: %v", dst.putCalls)
+	}
+}
+
+func TestMigratePrefix_keepsOriginalsAndResumes(t *testing.T) {
+	dst := newMockDest()
+	mtime := time.Unix(1700000000, 0)
+	for _, key := range []string{"old/a.txt", "old/b.txt", "new/a.txt"} {
+		dst.objects[key] = &ObjectMeta{Size: 1, ModTime: mtime}
+	}
+
+	err := MigratePrefix(context.Background(), MigrateOptions{Dst: dst, From: "old", To: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"old/b.txt"}; !slices.Equal(dst.copyCalls, want) {
+		t.Errorf("copied %v, want %v", dst.copyCalls, want)
+	}
+	if len(dst.deleteCalls) != 0 {
+		t.Errorf("deleted %v without Delete", dst.deleteCalls)
+	}
+}
+
+func TestMigratePrefix_rejectsOverlap(t *testing.T) {
+	for _, tc := range []struct{ from, to string }{
+		{"a", "a/"},
+		{"a", "a/b"},
+		{"a/b", "a"},
+		{"", "b"},
+		{"a", ".foldersync"},
+	} {
+		err := MigratePrefix(context.Background(), MigrateOptions{Dst: newMockDest(), From: tc.from, To: tc.to})
+		if err == nil {
+			t.Errorf("%q -> %q: expected an error", tc.from, tc.to)
+		}
+	}
+}
+
+func TestMigratePrefix_rewritesSignedManifest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	dst := newMockDest()
+	dst.objects["old/a.txt"] = &ObjectMeta{Size: 1}
+	m := &Manifest{Files: []ManifestEntry{{Key: "keep.txt"}, {Key: "old/a.txt"}}}
+	if err := WriteManifest(ctx, dst, m, priv); err != nil {
+		t.Fatal(err)
+	}
+
+	err = MigratePrefix(ctx, MigrateOptions{Dst: dst, From: "old", To: "new"})
+	if err == nil {
+		t.Fatal("expected an error without a signing key")
+	}
+	if len(dst.copyCalls) != 0 {
+		t.Fatalf("copied %v before refusing", dst.copyCalls)
+	}
+
+	if err := MigratePrefix(ctx, MigrateOptions{Dst: dst, From: "old", To: "new", SigningKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadManifest(ctx, dst, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Files) != 2 || got.Files[1].Key != "new/a.txt" {
+		t.Errorf("manifest = %+v, want old/a.txt moved to new/a.txt", got.Files)
+	}
+}
@@ -0,0 +1,466 @@
+package sync
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	gosync "sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mount serves fsys, read-only, as a FUSE file system mounted at dir until
+// ctx is done or it is unmounted, as with "fusermount -u dir", and then
+// unmounts it. Files fsys opens must be io.ReaderAts, as those of a
+// BackupFS are. Mounting takes root, or fusermount3 or fusermount from
+// libfuse on the PATH. Only the user who mounted the file system can see
+// into it, from other processes: the calling process may deadlock if it
+// reads the file system itself. Errors reading fsys are reported to
+// warnings, as well as to the process reading.
+func Mount(ctx context.Context, fsys fs.FS, dir string, warnings io.Writer) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	fd, unmount, err := fuseMount(dir)
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", dir, err)
+	}
+	defer unix.Close(fd)
+	stop := context.AfterFunc(ctx, func() { unmount() })
+	defer stop()
+
+	err = newFuseServer(fsys, fd, warnings).serve()
+	if stop() {
+		unmount() // unless ctx has, or it was unmounted already
+	}
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", dir, err)
+	}
+	return nil
+}
+
+// fuseMount mounts a FUSE file system at dir, and returns the descriptor
+// of /dev/fuse to serve it on and a function that unmounts it.
+func fuseMount(dir string) (int, func() error, error) {
+	if os.Geteuid() == 0 {
+		fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, nil, err
+		}
+		opts := fmt.Sprintf("fd=%d,rootmode=%o,user_id=0,group_id=0", fd, unix.S_IFDIR)
+		if err := unix.Mount("foldersync", dir, "fuse.foldersync", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, opts); err != nil {
+			unix.Close(fd)
+			return -1, nil, err
+		}
+		return fd, func() error { return unix.Unmount(dir, unix.MNT_DETACH) }, nil
+	}
+
+	// fusermount mounts it for us, and passes the descriptor back over
+	// a socket.
+	bin, err := exec.LookPath("fusermount3")
+	if err != nil {
+		if bin, err = exec.LookPath("fusermount"); err != nil {
+			return -1, nil, errors.New("mounting takes root, or fusermount3 or fusermount from libfuse")
+		}
+	}
+	pair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	defer unix.Close(pair[1])
+	theirs := os.NewFile(uintptr(pair[0]), "fusermount")
+	cmd := exec.Command(bin, "-o", "ro,nosuid,nodev,fsname=foldersync,subtype=foldersync", "--", dir)
+	cmd.ExtraFiles = []*os.File{theirs}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	out, err := cmd.CombinedOutput()
+	theirs.Close()
+	if err != nil {
+		return -1, nil, fmt.Errorf("%s: %v: %s", filepath.Base(bin), err, strings.TrimSpace(string(out)))
+	}
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(pair[1], make([]byte, 1), oob, 0)
+	if err != nil {
+		return -1, nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return -1, nil, fmt.Errorf("%s passed no descriptor back: %v", filepath.Base(bin), err)
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) == 0 {
+		return -1, nil, fmt.Errorf("%s passed no descriptor back: %v", filepath.Base(bin), err)
+	}
+	return fds[0], func() error { return exec.Command(bin, "-u", "-z", dir).Run() }, nil
+}
+
+// The parts of the FUSE protocol, version 7, that a read-only file system
+// needs; see linux/fuse.h.
+const (
+	fuseLookup      = 1
+	fuseForget      = 2
+	fuseGetattr     = 3
+	fuseOpen        = 14
+	fuseRead        = 15
+	fuseStatfs      = 17
+	fuseRelease     = 18
+	fuseInit        = 26
+	fuseOpendir     = 27
+	fuseReaddir     = 28
+	fuseReleasedir  = 29
+	fuseAccess      = 34
+	fuseInterrupt   = 36
+	fuseDestroy     = 38
+	fuseBatchForget = 42
+
+	fuseRootID       = 1
+	fuseMinor        = 31
+	fuseAsyncRead    = 1 << 0 // init flag
+	fuseKeepCache    = 1 << 1 // open flag
+	fuseInHeaderSize = 40
+	fuseMaxRead      = 128 << 10
+	fuseValid        = 60 // seconds the kernel may cache entries and attributes
+)
+
+// fuseServer answers the requests of the kernel for a mounted fs.FS.
+// Nodes are numbered by path as the kernel first looks them up, and keep
+// their numbers while mounted.
+type fuseServer struct {
+	fsys     fs.FS
+	fd       int
+	uid, gid uint32
+	warnings io.Writer
+
+	mu      gosync.Mutex
+	paths   map[uint64]string // by node
+	ids     map[string]uint64 // by path
+	handles map[uint64]any    // open fs.Files and *fuseDirs
+	next    uint64            // last node or handle number given out
+}
+
+// newFuseServer returns a fuseServer answering the requests read from fd,
+// the descriptor of a mounted FUSE file system, with fsys.
+func newFuseServer(fsys fs.FS, fd int, warnings io.Writer) *fuseServer {
+	return &fuseServer{
+		fsys: fsys, fd: fd, uid: uint32(os.Getuid()), gid: uint32(os.Getgid()), warnings: warnings,
+		paths:   map[uint64]string{fuseRootID: "."},
+		ids:     map[string]uint64{".": fuseRootID},
+		handles: map[uint64]any{},
+		next:    fuseRootID,
+	}
+}
+
+// fuseDir is an open directory.
+type fuseDir struct {
+	path    string
+	entries []fs.DirEntry
+}
+
+// serve answers requests until the file system is unmounted.
+func (s *fuseServer) serve() error {
+	buf := make([]byte, fuseMaxRead+64<<10)
+	for {
+		n, err := unix.Read(s.fd, buf)
+		switch {
+		case err == unix.ENODEV:
+			return nil // unmounted
+		case err == unix.EINTR || err == unix.EAGAIN || err == unix.ENOENT:
+			continue
+		case err != nil:
+			return err
+		case n < fuseInHeaderSize:
+			continue
+		}
+		req := slices.Clone(buf[:n])
+		switch binary.NativeEndian.Uint32(req[4:]) {
+		case fuseInit:
+			s.init(req)
+		case fuseForget, fuseBatchForget, fuseInterrupt:
+			// Nodes are kept, and requests run to the end.
+		default:
+			go s.handle(req)
+		}
+	}
+}
+
+func (s *fuseServer) reply(req []byte, errno syscall.Errno, out []byte) {
+	msg := make([]byte, 16, 16+len(out))
+	binary.NativeEndian.PutUint32(msg[0:], uint32(16+len(out)))
+	binary.NativeEndian.PutUint32(msg[4:], uint32(-int32(errno)))
+	copy(msg[8:16], req[8:16]) // unique
+	unix.Write(s.fd, append(msg, out...))
+}
+
+func (s *fuseServer) init(req []byte) {
+	in := req[fuseInHeaderSize:]
+	if len(in) < 16 || binary.NativeEndian.Uint32(in) != 7 {
+		s.reply(req, unix.EPROTO, nil)
+		return
+	}
+	minor := min(binary.NativeEndian.Uint32(in[4:]), fuseMinor)
+	var out []byte
+	out = binary.NativeEndian.AppendUint32(out, 7)
+	out = binary.NativeEndian.AppendUint32(out, minor)
+	out = binary.NativeEndian.AppendUint32(out, binary.NativeEndian.Uint32(in[8:])) // max_readahead
+	out = binary.NativeEndian.AppendUint32(out, binary.NativeEndian.Uint32(in[12:])&fuseAsyncRead)
+	out = binary.NativeEndian.AppendUint16(out, 16) // max_background
+	out = binary.NativeEndian.AppendUint16(out, 12) // congestion_threshold
+	out = binary.NativeEndian.AppendUint32(out, fuseMaxRead)
+	out = binary.NativeEndian.AppendUint32(out, 1) // time_gran
+	out = append(out, make([]byte, 64-len(out))...)
+	s.reply(req, 0, out)
+}
+
+func (s *fuseServer) handle(req []byte) {
+	node := binary.NativeEndian.Uint64(req[16:])
+	in := req[fuseInHeaderSize:]
+	s.mu.Lock()
+	p, ok := s.paths[node]
+	s.mu.Unlock()
+	if !ok {
+		s.reply(req, unix.ENOENT, nil)
+		return
+	}
+	var out []byte
+	var err error
+	switch op := binary.NativeEndian.Uint32(req[4:]); op {
+	case fuseLookup:
+		out, err = s.lookup(p, strings.TrimRight(string(in), "\x00"))
+	case fuseGetattr:
+		out, err = s.getattr(node, p)
+	case fuseOpen:
+		out, err = s.open(p, binary.NativeEndian.Uint32(in))
+	case fuseRead:
+		out, err = s.read(in)
+	case fuseRelease, fuseReleasedir:
+		err = s.release(binary.NativeEndian.Uint64(in))
+	case fuseOpendir:
+		out, err = s.opendir(p)
+	case fuseReaddir:
+		out, err = s.readdir(node, in)
+	case fuseStatfs:
+		out = make([]byte, 80)
+		binary.NativeEndian.PutUint32(out[40:], 4096) // bsize
+		binary.NativeEndian.PutUint32(out[44:], 255)  // namelen
+		binary.NativeEndian.PutUint32(out[48:], 4096) // frsize
+	case fuseAccess:
+		if binary.NativeEndian.Uint32(in)&unix.W_OK != 0 {
+			err = unix.EROFS
+		}
+	case fuseDestroy:
+	default:
+		err = unix.ENOSYS
+	}
+	if err != nil {
+		errno := fuseErrno(err)
+		if errno == unix.EIO {
+			warnf(s.warnings, "%s: %v", p, err)
+		}
+		s.reply(req, errno, nil)
+		return
+	}
+	s.reply(req, 0, out)
+}
+
+// fuseErrno returns the errno that reports err to the kernel.
+func fuseErrno(err error) syscall.Errno {
+	var errno syscall.Errno
+	switch {
+	case errors.As(err, &errno):
+		return errno
+	case errors.Is(err, fs.ErrNotExist):
+		return unix.ENOENT
+	case errors.Is(err, fs.ErrPermission):
+		return unix.EACCES
+	case errors.Is(err, fs.ErrInvalid):
+		return unix.EINVAL
+	}
+	return unix.EIO
+}
+
+// id returns the node number of the path p, giving it one if it has none.
+func (s *fuseServer) id(p string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.ids[p]; ok {
+		return id
+	}
+	s.next++
+	s.ids[p], s.paths[s.next] = s.next, p
+	return s.next
+}
+
+func (s *fuseServer) lookup(dir, name string) ([]byte, error) {
+	p := path.Join(dir, name)
+	info, err := fs.Stat(s.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	id := s.id(p)
+	var out []byte
+	out = binary.NativeEndian.AppendUint64(out, id)
+	out = binary.NativeEndian.AppendUint64(out, 0) // generation
+	out = binary.NativeEndian.AppendUint64(out, fuseValid)
+	out = binary.NativeEndian.AppendUint64(out, fuseValid)
+	out = binary.NativeEndian.AppendUint64(out, 0) // nanoseconds of both
+	return s.appendAttr(out, id, info), nil
+}
+
+func (s *fuseServer) getattr(node uint64, p string) ([]byte, error) {
+	info, err := fs.Stat(s.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	out = binary.NativeEndian.AppendUint64(out, fuseValid)
+	out = binary.NativeEndian.AppendUint64(out, 0) // nanoseconds, padding
+	return s.appendAttr(out, node, info), nil
+}
+
+// appendAttr appends the struct fuse_attr of the node numbered ino to b.
+func (s *fuseServer) appendAttr(b []byte, ino uint64, info fs.FileInfo) []byte {
+	mode, nlink, size := uint32(info.Mode().Perm())|unix.S_IFREG, uint32(1), uint64(info.Size())
+	if info.IsDir() {
+		mode, nlink, size = uint32(info.Mode().Perm())|unix.S_IFDIR, 2, 0
+	}
+	var sec, nsec int64
+	if t := info.ModTime(); !t.IsZero() {
+		sec, nsec = t.Unix(), int64(t.Nanosecond())
+	}
+	b = binary.NativeEndian.AppendUint64(b, ino)
+	b = binary.NativeEndian.AppendUint64(b, size)
+	b = binary.NativeEndian.AppendUint64(b, (size+511)/512) // blocks
+	for range 3 {                                           // atime, mtime, ctime
+		b = binary.NativeEndian.AppendUint64(b, uint64(sec))
+	}
+	for range 3 {
+		b = binary.NativeEndian.AppendUint32(b, uint32(nsec))
+	}
+	b = binary.NativeEndian.AppendUint32(b, mode)
+	b = binary.NativeEndian.AppendUint32(b, nlink)
+	b = binary.NativeEndian.AppendUint32(b, s.uid)
+	b = binary.NativeEndian.AppendUint32(b, s.gid)
+	b = binary.NativeEndian.AppendUint32(b, 0)    // rdev
+	b = binary.NativeEndian.AppendUint32(b, 4096) // blksize
+	return binary.NativeEndian.AppendUint32(b, 0) // flags
+}
+
+// handle stores h as an open file or directory, and returns the struct
+// fuse_open_out for it.
+func (s *fuseServer) newHandle(h any, flags uint32) []byte {
+	s.mu.Lock()
+	s.next++
+	fh := s.next
+	s.handles[fh] = h
+	s.mu.Unlock()
+	out := binary.NativeEndian.AppendUint64(nil, fh)
+	out = binary.NativeEndian.AppendUint32(out, flags)
+	return binary.NativeEndian.AppendUint32(out, 0)
+}
+
+func (s *fuseServer) open(p string, flags uint32) ([]byte, error) {
+	if flags&unix.O_ACCMODE != unix.O_RDONLY {
+		return nil, unix.EROFS
+	}
+	f, err := s.fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.(io.ReaderAt); !ok {
+		f.Close()
+		return nil, fmt.Errorf("open %s: %T is not an io.ReaderAt", p, f)
+	}
+	// The content of a backup does not change while it is mounted.
+	return s.newHandle(f, fuseKeepCache), nil
+}
+
+func (s *fuseServer) read(in []byte) ([]byte, error) {
+	fh, off, size := binary.NativeEndian.Uint64(in), int64(binary.NativeEndian.Uint64(in[8:])), binary.NativeEndian.Uint32(in[16:])
+	s.mu.Lock()
+	f, ok := s.handles[fh].(io.ReaderAt)
+	s.mu.Unlock()
+	if !ok {
+		return nil, unix.EBADF
+	}
+	buf := make([]byte, min(size, fuseMaxRead))
+	n, err := f.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		// Reads past the end find nothing, rather than failing as some
+		// ReaderAts do.
+		if info, serr := f.(fs.File).Stat(); serr != nil || off < info.Size() {
+			return nil, err
+		}
+		n = 0
+	}
+	return buf[:n], nil
+}
+
+func (s *fuseServer) release(fh uint64) error {
+	s.mu.Lock()
+	h := s.handles[fh]
+	delete(s.handles, fh)
+	s.mu.Unlock()
+	if f, ok := h.(fs.File); ok {
+		f.Close()
+	}
+	return nil
+}
+
+func (s *fuseServer) opendir(p string) ([]byte, error) {
+	entries, err := fs.ReadDir(s.fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	return s.newHandle(&fuseDir{path: p, entries: entries}, 0), nil
+}
+
+// readdir returns the struct fuse_dirents of the entries of a directory
+// from the offset asked for, as many as fit. Entry i is at offset i, after
+// "." and "..".
+func (s *fuseServer) readdir(node uint64, in []byte) ([]byte, error) {
+	fh, off, size := binary.NativeEndian.Uint64(in), binary.NativeEndian.Uint64(in[8:]), int(binary.NativeEndian.Uint32(in[16:]))
+	s.mu.Lock()
+	d, ok := s.handles[fh].(*fuseDir)
+	s.mu.Unlock()
+	if !ok {
+		return nil, unix.EBADF
+	}
+	var out []byte
+	for i := off; i < uint64(len(d.entries))+2; i++ {
+		name, ino, typ := "", node, uint32(unix.DT_DIR)
+		switch i {
+		case 0:
+			name = "."
+		case 1:
+			name = ".."
+		default:
+			e := d.entries[i-2]
+			name, ino = e.Name(), s.id(path.Join(d.path, e.Name()))
+			if !e.IsDir() {
+				typ = unix.DT_REG
+			}
+		}
+		n := 24 + len(name)
+		n += -n & 7 // padded to 8 bytes
+		if len(out)+n > size {
+			break
+		}
+		out = binary.NativeEndian.AppendUint64(out, ino)
+		out = binary.NativeEndian.AppendUint64(out, i+1) // offset of the next
+		out = binary.NativeEndian.AppendUint32(out, uint32(len(name)))
+		out = binary.NativeEndian.AppendUint32(out, typ)
+		out = append(out, name...)
+		out = append(out, make([]byte, n-24-len(name))...)
+	}
+	return out, nil
+}
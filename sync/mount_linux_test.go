@@ -0,0 +1,338 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMount(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting takes root")
+	}
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "docs/b.txt", strings.Repeat("b", 300<<10))
+	dst := NewLocalDestination(t.TempDir())
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenBackupFS(context.Background(), RestoreOptions{From: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Mount(ctx, b, dir, nil) }()
+	defer cancel()
+	// The mount is read by other processes: one that serves a FUSE file
+	// system cannot safely read it itself.
+	run := func(name string, args ...string) (string, error) {
+		out, err := exec.Command(name, args...).CombinedOutput()
+		return string(out), err
+	}
+	for {
+		if out, _ := run("ls", dir); out != "" {
+			break
+		}
+		select {
+		case err := <-done:
+			if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENODEV) || errors.Is(err, unix.ENOENT) {
+				t.Skipf("cannot mount here: %v", err)
+			}
+			t.Fatalf("Mount = %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Mount = %v", err)
+		}
+	}()
+
+	if out, err := run("ls", dir); err != nil || out != "a.txt\ndocs\n" {
+		t.Errorf("ls = %q, %v; want a.txt and docs", out, err)
+	}
+	if out, err := run("cat", filepath.Join(dir, "docs/b.txt")); err != nil || out != strings.Repeat("b", 300<<10) {
+		t.Errorf("cat docs/b.txt = %d bytes, %v", len(out), err)
+	}
+	want, _ := os.Stat(filepath.Join(src, "a.txt"))
+	if out, err := run("stat", "-c", "%s %Y", filepath.Join(dir, "a.txt")); err != nil || out != fmt.Sprintf("5 %d\n", want.ModTime().Unix()) {
+		t.Errorf("stat a.txt = %q, %v; want the size and mtime of the file", out, err)
+	}
+	if out, err := run("touch", filepath.Join(dir, "a.txt")); err == nil || !strings.Contains(out, "Read-only") {
+		t.Errorf("touch a.txt = %q, %v; want a read-only file system", out, err)
+	}
+}
+
+// fuseTester sends requests to a fuseServer over a socket pair, as the
+// kernel does over /dev/fuse, and returns its replies.
+type fuseTester struct {
+	t      *testing.T
+	s      *fuseServer
+	fd     int // the kernel's end
+	unique uint64
+}
+
+func newFuseTester(t *testing.T, fsys fs.FS) *fuseTester {
+	t.Helper()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unix.Close(fds[0]); unix.Close(fds[1]) })
+	return &fuseTester{t: t, s: newFuseServer(fsys, fds[1], nil), fd: fds[0]}
+}
+
+// call sends the request op for node with the body in, and returns the
+// errno and body of the reply.
+func (ft *fuseTester) call(op uint32, node uint64, in []byte) (syscall.Errno, []byte) {
+	ft.t.Helper()
+	ft.unique++
+	req := binary.NativeEndian.AppendUint32(nil, uint32(fuseInHeaderSize+len(in)))
+	req = binary.NativeEndian.AppendUint32(req, op)
+	req = binary.NativeEndian.AppendUint64(req, ft.unique)
+	req = binary.NativeEndian.AppendUint64(req, node)
+	req = append(req, make([]byte, 16)...) // uid, gid, pid, padding
+	req = append(req, in...)
+	if op == fuseInit {
+		ft.s.init(req)
+	} else {
+		ft.s.handle(req)
+	}
+
+	buf := make([]byte, fuseMaxRead+4096)
+	n, err := unix.Read(ft.fd, buf)
+	if err != nil {
+		ft.t.Fatal(err)
+	}
+	if n < 16 || int(binary.NativeEndian.Uint32(buf)) != n {
+		ft.t.Fatalf("reply of %d bytes, with length %d", n, binary.NativeEndian.Uint32(buf))
+	}
+	if got := binary.NativeEndian.Uint64(buf[8:]); got != ft.unique {
+		ft.t.Fatalf("reply to request %d, want %d", got, ft.unique)
+	}
+	return syscall.Errno(-int32(binary.NativeEndian.Uint32(buf[4:]))), buf[16:n]
+}
+
+// fuseArgs encodes the fields of a request body.
+func fuseArgs(fields ...any) []byte {
+	var b bytes.Buffer
+	for _, f := range fields {
+		binary.Write(&b, binary.NativeEndian, f)
+	}
+	return b.Bytes()
+}
+
+// fuseAttr is the start of a struct fuse_attr.
+type fuseAttr struct {
+	Ino, Size, Blocks, Atime, Mtime, Ctime uint64
+	AtimeNsec, MtimeNsec, CtimeNsec, Mode  uint32
+}
+
+func parseFuseAttr(t *testing.T, b []byte) fuseAttr {
+	t.Helper()
+	var a fuseAttr
+	if err := binary.Read(bytes.NewReader(b), binary.NativeEndian, &a); err != nil {
+		t.Fatalf("attributes of %d bytes: %v", len(b), err)
+	}
+	return a
+}
+
+func mountTestFS() fstest.MapFS {
+	mtime := time.Unix(1700000000, 500)
+	return fstest.MapFS{
+		"a.txt":      {Data: []byte("alpha"), Mode: 0644, ModTime: mtime},
+		"docs/b.txt": {Data: bytes.Repeat([]byte("b"), 300<<10), Mode: 0644, ModTime: mtime},
+	}
+}
+
+func TestFuseServer_init(t *testing.T) {
+	ft := newFuseTester(t, mountTestFS())
+	errno, out := ft.call(fuseInit, 0, fuseArgs(uint32(7), uint32(38), uint32(128<<10), uint32(fuseAsyncRead|1<<5)))
+	if errno != 0 || len(out) != 64 {
+		t.Fatalf("init = %v, %d bytes", errno, len(out))
+	}
+	major, minor := binary.NativeEndian.Uint32(out), binary.NativeEndian.Uint32(out[4:])
+	flags, maxWrite := binary.NativeEndian.Uint32(out[12:]), binary.NativeEndian.Uint32(out[20:])
+	if major != 7 || minor != fuseMinor || flags != fuseAsyncRead || maxWrite != fuseMaxRead {
+		t.Errorf("init = 7.%d (major %d), flags %#x, max write %d", minor, major, flags, maxWrite)
+	}
+
+	if errno, _ := ft.call(fuseInit, 0, fuseArgs(uint32(6), uint32(0), uint32(0), uint32(0))); errno != unix.EPROTO {
+		t.Errorf("init of protocol 6 = %v, want EPROTO", errno)
+	}
+}
+
+func TestFuseServer_lookup(t *testing.T) {
+	ft := newFuseTester(t, mountTestFS())
+	errno, out := ft.call(fuseLookup, fuseRootID, []byte("a.txt\x00"))
+	if errno != 0 || len(out) < 40+64 {
+		t.Fatalf("lookup a.txt = %v, %d bytes", errno, len(out))
+	}
+	node := binary.NativeEndian.Uint64(out)
+	if node == fuseRootID || binary.NativeEndian.Uint64(out[16:]) != fuseValid {
+		t.Errorf("lookup a.txt = node %d, valid for %ds", node, binary.NativeEndian.Uint64(out[16:]))
+	}
+	a := parseFuseAttr(t, out[40:])
+	if a.Ino != node || a.Size != 5 || a.Mtime != 1700000000 || a.MtimeNsec != 500 || a.Mode != unix.S_IFREG|0644 {
+		t.Errorf("attributes of a.txt = %+v", a)
+	}
+
+	// The same path keeps its node; getattr answers for it.
+	if _, again := ft.call(fuseLookup, fuseRootID, []byte("a.txt\x00")); binary.NativeEndian.Uint64(again) != node {
+		t.Errorf("a.txt looked up again as node %d, want %d", binary.NativeEndian.Uint64(again), node)
+	}
+	errno, out = ft.call(fuseGetattr, node, fuseArgs(uint32(0), uint32(0), uint64(0)))
+	if errno != 0 || parseFuseAttr(t, out[16:]).Size != 5 {
+		t.Errorf("getattr a.txt = %v, %+v", errno, parseFuseAttr(t, out[16:]))
+	}
+
+	errno, out = ft.call(fuseLookup, fuseRootID, []byte("docs\x00"))
+	if a := parseFuseAttr(t, out[40:]); errno != 0 || a.Mode&unix.S_IFMT != unix.S_IFDIR {
+		t.Errorf("lookup docs = %v, mode %o; want a directory", errno, a.Mode)
+	}
+	if errno, _ := ft.call(fuseLookup, fuseRootID, []byte("missing\x00")); errno != unix.ENOENT {
+		t.Errorf("lookup of a missing file = %v, want ENOENT", errno)
+	}
+	if errno, _ := ft.call(fuseGetattr, 99, nil); errno != unix.ENOENT {
+		t.Errorf("getattr of an unknown node = %v, want ENOENT", errno)
+	}
+	const fuseMkdir = 9
+	if errno, _ := ft.call(fuseMkdir, fuseRootID, []byte("new\x00")); errno != unix.ENOSYS {
+		t.Errorf("mkdir = %v, want ENOSYS", errno)
+	}
+}
+
+// fuseDirent is an entry of a readdir reply.
+type fuseDirent struct {
+	ino, off uint64
+	typ      uint32
+	name     string
+}
+
+func parseFuseDirents(b []byte) []fuseDirent {
+	var ents []fuseDirent
+	for len(b) >= 24 {
+		n := binary.NativeEndian.Uint32(b[16:])
+		ents = append(ents, fuseDirent{
+			ino: binary.NativeEndian.Uint64(b), off: binary.NativeEndian.Uint64(b[8:]),
+			typ: binary.NativeEndian.Uint32(b[20:]), name: string(b[24 : 24+n]),
+		})
+		size := 24 + int(n)
+		b = b[size+(-size&7):]
+	}
+	return ents
+}
+
+func TestFuseServer_readdir(t *testing.T) {
+	ft := newFuseTester(t, mountTestFS())
+	errno, out := ft.call(fuseOpendir, fuseRootID, fuseArgs(uint32(0), uint32(0)))
+	if errno != 0 {
+		t.Fatalf("opendir = %v", errno)
+	}
+	fh := binary.NativeEndian.Uint64(out)
+
+	// A buffer too small for every entry takes them from the offset of
+	// the last one returned.
+	var names []string
+	var types []uint32
+	for off := uint64(0); ; {
+		errno, out := ft.call(fuseReaddir, fuseRootID, fuseArgs(fh, off, uint32(64), uint32(0)))
+		if errno != 0 {
+			t.Fatalf("readdir from %d = %v", off, errno)
+		}
+		ents := parseFuseDirents(out)
+		if len(ents) == 0 {
+			break
+		}
+		if len(ents) > 2 {
+			t.Errorf("readdir into 64 bytes = %d entries", len(ents))
+		}
+		for _, e := range ents {
+			names, types = append(names, e.name), append(types, e.typ)
+			off = e.off
+		}
+	}
+	if want := []string{".", "..", "a.txt", "docs"}; !slices.Equal(names, want) {
+		t.Errorf("readdir = %q, want %q", names, want)
+	}
+	if want := []uint32{unix.DT_DIR, unix.DT_DIR, unix.DT_REG, unix.DT_DIR}; !slices.Equal(types, want) {
+		t.Errorf("readdir types = %v, want %v", types, want)
+	}
+
+	// Entries name the nodes lookup gives the same paths.
+	_, out = ft.call(fuseReaddir, fuseRootID, fuseArgs(fh, uint64(2), uint32(4096), uint32(0)))
+	_, entry := ft.call(fuseLookup, fuseRootID, []byte("a.txt\x00"))
+	if ents := parseFuseDirents(out); len(ents) != 2 || ents[0].ino != binary.NativeEndian.Uint64(entry) {
+		t.Errorf("readdir from 2 = %+v, want a.txt as node %d first", ents, binary.NativeEndian.Uint64(entry))
+	}
+
+	if errno, _ := ft.call(fuseReleasedir, fuseRootID, fuseArgs(fh, uint32(0), uint32(0), uint64(0))); errno != 0 {
+		t.Errorf("releasedir = %v", errno)
+	}
+	if errno, _ := ft.call(fuseReaddir, fuseRootID, fuseArgs(fh, uint64(0), uint32(4096), uint32(0))); errno != unix.EBADF {
+		t.Errorf("readdir of a released handle = %v, want EBADF", errno)
+	}
+}
+
+func TestFuseServer_read(t *testing.T) {
+	fsys := mountTestFS()
+	ft := newFuseTester(t, fsys)
+	_, entry := ft.call(fuseLookup, fuseRootID, []byte("docs\x00"))
+	_, entry = ft.call(fuseLookup, binary.NativeEndian.Uint64(entry), []byte("b.txt\x00"))
+	node := binary.NativeEndian.Uint64(entry)
+
+	if errno, _ := ft.call(fuseOpen, node, fuseArgs(uint32(unix.O_RDWR), uint32(0))); errno != unix.EROFS {
+		t.Errorf("open for writing = %v, want EROFS", errno)
+	}
+	errno, out := ft.call(fuseOpen, node, fuseArgs(uint32(unix.O_RDONLY), uint32(0)))
+	if errno != 0 || binary.NativeEndian.Uint32(out[8:])&fuseKeepCache == 0 {
+		t.Fatalf("open = %v, %x; want the page cache kept", errno, out)
+	}
+	fh := binary.NativeEndian.Uint64(out)
+
+	read := func(off uint64, size uint32) []byte {
+		t.Helper()
+		errno, out := ft.call(fuseRead, node, fuseArgs(fh, off, size, uint32(0), uint64(0), uint32(0), uint32(0)))
+		if errno != 0 {
+			t.Fatalf("read %d at %d = %v", size, off, errno)
+		}
+		return out
+	}
+	data := fsys["docs/b.txt"].Data
+	if got := read(1000, 4096); !bytes.Equal(got, data[1000:5096]) {
+		t.Errorf("read 4096 at 1000 = %d bytes", len(got))
+	}
+	if got := read(0, 1<<20); len(got) != fuseMaxRead {
+		t.Errorf("read of 1 MiB = %d bytes, want at most %d", len(got), fuseMaxRead)
+	}
+	if got := read(uint64(len(data))-10, 4096); !bytes.Equal(got, data[len(data)-10:]) {
+		t.Errorf("read across the end = %d bytes, want the last 10", len(got))
+	}
+	if got := read(uint64(len(data))+10, 4096); len(got) != 0 {
+		t.Errorf("read past the end = %d bytes, want none", len(got))
+	}
+
+	ft.call(fuseRelease, node, fuseArgs(fh, uint32(0), uint32(0), uint64(0)))
+	if errno, _ := ft.call(fuseRead, node, fuseArgs(fh, uint64(0), uint32(10), uint32(0), uint64(0), uint32(0), uint32(0))); errno != unix.EBADF {
+		t.Errorf("read of a released handle = %v, want EBADF", errno)
+	}
+}
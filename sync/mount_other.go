@@ -0,0 +1,17 @@
+//go:build !linux
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// Mount serves fsys as a FUSE file system, which is only supported on
+// Linux.
+func Mount(ctx context.Context, fsys fs.FS, dir string, warnings io.Writer) error {
+	return fmt.Errorf("mount %s: FUSE mounts need Linux: %w", dir, errors.ErrUnsupported)
+}
@@ -0,0 +1,225 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Notify configures the notifications sent when a Sync run finishes: to a
+// webhook, to Slack and by mail. A run is notified if it matches any of
+// OnSuccess, OnFailure and OnChanges. Dry runs are not notified. A
+// notification that cannot be sent is only a warning.
+type Notify struct {
+	// Job names the run in notifications. Empty means the source.
+	Job string
+
+	// Webhook is a URL to POST a JSON Notification to.
+	Webhook string
+	// Slack is the URL of a Slack incoming webhook to post a message to.
+	Slack string
+	// Mail, if set, mails a message.
+	Mail *Mail
+
+	OnSuccess bool // runs that succeeded
+	OnFailure bool // runs that failed, stopped early or were canceled
+	// OnChanges notifies runs that uploaded or deleted more than Changes
+	// files, whether or not they succeeded.
+	OnChanges bool
+	Changes   int
+}
+
+// ParseNotifyEvents returns a Notify that notifies the runs events name:
+// "success", "failure" and "changes", with Changes left at zero. No events
+// means "failure".
+func ParseNotifyEvents(events []string) (Notify, error) {
+	var n Notify
+	if len(events) == 0 {
+		events = []string{"failure"}
+	}
+	for _, e := range events {
+		switch e {
+		case "success":
+			n.OnSuccess = true
+		case "failure":
+			n.OnFailure = true
+		case "changes":
+			n.OnChanges = true
+		default:
+			return n, fmt.Errorf("unknown notify event %q (valid: success, failure, changes)", e)
+		}
+	}
+	return n, nil
+}
+
+// Mail describes how notifications are mailed.
+type Mail struct {
+	// Server is the SMTP server, as smtp://[user:password@]host[:port].
+	// The port defaults to 587. Connections are upgraded with STARTTLS
+	// when the server offers it, and must be to send a password other
+	// than to localhost.
+	Server string
+	From   string
+	To     []string
+}
+
+// Check reports whether m is complete.
+func (m Mail) Check() error {
+	u, err := url.Parse(m.Server)
+	switch {
+	case err != nil:
+		return fmt.Errorf("mail server: %w", err)
+	case u.Scheme != "smtp" || u.Hostname() == "":
+		return fmt.Errorf("mail server %q: want smtp://[user:password@]host[:port]", m.Server)
+	case m.From == "" || len(m.To) == 0:
+		return errors.New("mail needs a sender and at least one recipient")
+	}
+	return nil
+}
+
+// Notification is the JSON body posted to Notify.Webhook.
+type Notification struct {
+	Job           string  `json:"job"`
+	Host          string  `json:"host"`
+	Status        string  `json:"status"` // see Summary.Status
+	Files         int     `json:"files"`
+	Uploads       int     `json:"uploads"`
+	Uploaded      int     `json:"uploaded"`
+	Deletes       int     `json:"deletes"`
+	Deleted       int     `json:"deleted"`
+	UploadedBytes int64   `json:"uploaded_bytes"`
+	Duration      float64 `json:"duration_seconds"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// wants reports whether the run summarized by s is to be notified.
+func (n *Notify) wants(s Summary) bool {
+	if n == nil || s.DryRun {
+		return false
+	}
+	return s.Err == nil && n.OnSuccess || s.Err != nil && n.OnFailure ||
+		n.OnChanges && s.Uploaded+s.Deleted > n.Changes
+}
+
+// notification returns the notification of the run summarized by s.
+func (n *Notify) notification(s Summary) Notification {
+	host, _ := os.Hostname()
+	job := n.Job
+	if job == "" {
+		job = s.Src
+	}
+	m := Notification{
+		Job: job, Host: host, Status: s.Status(),
+		Files: s.Files, Uploads: s.Uploads, Uploaded: s.Uploaded, Deletes: s.Deletes, Deleted: s.Deleted,
+		UploadedBytes: s.UploadedBytes, Duration: s.Duration.Seconds(),
+	}
+	if s.Err != nil {
+		m.Error = s.Err.Error()
+	}
+	return m
+}
+
+// String returns the text of the notification, for Slack and mail.
+func (m Notification) String() string {
+	text := fmt.Sprintf("foldersync %s on %s: %s: %d files, uploaded %d of %d (%d bytes), deleted %d of %d, in %.1fs",
+		m.Job, m.Host, m.Status, m.Files, m.Uploaded, m.Uploads, m.UploadedBytes, m.Deleted, m.Deletes, m.Duration)
+	if m.Error != "" {
+		text += "\n" + m.Error
+	}
+	return text
+}
+
+// send sends the notifications of the run summarized by s that n wants,
+// warning of those that fail to warnings.
+func (n *Notify) send(ctx context.Context, s Summary, warnings io.Writer) {
+	if !n.wants(s) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	m := n.notification(s)
+	if n.Webhook != "" {
+		if err := postJSON(ctx, n.Webhook, m); err != nil {
+			warnf(warnings, "notify webhook: %v", err)
+		}
+	}
+	if n.Slack != "" {
+		if err := postJSON(ctx, n.Slack, map[string]string{"text": m.String()}); err != nil {
+			warnf(warnings, "notify slack: %v", err)
+		}
+	}
+	if n.Mail != nil {
+		if err := n.Mail.send(m); err != nil {
+			warnf(warnings, "notify mail: %v", err)
+		}
+	}
+}
+
+// postJSON posts v as JSON to rawURL. Errors leave the URL out, since it
+// may hold a secret.
+func postJSON(ctx context.Context, rawURL string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// send mails m.
+func (ml *Mail) send(m Notification) error {
+	u, err := url.Parse(ml.Server)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "587")
+	}
+	var auth smtp.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+	}
+	return smtp.SendMail(addr, auth, ml.From, ml.To, ml.message(m))
+}
+
+// message returns the mail of m.
+func (ml *Mail) message(m Notification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", ml.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(ml.To, ", "))
+	fmt.Fprintf(&b, "Subject: foldersync %s: %s\r\n", m.Job, m.Status)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.String(), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
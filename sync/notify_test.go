@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSync_notify(t *testing.T) {
+	var hooks []Notification
+	var slack []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hook":
+			var n Notification
+			if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+				t.Error(err)
+			}
+			hooks = append(hooks, n)
+		case "/slack":
+			var m struct{ Text string }
+			if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+				t.Error(err)
+			}
+			slack = append(slack, m.Text)
+		}
+	}))
+	defer srv.Close()
+
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	dst := newMockDest()
+	notify := &Notify{Job: "docs", Webhook: srv.URL + "/hook", Slack: srv.URL + "/slack", OnFailure: true, OnChanges: true, Changes: 1}
+	opts := Options{Src: src, Dst: dst, Notify: notify}
+
+	// Two files changed: more than Changes.
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].Job != "docs" || hooks[0].Status != "ok" || hooks[0].Uploaded != 2 {
+		t.Fatalf("webhook got %+v, want the run uploading 2 files", hooks)
+	}
+	if len(slack) != 1 || !strings.HasPrefix(slack[0], "foldersync docs on ") {
+		t.Errorf("slack got %q", slack)
+	}
+
+	// Nothing changed, and the run succeeded.
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 {
+		t.Errorf("an unchanged run was notified: %+v", hooks[1:])
+	}
+
+	opts.PreSync = func(context.Context) error { return errors.New("database busy") }
+	if _, err := Sync(context.Background(), opts); err == nil {
+		t.Fatal("run with a failing pre-sync hook succeeded")
+	}
+	if len(hooks) != 2 || hooks[1].Status != "failed" || !strings.Contains(hooks[1].Error, "database busy") {
+		t.Errorf("webhook got %+v, want the failure", hooks[1:])
+	}
+}
+
+func TestParseNotifyEvents(t *testing.T) {
+	if n, err := ParseNotifyEvents(nil); err != nil || !n.OnFailure || n.OnSuccess || n.OnChanges {
+		t.Errorf("ParseNotifyEvents(nil) = %+v, %v; want failures only", n, err)
+	}
+	if n, err := ParseNotifyEvents([]string{"success", "changes"}); err != nil || n.OnFailure || !n.OnSuccess || !n.OnChanges {
+		t.Errorf("ParseNotifyEvents(success, changes) = %+v, %v", n, err)
+	}
+	if _, err := ParseNotifyEvents([]string{"always"}); err == nil {
+		t.Error("ParseNotifyEvents(always) succeeded")
+	}
+}
+
+func TestMail_message(t *testing.T) {
+	m := Mail{Server: "smtp://mail.example.com", From: "backup@example.com", To: []string{"me@example.com", "you@example.com"}}
+	if err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	msg := string(m.message(Notification{Job: "docs", Host: "nas", Status: "failed", Error: "no route to host"}))
+	for _, want := range []string{"To: me@example.com, you@example.com\r\n", "Subject: foldersync docs: failed\r\n", "\r\n\r\nfoldersync docs on nas: failed: ", "\r\nno route to host\r\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+	if err := (Mail{Server: "mail.example.com:25", From: "a@b", To: []string{"c@d"}}).Check(); err == nil {
+		t.Error("Check accepted a server without the smtp scheme")
+	}
+}
@@ -0,0 +1,256 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	stdsync "sync"
+	"time"
+)
+
+// Event is a change a run makes, or with DryRun would make, as reported to
+// Options.Log and Options.OnEvent.
+type Event struct {
+	// Action is what is done: "upload", "copy", "link", "mkdir",
+	// "delete", "expire", "skip", "bundle", "unbundle", "conflict",
+	// "download" or "delete-local".
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	From   string `json:"from,omitempty"`   // the key copied from, for "copy"
+	Reason string `json:"reason,omitempty"` // why, where it is not plain
+
+	// Item, with Options.Itemize, says how an uploaded file differs from
+	// the destination's copy. See itemize.
+	Item string `json:"item,omitempty"`
+}
+
+// String formats e as a run prints it, for example
+// "copy a.txt -> b.txt (renamed)".
+func (e Event) String() string {
+	s := strings.ReplaceAll(e.Action, "-", " ") + " "
+	if e.From != "" {
+		s += e.From + " -> "
+	}
+	s += e.Key
+	if e.Reason != "" {
+		s += " (" + e.Reason + ")"
+	}
+	return s
+}
+
+// itemized formats e as a run with Options.Itemize prints it, in the style
+// of rsync --itemize-changes: "*deleting old.txt", ">f.st.... a.txt". Events
+// other than uploads, copies, links, directories and deletes are formatted
+// by String.
+func (e Event) itemized() string {
+	item := e.Item
+	if item == "" {
+		switch e.Action {
+		case "delete":
+			item = "*deleting"
+		case "copy":
+			item = "cf+++++++"
+		case "link":
+			item = "hf+++++++"
+		case "mkdir":
+			item = "cd+++++++"
+		default:
+			return e.String()
+		}
+	}
+	s := fmt.Sprintf("%-9s %s", item, e.Key)
+	if e.From != "" {
+		s += " <- " + e.From
+	}
+	if e.Reason != "" {
+		s += " (" + e.Reason + ")"
+	}
+	return s
+}
+
+// itemize describes how f differs from its copy at the destination, as
+// ">f" followed by a letter for each attribute that differs, or a dot: c
+// for the content, if nothing else does, s for the size, t for the mtime,
+// then, with Options.PreservePOSIX, p for the permissions, o for the
+// owner, g for the group and x for the extended attributes. A file the
+// destination lacks is ">f+++++++", and one the run did not check, with
+// Options.Incremental, ">f???????".
+func itemize(f File, checked bool) string {
+	r := f.Remote
+	switch {
+	case r == nil && !checked:
+		return ">f???????"
+	case r == nil:
+		return ">f+++++++"
+	}
+	b := []byte(">f.......")
+	if f.Size != r.Size {
+		b[3] = 's'
+	}
+	if !sameModTime(f.ModTime, r.ModTime, 0) {
+		b[4] = 't'
+	}
+	if a := f.POSIX; a != nil {
+		ra := r.POSIX
+		if ra == nil {
+			ra = &POSIXAttrs{UID: -1, GID: -1}
+		}
+		if a.Mode != ra.Mode {
+			b[5] = 'p'
+		}
+		if a.UID != ra.UID {
+			b[6] = 'o'
+		}
+		if a.GID != ra.GID {
+			b[7] = 'g'
+		}
+		if !maps.EqualFunc(a.Xattrs, ra.Xattrs, func(x, y []byte) bool { return string(x) == string(y) }) {
+			b[8] = 'x'
+		}
+	}
+	if string(b[3:]) == "......" {
+		b[2] = 'c'
+	}
+	return string(b)
+}
+
+// item returns the Event.Item of an upload of f, if opts.Itemize is set.
+func (opts Options) item(plan *Plan, f File) string {
+	if !opts.Itemize {
+		return ""
+	}
+	return itemize(f, !plan.incremental)
+}
+
+// printItemSummary prints a line counting the changes plan makes, by what
+// itemize says of them.
+func printItemSummary(opts Options, plan *Plan) {
+	var added, changed, unchecked, copied, content, size, mtime, attrs int
+	for _, f := range plan.Uploads {
+		_, renamed := plan.renamed[f.Key]
+		if _, duplicate := plan.duplicates[f.Key]; renamed || duplicate {
+			copied++
+			continue
+		}
+		item := itemize(f, !plan.incremental)
+		switch item[2] {
+		case '+':
+			added++
+			continue
+		case '?':
+			unchecked++
+			continue
+		case 'c':
+			content++
+		}
+		changed++
+		if item[3] == 's' {
+			size++
+		}
+		if item[4] == 't' {
+			mtime++
+		}
+		if strings.Trim(item[5:], ".") != "" {
+			attrs++
+		}
+	}
+	s := fmt.Sprintf("%d new, %d changed (%d in size, %d in mtime, %d in attributes, %d in content only)",
+		added, changed, size, mtime, attrs, content)
+	if unchecked > 0 {
+		s += fmt.Sprintf(", %d unchecked", unchecked)
+	}
+	if copied > 0 {
+		s += fmt.Sprintf(", %d copied", copied)
+	}
+	if len(plan.Bundled) > 0 {
+		s += fmt.Sprintf(", %d bundled", len(plan.Bundled))
+	}
+	if n := len(plan.links); n > 0 {
+		s += fmt.Sprintf(", %d linked", n)
+	}
+	opts.logf("%s, %d deleted", s, len(plan.Deletes))
+}
+
+// log returns where opts prints its account of a run.
+func (opts Options) log() io.Writer {
+	return logTo(opts.Log)
+}
+
+// logTo returns w, the Log or Warnings of some options, or io.Discard if
+// it is nil: nothing is printed unless a program asks for it.
+func logTo(w io.Writer) io.Writer {
+	if w == nil {
+		return io.Discard
+	}
+	return w
+}
+
+// logPlan prints, with opts.Verbose, what plan holds and how long it took
+// to make, after the files found up to date at higher levels.
+func logPlan(opts Options, plan *Plan, took time.Duration) {
+	if opts.Verbose <= 0 {
+		return
+	}
+	pending := make(map[string]bool, len(plan.Uploads)+len(plan.Bundled))
+	var size int64
+	for _, f := range slices.Concat(plan.Uploads, plan.Bundled) {
+		pending[f.Key] = true
+		size += f.Size
+	}
+	if opts.Verbose >= 2 {
+		for _, f := range plan.Files {
+			if !pending[f.Key] {
+				opts.logf("%s is up to date", f.Key)
+			}
+		}
+	}
+	opts.logf("planned in %s: %d files, %d to upload (%s), %d to delete",
+		FormatDuration(took), len(plan.Files), len(pending), FormatSize(size), len(plan.Deletes))
+}
+
+// lockedWriter is a Log shared by the workers of a run, whose lines it
+// keeps from interleaving.
+type lockedWriter struct {
+	mu stdsync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// logf prints a line of the account of a run that is not about any one
+// change.
+func (opts Options) logf(format string, args ...any) {
+	fmt.Fprintf(opts.log(), format+"\n", args...)
+}
+
+// warnf prints a warning to w, the Warnings of some options.
+func warnf(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(logTo(w), "warning: "+format+"\n", args...)
+}
+
+// warnf prints a warning of a run to opts.Warnings.
+func (opts Options) warnf(format string, args ...any) {
+	warnf(opts.Warnings, format, args...)
+}
+
+// report prints e, passes it to opts.OnEvent, and adds it to the Result
+// of the run.
+func (opts Options) report(e Event) {
+	if opts.Itemize {
+		fmt.Fprintln(opts.log(), e.itemized())
+	} else {
+		fmt.Fprintln(opts.log(), e)
+	}
+	if opts.OnEvent != nil {
+		opts.OnEvent(e)
+	}
+	if opts.changes != nil {
+		*opts.changes = append(*opts.changes, e)
+	}
+}
@@ -0,0 +1,158 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSync_events(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	dst.objects["old.txt"] = &ObjectMeta{Size: 1}
+
+	var log bytes.Buffer
+	var events []Event
+	opts := Options{Src: src, Dst: dst, Delete: true, Log: &log, OnEvent: func(e Event) { events = append(events, e) }}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{{Action: "upload", Key: "a.txt"}, {Action: "delete", Key: "old.txt"}}
+	if !slices.Equal(events, want) {
+		t.Errorf("events %+v, want %+v", events, want)
+	}
+	if got := log.String(); got != "upload a.txt\ndelete old.txt\n" {
+		t.Errorf("logged %q", got)
+	}
+}
+
+func TestSync_verbose(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "bb")
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, src, "c.txt", "ccc")
+
+	var log bytes.Buffer
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Log: &log, Verbose: 2}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(log.String(), "\n"), "\n")
+	if len(lines) != 5 || lines[0] != "a.txt is up to date" || lines[1] != "b.txt is up to date" ||
+		!strings.HasPrefix(lines[2], "planned in ") || !strings.HasSuffix(lines[2], ": 3 files, 1 to upload (3 B), 0 to delete") ||
+		lines[3] != "upload c.txt" || !strings.HasPrefix(lines[4], "applied in ") {
+		t.Errorf("logged %q", lines)
+	}
+
+	// Without Verbose, only the changes.
+	log.Reset()
+	writeFile(t, src, "d.txt", "d")
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Log: &log}); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.String(); got != "upload d.txt\n" {
+		t.Errorf("logged %q without Verbose", got)
+	}
+}
+
+func TestRestore_log(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	to := t.TempDir()
+	writeFile(t, to, "b.txt", "kept")
+	dst.objects["b.txt"] = &ObjectMeta{Size: 1}
+	dst.data["b.txt"] = []byte("b")
+	err := Restore(context.Background(), RestoreOptions{From: dst, To: to, Overwrite: OverwriteNever, Log: &log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := log.String(); got != "restore a.txt\nskip b.txt (exists)\n" {
+		t.Errorf("logged %q", got)
+	}
+}
+
+func TestEvent_String(t *testing.T) {
+	for _, tc := range []struct {
+		e    Event
+		want string
+	}{
+		{Event{Action: "upload", Key: "a"}, "upload a"},
+		{Event{Action: "copy", Key: "b", From: "a", Reason: "renamed"}, "copy a -> b (renamed)"},
+		{Event{Action: "delete-local", Key: "c"}, "delete local c"},
+	} {
+		if got := tc.e.String(); got != tc.want {
+			t.Errorf("%+v: got %q, want %q", tc.e, got, tc.want)
+		}
+	}
+}
+
+func TestSync_itemize(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"mtime.txt", "new.txt", "same.txt", "size.txt"} {
+		writeFile(t, src, name, "abc")
+	}
+	info, err := os.Stat(filepath.Join(src, "same.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := newMockDest()
+	dst.objects["mtime.txt"] = &ObjectMeta{Size: 3, ModTime: info.ModTime().Add(-time.Hour)}
+	dst.objects["same.txt"] = &ObjectMeta{Size: 3, ModTime: info.ModTime()}
+	dst.objects["size.txt"] = &ObjectMeta{Size: 4, ModTime: info.ModTime()}
+	dst.objects["old.txt"] = &ObjectMeta{Size: 1}
+
+	var log bytes.Buffer
+	opts := Options{Src: src, Dst: dst, Delete: true, DryRun: true, Itemize: true, Reupload: []string{"same.txt"}, Log: &log}
+	res, err := Sync(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ">f..t.... mtime.txt\n" +
+		">f+++++++ new.txt\n" +
+		">fc...... same.txt\n" +
+		">f.s..... size.txt\n" +
+		"*deleting old.txt\n" +
+		"1 new, 3 changed (1 in size, 1 in mtime, 0 in attributes, 1 in content only), 1 deleted\n"
+	if got := log.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("logged\n%s\nwant it to start with\n%s", got, want)
+	}
+	if got := res.Changes[1]; got != (Event{Action: "upload", Key: "new.txt", Item: ">f+++++++"}) {
+		t.Errorf("event %+v, want the item set", got)
+	}
+}
+
+func TestItemize(t *testing.T) {
+	now := time.Now()
+	posix := &POSIXAttrs{Mode: 0644, UID: 1, GID: 2, Xattrs: map[string][]byte{"user.a": []byte("x")}}
+	for _, tc := range []struct {
+		remote *POSIXAttrs
+		want   string
+	}{
+		{posix, ">fc......"},
+		{&POSIXAttrs{Mode: 0600, UID: 1, GID: 3, Xattrs: posix.Xattrs}, ">f...p.g."},
+		{&POSIXAttrs{Mode: 0644, UID: 0, GID: 2}, ">f....o.x"},
+		{nil, ">f...pogx"},
+	} {
+		f := File{Size: 1, ModTime: now, POSIX: posix, Remote: &ObjectMeta{Size: 1, ModTime: now, POSIX: tc.remote}}
+		if got := itemize(f, true); got != tc.want {
+			t.Errorf("remote %+v: itemize = %q, want %q", tc.remote, got, tc.want)
+		}
+	}
+	if got := itemize(File{}, false); got != ">f???????" {
+		t.Errorf("unchecked file: itemize = %q", got)
+	}
+}
@@ -0,0 +1,241 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	stdsync "sync"
+	"time"
+)
+
+// ErrRequestLimit is returned once a run has made Options.MaxRequests
+// requests to the destination. Sync stops early, keeps what it has done
+// and leaves the rest for the next run.
+var ErrRequestLimit = errors.New("request limit reached")
+
+// RequestPrices are the prices of requests to a destination, in US
+// dollars per 1000 requests, used to estimate the cost of a dry run.
+// Deletes are free on both S3 and GCS.
+type RequestPrices struct {
+	Write float64 // PUT, COPY, POST and LIST requests
+	Read  float64 // GET and HEAD requests
+}
+
+// RequestPricer is implemented by destinations that know what their
+// requests cost, for the estimate printed by a dry run.
+type RequestPricer interface {
+	RequestPrices() RequestPrices
+}
+
+type requestKind int
+
+const (
+	readRequest requestKind = iota
+	writeRequest
+	listRequest
+	deleteRequest
+)
+
+// requestCounts counts requests by kind.
+type requestCounts struct {
+	Read, Write, List, Delete int
+}
+
+func (c *requestCounts) add(kind requestKind) {
+	switch kind {
+	case readRequest:
+		c.Read++
+	case writeRequest:
+		c.Write++
+	case listRequest:
+		c.List++
+	case deleteRequest:
+		c.Delete++
+	}
+}
+
+func (c requestCounts) total() int {
+	return c.Read + c.Write + c.List + c.Delete
+}
+
+// cost returns the price of c at p. LIST requests are billed as writes.
+func (c requestCounts) cost(p RequestPrices) float64 {
+	return (float64(c.Write+c.List)*p.Write + float64(c.Read)*p.Read) / 1000
+}
+
+// pacer counts the requests made to a destination, spaces them out to at
+// most perSecond a second, and refuses any beyond limit. A zero limit or
+// rate means no limit.
+type pacer struct {
+	limit    int
+	interval time.Duration
+
+	mu     stdsync.Mutex
+	next   time.Time // earliest time of the next request
+	counts requestCounts
+}
+
+func newPacer(limit int, perSecond float64) *pacer {
+	p := &pacer{limit: limit}
+	if perSecond > 0 {
+		p.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return p
+}
+
+// take waits for a slot for one more request of the given kind.
+func (p *pacer) take(ctx context.Context, kind requestKind) error {
+	p.mu.Lock()
+	if p.limit > 0 && p.counts.total() >= p.limit {
+		p.mu.Unlock()
+		return ErrRequestLimit
+	}
+	p.counts.add(kind)
+	now := time.Now()
+	at := p.next
+	if at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(p.interval)
+	p.mu.Unlock()
+
+	if wait := at.Sub(now); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// snapshot returns the requests counted so far.
+func (p *pacer) snapshot() requestCounts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.counts
+}
+
+// pacedDest passes every request to its Destination through a pacer. A
+// multipart upload or paginated listing counts as one request.
+type pacedDest struct {
+	Destination
+	p *pacer
+}
+
+func (d pacedDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := d.p.take(ctx, writeRequest); err != nil {
+		return err
+	}
+	return d.Destination.Put(ctx, key, r, meta)
+}
+
+func (d pacedDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	if err := d.p.take(ctx, readRequest); err != nil {
+		return nil, err
+	}
+	return d.Destination.Stat(ctx, key)
+}
+
+func (d pacedDest) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := d.p.take(ctx, readRequest); err != nil {
+		return nil, err
+	}
+	return get(ctx, d.Destination, key)
+}
+
+func (d pacedDest) Copy(ctx context.Context, src, dst string) error {
+	if err := d.p.take(ctx, writeRequest); err != nil {
+		return err
+	}
+	return copyObject(ctx, d.Destination, src, dst)
+}
+
+func (d pacedDest) CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error {
+	if err := d.p.take(ctx, writeRequest); err != nil {
+		return err
+	}
+	return copyWithMeta(ctx, d.Destination, src, dst, meta)
+}
+
+// Append counts as a single write, as a multipart copy does.
+func (d pacedDest) Append(ctx context.Context, key string, size int64, tail io.Reader, meta ObjectMeta) error {
+	if err := d.p.take(ctx, writeRequest); err != nil {
+		return err
+	}
+	return appendObject(ctx, d.Destination, key, size, tail, meta)
+}
+
+// Rename counts as a single write, as a rename or a copy does; the delete
+// that follows a copy is not counted.
+func (d pacedDest) Rename(ctx context.Context, src, dst string) error {
+	if err := d.p.take(ctx, writeRequest); err != nil {
+		return err
+	}
+	return renameObject(ctx, d.Destination, src, dst)
+}
+
+func (d pacedDest) SetStorageClass(ctx context.Context, key, class string) error {
+	if err := d.p.take(ctx, writeRequest); err != nil {
+		return err
+	}
+	return setStorageClass(ctx, d.Destination, key, class)
+}
+
+// List counts and paces each page of the listing after the first as it
+// arrives: the destination asks for the next page only once fn returns,
+// or, listing prefixes in parallel, a few pages ahead of it.
+func (d pacedDest) List(ctx context.Context, fn func(keys []string) error) error {
+	if err := d.p.take(ctx, listRequest); err != nil {
+		return err
+	}
+	first := true
+	return d.Destination.List(ctx, func(keys []string) error {
+		if !first {
+			if err := d.p.take(ctx, listRequest); err != nil {
+				return err
+			}
+		}
+		first = false
+		return fn(keys)
+	})
+}
+
+func (d pacedDest) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	if err := d.p.take(ctx, listRequest); err != nil {
+		return nil, err
+	}
+	return listWritten(ctx, d.Destination)
+}
+
+func (d pacedDest) Delete(ctx context.Context, key string) error {
+	if err := d.p.take(ctx, deleteRequest); err != nil {
+		return err
+	}
+	return d.Destination.Delete(ctx, key)
+}
+
+func (d pacedDest) DeleteBatch(ctx context.Context, keys []string) ([]string, error) {
+	if err := d.p.take(ctx, deleteRequest); err != nil {
+		return nil, err
+	}
+	return deleteBatch(ctx, d.Destination, keys)
+}
+
+// printEstimate prints the requests a dry run made while planning plus
+// those applying plan would make, and their cost at opts.prices if known.
+func printEstimate(opts Options, plan *Plan) {
+	counts := opts.pacer.snapshot()
+	counts.Write += putRequests(opts, plan)
+	counts.Delete += len(plan.Deletes)
+	w := opts.log()
+	fmt.Fprintf(w, "estimated requests: %d (%d HEAD/GET, %d PUT, %d LIST, %d DELETE)",
+		counts.total(), counts.Read, counts.Write, counts.List, counts.Delete)
+	if opts.prices != nil {
+		fmt.Fprintf(w, ", about $%.4f", counts.cost(*opts.prices))
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSync_maxRequestsResumes(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		writeFile(t, src, name, name)
+	}
+	dst := newMockDest()
+	opts := Options{
+		Src:         src,
+		Dst:         dst,
+		MaxRequests: 6,
+		StateCache:  filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	// 5 HEADs and 1 PUT, then 4 HEADs and 2 PUTs, then 2 HEADs and 2 PUTs.
+	for run, wantErr := range []bool{true, true, false} {
+		_, err := Sync(context.Background(), opts)
+		if got := errors.Is(err, ErrRequestLimit); got != wantErr {
+			t.Fatalf("run %d: err = %v, want request limit %v", run+1, err, wantErr)
+		}
+	}
+	if len(dst.putCalls) != 5 || len(dst.objects) != 5 {
+		t.Errorf("uploaded %v, want every file once", dst.putCalls)
+	}
+}
+
+func TestSync_maxRequestsSkipsDeletes(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a", "a")
+	writeFile(t, src, "b", "b")
+	dst := newMockDest()
+	dst.objects["old"] = &ObjectMeta{}
+
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true, MaxRequests: 1})
+	if !errors.Is(err, ErrRequestLimit) {
+		t.Fatalf("err = %v, want ErrRequestLimit", err)
+	}
+	if len(dst.deleteCalls) != 0 {
+		t.Errorf("deleted %v from an incomplete run", dst.deleteCalls)
+	}
+}
+
+func TestPacer_rate(t *testing.T) {
+	p := newPacer(0, 100)
+	start := time.Now()
+	for range 5 {
+		if err := p.take(context.Background(), readRequest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 requests at 100/s took %v, want at least 40ms", elapsed)
+	}
+	if got := p.snapshot().Read; got != 5 {
+		t.Errorf("counted %d reads, want 5", got)
+	}
+}
+
+func TestRequestCounts_cost(t *testing.T) {
+	c := requestCounts{Read: 10000, Write: 1000, List: 1000, Delete: 500}
+	got := c.cost(RequestPrices{Write: 0.005, Read: 0.0004})
+	if want := 0.014; math.Abs(got-want) > 1e-9 {
+		t.Errorf("cost = %v, want %v", got, want)
+	}
+}
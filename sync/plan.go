@@ -0,0 +1,638 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Plan is the set of changes a sync run intends to make.
+type Plan struct {
+	Files   []File   // every source file considered, in walk order
+	Uploads []File   // files that are missing or stale at the destination
+	Deletes []string // destination keys absent from the source
+
+	// Transitions holds the files up to date at the destination whose
+	// objects are to move to the storage class of their tier. See
+	// Options.Tiers.
+	Transitions []File
+
+	// Expiring holds destination keys absent from the source that are
+	// left for lifecycle rules to expire. See Options.ExpireAfter.
+	Expiring []string
+
+	// Bundled holds the files to pack into a new bundle, and Unbundle the
+	// keys to drop from the bundle index: files since uploaded on their
+	// own or, with Options.Delete, absent from the source. See
+	// Options.Bundle.
+	Bundled  []File
+	Unbundle []string
+
+	// Filtered holds the files skipped by the size and age filters of
+	// Options, and by Options.SkipInFlight.
+	Filtered []File
+
+	// Incomplete reports that the run reached Options.MaxRequests, or used
+	// up its budget, before every file was checked and uploaded. Nothing
+	// is deleted.
+	Incomplete bool
+
+	dirs    *dirCache      // nil unless Options.DirCache is set
+	state   *stateCache    // nil unless Options.StateCache is set
+	journal *journal       // nil unless Options.Journal is set and the plan is being applied
+	bundles *BundleIndex   // nil unless Options.Bundle is set
+	chunks  *ChunkIndex    // set by applyPlan if Options.Chunk is
+	ignore  *ignorer       // patterns of the IgnoreFiles in the source being walked
+	cases   *caseNames     // nil unless Options.CaseCollisions is set
+	writing map[inode]bool // files open for writing, with Options.SkipInFlight
+
+	// incremental is set if the run trusts the state cache alone. See
+	// Options.Incremental.
+	incremental bool
+	// renamed maps the keys of uploads to copy instead to the keys of the
+	// objects to copy them from. See Options.DetectRenames.
+	renamed map[string]string
+	// duplicates maps the keys of uploads to copy instead to the keys of
+	// the uploads before them with the same content, and originals holds
+	// the keys of those, set once they are uploaded unchanged. See
+	// Options.DedupeUploads.
+	duplicates map[string]string
+	originals  map[string]bool
+
+	// With Options.HardLinks, links maps the keys of files hard linked to
+	// a file walked before them to its key, inodes maps the files walked
+	// to their keys, and linkIndex is the destination's link index.
+	links     map[string]string
+	inodes    map[inode]string
+	linkIndex *LinkIndex
+
+	// emptyDirs holds the keys of the empty directories walked, with
+	// Options.KeepEmptyDirs.
+	emptyDirs []string
+
+	// keyPaths maps the keys of the files walked to their paths, with
+	// ShapedKeys, to find those stored under one key.
+	keyPaths map[string]string
+
+	// unreadable holds the source files and directories left out
+	// because they could not be read. See Options.OnWalkError.
+	unreadable []UnreadablePath
+
+	uploaded, deleted int          // progress of applyPlan
+	uploadedBytes     int64        // size of the files uploaded
+	failed            []*FileError // uploads that failed; see Options.KeepGoing
+	budget            string       // how the run used up its budget, if it did
+	changed           []string     // files still changing after uploadSettled
+}
+
+// File describes a local file and the key it is stored under.
+type File struct {
+	Key     string // destination key, always slash-separated
+	Path    string // local path
+	Size    int64
+	ModTime time.Time
+	POSIX   *POSIXAttrs // set if Options.PreservePOSIX is
+	Class   string      // storage class of its tier, if Options.Tiers assigns one
+
+	Remote *ObjectMeta // the destination's current copy, nil if absent
+
+	hash    *fileHash // set by newFile: its content hash, once read
+	skipped string    // why the filters skip it, for files in Plan.Filtered
+}
+
+// meta returns the metadata to store with f.
+func (f File) meta() ObjectMeta {
+	return ObjectMeta{Size: f.Size, ModTime: f.ModTime, POSIX: f.POSIX, StorageClass: f.Class}
+}
+
+// forget drops the directory of the file stored under key from the
+// directory cache, so that the next run checks the file again.
+func (p *Plan) forget(opts Options, key string) {
+	if s, rest, ok := sourceFor(opts, key); ok {
+		if rel, ok := keyMapper(opts.Keys).Path(rest); ok {
+			p.dirs.forget(s.Prefix + dirOf(rel))
+		}
+	}
+}
+
+// upToDate returns f with Remote set to match it, for files known to be up
+// to date without asking the destination.
+func (f File) upToDate() File {
+	meta := f.meta()
+	f.Remote = &meta
+	return f
+}
+
+// buildPlan walks opts.Src and compares it to opts.Dst without changing
+// anything.
+func buildPlan(ctx context.Context, opts Options) (_ *Plan, err error) {
+	plan := &Plan{}
+	defer func() {
+		// Keep what was checked before the run was canceled.
+		if err != nil && ctx.Err() != nil && !opts.DryRun {
+			if serr := plan.state.saveProgress(); serr != nil {
+				err = fmt.Errorf("%w; save state cache: %v", err, serr)
+			}
+		}
+	}()
+	if opts.DirCache != "" && opts.FileList == nil && len(opts.Tiers) == 0 && opts.CaseCollisions != CaseCollisionsRename {
+		plan.dirs = loadDirCache(opts.DirCache, opts.Warnings)
+	}
+	if opts.StateCache != "" {
+		state, err := openStateCache(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		plan.state = state
+		plan.incremental = incremental(opts, state)
+	}
+	if opts.ApplyPlan != nil {
+		if err := planSaved(ctx, opts, plan); err != nil {
+			return nil, err
+		}
+		return plan, nil
+	}
+	if opts.Bundle != nil {
+		idx, err := ReadBundleIndex(ctx, opts.Dst)
+		if err != nil {
+			return nil, err
+		}
+		plan.bundles = idx
+	}
+	plan.cases = newCaseNames(opts)
+	if opts.SkipInFlight {
+		plan.writing = openForWriting()
+	}
+	for _, src := range sources(opts) {
+		src.Dir = longPath(src.Dir)
+		opts.emit(WalkStarted{Dir: src.Dir, Prefix: src.Prefix})
+		plan.ignore = newIgnorer(src.Dir)
+		var err error
+		if opts.FileList != nil {
+			err = planFileList(ctx, opts, plan, src)
+		} else {
+			err = planUploads(ctx, opts, plan, src, src.Dir)
+		}
+		if errors.Is(err, ErrRequestLimit) {
+			plan.Incomplete = true
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if err := plan.cases.err(); err != nil {
+		return nil, err
+	}
+	if !plan.Incomplete {
+		planUnbundle(opts, plan)
+	}
+	if opts.ScanSecrets {
+		if err := screenSecrets(opts, plan); err != nil {
+			return nil, err
+		}
+	}
+	if err := planRenames(opts, plan); err != nil {
+		return nil, err
+	}
+	if (opts.Delete || opts.ReportExtraneous != "") && !plan.Incomplete && opts.FileList == nil {
+		err := planDeletes(ctx, opts, plan)
+		if errors.Is(err, ErrRequestLimit) {
+			plan.Incomplete = true
+			plan.Deletes, plan.Expiring = nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if opts.ReportExtraneous != "" && !plan.Incomplete {
+		if err := writeExtraneous(opts.log(), opts.ReportExtraneous, plan); err != nil {
+			return nil, fmt.Errorf("report extraneous objects: %w", err)
+		}
+		if !opts.Delete {
+			plan.Deletes, plan.Expiring = nil, nil
+		}
+	}
+	if !plan.Incomplete {
+		if err := planLinkObjects(ctx, opts, plan); err != nil {
+			return nil, err
+		}
+	}
+	// In key order rather than that of the walk, which puts "a/b" before
+	// "a.txt", so that the output of runs over the same files compares
+	// equal byte for byte, as do the keys of a listing.
+	byKey := func(a, b File) int { return strings.Compare(a.Key, b.Key) }
+	slices.SortStableFunc(plan.Uploads, byKey)
+	slices.SortStableFunc(plan.Bundled, byKey)
+	prioritize(opts.Priority, plan.Uploads)
+	// In the order of the uploads, so that each is copied from one before.
+	if err := planDuplicates(opts, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// writeExtraneous writes the keys plan would delete or leave to expire to
+// the file at path, in order, one per line.
+func writeExtraneous(w io.Writer, path string, plan *Plan) error {
+	keys := slices.Concat(plan.Deletes, plan.Expiring)
+	slices.Sort(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%d objects absent from the source listed in %s\n", len(keys), path)
+	return nil
+}
+
+// planUploads adds the files under root, which is src.Dir or a directory
+// inside it, to plan, with plan.ignore holding the patterns of src.
+func planUploads(ctx context.Context, opts Options, plan *Plan, src SourceSpec, root string) error {
+	unchanged := make(map[string]bool) // directories whose files need no checking
+	empty := newEmptyDirs(opts)
+	ahead := newStatAhead(ctx, opts)
+	defer ahead.stop()
+	// planned finishes planning file, once the files walked before it are.
+	planned := func(file File) error {
+		plan.state.visited(file.Key)
+		return plan.state.checkpoint()
+	}
+	// unreadable applies opts.OnWalkError to err, the failure to read
+	// the file or directory d at path, rel inside src.
+	unreadable := func(path, rel string, d fs.DirEntry, err error) error {
+		if err := plan.skipUnreadable(opts, path, err); err != nil {
+			return err
+		}
+		plan.dirs.forget(src.Prefix + dirOf(rel))
+		empty.file(rel) // whatever it holds, its directory holds it
+		if d != nil && d.IsDir() {
+			plan.dirs.forget(src.Prefix + rel)
+			delete(empty, rel)
+			return filepath.SkipDir
+		}
+		return nil
+	}
+	err := walkSource(opts, root, func(path string, d fs.DirEntry, walkErr error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := sourceKey(src.Dir, path)
+		if err != nil {
+			return err
+		}
+		if walkErr != nil {
+			if path == src.Dir {
+				return walkErr
+			}
+			return unreadable(path, rel, d, walkErr)
+		}
+		if skip, err := plan.ignore.match(rel, d.IsDir()); err != nil {
+			return unreadable(path, rel, d, err)
+		} else if skip {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			// Check the directory again next run, in case the file is
+			// no longer ignored then.
+			plan.dirs.forget(src.Prefix + dirOf(rel))
+			return nil
+		}
+
+		if d.IsDir() {
+			if rel+"/" == metaPrefix {
+				return filepath.SkipDir // reserved for foldersync's own objects
+			}
+			if empty != nil {
+				var info fs.FileInfo
+				if err := retrySource(ctx, opts, func() (err error) { info, err = d.Info(); return err }); err != nil {
+					return unreadable(path, rel, d, err)
+				}
+				empty.dir(rel, src.Prefix+fileKey(opts, rel, info.ModTime()))
+			}
+			if plan.dirs != nil {
+				err := retrySource(ctx, opts, func() (err error) {
+					unchanged[rel], err = plan.dirs.check(src.Prefix+rel, path)
+					return err
+				})
+				if err != nil {
+					return unreadable(path, rel, d, err)
+				}
+			}
+			return nil
+		}
+
+		var info fs.FileInfo
+		if err := retrySource(ctx, opts, func() (err error) { info, err = d.Info(); return err }); err != nil {
+			return unreadable(path, rel, d, err)
+		}
+		if reason := opts.skipReason(plan, rel, info); reason != "" {
+			key := src.Prefix + fileKey(opts, rel, info.ModTime())
+			plan.Filtered = append(plan.Filtered, File{Key: key, Path: path, Size: info.Size(), ModTime: info.ModTime(), skipped: reason})
+			plan.dirs.forget(src.Prefix + dirOf(rel)) // as for ignored files
+			return nil
+		}
+		name := plan.cases.check(opts, src.Prefix, rel)
+		var file File
+		err = retrySource(ctx, opts, func() (err error) {
+			file, err = newFile(opts, path, name, info)
+			return err
+		})
+		if err != nil {
+			return unreadable(path, rel, d, err)
+		}
+		file.Key = src.Prefix + file.Key
+		if err := plan.claimKey(opts, file.Key, path); err != nil {
+			return err
+		}
+		empty.file(rel)
+		if planLink(opts, plan, file, info) {
+			return nil
+		}
+		_, always := comparerFor(opts.Compare, file.Key).(AlwaysUpload)
+		if unchanged[dirOf(rel)] && !always && !matchKey(opts.Reupload, src.Prefix+rel) {
+			// Uploaded or found up to date by the last run, and not
+			// modified since.
+			return ahead.add("", func(*ObjectMeta, error) error {
+				if hit, err := plan.state.lookup(file); err != nil {
+					return err
+				} else if !hit {
+					if err := plan.state.record(file); err != nil {
+						return err
+					}
+				}
+				plan.Files = append(plan.Files, file.upToDate())
+				return planned(file)
+			})
+		}
+		c, err := checkFile(opts, plan, file)
+		if err != nil {
+			return err
+		}
+		key := ""
+		if c.stat {
+			key = file.Key
+		}
+		return ahead.add(key, func(meta *ObjectMeta, err error) error {
+			if err != nil {
+				return &FileError{Op: "stat", Key: file.Key, Err: err}
+			}
+			if err := planChecked(ctx, opts, plan, file, c, meta); err != nil {
+				return err
+			}
+			return planned(file)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	plan.emptyDirs = append(plan.emptyDirs, empty.keys()...)
+	return ahead.flush()
+}
+
+// planFile looks up file at the destination and adds it to plan, and to
+// plan.Uploads unless the destination's copy is up to date.
+func planFile(ctx context.Context, opts Options, plan *Plan, file File) error {
+	c, err := checkFile(opts, plan, file)
+	if err != nil {
+		return err
+	}
+	var meta *ObjectMeta
+	if c.stat {
+		if meta, err = opts.Dst.Stat(ctx, file.Key); err != nil {
+			return &FileError{Op: "stat", Key: file.Key, Err: err}
+		}
+	}
+	return planChecked(ctx, opts, plan, file, c, meta)
+}
+
+// fileCheck is what planFile finds out about a file before asking the
+// destination about it.
+type fileCheck struct {
+	compare  Comparer
+	reupload bool // to be uploaded whatever the destination holds
+	hit      bool // up to date, as the state cache says
+	stat     bool // the destination must be asked for its copy
+}
+
+// checkFile finds out what it can about file without asking the
+// destination.
+func checkFile(opts Options, plan *Plan, file File) (fileCheck, error) {
+	c := fileCheck{compare: comparerFor(opts.Compare, file.Key)}
+	if err := checkKeyLength(opts, file); err != nil {
+		return c, err
+	}
+	_, always := c.compare.(AlwaysUpload)
+	c.reupload = always || matchKey(opts.Reupload, file.Key)
+	r, ok := c.compare.(Reconciler)
+	due := ok && r.due(file.Key)
+	if !c.reupload && !due {
+		hit, err := plan.state.lookup(file)
+		if err != nil {
+			return c, err
+		}
+		c.hit = hit
+	}
+	// Bundled files are compared to the bundle index, and those of an
+	// incremental run that the state cache does not vouch for have
+	// changed.
+	c.stat = !c.hit && !opts.Bundle.bundles(file) && (!plan.incremental || due)
+	return c, nil
+}
+
+// planChecked adds file to plan, as planFile does, given what checkFile
+// found out about it and meta, the destination's copy, if c.stat says it
+// was asked for.
+func planChecked(ctx context.Context, opts Options, plan *Plan, file File, c fileCheck, meta *ObjectMeta) error {
+	switch {
+	case c.hit:
+		plan.Files = append(plan.Files, file.upToDate())
+		return nil
+	case opts.Bundle.bundles(file):
+		return planBundled(opts, plan, file)
+	case !c.stat:
+		plan.Files = append(plan.Files, file)
+		plan.Uploads = append(plan.Uploads, file)
+		return nil
+	}
+
+	file.Remote = meta
+	plan.Files = append(plan.Files, file)
+
+	if meta != nil && !c.reupload {
+		equal, err := c.compare.Equal(ctx, opts.Dst, file)
+		if err != nil && readFailed(err, file.Path) && plan.skipUnreadable(opts, file.Path, err) == nil {
+			return nil // left out of the run by commit
+		} else if err != nil {
+			return &FileError{Op: "compare", Key: file.Key, Err: err}
+		}
+		// Metadata can only be replaced by uploading the object again.
+		if equal && (!opts.PreservePOSIX || file.POSIX.Equal(meta.POSIX)) {
+			return planTransition(opts, plan, file) // already up to date
+		}
+	}
+
+	plan.Uploads = append(plan.Uploads, file)
+	return nil
+}
+
+// matchKey reports whether key, or a directory containing it, matches one
+// of patterns. See Options.Reupload.
+func matchKey(patterns []string, key string) bool {
+	for _, p := range patterns {
+		for k := key; k != "."; k = path.Dir(k) {
+			if ok, _ := path.Match(p, k); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newFile describes the source file at path, rel inside opts.Src.
+func newFile(opts Options, path, rel string, info fs.FileInfo) (File, error) {
+	file := File{
+		Key:     fileKey(opts, rel, info.ModTime()),
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		hash:    new(fileHash),
+	}
+	file.Class = tierClass(opts.Tiers, file.Key, file.ModTime, time.Now())
+	if opts.PreservePOSIX {
+		var err error
+		if file.POSIX, err = posixAttrs(opts, path, info); err != nil {
+			return File{}, err
+		}
+	}
+	opts.hashCache.lookup(file, info)
+	return file, nil
+}
+
+// sourceKey returns the destination key for path, inside src.
+func sourceKey(src, path string) (string, error) {
+	rel, err := filepath.Rel(src, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil // S3 keys use forward slashes
+}
+
+// planDeletes adds the keys at opts.Dst absent from the source to plan. It
+// merges the listing, a page at a time, with the sorted keys of the files
+// the plan holds, so that only keys missing from those are looked for in
+// the source. Keys outside the prefixes of Options.Sources are left alone,
+// as are those of paths the IgnoreFiles exclude and those the key layout
+// maps to no path, unless Options.DeleteExcluded is set.
+func planDeletes(ctx context.Context, opts Options, plan *Plan) error {
+	local := plannedKeys(plan)
+	ignorers := make(map[string]*ignorer) // by source directory
+	return listForDelete(ctx, opts, plan, func(keys []string, written map[string]time.Time) error {
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if strings.HasPrefix(key, metaPrefix) {
+				continue
+			}
+			for len(local) > 0 && local[0] < key {
+				local = local[1:]
+			}
+			if len(local) > 0 && local[0] == key {
+				continue
+			}
+			s, rest, ok := sourceFor(opts, key)
+			if !ok {
+				continue // outside the prefixes of Options.Sources
+			}
+			// Keys the layout maps to no path, such as those of an
+			// earlier layout, cannot be the current source's either, and
+			// are excluded as ignored paths are.
+			excluded := true
+			if rel, ok := keyMapper(opts.Keys).Path(rest); ok {
+				if ignorers[s.Dir] == nil {
+					ignorers[s.Dir] = newIgnorer(longPath(s.Dir))
+				}
+				var err error
+				if excluded, err = ignorers[s.Dir].ignored(rel, false); err != nil && opts.OnWalkError == WalkErrorsSkip {
+					continue // kept, as the files of unreadable directories are
+				} else if err != nil {
+					return err
+				}
+			}
+			if excluded && !opts.DeleteExcluded || !excluded && isSourceKey(opts, key) {
+				continue
+			}
+			if t, ok := written[key]; ok && time.Since(t) >= opts.ExpireAfter {
+				plan.Expiring = append(plan.Expiring, key)
+				continue
+			}
+			plan.Deletes = append(plan.Deletes, key)
+		}
+		return nil
+	})
+}
+
+// plannedKeys returns the keys of the source files plan holds, sorted: the
+// keys they are stored under, after normalization, case-collision renames
+// and the key layout, which a walk of the source cannot tell.
+func plannedKeys(plan *Plan) []string {
+	keys := make([]string, 0, len(plan.Files)+len(plan.Filtered))
+	for _, f := range plan.Files {
+		keys = append(keys, f.Key)
+	}
+	for _, f := range plan.Filtered {
+		keys = append(keys, f.Key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// listForDelete calls fn with the keys at opts.Dst, a page at a time and in
+// byte order. With opts.ExpireAfter set, it also passes the time each was
+// written. Incremental runs take the keys the state cache recorded instead.
+func listForDelete(ctx context.Context, opts Options, plan *Plan, fn func(keys []string, written map[string]time.Time) error) error {
+	if plan.incremental {
+		return fn(slices.Sorted(maps.Keys(plan.state.old)), nil)
+	}
+	if opts.ExpireAfter <= 0 {
+		return opts.Dst.List(ctx, func(keys []string) error {
+			return fn(keys, nil)
+		})
+	}
+	written, err := listWritten(ctx, opts.Dst)
+	if err != nil {
+		return err
+	}
+	return fn(slices.Sorted(maps.Keys(written)), written)
+}
+
+// posixAttrs reads the attributes of path for Options.PreservePOSIX,
+// dropping extended attributes too large to store as object metadata.
+func posixAttrs(opts Options, path string, info fs.FileInfo) (*POSIXAttrs, error) {
+	attrs, err := readPOSIX(path, info)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(encodeXattrs(attrs.Xattrs)); n > maxXattrMetadata {
+		opts.warnf("%s: extended attributes too large to preserve (%d bytes)", path, n)
+		attrs.Xattrs = nil
+	}
+	return attrs, nil
+}
+
+// dirOf returns the directory of key as recorded in the directory cache:
+// "." for keys at the top of the source tree.
+func dirOf(key string) string {
+	return path.Dir(key)
+}
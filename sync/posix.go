@@ -0,0 +1,189 @@
+package sync
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"maps"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// maxXattrMetadata is the largest encoded set of extended attributes that
+// is stored with an object. S3 allows 2 KB of user metadata in total.
+const maxXattrMetadata = 1536
+
+// POSIXAttrs holds the permissions, ownership and extended attributes of a
+// file. Extended attributes include POSIX ACLs on filesystems that store
+// them as system.posix_acl_* attributes.
+type POSIXAttrs struct {
+	Mode   fs.FileMode // permission bits plus setuid, setgid and sticky
+	UID    int         // -1 if unknown
+	GID    int         // -1 if unknown
+	Xattrs map[string][]byte
+}
+
+// Equal reports whether a and b describe the same attributes. Two nil
+// values are equal.
+func (a *POSIXAttrs) Equal(b *POSIXAttrs) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.UID == b.UID && a.GID == b.GID &&
+		maps.EqualFunc(a.Xattrs, b.Xattrs, func(x, y []byte) bool { return string(x) == string(y) })
+}
+
+const modeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// readPOSIX returns the attributes of the file at path, whose FileInfo is
+// info.
+func readPOSIX(path string, info fs.FileInfo) (*POSIXAttrs, error) {
+	attrs := &POSIXAttrs{Mode: info.Mode() & modeBits}
+	attrs.UID, attrs.GID = fileOwner(info)
+	xattrs, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	attrs.Xattrs = xattrs
+	return attrs, nil
+}
+
+// applyPOSIX sets attrs on the file at path, and returns the attributes
+// it was not permitted to set, or nil if it set them all: only root can
+// give files away, and some extended attributes, such as trusted.*, need
+// privileges too. Of the attributes returned, UID and GID are -1 if the
+// ownership was set, and Xattrs holds only those that were not; Mode is
+// that of attrs. Extended attributes are skipped where the filesystem does
+// not support them.
+func applyPOSIX(path string, attrs *POSIXAttrs) (*POSIXAttrs, error) {
+	if attrs == nil {
+		return nil, nil
+	}
+	denied := &POSIXAttrs{Mode: attrs.Mode, UID: -1, GID: -1}
+	if attrs.UID >= 0 || attrs.GID >= 0 {
+		err := os.Lchown(path, attrs.UID, attrs.GID)
+		if errors.Is(err, fs.ErrPermission) {
+			denied.UID, denied.GID = attrs.UID, attrs.GID
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	names, err := setXattrs(path, attrs.Xattrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if denied.Xattrs == nil {
+			denied.Xattrs = make(map[string][]byte)
+		}
+		denied.Xattrs[name] = attrs.Xattrs[name]
+	}
+	// chmod last: chown clears setuid and setgid.
+	if err := os.Chmod(path, attrs.Mode); err != nil {
+		return nil, err
+	}
+	if denied.UID < 0 && denied.GID < 0 && denied.Xattrs == nil {
+		return nil, nil
+	}
+	return denied, nil
+}
+
+// encode adds attrs to object metadata md. A nil attrs adds nothing.
+func (a *POSIXAttrs) encode(md map[string]string) {
+	if a == nil {
+		return
+	}
+	md["mode"] = strconv.FormatUint(uint64(unixMode(a.Mode)), 8)
+	if a.UID >= 0 {
+		md["uid"] = strconv.Itoa(a.UID)
+	}
+	if a.GID >= 0 {
+		md["gid"] = strconv.Itoa(a.GID)
+	}
+	if x := encodeXattrs(a.Xattrs); x != "" {
+		md["xattrs"] = x
+	}
+}
+
+// decodePOSIX reads the attributes written by encode, or returns nil if
+// md has none.
+func decodePOSIX(md map[string]string) *POSIXAttrs {
+	v, ok := md["mode"]
+	if !ok {
+		return nil
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil {
+		return nil
+	}
+	attrs := &POSIXAttrs{Mode: fileMode(uint32(mode)), UID: -1, GID: -1}
+	if id, err := strconv.Atoi(md["uid"]); err == nil {
+		attrs.UID = id
+	}
+	if id, err := strconv.Atoi(md["gid"]); err == nil {
+		attrs.GID = id
+	}
+	attrs.Xattrs = decodeXattrs(md["xattrs"])
+	return attrs
+}
+
+// encodeXattrs packs extended attributes into a header-safe string:
+// url-encoded names with base64 values.
+func encodeXattrs(xattrs map[string][]byte) string {
+	if len(xattrs) == 0 {
+		return ""
+	}
+	q := url.Values{}
+	for name, value := range xattrs {
+		q.Set(name, base64.StdEncoding.EncodeToString(value))
+	}
+	return q.Encode()
+}
+
+func decodeXattrs(s string) map[string][]byte {
+	q, err := url.ParseQuery(s)
+	if err != nil || len(q) == 0 {
+		return nil
+	}
+	xattrs := make(map[string][]byte, len(q))
+	for name := range q {
+		value, err := base64.StdEncoding.DecodeString(q.Get(name))
+		if err != nil {
+			continue
+		}
+		xattrs[name] = value
+	}
+	return xattrs
+}
+
+// unixMode converts the permission and special bits of m to their
+// traditional octal representation, e.g. 04755.
+func unixMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+	return mode
+}
+
+// fileMode is the inverse of unixMode.
+func fileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0o777)
+	if mode&0o4000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
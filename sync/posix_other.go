@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package sync
+
+import "io/fs"
+
+// Ownership and extended attributes are not recorded on this platform;
+// only the permission bits reported by the OS are.
+
+func fileOwner(fs.FileInfo) (uid, gid int) { return -1, -1 }
+
+func listXattrs(string) (map[string][]byte, error) { return nil, nil }
+
+func setXattrs(string, map[string][]byte) ([]string, error) { return nil, nil }
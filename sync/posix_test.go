@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPOSIXMetadata_roundTrip(t *testing.T) {
+	attrs := &POSIXAttrs{
+		Mode: 0750 | fs.ModeSetgid,
+		UID:  1000,
+		GID:  100,
+		Xattrs: map[string][]byte{
+			"user.origin":             []byte("scanner 3"),
+			"system.posix_acl_access": {2, 0, 0, 0, 1, 0, 6, 0},
+		},
+	}
+	md := objectMetadata(ObjectMeta{Size: 3, POSIX: attrs})
+	if md["mode"] != "2750" {
+		t.Errorf("mode = %q, want 2750", md["mode"])
+	}
+
+	got := parseMetadata(3, md).POSIX
+	if !got.Equal(attrs) {
+		t.Errorf("round trip = %+v, want %+v", got, attrs)
+	}
+}
+
+func TestPOSIXMetadata_absent(t *testing.T) {
+	md := objectMetadata(ObjectMeta{Size: 3})
+	if _, ok := md["mode"]; ok {
+		t.Error("mode recorded without POSIX attributes")
+	}
+	if got := parseMetadata(3, md).POSIX; got != nil {
+		t.Errorf("POSIX = %+v, want nil", got)
+	}
+}
+
+func TestSync_preservePOSIXRecordsMode(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "run.sh", "#!/bin/sh")
+	if err := os.Chmod(filepath.Join(src, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, PreservePOSIX: true}); err != nil {
+		t.Fatal(err)
+	}
+	attrs := dst.objects["run.sh"].POSIX
+	if attrs == nil || attrs.Mode != 0755 {
+		t.Fatalf("POSIX = %+v, want mode 0755", attrs)
+	}
+}
+
+func TestSync_preservePOSIXReuploadsOnModeChange(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "run.sh", "#!/bin/sh")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, PreservePOSIX: true}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "run.sh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	dst.putCalls = nil
+
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 1 {
+		t.Errorf("expected run.sh to be re-uploaded, got %v", dst.putCalls)
+	}
+}
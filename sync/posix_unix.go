@@ -0,0 +1,88 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package sync
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func fileOwner(info fs.FileInfo) (uid, gid int) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(st.Uid), int(st.Gid)
+}
+
+func listXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, ignoreNoXattrs(err)
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, ignoreNoXattrs(err)
+	}
+
+	xattrs := make(map[string][]byte)
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		value, err := getXattr(path, name)
+		if errors.Is(err, unix.ENODATA) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "getxattr " + name, Path: path, Err: err}
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+// setXattrs sets xattrs on the file at path, and returns the names of
+// those it was not permitted to set.
+func setXattrs(path string, xattrs map[string][]byte) (denied []string, err error) {
+	for name, value := range xattrs {
+		err := unix.Setxattr(path, name, value, 0)
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil // the filesystem cannot hold any of them
+		}
+		if errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+			// e.g. trusted.* needs privileges the restorer lacks
+			denied = append(denied, name)
+			continue
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "setxattr " + name, Path: path, Err: err}
+		}
+	}
+	return denied, nil
+}
+
+func ignoreNoXattrs(err error) error {
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PrefixVars are the values of the variables ExpandPrefix expands.
+type PrefixVars struct {
+	Hostname string    // the name of the machine
+	Profile  string    // the AWS shared config profile the run uses
+	Time     time.Time // when the run started
+}
+
+// DefaultDateLayout is the layout of {date} without one of its own.
+const DefaultDateLayout = "2006-01-02"
+
+// ExpandPrefix expands the variables in the path of the destination URL
+// rawURL, so that one configuration can serve several machines, or keep a
+// prefix per day:
+//
+//	{hostname}     vars.Hostname
+//	{profile}      vars.Profile
+//	{date}         the date of vars.Time, as 2024-03-01
+//	{date:layout}  vars.Time in the Go time layout, as {date:2006/01} for 2024/03
+//
+// {{ stands for a literal {. The scheme, host and parameters of rawURL are
+// left alone. An unknown variable, or one whose value is empty, is an
+// error.
+func ExpandPrefix(rawURL string, vars PrefixVars) (string, error) {
+	start := 0
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		start = i + len("://")
+		if j := strings.IndexByte(rawURL[start:], '/'); j >= 0 {
+			start += j
+		} else {
+			start = len(rawURL)
+		}
+	}
+	end := len(rawURL)
+	if i := strings.IndexAny(rawURL[start:], "?#"); i >= 0 {
+		end = start + i
+	}
+	path, err := expandPrefixVars(rawURL[start:end], vars)
+	if err != nil {
+		return "", err
+	}
+	return rawURL[:start] + path + rawURL[end:], nil
+}
+
+// expandPrefixVars expands the variables in s. See ExpandPrefix.
+func expandPrefixVars(s string, vars PrefixVars) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		if strings.HasPrefix(s[i:], "{{") {
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		ref, rest, ok := strings.Cut(s[i+1:], "}")
+		if !ok {
+			return "", fmt.Errorf("unterminated { in %q", s)
+		}
+		name, layout, _ := strings.Cut(ref, ":")
+		var v string
+		switch name {
+		case "hostname":
+			v = vars.Hostname
+		case "profile":
+			v = vars.Profile
+		case "date":
+			if layout == "" {
+				layout = DefaultDateLayout
+			}
+			if !vars.Time.IsZero() {
+				v = vars.Time.Format(layout)
+			}
+		default:
+			return "", fmt.Errorf("{%s}: unknown variable %q (want hostname, profile or date)", ref, name)
+		}
+		if name != "date" && layout != "" {
+			return "", fmt.Errorf("{%s}: %s takes no layout", ref, name)
+		}
+		if v == "" {
+			return "", fmt.Errorf("{%s}: no value", ref)
+		}
+		b.WriteString(v)
+		s = rest
+	}
+}
@@ -0,0 +1,38 @@
+package sync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandPrefix(t *testing.T) {
+	vars := PrefixVars{Hostname: "laptop", Profile: "backup", Time: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)}
+	for _, tt := range []struct {
+		url, want string
+	}{
+		{"s3://bucket/{hostname}/photos", "s3://bucket/laptop/photos"},
+		{"s3://bucket/daily/{date}", "s3://bucket/daily/2024-03-01"},
+		{"gs://bucket/{profile}/{date:2006/01}/{hostname}?storage-class=NEARLINE", "gs://bucket/backup/2024/03/laptop?storage-class=NEARLINE"},
+		{"file:///srv/backup/{hostname}", "file:///srv/backup/laptop"},
+		{"s3://bucket/{{hostname}", "s3://bucket/{hostname}"},
+		{"s3://bucket", "s3://bucket"},
+		{"s3://bucket/plain?x={y}", "s3://bucket/plain?x={y}"},
+	} {
+		if got, err := ExpandPrefix(tt.url, vars); err != nil || got != tt.want {
+			t.Errorf("ExpandPrefix(%q) = %q, %v; want %q", tt.url, got, err, tt.want)
+		}
+	}
+
+	for _, url := range []string{
+		"s3://bucket/{user}",
+		"s3://bucket/{hostname",
+		"s3://bucket/{hostname:short}",
+	} {
+		if got, err := ExpandPrefix(url, vars); err == nil {
+			t.Errorf("ExpandPrefix(%q) = %q, want an error", url, got)
+		}
+	}
+	if got, err := ExpandPrefix("s3://bucket/{profile}", PrefixVars{}); err == nil {
+		t.Errorf("ExpandPrefix without a profile = %q, want an error", got)
+	}
+}
@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Priority is the order in which the files of a plan are uploaded. See
+// Options.Priority.
+type Priority int
+
+const (
+	// PriorityPath uploads files in key order, as they are listed.
+	PriorityPath Priority = iota
+	// PrioritySmallestFirst uploads the smallest files first, so that a
+	// run cut short has uploaded as many files as it could.
+	PrioritySmallestFirst
+	// PriorityLargestFirst uploads the largest files first.
+	PriorityLargestFirst
+	// PriorityNewestFirst uploads the files modified most recently first,
+	// the ones most likely to have no other copy.
+	PriorityNewestFirst
+)
+
+var priorityNames = []string{"path-order", "smallest-first", "largest-first", "newest-first"}
+
+// ParsePriority parses the names used on the command line: path-order,
+// smallest-first, largest-first and newest-first.
+func ParsePriority(s string) (Priority, error) {
+	if i := slices.Index(priorityNames, s); i >= 0 {
+		return Priority(i), nil
+	}
+	return 0, fmt.Errorf("unknown priority %q (valid: path-order, smallest-first, largest-first, newest-first)", s)
+}
+
+func (p Priority) String() string {
+	if p < 0 || int(p) >= len(priorityNames) {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// prioritize sorts files, which are in key order, by p. Files that p ranks
+// alike stay in key order.
+func prioritize(p Priority, files []File) {
+	var by func(a, b File) int
+	switch p {
+	case PrioritySmallestFirst:
+		by = func(a, b File) int { return cmp.Compare(a.Size, b.Size) }
+	case PriorityLargestFirst:
+		by = func(a, b File) int { return cmp.Compare(b.Size, a.Size) }
+	case PriorityNewestFirst:
+		by = func(a, b File) int { return b.ModTime.Compare(a.ModTime) }
+	default:
+		return
+	}
+	slices.SortStableFunc(files, by)
+}
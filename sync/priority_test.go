@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSync_priority(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "aaaa")
+	writeFile(t, src, "b.txt", "b")
+	writeFile(t, src, "c.txt", "cc")
+	writeFile(t, src, "d.txt", "dd")
+	now := time.Now()
+	for i, name := range []string{"c.txt", "a.txt", "d.txt", "b.txt"} {
+		mtime := now.Add(time.Duration(i-10) * time.Hour)
+		if err := os.Chtimes(filepath.Join(src, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, want := range map[Priority][]string{
+		PriorityPath:          {"a.txt", "b.txt", "c.txt", "d.txt"},
+		PrioritySmallestFirst: {"b.txt", "c.txt", "d.txt", "a.txt"},
+		PriorityLargestFirst:  {"a.txt", "c.txt", "d.txt", "b.txt"},
+		PriorityNewestFirst:   {"b.txt", "d.txt", "a.txt", "c.txt"},
+	} {
+		dst := newMockDest()
+		if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Priority: p}); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(dst.putCalls, want) {
+			t.Errorf("%v: uploaded %v, want %v", p, dst.putCalls, want)
+		}
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []Priority{PriorityPath, PrioritySmallestFirst, PriorityLargestFirst, PriorityNewestFirst} {
+		if got, err := ParsePriority(p.String()); err != nil || got != p {
+			t.Errorf("ParsePriority(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParsePriority("random"); err == nil {
+		t.Error("accepted random")
+	}
+}
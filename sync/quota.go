@@ -0,0 +1,169 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrQuotaExceeded is returned when a plan would leave more data at the
+// destination than Options.MaxDstSize allows.
+var ErrQuotaExceeded = errors.New("destination quota exceeded")
+
+// dstSizes returns the size of every object in dst, from one listing if
+// dst is an ObjectLister and otherwise by asking about each object.
+func dstSizes(ctx context.Context, dst Destination) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	if l, ok := dst.(ObjectLister); ok {
+		listed, err := l.ListObjects(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for key, o := range listed {
+			sizes[key] = o.Size
+		}
+		return sizes, nil
+	}
+	err := dst.List(ctx, func(keys []string) error {
+		for _, key := range keys {
+			meta, err := dst.Stat(ctx, key)
+			if err != nil {
+				return &FileError{Op: "stat", Key: key, Err: err}
+			}
+			if meta != nil {
+				sizes[key] = meta.Size
+			}
+		}
+		return nil
+	})
+	return sizes, err
+}
+
+// uploadGrowth is how much uploading f adds to a destination holding
+// objects of sizes. With snapshots, the object it replaces is kept.
+func uploadGrowth(opts Options, f File, sizes map[string]int64) int64 {
+	if opts.Snapshots {
+		return f.Size
+	}
+	return f.Size - sizes[f.Key]
+}
+
+// sizeAfter estimates how much data a destination holding objects of sizes
+// holds once plan has been applied. Bundles are counted at the size of the
+// files they pack.
+func sizeAfter(opts Options, plan *Plan, sizes map[string]int64) int64 {
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	for _, f := range plan.Uploads {
+		total += uploadGrowth(opts, f, sizes)
+	}
+	for _, f := range plan.Bundled {
+		total += f.Size
+	}
+	if !opts.Snapshots {
+		for _, key := range plan.Deletes {
+			total -= sizes[key]
+		}
+	}
+	return total
+}
+
+// checkQuota fails with ErrQuotaExceeded if applying plan would leave more
+// than opts.MaxDstSize bytes at the destination. Before failing, it drops
+// the uploads matching each of opts.QuotaTrim in turn from plan, until the
+// rest fits, and then with opts.QuotaFill, the uploads from the first that
+// does not fit on.
+func checkQuota(ctx context.Context, opts Options, plan *Plan) error {
+	if opts.MaxDstSize <= 0 {
+		return nil
+	}
+	sizes, err := dstSizes(ctx, opts.Dst)
+	if err != nil {
+		return fmt.Errorf("quota: %w", err)
+	}
+	after := sizeAfter(opts, plan, sizes)
+	for _, pattern := range opts.QuotaTrim {
+		if after <= opts.MaxDstSize {
+			break
+		}
+		after -= trimUploads(opts, plan, pattern, sizes)
+	}
+	if after > opts.MaxDstSize && opts.QuotaFill {
+		after = fillQuota(opts, plan, sizes, after)
+	}
+	if after > opts.MaxDstSize {
+		return fmt.Errorf("%w: the destination would hold %s, over its quota of %s",
+			ErrQuotaExceeded, FormatSize(after), FormatSize(opts.MaxDstSize))
+	}
+	return nil
+}
+
+// trimUploads drops the uploads and bundled files of plan whose keys match
+// pattern, as for Options.Reupload, and returns by how much less the
+// destination grows.
+func trimUploads(opts Options, plan *Plan, pattern string, sizes map[string]int64) int64 {
+	var saved int64
+	dropUploads(opts, plan, sizes, func(f File, growth int64) bool {
+		if !matchKey([]string{pattern}, f.Key) {
+			return false
+		}
+		saved += growth
+		return true
+	})
+	return saved
+}
+
+// fillQuota keeps the uploads and bundled files of plan, in order, while
+// the destination, holding after bytes once plan has been applied, stays
+// within opts.MaxDstSize, and drops the rest from the first that does not
+// fit. It returns what the destination holds then, and logs what was left
+// out.
+func fillQuota(opts Options, plan *Plan, sizes map[string]int64, after int64) int64 {
+	// What the destination holds with none of them.
+	for _, f := range plan.Uploads {
+		after -= uploadGrowth(opts, f, sizes)
+	}
+	for _, f := range plan.Bundled {
+		after -= f.Size
+	}
+	var full bool
+	var skipped int
+	var skippedBytes int64
+	dropUploads(opts, plan, sizes, func(f File, growth int64) bool {
+		if !full && after+growth <= opts.MaxDstSize {
+			after += growth
+			return false
+		}
+		full = true
+		skipped++
+		skippedBytes += f.Size
+		return true
+	})
+	if skipped > 0 {
+		opts.logf("quota: skipped %d files (%s) that would take the destination over its quota of %s; they are tried again next run",
+			skipped, FormatSize(skippedBytes), FormatSize(opts.MaxDstSize))
+	}
+	return after
+}
+
+// dropUploads drops the uploads and bundled files of plan for which drop,
+// given how much each grows the destination, reports true. They are left
+// out of the manifest and the caches, so the next run tries them again.
+func dropUploads(opts Options, plan *Plan, sizes map[string]int64, drop func(f File, growth int64) bool) {
+	dropped := make(map[string]bool)
+	trim := func(f File, growth int64) bool {
+		if !drop(f, growth) {
+			return false
+		}
+		opts.report(Event{Action: "skip", Key: f.Key, Reason: "over quota"})
+		dropped[f.Key] = true
+		plan.forget(opts, f.Key)
+		return true
+	}
+	plan.Uploads = slices.DeleteFunc(plan.Uploads, func(f File) bool { return trim(f, uploadGrowth(opts, f, sizes)) })
+	plan.Bundled = slices.DeleteFunc(plan.Bundled, func(f File) bool { return trim(f, f.Size) })
+	plan.Files = slices.DeleteFunc(plan.Files, func(f File) bool { return dropped[f.Key] })
+}
@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSync_quota(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	writeFile(t, src, "logs/b.log", strings.Repeat("x", 20))
+	newDst := func() *mockDest {
+		dst := newMockDest()
+		dst.objects["old.bin"] = &ObjectMeta{Size: 10}
+		dst.data["old.bin"] = make([]byte, 10)
+		return dst
+	}
+
+	dst := newDst()
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, MaxDstSize: 30})
+	if !errors.Is(err, ErrQuotaExceeded) || len(dst.putCalls) != 0 {
+		t.Fatalf("Sync = %v, put %v; want ErrQuotaExceeded before uploading", err, dst.putCalls)
+	}
+
+	// Deleting the old object makes room.
+	dst = newDst()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, MaxDstSize: 30, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The logs are dropped to make the rest fit.
+	dst = newDst()
+	_, err = Sync(context.Background(), Options{Src: src, Dst: dst, MaxDstSize: 30, QuotaTrim: []string{"*.mp4", "logs"}, Manifest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.putCalls, []string{"a.txt", ManifestKey}) {
+		t.Errorf("put %v, want a.txt and the manifest", dst.putCalls)
+	}
+	m, err := ReadManifest(context.Background(), dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Key != "a.txt" {
+		t.Errorf("manifest lists %+v, want a.txt only", m.Files)
+	}
+}
+
+func TestSync_quotaFill(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", strings.Repeat("a", 10))
+	writeFile(t, src, "b.txt", strings.Repeat("b", 10))
+	writeFile(t, src, "c.txt", "c")
+	dst := newMockDest()
+
+	var skipped []string
+	opts := Options{Src: src, Dst: dst, MaxDstSize: 15, QuotaFill: true, OnEvent: func(e Event) {
+		if e.Action == "skip" {
+			skipped = append(skipped, e.Key)
+		}
+	}}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.putCalls, []string{"a.txt"}) {
+		t.Errorf("put %v, want a.txt only", dst.putCalls)
+	}
+	// c.txt would fit, but comes after the first that does not.
+	if !slices.Equal(skipped, []string{"b.txt", "c.txt"}) {
+		t.Errorf("skipped %v, want b.txt and c.txt", skipped)
+	}
+}
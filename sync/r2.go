@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func init() {
+	Register("r2", openR2URL)
+	RegisterStorageClasses("r2", string(types.StorageClassStandard), string(types.StorageClassStandardIa))
+}
+
+// Cloudflare account IDs and the credentials of R2 API tokens are
+// lowercase hexadecimal.
+var (
+	r2AccountID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	r2KeyID     = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	r2Secret    = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// r2Unsupported are the parameters of s3:// URLs that r2:// URLs reject,
+// for features R2 does not have or that the preset sets itself.
+var r2Unsupported = []string{
+	"endpoint-url", "path-style", "accelerate", "request-payer",
+	"sse", "sse-kms-key-id", "sse-context",
+	"profile", "role-arn", "external-id", "role-session-name",
+}
+
+// CheckR2AccountID reports whether id, as given to the account-id
+// parameter of an r2:// URL, is a Cloudflare account ID.
+func CheckR2AccountID(id string) error {
+	if !r2AccountID.MatchString(id) {
+		return fmt.Errorf("account ID %q: want the 32 hexadecimal digits shown on the R2 overview page of the Cloudflare dashboard", id)
+	}
+	return nil
+}
+
+// R2Endpoint returns the S3 API endpoint of the R2 buckets of account
+// created in jurisdiction, which is empty for the default one, "eu" or
+// "fedramp".
+func R2Endpoint(account, jurisdiction string) (string, error) {
+	if err := CheckR2AccountID(account); err != nil {
+		return "", err
+	}
+	switch jurisdiction {
+	case "":
+		return "https://" + account + ".r2.cloudflarestorage.com", nil
+	case "eu", "fedramp":
+		return "https://" + account + "." + jurisdiction + ".r2.cloudflarestorage.com", nil
+	}
+	return "", fmt.Errorf("jurisdiction=%q: want eu or fedramp", jurisdiction)
+}
+
+// r2Credentials returns the credentials of an R2 API token from the
+// environment: R2_ACCESS_KEY_ID and R2_SECRET_ACCESS_KEY, or else
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. R2 derives them from the
+// token, so unlike AWS keys they have a fixed form.
+func r2Credentials() (aws.CredentialsProvider, error) {
+	id, secret := os.Getenv("R2_ACCESS_KEY_ID"), os.Getenv("R2_SECRET_ACCESS_KEY")
+	if id == "" && secret == "" {
+		id, secret = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	switch {
+	case id == "" || secret == "":
+		return nil, errors.New("set R2_ACCESS_KEY_ID and R2_SECRET_ACCESS_KEY to the Access Key ID and Secret Access Key of an R2 API token")
+	case !r2KeyID.MatchString(id):
+		return nil, errors.New("the access key ID is not that of an R2 API token, which has 32 hexadecimal digits; a Cloudflare API token or Global API Key cannot sign S3 requests")
+	case !r2Secret.MatchString(secret):
+		return nil, errors.New("the secret access key is not that of an R2 API token, which has 64 hexadecimal digits; a Cloudflare API token or Global API Key cannot sign S3 requests")
+	}
+	return credentials.NewStaticCredentialsProvider(id, secret, ""), nil
+}
+
+// openR2URL opens r2://bucket/prefix, a Cloudflare R2 bucket, through its
+// S3-compatible API with the credentials of an R2 API token; see
+// r2Credentials. Objects are uploaded as with compat=legacy, without the
+// additional checksums R2 does not support. Query parameters:
+//
+//	account-id      Cloudflare account ID (default: $CLOUDFLARE_ACCOUNT_ID)
+//	jurisdiction    eu or fedramp, for buckets created in one
+//	storage-class   STANDARD (default) or STANDARD_IA (Infrequent Access)
+//	compat          legacy (default) or full
+//	part-size-mb, upload-concurrency, adaptive-concurrency,
+//	leave-parts-on-error, list-concurrency
+//	                as for s3:// URLs
+//
+// R2 has a single region, "auto", and no KMS, object tags or Object Lock
+// headers, so the s3:// parameters for these are rejected.
+func openR2URL(ctx context.Context, u *url.URL) (Destination, error) {
+	bucket, prefix, err := bucketAndPrefix(u)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	for _, name := range r2Unsupported {
+		if q.Has(name) {
+			return nil, fmt.Errorf("r2:// destinations do not support %s", name)
+		}
+	}
+	if region := q.Get("region"); region != "" && region != "auto" {
+		return nil, fmt.Errorf("region=%q: R2 buckets are in region auto; use jurisdiction for eu or fedramp buckets", region)
+	}
+	account := q.Get("account-id")
+	if account == "" {
+		account = os.Getenv("CLOUDFLARE_ACCOUNT_ID")
+	}
+	if account == "" {
+		return nil, errors.New("set account-id or CLOUDFLARE_ACCOUNT_ID to the Cloudflare account of the bucket")
+	}
+	endpoint, err := R2Endpoint(account, q.Get("jurisdiction"))
+	if err != nil {
+		return nil, err
+	}
+	opts := []S3Option{WithS3Endpoint(endpoint)}
+	if sc := q.Get("storage-class"); sc != "" {
+		if err := CheckStorageClass("r2", sc); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithS3StorageClass(types.StorageClass(sc)))
+	}
+	transfer, err := s3TransferOptions(q)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, transfer...)
+	compat := S3CompatLegacy
+	if q.Has("compat") {
+		if compat, err = ParseS3Compat(q.Get("compat")); err != nil {
+			return nil, err
+		}
+	}
+	opts = append(opts, WithS3Compat(compat))
+
+	creds, err := r2Credentials()
+	if err != nil {
+		return nil, err
+	}
+	cfg := aws.Config{Region: "auto", Credentials: creds}
+	return NewS3Destination(s3.NewFromConfig(cfg), bucket, prefix, types.StorageClassStandard, opts...), nil
+}
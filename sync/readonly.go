@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrReadOnly is returned by a read-only Destination for every write.
+var ErrReadOnly = errors.New("destination is read-only")
+
+// ReadOnly wraps dst so that Put, Delete and Copy always fail with
+// ErrReadOnly, while reads pass through. Other optional write capabilities
+// of dst are hidden.
+func ReadOnly(dst Destination) Destination {
+	if _, ok := dst.(readOnlyDest); ok {
+		return dst
+	}
+	return readOnlyDest{dst}
+}
+
+type readOnlyDest struct {
+	Destination
+}
+
+func (r readOnlyDest) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return get(ctx, r.Destination, key)
+}
+
+func (r readOnlyDest) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	return listWritten(ctx, r.Destination)
+}
+
+func (readOnlyDest) Copy(context.Context, string, string) error {
+	return ErrReadOnly
+}
+
+func (readOnlyDest) CopyWithMeta(context.Context, string, string, ObjectMeta) error {
+	return ErrReadOnly
+}
+
+func (readOnlyDest) Append(context.Context, string, int64, io.Reader, ObjectMeta) error {
+	return ErrReadOnly
+}
+
+func (readOnlyDest) Rename(context.Context, string, string) error {
+	return ErrReadOnly
+}
+
+func (readOnlyDest) SetStorageClass(context.Context, string, string) error {
+	return ErrReadOnly
+}
+
+func (readOnlyDest) Put(context.Context, string, io.Reader, ObjectMeta) error {
+	return ErrReadOnly
+}
+
+func (readOnlyDest) Delete(context.Context, string) error {
+	return ErrReadOnly
+}
+
+func (readOnlyDest) DeleteBatch(context.Context, []string) ([]string, error) {
+	return nil, ErrReadOnly
+}
@@ -0,0 +1,47 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadOnly_blocksWrites(t *testing.T) {
+	inner := newMockDest()
+	inner.objects["a.txt"] = &ObjectMeta{Size: 1}
+	dst := ReadOnly(inner)
+	ctx := context.Background()
+
+	if err := dst.Put(ctx, "b.txt", strings.NewReader("x"), ObjectMeta{Size: 1, ModTime: time.Now()}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Put: got %v, want ErrReadOnly", err)
+	}
+	if err := dst.Delete(ctx, "a.txt"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Delete: got %v, want ErrReadOnly", err)
+	}
+	if err := copyObject(ctx, dst, "a.txt", "c.txt"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Copy: got %v, want ErrReadOnly", err)
+	}
+	if len(inner.putCalls) != 0 || len(inner.deleteCalls) != 0 {
+		t.Errorf("writes reached the wrapped destination: put=%v delete=%v", inner.putCalls, inner.deleteCalls)
+	}
+
+	if meta, err := dst.Stat(ctx, "a.txt"); err != nil || meta == nil {
+		t.Errorf("Stat: got (%v, %v), want existing object", meta, err)
+	}
+}
+
+func TestSync_readOnlyOption(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+
+	dst := newMockDest()
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, ReadOnly: true})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("read-only: expected no uploads, got %v", dst.putCalls)
+	}
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Opener creates a Destination from a parsed URL. Backend-specific settings
+// such as the region or storage class are passed as query parameters.
+type Opener func(ctx context.Context, u *url.URL) (Destination, error)
+
+var (
+	openers        = make(map[string]Opener)
+	storageClasses = make(map[string][]string)
+)
+
+// Register makes a backend available under a URL scheme. It panics if the
+// scheme is registered twice or open is nil.
+func Register(scheme string, open Opener) {
+	if open == nil {
+		panic("sync: Register opener is nil")
+	}
+	if _, dup := openers[scheme]; dup {
+		panic("sync: Register called twice for scheme " + scheme)
+	}
+	openers[scheme] = open
+}
+
+// RegisterStorageClasses records the storage classes the backend for scheme
+// accepts in its storage-class parameter, so that URLs can be checked
+// without opening them.
+func RegisterStorageClasses(scheme string, classes ...string) {
+	storageClasses[scheme] = append(storageClasses[scheme], classes...)
+}
+
+// Schemes returns the registered URL schemes in sorted order.
+func Schemes() []string {
+	schemes := make([]string, 0, len(openers))
+	for s := range openers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open creates the Destination named by rawURL, for example
+// s3://bucket/prefix, gs://bucket/prefix or file:///mnt/backup.
+func Open(ctx context.Context, rawURL string) (Destination, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	open, ok := openers[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("%q: unsupported scheme %q (supported: %v)", rawURL, u.Scheme, Schemes())
+	}
+	dst, err := open(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", rawURL, err)
+	}
+	return dst, nil
+}
+
+// CheckURL validates rawURL without opening the destination: the scheme
+// must be registered, and any storage-class parameter must be one the
+// backend accepts.
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if _, ok := openers[u.Scheme]; !ok {
+		return fmt.Errorf("unsupported scheme %q (supported: %v)", u.Scheme, Schemes())
+	}
+	if sc := u.Query().Get("storage-class"); sc != "" {
+		return CheckStorageClass(u.Scheme, sc)
+	}
+	return nil
+}
+
+// CheckStorageClass reports whether class is a storage class the backend
+// for scheme accepts.
+func CheckStorageClass(scheme, class string) error {
+	classes := storageClasses[scheme]
+	if len(classes) == 0 {
+		return fmt.Errorf("%s:// destinations do not support storage classes", scheme)
+	}
+	if !slices.Contains(classes, class) {
+		return fmt.Errorf("unknown %s storage class %q (valid: %s)", scheme, class, strings.Join(classes, ", "))
+	}
+	return nil
+}
+
+// bucketAndPrefix splits a bucket URL such as s3://bucket/a/b into its
+// bucket and key prefix.
+func bucketAndPrefix(u *url.URL) (bucket, prefix string, err error) {
+	if u.Host == "" {
+		return "", "", fmt.Errorf("missing bucket")
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
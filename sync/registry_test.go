@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestOpen_fileURL(t *testing.T) {
+	dir := t.TempDir()
+	dst, err := Open(context.Background(), "file://"+dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, ok := dst.(*LocalDestination)
+	if !ok {
+		t.Fatalf("expected *LocalDestination, got %T", dst)
+	}
+	if local.root != dir {
+		t.Errorf("root = %q, want %q", local.root, dir)
+	}
+}
+
+func TestOpen_unsupportedScheme(t *testing.T) {
+	if _, err := Open(context.Background(), "ftp://host/path"); err == nil {
+		t.Error("expected error for unregistered scheme, got nil")
+	}
+}
+
+func TestRegister_customScheme(t *testing.T) {
+	want := newMockDest()
+	var gotURL *url.URL
+	Register("mock", func(_ context.Context, u *url.URL) (Destination, error) {
+		gotURL = u
+		return want, nil
+	})
+	t.Cleanup(func() { delete(openers, "mock") })
+
+	dst, err := Open(context.Background(), "mock://bucket/a/b?storage-class=COLD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst != want {
+		t.Errorf("Open returned %v, want the registered destination", dst)
+	}
+
+	bucket, prefix, err := bucketAndPrefix(gotURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucket != "bucket" || prefix != "a/b" || gotURL.Query().Get("storage-class") != "COLD" {
+		t.Errorf("unexpected URL parts: bucket=%q prefix=%q query=%v", bucket, prefix, gotURL.Query())
+	}
+}
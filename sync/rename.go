@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// checkRenames reports whether opts.DetectRenames can be used with the
+// rest of opts.
+func checkRenames(opts Options) error {
+	if !opts.DetectRenames {
+		return nil
+	}
+	if opts.StateCache == "" {
+		return errors.New("detecting renames needs a state cache")
+	}
+	if _, ok := opts.Dst.(Copier); !ok {
+		return fmt.Errorf("detecting renames: destination cannot copy objects: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// renameSig is what a file must share with a file the state cache
+// recorded before its content hash is compared: the object copied keeps
+// the metadata of the original.
+type renameSig struct {
+	size, modTime int64
+	posix, ext    string
+}
+
+// planRenames finds the uploads of plan whose content the state cache
+// recorded for a key that is no longer that of a source file, and has
+// them copied from the object at that key instead. See
+// Options.DetectRenames.
+func planRenames(opts Options, plan *Plan) error {
+	if !opts.DetectRenames || plan.state == nil || len(plan.Uploads) == 0 {
+		return nil
+	}
+	sig := func(key string, e stateEntry) renameSig {
+		s := renameSig{size: e.Size, modTime: e.ModTime, posix: e.POSIX}
+		if opts.ContentType != ContentTypeNone {
+			s.ext = path.Ext(key) // the copy keeps the original's type
+		}
+		return s
+	}
+	recorded := make(map[renameSig][]string)
+	for key, e := range plan.state.old {
+		if e.SHA256 != "" && e.Size > 0 {
+			s := sig(key, e)
+			recorded[s] = append(recorded[s], key)
+		}
+	}
+
+	for _, u := range plan.Uploads {
+		candidates := recorded[sig(u.Key, newStateEntry(u))]
+		candidates = slices.DeleteFunc(slices.Clone(candidates), func(key string) bool {
+			return key == u.Key || isSourceKey(opts, key)
+		})
+		if len(candidates) == 0 {
+			continue
+		}
+		sum, err := u.sha256()
+		if err != nil {
+			return err
+		}
+		hash := hex.EncodeToString(sum)
+		slices.Sort(candidates)
+		for _, key := range candidates {
+			if plan.state.old[key].SHA256 == hash {
+				if plan.renamed == nil {
+					plan.renamed = make(map[string]string)
+				}
+				plan.renamed[u.Key] = key
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// isSourceKey reports whether key is the key a source file of opts is
+// stored under. Keys whose file cannot be checked are taken to be.
+func isSourceKey(opts Options, key string) bool {
+	s, rest, ok := sourceFor(opts, key)
+	if !ok {
+		return false
+	}
+	rel, ok := keyMapper(opts.Keys).Path(rest)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(s.Dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	// A key for a name in another normalization form is not the file's.
+	return fileKey(opts, rel, info.ModTime()) == rest
+}
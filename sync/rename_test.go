@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSync_detectRenames(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "videos/big.mp4", "lots of video")
+	writeFile(t, src, "twin.mp4", "lots of video!")
+	mock := newMockDest()
+	opts := Options{Src: src, Dst: mock, Delete: true, DetectRenames: true, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rename keeps the modification time. A file of the same size and
+	// time but other content is uploaded.
+	if err := os.Rename(filepath.Join(src, "videos/big.mp4"), filepath.Join(src, "moved.mp4")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(src, "moved.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, src, "other.mp4", "more of video")
+	if err := os.Chtimes(filepath.Join(src, "other.mp4"), info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	mock.putCalls = nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(mock.copyCalls, []string{"videos/big.mp4"}) || string(mock.data["moved.mp4"]) != "lots of video" {
+		t.Errorf("copied %v, want moved.mp4 copied from videos/big.mp4", mock.copyCalls)
+	}
+	if !slices.Equal(mock.putCalls, []string{"other.mp4"}) {
+		t.Errorf("put %v, want other.mp4", mock.putCalls)
+	}
+	if !slices.Equal(mock.deleteCalls, []string{"videos/big.mp4"}) {
+		t.Errorf("deleted %v, want the old key", mock.deleteCalls)
+	}
+
+	// The copy is up to date.
+	mock.putCalls, mock.copyCalls = nil, nil
+	opts.StateCache = ""
+	opts.DetectRenames = false
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(mock.putCalls) != 0 {
+		t.Errorf("put %v after the rename, want nothing", mock.putCalls)
+	}
+}
+
+func TestSync_detectRenamesHashesCachedFiles(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.bin", "content")
+	mock := newMockDest()
+	opts := Options{Src: src, Dst: mock, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files recorded before renames were detected are hashed once.
+	opts.DetectRenames = true
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(src, "a.bin"), filepath.Join(src, "b.bin")); err != nil {
+		t.Fatal(err)
+	}
+	mock.putCalls = nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(mock.putCalls) != 0 || !slices.Equal(mock.copyCalls, []string{"a.bin"}) {
+		t.Errorf("put %v, copied %v; want b.bin copied", mock.putCalls, mock.copyCalls)
+	}
+}
+
+func TestSync_detectRenamesNeeds(t *testing.T) {
+	src := t.TempDir()
+	_, err := Sync(context.Background(), Options{Src: src, Dst: newMockDest(), DetectRenames: true})
+	if err == nil {
+		t.Error("no error without a state cache")
+	}
+	_, err = Sync(context.Background(), Options{Src: src, Dst: struct{ Destination }{newMockDest()}, DetectRenames: true, StateCache: filepath.Join(t.TempDir(), "state.json")})
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("err = %v, want ErrUnsupported for a destination that cannot copy", err)
+	}
+}
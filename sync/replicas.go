@@ -0,0 +1,356 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ReplicaOptions configures VerifyReplicas.
+type ReplicaOptions struct {
+	A, B Destination // the replicas, named "a" and "b" in reports
+
+	// Checksum also compares the content of objects whose recorded size
+	// and mtime match, downloading both copies.
+	Checksum bool
+
+	// Heal copies each object that is missing or stale at one replica from
+	// the other: server-side if the lagging replica implements CrossCopier
+	// for the other, or else streamed through this machine. Once every
+	// object is healed, the newer manifest is copied too. Objects absent
+	// from the manifest are reported, never deleted.
+	Heal   bool
+	DryRun bool // with Heal, print copies without making them
+
+	// VerifyKey, if set, is the public key the manifests must be signed
+	// with before they are trusted to tell which replica is right.
+	VerifyKey ed25519.PublicKey
+
+	// Log, if set, receives each copy Heal makes as it is made.
+	Log io.Writer
+}
+
+// ReplicaReport is the result of VerifyReplicas.
+type ReplicaReport struct {
+	Objects    int // keys compared
+	Mismatches []Mismatch
+	Healed     int // mismatches fixed by ReplicaOptions.Heal
+}
+
+// replicaFix is a mismatch Heal can fix by copying key from one replica to
+// the other.
+type replicaFix struct {
+	key      string
+	from, to string // "a" or "b"
+}
+
+// VerifyReplicas compares two destinations that hold copies of the same
+// backup, such as buckets in different regions synced from one source.
+// Objects are compared by the size and mtime recorded with them, and by
+// content with opts.Checksum. Where they differ, the manifest of the
+// replica synced last decides which copy is right: the one matching its
+// entry, or without a manifest, the one modified last. Files the manifest
+// lists that neither replica holds are reported too.
+//
+// Differences are returned in the report; the error is only set if the
+// comparison or healing could not be completed.
+func VerifyReplicas(ctx context.Context, opts ReplicaOptions) (*ReplicaReport, error) {
+	replicas := map[string]Destination{"a": opts.A, "b": opts.B}
+	ma, err := replicaManifest(ctx, opts.A, opts.VerifyKey)
+	if err != nil {
+		return nil, fmt.Errorf("a: %w", err)
+	}
+	mb, err := replicaManifest(ctx, opts.B, opts.VerifyKey)
+	if err != nil {
+		return nil, fmt.Errorf("b: %w", err)
+	}
+
+	report := &ReplicaReport{}
+	var fixes []replicaFix
+	manifest, newer := ma, "a"
+	if ma == nil || mb != nil && mb.Created.After(ma.Created) {
+		manifest, newer = mb, "b"
+	}
+	if reason := manifestMismatch(ma, mb); reason != "" {
+		report.Mismatches = append(report.Mismatches, Mismatch{Key: ManifestKey, Reason: reason})
+		fixes = append(fixes, replicaFix{key: ManifestKey, from: newer, to: other(newer)})
+	}
+	var expected map[string]ManifestEntry
+	if manifest != nil {
+		expected = make(map[string]ManifestEntry, len(manifest.Files))
+		for _, e := range manifest.Files {
+			expected[e.Key] = e
+		}
+		// Bundled files are listed but stored inside bundles.
+		idx, err := ReadBundleIndex(ctx, replicas[newer])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", newer, err)
+		}
+		for key := range idx.Files {
+			delete(expected, key)
+		}
+	}
+
+	held := make(map[string]map[string]bool) // by replica, then key
+	for _, name := range []string{"a", "b"} {
+		held[name] = make(map[string]bool)
+		err := replicas[name].List(ctx, func(keys []string) error {
+			for _, key := range keys {
+				if !strings.HasPrefix(key, metaPrefix) || strings.HasPrefix(key, BundlePrefix) {
+					held[name][key] = true
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: list: %w", name, err)
+		}
+	}
+	keys := slices.Sorted(maps.Keys(held["a"]))
+	for key := range held["b"] {
+		if !held["a"][key] {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		report.Objects++
+		metas := make(map[string]*ObjectMeta, 2)
+		for _, name := range []string{"a", "b"} {
+			if !held[name][key] {
+				continue
+			}
+			meta, err := replicas[name].Stat(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: stat %s: %w", name, key, err)
+			}
+			metas[name] = meta
+		}
+		entry, listed := expected[key]
+		reason, from, err := compareReplicas(ctx, opts, key, metas["a"], metas["b"], manifest != nil, entry, listed)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			continue
+		}
+		report.Mismatches = append(report.Mismatches, Mismatch{Key: key, Reason: reason})
+		if from != "" {
+			fixes = append(fixes, replicaFix{key: key, from: from, to: other(from)})
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(expected)) {
+		if !held["a"][key] && !held["b"][key] {
+			report.Mismatches = append(report.Mismatches, Mismatch{Key: key, Reason: "in the manifest but at neither replica"})
+		}
+	}
+
+	if !opts.Heal {
+		return report, nil
+	}
+	// The manifest goes last, once the objects it describes are in place.
+	if len(fixes) > 0 && fixes[0].key == ManifestKey {
+		fixes = append(fixes[1:], fixes[0])
+	}
+	for i, fix := range fixes {
+		if fix.key == ManifestKey && i < len(report.Mismatches)-1 {
+			break // some objects cannot be healed
+		}
+		fmt.Fprintf(logTo(opts.Log), "copy %s: %s -> %s\n", fix.key, fix.from, fix.to)
+		if opts.DryRun {
+			continue
+		}
+		from, to := replicas[fix.from], replicas[fix.to]
+		if fix.key == ManifestKey {
+			err = copyManifest(ctx, from, to)
+		} else {
+			err = copyBetween(ctx, from, to, fix.key)
+		}
+		if err != nil {
+			return report, fmt.Errorf("copy %s to %s: %w", fix.key, fix.to, err)
+		}
+		report.Healed++
+	}
+	return report, nil
+}
+
+// compareReplicas compares the copies a and b of key, either of which may
+// be nil. It returns why they differ, or "" if they do not, and the replica
+// holding the right copy, or "" if it cannot be told. hasManifest reports
+// whether there is a manifest; entry, if listed, is its entry for key.
+func compareReplicas(ctx context.Context, opts ReplicaOptions, key string, a, b *ObjectMeta, hasManifest bool, entry ManifestEntry, listed bool) (string, string, error) {
+	if a == nil || b == nil {
+		have, meta := "a", a
+		if a == nil {
+			have, meta = "b", b
+		}
+		switch {
+		case meta == nil:
+			return "", "", nil // deleted while listing
+		case hasManifest && !listed && !strings.HasPrefix(key, metaPrefix):
+			return fmt.Sprintf("only at %s, and not in the manifest", have), "", nil
+		case listed && !matchesEntry(meta, entry):
+			return fmt.Sprintf("missing at %s, and %s's copy does not match the manifest", other(have), have), "", nil
+		}
+		return "missing at " + other(have), have, nil
+	}
+
+	if a.Size == b.Size && a.ModTime.Unix() == b.ModTime.Unix() && a.POSIX.Equal(b.POSIX) {
+		if !opts.Checksum {
+			return "", "", nil
+		}
+		same, err := sameObjects(ctx, opts.A, opts.B, key, a, b)
+		if err != nil || same {
+			return "", "", err
+		}
+		// Nothing records which content is right.
+		return "content differs", "", nil
+	}
+
+	var reason string
+	switch {
+	case a.Size != b.Size:
+		reason = fmt.Sprintf("size %d at a, %d at b", a.Size, b.Size)
+	case a.ModTime.Unix() != b.ModTime.Unix():
+		reason = fmt.Sprintf("modified %s at a, %s at b", a.ModTime.UTC().Format(time.RFC3339), b.ModTime.UTC().Format(time.RFC3339))
+	default:
+		reason = "permissions or ownership differ"
+	}
+	switch {
+	case listed && matchesEntry(a, entry):
+		return reason, "a", nil
+	case listed && matchesEntry(b, entry):
+		return reason, "b", nil
+	case listed:
+		return reason + ", and neither matches the manifest", "", nil
+	case a.ModTime.After(b.ModTime):
+		return reason, "a", nil
+	case b.ModTime.After(a.ModTime):
+		return reason, "b", nil
+	}
+	return reason, "", nil
+}
+
+func matchesEntry(meta *ObjectMeta, e ManifestEntry) bool {
+	return meta.Size == e.Size && meta.ModTime.Unix() == e.ModTime.Unix()
+}
+
+func other(replica string) string {
+	if replica == "a" {
+		return "b"
+	}
+	return "a"
+}
+
+// replicaManifest reads the manifest of d, or returns nil if it has none.
+func replicaManifest(ctx context.Context, d Destination, pub ed25519.PublicKey) (*Manifest, error) {
+	m, err := ReadManifest(ctx, d, pub)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return m, err
+}
+
+// manifestMismatch describes how the manifests a and b, either of which
+// may be nil, differ, or returns "" if they are the same.
+func manifestMismatch(a, b *Manifest) string {
+	switch {
+	case a == nil && b == nil:
+		return ""
+	case a == nil:
+		return "missing at a"
+	case b == nil:
+		return "missing at b"
+	case !a.Created.Equal(b.Created):
+		return fmt.Sprintf("written %s at a, %s at b", a.Created.Format(time.RFC3339), b.Created.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// sameObjects reports whether key, described by ma and mb, has the same
+// content in a and b.
+func sameObjects(ctx context.Context, a, b Destination, key string, ma, mb *ObjectMeta) (bool, error) {
+	ha, err := objectSHA256(ctx, a, key, ma)
+	if err != nil {
+		return false, fmt.Errorf("a: %w", err)
+	}
+	hb, err := objectSHA256(ctx, b, key, mb)
+	if err != nil {
+		return false, fmt.Errorf("b: %w", err)
+	}
+	return bytes.Equal(ha, hb), nil
+}
+
+func objectSHA256(ctx context.Context, d Destination, key string, meta *ObjectMeta) ([]byte, error) {
+	rc, err := getContent(ctx, d, key, meta)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return nil, &FileError{Op: "read", Key: key, Err: err}
+	}
+	return h.Sum(nil), nil
+}
+
+// copyBetween copies key from one destination to another, server-side if
+// to can copy from from.
+func copyBetween(ctx context.Context, from, to Destination, key string) error {
+	return copyTo(ctx, from, to, key, key)
+}
+
+// copyTo copies the object at src in from to dst in to, server-side if to
+// can copy from from.
+func copyTo(ctx context.Context, from, to Destination, src, dst string) error {
+	meta, err := from.Stat(ctx, src)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	}
+	if meta.Chunked {
+		// Its chunks are only recorded in the chunk index of from.
+		return fmt.Errorf("%s is chunked: %w", src, errors.ErrUnsupported)
+	}
+	if c, ok := to.(CrossCopier); ok {
+		err := c.CopyFrom(ctx, from, src, dst)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return err
+		}
+	}
+	rc, err := get(ctx, from, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return to.Put(ctx, dst, rc, *meta)
+}
+
+// copyManifest copies the manifest and its signature, if it has one, from
+// one destination to another.
+func copyManifest(ctx context.Context, from, to Destination) error {
+	if err := copyBetween(ctx, from, to, ManifestKey); err != nil {
+		return err
+	}
+	sig, err := from.Stat(ctx, ManifestSigKey)
+	if err != nil {
+		return err
+	}
+	if sig == nil {
+		// A stale signature must not vouch for the new manifest.
+		return to.Delete(ctx, ManifestSigKey)
+	}
+	return copyBetween(ctx, from, to, ManifestSigKey)
+}
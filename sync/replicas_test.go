@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// cloneDest returns a copy of d, as a replica synced at the same time.
+func cloneDest(d *mockDest) *mockDest {
+	c := newMockDest()
+	for k, meta := range d.objects {
+		copied := *meta
+		c.objects[k] = &copied
+	}
+	maps.Copy(c.data, d.data)
+	return c
+}
+
+func TestVerifyReplicas_heal(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	writeFile(t, src, "c.txt", "c")
+	a := newMockDest()
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: a, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	b := cloneDest(a)
+
+	// b misses a run: a.txt changes and d.txt is added.
+	writeFile(t, src, "a.txt", "aa")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, src, "d.txt", "d")
+	time.Sleep(time.Millisecond) // a later manifest
+	if _, err := Sync(ctx, Options{Src: src, Dst: a, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	delete(b.objects, "b.txt") // and loses b.txt
+
+	report, err := VerifyReplicas(ctx, ReplicaOptions{A: a, B: b, Heal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range report.Mismatches {
+		got = append(got, m.Key)
+	}
+	want := []string{ManifestKey, "a.txt", "b.txt", "d.txt"}
+	if !slices.Equal(got, want) || report.Healed != 4 {
+		t.Fatalf("mismatches %v, healed %d; want %v, all healed", report.Mismatches, report.Healed, want)
+	}
+	if string(b.data["a.txt"]) != "aa" || !b.objects["a.txt"].ModTime.Equal(a.objects["a.txt"].ModTime) {
+		t.Errorf("a.txt not healed: %q", b.data["a.txt"])
+	}
+
+	report, err = VerifyReplicas(ctx, ReplicaOptions{A: a, B: b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 0 || report.Objects != 4 {
+		t.Errorf("after healing: %d objects, mismatches %v", report.Objects, report.Mismatches)
+	}
+}
+
+func TestVerifyReplicas_unhealable(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	a := newMockDest()
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: a, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	b := cloneDest(a)
+	b.objects["stray.txt"] = &ObjectMeta{Size: 1}
+	b.data["stray.txt"] = []byte("s")
+	b.data["a.txt"] = []byte("x") // same size and mtime, other content
+
+	report, err := VerifyReplicas(ctx, ReplicaOptions{A: a, B: b, Checksum: true, Heal: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mismatch{
+		{Key: "a.txt", Reason: "content differs"},
+		{Key: "stray.txt", Reason: "only at b, and not in the manifest"},
+	}
+	if !slices.Equal(report.Mismatches, want) || report.Healed != 0 {
+		t.Errorf("mismatches %v, healed %d; want %v, none healed", report.Mismatches, report.Healed, want)
+	}
+	if _, ok := b.objects["stray.txt"]; !ok {
+		t.Error("stray.txt was deleted")
+	}
+}
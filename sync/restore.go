@@ -0,0 +1,603 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RestoreOptions configures a restore.
+type RestoreOptions struct {
+	From   Destination // where the backup is stored
+	To     string      // local directory to restore into
+	DryRun bool        // if true, print actions without making changes
+
+	// Log, if set, receives the account of the restore as the command
+	// line prints it: each file restored, extracted, linked or skipped,
+	// and the progress of restores from the archive.
+	Log io.Writer
+	// Warnings, if set, receives the warnings of the restore, one to a
+	// line, such as of keys skipped or attributes that could not be set.
+	Warnings io.Writer
+
+	// The following apply to archived objects, which must be restored
+	// from an archive storage class before they can be downloaded. See
+	// Archiver.
+
+	// Tier is how quickly archived objects are retrieved, at what cost.
+	// The default is TierStandard.
+	Tier RestoreTier
+	// Days is how long restored copies are kept before they return to the
+	// archive. The default is 7.
+	Days int
+	// BatchSize, if positive, is how many archived objects are restored
+	// at a time: the next batch is requested as objects of the current
+	// one are downloaded, which keeps down the cost of restored copies
+	// waiting to be read.
+	BatchSize int
+	// PollInterval is how often the progress of restores is checked. The
+	// default is 15 minutes.
+	PollInterval time.Duration
+	// NoWait, if true, requests the restores and returns without waiting
+	// for them; objects that are already readable are still downloaded.
+	// Run again once the restores have completed to download the rest.
+	NoWait bool
+
+	// Keys maps keys back to paths. It must be the mapper the backup was
+	// made with; see Options.Keys. Nil means IdentityKeys{}.
+	Keys KeyMapper
+
+	// Snapshot, if set, restores the files as they were after the run
+	// named, as listed by ListSnapshots, instead of as they are now. See
+	// Options.Snapshots. AsOf, if set instead, names the last snapshot
+	// made at or before it.
+	Snapshot string
+	AsOf     time.Time
+
+	// Paths, if set, restores only the files whose paths match one of
+	// these patterns, or are in a directory that does. Patterns are
+	// slash-separated and matched from the top of the backup, as in an
+	// IgnoreFile: "*" matches within a name and "**" any number of
+	// directories, as in "photos/2023/**".
+	Paths []string
+	// Overwrite decides what becomes of files already in To. The default
+	// is OverwriteAlways.
+	Overwrite OverwritePolicy
+
+	// EncryptionContext, if set, is the SSE-KMS encryption context every
+	// object must have been encrypted with; see ObjectMeta.EncryptionContext.
+	// An object recorded with another context, or none, fails the restore
+	// before it is downloaded. Files packed into bundles are not checked.
+	EncryptionContext map[string]string
+
+	// VerifyKey, if set, makes Restore check the signature of the manifest
+	// at the root of From first and refuse to restore if it does not
+	// verify.
+	VerifyKey ed25519.PublicKey
+
+	// POSIX decides what becomes of the POSIX attributes recorded with
+	// files that Restore is not permitted to set, such as their owner
+	// when it does not run as root. The default is POSIXBestEffort, which
+	// sets what it may and warns how many files were left without the
+	// rest once done.
+	POSIX POSIXPolicy
+	// OwnershipScript, if set, is where a shell script is then written
+	// that sets the rest when run as root.
+	OwnershipScript string
+
+	// Untransform, if set, undoes the transform objects were uploaded
+	// with, as recorded in ObjectMeta.Transform. Without it, such objects
+	// are restored as they are stored, and their checksums are not
+	// checked. See Options.Transform.
+	Untransform UntransformFunc
+
+	// Concurrency, if above 1, is how many objects are downloaded at
+	// once.
+	Concurrency int
+	// PartSize, if positive, splits the download of each object larger
+	// than it into ranged reads of this many bytes, PartConcurrency of
+	// them at once (default DefaultPartConcurrency), from destinations
+	// that are RangeGetters. Parts are written into a partial file next
+	// to the file restored, and a restore interrupted part way resumes
+	// from the parts it has. Objects stored compressed, sparse, chunked
+	// or transformed are downloaded whole.
+	PartSize        int64
+	PartConcurrency int
+	// BytesPerSecond, if positive, limits the rate at which all the
+	// downloads together read from From.
+	BytesPerSecond int64
+
+	bundles  *BundleIndex      // set by Restore
+	names    map[string]string // paths of the keys of a Snapshot, set by Restore
+	transfer transfer          // set by Restore
+}
+
+// OverwritePolicy decides whether Restore replaces a file that already
+// exists.
+type OverwritePolicy string
+
+const (
+	OverwriteAlways  OverwritePolicy = "always"
+	OverwriteNever   OverwritePolicy = "never"
+	OverwriteIfNewer OverwritePolicy = "if-newer" // if the backup's copy was modified later
+)
+
+// ParseOverwritePolicy parses the names used on the command line: always,
+// never and if-newer.
+func ParseOverwritePolicy(s string) (OverwritePolicy, error) {
+	switch p := OverwritePolicy(s); p {
+	case OverwriteAlways, OverwriteNever, OverwriteIfNewer:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown overwrite policy %q (valid: always, never, if-newer)", s)
+}
+
+// selected reports whether the file at name is to be restored, as
+// opts.Paths says.
+func (opts RestoreOptions) selected(name string) bool {
+	if len(opts.Paths) == 0 {
+		return true
+	}
+	for _, p := range opts.Paths {
+		pattern := strings.Split(strings.Trim(p, "/"), "/")
+		for n := name; n != "."; n = path.Dir(n) {
+			if matchSegments(pattern, strings.Split(n, "/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keepLocal reports whether the file at name in to is to be kept, as
+// opts.Overwrite says, rather than replaced with key, which remote
+// describes if it is known, and prints why.
+func (opts RestoreOptions) keepLocal(ctx context.Context, to *LocalDestination, key, name string, remote *ObjectMeta) (bool, error) {
+	if opts.Overwrite == "" || opts.Overwrite == OverwriteAlways {
+		return false, nil
+	}
+	local, err := to.Stat(ctx, name)
+	if err != nil || local == nil {
+		return false, err
+	}
+	if opts.Overwrite == OverwriteIfNewer {
+		if remote == nil {
+			if remote, err = opts.From.Stat(ctx, key); err != nil || remote == nil {
+				return false, err
+			}
+		}
+		if remote.ModTime.Unix() > local.ModTime.Unix() {
+			return false, nil
+		}
+		opts.logf("skip %s (not newer than the existing file)", name)
+		return true, nil
+	}
+	opts.logf("skip %s (exists)", name)
+	return true, nil
+}
+
+// logf prints a line of the account of the restore to opts.Log.
+func (opts RestoreOptions) logf(format string, args ...any) {
+	fmt.Fprintf(logTo(opts.Log), format+"\n", args...)
+}
+
+// warnf prints a warning of the restore to opts.Warnings.
+func (opts RestoreOptions) warnf(format string, args ...any) {
+	warnf(opts.Warnings, format, args...)
+}
+
+// RestoreTier is the retrieval tier of a restore from an archive storage
+// class. For S3 GLACIER, Expedited takes minutes, Standard 3-5 hours and
+// Bulk 5-12 hours; DEEP_ARCHIVE takes up to 12 hours at Standard and 48
+// at Bulk, and cannot be restored at Expedited.
+type RestoreTier string
+
+const (
+	TierBulk      RestoreTier = "Bulk"
+	TierStandard  RestoreTier = "Standard"
+	TierExpedited RestoreTier = "Expedited"
+)
+
+// ParseRestoreTier parses a tier name, ignoring case.
+func ParseRestoreTier(s string) (RestoreTier, error) {
+	for _, t := range []RestoreTier{TierBulk, TierStandard, TierExpedited} {
+		if strings.EqualFold(s, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown restore tier %q (want Bulk, Standard or Expedited)", s)
+}
+
+// ArchiveState is the state of an object with respect to archiving.
+type ArchiveState int
+
+const (
+	NotArchived ArchiveState = iota // readable
+	Archived                        // must be restored to be read
+	Restoring                       // a restore is in progress
+	Restored                        // a restored copy is readable
+)
+
+func (s ArchiveState) String() string {
+	switch s {
+	case Archived:
+		return "archived"
+	case Restoring:
+		return "restoring"
+	case Restored:
+		return "restored"
+	default:
+		return "readable"
+	}
+}
+
+// ArchiveStatus is what an Archiver reports about an object.
+type ArchiveStatus struct {
+	State        ArchiveState
+	StorageClass string
+	Expiry       time.Time // when a restored copy is removed, if known
+}
+
+// readable reports whether the object can be downloaded.
+func (s ArchiveStatus) readable() bool {
+	return s.State == NotArchived || s.State == Restored
+}
+
+// ArchivedObject is an object listed by ArchiveReport.
+type ArchivedObject struct {
+	Key string
+	ArchiveStatus
+}
+
+// ArchiveReport returns the archive status of every object in from, in
+// key order. If from has no archive storage classes, every object is
+// NotArchived.
+func ArchiveReport(ctx context.Context, from Destination) ([]ArchivedObject, error) {
+	keys, err := restoreKeys(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]ArchivedObject, 0, len(keys))
+	for _, key := range keys {
+		st, err := archiveStatus(ctx, from, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		objects = append(objects, ArchivedObject{Key: key, ArchiveStatus: st})
+	}
+	return objects, nil
+}
+
+// archiveStatus returns the status of key, which is NotArchived if dst is
+// not an Archiver.
+func archiveStatus(ctx context.Context, dst Destination, key string) (ArchiveStatus, error) {
+	a, ok := dst.(Archiver)
+	if !ok {
+		return ArchiveStatus{}, nil
+	}
+	return a.ArchiveStatus(ctx, key)
+}
+
+// restoreKeys lists the objects in from to restore, in key order.
+func restoreKeys(ctx context.Context, from Destination) ([]string, error) {
+	var restore []string
+	err := from.List(ctx, func(keys []string) error {
+		for _, key := range keys {
+			if !strings.HasPrefix(key, metaPrefix) {
+				restore = append(restore, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(restore)
+	return restore, nil
+}
+
+// Restore downloads every object in opts.From, or those opts.Paths
+// selects, into opts.To, reapplying the modification time and any POSIX
+// attributes recorded with it (see Options.PreservePOSIX). Existing files
+// are overwritten as opts.Overwrite says.
+//
+// If opts.From is an Archiver, readable objects are downloaded first.
+// Archived objects are then restored, opts.BatchSize at a time, and each
+// is downloaded once its restore completes.
+//
+// Files packed into bundles (see Options.Bundle) are extracted from their
+// archives, each of which is downloaded, and if need be restored, once.
+//
+// With opts.Snapshot set, each file of the snapshot is downloaded from its
+// object if that has not changed since, and otherwise from the copy kept
+// under VersionPrefix.
+//
+// Files stored as hard links (see Options.HardLinks) are made hard links
+// again once the rest is restored, and the empty directories recorded with
+// Options.KeepEmptyDirs are created.
+//
+// Recorded attributes Restore is not permitted to set are handled as
+// opts.POSIX says.
+//
+// Files uploaded with Options.Checksums are checked against the SHA-256
+// recorded with them once written, and left unrestored, failing with
+// ErrChecksumMismatch, if they do not match.
+func Restore(ctx context.Context, opts RestoreOptions) (err error) {
+	defer func() { err = classify(err) }()
+	if opts.Tier == "" {
+		opts.Tier = TierStandard
+	}
+	if opts.Days <= 0 {
+		opts.Days = 7
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 15 * time.Minute
+	}
+	if opts.VerifyKey != nil {
+		if err := verifyManifest(ctx, opts.From, opts.VerifyKey); err != nil {
+			return err
+		}
+	}
+
+	opts.Keys = keyMapper(opts.Keys)
+	if opts.Log != nil {
+		opts.Log = &lockedWriter{w: opts.Log} // written to by each worker
+	}
+	opts.transfer = newTransfer(opts)
+	if opts.Snapshot == "" && !opts.AsOf.IsZero() {
+		name, err := snapshotAsOf(ctx, opts.From, opts.AsOf)
+		if err != nil {
+			return err
+		}
+		opts.logf("restoring snapshot %s", name)
+		opts.Snapshot = name
+	}
+	keys, err := restoreList(ctx, &opts)
+	if err != nil {
+		return err
+	}
+
+	to := NewLocalDestination(opts.To)
+	to.posix, to.denied = opts.POSIX, &posixReport{}
+	w := newWorkers(ctx, opts.Concurrency)
+	var archived []string // in key order
+	for _, key := range keys {
+		st, err := archiveStatus(ctx, opts.From, key)
+		if err != nil {
+			w.wait()
+			return &FileError{Op: "restore", Key: key, Err: err}
+		}
+		if !st.readable() {
+			archived = append(archived, key)
+			continue
+		}
+		if w.run(func(ctx context.Context) error { return download(ctx, opts, to, key) }); w.failed() {
+			break
+		}
+	}
+	if len(archived) > 0 && !w.failed() {
+		if err := restoreArchived(ctx, opts, to, w, archived); err != nil {
+			w.wait()
+			return err
+		}
+	}
+	if err := w.wait(); err != nil {
+		return err
+	}
+	if err := restoreLinks(ctx, opts, to); err != nil {
+		return err
+	}
+	if err := restoreEmptyDirs(ctx, opts, to); err != nil {
+		return err
+	}
+	return reportPOSIX(opts, to.denied)
+}
+
+// restoreList returns the keys Restore downloads, setting the fields of
+// opts that describe them.
+func restoreList(ctx context.Context, opts *RestoreOptions) ([]string, error) {
+	if opts.Snapshot != "" {
+		keys, names, err := snapshotKeys(ctx, *opts)
+		opts.names = names
+		return keys, err
+	}
+	keys, err := restoreKeys(ctx, opts.From)
+	if err != nil {
+		return nil, err
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool {
+		name, ok := opts.Keys.Path(key)
+		if !ok {
+			opts.warnf("skipping %s: not a key of the layout", key)
+			return true
+		}
+		return !opts.selected(name)
+	})
+	// Bundles are restored like any other object, then extracted.
+	if opts.bundles, err = ReadBundleIndex(ctx, opts.From); err != nil {
+		return nil, err
+	}
+	bundles := make(map[string]bool)
+	for key, e := range opts.bundles.Files {
+		if name, ok := opts.Keys.Path(key); ok && opts.selected(name) {
+			bundles[e.Bundle] = true
+		}
+	}
+	return append(keys, slices.Sorted(maps.Keys(bundles))...), nil
+}
+
+// restoreArchived requests restores of the archived keys, waits for them
+// and downloads each with w as it becomes readable.
+func restoreArchived(ctx context.Context, opts RestoreOptions, to *LocalDestination, w *workers, queue []string) error {
+	a := opts.From.(Archiver)
+	var inFlight []string
+	for {
+		// Keep up to BatchSize restores going.
+		for len(queue) > 0 && (opts.BatchSize <= 0 || len(inFlight) < opts.BatchSize) {
+			key := queue[0]
+			queue = queue[1:]
+			opts.logf("request restore %s (%s)", key, opts.Tier)
+			if !opts.DryRun {
+				if err := a.RequestRestore(ctx, key, opts.Tier, opts.Days); err != nil {
+					return fmt.Errorf("request restore of %s: %w", key, err)
+				}
+			}
+			inFlight = append(inFlight, key)
+		}
+		if opts.DryRun || opts.NoWait {
+			opts.logf("%d objects being restored, %d waiting; run again once they are restored", len(inFlight), len(queue))
+			return nil
+		}
+
+		var waiting []string
+		for _, key := range inFlight {
+			st, err := a.ArchiveStatus(ctx, key)
+			if err != nil {
+				return &FileError{Op: "restore", Key: key, Err: err}
+			}
+			switch st.State {
+			case Restoring:
+				waiting = append(waiting, key)
+			case Archived:
+				// The restored copy expired before it was read.
+				queue = append(queue, key)
+			default:
+				if w.run(func(ctx context.Context) error { return download(ctx, opts, to, key) }); w.failed() {
+					return nil // w.wait returns the error
+				}
+			}
+		}
+		inFlight = waiting
+		if len(inFlight) == 0 && len(queue) == 0 {
+			return nil
+		}
+		if len(inFlight) == 0 {
+			continue // request the next batch now
+		}
+
+		opts.logf("%d objects restoring, %d waiting; checking again in %s", len(inFlight), len(queue), opts.PollInterval)
+		t := time.NewTimer(opts.PollInterval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// download restores key from opts.From into to.
+func download(ctx context.Context, opts RestoreOptions, to *LocalDestination, key string) error {
+	if strings.HasPrefix(key, BundlePrefix) {
+		opts.logf("extract %s", key)
+		if opts.DryRun {
+			return nil
+		}
+		want := func(key, name string, meta *ObjectMeta) (bool, error) {
+			if !opts.selected(name) {
+				return false, nil
+			}
+			keep, err := opts.keepLocal(ctx, to, key, localName(name, opts.Warnings), meta)
+			return !keep, err
+		}
+		if err := extractBundle(ctx, opts.From, to, key, opts.bundles, opts.Keys, opts.Log, opts.Warnings, want); err != nil {
+			return &FileError{Op: "extract", Key: key, Err: err}
+		}
+		return nil
+	}
+	name, ok := opts.names[key]
+	if !ok {
+		name, _ = opts.Keys.Path(key)
+	}
+	if keep, err := opts.keepLocal(ctx, to, key, localName(name, opts.Warnings), nil); err != nil {
+		return &FileError{Op: "restore", Key: key, Err: err}
+	} else if keep {
+		return nil
+	}
+	opts.logf("restore %s", key)
+	if opts.DryRun {
+		return nil
+	}
+	if err := restoreFile(ctx, opts.From, to, key, localName(name, opts.Warnings), opts.EncryptionContext, opts.Untransform, opts.transfer); err != nil {
+		return &FileError{Op: "restore", Key: key, Err: err}
+	}
+	return nil
+}
+
+// restoreFile downloads key from from into to as the file name, as t
+// says. If ec is set, the object must have been encrypted with it.
+// Objects uploaded with a transform are undone with untransform if it is
+// set, and are restored as they are stored otherwise.
+func restoreFile(ctx context.Context, from Destination, to *LocalDestination, key, name string, ec map[string]string, untransform UntransformFunc, t transfer) error {
+	meta, err := from.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fs.ErrNotExist // deleted since it was listed
+	}
+	if ec != nil && !maps.Equal(meta.EncryptionContext, ec) {
+		return fmt.Errorf("encrypted with context %s, want %s", formatContext(meta.EncryptionContext), formatContext(ec))
+	}
+	if rg, ok := t.ranged(from, meta); ok {
+		return downloadRanges(ctx, rg, to, key, name, *meta, t)
+	}
+	var rc io.ReadCloser
+	if meta.Chunked {
+		// to writes the file the chunks add up to as it is.
+		rc, err = getContent(ctx, from, key, meta)
+		meta.Chunked, meta.Compression = false, ""
+	} else {
+		rc, err = get(ctx, from, key)
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	r := t.bw.reader(ctx, rc)
+	put := *meta
+	if put.Transform != "" {
+		if untransform == nil {
+			put.SHA256 = "" // that of the file before the transform
+		} else if r, err = untransform(put.Transform, key, r); err != nil {
+			return fmt.Errorf("undo transform %s: %w", put.Transform, err)
+		}
+		put.Transform = ""
+	}
+	return to.Put(ctx, name, r, put)
+}
+
+// localName returns the path to restore the file at name as. Names Windows
+// does not allow are percent-encoded there, with a warning to warnings; see
+// windowsName.
+func localName(name string, warnings io.Writer) string {
+	if runtime.GOOS != "windows" {
+		return name
+	}
+	if w := windowsName(name); w != name {
+		warnf(warnings, "restoring %s as %s, which Windows allows", name, w)
+		return w
+	}
+	return name
+}
+
+// formatContext formats an encryption context as sorted key=value pairs.
+func formatContext(ec map[string]string) string {
+	if len(ec) == 0 {
+		return "(none recorded)"
+	}
+	pairs := make([]string, 0, len(ec))
+	for _, k := range slices.Sorted(maps.Keys(ec)) {
+		pairs = append(pairs, k+"="+ec[k])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,291 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestore_reappliesMetadata(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "bin/run.sh", "#!/bin/sh")
+	writeFile(t, src, "notes.txt", "notes")
+	script := filepath.Join(src, "bin/run.sh")
+	if err := os.Chmod(script, 0751); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(script, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, PreservePOSIX: true, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(out, "bin/run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0751 {
+		t.Errorf("mode = %v, want 0751", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime = %v, want %v", info.ModTime(), mtime)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "notes.txt")); err != nil || string(data) != "notes" {
+		t.Errorf("notes.txt = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, ManifestKey)); !os.IsNotExist(err) {
+		t.Errorf("manifest restored as a file: %v", err)
+	}
+}
+
+func TestRestore_dryRun(t *testing.T) {
+	dst := newMockDest()
+	dst.objects["a.txt"] = &ObjectMeta{Size: 1}
+	dst.data["a.txt"] = []byte("a")
+
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("dry-run wrote a.txt: %v", err)
+	}
+}
+
+func TestRestore_pathsAndOverwrite(t *testing.T) {
+	src := t.TempDir()
+	old := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"photos/2023/a.jpg", "photos/2023/trip/b.jpg", "photos/2024/c.jpg", "notes.txt"} {
+		writeFile(t, src, name, name)
+		if err := os.Chtimes(filepath.Join(src, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := newMockDest()
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	if err := Restore(ctx, RestoreOptions{From: dst, To: out, Paths: []string{"photos/2023/**", "notes.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"photos/2023/a.jpg": true, "photos/2023/trip/b.jpg": true, "photos/2024/c.jpg": false, "notes.txt": true} {
+		if _, err := os.Stat(filepath.Join(out, name)); (err == nil) != want {
+			t.Errorf("%s restored: %v, want %v", name, err == nil, want)
+		}
+	}
+
+	// An edited file is kept unless the backup's copy is newer.
+	mine := filepath.Join(out, "notes.txt")
+	for _, tt := range []struct {
+		policy  OverwritePolicy
+		mtime   time.Time
+		replace bool
+	}{
+		{OverwriteNever, old.Add(-time.Hour), false},
+		{OverwriteIfNewer, old, false},
+		{OverwriteIfNewer, old.Add(-time.Hour), true},
+		{OverwriteAlways, old.Add(time.Hour), true},
+	} {
+		if err := os.WriteFile(mine, []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(mine, tt.mtime, tt.mtime); err != nil {
+			t.Fatal(err)
+		}
+		if err := Restore(ctx, RestoreOptions{From: dst, To: out, Paths: []string{"notes.txt"}, Overwrite: tt.policy}); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := os.ReadFile(mine)
+		if replaced := string(got) != "mine"; replaced != tt.replace {
+			t.Errorf("%s with a file modified at %v: replaced %v, want %v", tt.policy, tt.mtime, replaced, tt.replace)
+		}
+	}
+}
+
+// archiveDest is a mockDest whose objects start out archived. A restore
+// completes after the given number of status checks.
+type archiveDest struct {
+	*mockDest
+	states   map[string]ArchiveState
+	checks   map[string]int
+	after    int
+	requests []string
+	maxBusy  int
+}
+
+func newArchiveDest(after int) *archiveDest {
+	return &archiveDest{
+		mockDest: newMockDest(),
+		states:   make(map[string]ArchiveState),
+		checks:   make(map[string]int),
+		after:    after,
+	}
+}
+
+func (d *archiveDest) ArchiveStatus(_ context.Context, key string) (ArchiveStatus, error) {
+	if d.states[key] == Restoring {
+		if d.checks[key]++; d.checks[key] > d.after {
+			d.states[key] = Restored
+		}
+	}
+	return ArchiveStatus{State: d.states[key]}, nil
+}
+
+func (d *archiveDest) RequestRestore(_ context.Context, key string, tier RestoreTier, days int) error {
+	d.requests = append(d.requests, fmt.Sprintf("%s %s %d", key, tier, days))
+	d.states[key] = Restoring
+	busy := 0
+	for _, s := range d.states {
+		if s == Restoring {
+			busy++
+		}
+	}
+	d.maxBusy = max(d.maxBusy, busy)
+	return nil
+}
+
+func (d *archiveDest) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if st := d.states[key]; st == Archived || st == Restoring {
+		return nil, fmt.Errorf("%s is archived", key)
+	}
+	return d.mockDest.Get(ctx, key)
+}
+
+func TestRestore_checksEncryptionContext(t *testing.T) {
+	ec := map[string]string{"job": "photos", "host": "nas"}
+	dst := newMockDest()
+	dst.objects["a.txt"] = &ObjectMeta{Size: 1, EncryptionContext: ec}
+	dst.data["a.txt"] = []byte("a")
+
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, EncryptionContext: ec}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.objects["b.txt"] = &ObjectMeta{Size: 1, EncryptionContext: map[string]string{"job": "docs"}}
+	dst.data["b.txt"] = []byte("b")
+	err := Restore(context.Background(), RestoreOptions{From: dst, To: t.TempDir(), EncryptionContext: ec})
+	if err == nil || !strings.Contains(err.Error(), "encrypted with context job=docs, want host=nas,job=photos") {
+		t.Errorf("err = %v, want the mismatched context", err)
+	}
+}
+
+func TestRestore_checksums(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "good")
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Checksums: true}); err != nil {
+		t.Fatal(err)
+	}
+	if dst.objects["a.txt"].SHA256 == "" {
+		t.Fatal("no SHA-256 recorded with a.txt")
+	}
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Content corrupted at the destination is refused, and the local copy
+	// kept.
+	dst.data["a.txt"] = []byte("evil")
+	out := t.TempDir()
+	writeFile(t, out, "a.txt", "mine")
+	err := Restore(context.Background(), RestoreOptions{From: dst, To: out})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("err = %v, want ErrChecksumMismatch", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "a.txt")); err != nil || string(data) != "mine" {
+		t.Errorf("a.txt = %q, %v; want the local copy kept", data, err)
+	}
+}
+
+func TestRestore_archivedInBatches(t *testing.T) {
+	dst := newArchiveDest(2)
+	for _, key := range []string{"a", "b", "c", "d", "e", "hot"} {
+		dst.objects[key] = &ObjectMeta{Size: 1}
+		dst.data[key] = []byte(key)
+		if key != "hot" {
+			dst.states[key] = Archived
+		}
+	}
+
+	out := t.TempDir()
+	err := Restore(context.Background(), RestoreOptions{
+		From: dst, To: out, Tier: TierBulk, BatchSize: 2, PollInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range dst.objects {
+		if data, err := os.ReadFile(filepath.Join(out, key)); err != nil || string(data) != key {
+			t.Errorf("%s = %q, %v", key, data, err)
+		}
+	}
+	want := []string{"a Bulk 7", "b Bulk 7", "c Bulk 7", "d Bulk 7", "e Bulk 7"}
+	if !slices.Equal(dst.requests, want) {
+		t.Errorf("requests = %v, want %v", dst.requests, want)
+	}
+	if dst.maxBusy != 2 {
+		t.Errorf("%d restores at once, want 2", dst.maxBusy)
+	}
+}
+
+func TestRestore_noWait(t *testing.T) {
+	dst := newArchiveDest(0)
+	dst.objects["cold"] = &ObjectMeta{Size: 1}
+	dst.states["cold"] = Archived
+	dst.objects["hot"] = &ObjectMeta{Size: 1}
+	dst.data["hot"] = []byte("h")
+
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, NoWait: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.requests) != 1 || dst.requests[0] != "cold Standard 7" {
+		t.Errorf("requests = %v, want cold at the Standard tier", dst.requests)
+	}
+	if _, err := os.Stat(filepath.Join(out, "hot")); err != nil {
+		t.Errorf("readable object not downloaded: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "cold")); !os.IsNotExist(err) {
+		t.Errorf("archived object downloaded without waiting: %v", err)
+	}
+}
+
+func TestArchiveReport(t *testing.T) {
+	dst := newArchiveDest(0)
+	dst.objects["a"] = &ObjectMeta{}
+	dst.objects["b"] = &ObjectMeta{}
+	dst.objects[ManifestKey] = &ObjectMeta{}
+	dst.states["b"] = Archived
+
+	got, err := ArchiveReport(context.Background(), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []string
+	for _, o := range got {
+		states = append(states, o.Key+" "+o.State.String())
+	}
+	if want := []string{"a readable", "b archived"}; !slices.Equal(states, want) {
+		t.Errorf("report = %v, want %v", states, want)
+	}
+}
@@ -0,0 +1,194 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	gosync "sync"
+)
+
+// ErrPOSIXDenied is returned by Restore with POSIXStrict for a file whose
+// recorded ownership or extended attributes it was not permitted to set.
+var ErrPOSIXDenied = errors.New("not permitted to restore POSIX attributes")
+
+// POSIXPolicy decides what Restore does with the POSIX attributes recorded
+// with files (see Options.PreservePOSIX) that it is not permitted to set,
+// as happens when it does not run as root.
+type POSIXPolicy string
+
+const (
+	// POSIXBestEffort sets what it may, and reports the rest.
+	POSIXBestEffort POSIXPolicy = "best-effort"
+	// POSIXStrict fails the restore at the first file whose attributes
+	// cannot all be set.
+	POSIXStrict POSIXPolicy = "strict"
+	// POSIXIgnore sets only the permissions, which are always permitted:
+	// files are owned by the restorer, without extended attributes.
+	POSIXIgnore POSIXPolicy = "ignore"
+)
+
+// ParsePOSIXPolicy parses the names used on the command line: best-effort,
+// strict and ignore.
+func ParsePOSIXPolicy(s string) (POSIXPolicy, error) {
+	switch p := POSIXPolicy(s); p {
+	case POSIXBestEffort, POSIXStrict, POSIXIgnore:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown POSIX policy %q (valid: best-effort, strict, ignore)", s)
+}
+
+// applyPOSIX sets attrs on the file at path, which is restored as key, as
+// d.posix says.
+func (d *LocalDestination) applyPOSIX(key, path string, attrs *POSIXAttrs) error {
+	if d.posix == POSIXIgnore {
+		if attrs == nil {
+			return nil
+		}
+		return os.Chmod(path, attrs.Mode)
+	}
+	denied, err := applyPOSIX(path, attrs)
+	if err != nil || denied == nil {
+		return err
+	}
+	if d.posix == POSIXStrict {
+		return fmt.Errorf("%w: %s", ErrPOSIXDenied, denied.describe())
+	}
+	d.denied.add(key, denied)
+	return nil
+}
+
+// reportPOSIX warns of the attributes in r that were not restored, and
+// writes opts.OwnershipScript to set them if it is set.
+func reportPOSIX(opts RestoreOptions, r *posixReport) error {
+	s := r.summary()
+	if s == "" {
+		return nil
+	}
+	if opts.OwnershipScript == "" {
+		opts.warnf("%s; restore as root to set them", s)
+		return nil
+	}
+	if err := r.writeScript(opts.OwnershipScript, opts.To); err != nil {
+		return fmt.Errorf("ownership script: %w", err)
+	}
+	opts.warnf("%s; run %s as root to set them", s, opts.OwnershipScript)
+	return nil
+}
+
+// describe lists what of the attributes applyPOSIX returned were denied.
+func (a *POSIXAttrs) describe() string {
+	var what []string
+	if a.UID >= 0 || a.GID >= 0 {
+		what = append(what, "owner "+a.owner())
+	}
+	for _, name := range slices.Sorted(maps.Keys(a.Xattrs)) {
+		what = append(what, "xattr "+name)
+	}
+	return strings.Join(what, ", ")
+}
+
+// owner formats the ownership of a for chown: uid:gid, uid or :gid.
+func (a *POSIXAttrs) owner() string {
+	var s string
+	if a.UID >= 0 {
+		s = strconv.Itoa(a.UID)
+	}
+	if a.GID >= 0 {
+		s += ":" + strconv.Itoa(a.GID)
+	}
+	return s
+}
+
+// posixReport collects the attributes Restore could not set, by the name
+// of the file they belong to. A nil *posixReport drops them.
+type posixReport struct {
+	mu    gosync.Mutex
+	files map[string]*POSIXAttrs
+}
+
+func (r *posixReport) add(name string, denied *POSIXAttrs) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.files == nil {
+		r.files = make(map[string]*POSIXAttrs)
+	}
+	r.files[name] = denied
+}
+
+// summary describes what was not restored in a line, or returns "" if
+// every attribute was.
+func (r *posixReport) summary() string {
+	var owners, xattrs int
+	for _, a := range r.files {
+		if a.UID >= 0 || a.GID >= 0 {
+			owners++
+		}
+		if len(a.Xattrs) > 0 {
+			xattrs++
+		}
+	}
+	var parts []string
+	if owners > 0 {
+		parts = append(parts, fmt.Sprintf("the ownership of %d files", owners))
+	}
+	if xattrs > 0 {
+		parts = append(parts, fmt.Sprintf("extended attributes of %d files", xattrs))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, " and ") + " could not be restored without more privileges"
+}
+
+// writeScript writes a shell script to path that sets, when run as root,
+// the attributes in r on the files restored into dir.
+func (r *posixReport) writeScript(path, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o700)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintln(w, "# Sets the ownership and extended attributes foldersync restore could not, as root.")
+	fmt.Fprintln(w, "set -e")
+	for _, name := range slices.Sorted(maps.Keys(r.files)) {
+		a := r.files[name]
+		file := shellQuote(filepath.Join(dir, filepath.FromSlash(name)))
+		if a.UID >= 0 || a.GID >= 0 {
+			fmt.Fprintf(w, "chown -h %s %s\n", a.owner(), file)
+		}
+		for _, x := range slices.Sorted(maps.Keys(a.Xattrs)) {
+			fmt.Fprintf(w, "setfattr -h -n %s -v 0s%s %s\n",
+				shellQuote(x), base64.StdEncoding.EncodeToString(a.Xattrs[x]), file)
+		}
+		if a.Mode&(fs.ModeSetuid|fs.ModeSetgid) != 0 {
+			// chown cleared them.
+			fmt.Fprintf(w, "chmod %o %s\n", unixMode(a.Mode), file)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,58 @@
+package sync
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPOSIXReport_script(t *testing.T) {
+	r := &posixReport{}
+	r.add("bin/su'do", &POSIXAttrs{Mode: 0o755 | fs.ModeSetuid, UID: 0, GID: 0})
+	r.add("notes.txt", &POSIXAttrs{Mode: 0o644, UID: -1, GID: -1, Xattrs: map[string][]byte{"trusted.tag": []byte("x")}})
+	if got, want := r.summary(), "the ownership of 1 files and extended attributes of 1 files could not be restored without more privileges"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	script := filepath.Join(t.TempDir(), "fix.sh")
+	if err := r.writeScript(script, "/srv/home"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `#!/bin/sh
+# Sets the ownership and extended attributes foldersync restore could not, as root.
+set -e
+chown -h 0:0 '/srv/home/bin/su'\''do'
+chmod 4755 '/srv/home/bin/su'\''do'
+setfattr -h -n 'trusted.tag' -v 0seA== '/srv/home/notes.txt'
+`
+	if string(data) != want {
+		t.Errorf("script:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestPOSIXReport_none(t *testing.T) {
+	var r posixReport
+	if s := r.summary(); s != "" {
+		t.Errorf("summary = %q, want none", s)
+	}
+	if err := reportPOSIX(RestoreOptions{OwnershipScript: filepath.Join(t.TempDir(), "fix.sh")}, &r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePOSIXPolicy(t *testing.T) {
+	for _, s := range []string{"best-effort", "strict", "ignore"} {
+		if p, err := ParsePOSIXPolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParsePOSIXPolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParsePOSIXPolicy("root"); err == nil || !strings.Contains(err.Error(), "best-effort") {
+		t.Errorf("ParsePOSIXPolicy(root) = %v, want an error listing the policies", err)
+	}
+}
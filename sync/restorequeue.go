@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// RestoreQueue is a persistent list of objects to restore from a
+// destination whose objects may be archived. Unlike Restore, which waits
+// for its restores in one long run, a queue is worked through by calls to
+// Process, from repeated invocations or a long-running loop, and survives
+// restarts in between.
+type RestoreQueue struct {
+	path  string
+	Items []QueuedRestore // in the order they were added
+
+	// Log, if set, receives each restore requested and object downloaded
+	// by Process.
+	Log io.Writer
+}
+
+// QueuedRestore is an object waiting in a RestoreQueue.
+type QueuedRestore struct {
+	Key       string      `json:"key"`
+	To        string      `json:"to"` // local directory to restore into
+	Tier      RestoreTier `json:"tier"`
+	Added     time.Time   `json:"added"`
+	Requested time.Time   `json:"requested,omitzero"` // when its restore was last requested
+	State     string      `json:"state,omitempty"`    // as of the last Process
+}
+
+type restoreQueueFile struct {
+	Items []QueuedRestore `json:"items"`
+}
+
+// LoadRestoreQueue reads the queue stored at path. A missing file is an
+// empty queue.
+func LoadRestoreQueue(path string) (*RestoreQueue, error) {
+	q := &RestoreQueue{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f restoreQueueFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	q.Items = f.Items
+	return q, nil
+}
+
+// Save writes the queue back to its file, removing the file once the
+// queue is empty.
+func (q *RestoreQueue) Save() error {
+	if len(q.Items) == 0 {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return writeCacheFile(q.path, restoreQueueFile{Items: q.Items})
+}
+
+// Add queues the objects in from whose keys match patterns, in path.Match
+// syntax, to be restored into the directory to at tier. A pattern also
+// matches the keys under it. Objects already queued are left as they are.
+// It returns the number of objects added.
+func (q *RestoreQueue) Add(ctx context.Context, from Destination, to string, tier RestoreTier, patterns []string) (int, error) {
+	keys, err := restoreKeys(ctx, from)
+	if err != nil {
+		return 0, err
+	}
+	queued := make(map[string]bool, len(q.Items))
+	for _, it := range q.Items {
+		queued[it.Key] = true
+	}
+	now := time.Now()
+	added := 0
+	for _, key := range keys {
+		if queued[key] || !matchKey(patterns, key) {
+			continue
+		}
+		q.Items = append(q.Items, QueuedRestore{Key: key, To: to, Tier: tier, Added: now})
+		added++
+	}
+	return added, nil
+}
+
+// QueueProgress is the outcome of one RestoreQueue.Process pass.
+type QueueProgress struct {
+	Requested  int // restores requested by this pass
+	Restoring  int // restores still in progress
+	Downloaded int // objects downloaded and removed from the queue
+}
+
+// Process makes one pass over the queue: it requests restores of archived
+// objects, keeping restored copies for days, and downloads every object
+// that is readable, removing it from the queue. The queue is saved after
+// each change, so an interrupted pass loses nothing.
+func (q *RestoreQueue) Process(ctx context.Context, from Destination, days int) (QueueProgress, error) {
+	var p QueueProgress
+	for i := 0; i < len(q.Items); {
+		it := &q.Items[i]
+		st, err := archiveStatus(ctx, from, it.Key)
+		if err != nil {
+			return p, &FileError{Op: "restore", Key: it.Key, Err: err}
+		}
+		it.State = st.State.String()
+
+		switch {
+		case st.readable():
+			fmt.Fprintf(logTo(q.Log), "restore %s\n", it.Key)
+			if err := restoreFile(ctx, from, NewLocalDestination(it.To), it.Key, it.Key, nil, nil, transfer{}); err != nil {
+				return p, &FileError{Op: "restore", Key: it.Key, Err: err}
+			}
+			q.Items = slices.Delete(q.Items, i, i+1)
+			p.Downloaded++
+			if err := q.Save(); err != nil {
+				return p, err
+			}
+			continue
+		case st.State == Archived:
+			fmt.Fprintf(logTo(q.Log), "request restore %s (%s)\n", it.Key, it.Tier)
+			if err := from.(Archiver).RequestRestore(ctx, it.Key, it.Tier, days); err != nil {
+				return p, fmt.Errorf("request restore of %s: %w", it.Key, err)
+			}
+			it.Requested = time.Now()
+			it.State = Restoring.String()
+			p.Requested++
+			if err := q.Save(); err != nil {
+				return p, err
+			}
+		}
+		if it.State == Restoring.String() {
+			p.Restoring++
+		}
+		i++
+	}
+	return p, q.Save()
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreQueue(t *testing.T) {
+	dst := newArchiveDest(1)
+	for _, key := range []string{"photos/a.jpg", "photos/b.jpg", "docs/c.txt"} {
+		dst.objects[key] = &ObjectMeta{Size: 1}
+		dst.data[key] = []byte(key)
+		dst.states[key] = Archived
+	}
+	out := t.TempDir()
+	path := filepath.Join(t.TempDir(), "queue.json")
+	ctx := context.Background()
+
+	q, err := LoadRestoreQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.Add(ctx, dst, out, TierBulk, []string{"photos"}); err != nil || n != 2 {
+		t.Fatalf("Add = %d, %v; want 2 objects", n, err)
+	}
+	if n, _ := q.Add(ctx, dst, out, TierBulk, []string{"photos/a.jpg"}); n != 0 {
+		t.Errorf("queued photos/a.jpg twice")
+	}
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each pass is a separate invocation, loading the queue afresh.
+	var passes []QueueProgress
+	for range 3 {
+		q, err := LoadRestoreQueue(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := q.Process(ctx, dst, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		passes = append(passes, p)
+	}
+	want := []QueueProgress{{Requested: 2, Restoring: 2}, {Restoring: 2}, {Downloaded: 2}}
+	for i := range want {
+		if passes[i] != want[i] {
+			t.Errorf("pass %d = %+v, want %+v", i+1, passes[i], want[i])
+		}
+	}
+	if len(dst.requests) != 2 || dst.requests[0] != "photos/a.jpg Bulk 3" {
+		t.Errorf("requests = %v", dst.requests)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "photos/b.jpg")); err != nil || string(data) != "photos/b.jpg" {
+		t.Errorf("photos/b.jpg = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "docs/c.txt")); !os.IsNotExist(err) {
+		t.Errorf("unqueued docs/c.txt restored: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("empty queue file left behind: %v", err)
+	}
+}
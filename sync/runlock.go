@@ -0,0 +1,343 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	stdsync "sync"
+	"time"
+)
+
+// RunLockKey is the key of the lock kept at a destination by runs with
+// RunLock.Remote set.
+const RunLockKey = metaPrefix + "lock.json"
+
+// ErrLocked is returned when another run holds a lock the run needs. See
+// Options.Lock.
+var ErrLocked = errors.New("locked by another run")
+
+// RunLock configures the locks a run holds while it lasts, so that
+// overlapping runs, such as two cron invocations or two machines syncing
+// to the same destination, do not change the same files at once and, with
+// Options.Delete, delete what the other uploads. The locks are advisory:
+// they only keep out other runs that take them.
+type RunLock struct {
+	// File is the path of a local lock file, created while the run lasts.
+	// Empty means none.
+	File string
+	// Remote also keeps a lock at RunLockKey in the destination, for runs
+	// on other machines. The destination must implement Getter. Objects
+	// cannot be created only if absent, so the lock is read back after it
+	// is written to tell which of two runs starting together got it.
+	Remote bool
+	// StaleAfter is how long a lock may go without being refreshed before
+	// it is taken to have been left by a run that died; zero means never.
+	// Locks are refreshed at a quarter of it while the run lasts. A lock
+	// held by a process of this machine that has exited is stale whatever
+	// its age.
+	StaleAfter time.Duration
+	// Force takes the locks even if another run holds them.
+	Force bool
+}
+
+// LockHolder describes the run holding a lock.
+type LockHolder struct {
+	Host      string    `json:"host"`
+	PID       int       `json:"pid"`
+	Token     string    `json:"token"` // tells runs of the same process apart
+	Acquired  time.Time `json:"acquired"`
+	Refreshed time.Time `json:"refreshed"`
+}
+
+func (h LockHolder) String() string {
+	return fmt.Sprintf("process %d on %s, since %s", h.PID, h.Host, h.Acquired.Format(time.RFC3339))
+}
+
+// stale reports whether h was left by a run that died, as seen by self.
+func (h LockHolder) stale(self LockHolder, after time.Duration) bool {
+	if h.Host == self.Host && h.PID != self.PID && !processAlive(h.PID) {
+		return true
+	}
+	return after > 0 && time.Since(h.Refreshed) > after
+}
+
+// newLockHolder returns the holder of the locks of a run of this process.
+func newLockHolder() (LockHolder, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return LockHolder{}, err
+	}
+	now := time.Now()
+	return LockHolder{Host: host, PID: os.Getpid(), Token: rand.Text(), Acquired: now, Refreshed: now}, nil
+}
+
+// acquireRunLock takes the locks opts.Lock configures and returns a
+// function that releases them. Dry runs and read-only runs take none.
+func acquireRunLock(ctx context.Context, opts Options) (release func(), err error) {
+	l := opts.Lock
+	if l == nil || opts.DryRun || opts.ReadOnly {
+		return func() {}, nil
+	}
+	if l.StaleAfter < 0 {
+		return nil, errors.New("run lock: the stale lock age must not be negative")
+	}
+	if _, ok := opts.Dst.(Getter); l.Remote && !ok {
+		return nil, fmt.Errorf("run lock: destination cannot read objects back: %w", errors.ErrUnsupported)
+	}
+	self, err := newLockHolder()
+	if err != nil {
+		return nil, err
+	}
+	if l.File != "" {
+		if err := lockFile(l.File, self, *l); err != nil {
+			return nil, err
+		}
+	}
+	if l.Remote {
+		if err := lockRemote(ctx, opts.Dst, self, *l); err != nil {
+			if l.File != "" {
+				unlockFile(l.File, self)
+			}
+			return nil, err
+		}
+	}
+
+	// Keep the locks fresh until released.
+	done := make(chan struct{})
+	var wg stdsync.WaitGroup
+	if l.StaleAfter > 0 {
+		wg.Go(func() {
+			t := time.NewTicker(l.StaleAfter / 4)
+			defer t.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+				}
+				self.Refreshed = time.Now()
+				if l.File != "" {
+					if err := writeLockFile(l.File, self); err != nil {
+						opts.warnf("refresh lock file: %v", err)
+					}
+				}
+				if l.Remote {
+					if err := putRunLock(context.WithoutCancel(ctx), opts.Dst, self); err != nil {
+						opts.warnf("refresh destination lock: %v", err)
+					}
+				}
+			}
+		})
+	}
+	return func() {
+		close(done)
+		wg.Wait()
+		if l.Remote {
+			if err := unlockRemote(context.WithoutCancel(ctx), opts.Dst, self); err != nil {
+				opts.warnf("release destination lock: %v", err)
+			}
+		}
+		if l.File != "" {
+			unlockFile(l.File, self)
+		}
+	}, nil
+}
+
+// lockFile creates the lock file at path for self, replacing one left by a
+// run that died, or held by another if l.Force is set. A lock file that
+// cannot be read is taken to be held until it is older than l.StaleAfter.
+func lockFile(path string, self LockHolder, l RunLock) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("lock file: %w", err)
+	}
+	data, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	for range 2 {
+		err := createLockFile(path, data)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("lock file: %w", err)
+		}
+		h, err := readLockFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue // released meanwhile
+		case l.Force:
+		case err == nil && !h.stale(self, l.StaleAfter):
+			return fmt.Errorf("%w: %s is held by %s", ErrLocked, path, h)
+		case err != nil && !lockFileStale(path, l.StaleAfter):
+			return fmt.Errorf("%w: %s is held, but cannot be read: %v", ErrLocked, path, err)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("lock file: %w", err)
+		}
+	}
+	return fmt.Errorf("%w: %s was taken while it was being replaced", ErrLocked, path)
+}
+
+// createLockFile creates the file at path holding data, failing with an
+// error wrapping fs.ErrExist if it exists. It is written under another name
+// and linked into place, so that it is never seen half written; on file
+// systems without hard links it is created with O_EXCL and written in
+// place.
+func createLockFile(path string, data []byte) error {
+	tmp, err := writeTempLockFile(path, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, path); err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// writeTempLockFile writes data to a new file next to path, and returns its
+// name.
+func writeTempLockFile(path string, data []byte) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// lockFileStale reports whether the lock file at path was last written
+// longer than after ago, a lock that could not be read having nothing else
+// to tell.
+func lockFileStale(path string, after time.Duration) bool {
+	info, err := os.Stat(path)
+	return err == nil && after > 0 && time.Since(info.ModTime()) > after
+}
+
+func readLockFile(path string) (LockHolder, error) {
+	var h LockHolder
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h, err
+	}
+	return h, json.Unmarshal(data, &h)
+}
+
+// writeLockFile refreshes the lock file at path if self still holds it,
+// replacing it with a rename so that it is never seen half written.
+func writeLockFile(path string, self LockHolder) error {
+	if h, err := readLockFile(path); err != nil || h.Token != self.Token {
+		return fmt.Errorf("%s is no longer held by this run", path)
+	}
+	data, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	tmp, err := writeTempLockFile(path, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// unlockFile removes the lock file at path if self still holds it.
+func unlockFile(path string, self LockHolder) {
+	if h, err := readLockFile(path); err == nil && h.Token == self.Token {
+		os.Remove(path)
+	}
+}
+
+// lockRemote writes the lock at RunLockKey in dst for self, unless another
+// run holds it.
+func lockRemote(ctx context.Context, dst Destination, self LockHolder, l RunLock) error {
+	h, err := readRunLock(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if h != nil && !l.Force && !h.stale(self, l.StaleAfter) {
+		return fmt.Errorf("%w: the destination's lock is held by %s", ErrLocked, h)
+	}
+	if err := putRunLock(ctx, dst, self); err != nil {
+		return err
+	}
+	if h, err = readRunLock(ctx, dst); err != nil {
+		return err
+	}
+	if h == nil || h.Token != self.Token {
+		return fmt.Errorf("%w: the destination's lock was taken at the same time", ErrLocked)
+	}
+	return nil
+}
+
+// readRunLock reads the lock at RunLockKey in dst, returning nil if there
+// is none.
+func readRunLock(ctx context.Context, dst Destination) (*LockHolder, error) {
+	data, err := readObject(ctx, dst, RunLockKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read destination lock: %w", err)
+	}
+	var h LockHolder
+	if err := json.Unmarshal(data, &h); err != nil {
+		// Left half written; as good as none.
+		return nil, nil
+	}
+	return &h, nil
+}
+
+func putRunLock(ctx context.Context, dst Destination, self LockHolder) error {
+	data, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+	meta := ObjectMeta{Size: int64(len(data)), ModTime: self.Refreshed, ContentType: "application/json"}
+	if err := dst.Put(ctx, RunLockKey, bytes.NewReader(data), meta); err != nil {
+		return fmt.Errorf("write destination lock: %w", err)
+	}
+	return nil
+}
+
+// unlockRemote deletes the lock at RunLockKey in dst if self still holds
+// it.
+func unlockRemote(ctx context.Context, dst Destination, self LockHolder) error {
+	h, err := readRunLock(ctx, dst)
+	if err != nil || h == nil || h.Token != self.Token {
+		return err
+	}
+	return dst.Delete(ctx, RunLockKey)
+}
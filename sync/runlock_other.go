@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package sync
+
+// Processes cannot be looked up on this platform, so locks are only taken
+// to be stale by age.
+
+func processAlive(int) bool { return true }
@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// heldBy returns the lock of a live process other than this one, on host,
+// refreshed at refreshed.
+func heldBy(t *testing.T, host string, refreshed time.Time) []byte {
+	t.Helper()
+	data, err := json.Marshal(LockHolder{Host: host, PID: os.Getppid(), Token: "other", Acquired: refreshed, Refreshed: refreshed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSync_runLockFile(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "lock.json")
+	if err := os.WriteFile(file, heldBy(t, host, time.Now()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMockDest()
+	lock := &RunLock{File: file, StaleAfter: time.Hour}
+	opts := Options{Src: src, Dst: dst, Lock: lock}
+	if _, err := Sync(context.Background(), opts); !errors.Is(err, ErrLocked) {
+		t.Fatalf("err = %v, want ErrLocked", err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("a locked run uploaded %v", dst.putCalls)
+	}
+
+	// Dry runs take no lock.
+	opts.DryRun = true
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Errorf("dry run: %v", err)
+	}
+	opts.DryRun = false
+
+	// A lock that has not been refreshed for longer than StaleAfter was
+	// left by a run that died.
+	lock.StaleAfter = time.Minute
+	if err := os.WriteFile(file, heldBy(t, host, time.Now().Add(-time.Hour)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("lock file left after the run: %v", err)
+	}
+
+	if err := os.WriteFile(file, heldBy(t, host, time.Now()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lock.Force = true
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Errorf("forced: %v", err)
+	}
+}
+
+func TestSync_runLockFile_unreadable(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	file := filepath.Join(t.TempDir(), "lock.json")
+	lock := &RunLock{File: file, StaleAfter: time.Hour}
+	opts := Options{Src: src, Dst: newMockDest(), Lock: lock}
+
+	// An empty or half written lock may be being written by another run.
+	for _, data := range []string{"", `{"host": "elsewhere", "pi`} {
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Sync(context.Background(), opts); !errors.Is(err, ErrLocked) {
+			t.Errorf("with lock file %q: err = %v, want ErrLocked", data, err)
+		}
+		if got, _ := os.ReadFile(file); string(got) != data {
+			t.Errorf("lock file %q replaced with %q", data, got)
+		}
+	}
+
+	// Until it is older than StaleAfter.
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatalf("stale unreadable lock: %v", err)
+	}
+
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lock.Force = true
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Errorf("forced: %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("lock file left after the run: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(file)); len(entries) != 0 {
+		t.Errorf("left %v next to the lock file", entries)
+	}
+}
+
+func TestSync_runLockRemote(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	putRemote(dst, RunLockKey, string(heldBy(t, "elsewhere", time.Now())), time.Now())
+
+	opts := Options{Src: src, Dst: dst, Delete: true, Lock: &RunLock{Remote: true, StaleAfter: time.Hour}}
+	if _, err := Sync(context.Background(), opts); !errors.Is(err, ErrLocked) {
+		t.Fatalf("err = %v, want ErrLocked", err)
+	}
+
+	dst.Delete(context.Background(), RunLockKey)
+	held := false
+	opts.PreSync = func(context.Context) error {
+		_, held = dst.objects[RunLockKey]
+		return nil
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !held {
+		t.Error("the destination's lock was not held during the run")
+	}
+	if _, ok := dst.objects[RunLockKey]; ok {
+		t.Error("the destination's lock was left after the run")
+	}
+	if _, ok := dst.objects["a.txt"]; !ok {
+		t.Error("a.txt not uploaded")
+	}
+}
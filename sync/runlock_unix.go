@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package sync
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive reports whether the process pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -1,22 +1,41 @@
 package sync
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
+func init() {
+	Register("s3", openS3URL)
+	for _, sc := range types.StorageClass("").Values() {
+		RegisterStorageClasses("s3", string(sc))
+	}
+}
+
 // S3Destination uploads files to an S3 bucket using the specified storage class.
 //
 // Recommended storage classes for infrequent access (cheapest first):
@@ -30,45 +49,853 @@ type S3Destination struct {
 	bucket       string
 	prefix       string
 	storageClass types.StorageClass
+	clientOpts   []func(*s3.Options) // applied to every request
+	uploadOpts   []func(*manager.Uploader)
+	leaveParts   bool // see WithS3LeavePartsOnError
+	compat       S3Compat
+
+	listConcurrency int            // see WithS3ListConcurrency
+	adaptive        *adaptiveLimit // see WithS3AdaptiveConcurrency
+
+	// ServerSideEncryption, if set, is how uploaded objects are encrypted
+	// at rest: AES256 (SSE-S3) or aws:kms (SSE-KMS). If empty, the bucket's
+	// default encryption applies.
+	ServerSideEncryption types.ServerSideEncryption
+	// SSEKMSKeyID is the ID or ARN of the KMS key used with aws:kms. If
+	// empty, S3 uses the bucket's key or the AWS managed key aws/s3.
+	SSEKMSKeyID string
+	// SSEKMSEncryptionContext, if set, is the encryption context of
+	// objects encrypted with aws:kms: pairs KMS binds to each object's data
+	// key, which key policies and grants can require. S3 does not report
+	// it back, so it is also recorded in each object's metadata, where Stat
+	// reads it into ObjectMeta.EncryptionContext.
+	SSEKMSEncryptionContext map[string]string
+}
+
+// S3Option configures an S3Destination.
+type S3Option func(*S3Destination)
+
+// WithS3StorageClass sets the storage class of uploaded objects.
+func WithS3StorageClass(sc types.StorageClass) S3Option {
+	return func(d *S3Destination) { d.storageClass = sc }
+}
+
+// WithS3Encryption sets ServerSideEncryption and SSEKMSKeyID.
+func WithS3Encryption(sse types.ServerSideEncryption, kmsKeyID string) S3Option {
+	return func(d *S3Destination) {
+		d.ServerSideEncryption = sse
+		d.SSEKMSKeyID = kmsKeyID
+	}
+}
+
+// WithS3EncryptionContext sets SSEKMSEncryptionContext.
+func WithS3EncryptionContext(ec map[string]string) S3Option {
+	return func(d *S3Destination) { d.SSEKMSEncryptionContext = ec }
+}
+
+// WithS3ClientOptions adjusts the s3.Options of every request the
+// destination makes, including those of multipart uploads.
+func WithS3ClientOptions(fns ...func(*s3.Options)) S3Option {
+	return func(d *S3Destination) { d.clientOpts = append(d.clientOpts, fns...) }
+}
+
+// WithS3Retryer makes requests with r instead of the client's retryer.
+func WithS3Retryer(r aws.Retryer) S3Option {
+	return WithS3ClientOptions(func(o *s3.Options) { o.Retryer = r })
+}
+
+// WithS3AppID adds id to the User-Agent of every request, so the
+// embedding application can be told apart in S3 access logs.
+func WithS3AppID(id string) S3Option {
+	return WithS3ClientOptions(func(o *s3.Options) { o.AppID = id })
+}
+
+// WithS3Middleware adds fns to the middleware stack of every request.
+func WithS3Middleware(fns ...func(*middleware.Stack) error) S3Option {
+	return WithS3ClientOptions(func(o *s3.Options) { o.APIOptions = append(o.APIOptions, fns...) })
+}
+
+// WithS3Endpoint sends requests to endpoint, the URL of an S3-compatible
+// service such as MinIO, Ceph RGW, Backblaze B2 or Wasabi, instead of AWS.
+func WithS3Endpoint(endpoint string) S3Option {
+	return WithS3ClientOptions(func(o *s3.Options) { o.BaseEndpoint = aws.String(endpoint) })
+}
+
+// WithS3PathStyle names the bucket in the path of each request's URL
+// rather than in its host name, as most S3-compatible services need.
+func WithS3PathStyle() S3Option {
+	return WithS3ClientOptions(func(o *s3.Options) { o.UsePathStyle = true })
+}
+
+// WithS3Accelerate sends requests to the bucket's S3 Transfer Acceleration
+// endpoint, which routes them through the nearest CloudFront edge location:
+// faster across continents, at an extra charge per GB. The bucket must
+// have acceleration enabled and a name without dots. It cannot be combined
+// with WithS3Endpoint or WithS3PathStyle.
+func WithS3Accelerate() S3Option {
+	return WithS3ClientOptions(func(o *s3.Options) { o.UseAccelerate = true })
+}
+
+// WithS3RequestPayer sends every request with the x-amz-request-payer
+// header, accepting the charges for requests to, and downloads from, a
+// Requester Pays bucket, which otherwise refuses them.
+func WithS3RequestPayer() S3Option {
+	return WithS3Middleware(func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("RequestPayer",
+			func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					req.Header.Set("X-Amz-Request-Payer", string(types.RequestPayerRequester))
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+	})
+}
+
+// Limits on the parts of multipart uploads.
+const (
+	minS3PartSize = manager.MinUploadPartSize // 5 MiB
+	maxS3PartSize = 5 << 30
+)
+
+// WithS3PartSize sets the size of the parts files larger than it are
+// uploaded in, instead of 5 MiB. Larger parts make fewer requests, each
+// slower to retry; the uploader raises it for files that would otherwise
+// need more than 10,000 parts. See CheckS3PartSize.
+func WithS3PartSize(size int64) S3Option {
+	return func(d *S3Destination) {
+		d.uploadOpts = append(d.uploadOpts, func(u *manager.Uploader) { u.PartSize = size })
+	}
+}
+
+// WithS3UploadConcurrency sets how many parts of a file are uploaded at
+// once, instead of 5. Each holds a part's worth of memory while it is
+// uploaded.
+func WithS3UploadConcurrency(n int) S3Option {
+	return func(d *S3Destination) {
+		d.uploadOpts = append(d.uploadOpts, func(u *manager.Uploader) { u.Concurrency = n })
+	}
+}
+
+// WithS3AdaptiveConcurrency adapts how many parts of a file are uploaded
+// at once to how the uploads fare, from one up to at most n: it raises the
+// number while raising it makes the parts go faster, and lowers it when S3
+// throttles them, as with SlowDown, or they slow down. What it learns
+// carries over from file to file. Each of the n parts that may be
+// uploaded at once holds a part's worth of memory. It replaces
+// WithS3UploadConcurrency.
+func WithS3AdaptiveConcurrency(n int) S3Option {
+	return func(d *S3Destination) {
+		d.adaptive = newAdaptiveLimit(n)
+		d.uploadOpts = append(d.uploadOpts, func(u *manager.Uploader) { u.Concurrency = n })
+	}
+}
+
+// adaptiveUploadClient makes the uploads of parts wait for limit to allow
+// them, and tells it how each went.
+type adaptiveUploadClient struct {
+	*s3.Client
+	limit *adaptiveLimit
+}
+
+func (c adaptiveUploadClient) UploadPart(ctx context.Context, in *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if err := c.limit.acquire(ctx); err != nil {
+		return nil, err
+	}
+	var size int64
+	if s, ok := in.Body.(io.Seeker); ok {
+		pos, _ := s.Seek(0, io.SeekCurrent)
+		if end, err := s.Seek(0, io.SeekEnd); err == nil {
+			size = end - pos
+		}
+		s.Seek(pos, io.SeekStart)
+	}
+	// Retries happen within the call, so each attempt is checked.
+	throttled := false
+	throttle := func(stack *middleware.Stack) error {
+		return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("AdaptiveConcurrency",
+			func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+				out, md, err := next.HandleDeserialize(ctx, in)
+				if resp, ok := out.RawResponse.(*smithyhttp.Response); ok && (resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests) {
+					throttled = true
+				}
+				return out, md, err
+			}), middleware.After)
+	}
+	optFns = append(optFns, func(o *s3.Options) { o.APIOptions = append(o.APIOptions, throttle) })
+	start := time.Now()
+	out, err := c.Client.UploadPart(ctx, in, optFns...)
+	c.limit.release(size, time.Since(start), throttled, err != nil)
+	return out, err
+}
+
+// WithS3LeavePartsOnError leaves the parts of a multipart upload that
+// fails or is canceled stored, rather than aborting it, for inspection.
+// They are billed until the upload is aborted, by hand or by a lifecycle
+// rule.
+func WithS3LeavePartsOnError() S3Option {
+	return func(d *S3Destination) {
+		d.leaveParts = true
+		d.uploadOpts = append(d.uploadOpts, func(u *manager.Uploader) { u.LeavePartsOnError = true })
+	}
 }
 
-// NewS3Destination creates a new S3Destination.
-func NewS3Destination(client *s3.Client, bucket, prefix string, storageClass types.StorageClass) *S3Destination {
-	return &S3Destination{
+// WithS3ListConcurrency sets how many prefixes listings fetch at once,
+// instead of 8. Each common prefix under the destination's own, such as
+// "photos/" and "docs/", is listed by a paginator of its own, and the
+// pages merged back into byte order; a destination whose objects are
+// under a single prefix, or directly under its own, is listed in one go.
+// 1 lists every destination in one go.
+func WithS3ListConcurrency(n int) S3Option {
+	return func(d *S3Destination) { d.listConcurrency = n }
+}
+
+// CheckS3PartSize reports whether size, in bytes, is a part size S3
+// accepts: from 5 MiB to 5 GiB.
+func CheckS3PartSize(size int64) error {
+	if size < minS3PartSize || size > maxS3PartSize {
+		return fmt.Errorf("part size %d MiB: want 5 to 5120 MiB", size>>20)
+	}
+	return nil
+}
+
+// WithS3InsecureSkipVerify accepts any TLS certificate the endpoint
+// presents, for servers with self-signed certificates. Anyone on the
+// network path can then read and change what is uploaded.
+func WithS3InsecureSkipVerify() S3Option {
+	client := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		if tr.TLSClientConfig == nil {
+			tr.TLSClientConfig = &tls.Config{}
+		}
+		tr.TLSClientConfig.InsecureSkipVerify = true
+	})
+	return WithS3ClientOptions(func(o *s3.Options) { o.HTTPClient = client })
+}
+
+// CheckS3Endpoint reports whether endpoint, as given to the endpoint-url
+// parameter of an s3:// URL, is an http or https URL.
+func CheckS3Endpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint %q: want an http:// or https:// URL", endpoint)
+	}
+	return nil
+}
+
+// NewS3Destination creates a new S3Destination that makes its requests
+// with client.
+func NewS3Destination(client *s3.Client, bucket, prefix string, storageClass types.StorageClass, opts ...S3Option) *S3Destination {
+	d := &S3Destination{
 		client:       client,
-		uploader:     manager.NewUploader(client),
 		bucket:       bucket,
 		prefix:       prefix,
 		storageClass: storageClass,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	var uploadClient manager.UploadAPIClient = client
+	if d.adaptive != nil {
+		uploadClient = adaptiveUploadClient{client, d.adaptive}
+	}
+	d.uploader = manager.NewUploader(uploadClient, func(u *manager.Uploader) {
+		u.ClientOptions = append(u.ClientOptions, d.clientOpts...)
+	}, func(u *manager.Uploader) {
+		for _, fn := range d.uploadOpts {
+			fn(u)
+		}
+	})
+	return d
 }
 
-func (d *S3Destination) fullKey(rel string) string {
-	rel = strings.TrimPrefix(rel, "/")
-	if d.prefix == "" {
-		return rel
+// NewS3DestinationFromConfig creates a new S3Destination with a client
+// built from cfg, for programs that already load their own AWS
+// configuration. The storage class defaults to GLACIER_IR, as for s3://
+// URLs.
+func NewS3DestinationFromConfig(cfg aws.Config, bucket, prefix string, opts ...S3Option) *S3Destination {
+	return NewS3Destination(s3.NewFromConfig(cfg), bucket, prefix, types.StorageClassGlacierIr, opts...)
+}
+
+// openS3URL opens s3://bucket/prefix. Query parameters:
+//
+//	region          AWS region (default: from the environment, else us-east-1)
+//	storage-class   S3 storage class (default GLACIER_IR, or STANDARD with
+//	                endpoint-url)
+//	endpoint-url    URL of an S3-compatible service to use instead of AWS
+//	path-style      if true, name the bucket in the path of request URLs
+//	tls-skip-verify if true, accept any TLS certificate from the endpoint
+//	accelerate      if true, use S3 Transfer Acceleration
+//	request-payer   "requester" to pay for requests to a Requester Pays
+//	                bucket
+//	sse             server-side encryption: AES256 or aws:kms
+//	sse-kms-key-id  KMS key for aws:kms (implies sse=aws:kms)
+//	sse-context     encryption context for aws:kms, as key=value pairs
+//	                separated by commas (implies sse=aws:kms)
+//	part-size-mb    size of the parts of multipart uploads, in MiB
+//	upload-concurrency
+//	                parts of a file uploaded at once
+//	adaptive-concurrency
+//	                if true, adapt the parts uploaded at once to how they
+//	                fare, up to upload-concurrency (default 16)
+//	leave-parts-on-error
+//	                if true, don't abort multipart uploads that fail
+//	list-concurrency
+//	                prefixes listed at once (default 8)
+//	compat          how much of the S3 API the service supports: full
+//	                (default) or legacy; see S3Compat
+//	profile         shared config profile to load credentials and settings
+//	                from, which may sign in with IAM Identity Center (SSO)
+//	role-arn        IAM role to assume, with the credentials of the profile
+//	                or environment
+//	external-id     external ID the role's trust policy requires
+//	role-session-name
+//	                name of the role's sessions (default foldersync)
+func openS3URL(ctx context.Context, u *url.URL) (Destination, error) {
+	bucket, prefix, err := bucketAndPrefix(u)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	cfg, err := loadS3Config(ctx, q)
+	if err != nil {
+		return nil, err
 	}
-	return strings.TrimSuffix(d.prefix, "/") + "/" + rel
+
+	ec, err := ParseEncryptionContext(q.Get("sse-context"))
+	if err != nil {
+		return nil, err
+	}
+	sse, err := s3EncryptionWithContext(q.Get("sse"), q.Get("sse-kms-key-id"), ec)
+	if err != nil {
+		return nil, err
+	}
+	opts := []S3Option{
+		WithS3Encryption(sse, q.Get("sse-kms-key-id")),
+		WithS3EncryptionContext(ec),
+	}
+	endpoint := q.Get("endpoint-url")
+	if endpoint != "" {
+		if err := CheckS3Endpoint(endpoint); err != nil {
+			return nil, err
+		}
+		// Few S3-compatible services know the storage classes of AWS.
+		opts = append(opts, WithS3Endpoint(endpoint), WithS3StorageClass(types.StorageClassStandard))
+	}
+	pathStyle, err := boolParam(q, "path-style")
+	if err != nil {
+		return nil, err
+	} else if pathStyle {
+		opts = append(opts, WithS3PathStyle())
+	}
+	if on, err := boolParam(q, "tls-skip-verify"); err != nil {
+		return nil, err
+	} else if on {
+		opts = append(opts, WithS3InsecureSkipVerify())
+	}
+	if on, err := boolParam(q, "accelerate"); err != nil {
+		return nil, err
+	} else if on {
+		if endpoint != "" || pathStyle {
+			return nil, errors.New("accelerate cannot be combined with endpoint-url or path-style")
+		}
+		if strings.Contains(bucket, ".") {
+			return nil, fmt.Errorf("bucket %q: Transfer Acceleration does not support bucket names with dots", bucket)
+		}
+		opts = append(opts, WithS3Accelerate())
+	}
+	switch payer := q.Get("request-payer"); payer {
+	case "":
+	case string(types.RequestPayerRequester):
+		opts = append(opts, WithS3RequestPayer())
+	default:
+		return nil, fmt.Errorf("request-payer=%q: want requester", payer)
+	}
+	if sc := q.Get("storage-class"); sc != "" {
+		opts = append(opts, WithS3StorageClass(types.StorageClass(sc)))
+	}
+	transfer, err := s3TransferOptions(q)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, transfer...)
+	compat, err := ParseS3Compat(q.Get("compat"))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, WithS3Compat(compat))
+	return NewS3DestinationFromConfig(cfg, bucket, prefix, opts...), nil
 }
 
-func (d *S3Destination) relKey(full string) string {
-	if d.prefix == "" {
-		return full
+// defaultS3AdaptiveConcurrency is the most parts adaptive-concurrency
+// uploads at once unless upload-concurrency says otherwise.
+const defaultS3AdaptiveConcurrency = 16
+
+// s3TransferOptions returns the options for the part-size-mb,
+// upload-concurrency, adaptive-concurrency, leave-parts-on-error and
+// list-concurrency parameters of q, which r2:// URLs share with s3:// ones.
+func s3TransferOptions(q url.Values) ([]S3Option, error) {
+	var opts []S3Option
+	if mb, err := intParam(q, "part-size-mb"); err != nil {
+		return nil, err
+	} else if mb != 0 {
+		if err := CheckS3PartSize(int64(mb) << 20); err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithS3PartSize(int64(mb)<<20))
+	}
+	adaptive, err := boolParam(q, "adaptive-concurrency")
+	if err != nil {
+		return nil, err
+	}
+	if n, err := intParam(q, "upload-concurrency"); err != nil {
+		return nil, err
+	} else if n < 0 {
+		return nil, fmt.Errorf("upload-concurrency=%d: want a positive number", n)
+	} else if adaptive {
+		if n == 0 {
+			n = defaultS3AdaptiveConcurrency
+		}
+		opts = append(opts, WithS3AdaptiveConcurrency(n))
+	} else if n > 0 {
+		opts = append(opts, WithS3UploadConcurrency(n))
+	}
+	if on, err := boolParam(q, "leave-parts-on-error"); err != nil {
+		return nil, err
+	} else if on {
+		opts = append(opts, WithS3LeavePartsOnError())
+	}
+	if n, err := intParam(q, "list-concurrency"); err != nil {
+		return nil, err
+	} else if n < 0 {
+		return nil, fmt.Errorf("list-concurrency=%d: want a positive number", n)
+	} else if n > 0 {
+		opts = append(opts, WithS3ListConcurrency(n))
+	}
+	return opts, nil
+}
+
+// boolParam returns the query parameter name as a boolean, false if it is
+// absent.
+func boolParam(q url.Values, name string) (bool, error) {
+	if !q.Has(name) {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(q.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("%s=%q: want true or false", name, q.Get(name))
+	}
+	return on, nil
+}
+
+// intParam returns the query parameter name as an integer, zero if it is
+// absent.
+func intParam(q url.Values, name string) (int, error) {
+	if !q.Has(name) {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(q.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("%s=%q: want a number", name, q.Get(name))
+	}
+	return n, nil
+}
+
+// S3 limits on object tags.
+const (
+	maxS3Tags        = 10
+	maxS3TagKeyLen   = 128
+	maxS3TagValueLen = 256
+)
+
+// CheckS3Tags reports whether tags are valid object tags for S3: at most
+// 10 tags, each with a non-empty key of up to 128 characters and a value of
+// up to 256, neither starting with the reserved prefix "aws:".
+func CheckS3Tags(tags map[string]string) error {
+	if len(tags) > maxS3Tags {
+		return fmt.Errorf("%d tags given; S3 allows at most %d per object", len(tags), maxS3Tags)
+	}
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		v := tags[k]
+		switch {
+		case k == "":
+			return errors.New("tag key must not be empty")
+		case utf8.RuneCountInString(k) > maxS3TagKeyLen:
+			return fmt.Errorf("tag key %q is longer than %d characters", k, maxS3TagKeyLen)
+		case utf8.RuneCountInString(v) > maxS3TagValueLen:
+			return fmt.Errorf("value of tag %q is longer than %d characters", k, maxS3TagValueLen)
+		case strings.HasPrefix(k, "aws:"):
+			return fmt.Errorf("tag key %q uses the reserved prefix aws:", k)
+		}
+	}
+	return nil
+}
+
+// s3Tagging formats tags for the Tagging header of an upload, or returns
+// nil if there are none.
+func s3Tagging(tags map[string]string) *string {
+	if len(tags) == 0 {
+		return nil
+	}
+	q := make(url.Values, len(tags))
+	for k, v := range tags {
+		q.Set(k, v)
+	}
+	return aws.String(q.Encode())
+}
+
+// CheckServerSideEncryption reports whether sse, kmsKeyID and the
+// encryption context ec, as given to the sse, sse-kms-key-id and
+// sse-context parameters of an s3:// URL, are valid.
+func CheckServerSideEncryption(sse, kmsKeyID string, ec map[string]string) error {
+	_, err := s3EncryptionWithContext(sse, kmsKeyID, ec)
+	return err
+}
+
+// s3EncryptionWithContext is s3Encryption for an encryption context too,
+// which, like a key ID, selects aws:kms if no mode is given.
+func s3EncryptionWithContext(sse, kmsKeyID string, ec map[string]string) (types.ServerSideEncryption, error) {
+	if err := checkEncryptionContext(ec); err != nil {
+		return "", err
+	}
+	if sse == "" && len(ec) > 0 {
+		sse = string(types.ServerSideEncryptionAwsKms)
+	}
+	mode, err := s3Encryption(sse, kmsKeyID)
+	if err != nil {
+		return "", err
+	}
+	if len(ec) > 0 && !strings.HasPrefix(string(mode), "aws:kms") {
+		return "", fmt.Errorf("an encryption context requires aws:kms encryption, not %s", mode)
+	}
+	return mode, nil
+}
+
+// ParseEncryptionContext parses an SSE-KMS encryption context written as
+// key=value pairs separated by commas, such as "job=photos,host=nas". It
+// returns nil for an empty s.
+func ParseEncryptionContext(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ec := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("encryption context %q: want key=value", pair)
+		}
+		if _, dup := ec[k]; dup {
+			return nil, fmt.Errorf("encryption context key %q given twice", k)
+		}
+		ec[k] = v
+	}
+	return ec, checkEncryptionContext(ec)
+}
+
+// checkEncryptionContext reports whether ec is a valid encryption context:
+// keys must not be empty or use the prefix "aws:", which S3 and KMS
+// reserve for their own pairs.
+func checkEncryptionContext(ec map[string]string) error {
+	for _, k := range slices.Sorted(maps.Keys(ec)) {
+		switch {
+		case k == "":
+			return errors.New("encryption context key must not be empty")
+		case strings.HasPrefix(strings.ToLower(k), "aws:"):
+			return fmt.Errorf("encryption context key %q uses the reserved prefix aws:", k)
+		}
+	}
+	return nil
+}
+
+// sseContextKey is the object metadata key the encryption context of an
+// object is recorded under, as the value of its header.
+const sseContextKey = "sse-context"
+
+// encodeEncryptionContext formats ec for the encryption context header of
+// a request: base64-encoded JSON. It returns nil if ec is empty.
+func encodeEncryptionContext(ec map[string]string) *string {
+	if len(ec) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(ec) // sorted by key
+	return aws.String(base64.StdEncoding.EncodeToString(data))
+}
+
+// decodeEncryptionContext is the inverse of encodeEncryptionContext. It
+// returns nil if s is empty or malformed.
+func decodeEncryptionContext(s string) map[string]string {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if s == "" || err != nil {
+		return nil
+	}
+	var ec map[string]string
+	if json.Unmarshal(data, &ec) != nil || len(ec) == 0 {
+		return nil
 	}
-	return strings.TrimPrefix(full, strings.TrimSuffix(d.prefix, "/")+"/")
+	return ec
 }
 
-func (d *S3Destination) Put(ctx context.Context, rel string, r io.Reader, size int64, modTime time.Time) error {
-	_, err := d.uploader.Upload(ctx, &s3.PutObjectInput{
+// s3Encryption validates an encryption mode and KMS key ID. A key ID
+// without a mode selects aws:kms.
+func s3Encryption(sse, kmsKeyID string) (types.ServerSideEncryption, error) {
+	mode := types.ServerSideEncryption(sse)
+	if mode == "" && kmsKeyID != "" {
+		mode = types.ServerSideEncryptionAwsKms
+	}
+	if mode == "" {
+		return "", nil
+	}
+	if !slices.Contains(mode.Values(), mode) {
+		return "", fmt.Errorf("unknown server-side encryption %q (valid: %v)", sse, mode.Values())
+	}
+	if kmsKeyID != "" && !strings.HasPrefix(string(mode), "aws:kms") {
+		return "", fmt.Errorf("a KMS key ID requires aws:kms encryption, not %s", mode)
+	}
+	return mode, nil
+}
+
+// encryption returns the encryption settings for a copy of the object
+// described by head: the destination's, if it has any, else the source
+// object's own, so that copies stay encrypted with the same key.
+func (d *S3Destination) encryption(head *s3.HeadObjectOutput) (types.ServerSideEncryption, *string) {
+	if d.ServerSideEncryption != "" {
+		return d.ServerSideEncryption, d.kmsKeyID()
+	}
+	return head.ServerSideEncryption, head.SSEKMSKeyId
+}
+
+// encryptionContext returns the encryption context header for a copy of
+// the object described by head, which goes with the settings encryption
+// returns: the destination's context, or else the one recorded for the
+// source object.
+func (d *S3Destination) encryptionContext(head *s3.HeadObjectOutput) *string {
+	if d.ServerSideEncryption != "" {
+		return encodeEncryptionContext(d.SSEKMSEncryptionContext)
+	}
+	return optional(head.Metadata[sseContextKey])
+}
+
+// copyMetadata returns the metadata of a copy of the object described by
+// head, encrypted with the context ec, and whether it differs from the
+// source object's.
+func copyMetadata(head *s3.HeadObjectOutput, ec *string) (map[string]string, bool) {
+	if aws.ToString(ec) == head.Metadata[sseContextKey] {
+		return head.Metadata, false
+	}
+	md := maps.Clone(head.Metadata)
+	if md == nil {
+		md = make(map[string]string)
+	}
+	if ec != nil {
+		md[sseContextKey] = *ec
+	} else {
+		delete(md, sseContextKey)
+	}
+	return md, true
+}
+
+// kmsKeyID returns SSEKMSKeyID for a request, or nil if it is unset.
+func (d *S3Destination) kmsKeyID() *string {
+	return optional(d.SSEKMSKeyID)
+}
+
+// optional returns s for an optional request field: nil if s is empty, so
+// that no empty header is sent.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// RequestPrices implements RequestPricer with the list prices for the
+// destination's storage class in us-east-1. Other regions differ slightly.
+func (d *S3Destination) RequestPrices() RequestPrices {
+	return s3StoragePrice(d.storageClass).Requests
+}
+
+// StoragePrices implements StoragePricer with the list prices of the
+// storage classes in us-east-1. Other regions differ slightly.
+func (d *S3Destination) StoragePrices() []StoragePrice {
+	prices := []StoragePrice{s3StoragePrice(d.storageClass)}
+	for _, p := range s3StoragePrices {
+		if p.Class != prices[0].Class {
+			prices = append(prices, p)
+		}
+	}
+	return prices
+}
+
+// s3StoragePrice returns the prices of class, or those of STANDARD for
+// classes s3StoragePrices leaves out, such as those of Outposts.
+func s3StoragePrice(class types.StorageClass) StoragePrice {
+	for _, p := range s3StoragePrices {
+		if p.Class == string(class) {
+			return p
+		}
+	}
+	p := s3StoragePrices[0]
+	if class != "" {
+		p.Class = string(class)
+	}
+	return p
+}
+
+// s3StoragePrices are the list prices of S3's storage classes for
+// backups, in us-east-1.
+var s3StoragePrices = []StoragePrice{
+	{Class: "STANDARD", GBMonth: 0.023, Requests: RequestPrices{Write: 0.005, Read: 0.0004}},
+	{
+		Class: "INTELLIGENT_TIERING", GBMonth: 0.023, Requests: RequestPrices{Write: 0.005, Read: 0.0004},
+		Note: "objects not read for 30 days cost $0.0125/GB-month, and for 90 days $0.004; " +
+			"monitoring costs $0.0025 a month per 1,000 objects of 128 KiB or more",
+	},
+	{
+		Class: "STANDARD_IA", GBMonth: 0.0125, RetrievalGB: 0.01, Requests: RequestPrices{Write: 0.01, Read: 0.001},
+		MinDays: 30, MinObjectSize: 128 << 10,
+	},
+	{
+		Class: "ONEZONE_IA", GBMonth: 0.01, RetrievalGB: 0.01, Requests: RequestPrices{Write: 0.01, Read: 0.001},
+		MinDays: 30, MinObjectSize: 128 << 10,
+	},
+	{
+		Class: "GLACIER_IR", GBMonth: 0.004, RetrievalGB: 0.03, Requests: RequestPrices{Write: 0.02, Read: 0.01},
+		MinDays: 90, MinObjectSize: 128 << 10,
+	},
+	{
+		Class: "GLACIER", GBMonth: 0.0036, RetrievalGB: 0.01, Requests: RequestPrices{Write: 0.03, Read: 0.0004},
+		MinDays: 90, ObjectOverhead: 40 << 10,
+		Note: "restores take 3-5 hours at the Standard tier priced here, and 5-12 hours at the Bulk tier, free of retrieval fees",
+	},
+	{
+		Class: "DEEP_ARCHIVE", GBMonth: 0.00099, RetrievalGB: 0.02, Requests: RequestPrices{Write: 0.05, Read: 0.0004},
+		MinDays: 180, ObjectOverhead: 40 << 10,
+		Note: "restores take up to 12 hours at the Standard tier priced here, and up to 48 hours at the Bulk tier, $0.0025/GB",
+	},
+}
+
+// uploadPartSize implements partSizer: larger files are uploaded in
+// parts, with a request to start the upload and one to complete it.
+func (d *S3Destination) uploadPartSize() (int64, int) {
+	return d.uploader.PartSize, 2
+}
+
+func (d *S3Destination) fullKey(rel string) string {
+	return joinKey(d.prefix, rel)
+}
+
+func (d *S3Destination) relKey(full string) string {
+	return splitKey(d.prefix, full)
+}
+
+// Bucket returns the name of the destination's bucket.
+func (d *S3Destination) Bucket() string {
+	return d.bucket
+}
+
+func (d *S3Destination) Prefix() string {
+	return strings.Trim(d.prefix, "/")
+}
+
+func (d *S3Destination) WithPrefix(prefix string) Destination {
+	c := *d
+	c.prefix = prefix
+	return &c
+}
+
+func (d *S3Destination) Put(ctx context.Context, rel string, r io.Reader, meta ObjectMeta) error {
+	md := objectMetadata(meta)
+	if d.compat == S3CompatLegacy {
+		md = packMetadata(md, meta)
+	}
+	ec := encodeEncryptionContext(d.SSEKMSEncryptionContext)
+	if ec != nil {
+		md[sseContextKey] = *ec
+	}
+	in := &s3.PutObjectInput{
 		Bucket:       aws.String(d.bucket),
 		Key:          aws.String(d.fullKey(rel)),
 		Body:         r,
-		StorageClass: d.storageClass,
-		Metadata: map[string]string{
-			"mtime": strconv.FormatInt(modTime.Unix(), 10),
-			"size":  strconv.FormatInt(size, 10),
-		},
-	})
+		StorageClass: d.class(meta),
+		Metadata:     md,
+		ContentType:  optional(meta.ContentType),
+		Tagging:      s3Tagging(meta.Tags),
+
+		CacheControl:       optional(meta.CacheControl),
+		ContentDisposition: optional(meta.ContentDisposition),
+		ContentEncoding:    optional(meta.ContentEncoding),
+
+		ServerSideEncryption:    d.ServerSideEncryption,
+		SSEKMSKeyId:             d.kmsKeyID(),
+		SSEKMSEncryptionContext: ec,
+	}
+	if l := meta.Lock; l != nil {
+		// S3 requires a checksum of objects uploaded with a lock.
+		in.ChecksumAlgorithm = types.ChecksumAlgorithmCrc32
+		if l.Mode != "" {
+			in.ObjectLockMode = types.ObjectLockMode(l.Mode)
+			in.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(l.Retain))
+		}
+		if l.LegalHold {
+			in.ObjectLockLegalHoldStatus = types.ObjectLockLegalHoldStatusOn
+		}
+	}
+	if meta.SHA256 != "" && d.compat != S3CompatLegacy {
+		// S3 checks each request against a SHA-256 of the bytes sent,
+		// which the SDK computes as they stream and sends as a trailer.
+		in.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	_, err := d.uploader.Upload(ctx, in)
+	var mu manager.MultiUploadFailure
+	if err != nil && ctx.Err() != nil && !d.leaveParts && errors.As(err, &mu) {
+		// The uploader aborts failed multipart uploads with ctx, which
+		// fails once ctx is canceled, leaving the parts stored and billed.
+		if aerr := d.abortUpload(ctx, rel, mu.UploadID()); aerr != nil {
+			return fmt.Errorf("%w; abort multipart upload %s: %v", err, mu.UploadID(), aerr)
+		}
+	}
+	return err
+}
+
+// ListUploads implements UploadAborter with ListMultipartUploads.
+func (d *S3Destination) ListUploads(ctx context.Context) ([]PendingUpload, error) {
+	in := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(d.bucket),
+		Prefix: aws.String(listPrefix(d.prefix)),
+	}
+	var pending []PendingUpload
+	for {
+		out, err := d.client.ListMultipartUploads(ctx, in, d.clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("list multipart uploads: %w", err)
+		}
+		for _, u := range out.Uploads {
+			pending = append(pending, PendingUpload{
+				Key:     d.relKey(aws.ToString(u.Key)),
+				ID:      aws.ToString(u.UploadId),
+				Started: aws.ToTime(u.Initiated),
+			})
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return pending, nil
+		}
+		in.KeyMarker, in.UploadIdMarker = out.NextKeyMarker, out.NextUploadIdMarker
+	}
+}
+
+// AbortUpload implements UploadAborter.
+func (d *S3Destination) AbortUpload(ctx context.Context, u PendingUpload) error {
+	return d.abortUpload(ctx, u.Key, u.ID)
+}
+
+// abortUpload aborts the multipart upload id of rel, even if ctx has been
+// canceled.
+func (d *S3Destination) abortUpload(ctx context.Context, rel, id string) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	_, err := d.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(d.bucket),
+		Key:      aws.String(d.fullKey(rel)),
+		UploadId: aws.String(id),
+	}, d.clientOpts...)
 	return err
 }
 
@@ -76,52 +903,642 @@ func (d *S3Destination) Stat(ctx context.Context, rel string) (*ObjectMeta, erro
 	out, err := d.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(d.bucket),
 		Key:    aws.String(d.fullKey(rel)),
-	})
+	}, d.clientOpts...)
 	if err != nil {
 		var re *awshttp.ResponseError
 		if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound {
 			return nil, nil
 		}
+		if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: %w", fs.ErrPermission, err)
+		}
 		return nil, err
 	}
 
-	meta := &ObjectMeta{Size: aws.ToInt64(out.ContentLength)}
-	if v, ok := out.Metadata["mtime"]; ok {
-		if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
-			meta.ModTime = time.Unix(ts, 0)
-		}
+	// Change detection relies on our own metadata, and on the ETag only
+	// where it is an MD5 of the content: not for SSE-KMS, SSE-C or
+	// multipart objects. See ETagComparer.
+	meta := parseMetadata(aws.ToInt64(out.ContentLength), unpackMetadata(out.Metadata))
+	if !strings.HasPrefix(string(out.ServerSideEncryption), "aws:kms") && out.SSECustomerAlgorithm == nil {
+		meta.MD5 = etagMD5(aws.ToString(out.ETag))
+	}
+	meta.ContentType = aws.ToString(out.ContentType)
+	meta.EncryptionContext = decodeEncryptionContext(out.Metadata[sseContextKey])
+	meta.Archived = !s3ArchiveStatus(out.StorageClass, aws.ToString(out.Restore)).readable()
+	meta.StorageClass = string(out.StorageClass)
+	if meta.StorageClass == "" {
+		meta.StorageClass = string(types.StorageClassStandard) // S3 leaves it out
 	}
 	return meta, nil
 }
 
-func (d *S3Destination) List(ctx context.Context) ([]string, error) {
-	prefix := d.prefix
-	if prefix != "" {
-		prefix = strings.TrimSuffix(prefix, "/") + "/"
+// class returns the storage class to upload an object described by meta
+// in.
+func (d *S3Destination) class(meta ObjectMeta) types.StorageClass {
+	if meta.StorageClass != "" {
+		return types.StorageClass(meta.StorageClass)
+	}
+	return d.storageClass
+}
+
+func (d *S3Destination) Get(ctx context.Context, rel string) (io.ReadCloser, error) {
+	// The SDK checks the content read against the checksum S3 stored
+	// with the object, if any, failing the read if they differ. Legacy
+	// services may reject the request for one.
+	in := &s3.GetObjectInput{
+		Bucket:       aws.String(d.bucket),
+		Key:          aws.String(d.fullKey(rel)),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}
+	if d.compat == S3CompatLegacy {
+		in.ChecksumMode = ""
+	}
+	out, err := d.client.GetObject(ctx, in, d.clientOpts...)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// GetRange implements RangeGetter. S3 checks the checksums it stores
+// only against whole objects, so the content is not checked as it is
+// read, as it is by Get.
+func (d *S3Destination) GetRange(ctx context.Context, rel string, off, n int64) (io.ReadCloser, error) {
+	out, err := d.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.fullKey(rel)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, off+n-1)),
+	}, d.clientOpts...)
+	if err != nil {
+		var nsk *types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, fmt.Errorf("%s: %w", rel, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// ArchiveStatus reports whether the object at rel is in GLACIER or
+// DEEP_ARCHIVE, which must be restored before it can be read.
+func (d *S3Destination) ArchiveStatus(ctx context.Context, rel string) (ArchiveStatus, error) {
+	out, err := d.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.fullKey(rel)),
+	}, d.clientOpts...)
+	if err != nil {
+		return ArchiveStatus{}, err
 	}
+	return s3ArchiveStatus(out.StorageClass, aws.ToString(out.Restore)), nil
+}
 
+// s3ArchiveStatus interprets an object's storage class and x-amz-restore
+// header, which is either
+//
+//	ongoing-request="true"
+//	ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+func s3ArchiveStatus(sc types.StorageClass, restore string) ArchiveStatus {
+	st := ArchiveStatus{StorageClass: string(sc)}
+	if sc != types.StorageClassGlacier && sc != types.StorageClassDeepArchive {
+		return st
+	}
+	switch {
+	case strings.Contains(restore, `ongoing-request="true"`):
+		st.State = Restoring
+	case strings.Contains(restore, `ongoing-request="false"`):
+		st.State = Restored
+		if _, date, ok := strings.Cut(restore, `expiry-date="`); ok {
+			date, _, _ = strings.Cut(date, `"`)
+			st.Expiry, _ = http.ParseTime(date)
+		}
+	default:
+		st.State = Archived
+	}
+	return st
+}
+
+// RequestRestore starts restoring a temporary copy of the archived object
+// at rel. DEEP_ARCHIVE objects cannot be restored at the Expedited tier.
+func (d *S3Destination) RequestRestore(ctx context.Context, rel string, tier RestoreTier, days int) error {
+	_, err := d.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.fullKey(rel)),
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 aws.Int32(int32(days)),
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(tier)},
+		},
+	}, d.clientOpts...)
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return err
+}
+
+// maxCopySize is the largest object CopyObject can copy; larger objects are
+// copied in parts of copyPartSize.
+const (
+	maxCopySize  = 5 << 30
+	copyPartSize = 512 << 20
+)
+
+// Copy copies an object within the bucket, server-side. Objects over 5 GB
+// are copied with a multipart upload, which does not copy their tags. The
+// copy is encrypted as set on d, or else as the original is.
+func (d *S3Destination) Copy(ctx context.Context, src, dst string) error {
+	return d.copyFrom(ctx, d, src, dst, d.storageClass)
+}
+
+// SetStorageClass implements Reclassifier by copying the object at rel
+// onto itself in class. Archived objects must be restored first.
+func (d *S3Destination) SetStorageClass(ctx context.Context, rel, class string) error {
+	return d.copyFrom(ctx, d, rel, rel, types.StorageClass(class))
+}
+
+// CopyFrom implements CrossCopier for S3 sources, copying server-side as
+// Copy does. The bucket of from must be readable with d's credentials.
+func (d *S3Destination) CopyFrom(ctx context.Context, from Destination, src, dst string) error {
+	s, ok := from.(*S3Destination)
+	if !ok {
+		return fmt.Errorf("copy %s: %w", src, errors.ErrUnsupported)
+	}
+	return d.copyFrom(ctx, s, src, dst, d.storageClass)
+}
+
+// copyFrom copies src in s to dst in d, in storage class class.
+func (d *S3Destination) copyFrom(ctx context.Context, s *S3Destination, src, dst string, class types.StorageClass) error {
+	return d.copyVersion(ctx, s, src, "", dst, class)
+}
+
+// copyVersion copies version of src in s, or its current version if
+// version is empty, to dst in d, in storage class class.
+func (d *S3Destination) copyVersion(ctx context.Context, s *S3Destination, src, version, dst string, class types.StorageClass) error {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(s.bucket),
+		Key:       aws.String(s.fullKey(src)),
+		VersionId: optional(version),
+	}, s.clientOpts...)
+	if err != nil {
+		var re *awshttp.ResponseError
+		if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound {
+			return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+		}
+		return err
+	}
+	source := copySource(s.bucket, s.fullKey(src))
+	if version != "" {
+		source += "?versionId=" + url.QueryEscape(version)
+	}
+	size := aws.ToInt64(head.ContentLength)
+	if size <= maxCopySize {
+		sse, keyID := d.encryption(head)
+		ec := d.encryptionContext(head)
+		in := &s3.CopyObjectInput{
+			Bucket:       aws.String(d.bucket),
+			Key:          aws.String(d.fullKey(dst)),
+			CopySource:   aws.String(source),
+			StorageClass: class,
+
+			ServerSideEncryption:    sse,
+			SSEKMSKeyId:             keyID,
+			SSEKMSEncryptionContext: ec,
+		}
+		if md, changed := copyMetadata(head, ec); changed {
+			// The copy's metadata must record its own context.
+			in.MetadataDirective = types.MetadataDirectiveReplace
+			in.Metadata, in.ContentType = md, head.ContentType
+			in.CacheControl, in.ContentDisposition, in.ContentEncoding = head.CacheControl, head.ContentDisposition, head.ContentEncoding
+		}
+		_, err := d.client.CopyObject(ctx, in, d.clientOpts...)
+		return err
+	}
+	return d.copyMultipart(ctx, source, dst, size, head, class)
+}
+
+// CopyWithMeta implements MetaCopier with CopyObject, replacing the
+// metadata and tags of the copy with those Put would give it. Objects over
+// 5 GB, which cannot be copied in one request, fail with
+// errors.ErrUnsupported.
+func (d *S3Destination) CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error {
+	if meta.Size > maxCopySize {
+		return fmt.Errorf("copy %s: %w", src, errors.ErrUnsupported)
+	}
+	md := objectMetadata(meta)
+	if d.compat == S3CompatLegacy {
+		md = packMetadata(md, meta)
+	}
+	ec := encodeEncryptionContext(d.SSEKMSEncryptionContext)
+	if ec != nil {
+		md[sseContextKey] = *ec
+	}
+	in := &s3.CopyObjectInput{
+		Bucket:            aws.String(d.bucket),
+		Key:               aws.String(d.fullKey(dst)),
+		CopySource:        aws.String(copySource(d.bucket, d.fullKey(src))),
+		StorageClass:      d.class(meta),
+		MetadataDirective: types.MetadataDirectiveReplace,
+		Metadata:          md,
+		ContentType:       optional(meta.ContentType),
+		TaggingDirective:  types.TaggingDirectiveReplace,
+		Tagging:           s3Tagging(meta.Tags),
+
+		CacheControl:       optional(meta.CacheControl),
+		ContentDisposition: optional(meta.ContentDisposition),
+		ContentEncoding:    optional(meta.ContentEncoding),
+
+		ServerSideEncryption:    d.ServerSideEncryption,
+		SSEKMSKeyId:             d.kmsKeyID(),
+		SSEKMSEncryptionContext: ec,
+	}
+	if meta.SHA256 != "" && d.compat != S3CompatLegacy {
+		in.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	}
+	_, err := d.client.CopyObject(ctx, in, d.clientOpts...)
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	}
+	return err
+}
+
+func (d *S3Destination) copyMultipart(ctx context.Context, source, dst string, size int64, head *s3.HeadObjectOutput, class types.StorageClass) error {
+	sse, keyID := d.encryption(head)
+	ec := d.encryptionContext(head)
+	md, _ := copyMetadata(head, ec)
+	upload, err := d.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(d.bucket),
+		Key:          aws.String(d.fullKey(dst)),
+		StorageClass: class,
+		ContentType:  head.ContentType,
+		Metadata:     md,
+
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+
+		ServerSideEncryption:    sse,
+		SSEKMSKeyId:             keyID,
+		SSEKMSEncryptionContext: ec,
+	}, d.clientOpts...)
+	if err != nil {
+		return err
+	}
+
+	var parts []types.CompletedPart
+	for start := int64(0); start < size; start += copyPartSize {
+		end := min(start+copyPartSize, size) - 1
+		n := int32(len(parts) + 1)
+		out, err := d.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(d.bucket),
+			Key:             upload.Key,
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int32(n),
+			CopySource:      aws.String(source),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		}, d.clientOpts...)
+		if err != nil {
+			d.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(d.bucket),
+				Key:      upload.Key,
+				UploadId: upload.UploadId,
+			}, d.clientOpts...)
+			return err
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int32(n)})
+	}
+
+	_, err = d.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(d.bucket),
+		Key:             upload.Key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}, d.clientOpts...)
+	return err
+}
+
+// maxS3Parts is the most parts a multipart upload may have.
+const maxS3Parts = 10000
+
+// Append implements Appender with a multipart upload whose first parts are
+// copied from the object at rel with UploadPartCopy, and whose last are
+// read from tail, in parts of the upload part size. Every part but the
+// last must be at least 5 MiB, so objects smaller than that fail with
+// errors.ErrUnsupported, as do those over 2.5 TB, which would leave too few
+// of the 10,000 parts for the tail, and uploads with a lock, which needs a
+// checksum of every part. So does an object that is no longer size bytes
+// long; the copies fail if it is replaced while they run.
+func (d *S3Destination) Append(ctx context.Context, rel string, size int64, tail io.Reader, meta ObjectMeta) error {
+	if size < minS3PartSize || size > maxS3Parts*copyPartSize/2 || meta.Lock != nil {
+		return fmt.Errorf("append to %s: %w", rel, errors.ErrUnsupported)
+	}
+	head, err := d.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.fullKey(rel)),
+	}, d.clientOpts...)
+	if err != nil {
+		return err
+	}
+	if aws.ToInt64(head.ContentLength) != size {
+		return fmt.Errorf("append to %s: object changed: %w", rel, errors.ErrUnsupported)
+	}
+
+	md := objectMetadata(meta)
+	if d.compat == S3CompatLegacy {
+		md = packMetadata(md, meta)
+	}
+	ec := encodeEncryptionContext(d.SSEKMSEncryptionContext)
+	if ec != nil {
+		md[sseContextKey] = *ec
+	}
+	upload, err := d.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(d.bucket),
+		Key:          aws.String(d.fullKey(rel)),
+		StorageClass: d.class(meta),
+		Metadata:     md,
+		ContentType:  optional(meta.ContentType),
+		Tagging:      s3Tagging(meta.Tags),
+
+		CacheControl:       optional(meta.CacheControl),
+		ContentDisposition: optional(meta.ContentDisposition),
+		ContentEncoding:    optional(meta.ContentEncoding),
+
+		ServerSideEncryption:    d.ServerSideEncryption,
+		SSEKMSKeyId:             d.kmsKeyID(),
+		SSEKMSEncryptionContext: ec,
+	}, d.clientOpts...)
+	if err != nil {
+		return err
+	}
+	parts, err := d.appendParts(ctx, rel, upload.UploadId, size, head.ETag, tail, meta.Size-size)
+	if err != nil {
+		if aerr := d.abortUpload(ctx, rel, aws.ToString(upload.UploadId)); aerr != nil {
+			return fmt.Errorf("%w; abort multipart upload %s: %v", err, aws.ToString(upload.UploadId), aerr)
+		}
+		return err
+	}
+	_, err = d.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(d.bucket),
+		Key:             upload.Key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}, d.clientOpts...)
+	return err
+}
+
+// appendParts uploads the parts of multipart upload id of rel for Append:
+// the first size bytes of the object, whose ETag must still be etag, split
+// evenly into parts of no more than copyPartSize, and then the n bytes of
+// tail.
+func (d *S3Destination) appendParts(ctx context.Context, rel string, id *string, size int64, etag *string, tail io.Reader, n int64) ([]types.CompletedPart, error) {
+	var parts []types.CompletedPart
+	copies := (size + copyPartSize - 1) / copyPartSize
+	partSize := d.uploader.PartSize
+	if free := maxS3Parts - copies; n > partSize*free {
+		partSize = (n + free - 1) / free
+	}
+	source := copySource(d.bucket, d.fullKey(rel))
+	for i := range copies {
+		start, end := size*i/copies, size*(i+1)/copies-1
+		out, err := d.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:            aws.String(d.bucket),
+			Key:               aws.String(d.fullKey(rel)),
+			UploadId:          id,
+			PartNumber:        aws.Int32(int32(len(parts) + 1)),
+			CopySource:        aws.String(source),
+			CopySourceIfMatch: etag,
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		}, d.clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int32(int32(len(parts) + 1))})
+	}
+	buf := make([]byte, min(partSize, n))
+	for sent := int64(0); sent < n; {
+		m, err := io.ReadFull(tail, buf[:min(partSize, n-sent)])
+		if err != nil {
+			return nil, err
+		}
+		out, err := d.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(d.bucket),
+			Key:           aws.String(d.fullKey(rel)),
+			UploadId:      id,
+			PartNumber:    aws.Int32(int32(len(parts) + 1)),
+			Body:          bytes.NewReader(buf[:m]),
+			ContentLength: aws.Int64(int64(m)),
+		}, d.clientOpts...)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(int32(len(parts) + 1))})
+		sent += int64(m)
+	}
+	return parts, nil
+}
+
+// copySource formats the CopySource of a copy request: the URL-encoded
+// bucket and key.
+func copySource(bucket, key string) string {
+	segments := strings.Split(bucket+"/"+key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// List implements Destination a page of up to 1000 keys at a time, which
+// S3 returns in byte order.
+func (d *S3Destination) List(ctx context.Context, fn func(keys []string) error) error {
+	return d.listPages(ctx, func(objs []types.Object) error {
+		keys := make([]string, len(objs))
+		for i, obj := range objs {
+			keys[i] = d.relKey(aws.ToString(obj.Key))
+		}
+		return fn(keys)
+	})
+}
+
+// ListWritten implements WrittenLister using each object's LastModified
+// time, which S3 lifecycle expiration is also based on.
+func (d *S3Destination) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	written := make(map[string]time.Time)
+	err := d.listObjects(ctx, func(obj types.Object) {
+		written[d.relKey(aws.ToString(obj.Key))] = aws.ToTime(obj.LastModified)
+	})
+	return written, err
+}
+
+// ListObjects implements ObjectLister from the same listing as List.
+func (d *S3Destination) ListObjects(ctx context.Context) (map[string]ListedObject, error) {
+	objects := make(map[string]ListedObject)
+	err := d.listObjects(ctx, func(obj types.Object) {
+		objects[d.relKey(aws.ToString(obj.Key))] = ListedObject{
+			Size:    aws.ToInt64(obj.Size),
+			Written: aws.ToTime(obj.LastModified),
+		}
+	})
+	return objects, err
+}
+
+// listObjects calls fn for every object under the destination's prefix.
+func (d *S3Destination) listObjects(ctx context.Context, fn func(types.Object)) error {
+	return d.listPages(ctx, func(objs []types.Object) error {
+		for _, obj := range objs {
+			fn(obj)
+		}
+		return nil
+	})
+}
+
+// listPages calls fn with each page of the objects under the destination's
+// prefix, stopping at the first error it returns. The prefixes under it
+// are listed in parallel; see WithS3ListConcurrency.
+func (d *S3Destination) listPages(ctx context.Context, fn func([]types.Object) error) error {
+	concurrency := d.listConcurrency
+	if concurrency == 0 {
+		concurrency = defaultS3ListConcurrency
+	}
+	return listSharded(ctx, d, listPrefix(d.prefix), concurrency, fn)
+}
+
+// defaultS3ListConcurrency is how many prefixes listPages lists at once
+// unless WithS3ListConcurrency says otherwise.
+const defaultS3ListConcurrency = 8
+
+func (d *S3Destination) listLevel(ctx context.Context, prefix string) ([]types.Object, []string, bool, error) {
+	page, err := d.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(d.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, d.clientOpts...)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("list objects: %w", err)
+	}
+	prefixes := make([]string, len(page.CommonPrefixes))
+	for i, p := range page.CommonPrefixes {
+		prefixes[i] = aws.ToString(p.Prefix)
+	}
+	return page.Contents, prefixes, aws.ToBool(page.IsTruncated), nil
+}
+
+func (d *S3Destination) listUnder(ctx context.Context, prefix string, fn func([]types.Object) error) error {
 	paginator := s3.NewListObjectsV2Paginator(d.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(d.bucket),
 		Prefix: aws.String(prefix),
 	})
-
-	var keys []string
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
+		page, err := paginator.NextPage(ctx, d.clientOpts...)
 		if err != nil {
-			return nil, fmt.Errorf("list objects: %w", err)
+			return fmt.Errorf("list objects: %w", err)
 		}
-		for _, obj := range page.Contents {
-			keys = append(keys, d.relKey(aws.ToString(obj.Key)))
+		if err := fn(page.Contents); err != nil {
+			return err
 		}
 	}
-	return keys, nil
+	return nil
+}
+
+func (d *S3Destination) objectKey(obj types.Object) string {
+	return aws.ToString(obj.Key)
 }
 
 func (d *S3Destination) Delete(ctx context.Context, rel string) error {
 	_, err := d.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(d.bucket),
 		Key:    aws.String(d.fullKey(rel)),
-	})
+	}, d.clientOpts...)
+	var ae smithy.APIError
+	if errors.As(err, &ae) && isLockError(ae.ErrorCode(), ae.ErrorMessage()) {
+		return d.lockedError(ctx, rel, err)
+	}
 	return err
 }
+
+// CheckObjectLock implements Locker, failing unless the bucket has Object
+// Lock enabled.
+func (d *S3Destination) CheckObjectLock(ctx context.Context) error {
+	out, err := d.client.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(d.bucket),
+	}, d.clientOpts...)
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if out == nil || out.ObjectLockConfiguration == nil || out.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("bucket %s does not have Object Lock enabled", d.bucket)
+	}
+	return nil
+}
+
+// isLockError reports whether the error code and message of a failed
+// delete say that the object is locked. S3 denies access, mentioning the
+// lock; some compatible services have a code of their own.
+func isLockError(code, msg string) bool {
+	return code == "ObjectLocked" ||
+		code == "AccessDenied" && strings.Contains(strings.ToLower(msg), "object lock")
+}
+
+// lockedError returns a LockedError for rel, which failed to delete with
+// err, describing its lock as the object reports it.
+func (d *S3Destination) lockedError(ctx context.Context, rel string, err error) error {
+	e := &LockedError{Key: rel, Err: err}
+	head, herr := d.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(d.fullKey(rel)),
+	}, d.clientOpts...)
+	if herr == nil {
+		e.Mode = LockMode(head.ObjectLockMode)
+		e.Until = aws.ToTime(head.ObjectLockRetainUntilDate)
+		e.LegalHold = head.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn
+	}
+	return e
+}
+
+// maxDeleteObjects is the most keys a DeleteObjects request may name.
+const maxDeleteObjects = 1000
+
+// DeleteBatch implements BatchDeleter with DeleteObjects, naming up to 1000
+// keys per request.
+func (d *S3Destination) DeleteBatch(ctx context.Context, rels []string) ([]string, error) {
+	var deleted []string
+	for chunk := range slices.Chunk(rels, maxDeleteObjects) {
+		ids := make([]types.ObjectIdentifier, len(chunk))
+		for i, rel := range chunk {
+			ids[i] = types.ObjectIdentifier{Key: aws.String(d.fullKey(rel))}
+		}
+		out, err := d.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(d.bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		}, d.clientOpts...)
+		if err != nil {
+			return deleted, fmt.Errorf("delete objects: %w", err)
+		}
+		// In quiet mode, only the keys that failed are reported.
+		failed := make(map[string]bool, len(out.Errors))
+		for _, e := range out.Errors {
+			failed[d.relKey(aws.ToString(e.Key))] = true
+		}
+		for _, rel := range chunk {
+			if !failed[rel] {
+				deleted = append(deleted, rel)
+			}
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			rel := d.relKey(aws.ToString(e.Key))
+			err := fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+			if isLockError(aws.ToString(e.Code), aws.ToString(e.Message)) {
+				err = d.lockedError(ctx, rel, err)
+			}
+			return deleted, fmt.Errorf("delete %s: %w (and %d more)", rel, err, len(out.Errors)-1)
+		}
+	}
+	return deleted, nil
+}
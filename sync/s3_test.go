@@ -1,7 +1,31 @@
 package sync
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	stdsync "sync"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 func TestS3Destination_fullKey(t *testing.T) {
@@ -65,3 +89,691 @@ func TestS3Destination_keyRoundTrip(t *testing.T) {
 		}
 	}
 }
+
+func TestCopySource(t *testing.T) {
+	tests := []struct {
+		bucket, key string
+		want        string
+	}{
+		{"b", "a/b.txt", "b/a/b.txt"},
+		{"b", "photos/summer 2024/a+b.jpg", "b/photos/summer%202024/a+b.jpg"},
+		{"b", "q?x=1#y", "b/q%3Fx=1%23y"},
+	}
+	for _, tt := range tests {
+		if got := copySource(tt.bucket, tt.key); got != tt.want {
+			t.Errorf("copySource(%q, %q) = %q, want %q", tt.bucket, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestS3Encryption(t *testing.T) {
+	tests := []struct {
+		sse, keyID string
+		want       types.ServerSideEncryption
+		wantErr    bool
+	}{
+		{"", "", "", false},
+		{"AES256", "", types.ServerSideEncryptionAes256, false},
+		{"aws:kms", "", types.ServerSideEncryptionAwsKms, false},
+		{"", "alias/backup", types.ServerSideEncryptionAwsKms, false},
+		{"aws:kms:dsse", "alias/backup", types.ServerSideEncryptionAwsKmsDsse, false},
+		{"AES256", "alias/backup", "", true},
+		{"kms", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := s3Encryption(tt.sse, tt.keyID)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("s3Encryption(%q, %q) = %q, %v; want %q, error %v", tt.sse, tt.keyID, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestS3Destination_copyEncryption(t *testing.T) {
+	head := &s3.HeadObjectOutput{
+		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          aws.String("arn:aws:kms:us-east-1:111122223333:key/old"),
+	}
+
+	sse, keyID := (&S3Destination{}).encryption(head)
+	if sse != head.ServerSideEncryption || aws.ToString(keyID) != aws.ToString(head.SSEKMSKeyId) {
+		t.Errorf("unset: got %q %q, want the source object's encryption", sse, aws.ToString(keyID))
+	}
+
+	d := &S3Destination{ServerSideEncryption: types.ServerSideEncryptionAes256}
+	if sse, keyID := d.encryption(head); sse != types.ServerSideEncryptionAes256 || keyID != nil {
+		t.Errorf("AES256: got %q %v, want AES256 without a key", sse, keyID)
+	}
+}
+
+func TestS3Destination_encryptionContext(t *testing.T) {
+	ec := map[string]string{"job": "photos", "host": "nas"}
+	const header = "eyJob3N0IjoibmFzIiwiam9iIjoicGhvdG9zIn0=" // {"host":"nas","job":"photos"}
+	var put *s3.PutObjectInput
+	var copies []*s3.CopyObjectInput
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				var out any
+				switch p := in.Parameters.(type) {
+				case *s3.PutObjectInput:
+					put = p
+					out = &s3.PutObjectOutput{}
+				case *s3.HeadObjectInput:
+					out = &s3.HeadObjectOutput{ContentLength: aws.Int64(1), Metadata: put.Metadata}
+				case *s3.CopyObjectInput:
+					copies = append(copies, p)
+					out = &s3.CopyObjectOutput{}
+				default:
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", p)
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "",
+		WithS3Middleware(fake),
+		WithS3Encryption(types.ServerSideEncryptionAwsKms, ""),
+		WithS3EncryptionContext(ec))
+
+	if err := d.Put(context.Background(), "a.txt", strings.NewReader("a"), ObjectMeta{Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.ToString(put.SSEKMSEncryptionContext); got != header {
+		t.Errorf("encryption context header = %q, want %q", got, header)
+	}
+	meta, err := d.Stat(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(meta.EncryptionContext, ec) {
+		t.Errorf("Stat: encryption context = %v, want %v", meta.EncryptionContext, ec)
+	}
+
+	// Without settings of its own, a copy keeps the original's context;
+	// with others, its metadata records the new one.
+	plain := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+	if err := plain.Copy(context.Background(), "a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	aes := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake), WithS3Encryption(types.ServerSideEncryptionAes256, ""))
+	if err := aes.Copy(context.Background(), "a.txt", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if c := copies[0]; aws.ToString(c.SSEKMSEncryptionContext) != header || c.MetadataDirective != "" {
+		t.Errorf("copy: context %q, directive %q; want the original's", aws.ToString(c.SSEKMSEncryptionContext), c.MetadataDirective)
+	}
+	if c := copies[1]; c.SSEKMSEncryptionContext != nil || c.MetadataDirective != types.MetadataDirectiveReplace || c.Metadata[sseContextKey] != "" || c.Metadata["size"] != "1" {
+		t.Errorf("AES256 copy: context %v, directive %q, metadata %v", c.SSEKMSEncryptionContext, c.MetadataDirective, c.Metadata)
+	}
+}
+
+func TestS3Destination_legacyCompat(t *testing.T) {
+	var put *s3.PutObjectInput
+	var get *s3.GetObjectInput
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				var out any
+				switch p := in.Parameters.(type) {
+				case *s3.PutObjectInput:
+					put = p
+					out = &s3.PutObjectOutput{}
+				case *s3.HeadObjectInput:
+					out = &s3.HeadObjectOutput{ContentLength: aws.Int64(1), Metadata: put.Metadata}
+				case *s3.GetObjectInput:
+					get = p
+					out = &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("a"))}
+				default:
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", p)
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake), WithS3Compat(S3CompatLegacy))
+
+	want := ObjectMeta{
+		Size:         1,
+		ModTime:      time.Unix(1700000000, 0),
+		SHA256:       "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+		POSIX:        &POSIXAttrs{Mode: 0640, UID: 1000, GID: 100},
+		UserMetadata: map[string]string{"team": "ops"},
+	}
+	if err := d.Put(context.Background(), "a.txt", strings.NewReader("a"), want); err != nil {
+		t.Fatal(err)
+	}
+	if put.ChecksumAlgorithm != "" {
+		t.Errorf("checksum algorithm = %q, want none", put.ChecksumAlgorithm)
+	}
+	if keys := slices.Sorted(maps.Keys(put.Metadata)); !slices.Equal(keys, []string{"foldersync", "team"}) {
+		t.Errorf("metadata keys = %v, want foldersync's packed into one", keys)
+	}
+	meta, err := d.Stat(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.ModTime.Equal(want.ModTime) || meta.SHA256 != want.SHA256 || meta.POSIX == nil || meta.POSIX.Mode != want.POSIX.Mode || meta.POSIX.UID != 1000 {
+		t.Errorf("Stat = %+v, want %+v", meta, want)
+	}
+	rc, err := d.Get(context.Background(), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+	if get.ChecksumMode != "" {
+		t.Errorf("checksum mode = %q, want none", get.ChecksumMode)
+	}
+
+	if _, err := Open(context.Background(), "s3://bucket?compat=wasabi"); err == nil {
+		t.Error("opened s3://bucket?compat=wasabi")
+	}
+}
+
+func TestParseEncryptionContext(t *testing.T) {
+	ec, err := ParseEncryptionContext("job=photos,host=nas.example.com,empty=")
+	if want := map[string]string{"job": "photos", "host": "nas.example.com", "empty": ""}; err != nil || !maps.Equal(ec, want) {
+		t.Errorf("got %v, %v; want %v", ec, err, want)
+	}
+	for _, s := range []string{"job", "=x", "job=a,job=b", "aws:s3:arn=x"} {
+		if _, err := ParseEncryptionContext(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	if _, err := s3EncryptionWithContext("AES256", "", map[string]string{"job": "photos"}); err == nil {
+		t.Error("AES256 with a context: no error")
+	}
+	if mode, err := s3EncryptionWithContext("", "", map[string]string{"job": "photos"}); err != nil || mode != types.ServerSideEncryptionAwsKms {
+		t.Errorf("context alone: got %q, %v; want aws:kms", mode, err)
+	}
+}
+
+func TestNewS3DestinationFromConfig_options(t *testing.T) {
+	stop := errors.New("stopped before sending")
+	var requests []*smithyhttp.Request
+	capture := func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("capture",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				requests = append(requests, in.Request.(*smithyhttp.Request))
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, stop
+			}), middleware.After)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "backups",
+		WithS3AppID("myapp"),
+		WithS3Retryer(aws.NopRetryer{}),
+		WithS3Middleware(capture),
+		WithS3ClientOptions(func(o *s3.Options) { o.UsePathStyle = true }))
+
+	if d.storageClass != types.StorageClassGlacierIr {
+		t.Errorf("storage class = %q, want GLACIER_IR", d.storageClass)
+	}
+	if err := d.Delete(context.Background(), "a.txt"); !errors.Is(err, stop) {
+		t.Fatalf("Delete: %v, want the middleware's error", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("made %d requests, want 1 without retries", len(requests))
+	}
+	req := requests[0]
+	if req.URL.Path != "/bucket/backups/a.txt" {
+		t.Errorf("path = %q, want a path-style URL", req.URL.Path)
+	}
+	if ua := req.Header.Get("User-Agent"); !strings.Contains(ua, "app/myapp") {
+		t.Errorf("User-Agent %q does not name the app", ua)
+	}
+}
+
+func TestS3Destination_accelerateRequestPayer(t *testing.T) {
+	stop := errors.New("stopped before sending")
+	var requests []*smithyhttp.Request
+	capture := func(stack *middleware.Stack) error {
+		return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("capture",
+			func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+				requests = append(requests, in.Request.(*smithyhttp.Request))
+				return middleware.FinalizeOutput{}, middleware.Metadata{}, stop
+			}), middleware.After)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Retryer(aws.NopRetryer{}),
+		WithS3Accelerate(), WithS3RequestPayer(), WithS3Middleware(capture))
+	if _, err := d.Get(context.Background(), "a.txt"); !errors.Is(err, stop) {
+		t.Fatalf("Get: %v, want the middleware's error", err)
+	}
+	if len(requests) != 1 {
+		t.Fatalf("made %d requests, want 1", len(requests))
+	}
+	if host := requests[0].URL.Host; host != "bucket.s3-accelerate.amazonaws.com" {
+		t.Errorf("host = %q, want the acceleration endpoint", host)
+	}
+	if payer := requests[0].Header.Get("X-Amz-Request-Payer"); payer != "requester" {
+		t.Errorf("x-amz-request-payer = %q, want requester", payer)
+	}
+
+	for _, bad := range []string{
+		"s3://bucket?accelerate=true&path-style=true",
+		"s3://bucket?accelerate=true&endpoint-url=https://minio.lan:9000",
+		"s3://my.bucket?accelerate=true",
+		"s3://bucket?request-payer=owner",
+	} {
+		if _, err := Open(context.Background(), bad); err == nil {
+			t.Errorf("opened %s", bad)
+		}
+	}
+}
+
+func TestOpenS3URL_endpoint(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minio123")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	var paths []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // the failed handshakes below
+	defer srv.Close()
+	ctx := context.Background()
+
+	raw := "s3://bucket/backups?path-style=true&endpoint-url=" + url.QueryEscape(srv.URL)
+	d, err := Open(ctx, raw+"&tls-skip-verify=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc := d.(*S3Destination).storageClass; sc != types.StorageClassStandard {
+		t.Errorf("storage class = %q, want STANDARD for another service", sc)
+	}
+	if meta, err := d.Stat(ctx, "a.txt"); meta != nil || err != nil {
+		t.Fatalf("Stat = %v, %v; want the object absent", meta, err)
+	}
+	if len(paths) != 1 || paths[0] != "/bucket/backups/a.txt" {
+		t.Errorf("requested %v, want a path-style request to the endpoint", paths)
+	}
+
+	// The server's certificate is self-signed.
+	d, err = Open(ctx, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, "a.txt"); err == nil {
+		t.Error("Stat trusted a self-signed certificate without tls-skip-verify")
+	}
+	for _, bad := range []string{"endpoint-url=minio:9000", "path-style=yes"} {
+		if _, err := Open(ctx, "s3://bucket?"+bad); err == nil {
+			t.Errorf("opened s3://bucket?%s", bad)
+		}
+	}
+}
+
+func TestS3Destination_Put_abortsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu stdsync.Mutex
+	var aborted []string
+	// Answer each request without sending it, canceling ctx once the
+	// parts are being uploaded. Aborts only succeed with a live context.
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				var out any
+				switch p := in.Parameters.(type) {
+				case *s3.CreateMultipartUploadInput:
+					out = &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}
+				case *s3.UploadPartInput:
+					cancel()
+					return middleware.InitializeOutput{}, middleware.Metadata{}, ctx.Err()
+				case *s3.AbortMultipartUploadInput:
+					if err := ctx.Err(); err != nil {
+						return middleware.InitializeOutput{}, middleware.Metadata{}, err
+					}
+					mu.Lock()
+					aborted = append(aborted, aws.ToString(p.UploadId))
+					mu.Unlock()
+					out = &s3.AbortMultipartUploadOutput{}
+				default:
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", p)
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+
+	body := bytes.NewReader(make([]byte, 6<<20)) // over the 5 MB part size
+	if err := d.Put(ctx, "big.bin", body, ObjectMeta{Size: int64(body.Len())}); err == nil {
+		t.Fatal("Put succeeded after cancellation")
+	}
+	if len(aborted) != 1 || aborted[0] != "upload-1" {
+		t.Errorf("aborted = %v, want [upload-1]", aborted)
+	}
+}
+
+func TestS3Destination_partSize(t *testing.T) {
+	var requests []string
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				var out any
+				switch p := in.Parameters.(type) {
+				case *s3.PutObjectInput:
+					out = &s3.PutObjectOutput{}
+				case *s3.CreateMultipartUploadInput:
+					out = &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}
+				default:
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", p)
+				}
+				requests = append(requests, fmt.Sprintf("%T", in.Parameters))
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake), WithS3PartSize(8<<20), WithS3UploadConcurrency(2))
+
+	// Under the part size, a file is uploaded whole.
+	body := bytes.NewReader(make([]byte, 6<<20))
+	if err := d.Put(context.Background(), "big.bin", body, ObjectMeta{Size: int64(body.Len())}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(requests, []string{"*s3.PutObjectInput"}) {
+		t.Errorf("requests = %v, want a single PutObject", requests)
+	}
+
+	for _, size := range []int64{4 << 20, 6 << 30} {
+		if err := CheckS3PartSize(size); err == nil {
+			t.Errorf("CheckS3PartSize(%d) succeeded", size)
+		}
+	}
+	if _, err := Open(context.Background(), "s3://bucket?region=eu-west-1&part-size-mb=1"); err == nil {
+		t.Error("Open accepted a 1 MiB part size")
+	}
+}
+
+func TestS3Destination_Put_tags(t *testing.T) {
+	var tagging []string
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				p, ok := in.Parameters.(*s3.PutObjectInput)
+				if !ok {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", in.Parameters)
+				}
+				tagging = append(tagging, aws.ToString(p.Tagging))
+				return middleware.InitializeOutput{Result: &s3.PutObjectOutput{}}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+
+	tags := map[string]string{"backup": "foldersync", "project": "photos & video"}
+	if err := d.Put(context.Background(), "a.txt", strings.NewReader("a"), ObjectMeta{Size: 1, Tags: tags}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(context.Background(), "b.txt", strings.NewReader("b"), ObjectMeta{Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"backup=foldersync&project=photos+%26+video", ""}
+	if !slices.Equal(tagging, want) {
+		t.Errorf("Tagging = %q, want %q", tagging, want)
+	}
+}
+
+func TestS3Destination_Put_checksum(t *testing.T) {
+	var algorithms []types.ChecksumAlgorithm
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				p, ok := in.Parameters.(*s3.PutObjectInput)
+				if !ok {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", in.Parameters)
+				}
+				algorithms = append(algorithms, p.ChecksumAlgorithm)
+				return middleware.InitializeOutput{Result: &s3.PutObjectOutput{}}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+
+	sum := "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb" // of "a"
+	if err := d.Put(context.Background(), "a.txt", strings.NewReader("a"), ObjectMeta{Size: 1, SHA256: sum}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(context.Background(), "b.txt", strings.NewReader("b"), ObjectMeta{Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	want := []types.ChecksumAlgorithm{types.ChecksumAlgorithmSha256, ""}
+	if !slices.Equal(algorithms, want) {
+		t.Errorf("ChecksumAlgorithm = %q, want %q", algorithms, want)
+	}
+}
+
+func TestS3Destination_DeleteBatch(t *testing.T) {
+	var requests [][]string
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				p, ok := in.Parameters.(*s3.DeleteObjectsInput)
+				if !ok {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", in.Parameters)
+				}
+				var keys []string
+				for _, id := range p.Delete.Objects {
+					keys = append(keys, aws.ToString(id.Key))
+				}
+				requests = append(requests, keys)
+				out := &s3.DeleteObjectsOutput{}
+				if slices.Contains(keys, "backups/locked.txt") {
+					out.Errors = []types.Error{{Key: aws.String("backups/locked.txt"), Code: aws.String("AccessDenied"), Message: aws.String("Access Denied")}}
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "backups", WithS3Middleware(fake))
+
+	keys := make([]string, 1500)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%04d.txt", i)
+	}
+	deleted, err := d.DeleteBatch(context.Background(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || len(requests[0]) != 1000 || len(requests[1]) != 500 || requests[0][0] != "backups/0000.txt" {
+		t.Errorf("made %d requests, want 1000 and then 500 prefixed keys", len(requests))
+	}
+	if !slices.Equal(deleted, keys) {
+		t.Errorf("deleted %d keys, want all %d", len(deleted), len(keys))
+	}
+
+	deleted, err = d.DeleteBatch(context.Background(), []string{"a.txt", "locked.txt", "b.txt"})
+	if err == nil || !strings.Contains(err.Error(), "locked.txt: AccessDenied") {
+		t.Errorf("err = %v, want the failed key", err)
+	}
+	if want := []string{"a.txt", "b.txt"}; !slices.Equal(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+}
+
+func TestS3Destination_ListVersions(t *testing.T) {
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var prefix string
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				prefix = aws.ToString(in.Parameters.(*s3.ListObjectVersionsInput).Prefix)
+				out := &s3.ListObjectVersionsOutput{
+					Versions: []types.ObjectVersion{
+						{Key: aws.String("backups/a.txt"), VersionId: aws.String("a2"), Size: aws.Int64(2), LastModified: aws.Time(t0.Add(2 * time.Hour)), IsLatest: aws.Bool(true)},
+						{Key: aws.String("backups/a.txt"), VersionId: aws.String("a1"), Size: aws.Int64(1), LastModified: aws.Time(t0)},
+						{Key: aws.String("backups/b.txt"), VersionId: aws.String("b1"), Size: aws.Int64(1), LastModified: aws.Time(t0)},
+					},
+					DeleteMarkers: []types.DeleteMarkerEntry{
+						{Key: aws.String("backups/a.txt"), VersionId: aws.String("am"), LastModified: aws.Time(t0.Add(time.Hour))},
+						{Key: aws.String("backups/b.txt"), VersionId: aws.String("bm"), LastModified: aws.Time(t0.Add(time.Hour)), IsLatest: aws.Bool(true)},
+					},
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "backups", WithS3Middleware(fake))
+
+	versions, err := ListVersions(context.Background(), d, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix != "backups/" {
+		t.Errorf("listed prefix %q, want backups/", prefix)
+	}
+	var got []string
+	for _, v := range versions {
+		got = append(got, v.Key+"@"+v.VersionID)
+	}
+	if want := []string{"a.txt@a2", "a.txt@am", "a.txt@a1", "b.txt@bm", "b.txt@b1"}; !slices.Equal(got, want) {
+		t.Errorf("versions %v, want %v, newest first with the delete markers", got, want)
+	}
+	if !versions[3].DeleteMarker || !versions[3].Latest || versions[4].Latest {
+		t.Errorf("b.txt versions %+v, want its delete marker current", versions[3:])
+	}
+}
+
+func TestS3Destination_Stat_denied(t *testing.T) {
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				resp := &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusForbidden}}
+				err := &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{Response: resp, Err: errors.New("Forbidden")}}
+				return middleware.InitializeOutput{}, middleware.Metadata{}, err
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+
+	if _, err := d.Stat(context.Background(), "a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("err = %v, want fs.ErrPermission", err)
+	}
+}
+
+func TestS3Destination_Stat_archived(t *testing.T) {
+	var restore *string
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				out := &s3.HeadObjectOutput{ContentLength: aws.Int64(1), StorageClass: types.StorageClassDeepArchive, Restore: restore}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Middleware(fake))
+
+	if meta, err := d.Stat(context.Background(), "a.txt"); err != nil || !meta.Archived || meta.StorageClass != "DEEP_ARCHIVE" {
+		t.Errorf("DEEP_ARCHIVE: Stat = %+v, %v, want archived in DEEP_ARCHIVE", meta, err)
+	}
+	restore = aws.String(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	if meta, err := d.Stat(context.Background(), "a.txt"); err != nil || meta.Archived {
+		t.Errorf("restored: Stat = %+v, %v, want readable", meta, err)
+	}
+}
+
+func TestCheckS3Tags(t *testing.T) {
+	tests := []struct {
+		tags map[string]string
+		ok   bool
+	}{
+		{nil, true},
+		{map[string]string{"backup": "foldersync", "empty": ""}, true},
+		{map[string]string{"": "x"}, false},
+		{map[string]string{"aws:createdBy": "me"}, false},
+		{map[string]string{strings.Repeat("k", 129): "x"}, false},
+		{map[string]string{"k": strings.Repeat("v", 257)}, false},
+		{map[string]string{"a": "", "b": "", "c": "", "d": "", "e": "", "f": "", "g": "", "h": "", "i": "", "j": "", "k": ""}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckS3Tags(tt.tags); (err == nil) != tt.ok {
+			t.Errorf("CheckS3Tags(%v) = %v, want ok %v", tt.tags, err, tt.ok)
+		}
+	}
+}
+
+func TestS3ArchiveStatus(t *testing.T) {
+	tests := []struct {
+		sc      types.StorageClass
+		restore string
+		want    ArchiveState
+	}{
+		{types.StorageClassStandard, "", NotArchived},
+		{types.StorageClassGlacierIr, "", NotArchived},
+		{types.StorageClassGlacier, "", Archived},
+		{types.StorageClassDeepArchive, `ongoing-request="true"`, Restoring},
+		{types.StorageClassGlacier, `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`, Restored},
+	}
+	for _, tt := range tests {
+		if got := s3ArchiveStatus(tt.sc, tt.restore); got.State != tt.want {
+			t.Errorf("s3ArchiveStatus(%s, %q) = %v, want %v", tt.sc, tt.restore, got.State, tt.want)
+		}
+	}
+	st := s3ArchiveStatus(types.StorageClassGlacier, `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	if want := time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC); !st.Expiry.Equal(want) {
+		t.Errorf("expiry = %v, want %v", st.Expiry, want)
+	}
+}
+
+func TestS3Destination_adaptiveConcurrency(t *testing.T) {
+	var mu stdsync.Mutex
+	var parts, inflight, most int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>big.bin</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && q.Has("partNumber"):
+			mu.Lock()
+			parts++
+			first := parts == 1
+			inflight++
+			most = max(most, inflight)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+			if first {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, `<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`)
+				return
+			}
+			w.Header().Set("ETag", `"etag"`)
+		case r.Method == http.MethodPost && q.Has("uploadId"):
+			fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>big.bin</Key><ETag>"etag-4"</ETag></CompleteMultipartUploadResult>`)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "", WithS3Endpoint(srv.URL), WithS3PathStyle(),
+		WithS3ClientOptions(func(o *s3.Options) {
+			noDelay := retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+			o.Retryer = retry.NewStandard(func(o *retry.StandardOptions) { o.Backoff = noDelay })
+		}),
+		WithS3AdaptiveConcurrency(8))
+	body := bytes.NewReader(make([]byte, 20<<20)) // four 5 MiB parts
+	if err := d.Put(context.Background(), "big.bin", body, ObjectMeta{Size: int64(body.Len())}); err != nil {
+		t.Fatal(err)
+	}
+	if parts != 5 {
+		t.Errorf("%d part requests, want the 4 parts and a retry of the first", parts)
+	}
+	// The throttled first part keeps the limit at one until it is done,
+	// and then it rises by one a round.
+	if most > 2 {
+		t.Errorf("uploaded %d parts at once, want at most 2 after being throttled", most)
+	}
+	if !d.adaptive.slow {
+		t.Error("the limit is still doubling after a throttled part")
+	}
+	if _, err := Open(context.Background(), "s3://bucket?region=eu-west-1&adaptive-concurrency=yes"); err == nil {
+		t.Error("Open accepted adaptive-concurrency=yes")
+	}
+}
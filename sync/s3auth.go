@@ -0,0 +1,117 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// defaultRoleSessionName names the sessions of assumed roles, as CloudTrail
+// shows them, unless the role-session-name URL parameter says otherwise.
+const defaultRoleSessionName = "foldersync"
+
+// roleSessionName matches the session names STS accepts.
+var roleSessionName = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// CheckAssumeRole reports whether roleARN, externalID and sessionName, as
+// given to the role-arn, external-id and role-session-name URL parameters,
+// can be used to assume a role. externalID and sessionName may be empty.
+func CheckAssumeRole(roleARN, externalID, sessionName string) error {
+	if roleARN == "" {
+		if externalID != "" || sessionName != "" {
+			return errors.New("external-id and role-session-name need role-arn")
+		}
+		return nil
+	}
+	if !strings.HasPrefix(roleARN, "arn:") || !strings.Contains(roleARN, ":role/") {
+		return fmt.Errorf("role %q: want the ARN of an IAM role, arn:aws:iam::<account>:role/<name>", roleARN)
+	}
+	if externalID != "" && (len(externalID) < 2 || len(externalID) > 1224) {
+		return fmt.Errorf("external ID %q: want 2 to 1224 characters", externalID)
+	}
+	if sessionName != "" && !roleSessionName.MatchString(sessionName) {
+		return fmt.Errorf("role session name %q: want 2 to 64 letters, digits and +=,.@_-", sessionName)
+	}
+	return nil
+}
+
+// loadS3Config loads the AWS configuration of an s3:// URL with query q:
+// that of the environment, or of the shared config profile named by the
+// profile parameter, in the region of the region parameter. With role-arn,
+// requests are signed with the credentials of that role instead, assumed
+// with those of the profile or environment.
+func loadS3Config(ctx context.Context, q url.Values) (aws.Config, error) {
+	roleARN, externalID, sessionName := q.Get("role-arn"), q.Get("external-id"), q.Get("role-session-name")
+	if err := CheckAssumeRole(roleARN, externalID, sessionName); err != nil {
+		return aws.Config{}, err
+	}
+	var loadOpts []func(*config.LoadOptions) error
+	if region := q.Get("region"); region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(region))
+	}
+	profile := q.Get("profile")
+	if profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		if profile != "" {
+			return aws.Config{}, fmt.Errorf("load AWS config of profile %s: %w", profile, err)
+		}
+		return aws.Config{}, fmt.Errorf("load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Credentials != nil {
+		cfg.Credentials = ssoLoginHint{cfg.Credentials, profile}
+	}
+	if roleARN != "" {
+		cfg.Credentials = assumeRole(cfg, roleARN, externalID, sessionName)
+	}
+	return cfg, nil
+}
+
+// assumeRole returns credentials of the role roleARN, assumed with those of
+// cfg and renewed before they expire.
+func assumeRole(cfg aws.Config, roleARN, externalID, sessionName string) aws.CredentialsProvider {
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+	p := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+	return aws.NewCredentialsCache(p)
+}
+
+// ssoLoginHint tells how to sign in again when the credentials of an IAM
+// Identity Center (SSO) profile cannot be retrieved because its session
+// has expired, which happens every few hours.
+type ssoLoginHint struct {
+	aws.CredentialsProvider
+	profile string
+}
+
+func (h ssoLoginHint) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := h.CredentialsProvider.Retrieve(ctx)
+	if tokenErr := (*ssocreds.InvalidTokenError)(nil); errors.As(err, &tokenErr) {
+		login := "aws sso login"
+		if h.profile != "" {
+			login += " --profile " + h.profile
+		}
+		return creds, fmt.Errorf("%w; sign in again with %s", err, login)
+	}
+	return creds, err
+}
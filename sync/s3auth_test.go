@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+)
+
+func TestCheckAssumeRole(t *testing.T) {
+	for _, tc := range []struct {
+		arn, externalID, session string
+		ok                       bool
+	}{
+		{"", "", "", true},
+		{"arn:aws:iam::111122223333:role/backup", "", "", true},
+		{"arn:aws:iam::111122223333:role/backup", "photos-7f3a", "nas-photos", true},
+		{"", "photos-7f3a", "", false},
+		{"", "", "nas", false},
+		{"backup", "", "", false},
+		{"arn:aws:iam::111122223333:user/backup", "", "", false},
+		{"arn:aws:iam::111122223333:role/backup", "x", "", false},
+		{"arn:aws:iam::111122223333:role/backup", "", "nas photos", false},
+	} {
+		if err := CheckAssumeRole(tc.arn, tc.externalID, tc.session); (err == nil) != tc.ok {
+			t.Errorf("CheckAssumeRole(%q, %q, %q) = %v, want ok %v", tc.arn, tc.externalID, tc.session, err, tc.ok)
+		}
+	}
+}
+
+func TestLoadS3Config_profile(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	profiles := "[default]\nregion = us-west-2\n\n[profile backup]\nregion = eu-west-1\n"
+	if err := os.WriteFile(configFile, []byte(profiles), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	credsFile := filepath.Join(dir, "credentials")
+	keys := "[default]\naws_access_key_id = DEFAULT\naws_secret_access_key = x\n\n[backup]\naws_access_key_id = BACKUP\naws_secret_access_key = y\n"
+	if err := os.WriteFile(credsFile, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credsFile)
+	for _, name := range []string{"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+		t.Setenv(name, "")
+	}
+	ctx := context.Background()
+
+	cfg, err := loadS3Config(ctx, url.Values{"profile": {"backup"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil || creds.AccessKeyID != "BACKUP" || cfg.Region != "eu-west-1" {
+		t.Errorf("profile backup: key %q in %s, %v; want BACKUP in eu-west-1", creds.AccessKeyID, cfg.Region, err)
+	}
+	if _, err := loadS3Config(ctx, url.Values{"profile": {"missing"}}); err == nil {
+		t.Error("missing profile: loadS3Config succeeded")
+	}
+	if _, err := loadS3Config(ctx, url.Values{"external-id": {"photos-7f3a"}}); err == nil {
+		t.Error("external-id without role-arn: loadS3Config succeeded")
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form = r.PostForm
+		fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>
+<Credentials><AccessKeyId>ROLE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken><Expiration>2099-01-01T00:00:00Z</Expiration></Credentials>
+<AssumedRoleUser><Arn>arn:aws:sts::111122223333:assumed-role/backup/foldersync</Arn><AssumedRoleId>AROA:foldersync</AssumedRoleId></AssumedRoleUser>
+</AssumeRoleResult></AssumeRoleResponse>`)
+	}))
+	defer srv.Close()
+	cfg := aws.Config{
+		Region:       "eu-west-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("BASE", "secret", ""),
+		BaseEndpoint: aws.String(srv.URL),
+	}
+
+	creds, err := assumeRole(cfg, "arn:aws:iam::111122223333:role/backup", "photos-7f3a", "").Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "ROLE" || creds.SessionToken != "token" {
+		t.Errorf("credentials %q, %q; want those of the role", creds.AccessKeyID, creds.SessionToken)
+	}
+	if form.Get("Action") != "AssumeRole" || form.Get("RoleArn") != "arn:aws:iam::111122223333:role/backup" ||
+		form.Get("ExternalId") != "photos-7f3a" || form.Get("RoleSessionName") != "foldersync" {
+		t.Errorf("requested %v, want the role assumed with the external ID as session foldersync", form)
+	}
+}
+
+func TestSSOLoginHint(t *testing.T) {
+	expired := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{}, &ssocreds.InvalidTokenError{}
+	})
+	_, err := ssoLoginHint{expired, "backup"}.Retrieve(context.Background())
+	var tokenErr *ssocreds.InvalidTokenError
+	if !errors.As(err, &tokenErr) || !strings.Contains(err.Error(), "aws sso login --profile backup") {
+		t.Errorf("expired session: got %v, want it to say to run aws sso login --profile backup", err)
+	}
+}
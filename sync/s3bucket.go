@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// BucketSetup is the configuration EnsureBucket gives a bucket it creates.
+type BucketSetup struct {
+	// Versioning turns on versioning, so that overwritten and deleted
+	// objects are kept as noncurrent versions.
+	Versioning bool
+	// Encryption, if set, is the bucket's default encryption: AES256
+	// (SSE-S3) or aws:kms (SSE-KMS), with KMSKeyID or else the AWS managed
+	// key aws/s3.
+	Encryption types.ServerSideEncryption
+	KMSKeyID   string
+	// BlockPublicAccess blocks public ACLs and bucket policies.
+	BlockPublicAccess bool
+	// ObjectLock creates the bucket with Object Lock enabled, which also
+	// turns on versioning. It cannot be enabled on a bucket later.
+	ObjectLock bool
+	// AbortUploadsAfterDays, if positive, adds a lifecycle rule aborting
+	// multipart uploads still incomplete this many days after they
+	// started, whose parts are billed until then.
+	AbortUploadsAfterDays int
+}
+
+// abortUploadsRule is the ID of the lifecycle rule of
+// BucketSetup.AbortUploadsAfterDays.
+const abortUploadsRule = "foldersync-abort-incomplete-uploads"
+
+// EnsureBucket creates the destination's bucket, in the client's region,
+// if it does not exist, and configures it as setup says, reporting whether
+// it did. A bucket that already exists is left as it is: its owner's
+// configuration is not overwritten.
+func (d *S3Destination) EnsureBucket(ctx context.Context, setup BucketSetup) (created bool, err error) {
+	_, err = d.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(d.bucket)}, d.clientOpts...)
+	var re *awshttp.ResponseError
+	if err == nil {
+		return false, nil
+	} else if !errors.As(err, &re) || re.HTTPStatusCode() != http.StatusNotFound {
+		return false, fmt.Errorf("check bucket %s: %w", d.bucket, err)
+	}
+
+	in := &s3.CreateBucketInput{Bucket: aws.String(d.bucket)}
+	if region := d.client.Options().Region; region != "" && region != "us-east-1" {
+		// us-east-1 is the default, which S3 refuses to be named.
+		in.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(region),
+		}
+	}
+	if setup.ObjectLock {
+		in.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
+	_, err = d.client.CreateBucket(ctx, in, d.clientOpts...)
+	var owned *types.BucketAlreadyOwnedByYou
+	if errors.As(err, &owned) {
+		return false, nil // created since it was checked
+	} else if err != nil {
+		return false, fmt.Errorf("create bucket %s: %w", d.bucket, err)
+	}
+	if err := d.configureBucket(ctx, setup); err != nil {
+		return true, fmt.Errorf("configure bucket %s: %w", d.bucket, err)
+	}
+	return true, nil
+}
+
+// configureBucket applies setup, but for ObjectLock, to the bucket.
+func (d *S3Destination) configureBucket(ctx context.Context, setup BucketSetup) error {
+	bucket := aws.String(d.bucket)
+	if setup.BlockPublicAccess {
+		_, err := d.client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: bucket,
+			PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		}, d.clientOpts...)
+		if err != nil {
+			return fmt.Errorf("block public access: %w", err)
+		}
+	}
+	if setup.Versioning && !setup.ObjectLock {
+		_, err := d.client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{
+			Bucket:                  bucket,
+			VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled},
+		}, d.clientOpts...)
+		if err != nil {
+			return fmt.Errorf("enable versioning: %w", err)
+		}
+	}
+	if setup.Encryption != "" {
+		def := &types.ServerSideEncryptionByDefault{SSEAlgorithm: setup.Encryption}
+		rule := types.ServerSideEncryptionRule{ApplyServerSideEncryptionByDefault: def}
+		if setup.Encryption == types.ServerSideEncryptionAwsKms {
+			if setup.KMSKeyID != "" {
+				def.KMSMasterKeyID = aws.String(setup.KMSKeyID)
+			}
+			// Saves a KMS request for most objects read or written.
+			rule.BucketKeyEnabled = aws.Bool(true)
+		}
+		_, err := d.client.PutBucketEncryption(ctx, &s3.PutBucketEncryptionInput{
+			Bucket:                            bucket,
+			ServerSideEncryptionConfiguration: &types.ServerSideEncryptionConfiguration{Rules: []types.ServerSideEncryptionRule{rule}},
+		}, d.clientOpts...)
+		if err != nil {
+			return fmt.Errorf("set default encryption: %w", err)
+		}
+	}
+	if setup.AbortUploadsAfterDays > 0 {
+		_, err := d.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket: bucket,
+			LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{{
+				ID:                             aws.String(abortUploadsRule),
+				Status:                         types.ExpirationStatusEnabled,
+				Filter:                         &types.LifecycleRuleFilterMemberPrefix{Value: ""},
+				AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{DaysAfterInitiation: aws.Int32(int32(setup.AbortUploadsAfterDays))},
+			}}},
+		}, d.clientOpts...)
+		if err != nil {
+			return fmt.Errorf("add lifecycle rule: %w", err)
+		}
+	}
+	return nil
+}
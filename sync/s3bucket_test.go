@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+func TestS3Destination_EnsureBucket(t *testing.T) {
+	exists := false
+	var calls []string
+	var create *s3.CreateBucketInput
+	var encryption *s3.PutBucketEncryptionInput
+	var lifecycle *s3.PutBucketLifecycleConfigurationInput
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				var out any
+				switch p := in.Parameters.(type) {
+				case *s3.HeadBucketInput:
+					calls = append(calls, "head")
+					if !exists {
+						resp := &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+						err := &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{Response: resp, Err: errors.New("NotFound")}}
+						return middleware.InitializeOutput{}, middleware.Metadata{}, err
+					}
+					out = &s3.HeadBucketOutput{}
+				case *s3.CreateBucketInput:
+					calls, create, out = append(calls, "create"), p, &s3.CreateBucketOutput{}
+				case *s3.PutPublicAccessBlockInput:
+					calls, out = append(calls, "public-access-block"), &s3.PutPublicAccessBlockOutput{}
+				case *s3.PutBucketVersioningInput:
+					calls, out = append(calls, "versioning"), &s3.PutBucketVersioningOutput{}
+				case *s3.PutBucketEncryptionInput:
+					calls, encryption, out = append(calls, "encryption"), p, &s3.PutBucketEncryptionOutput{}
+				case *s3.PutBucketLifecycleConfigurationInput:
+					calls, lifecycle, out = append(calls, "lifecycle"), p, &s3.PutBucketLifecycleConfigurationOutput{}
+				default:
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", p)
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "backups", WithS3Middleware(fake))
+	ctx := context.Background()
+
+	setup := BucketSetup{Versioning: true, Encryption: types.ServerSideEncryptionAwsKms, KMSKeyID: "alias/backups", BlockPublicAccess: true, AbortUploadsAfterDays: 7}
+	created, err := d.EnsureBucket(ctx, setup)
+	if err != nil || !created {
+		t.Fatalf("EnsureBucket = %v, %v; want the bucket created", created, err)
+	}
+	if want := []string{"head", "create", "public-access-block", "versioning", "encryption", "lifecycle"}; !slices.Equal(calls, want) {
+		t.Errorf("requests %v, want %v", calls, want)
+	}
+	if create.CreateBucketConfiguration == nil || create.CreateBucketConfiguration.LocationConstraint != "eu-west-1" {
+		t.Errorf("created bucket with %+v, want it in eu-west-1", create.CreateBucketConfiguration)
+	}
+	def := encryption.ServerSideEncryptionConfiguration.Rules[0].ApplyServerSideEncryptionByDefault
+	if def.SSEAlgorithm != types.ServerSideEncryptionAwsKms || aws.ToString(def.KMSMasterKeyID) != "alias/backups" {
+		t.Errorf("default encryption %+v, want aws:kms with alias/backups", def)
+	}
+	if rule := lifecycle.LifecycleConfiguration.Rules[0]; aws.ToInt32(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation) != 7 {
+		t.Errorf("lifecycle rule %+v, want incomplete uploads aborted after 7 days", rule)
+	}
+
+	// Object Lock is enabled as the bucket is created, with versioning.
+	calls = nil
+	setup.ObjectLock, setup.Encryption, setup.AbortUploadsAfterDays = true, "", 0
+	if _, err := d.EnsureBucket(ctx, setup); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"head", "create", "public-access-block"}; !slices.Equal(calls, want) || !aws.ToBool(create.ObjectLockEnabledForBucket) {
+		t.Errorf("with Object Lock: requests %v, lock %v; want %v and the lock enabled", calls, aws.ToBool(create.ObjectLockEnabledForBucket), want)
+	}
+
+	calls, exists = nil, true
+	if created, err := d.EnsureBucket(ctx, setup); err != nil || created || !slices.Equal(calls, []string{"head"}) {
+		t.Errorf("existing bucket: EnsureBucket = %v, %v after %v; want it left alone", created, err, calls)
+	}
+}
@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+)
+
+// S3Compat is how much of the S3 API the service behind an S3Destination
+// supports. See WithS3Compat.
+type S3Compat string
+
+const (
+	// S3CompatFull uses the whole API, as AWS supports it.
+	S3CompatFull S3Compat = "full"
+	// S3CompatLegacy keeps to the parts S3-compatible services have long
+	// supported, for those that reject the rest: objects are uploaded
+	// and read without the additional checksums of the x-amz-checksum
+	// headers and trailers, except as Object Lock requires, and
+	// foldersync's own metadata is packed into a single
+	// x-amz-meta-foldersync header, for services that limit how many
+	// metadata headers an object may have.
+	S3CompatLegacy S3Compat = "legacy"
+)
+
+// ParseS3Compat parses the compat parameter of s3:// URLs: "full", the
+// default if s is empty, or "legacy".
+func ParseS3Compat(s string) (S3Compat, error) {
+	switch c := S3Compat(s); c {
+	case "":
+		return S3CompatFull, nil
+	case S3CompatFull, S3CompatLegacy:
+		return c, nil
+	}
+	return "", fmt.Errorf("compat=%q: want full or legacy", s)
+}
+
+// WithS3Compat sets how much of the S3 API the destination uses. Objects
+// uploaded with either are read back alike.
+func WithS3Compat(c S3Compat) S3Option {
+	return func(d *S3Destination) { d.compat = c }
+}
+
+// packedMetadataKey is the metadata key S3CompatLegacy packs foldersync's
+// own metadata under.
+const packedMetadataKey = "foldersync"
+
+// packMetadata returns md, the metadata of an object described by meta,
+// with the keys foldersync sets packed into the value of
+// packedMetadataKey as a URL query. User metadata is left as it is.
+func packMetadata(md map[string]string, meta ObjectMeta) map[string]string {
+	meta.UserMetadata = nil
+	own := objectMetadata(meta)
+	packed := url.Values{}
+	for k, v := range own {
+		packed.Set(k, v)
+		delete(md, k)
+	}
+	md[packedMetadataKey] = packed.Encode()
+	return md
+}
+
+// unpackMetadata returns md with the keys packed by packMetadata, if any,
+// unpacked.
+func unpackMetadata(md map[string]string) map[string]string {
+	v, ok := md[packedMetadataKey]
+	if !ok {
+		return md
+	}
+	packed, err := url.ParseQuery(v)
+	if err != nil {
+		return md
+	}
+	md = maps.Clone(md)
+	delete(md, packedMetadataKey)
+	for k := range packed {
+		md[k] = packed.Get(k)
+	}
+	return md
+}
@@ -0,0 +1,222 @@
+// Package s3test provides a real S3-compatible server for the integration
+// tests of package sync, so that they exercise what the in-memory mocks of
+// the unit tests cannot: multipart uploads, paginated listings, batched
+// deletes and the metadata a server keeps.
+//
+// Start targets the server at the URL in $FOLDERSYNC_TEST_S3_ENDPOINT, such
+// as MinIO or LocalStack, with the credentials in $AWS_ACCESS_KEY_ID and
+// $AWS_SECRET_ACCESS_KEY, or else, if $FOLDERSYNC_TEST_DOCKER is set, starts
+// a MinIO container of its own with Docker. The integration tests are built
+// with the integration tag:
+//
+//	FOLDERSYNC_TEST_DOCKER=1 go test -tags integration ./sync
+package s3test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+// The environment variables Start reads.
+const (
+	EndpointEnv = "FOLDERSYNC_TEST_S3_ENDPOINT"
+	DockerEnv   = "FOLDERSYNC_TEST_DOCKER"
+)
+
+// MinIOImage is the image of the container Start runs.
+const MinIOImage = "minio/minio:latest"
+
+// ErrNoServer is returned by Start when the environment names no server
+// and does not ask for one to be started.
+var ErrNoServer = fmt.Errorf("no S3 server: set %s, or %s=1 to start MinIO with Docker", EndpointEnv, DockerEnv)
+
+// A Server is an S3-compatible server to run tests against.
+type Server struct {
+	Endpoint  string // base URL, such as http://127.0.0.1:9000
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	client    *s3.Client
+	container string // ID of the container Start ran, if it did
+}
+
+// Start returns the server the environment names, starting it first if
+// asked to, once it answers requests. It fails with ErrNoServer if there
+// is none to use.
+func Start(ctx context.Context) (*Server, error) {
+	s := &Server{
+		Endpoint:  os.Getenv(EndpointEnv),
+		Region:    "us-east-1",
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	switch {
+	case s.Endpoint != "":
+		if s.AccessKey == "" || s.SecretKey == "" {
+			// What MinIO and LocalStack accept out of the box.
+			s.AccessKey, s.SecretKey = "test", "test"
+		}
+	case os.Getenv(DockerEnv) != "":
+		if err := s.runMinIO(ctx); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrNoServer
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		s.Region = region
+	}
+	s.client = s3.New(s3.Options{
+		Region:       s.Region,
+		BaseEndpoint: aws.String(s.Endpoint),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider(s.AccessKey, s.SecretKey, ""),
+	})
+	if err := s.waitReady(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// runMinIO starts a MinIO container listening on a free port of the
+// loopback interface.
+func (s *Server) runMinIO(ctx context.Context) error {
+	s.AccessKey, s.SecretKey = "foldersync", "foldersync-secret"
+	out, err := exec.CommandContext(ctx, "docker", "run", "--detach", "--rm",
+		"--publish", "127.0.0.1::9000",
+		"--env", "MINIO_ROOT_USER="+s.AccessKey,
+		"--env", "MINIO_ROOT_PASSWORD="+s.SecretKey,
+		MinIOImage, "server", "/data").Output()
+	if err != nil {
+		return fmt.Errorf("start MinIO: %w", commandError(err))
+	}
+	s.container = strings.TrimSpace(string(out))
+	out, err = exec.CommandContext(ctx, "docker", "port", s.container, "9000/tcp").Output()
+	if err != nil {
+		s.Close()
+		return fmt.Errorf("find MinIO's port: %w", commandError(err))
+	}
+	addr, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	s.Endpoint = "http://" + addr
+	return nil
+}
+
+// commandError adds what a command printed on failing to err.
+func commandError(err error) error {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+	}
+	return err
+}
+
+// waitReady waits up to a minute for the server to answer requests.
+func (s *Server) waitReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	for {
+		_, err := s.client.ListBuckets(ctx, &s3.ListBucketsInput{})
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("S3 server at %s not ready: %w", s.Endpoint, err)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// Close stops the container Start ran, if it did.
+func (s *Server) Close() error {
+	if s.container == "" {
+		return nil
+	}
+	err := exec.Command("docker", "stop", s.container).Run()
+	s.container = ""
+	return err
+}
+
+// Client returns a client of the server, for checking what a test stored
+// without going through package sync.
+func (s *Server) Client() *s3.Client {
+	return s.client
+}
+
+// NewBucket creates an empty bucket with a name of its own, which is
+// emptied and deleted when tb and its subtests have finished.
+func (s *Server) NewBucket(tb testing.TB) string {
+	tb.Helper()
+	b := make([]byte, 6)
+	rand.Read(b)
+	bucket := "foldersync-test-" + hex.EncodeToString(b)
+	ctx := context.Background()
+	if _, err := s.client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		tb.Fatalf("create bucket: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := s.deleteBucket(ctx, bucket); err != nil {
+			tb.Errorf("delete bucket %s: %v", bucket, err)
+		}
+	})
+	return bucket
+}
+
+// deleteBucket deletes bucket and every object in it.
+func (s *Server) deleteBucket(ctx context.Context, bucket string) error {
+	p := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		ids := make([]types.ObjectIdentifier, len(page.Contents))
+		for i, o := range page.Contents {
+			ids[i] = types.ObjectIdentifier{Key: o.Key}
+		}
+		_, err = s.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err := s.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucket)})
+	return err
+}
+
+// Destination returns a destination storing objects under prefix in
+// bucket on the server, with opts.
+func (s *Server) Destination(bucket, prefix string, opts ...sync.S3Option) *sync.S3Destination {
+	cfg := aws.Config{
+		Region:      s.Region,
+		Credentials: credentials.NewStaticCredentialsProvider(s.AccessKey, s.SecretKey, ""),
+	}
+	opts = append([]sync.S3Option{
+		sync.WithS3Endpoint(s.Endpoint),
+		sync.WithS3PathStyle(),
+		sync.WithS3StorageClass(types.StorageClassStandard),
+	}, opts...)
+	return sync.NewS3DestinationFromConfig(cfg, bucket, prefix, opts...)
+}
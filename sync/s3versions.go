@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ListVersions implements Versioner with ListObjectVersions, a page of up
+// to 1000 versions and delete markers at a time.
+func (d *S3Destination) ListVersions(ctx context.Context, prefix string, fn func([]ObjectVersion) error) error {
+	paginator := s3.NewListObjectVersionsPaginator(d.client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(d.bucket),
+		Prefix: aws.String(listPrefix(d.prefix) + prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx, d.clientOpts...)
+		if err != nil {
+			return fmt.Errorf("list object versions: %w", err)
+		}
+		versions := make([]ObjectVersion, 0, len(page.Versions)+len(page.DeleteMarkers))
+		for _, v := range page.Versions {
+			versions = append(versions, ObjectVersion{
+				Key:       d.relKey(aws.ToString(v.Key)),
+				VersionID: aws.ToString(v.VersionId),
+				Size:      aws.ToInt64(v.Size),
+				Written:   aws.ToTime(v.LastModified),
+				Latest:    aws.ToBool(v.IsLatest),
+			})
+		}
+		for _, m := range page.DeleteMarkers {
+			versions = append(versions, ObjectVersion{
+				Key:          d.relKey(aws.ToString(m.Key)),
+				VersionID:    aws.ToString(m.VersionId),
+				Written:      aws.ToTime(m.LastModified),
+				Latest:       aws.ToBool(m.IsLatest),
+				DeleteMarker: true,
+			})
+		}
+		// S3 lists the versions and the delete markers apart.
+		slices.SortStableFunc(versions, func(a, b ObjectVersion) int {
+			return cmp.Or(strings.Compare(a.Key, b.Key), b.Written.Compare(a.Written))
+		})
+		if err := fn(versions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreVersion implements Versioner by copying the version onto its key
+// server-side, as Copy does.
+func (d *S3Destination) RestoreVersion(ctx context.Context, key, id string) error {
+	return d.copyVersion(ctx, d, key, id, key, d.storageClass)
+}
+
+// DeleteVersions implements Versioner with DeleteObjects, naming up to
+// 1000 versions per request.
+func (d *S3Destination) DeleteVersions(ctx context.Context, versions []ObjectVersion) ([]ObjectVersion, error) {
+	var deleted []ObjectVersion
+	for chunk := range slices.Chunk(versions, maxDeleteObjects) {
+		ids := make([]types.ObjectIdentifier, len(chunk))
+		for i, v := range chunk {
+			ids[i] = types.ObjectIdentifier{Key: aws.String(d.fullKey(v.Key)), VersionId: aws.String(v.VersionID)}
+		}
+		out, err := d.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(d.bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		}, d.clientOpts...)
+		if err != nil {
+			return deleted, fmt.Errorf("delete object versions: %w", err)
+		}
+		failed := make(map[string]bool, len(out.Errors))
+		for _, e := range out.Errors {
+			failed[aws.ToString(e.Key)+"\x00"+aws.ToString(e.VersionId)] = true
+		}
+		for _, v := range chunk {
+			if !failed[d.fullKey(v.Key)+"\x00"+v.VersionID] {
+				deleted = append(deleted, v)
+			}
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return deleted, fmt.Errorf("delete %s version %s: %s: %s (and %d more)",
+				d.relKey(aws.ToString(e.Key)), aws.ToString(e.VersionId), aws.ToString(e.Code), aws.ToString(e.Message), len(out.Errors)-1)
+		}
+	}
+	return deleted, nil
+}
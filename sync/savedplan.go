@@ -0,0 +1,158 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// savedPlanVersion is the version of the format of SavedPlan.
+const savedPlanVersion = 1
+
+// ErrStalePlan is returned by a run applying a SavedPlan when a file it
+// uploads has changed since the plan was made. Nothing is changed; make a
+// new plan.
+var ErrStalePlan = errors.New("plan is out of date")
+
+// SavedPlan is what a run would do, as passed to Options.SavePlan, for a
+// later run to do exactly that with Options.ApplyPlan once it has been
+// reviewed. It encodes to JSON.
+type SavedPlan struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Uploads []PlannedUpload `json:"uploads"`
+	Deletes []PlannedDelete `json:"deletes"`
+
+	// Incomplete reports that the plan was cut short by
+	// Options.MaxRequests or a budget, so it deletes nothing. See
+	// Plan.Incomplete.
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// PlannedUpload is an upload of a SavedPlan: the file at Path, as it was
+// when the plan was made, to be uploaded to Key.
+type PlannedUpload struct {
+	Key     string    `json:"key"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Reason  string    `json:"reason"` // why, such as "missing" or "size 10, want 12"
+}
+
+// PlannedDelete is a delete of a SavedPlan.
+type PlannedDelete struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// checkSavedPlans reports whether opts.SavePlan and opts.ApplyPlan can be
+// used with the rest of opts: a saved plan holds uploads and deletes, and
+// nothing of the features that plan other changes.
+func checkSavedPlans(opts Options) error {
+	if opts.SavePlan == nil && opts.ApplyPlan == nil {
+		return nil
+	}
+	switch {
+	case opts.SavePlan != nil && opts.ApplyPlan != nil:
+		return errors.New("a plan cannot be saved by the run applying one")
+	case opts.twoWay:
+		return errors.New("a plan cannot be saved or applied in two-way sync")
+	case opts.Bundle != nil || opts.Chunk != nil:
+		return errors.New("a plan cannot be saved or applied with bundling or chunking")
+	case opts.DetectRenames || opts.DedupeUploads || opts.HardLinks:
+		return errors.New("a plan cannot be saved or applied with rename detection, deduplication or hard links")
+	case opts.KeepEmptyDirs || len(opts.Tiers) > 0:
+		return errors.New("a plan cannot be saved or applied with empty directories or storage tiers")
+	case opts.ApplyPlan != nil && (opts.FileList != nil || opts.ReportExtraneous != ""):
+		return errors.New("a plan cannot be applied with a file list or a report of extraneous objects")
+	case opts.ApplyPlan != nil && (opts.Manifest || opts.Incremental || opts.Snapshots):
+		return errors.New("a plan cannot be applied with a manifest, snapshots or in incremental runs, which need every file")
+	case opts.ApplyPlan != nil && opts.ApplyPlan.Version != savedPlanVersion:
+		return fmt.Errorf("plan is of version %d; this foldersync reads version %d", opts.ApplyPlan.Version, savedPlanVersion)
+	}
+	return nil
+}
+
+// savePlan passes plan to opts.SavePlan.
+func savePlan(opts Options, plan *Plan) error {
+	saved := &SavedPlan{
+		Version:    savedPlanVersion,
+		Created:    time.Now().UTC(),
+		Uploads:    make([]PlannedUpload, 0, len(plan.Uploads)),
+		Deletes:    make([]PlannedDelete, 0, len(plan.Deletes)),
+		Incomplete: plan.Incomplete,
+	}
+	for _, f := range plan.Uploads {
+		saved.Uploads = append(saved.Uploads, PlannedUpload{
+			Key:     f.Key,
+			Path:    f.Path,
+			Size:    f.Size,
+			ModTime: f.ModTime,
+			Reason:  uploadReason(opts, plan, f),
+		})
+	}
+	reason := "absent from the source"
+	if r := opts.deleteReason(); r != "" {
+		reason += ", " + r
+	}
+	for _, key := range plan.Deletes {
+		saved.Deletes = append(saved.Deletes, PlannedDelete{Key: key, Reason: reason})
+	}
+	return opts.SavePlan(saved)
+}
+
+// uploadReason returns why plan uploads f.
+func uploadReason(opts Options, plan *Plan, f File) string {
+	switch {
+	case f.Remote == nil && plan.incremental:
+		return "changed since the last run"
+	case f.Remote != nil && matchKey(opts.Reupload, f.Key):
+		return "to upload again"
+	}
+	return mismatchReason(opts, f)
+}
+
+// planSaved adds the uploads and deletes of opts.ApplyPlan to plan, in
+// its order, without asking the destination about them. It fails with
+// ErrStalePlan if a file to upload is gone or has changed.
+func planSaved(ctx context.Context, opts Options, plan *Plan) error {
+	saved := opts.ApplyPlan
+	for _, u := range saved.Uploads {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if u.Key == "" || strings.HasPrefix(u.Key, metaPrefix) {
+			return fmt.Errorf("plan uploads to key %q", u.Key)
+		}
+		info, err := os.Lstat(u.Path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return fmt.Errorf("%w: %s is gone", ErrStalePlan, u.Path)
+		case err != nil:
+			return err
+		case !info.Mode().IsRegular() || info.Size() != u.Size || !info.ModTime().Equal(u.ModTime):
+			return fmt.Errorf("%w: %s has changed", ErrStalePlan, u.Path)
+		}
+		file, err := newFile(opts, u.Path, u.Key, info)
+		if err != nil {
+			return err
+		}
+		file.Key = u.Key
+		plan.Files = append(plan.Files, file)
+		plan.Uploads = append(plan.Uploads, file)
+		plan.state.visited(file.Key)
+	}
+	for _, d := range saved.Deletes {
+		if d.Key == "" || strings.HasPrefix(d.Key, metaPrefix) {
+			return fmt.Errorf("plan deletes key %q", d.Key)
+		}
+		plan.Deletes = append(plan.Deletes, d.Key)
+	}
+	if plan.state != nil {
+		plan.state.keepUnvisited()
+	}
+	return nil
+}
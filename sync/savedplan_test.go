@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSync_savedPlan(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "bb")
+	dst := newMockDest()
+	dst.objects["b.txt"] = &ObjectMeta{Size: 1}
+	dst.objects["gone.txt"] = &ObjectMeta{Size: 1}
+	ctx := context.Background()
+
+	var saved *SavedPlan
+	opts := Options{Src: src, Dst: dst, Delete: true, SavePlan: func(p *SavedPlan) error {
+		saved = p
+		return nil
+	}}
+	res, err := Sync(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 || len(dst.deleteCalls) != 0 || !res.Summary.DryRun {
+		t.Fatalf("saving a plan put %v and deleted %v; want a dry run", dst.putCalls, dst.deleteCalls)
+	}
+	if saved == nil || len(saved.Uploads) != 2 || len(saved.Deletes) != 1 {
+		t.Fatalf("saved plan %+v, want 2 uploads and a delete", saved)
+	}
+	if u := saved.Uploads[1]; u.Key != "b.txt" || u.Reason != "size 1, want 2" {
+		t.Errorf("second upload %+v, want b.txt for its size", u)
+	}
+
+	// The plan survives being written out, and the new file it does not
+	// hold is left alone.
+	data, err := json.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read SavedPlan
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, src, "c.txt", "c")
+	dst.statCalls = nil
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Delete: true, ApplyPlan: &read}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dst.putCalls)
+	if !slices.Equal(dst.putCalls, []string{"a.txt", "b.txt"}) || !slices.Equal(dst.deleteCalls, []string{"gone.txt"}) {
+		t.Errorf("applying the plan put %v and deleted %v; want a.txt and b.txt, and gone.txt", dst.putCalls, dst.deleteCalls)
+	}
+	if len(dst.statCalls) != 0 {
+		t.Errorf("applying the plan looked up %v, want nothing", dst.statCalls)
+	}
+
+	// A file changed since fails the run before anything is changed.
+	dst.putCalls, dst.deleteCalls = nil, nil
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Sync(ctx, Options{Src: src, Dst: dst, Delete: true, ApplyPlan: &read})
+	if !errors.Is(err, ErrStalePlan) || len(dst.putCalls) != 0 || len(dst.deleteCalls) != 0 {
+		t.Errorf("stale plan: %v, put %v, deleted %v; want ErrStalePlan and no changes", err, dst.putCalls, dst.deleteCalls)
+	}
+}
@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// SecretMatch is a file flagged by the secret scanner.
+type SecretMatch struct {
+	Key    string // destination key of the flagged file
+	Reason string // what the scanner found
+}
+
+// secretNames maps file-name glob patterns to the reason they are flagged.
+var secretNames = []struct {
+	pattern string
+	reason  string
+}{
+	{".env", "dotenv file"},
+	{".env.*", "dotenv file"},
+	{"id_rsa", "SSH private key"},
+	{"id_dsa", "SSH private key"},
+	{"id_ecdsa", "SSH private key"},
+	{"id_ed25519", "SSH private key"},
+	{"*.pem", "PEM file"},
+	{"*.key", "private key file"},
+	{"*.p12", "PKCS#12 keystore"},
+	{"*.pfx", "PKCS#12 keystore"},
+	{".netrc", "netrc credentials"},
+	{".pgpass", "PostgreSQL password file"},
+}
+
+// sniffLen is how much of each file the scanner reads when looking for
+// secret content.
+const sniffLen = 8 << 10
+
+var (
+	privateKeyMarker = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)
+	awsAccessKeyID   = regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)
+	awsSecretKey     = []byte("aws_secret_access_key")
+)
+
+// DetectSecret reports whether the file at path, stored under key, looks
+// like it holds credentials. It checks the key against well-known secret
+// file names and then sniffs the start of the file's content.
+func DetectSecret(path, key string) (reason string, found bool, err error) {
+	if reason, found := matchSecretName(key); found {
+		return reason, true, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", false, err
+	}
+	buf = buf[:n]
+
+	switch {
+	case privateKeyMarker.Match(buf):
+		return "contains a private key", true, nil
+	case bytes.Contains(buf, awsSecretKey):
+		return "contains an AWS secret access key", true, nil
+	case awsAccessKeyID.Match(buf):
+		return "contains an AWS access key ID", true, nil
+	}
+	return "", false, nil
+}
+
+func matchSecretName(key string) (string, bool) {
+	if key == ".aws/credentials" || strings.HasSuffix(key, "/.aws/credentials") {
+		return "AWS credentials file", true
+	}
+	name := path.Base(key)
+	for _, s := range secretNames {
+		if ok, _ := path.Match(s.pattern, name); ok {
+			return s.reason, true
+		}
+	}
+	return "", false
+}
+
+// screenSecrets removes flagged files from plan.Uploads unless
+// opts.ConfirmSecrets approves them.
+func screenSecrets(opts Options, plan *Plan) error {
+	var matches []SecretMatch
+	flagged := make(map[string]bool)
+	for _, u := range plan.Uploads {
+		reason, found, err := DetectSecret(u.Path, u.Key)
+		if err != nil {
+			return err
+		}
+		if found {
+			matches = append(matches, SecretMatch{Key: u.Key, Reason: reason})
+			flagged[u.Key] = true
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	if opts.ConfirmSecrets != nil && opts.ConfirmSecrets(matches) {
+		return nil
+	}
+
+	kept := plan.Uploads[:0]
+	for _, u := range plan.Uploads {
+		if !flagged[u.Key] {
+			kept = append(kept, u)
+		} else {
+			plan.dirs.forget(dirOf(u.Key))
+		}
+	}
+	plan.Uploads = kept
+	return nil
+}
@@ -0,0 +1,360 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	Register("sftp", openSFTPURL)
+}
+
+// SFTPDestination mirrors files into a directory on an SSH server, over
+// SFTP. As with a LocalDestination, each key is a file under the root, and
+// Stat reports back only the size and modification time: compressed and
+// sparse objects are written out as the files they hold.
+type SFTPDestination struct {
+	client *sftp.Client
+	root   string
+}
+
+// NewSFTPDestination creates a new SFTPDestination storing files under the
+// directory root on the server client is connected to. A relative root is
+// taken from the directory the server logs in to.
+func NewSFTPDestination(client *sftp.Client, root string) *SFTPDestination {
+	return &SFTPDestination{client: client, root: path.Clean(root)}
+}
+
+// openSFTPURL opens sftp://user@host:port/path, where the path is absolute,
+// or relative to the login directory if it starts with /~/. The user
+// defaults to $SFTP_USERNAME, else the current user. The server's key must
+// be in the known-hosts parameter's file, by default ~/.ssh/known_hosts.
+// The user is authenticated with the keys of the SSH agent, the identity
+// parameter's private key or the default ones in ~/.ssh, and the password
+// in the URL or $SFTP_PASSWORD.
+func openSFTPURL(ctx context.Context, u *url.URL) (Destination, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("sftp:// URL must have a host")
+	}
+	q := u.Query()
+	if q.Get("storage-class") != "" {
+		return nil, fmt.Errorf("sftp:// destinations do not support storage classes")
+	}
+
+	name, password := os.Getenv("SFTP_USERNAME"), os.Getenv("SFTP_PASSWORD")
+	if u.User != nil {
+		name = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+	if name == "" {
+		cur, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("sftp:// URL must name a user: %w", err)
+		}
+		name = cur.Username
+	}
+	hostKeys, err := sftpHostKeys(q.Get("known-hosts"))
+	if err != nil {
+		return nil, err
+	}
+	auth, err := sftpAuth(q.Get("identity"), password)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{User: name, Auth: auth, HostKeyCallback: hostKeys})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sshClient := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("start sftp: %w", err)
+	}
+
+	root := u.Path
+	if rel, ok := strings.CutPrefix(root, "/~"); ok && (rel == "" || rel[0] == '/') {
+		root = "." + rel
+	}
+	if root == "" {
+		root = "."
+	}
+	return NewSFTPDestination(client, root), nil
+}
+
+// sftpHostKeys checks host keys against the known_hosts file at file, or
+// ~/.ssh/known_hosts if it is empty.
+func sftpHostKeys(file string) (ssh.HostKeyCallback, error) {
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("known hosts: %w", err)
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("known hosts: %w; add the server's key, as with ssh-keyscan", err)
+	}
+	return hostKeys, nil
+}
+
+// sftpAuth returns the ways to log in: with the keys of the SSH agent, if
+// one is running; the private key in identity, or else the unencrypted
+// default keys in ~/.ssh; and password, if it is set.
+func sftpAuth(identity, password string) ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			if keys, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, keys...)
+			}
+		}
+	}
+	if identity != "" {
+		pem, err := os.ReadFile(identity)
+		if err != nil {
+			return nil, fmt.Errorf("identity: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("identity %s: %w", identity, err)
+		}
+		signers = append(signers, signer)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			pem, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+			if err != nil {
+				continue
+			}
+			if signer, err := ssh.ParsePrivateKey(pem); err == nil {
+				signers = append(signers, signer) // encrypted keys are left to the agent
+			}
+		}
+	}
+
+	var auth []ssh.AuthMethod
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	return auth, nil
+}
+
+func (d *SFTPDestination) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("key %q escapes the destination directory", key)
+	}
+	return path.Join(d.root, key), nil
+}
+
+// Put writes to a temporary file in the target directory and renames it
+// into place, so a partially written file never replaces a good one.
+func (d *SFTPDestination) Put(_ context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := d.client.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	tmp := path.Join(path.Dir(p), fmt.Sprintf(".foldersync-%016x.tmp", rand.Uint64()))
+	if err := d.write(tmp, r, meta); err != nil {
+		d.client.Remove(tmp)
+		return err
+	}
+	if err := d.rename(tmp, p); err != nil {
+		d.client.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// write writes the content of the object r, described by meta, to the
+// file at p, checking it against meta.SHA256 if it is set.
+func (d *SFTPDestination) write(p string, r io.Reader, meta ObjectMeta) error {
+	dc, err := decompress(meta.Compression, r)
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+	var content io.Reader = dc
+	if meta.Sparse {
+		content = newDenseReader(dc, meta.Size)
+	}
+
+	f, err := d.client.Create(p)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(f, io.TeeReader(content, h))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); meta.SHA256 != "" && got != meta.SHA256 {
+		return fmt.Errorf("%w: hash %s, recorded %s", ErrChecksumMismatch, got, meta.SHA256)
+	}
+	mtime := meta.ModTime.Truncate(time.Second)
+	return d.client.Chtimes(p, mtime, mtime)
+}
+
+// rename renames from to to, replacing it atomically if the server
+// supports it, as OpenSSH does; otherwise to is removed first.
+func (d *SFTPDestination) rename(from, to string) error {
+	if _, ok := d.client.HasExtension("posix-rename@openssh.com"); ok {
+		return d.client.PosixRename(from, to)
+	}
+	if err := d.client.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return d.client.Rename(from, to)
+}
+
+func (d *SFTPDestination) Stat(_ context.Context, key string) (*ObjectMeta, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := d.client.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectMeta{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (d *SFTPDestination) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.Open(p)
+}
+
+// GetRange implements RangeGetter.
+func (d *SFTPDestination) GetRange(_ context.Context, key string, off, n int64) (io.ReadCloser, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := d.client.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, off, n), f}, nil
+}
+
+// List implements Destination a page of up to localListPage keys at a
+// time, reading one directory at a time.
+func (d *SFTPDestination) List(ctx context.Context, fn func(keys []string) error) error {
+	var page []string
+	err := d.walk(ctx, d.root, "", func(key string) error {
+		page = append(page, key)
+		if len(page) < localListPage {
+			return nil
+		}
+		err := fn(page)
+		page = nil
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, serr := d.client.Stat(d.root); errors.Is(serr, fs.ErrNotExist) {
+			return nil // nothing synced yet
+		}
+	}
+	if err != nil || len(page) == 0 {
+		return err
+	}
+	return fn(page)
+}
+
+// walk calls fn with the key of each file under dir, whose key starts with
+// prefix, in byte order of the keys, as walkKeys does.
+func (d *SFTPDestination) walk(ctx context.Context, dir, prefix string, fn func(key string) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries, err := d.client.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	name := func(e fs.FileInfo) string {
+		if e.IsDir() {
+			return e.Name() + "/"
+		}
+		return e.Name()
+	}
+	slices.SortFunc(entries, func(a, b fs.FileInfo) int { return strings.Compare(name(a), name(b)) })
+	for _, e := range entries {
+		var err error
+		if e.IsDir() {
+			err = d.walk(ctx, path.Join(dir, e.Name()), prefix+e.Name()+"/", fn)
+		} else {
+			err = fn(prefix + e.Name())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the file for key, then any directories left empty by it.
+func (d *SFTPDestination) Delete(_ context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := d.client.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := path.Dir(key); dir != "."; dir = path.Dir(dir) {
+		if d.client.RemoveDirectory(path.Join(d.root, dir)) != nil {
+			break // not empty
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpServer serves SFTP over SSH on a local port to user "me" with the
+// password "secret", and returns its address and a known_hosts file
+// naming its key.
+func sftpServer(t *testing.T) (addr, knownHosts string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "me" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("denied")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+
+	addr = l.Addr().String()
+	knownHosts = filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return addr, knownHosts
+}
+
+// serveSFTP serves the sftp subsystem to the sessions of an SSH connection.
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					if srv, err := sftp.NewServer(ch); err == nil {
+						srv.Serve()
+					}
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPDestination(t *testing.T) {
+	addr, knownHosts := sftpServer(t)
+	t.Setenv("HOME", t.TempDir()) // no default keys
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("SFTP_PASSWORD", "secret")
+	root := t.TempDir()
+	ctx := context.Background()
+
+	d, err := Open(ctx, "sftp://me@"+addr+root+"/backups?known-hosts="+knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := listKeys(ctx, d); err != nil || len(keys) != 0 {
+		t.Fatalf("List before anything is put = %v, %v; want nothing", keys, err)
+	}
+
+	mtime := time.Unix(1700000000, 0)
+	if err := d.Put(ctx, "docs/a.txt", strings.NewReader("hello"), ObjectMeta{Size: 5, ModTime: mtime}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "backups/docs/a.txt")); err != nil || string(data) != "hello" {
+		t.Errorf("stored %q, %v; want hello", data, err)
+	}
+	got, err := d.Stat(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.Size != 5 || !got.ModTime.Equal(mtime) {
+		t.Errorf("Stat = %+v, want 5 bytes modified at %v", got, mtime)
+	}
+	if got, err := d.Stat(ctx, "missing.txt"); got != nil || err != nil {
+		t.Errorf("Stat of a missing file = %v, %v; want nil, nil", got, err)
+	}
+
+	rc, err := d.(Getter).Get(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Get = %q, %v; want hello", data, err)
+	}
+
+	for _, key := range []string{"a-b.txt", "a/b.txt"} {
+		if err := d.Put(ctx, key, strings.NewReader(key), ObjectMeta{Size: int64(len(key))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := listKeys(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a-b.txt", "a/b.txt", "docs/a.txt"}; !slices.Equal(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	err = d.Put(ctx, "docs/a.txt", strings.NewReader("corrupt"), ObjectMeta{Size: 7, SHA256: strings.Repeat("0", 64)})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Put of content not matching its hash = %v, want ErrChecksumMismatch", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "backups/docs/a.txt")); string(data) != "hello" {
+		t.Errorf("a failed Put replaced the file with %q", data)
+	}
+
+	if err := d.Delete(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete(ctx, "docs/a.txt"); err != nil {
+		t.Errorf("Delete of a missing file = %v, want nil", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "backups/docs")); !os.IsNotExist(err) {
+		t.Errorf("the emptied directory was left: %v", err)
+	}
+	if _, err := d.Stat(ctx, "../escape.txt"); err == nil {
+		t.Error("Stat of a key outside the root succeeded")
+	}
+}
+
+func TestOpenSFTPURL_refuses(t *testing.T) {
+	addr, knownHosts := sftpServer(t)
+	_, otherKnownHosts := sftpServer(t)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SSH_AUTH_SOCK", "")
+	ctx := context.Background()
+
+	if _, err := Open(ctx, "sftp://me:secret@"+addr+"/tmp?known-hosts="+otherKnownHosts); err == nil {
+		t.Error("Open of a server whose key is not known succeeded")
+	}
+	if _, err := Open(ctx, "sftp://me:secret@"+addr+"/tmp"); err == nil {
+		t.Error("Open without a known_hosts file succeeded")
+	}
+	if _, err := Open(ctx, "sftp://me:wrong@"+addr+"/tmp?known-hosts="+knownHosts); err == nil {
+		t.Error("Open with the wrong password succeeded")
+	}
+}
@@ -0,0 +1,196 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// SnapshotPrefix holds a copy of the manifest of each run made with
+	// Options.Snapshots, named for the time it was written.
+	SnapshotPrefix = metaPrefix + "snapshots/"
+	// VersionPrefix holds the objects such runs replaced or deleted, each
+	// under its key followed by "@" and its modification time.
+	VersionPrefix = metaPrefix + "versions/"
+)
+
+// snapshotTime is the format of the times in snapshot and version keys.
+const snapshotTime = "20060102T150405Z"
+
+func snapshotKey(created time.Time) string {
+	return SnapshotPrefix + created.UTC().Format(snapshotTime) + ".json"
+}
+
+func versionKey(key string, modTime time.Time) string {
+	return VersionPrefix + key + "@" + modTime.UTC().Format(snapshotTime)
+}
+
+// checkIncremental reports whether opts.Incremental and opts.Snapshots can
+// be used with the rest of opts.
+func checkIncremental(opts Options) error {
+	if opts.Incremental {
+		switch {
+		case opts.StateCache == "":
+			return errors.New("incremental runs need a state cache")
+		case opts.ExpireAfter > 0:
+			return errors.New("incremental runs cannot leave objects to lifecycle expiry")
+		}
+	}
+	if opts.Snapshots {
+		switch {
+		case !opts.Manifest:
+			return errors.New("snapshots need a manifest")
+		case opts.Bundle != nil:
+			return errors.New("snapshots cannot be combined with bundling")
+		}
+		if _, ok := opts.Dst.(Copier); !ok {
+			return fmt.Errorf("snapshots: destination cannot copy objects: %w", errors.ErrUnsupported)
+		}
+	}
+	return nil
+}
+
+// incremental reports whether a run with opts, whose state cache is state,
+// may skip asking the destination: it is due a full run if the cache is
+// empty or the last full run was opts.FullEvery ago.
+func incremental(opts Options, state *stateCache) bool {
+	if !opts.Incremental || state == nil || state.old == nil {
+		return false
+	}
+	return opts.FullEvery <= 0 || time.Since(time.Unix(state.full, 0)) < opts.FullEvery
+}
+
+// preserveUpload copies the destination's copy of u under VersionPrefix
+// before u replaces it, if opts.Snapshots is set. In incremental runs, the
+// state cache says which copy that is; an object it does not know about is
+// not preserved.
+func preserveUpload(ctx context.Context, opts Options, plan *Plan, u File) error {
+	if !opts.Snapshots {
+		return nil
+	}
+	var old ObjectMeta
+	switch e, ok := plan.state.previous(u.Key); {
+	case u.Remote != nil:
+		old = *u.Remote
+	case plan.incremental && ok:
+		old = ObjectMeta{Size: e.Size, ModTime: time.Unix(0, e.ModTime)}
+	default:
+		return nil // nothing there
+	}
+	if old.Size == u.Size && old.ModTime.Unix() == u.ModTime.Unix() {
+		return nil // uploaded again unchanged: snapshots can use u
+	}
+	return preserve(ctx, opts, u.Key, old.ModTime)
+}
+
+// preserveDelete copies the object at key under VersionPrefix before it is
+// deleted, if opts.Snapshots is set.
+func preserveDelete(ctx context.Context, opts Options, plan *Plan, key string) error {
+	if !opts.Snapshots {
+		return nil
+	}
+	if e, ok := plan.state.previous(key); ok {
+		return preserve(ctx, opts, key, time.Unix(0, e.ModTime))
+	}
+	meta, err := opts.Dst.Stat(ctx, key)
+	if err != nil || meta == nil {
+		return err
+	}
+	return preserve(ctx, opts, key, meta.ModTime)
+}
+
+func preserve(ctx context.Context, opts Options, key string, modTime time.Time) error {
+	err := copyObject(ctx, opts.Dst, key, versionKey(key, modTime))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // already gone
+	}
+	if err != nil {
+		return fmt.Errorf("keep %s for snapshots: %w", key, err)
+	}
+	return nil
+}
+
+// ListSnapshots returns the names of the snapshots kept in dst by runs
+// with Options.Snapshots, oldest first. Each names the time, in UTC, the
+// run finished, such as "20240301T100000Z".
+func ListSnapshots(ctx context.Context, dst Destination) ([]string, error) {
+	var names []string
+	err := dst.List(ctx, func(keys []string) error {
+		for _, key := range keys {
+			name, ok := strings.CutPrefix(key, SnapshotPrefix)
+			if ok && strings.HasSuffix(name, ".json") {
+				names = append(names, strings.TrimSuffix(name, ".json"))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// snapshotAsOf returns the name of the last snapshot in dst made at or
+// before t.
+func snapshotAsOf(ctx context.Context, dst Destination, t time.Time) (string, error) {
+	names, err := ListSnapshots(ctx, dst)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range slices.Backward(names) {
+		if made, err := time.Parse(snapshotTime, name); err == nil && !made.After(t) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no snapshot made at or before %s", t.Format(time.RFC3339))
+}
+
+// snapshotKeys returns the keys holding the files of opts.Snapshot, in
+// key order, with the path each is restored to: a file's current object
+// if it is still the same version, and otherwise the copy under
+// VersionPrefix.
+func snapshotKeys(ctx context.Context, opts RestoreOptions) ([]string, map[string]string, error) {
+	data, err := readObject(ctx, opts.From, SnapshotPrefix+opts.Snapshot+".json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("snapshot %s: %w", opts.Snapshot, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, nil, fmt.Errorf("snapshot %s: %w", opts.Snapshot, err)
+	}
+	names := make(map[string]string, len(m.Files))
+	// Each entry is looked up on its own, as snapshots are made by runs
+	// that avoid listing the destination.
+	for _, e := range m.Files {
+		name, ok := opts.Keys.Path(e.Key)
+		if !ok {
+			opts.warnf("skipping %s: not a key of the layout", e.Key)
+			continue
+		}
+		if !opts.selected(name) {
+			continue
+		}
+		meta, err := opts.From.Stat(ctx, e.Key)
+		if err != nil {
+			return nil, nil, &FileError{Op: "stat", Key: e.Key, Err: err}
+		}
+		key := e.Key
+		if meta == nil || !matchesEntry(meta, e) {
+			key = versionKey(e.Key, e.ModTime)
+		}
+		names[key] = name
+	}
+	keys := make([]string, 0, len(names))
+	for key := range names {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys, names, nil
+}
@@ -0,0 +1,164 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// listCountingDest is a mockDest that counts calls to List.
+type listCountingDest struct {
+	*mockDest
+	lists int
+}
+
+func (d *listCountingDest) List(ctx context.Context, fn func(keys []string) error) error {
+	d.lists++
+	return d.mockDest.List(ctx, fn)
+}
+
+func TestSync_incremental(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+
+	dst := &listCountingDest{mockDest: newMockDest()}
+	opts := Options{
+		Src: src, Dst: dst, Delete: true,
+		StateCache:  filepath.Join(t.TempDir(), "state.json"),
+		Incremental: true,
+		FullEvery:   time.Hour,
+	}
+	// Without a cache, the first run is a full one.
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if dst.lists != 1 || len(dst.putCalls) != 2 {
+		t.Fatalf("first run: %d lists, put %v; want a full run", dst.lists, dst.putCalls)
+	}
+
+	writeFile(t, src, "b.txt", "bb")
+	writeFile(t, src, "c.txt", "c")
+	if err := os.Remove(filepath.Join(src, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if dst.lists != 1 || len(dst.statCalls) != 0 {
+		t.Errorf("incremental run: %d lists, stat %v; want neither", dst.lists, dst.statCalls)
+	}
+	slices.Sort(dst.putCalls)
+	if !slices.Equal(dst.putCalls, []string{"b.txt", "c.txt"}) {
+		t.Errorf("incremental run: put %v, want b.txt and c.txt", dst.putCalls)
+	}
+	if !slices.Equal(dst.deleteCalls, []string{"a.txt"}) {
+		t.Errorf("incremental run: deleted %v, want a.txt", dst.deleteCalls)
+	}
+
+	// Once FullEvery has passed, the destination is checked again.
+	opts.FullEvery = time.Nanosecond
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if dst.lists != 2 {
+		t.Errorf("due run: %d lists, want a full run", dst.lists)
+	}
+}
+
+func TestSync_incrementalNeedsStateCache(t *testing.T) {
+	_, err := Sync(context.Background(), Options{Src: t.TempDir(), Dst: newMockDest(), Incremental: true})
+	if err == nil {
+		t.Error("incremental run without a state cache succeeded")
+	}
+}
+
+func TestSync_snapshots(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "v1")
+	t1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), t1, t1); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMockDest()
+	opts := Options{
+		Src: src, Dst: dst, Delete: true, Manifest: true, Snapshots: true,
+		StateCache:  filepath.Join(t.TempDir(), "state.json"),
+		Incremental: true,
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	// Snapshots are named to the second.
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	writeFile(t, src, "a.txt", "v2")
+	writeFile(t, src, "b.txt", "b")
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dst.objects[versionKey("a.txt", t1)]; !ok {
+		t.Errorf("replaced a.txt not kept under %s", VersionPrefix)
+	}
+
+	names, err := ListSnapshots(context.Background(), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("snapshots = %v, want 2", names)
+	}
+
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, Snapshot: names[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "a.txt")); err != nil || string(data) != "v1" {
+		t.Errorf("first snapshot: a.txt = %q, %v; want v1", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(out, "a.txt")); err == nil && !info.ModTime().Equal(t1) {
+		t.Errorf("first snapshot: a.txt mtime = %v, want %v", info.ModTime(), t1)
+	}
+	if _, err := os.Stat(filepath.Join(out, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("first snapshot restored b.txt: %v", err)
+	}
+
+	out = t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, Snapshot: names[1]}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a.txt": "v2", "b.txt": "b"} {
+		if data, err := os.ReadFile(filepath.Join(out, name)); err != nil || string(data) != want {
+			t.Errorf("second snapshot: %s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+
+	// A point in time restores the last snapshot made at or before it.
+	made, err := time.Parse(snapshotTime, names[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, AsOf: made.Add(-time.Nanosecond)}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "a.txt")); err != nil || string(data) != "v1" {
+		t.Errorf("as of before the second snapshot: a.txt = %q, %v; want v1", data, err)
+	}
+	err = Restore(context.Background(), RestoreOptions{From: dst, To: t.TempDir(), AsOf: t1})
+	if err == nil {
+		t.Error("restore as of before any snapshot succeeded")
+	}
+}
+
+func TestSync_snapshotsNeedManifest(t *testing.T) {
+	opts := Options{Src: t.TempDir(), Dst: newMockDest(), Snapshots: true}
+	if _, err := Sync(context.Background(), opts); err == nil {
+		t.Error("snapshots without a manifest succeeded")
+	}
+}
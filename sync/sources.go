@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SourceSpec is one of the directories of a run with Options.Sources.
+type SourceSpec struct {
+	Dir    string // local directory
+	Prefix string // key prefix its files are stored under, such as "docs/"
+}
+
+// sources returns the directories opts syncs: opts.Sources, or opts.Src
+// under no prefix.
+func sources(opts Options) []SourceSpec {
+	if len(opts.Sources) > 0 {
+		return opts.Sources
+	}
+	return []SourceSpec{{Dir: opts.Src}}
+}
+
+// sourceDirs returns the directories opts syncs, for messages and
+// markers.
+func sourceDirs(opts Options) []string {
+	if opts.sourceNames != nil {
+		return opts.sourceNames
+	}
+	var dirs []string
+	for _, s := range sources(opts) {
+		dirs = append(dirs, s.Dir)
+	}
+	return dirs
+}
+
+// checkSources checks the source directories of opts, returning opts with
+// the prefixes of Sources ending in "/".
+func checkSources(opts Options) (Options, error) {
+	if len(opts.Sources) == 0 {
+		return opts, validateSrc(opts.Src)
+	}
+	if opts.Src != "" {
+		return opts, errors.New("Src and Sources cannot both be set")
+	}
+	if opts.twoWay {
+		return opts, errors.New("two-way sync cannot be combined with several sources")
+	}
+	specs := make([]SourceSpec, len(opts.Sources))
+	for i, s := range opts.Sources {
+		if err := validateSrc(s.Dir); err != nil {
+			return opts, err
+		}
+		prefix := strings.Trim(s.Prefix, "/")
+		if prefix == "" {
+			return opts, fmt.Errorf("source %s: a key prefix is needed", s.Dir)
+		}
+		prefix += "/"
+		if strings.HasPrefix(prefix, metaPrefix) {
+			return opts, fmt.Errorf("source %s: key prefix %s is reserved", s.Dir, metaPrefix)
+		}
+		for _, o := range specs[:i] {
+			if strings.HasPrefix(prefix, o.Prefix) || strings.HasPrefix(o.Prefix, prefix) {
+				return opts, fmt.Errorf("sources %s and %s: key prefixes %q and %q overlap", o.Dir, s.Dir, o.Prefix, prefix)
+			}
+		}
+		specs[i] = SourceSpec{Dir: s.Dir, Prefix: prefix}
+	}
+	opts.Sources = specs
+	return opts, nil
+}
+
+// sourceFor returns the source of opts whose files are stored under key,
+// and key without its prefix.
+func sourceFor(opts Options, key string) (SourceSpec, string, bool) {
+	for _, s := range sources(opts) {
+		if rest, ok := strings.CutPrefix(key, s.Prefix); ok {
+			return s, rest, true
+		}
+	}
+	return SourceSpec{}, "", false
+}
+
+// sourcePath returns the path of the source file stored under key, if key
+// is the key of one.
+func sourcePath(opts Options, key string) (string, bool) {
+	s, rest, ok := sourceFor(opts, key)
+	if !ok {
+		return "", false
+	}
+	rel, ok := keyMapper(opts.Keys).Path(rest)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(rel)), true
+}
@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSync_sources(t *testing.T) {
+	docs, photos := t.TempDir(), t.TempDir()
+	writeFile(t, docs, "a.txt", "a")
+	writeFile(t, docs, "scratch.tmp", "tmp")
+	writeFile(t, docs, IgnoreFile, "*.tmp\n")
+	writeFile(t, photos, "2024/b.jpg", "b")
+	writeFile(t, photos, "scratch.tmp", "kept")
+	dst := newMockDest()
+	dst.objects["docs/stale.txt"] = &ObjectMeta{Size: 1}
+	dst.objects["music/c.mp3"] = &ObjectMeta{Size: 1}
+	dst.objects["docs-old/d.txt"] = &ObjectMeta{Size: 1}
+
+	opts := Options{
+		Sources:    []SourceSpec{{Dir: docs, Prefix: "docs"}, {Dir: photos, Prefix: "photos/"}},
+		Dst:        dst,
+		Delete:     true,
+		StateCache: filepath.Join(t.TempDir(), "state.json"),
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dst.putCalls)
+	if want := []string{"docs/" + IgnoreFile, "docs/a.txt", "photos/2024/b.jpg", "photos/scratch.tmp"}; !slices.Equal(dst.putCalls, want) {
+		t.Errorf("put %v, want %v", dst.putCalls, want)
+	}
+	// Objects outside the prefixes belong to no source.
+	if !slices.Equal(dst.deleteCalls, []string{"docs/stale.txt"}) {
+		t.Errorf("deleted %v, want docs/stale.txt", dst.deleteCalls)
+	}
+
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 || len(dst.statCalls) != 0 {
+		t.Errorf("second run put %v and checked %v, want nothing", dst.putCalls, dst.statCalls)
+	}
+}
+
+func TestSync_sourcesInvalid(t *testing.T) {
+	docs := t.TempDir()
+	for _, opts := range []Options{
+		{Sources: []SourceSpec{{Dir: docs}}},
+		{Sources: []SourceSpec{{Dir: docs, Prefix: "docs"}, {Dir: t.TempDir(), Prefix: "docs/old"}}},
+		{Sources: []SourceSpec{{Dir: docs, Prefix: ".foldersync"}}},
+		{Sources: []SourceSpec{{Dir: docs, Prefix: "docs"}}, Src: docs},
+		{Sources: []SourceSpec{{Dir: filepath.Join(docs, "missing"), Prefix: "docs"}}},
+	} {
+		opts.Dst = newMockDest()
+		if _, err := Sync(context.Background(), opts); err == nil {
+			t.Errorf("Sync(%+v) = nil, want an error", opts.Sources)
+		}
+	}
+}
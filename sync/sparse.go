@@ -0,0 +1,175 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A sparse object holds only the data of a file with holes. It is a
+// sequence of extents, each an 8-byte offset and an 8-byte length, both
+// big-endian, followed by that many bytes of data. Extents are in order and
+// do not overlap; everything between them, and after the last one up to
+// the size recorded with the object, is zero. See Options.Sparse.
+
+// extent is a run of data in a sparse file.
+type extent struct {
+	off, n int64
+}
+
+// sparseBody returns the content of a sparse object for f, whose data
+// lies in extents. Like f, it can be read at any offset, so that parts of
+// it can be uploaded and retried on their own.
+func sparseBody(f *os.File, extents []extent) *io.SectionReader {
+	var parts sections
+	for _, e := range extents {
+		var hdr [16]byte
+		binary.BigEndian.PutUint64(hdr[:8], uint64(e.off))
+		binary.BigEndian.PutUint64(hdr[8:], uint64(e.n))
+		parts = append(parts, io.NewSectionReader(bytes.NewReader(hdr[:]), 0, 16), io.NewSectionReader(f, e.off, e.n))
+	}
+	return io.NewSectionReader(parts, 0, parts.size())
+}
+
+// sections reads its elements one after the other.
+type sections []*io.SectionReader
+
+func (s sections) size() int64 {
+	var n int64
+	for _, sr := range s {
+		n += sr.Size()
+	}
+	return n
+}
+
+func (s sections) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	for _, sr := range s {
+		if off >= sr.Size() {
+			off -= sr.Size()
+			continue
+		}
+		m, err := sr.ReadAt(p[n:], off)
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if n == len(p) {
+			return n, nil
+		}
+		off = 0
+	}
+	return n, io.EOF
+}
+
+// isSparse reports whether extents leave a hole in a file of size bytes.
+func isSparse(extents []extent, size int64) bool {
+	var data int64
+	for _, e := range extents {
+		data += e.n
+	}
+	return data < size
+}
+
+// sparseDecoder reads the extents of a sparse object of size bytes.
+type sparseDecoder struct {
+	r    io.Reader
+	size int64
+	end  int64 // end of the last extent read
+}
+
+// next returns the next extent, whose data follows in d.r, or io.EOF after
+// the last one.
+func (d *sparseDecoder) next() (extent, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errors.New("sparse object: truncated extent header")
+		}
+		return extent{}, err
+	}
+	e := extent{int64(binary.BigEndian.Uint64(hdr[:8])), int64(binary.BigEndian.Uint64(hdr[8:]))}
+	if e.off < d.end || e.n < 0 || e.off+e.n > d.size || e.off+e.n < e.off {
+		return extent{}, fmt.Errorf("sparse object: bad extent of %d bytes at %d", e.n, e.off)
+	}
+	d.end = e.off + e.n
+	return e, nil
+}
+
+// writeSparse writes the sparse object r, of size bytes, to f, leaving
+// holes where it has no data.
+func writeSparse(f *os.File, r io.Reader, size int64) error {
+	d := &sparseDecoder{r: r, size: size}
+	for {
+		e, err := d.next()
+		if err == io.EOF {
+			return f.Truncate(size)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(io.NewOffsetWriter(f, e.off), r, e.n); err != nil {
+			return noEOF(err)
+		}
+	}
+}
+
+// denseReader reads a sparse object as the file it holds, zeros included.
+type denseReader struct {
+	d    *sparseDecoder
+	pos  int64
+	cur  extent // the extent being read, or the zero extent past the last
+	done bool   // no extents are left
+}
+
+// newDenseReader returns a reader of the file held by the sparse object r,
+// of size bytes.
+func newDenseReader(r io.Reader, size int64) io.Reader {
+	return &denseReader{d: &sparseDecoder{r: r, size: size}}
+}
+
+func (r *denseReader) Read(p []byte) (int, error) {
+	for !r.done && r.pos >= r.cur.off+r.cur.n {
+		e, err := r.d.next()
+		if err == io.EOF {
+			r.done = true
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.cur = e
+	}
+	if r.pos >= r.d.size {
+		return 0, io.EOF
+	}
+	if r.done || r.pos < r.cur.off {
+		// In a hole, up to the next extent or the end of the file.
+		end := r.d.size
+		if !r.done {
+			end = r.cur.off
+		}
+		p = p[:min(int64(len(p)), end-r.pos)]
+		clear(p)
+		r.pos += int64(len(p))
+		return len(p), nil
+	}
+	p = p[:min(int64(len(p)), r.cur.off+r.cur.n-r.pos)]
+	n, err := r.d.r.Read(p)
+	r.pos += int64(n)
+	if n > 0 {
+		return n, nil
+	}
+	return 0, noEOF(err)
+}
+
+// noEOF reports an early end of a sparse object as an error.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return errors.New("sparse object: truncated extent")
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSparseFile writes a file of size bytes to dir holding data at each
+// offset in data, and skips the test if the filesystem leaves no holes.
+func writeSparseFile(t *testing.T, dir, name string, size int64, data map[int64]string) []byte {
+	t.Helper()
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := make([]byte, size)
+	for off, s := range data {
+		if _, err := f.WriteAt([]byte(s), off); err != nil {
+			t.Fatal(err)
+		}
+		copy(want[off:], s)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	extents, err := dataExtents(f, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSparse(extents, size) {
+		t.Skip("no holes reported for files in", dir)
+	}
+	return want
+}
+
+func TestSync_sparse(t *testing.T) {
+	src := t.TempDir()
+	const size = 8 << 20
+	want := writeSparseFile(t, src, "disk.img", size, map[int64]string{0: "boot", 4 << 20: "data", size - 3: "end"})
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Sparse: true}); err != nil {
+		t.Fatal(err)
+	}
+	meta := dst.objects["disk.img"]
+	if !meta.Sparse || meta.Size != size || len(dst.data["disk.img"]) >= 1<<20 {
+		t.Fatalf("stored %d bytes with %+v, want a sparse object for %d bytes", len(dst.data["disk.img"]), meta, size)
+	}
+
+	// The next run compares the file's own size.
+	dst.putCalls = nil
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Sparse: true, Compare: ChecksumComparer{}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("second run put %v, want nothing", dst.putCalls)
+	}
+
+	to := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: to}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(to, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("restored file differs from the source")
+	}
+	extents, err := dataExtents(f, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSparse(extents, size) {
+		t.Error("restored file has no holes")
+	}
+}
+
+func TestDenseReader(t *testing.T) {
+	src := t.TempDir()
+	want := writeSparseFile(t, src, "a", 1<<20, map[int64]string{100 << 10: "x", 900 << 10: "yz"})
+	f, err := os.Open(filepath.Join(src, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	extents, err := dataExtents(f, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj bytes.Buffer
+	if _, err := io.Copy(&obj, sparseBody(f, extents)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(newDenseReader(bytes.NewReader(obj.Bytes()), 1<<20))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, err %v; want the file back", len(got), err)
+	}
+	truncated := obj.Bytes()[:obj.Len()-1]
+	if _, err := io.ReadAll(newDenseReader(bytes.NewReader(truncated), 1<<20)); err == nil {
+		t.Error("truncated object read without error")
+	}
+}
+
+func TestSparseBody_readAt(t *testing.T) {
+	src := t.TempDir()
+	writeSparseFile(t, src, "a", 1<<20, map[int64]string{100 << 10: "x", 900 << 10: "yz"})
+	f, err := os.Open(filepath.Join(src, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	extents, err := dataExtents(f, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := sparseBody(f, extents)
+	want, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(want)) != body.Size() {
+		t.Fatalf("read %d bytes, Size = %d", len(want), body.Size())
+	}
+
+	// Parts of any size read the same as the whole, across extents.
+	for _, n := range []int{7, 4096, 5 << 10} {
+		var got []byte
+		part := make([]byte, n)
+		for off := int64(0); off < body.Size(); off += int64(n) {
+			m, err := body.ReadAt(part, off)
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+			got = append(got, part[:m]...)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("parts of %d bytes: read %d bytes, want the %d of the whole", n, len(got), len(want))
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd
+
+package sync
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataExtents returns where the data of f, of size bytes, lies, skipping
+// the holes the filesystem reports.
+func dataExtents(f *os.File, size int64) ([]extent, error) {
+	var extents []extent
+	for off := int64(0); off < size; {
+		start, err := f.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			break // a hole up to the end
+		}
+		if errors.Is(err, unix.EINVAL) {
+			return []extent{{0, size}}, nil // holes are not reported
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := f.Seek(start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, size)
+		if start >= end {
+			break
+		}
+		extents = append(extents, extent{start, end - start})
+		off = end
+	}
+	_, err := f.Seek(0, 0)
+	return extents, err
+}
@@ -0,0 +1,309 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshotter takes read-only snapshots of the filesystems source
+// directories are on, so that a run reads every file as it was at a
+// single moment, as a crash would have left it, rather than each as it
+// was when the run reached it. See Options.SourceSnapshot.
+type Snapshotter interface {
+	// Snapshot snapshots the filesystem holding dir, an absolute path, and
+	// returns the directory dir is found at in the snapshot, and a
+	// function that deletes the snapshot once the run is done with it.
+	Snapshot(ctx context.Context, dir string) (snapDir string, release func(context.Context) error, err error)
+}
+
+// ParseSnapshotter returns the Snapshotter named by s: "zfs", "lvm" or
+// "vss". lvmSize is the LVMSnapshotter's Size, and may only be set for
+// "lvm".
+func ParseSnapshotter(s, lvmSize string) (Snapshotter, error) {
+	if lvmSize != "" && s != "lvm" {
+		return nil, errors.New("an LVM snapshot size needs LVM snapshots")
+	}
+	switch s {
+	case "zfs":
+		return ZFSSnapshotter{}, nil
+	case "lvm":
+		if err := CheckLVMSnapshotSize(lvmSize); err != nil {
+			return nil, err
+		}
+		return LVMSnapshotter{Size: lvmSize}, nil
+	case "vss":
+		return VSSSnapshotter{}, nil
+	}
+	return nil, fmt.Errorf("source snapshot %q: want zfs, lvm or vss", s)
+}
+
+var lvmSize = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmMgGtT]?$`)
+
+// CheckLVMSnapshotSize checks size is empty or a size lvcreate takes, such
+// as 512M or 2G.
+func CheckLVMSnapshotSize(size string) error {
+	if size != "" && !lvmSize.MatchString(size) {
+		return fmt.Errorf("LVM snapshot size %q: want a number with an optional K, M, G or T suffix, like 2G", size)
+	}
+	return nil
+}
+
+// snapshotCommand runs a command of a Snapshotter and returns its output.
+// Tests replace it.
+var snapshotCommand = func(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if ee := (*exec.ExitError)(nil); errors.As(err, &ee) && len(ee.Stderr) > 0 {
+		return "", fmt.Errorf("%s %s: %s", name, args[0], strings.TrimSpace(string(ee.Stderr)))
+	} else if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return string(out), nil
+}
+
+// snapshotSeq tells apart the snapshots taken in the same second.
+var snapshotSeq atomic.Int64
+
+// snapshotName returns a name for a new snapshot, which ZFS, LVM and
+// anyone listing snapshots can tell as foldersync's.
+func snapshotName() string {
+	return fmt.Sprintf("foldersync-%s-%d-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid(), snapshotSeq.Add(1))
+}
+
+// within reports whether the absolute path dir is mount or below it.
+func within(dir, mount string) bool {
+	return dir == mount || strings.HasPrefix(dir, strings.TrimSuffix(mount, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// mountEscape matches the escapes findmnt -r and mount -p write in place
+// of spaces and other characters in mount points.
+var mountEscape = regexp.MustCompile(`\\x[0-9a-fA-F]{2}|\\[0-7]{3}`)
+
+// checkNoMountsBelow fails if a filesystem is mounted below dir. A
+// snapshot of the filesystem holding dir has only the empty directory it
+// is mounted on, so the run would find none of its files, and with
+// Delete delete all of their objects. It runs findmnt on Linux, and
+// mount elsewhere.
+func checkNoMountsBelow(ctx context.Context, dir string) error {
+	name, args, field := "mount", []string{"-p"}, 1
+	if runtime.GOOS == "linux" {
+		name, args, field = "findmnt", []string{"-rn", "-o", "TARGET"}, 0
+	}
+	out, err := snapshotCommand(ctx, name, args...)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) <= field {
+			continue
+		}
+		mount := mountEscape.ReplaceAllStringFunc(fields[field], unescapeMount)
+		if mount != dir && within(mount, dir) {
+			return fmt.Errorf("%s is mounted below %s, and its files would be missing from the snapshot; sync it as a source of its own", mount, dir)
+		}
+	}
+	return nil
+}
+
+// unescapeMount decodes an escape mountEscape matches, \x20 or \040.
+func unescapeMount(esc string) string {
+	base, digits := 8, esc[1:]
+	if digits[0] == 'x' {
+		base, digits = 16, digits[1:]
+	}
+	n, _ := strconv.ParseUint(digits, base, 8)
+	return string([]byte{byte(n)})
+}
+
+// ZFSSnapshotter snapshots the ZFS dataset holding a source directory and
+// reads it through the dataset's .zfs/snapshot directory. It runs zfs,
+// which needs root or the snapshot, mount and destroy permissions
+// delegated with "zfs allow". Directories with datasets or other
+// filesystems mounted below them are refused; see checkNoMountsBelow.
+type ZFSSnapshotter struct{}
+
+func (ZFSSnapshotter) Snapshot(ctx context.Context, dir string) (string, func(context.Context) error, error) {
+	if err := checkNoMountsBelow(ctx, dir); err != nil {
+		return "", nil, err
+	}
+	out, err := snapshotCommand(ctx, "zfs", "list", "-H", "-t", "filesystem", "-o", "name,mountpoint")
+	if err != nil {
+		return "", nil, err
+	}
+	var dataset, mount string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		name, mp, ok := strings.Cut(line, "\t")
+		if ok && filepath.IsAbs(mp) && within(dir, mp) && len(mp) > len(mount) {
+			dataset, mount = name, mp
+		}
+	}
+	if dataset == "" {
+		return "", nil, fmt.Errorf("%s is not on a mounted ZFS dataset", dir)
+	}
+	snap := snapshotName()
+	if _, err := snapshotCommand(ctx, "zfs", "snapshot", dataset+"@"+snap); err != nil {
+		return "", nil, err
+	}
+	release := func(ctx context.Context) error {
+		_, err := snapshotCommand(ctx, "zfs", "destroy", dataset+"@"+snap)
+		return err
+	}
+	rel, _ := filepath.Rel(mount, dir)
+	return filepath.Join(mount, ".zfs", "snapshot", snap, rel), release, nil
+}
+
+// LVMSnapshotter snapshots the LVM logical volume holding a source
+// directory and mounts the snapshot read-only under a temporary
+// directory. It runs findmnt, lvs, lvcreate, mount, umount and lvremove,
+// which need root. Directories with other filesystems mounted below them
+// are refused; see checkNoMountsBelow.
+type LVMSnapshotter struct {
+	// Size is the space the volume group sets aside for the blocks changed
+	// while the snapshot exists, as lvcreate's --size takes it. A run
+	// whose snapshot fills up fails. The default is 1G.
+	Size string
+}
+
+func (l LVMSnapshotter) Snapshot(ctx context.Context, dir string) (string, func(context.Context) error, error) {
+	if err := checkNoMountsBelow(ctx, dir); err != nil {
+		return "", nil, err
+	}
+	out, err := snapshotCommand(ctx, "findmnt", "-n", "-o", "SOURCE,TARGET,FSTYPE", "--target", dir)
+	if err != nil {
+		return "", nil, err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return "", nil, fmt.Errorf("findmnt: unexpected output %q", out)
+	}
+	device, mount, fsType := fields[0], fields[1], fields[2]
+	if out, err = snapshotCommand(ctx, "lvs", "--noheadings", "-o", "vg_name,lv_name", device); err != nil {
+		return "", nil, fmt.Errorf("%s is not on an LVM logical volume: %w", dir, err)
+	}
+	fields = strings.Fields(out)
+	if len(fields) != 2 {
+		return "", nil, fmt.Errorf("lvs: unexpected output %q", out)
+	}
+	vg, snap := fields[0], snapshotName()
+	size := l.Size
+	if size == "" {
+		size = "1G"
+	}
+	if _, err := snapshotCommand(ctx, "lvcreate", "--snapshot", "--size", size, "--name", snap, vg+"/"+fields[1]); err != nil {
+		return "", nil, err
+	}
+	remove := func(ctx context.Context) error {
+		_, err := snapshotCommand(ctx, "lvremove", "-f", vg+"/"+snap)
+		return err
+	}
+	mnt, err := os.MkdirTemp("", "foldersync-snapshot-")
+	if err != nil {
+		return "", nil, errors.Join(err, remove(ctx))
+	}
+	mountOpts := "ro"
+	if fsType == "xfs" {
+		// XFS refuses to mount a second filesystem with the same UUID.
+		mountOpts += ",nouuid"
+	}
+	if _, err := snapshotCommand(ctx, "mount", "-o", mountOpts, "/dev/"+vg+"/"+snap, mnt); err != nil {
+		return "", nil, errors.Join(err, remove(ctx), os.Remove(mnt))
+	}
+	release := func(ctx context.Context) error {
+		if _, err := snapshotCommand(ctx, "umount", mnt); err != nil {
+			return err
+		}
+		return errors.Join(remove(ctx), os.Remove(mnt))
+	}
+	rel, _ := filepath.Rel(mount, dir)
+	return filepath.Join(mnt, rel), release, nil
+}
+
+// VSSSnapshotter takes a Volume Shadow Copy of the Windows volume holding
+// a source directory and reads it through the shadow copy's device. It
+// runs PowerShell, which must be elevated.
+type VSSSnapshotter struct{}
+
+func (VSSSnapshotter) Snapshot(ctx context.Context, dir string) (string, func(context.Context) error, error) {
+	if runtime.GOOS != "windows" {
+		return "", nil, fmt.Errorf("Volume Shadow Copies are only taken on Windows: %w", errors.ErrUnsupported)
+	}
+	volume := filepath.VolumeName(dir)
+	if len(volume) != 2 || volume[1] != ':' {
+		return "", nil, fmt.Errorf("%s is not on a drive with a letter", dir)
+	}
+	out, err := powerShell(ctx, `$r = Invoke-CimMethod -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{Volume='`+volume+`\'; Context='ClientAccessible'}
+if ($r.ReturnValue -ne 0) { throw "Win32_ShadowCopy.Create returned $($r.ReturnValue)" }
+$s = Get-CimInstance -ClassName Win32_ShadowCopy -Filter "ID='$($r.ShadowID)'"
+$s.ID
+$s.DeviceObject`)
+	if err != nil {
+		return "", nil, err
+	}
+	lines := strings.Fields(out)
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], `\\?\GLOBALROOT\`) {
+		return "", nil, fmt.Errorf("shadow copy: unexpected output %q", out)
+	}
+	id, device := lines[0], lines[1]
+	release := func(ctx context.Context) error {
+		_, err := powerShell(ctx, `Get-CimInstance -ClassName Win32_ShadowCopy -Filter "ID='`+id+`'" | Remove-CimInstance`)
+		return err
+	}
+	return device + dir[len(volume):], release, nil
+}
+
+func powerShell(ctx context.Context, script string) (string, error) {
+	return snapshotCommand(ctx, "powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script)
+}
+
+// snapshotSources returns opts reading each source directory from a
+// snapshot opts.SourceSnapshot takes of it, and a function deleting the
+// snapshots, which warns of those it cannot delete. Dry runs read the
+// source directories themselves.
+func snapshotSources(ctx context.Context, opts Options) (Options, func(), error) {
+	if opts.SourceSnapshot == nil || opts.DryRun {
+		return opts, func() {}, nil
+	}
+	var releases []func(context.Context) error
+	releaseAll := func() {
+		for _, release := range slices.Backward(releases) {
+			// Even if the run was canceled.
+			if err := release(context.WithoutCancel(ctx)); err != nil {
+				opts.warnf("delete source snapshot: %v", err)
+			}
+		}
+	}
+	specs := slices.Clone(sources(opts))
+	for i, s := range specs {
+		dir, err := filepath.Abs(s.Dir)
+		if err == nil {
+			dir, err = filepath.EvalSymlinks(dir)
+		}
+		var release func(context.Context) error
+		if err == nil {
+			specs[i].Dir, release, err = opts.SourceSnapshot.Snapshot(ctx, dir)
+		}
+		if err != nil {
+			releaseAll()
+			return opts, nil, fmt.Errorf("snapshot %s: %w", s.Dir, err)
+		}
+		releases = append(releases, release)
+	}
+	opts.sourceNames = sourceDirs(opts)
+	if len(opts.Sources) > 0 {
+		opts.Sources = specs
+	} else {
+		opts.Src = specs[0].Dir
+	}
+	return opts, releaseAll, nil
+}
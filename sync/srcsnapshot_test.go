@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeCommands replaces snapshotCommand for the test, answering each
+// command from out by its name and first argument, and returns the
+// commands run.
+func fakeCommands(t *testing.T, out map[string]string) *[]string {
+	t.Helper()
+	var ran []string
+	old := snapshotCommand
+	snapshotCommand = func(ctx context.Context, name string, args ...string) (string, error) {
+		ran = append(ran, name+" "+strings.Join(args, " "))
+		if o, ok := out[name+" "+args[0]]; ok {
+			return o, nil
+		}
+		if name == "mount" || name == "umount" || name == "lvcreate" || name == "lvremove" || args[0] == "snapshot" || args[0] == "destroy" {
+			return "", nil
+		}
+		return "", errors.New("unexpected command")
+	}
+	t.Cleanup(func() { snapshotCommand = old })
+	return &ran
+}
+
+// mounts answers checkNoMountsBelow, as findmnt on Linux and mount
+// elsewhere, with the root filesystem and those mounted at targets.
+func mounts(out map[string]string, targets ...string) map[string]string {
+	out["findmnt -rn"], out["mount -p"] = "/\n", "/dev/ada0p2\t/\tufs\trw\t1\t1\n"
+	for _, target := range targets {
+		out["findmnt -rn"] += strings.ReplaceAll(target, " ", `\x20`) + "\n"
+		out["mount -p"] += "tank/x\t" + strings.ReplaceAll(target, " ", `\040`) + "\tzfs\trw\t0\t0\n"
+	}
+	return out
+}
+
+func TestZFSSnapshotter(t *testing.T) {
+	ran := fakeCommands(t, mounts(map[string]string{
+		"zfs list": "tank\t/tank\ntank/home\t/home\ntank/old\tlegacy\ntank/homes\t/homes\n",
+	}, "/tank", "/home", "/homes", "/home/ana/docs"))
+	dir, release, err := ZFSSnapshotter{}.Snapshot(context.Background(), "/home/ana/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, _ := strings.CutPrefix((*ran)[2], "zfs snapshot tank/home@")
+	if !strings.HasPrefix(snap, "foldersync-") || dir != "/home/.zfs/snapshot/"+snap+"/ana/docs" {
+		t.Errorf("snapshot %q read at %s, want the innermost dataset's", (*ran)[2], dir)
+	}
+	if err := release(context.Background()); err != nil || (*ran)[3] != "zfs destroy tank/home@"+snap {
+		t.Errorf("release ran %q, %v; want the snapshot destroyed", (*ran)[3], err)
+	}
+
+	if _, _, err := (ZFSSnapshotter{}).Snapshot(context.Background(), "/srv/data"); err == nil {
+		t.Error("directory on no dataset: got no error")
+	}
+}
+
+func TestLVMSnapshotter(t *testing.T) {
+	ran := fakeCommands(t, mounts(map[string]string{
+		"findmnt -n":       "/dev/mapper/vg0-data /srv xfs\n",
+		"lvs --noheadings": "  vg0 data\n",
+	}, "/srv", "/srv/www-old/cache"))
+	dir, release, err := LVMSnapshotter{Size: "5G"}.Snapshot(context.Background(), "/srv/www")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := strings.Fields((*ran)[3])
+	snap := create[len(create)-2]
+	want := "lvcreate --snapshot --size 5G --name " + snap + " vg0/data"
+	if (*ran)[3] != want {
+		t.Errorf("ran %q, want %q", (*ran)[3], want)
+	}
+	mnt := filepath.Dir(dir)
+	if filepath.Base(dir) != "www" || (*ran)[4] != "mount -o ro,nouuid /dev/vg0/"+snap+" "+mnt {
+		t.Errorf("read at %s after %q, want the snapshot mounted read-only", dir, (*ran)[4])
+	}
+	if err := release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := (*ran)[5:]; !slices.Equal(got, []string{"umount " + mnt, "lvremove -f vg0/" + snap}) {
+		t.Errorf("release ran %q, want the snapshot unmounted and removed", got)
+	}
+	if _, err := os.Stat(mnt); !os.IsNotExist(err) {
+		t.Errorf("mount point left behind: %v", err)
+	}
+}
+
+func TestSnapshotters_nestedMount(t *testing.T) {
+	for _, s := range []Snapshotter{ZFSSnapshotter{}, LVMSnapshotter{}} {
+		ran := fakeCommands(t, mounts(map[string]string{
+			"zfs list":         "tank/home\t/home\ntank/home/ana/media\t/home/ana/my media\n",
+			"findmnt -n":       "/dev/mapper/vg0-home /home ext4\n",
+			"lvs --noheadings": "  vg0 home\n",
+		}, "/home", "/home/ana/my media"))
+		_, _, err := s.Snapshot(context.Background(), "/home/ana")
+		if err == nil || !strings.Contains(err.Error(), "/home/ana/my media is mounted below /home/ana") {
+			t.Errorf("%T.Snapshot with a filesystem mounted below = %v, want it refused", s, err)
+		}
+		if len(*ran) != 1 {
+			t.Errorf("%T ran %q, want nothing after finding the mount", s, *ran)
+		}
+	}
+}
+
+// dirSnapshotter snapshots a directory by copying it.
+type dirSnapshotter struct {
+	taken, released []string
+}
+
+func (s *dirSnapshotter) Snapshot(ctx context.Context, dir string) (string, func(context.Context) error, error) {
+	snap, err := os.MkdirTemp("", "snapshot-")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.CopyFS(snap, os.DirFS(dir)); err != nil {
+		return "", nil, err
+	}
+	s.taken = append(s.taken, dir)
+	return snap, func(context.Context) error {
+		s.released = append(s.released, dir)
+		return os.RemoveAll(snap)
+	}, nil
+}
+
+func TestSync_sourceSnapshot(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "dir/b.txt", "bravo")
+	dst := newMockDest()
+	snap := &dirSnapshotter{}
+	res, err := Sync(context.Background(), Options{Src: src, Dst: dst, SourceSnapshot: snap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(maps.Keys(dst.objects)); !slices.Equal(got, []string{"a.txt", "dir/b.txt"}) {
+		t.Errorf("uploaded %v, want the keys of the source itself", got)
+	}
+	real, _ := filepath.EvalSymlinks(src)
+	if !slices.Equal(snap.taken, []string{real}) || !slices.Equal(snap.released, snap.taken) {
+		t.Errorf("snapshots taken of %v, released %v; want one of %s, released", snap.taken, snap.released, real)
+	}
+	if res.Summary.Src != src {
+		t.Errorf("summary names %s, want the source directory", res.Summary.Src)
+	}
+
+	// Dry runs do not snapshot.
+	snap = &dirSnapshotter{}
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: newMockDest(), SourceSnapshot: snap, DryRun: true}); err != nil || len(snap.taken) != 0 {
+		t.Errorf("dry run: %v, snapshots %v; want none", err, snap.taken)
+	}
+}
@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StagingPrefix is where Options.StageUploads uploads each file before
+// moving it to its key.
+const StagingPrefix = metaPrefix + "staging/"
+
+// checkStaging reports whether opts.StageUploads can be used with Dst and
+// the rest of opts.
+func checkStaging(opts Options) error {
+	if !opts.StageUploads {
+		return nil
+	}
+	if opts.ObjectLock != nil {
+		return errors.New("staged uploads cannot be combined with object lock, which would lock the staged objects")
+	}
+	_, renames := opts.Dst.(Renamer)
+	if _, copies := opts.Dst.(Copier); !renames && !copies {
+		return fmt.Errorf("staged uploads: destination cannot move objects: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// putStaged uploads body for key under StagingPrefix, checks it there with
+// verifyUpload, and then moves it to key. A staged object that fails the
+// check is deleted, leaving the object at key as it was.
+func putStaged(ctx context.Context, opts Options, key string, body io.Reader, meta ObjectMeta) error {
+	staged := StagingPrefix + key
+	if err := opts.Dst.Put(ctx, staged, body, meta); err != nil {
+		return err
+	}
+	if err := verifyUpload(ctx, opts, staged, meta); err != nil {
+		if derr := opts.Dst.Delete(ctx, staged); derr != nil {
+			return fmt.Errorf("%w; delete %s: %v", err, staged, derr)
+		}
+		return err
+	}
+	if err := renameObject(ctx, opts.Dst, staged, key); err != nil {
+		return fmt.Errorf("move %s into place: %w", staged, err)
+	}
+	return nil
+}
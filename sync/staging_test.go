@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// shortStagedDest reports staged objects a byte shorter than they are, as
+// an upload cut short would leave them.
+type shortStagedDest struct {
+	*mockDest
+}
+
+func (d shortStagedDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	meta, err := d.mockDest.Stat(ctx, key)
+	if meta != nil && strings.HasPrefix(key, StagingPrefix) {
+		short := *meta
+		short.Size--
+		return &short, nil
+	}
+	return meta, err
+}
+
+func TestSync_stageUploads(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "new")
+	ctx := context.Background()
+
+	// Destinations that cannot rename copy the staged object into place.
+	dst := newMockDest()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, StageUploads: true, VerifyAfterUpload: true}); err != nil {
+		t.Fatal(err)
+	}
+	staged := StagingPrefix + "a.txt"
+	if !slices.Equal(dst.putCalls, []string{staged}) || !slices.Equal(dst.copyCalls, []string{staged}) {
+		t.Errorf("put %v and copied %v, want the staged object", dst.putCalls, dst.copyCalls)
+	}
+	if keys := slices.Sorted(maps.Keys(dst.objects)); !slices.Equal(keys, []string{"a.txt"}) {
+		t.Errorf("destination holds %v, want only a.txt", keys)
+	}
+
+	// One that fails the check is deleted, leaving the old object.
+	writeFile(t, src, "a.txt", "newer")
+	_, err := Sync(ctx, Options{Src: src, Dst: shortStagedDest{dst}, StageUploads: true, VerifyAfterUpload: true})
+	if !errors.Is(err, ErrUploadMismatch) {
+		t.Fatalf("Sync = %v, want ErrUploadMismatch", err)
+	}
+	if keys := slices.Sorted(maps.Keys(dst.objects)); !slices.Equal(keys, []string{"a.txt"}) || string(dst.data["a.txt"]) != "new" {
+		t.Errorf("destination holds %v, a.txt %q; want the old a.txt alone", keys, dst.data["a.txt"])
+	}
+
+	// Local directories rename it.
+	dir := t.TempDir()
+	if _, err := Sync(ctx, Options{Src: src, Dst: NewLocalDestination(dir), StageUploads: true}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(data) != "newer" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, filepath.FromSlash(StagingPrefix))); len(entries) != 0 {
+		t.Errorf("left %v staged", entries)
+	}
+
+	lock := &ObjectLock{Mode: "GOVERNANCE"}
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, StageUploads: true, ObjectLock: lock}); err == nil {
+		t.Error("staged uploads with object lock: Sync succeeded")
+	}
+}
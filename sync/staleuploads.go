@@ -0,0 +1,58 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PendingUpload is a multipart upload a destination has started and that
+// has been neither completed nor aborted. Its parts are stored, and
+// billed, until it is.
+type PendingUpload struct {
+	Key     string
+	ID      string
+	Started time.Time
+}
+
+// UploadAborter is implemented by destinations that upload files in parts
+// kept until the upload completes or is aborted, such as S3.
+type UploadAborter interface {
+	// ListUploads returns the multipart uploads pending under the
+	// destination's prefix.
+	ListUploads(ctx context.Context) ([]PendingUpload, error)
+	// AbortUpload aborts u, deleting its parts.
+	AbortUpload(ctx context.Context, u PendingUpload) error
+}
+
+// AbortStaleUploads aborts the multipart uploads pending at dst that were
+// started more than olderThan before now, such as those of runs that were
+// killed, and returns them. With dryRun, it only returns them. It fails
+// with errors.ErrUnsupported if dst does not upload in parts.
+func AbortStaleUploads(ctx context.Context, dst Destination, olderThan time.Duration, dryRun bool) ([]PendingUpload, error) {
+	a, ok := dst.(UploadAborter)
+	if !ok {
+		return nil, fmt.Errorf("abort uploads: destination keeps no multipart uploads: %w", errors.ErrUnsupported)
+	}
+	pending, err := a.ListUploads(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var stale []PendingUpload
+	for _, u := range pending {
+		if u.Started.Before(cutoff) {
+			stale = append(stale, u)
+		}
+	}
+	if dryRun {
+		return stale, nil
+	}
+	for i, u := range stale {
+		if err := a.AbortUpload(ctx, u); err != nil {
+			return stale[:i], fmt.Errorf("abort upload of %s: %w", u.Key, err)
+		}
+	}
+	return stale, nil
+}
@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// uploadsDest is a mockDest with pending multipart uploads.
+type uploadsDest struct {
+	*mockDest
+	pending []PendingUpload
+	aborted []string
+}
+
+func (d *uploadsDest) ListUploads(context.Context) ([]PendingUpload, error) {
+	return d.pending, nil
+}
+
+func (d *uploadsDest) AbortUpload(_ context.Context, u PendingUpload) error {
+	d.aborted = append(d.aborted, u.ID)
+	return nil
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	now := time.Now()
+	dst := &uploadsDest{mockDest: newMockDest(), pending: []PendingUpload{
+		{Key: "a.bin", ID: "1", Started: now.Add(-72 * time.Hour)},
+		{Key: "b.bin", ID: "2", Started: now.Add(-time.Hour)},
+		{Key: "a.bin", ID: "3", Started: now.Add(-30 * time.Hour)},
+	}}
+	ctx := context.Background()
+
+	stale, err := AbortStaleUploads(ctx, dst, 24*time.Hour, true)
+	if err != nil || len(stale) != 2 || len(dst.aborted) != 0 {
+		t.Errorf("dry run: %+v, %v, aborted %v; want two uploads and none aborted", stale, err, dst.aborted)
+	}
+	if _, err := AbortStaleUploads(ctx, dst, 24*time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1", "3"}; !slices.Equal(dst.aborted, want) {
+		t.Errorf("aborted %v, want %v", dst.aborted, want)
+	}
+	if _, err := AbortStaleUploads(ctx, newMockDest(), 0, false); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("destination without multipart uploads: got %v, want errors.ErrUnsupported", err)
+	}
+}
+
+func TestS3Destination_ListUploads(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	var markers []string
+	fake := func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fake",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				p, ok := in.Parameters.(*s3.ListMultipartUploadsInput)
+				if !ok {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("unexpected %T", in.Parameters)
+				}
+				if aws.ToString(p.Prefix) != "backups/" {
+					return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("prefix %q", aws.ToString(p.Prefix))
+				}
+				markers = append(markers, aws.ToString(p.KeyMarker))
+				out := &s3.ListMultipartUploadsOutput{Uploads: []types.MultipartUpload{
+					{Key: aws.String("backups/a.bin"), UploadId: aws.String("1"), Initiated: aws.Time(started)},
+				}}
+				if p.KeyMarker == nil {
+					out.IsTruncated, out.NextKeyMarker, out.NextUploadIdMarker = aws.Bool(true), aws.String("backups/a.bin"), aws.String("1")
+					out.Uploads[0].Key = aws.String("backups/0.bin")
+				}
+				return middleware.InitializeOutput{Result: out}, middleware.Metadata{}, nil
+			}), middleware.Before)
+	}
+	cfg := aws.Config{Region: "eu-west-1", Credentials: aws.AnonymousCredentials{}}
+	d := NewS3DestinationFromConfig(cfg, "bucket", "backups", WithS3Middleware(fake))
+
+	pending, err := d.ListUploads(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PendingUpload{{Key: "0.bin", ID: "1", Started: started}, {Key: "a.bin", ID: "1", Started: started}}
+	if !slices.Equal(pending, want) || !slices.Equal(markers, []string{"", "backups/a.bin"}) {
+		t.Errorf("listed %+v with markers %q, want %+v over two pages", pending, markers, want)
+	}
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	stdsync "sync"
+	"time"
+)
+
+// ErrUploadStalled is returned for an upload that read nothing of its
+// file for Options.StallTimeout, once it has been retried stallRetries
+// times.
+var ErrUploadStalled = errors.New("upload stalled")
+
+// ErrFileTimeout is returned for an upload that took longer than
+// Options.PerFileTimeout.
+var ErrFileTimeout = errors.New("upload timed out")
+
+// stallRetries is how many more times a stalled upload is retried.
+const stallRetries = 2
+
+// uploadUnstalled uploads u as upload does, and uploads it again if the
+// upload stalls, up to stallRetries times.
+func uploadUnstalled(ctx context.Context, opts Options, u File, hash bool) error {
+	for retry := 0; ; retry++ {
+		err := upload(ctx, opts, u, hash)
+		if !errors.Is(err, ErrUploadStalled) || retry == stallRetries || ctx.Err() != nil {
+			return err
+		}
+		opts.warnf("%s: %v; retrying", u.Key, err)
+	}
+}
+
+// watchUpload returns ctx, canceled if the upload of key takes longer than
+// opts.PerFileTimeout or reads nothing of body for opts.StallTimeout, and
+// body, counting its reads. stop releases the timers, and returns the
+// error the upload failed with, err, wrapping the reason it was canceled,
+// if it was.
+func (opts Options) watchUpload(ctx context.Context, body uploadBody) (context.Context, uploadBody, func(err error) error) {
+	if opts.PerFileTimeout <= 0 && opts.StallTimeout <= 0 {
+		return ctx, body, func(err error) error { return err }
+	}
+	parent := ctx
+	var cancelTimeout context.CancelFunc = func() {}
+	if opts.PerFileTimeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, opts.PerFileTimeout,
+			fmt.Errorf("%w after %s", ErrFileTimeout, opts.PerFileTimeout))
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &stallBody{uploadBody: body}
+	if opts.StallTimeout > 0 {
+		w.timeout = opts.StallTimeout
+		w.timer = time.AfterFunc(opts.StallTimeout, func() {
+			cancel(fmt.Errorf("%w: nothing read for %s", ErrUploadStalled, opts.StallTimeout))
+		})
+	}
+	stop := func(err error) error {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		cause := context.Cause(ctx)
+		cancel(nil)
+		cancelTimeout()
+		if err != nil && parent.Err() == nil && (errors.Is(cause, ErrUploadStalled) || errors.Is(cause, ErrFileTimeout)) {
+			return cause
+		}
+		return err
+	}
+	return ctx, w, stop
+}
+
+// stallBody restarts the stall timer of an upload whenever its body is
+// read, and stops it once the body has been read to its end: waiting for
+// the destination to answer then is left to the per-file timeout.
+type stallBody struct {
+	uploadBody
+	timeout time.Duration
+
+	mu    stdsync.Mutex
+	timer *time.Timer // nil without a stall timeout
+	done  bool        // read to the end
+}
+
+func (b *stallBody) Read(p []byte) (int, error) {
+	n, err := b.uploadBody.Read(p)
+	b.progress(n, err)
+	return n, err
+}
+
+func (b *stallBody) ReadAt(p []byte, off int64) (int, error) {
+	n, err := b.uploadBody.ReadAt(p, off)
+	b.progress(n, err)
+	return n, err
+}
+
+func (b *stallBody) progress(n int, err error) {
+	if b.timer == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.done:
+	case err == io.EOF:
+		b.done = true
+		b.timer.Stop()
+	case n > 0:
+		b.timer.Reset(b.timeout)
+	}
+}
@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// wedgedDest is a mockDest whose Put reads readFirst bytes of the body,
+// all of it if negative, and then hangs until it is canceled.
+type wedgedDest struct {
+	*mockDest
+	readFirst int64
+	puts      int
+}
+
+func (d *wedgedDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	d.puts++
+	if d.readFirst < 0 {
+		io.Copy(io.Discard, r)
+	} else {
+		io.CopyN(io.Discard, r, d.readFirst)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestUploadUnstalled(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "alpha")
+	u := File{Key: "a.txt", Path: filepath.Join(src, "a.txt"), Size: info.Size(), ModTime: info.ModTime()}
+	ctx := context.Background()
+
+	dst := &wedgedDest{mockDest: newMockDest(), readFirst: 2}
+	err := uploadUnstalled(ctx, Options{Dst: dst, StallTimeout: 20 * time.Millisecond}, u, false)
+	if !errors.Is(err, ErrUploadStalled) || dst.puts != 1+stallRetries {
+		t.Errorf("stalled: %v after %d tries; want ErrUploadStalled after %d", err, dst.puts, 1+stallRetries)
+	}
+
+	// Once the file has been read, only the file timeout applies.
+	dst = &wedgedDest{mockDest: newMockDest(), readFirst: -1}
+	opts := Options{Dst: dst, StallTimeout: 20 * time.Millisecond, PerFileTimeout: 100 * time.Millisecond}
+	start := time.Now()
+	err = uploadUnstalled(ctx, opts, u, false)
+	if !errors.Is(err, ErrFileTimeout) || dst.puts != 1 {
+		t.Errorf("read whole: %v after %d tries; want ErrFileTimeout after 1", err, dst.puts)
+	}
+	if d := time.Since(start); d < opts.PerFileTimeout {
+		t.Errorf("gave up after %s, before the file timeout", d)
+	}
+
+	// Canceling the run is not mistaken for either.
+	dst = &wedgedDest{mockDest: newMockDest(), readFirst: 2}
+	cctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err = uploadUnstalled(cctx, Options{Dst: dst, StallTimeout: time.Minute}, u, false)
+	if !errors.Is(err, context.Canceled) || dst.puts != 1 {
+		t.Errorf("canceled: %v after %d tries; want context.Canceled after 1", err, dst.puts)
+	}
+}
@@ -0,0 +1,111 @@
+package sync
+
+import "context"
+
+// statAheadFiles is how many files, for each lookup that may be in flight,
+// a statAhead lets the walk go ahead of the files it has planned.
+const statAheadFiles = 8
+
+// statAhead looks files up at the destination with up to
+// opts.StatConcurrency requests in flight while the walk goes on, and
+// plans them in walk order as their answers come in. Without
+// StatConcurrency, each file is looked up as it is planned.
+type statAhead struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	dst    Destination
+	sem    chan struct{} // a token for each lookup in flight; nil if not looking ahead
+	queue  []*aheadFile  // walked but not yet planned, in walk order
+}
+
+// aheadFile is a file walked, waiting for the files before it and its own
+// lookup, if any, before it is planned.
+type aheadFile struct {
+	plan func(meta *ObjectMeta, err error) error
+	done chan struct{} // closed once meta and err are set; nil without a lookup
+	meta *ObjectMeta
+	err  error
+}
+
+func newStatAhead(ctx context.Context, opts Options) *statAhead {
+	ctx, cancel := context.WithCancel(ctx)
+	a := &statAhead{ctx: ctx, cancel: cancel, dst: opts.Dst}
+	if opts.StatConcurrency > 1 {
+		a.sem = make(chan struct{}, opts.StatConcurrency)
+	}
+	return a
+}
+
+// add queues a file to plan: it looks up key at the destination, unless
+// key is "", and calls plan with what it found once the files added before
+// are planned. It may plan those, and wait for their lookups, to keep the
+// queue bounded.
+func (a *statAhead) add(key string, plan func(meta *ObjectMeta, err error) error) error {
+	if a.sem == nil {
+		var meta *ObjectMeta
+		var err error
+		if key != "" {
+			meta, err = a.dst.Stat(a.ctx, key)
+		}
+		return plan(meta, err)
+	}
+	f := &aheadFile{plan: plan}
+	if key != "" {
+		f.done = make(chan struct{})
+		select {
+		case a.sem <- struct{}{}:
+		case <-a.ctx.Done():
+			return a.ctx.Err()
+		}
+		go func() {
+			defer func() { <-a.sem }()
+			f.meta, f.err = a.dst.Stat(a.ctx, key)
+			close(f.done)
+		}()
+	}
+	a.queue = append(a.queue, f)
+	return a.drain(len(a.queue) >= cap(a.sem)*statAheadFiles)
+}
+
+// drain plans the files at the head of the queue whose lookups are done,
+// and with wait, the oldest one whatever it waits for.
+func (a *statAhead) drain(wait bool) error {
+	for len(a.queue) > 0 {
+		f := a.queue[0]
+		if f.done != nil {
+			select {
+			case <-f.done:
+			default:
+				if !wait {
+					return nil
+				}
+				<-f.done
+			}
+		}
+		wait = false
+		a.queue = a.queue[1:]
+		if err := f.plan(f.meta, f.err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush plans every file queued, once the walk is over.
+func (a *statAhead) flush() error {
+	for len(a.queue) > 0 {
+		if err := a.drain(true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop cancels the lookups still in flight, if the walk ended early, and
+// waits for them to return.
+func (a *statAhead) stop() {
+	a.cancel()
+	for range cap(a.sem) {
+		a.sem <- struct{}{}
+	}
+}
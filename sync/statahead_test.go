@@ -0,0 +1,72 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStatDest answers Stat after a delay, counting the most lookups it
+// had in flight at once.
+type slowStatDest struct {
+	Destination
+	delay    time.Duration
+	fail     string // key whose lookup fails
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (d *slowStatDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	n := d.inFlight.Add(1)
+	defer d.inFlight.Add(-1)
+	for p := d.peak.Load(); n > p && !d.peak.CompareAndSwap(p, n); p = d.peak.Load() {
+	}
+	time.Sleep(d.delay)
+	if key == d.fail {
+		return nil, errors.New("boom")
+	}
+	return d.Destination.Stat(ctx, key)
+}
+
+func TestBuildPlan_statConcurrency(t *testing.T) {
+	src := t.TempDir()
+	for i := range 40 {
+		writeFile(t, src, fmt.Sprintf("d%d/f%02d.txt", i%3, i), "x")
+	}
+	keys := func(files []File) []string {
+		var keys []string
+		for _, f := range files {
+			keys = append(keys, f.Key)
+		}
+		return keys
+	}
+	ctx := context.Background()
+	want, err := buildPlan(ctx, Options{Src: src, Dst: NewLocalDestination(t.TempDir())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &slowStatDest{Destination: NewLocalDestination(t.TempDir()), delay: 5 * time.Millisecond}
+	got, err := buildPlan(ctx, Options{Src: src, Dst: dst, StatConcurrency: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys(got.Files), keys(want.Files)) || !slices.Equal(keys(got.Uploads), keys(want.Uploads)) {
+		t.Errorf("planned files %v, want them in walk order, %v", keys(got.Files), keys(want.Files))
+	}
+	if p := dst.peak.Load(); p < 2 || p > 8 {
+		t.Errorf("%d lookups in flight at most, want 2 to 8", p)
+	}
+
+	dst = &slowStatDest{Destination: NewLocalDestination(t.TempDir()), fail: "d1/f07.txt"}
+	if _, err := buildPlan(ctx, Options{Src: src, Dst: dst, StatConcurrency: 8}); err == nil || err.Error() != "stat d1/f07.txt: boom" {
+		t.Errorf("buildPlan = %v, want the failed lookup", err)
+	}
+	if n := dst.inFlight.Load(); n != 0 {
+		t.Errorf("%d lookups still in flight after the walk", n)
+	}
+}
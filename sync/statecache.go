@@ -0,0 +1,276 @@
+package sync
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// stateCache records the source file last synced to each key, so that a
+// file unchanged since then is known to be up to date without asking the
+// destination. See Options.StateCache.
+type stateCache struct {
+	path    string
+	compare Comparer // entries for keys it compares by checksum carry a content hash
+	hashAll bool     // every entry carries one, for Options.DetectRenames and ManifestChecksums
+	old     map[string]stateEntry
+	new     map[string]stateEntry
+
+	// manifest is the modification time (Unix seconds) of the
+	// destination's manifest after the last run, if it has one.
+	manifest int64
+	// full is when (Unix seconds) the last run that was not incremental
+	// finished. See Options.Incremental.
+	full int64
+
+	// resumed is the last key checked by the run that wrote the cache, if
+	// it was interrupted, and last the last key this run has checked.
+	resumed, last string
+	// every is how often checkpoint saves the cache, if positive, and
+	// saved when it last did. See Options.Checkpoint.
+	every time.Duration
+	saved time.Time
+}
+
+// stateEntry describes a source file as of the run that synced it.
+type stateEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // Unix nanoseconds
+	POSIX   string `json:"posix,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Class   string `json:"class,omitempty"` // see Options.Tiers
+}
+
+type stateCacheFile struct {
+	Manifest int64                 `json:"manifest,omitempty"`
+	Full     int64                 `json:"full,omitempty"`
+	Files    map[string]stateEntry `json:"files"`
+
+	// Checkpoint is the last key checked by a run that was interrupted.
+	Checkpoint string `json:"checkpoint,omitempty"`
+}
+
+// loadStateCache reads the cache at path. A missing or unreadable cache is
+// treated as empty.
+func loadStateCache(path string, compare Comparer, warnings io.Writer) *stateCache {
+	var f stateCacheFile
+	readCacheFile(path, "state cache", &f, warnings)
+	return &stateCache{
+		path:     path,
+		compare:  compare,
+		old:      f.Files,
+		new:      make(map[string]stateEntry),
+		manifest: f.Manifest,
+		full:     f.Full,
+		resumed:  f.Checkpoint,
+		saved:    time.Now(),
+	}
+}
+
+// invalidate discards every entry, so that each file is checked again.
+func (c *stateCache) invalidate() {
+	c.old = nil
+}
+
+// previous returns the entry the last run recorded for key, if any.
+func (c *stateCache) previous(key string) (stateEntry, bool) {
+	if c == nil {
+		return stateEntry{}, false
+	}
+	e, ok := c.old[key]
+	return e, ok
+}
+
+// lookup reports whether file is unchanged since it was last synced, in
+// which case the destination's copy is up to date.
+func (c *stateCache) lookup(file File) (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+	old, ok := c.old[file.Key]
+	if !ok {
+		return false, nil
+	}
+	e := newStateEntry(file)
+	e.SHA256 = old.SHA256
+	if e != old {
+		return false, nil
+	}
+	if c.hashes(file.Key) {
+		sum, err := file.sha256()
+		if err != nil || hex.EncodeToString(sum) != old.SHA256 {
+			return false, err
+		}
+	}
+	if c.hashAll && old.SHA256 == "" {
+		// Recorded before every entry was hashed.
+		sum, err := file.sha256()
+		if err != nil {
+			return false, err
+		}
+		old.SHA256 = hex.EncodeToString(sum)
+	}
+	c.new[file.Key] = old
+	return true, nil
+}
+
+// record notes that the destination holds an up to date copy of file.
+func (c *stateCache) record(file File) error {
+	if c == nil {
+		return nil
+	}
+	e := newStateEntry(file)
+	if c.hashes(file.Key) || c.hashAll {
+		sum, err := file.sha256()
+		if err != nil {
+			return err
+		}
+		e.SHA256 = hex.EncodeToString(sum)
+	}
+	c.new[file.Key] = e
+	return nil
+}
+
+// wantsHash reports whether recording the file at key hashes its content.
+func (c *stateCache) wantsHash(key string) bool {
+	return c != nil && (c.hashAll || c.hashes(key))
+}
+
+// hashes reports whether the entry for key carries a content hash.
+func (c *stateCache) hashes(key string) bool {
+	_, ok := comparerFor(c.compare, key).(ChecksumComparer)
+	return ok
+}
+
+func newStateEntry(file File) stateEntry {
+	return stateEntry{
+		Size:    file.Size,
+		ModTime: file.ModTime.UnixNano(),
+		POSIX:   posixString(file.POSIX),
+		Class:   file.Class,
+	}
+}
+
+// forget drops key, once its object has been deleted.
+func (c *stateCache) forget(key string) {
+	if c != nil {
+		delete(c.new, key)
+	}
+}
+
+// next returns the cache for a run that follows this one and only looks
+// at some of the files, such as a batch of changes in watch mode: entries
+// it does not touch are carried over.
+func (c *stateCache) next() *stateCache {
+	if c == nil {
+		return nil
+	}
+	n := *c
+	n.old = c.new
+	n.new = maps.Clone(c.new)
+	return &n
+}
+
+// keepUnvisited carries over the entries for keys this run did not look
+// up, for a run that stopped early.
+func (c *stateCache) keepUnvisited() {
+	for key, e := range c.old {
+		if _, ok := c.new[key]; !ok {
+			c.new[key] = e
+		}
+	}
+}
+
+// save writes the entries recorded by this run, replacing the old cache.
+func (c *stateCache) save() error {
+	return writeCacheFile(c.path, stateCacheFile{Manifest: c.manifest, Full: c.full, Files: c.new})
+}
+
+// saveProgress saves the cache of a run that stopped early, noting the
+// last key it checked.
+func (c *stateCache) saveProgress() error {
+	if c == nil {
+		return nil
+	}
+	c.keepUnvisited()
+	return writeCacheFile(c.path, stateCacheFile{Manifest: c.manifest, Full: c.full, Files: c.new, Checkpoint: c.last})
+}
+
+// visited notes that key has been checked, for the checkpoints.
+func (c *stateCache) visited(key string) {
+	if c != nil {
+		c.last = key
+	}
+}
+
+// checkpoint saves what the run has recorded so far, along with the
+// entries it has not looked up yet, if c.every has passed since the cache
+// was last saved, so that a run that is killed resumes from there.
+func (c *stateCache) checkpoint() error {
+	if c == nil || c.every <= 0 || time.Since(c.saved) < c.every {
+		return nil
+	}
+	files := make(map[string]stateEntry, max(len(c.old), len(c.new)))
+	maps.Copy(files, c.old)
+	maps.Copy(files, c.new)
+	if err := writeCacheFile(c.path, stateCacheFile{Manifest: c.manifest, Full: c.full, Files: files, Checkpoint: c.last}); err != nil {
+		return fmt.Errorf("save state cache: %w", err)
+	}
+	c.saved = time.Now()
+	return nil
+}
+
+// posixString is a canonical form of a, for comparing attributes.
+func posixString(a *POSIXAttrs) string {
+	if a == nil {
+		return ""
+	}
+	md := make(map[string]string)
+	a.encode(md)
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(md)) {
+		fmt.Fprintf(&b, "%s=%s;", k, md[k])
+	}
+	return b.String()
+}
+
+// openStateCache loads opts.StateCache. If the destination's manifest has
+// been written since this cache was, another run has changed the
+// destination and the cache is discarded.
+func openStateCache(ctx context.Context, opts Options) (*stateCache, error) {
+	c := loadStateCache(opts.StateCache, opts.Compare, opts.Warnings)
+	c.hashAll = opts.DetectRenames || opts.Manifest && opts.ManifestChecksums
+	if !opts.DryRun {
+		c.every = opts.Checkpoint
+	}
+	if !opts.Manifest && c.manifest == 0 {
+		c.logResumed(opts)
+		return c, nil
+	}
+	meta, err := opts.Dst.Stat(ctx, ManifestKey)
+	if err != nil {
+		return nil, &FileError{Op: "stat", Key: ManifestKey, Err: err}
+	}
+	var written int64
+	if meta != nil {
+		written = meta.ModTime.Unix()
+	}
+	if written != c.manifest {
+		c.invalidate()
+	} else {
+		c.logResumed(opts)
+	}
+	return c, nil
+}
+
+// logResumed says where the run that wrote c stopped, if it stopped early.
+func (c *stateCache) logResumed(opts Options) {
+	if c.resumed != "" {
+		opts.logf("resuming a run that stopped early, after checking the files up to %s", c.resumed)
+	}
+}
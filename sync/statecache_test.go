@@ -0,0 +1,228 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSync_stateCacheSkipsStat(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "sub/b.txt", "b")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.statCalls) != 0 || len(dst.putCalls) != 0 {
+		t.Errorf("unchanged tree: stat %v, put %v; want neither", dst.statCalls, dst.putCalls)
+	}
+
+	writeFile(t, src, "sub/b.txt", "bb")
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.statCalls) != 1 || len(dst.putCalls) != 1 || dst.putCalls[0] != "sub/b.txt" {
+		t.Errorf("changed file: stat %v, put %v; want sub/b.txt checked and uploaded", dst.statCalls, dst.putCalls)
+	}
+}
+
+func TestSync_stateCacheInvalidatedByOtherWriter(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, Manifest: true, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another machine syncs to the same destination.
+	other := &Manifest{Created: time.Now().Add(time.Hour)}
+	if err := WriteManifest(context.Background(), dst, other, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.statCalls = nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !containsKey(dst.statCalls, "a.txt") {
+		t.Errorf("expected a.txt to be checked after the manifest changed, got stat %v", dst.statCalls)
+	}
+}
+
+// statLimitDest fails its run at the Stat after the first n, canceling it
+// first if cancel is set.
+type statLimitDest struct {
+	*mockDest
+	n      int
+	cancel context.CancelFunc
+}
+
+func (d *statLimitDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	if d.n--; d.n < 0 {
+		if d.cancel != nil {
+			d.cancel()
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("killed")
+	}
+	return d.mockDest.Stat(ctx, key)
+}
+
+func TestSync_stateCacheResumes(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		writeFile(t, src, name, name)
+	}
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(t.TempDir(), "state.json")
+
+	// A run canceled while checking the files keeps what it checked.
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := Sync(ctx, Options{Src: src, Dst: &statLimitDest{mockDest: dst, n: 2, cancel: cancel}, StateCache: state})
+	if !errors.Is(err, ErrCanceled) {
+		t.Fatalf("Sync = %v, want ErrCanceled", err)
+	}
+	var log bytes.Buffer
+	dst.statCalls = nil
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, StateCache: state, Log: &log}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.statCalls, []string{"c.txt", "d.txt"}) {
+		t.Errorf("after a canceled run, checked %v, want c.txt and d.txt", dst.statCalls)
+	}
+	if want := "after checking the files up to b.txt\n"; !strings.HasSuffix(log.String(), want) {
+		t.Errorf("log %q does not end in %q", log.String(), want)
+	}
+
+	// One that is killed keeps what it checked before its last checkpoint.
+	for _, every := range []time.Duration{0, time.Nanosecond} {
+		os.Remove(state)
+		_, err := Sync(context.Background(), Options{Src: src, Dst: &statLimitDest{mockDest: dst, n: 3}, StateCache: state, Checkpoint: every})
+		if err == nil {
+			t.Fatal("Sync succeeded")
+		}
+		dst.statCalls = nil
+		if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, StateCache: state}); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+		if every > 0 {
+			want = want[3:]
+		}
+		if !slices.Equal(dst.statCalls, want) {
+			t.Errorf("after a run checkpointing every %v was killed, checked %v, want %v", every, dst.statCalls, want)
+		}
+	}
+}
+
+func TestSync_stateCacheChecksumNoticesSameSizeEdit(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "aaaa")
+
+	dst := newMockDest()
+	opts := Options{
+		Src:        src,
+		Dst:        dst,
+		Compare:    ChecksumComparer{},
+		StateCache: filepath.Join(t.TempDir(), "state.json"),
+	}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(src, "a.txt")
+	if err := os.WriteFile(path, []byte("bbbb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.putCalls = nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 1 {
+		t.Errorf("expected a.txt to be re-uploaded, got %v", dst.putCalls)
+	}
+}
+
+func TestSync_stateCacheBypassedForReconciliation(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+
+	dst := newMockDest()
+	opts := Options{
+		Src:        src,
+		Dst:        dst,
+		Compare:    Reconciler{Base: SizeComparer{}, Every: 1},
+		StateCache: filepath.Join(t.TempDir(), "state.json"),
+	}
+	for range 2 {
+		dst.statCalls = nil
+		if _, err := Sync(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(dst.statCalls) != 1 {
+		t.Errorf("expected the file due for reconciliation to be checked, got stat %v", dst.statCalls)
+	}
+}
+
+func TestWatcher_stateCacheForgetsDeletedFiles(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "a")
+
+	dst := newMockDest()
+	w := newTestWatcher(t, Options{Src: src, Dst: dst, Delete: true, StateCache: filepath.Join(t.TempDir(), "state.json")})
+	path := filepath.Join(src, "a.txt")
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.syncChanged(context.Background(), []string{path}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restored with the same size and mtime: it must still be uploaded.
+	writeFile(t, src, "a.txt", "a")
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	dst.putCalls = nil
+	if err := w.syncChanged(context.Background(), []string{path}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 1 {
+		t.Errorf("expected a.txt to be uploaded again, got %v", dst.putCalls)
+	}
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	stdsync "sync"
+	"time"
+)
+
+// statFallbackDest passes Stat through to a destination until it is
+// denied. Some buckets allow listing and writing objects but deny
+// HeadObject, which would otherwise fail every file. From then on, Stat is
+// answered from one listing: sizes come from the listing, and modification
+// times from the destination's manifest, for objects it still describes.
+// Without a readable manifest, an object the size of its source file is
+// reported with the file's modification time, so files are compared by
+// size alone. Writes made through it keep the answers up to date. Files
+// may be looked up from several goroutines at once; see
+// Options.StatConcurrency.
+type statFallbackDest struct {
+	Destination
+	path     func(key string) (string, bool) // of the source file stored under key
+	warnings io.Writer                       // Options.Warnings
+
+	mu      stdsync.Mutex
+	objects map[string]*ObjectMeta // nil until Stat is denied
+	known   map[string]bool        // keys whose modification time is known
+}
+
+// withStatFallback wraps opts.Dst in a statFallbackDest if it can list
+// what Stat would report.
+func withStatFallback(opts Options) Destination {
+	if _, ok := opts.Dst.(ObjectLister); !ok {
+		return opts.Dst
+	}
+	path := func(key string) (string, bool) { return sourcePath(opts, key) }
+	return &statFallbackDest{Destination: opts.Dst, path: path, warnings: opts.Warnings}
+}
+
+func (d *statFallbackDest) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	d.mu.Lock()
+	listed := d.objects != nil
+	d.mu.Unlock()
+	if !listed {
+		meta, err := d.Destination.Stat(ctx, key)
+		if !errors.Is(err, fs.ErrPermission) {
+			return meta, err
+		}
+		if err := d.fallBack(ctx, err); err != nil {
+			return nil, err
+		}
+	}
+	d.mu.Lock()
+	meta, ok := d.objects[key]
+	known := d.known[key]
+	d.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	m := *meta
+	if !known {
+		if path, ok := d.path(key); ok {
+			info, err := os.Stat(path)
+			if err == nil && info.Size() == m.Size {
+				m.ModTime = info.ModTime().Truncate(time.Second)
+			}
+		}
+	}
+	return &m, nil
+}
+
+// fallBack lists the destination and reads its manifest once Stat has
+// been denied with denied, unless another lookup has already.
+func (d *statFallbackDest) fallBack(ctx context.Context, denied error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.objects != nil {
+		return nil
+	}
+	listed, err := d.Destination.(ObjectLister).ListObjects(ctx)
+	if err != nil {
+		return fmt.Errorf("%w; listing the destination instead failed: %w", denied, err)
+	}
+	d.objects = make(map[string]*ObjectMeta, len(listed))
+	d.known = make(map[string]bool)
+	for key, o := range listed {
+		d.objects[key] = &ObjectMeta{Size: o.Size, ModTime: o.Written}
+	}
+
+	m, err := ReadManifest(ctx, d.Destination, nil)
+	if err != nil {
+		warnf(d.warnings, "destination denies reading object metadata and its manifest cannot be read; comparing files by size")
+		return nil
+	}
+	for _, e := range m.Files {
+		if meta, ok := d.objects[e.Key]; ok && meta.Size == e.Size {
+			meta.ModTime = e.ModTime
+			d.known[e.Key] = true
+		}
+	}
+	if meta, ok := d.objects[ManifestKey]; ok {
+		meta.ModTime = m.Created // as it was written; see openStateCache
+		d.known[ManifestKey] = true
+	}
+	warnf(d.warnings, "destination denies reading object metadata; comparing files to its listing and manifest")
+	return nil
+}
+
+func (d *statFallbackDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := d.Destination.Put(ctx, key, r, meta); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.objects != nil {
+		meta.ModTime = meta.ModTime.Truncate(time.Second)
+		d.objects[key] = &meta
+		d.known[key] = true
+	}
+	return nil
+}
+
+func (d *statFallbackDest) Delete(ctx context.Context, key string) error {
+	if err := d.Destination.Delete(ctx, key); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.objects, key)
+	return nil
+}
+
+func (d *statFallbackDest) DeleteBatch(ctx context.Context, keys []string) ([]string, error) {
+	deleted, err := deleteBatch(ctx, d.Destination, keys)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range deleted {
+		delete(d.objects, key)
+	}
+	return deleted, err
+}
+
+func (d *statFallbackDest) Copy(ctx context.Context, src, dst string) error {
+	if err := copyObject(ctx, d.Destination, src, dst); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if meta, ok := d.objects[src]; ok {
+		m := *meta
+		d.objects[dst] = &m
+		d.known[dst] = d.known[src]
+	}
+	return nil
+}
+
+func (d *statFallbackDest) CopyWithMeta(ctx context.Context, src, dst string, meta ObjectMeta) error {
+	if err := copyWithMeta(ctx, d.Destination, src, dst, meta); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.objects != nil {
+		meta.ModTime = meta.ModTime.Truncate(time.Second)
+		d.objects[dst] = &meta
+		d.known[dst] = true
+	}
+	return nil
+}
+
+func (d *statFallbackDest) Append(ctx context.Context, key string, size int64, tail io.Reader, meta ObjectMeta) error {
+	if err := appendObject(ctx, d.Destination, key, size, tail, meta); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.objects != nil {
+		meta.ModTime = meta.ModTime.Truncate(time.Second)
+		d.objects[key] = &meta
+		d.known[key] = true
+	}
+	return nil
+}
+
+func (d *statFallbackDest) Rename(ctx context.Context, src, dst string) error {
+	if err := renameObject(ctx, d.Destination, src, dst); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if meta, ok := d.objects[src]; ok {
+		d.objects[dst], d.known[dst] = meta, d.known[src]
+		delete(d.objects, src)
+	}
+	return nil
+}
+
+func (d *statFallbackDest) SetStorageClass(ctx context.Context, key, class string) error {
+	return setStorageClass(ctx, d.Destination, key, class)
+}
+
+func (d *statFallbackDest) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return get(ctx, d.Destination, key)
+}
+
+func (d *statFallbackDest) ListWritten(ctx context.Context) (map[string]time.Time, error) {
+	return listWritten(ctx, d.Destination)
+}
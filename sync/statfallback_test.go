@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// noHeadDest is a listingDest that denies Stat, like a bucket policy that
+// denies HeadObject.
+type noHeadDest struct {
+	listingDest
+}
+
+func (d noHeadDest) Stat(_ context.Context, key string) (*ObjectMeta, error) {
+	d.statCalls = append(d.statCalls, key)
+	return nil, fmt.Errorf("HeadObject %s: %w", key, fs.ErrPermission)
+}
+
+func TestSync_statDeniedUsesManifest(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+	mock := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: mock, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same size, but modified since: only the manifest tells.
+	writeFile(t, src, "b.txt", "c")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "b.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, src, "new.txt", "new")
+	mock.putCalls, mock.statCalls = nil, nil
+	dst := noHeadDest{listingDest{mock}}
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Manifest: true}); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(mock.putCalls)
+	if want := []string{ManifestKey, "b.txt", "new.txt"}; !slices.Equal(mock.putCalls, want) {
+		t.Errorf("put %v, want %v", mock.putCalls, want)
+	}
+	if len(mock.statCalls) != 1 {
+		t.Errorf("stat calls = %v, want none once denied", mock.statCalls)
+	}
+}
+
+func TestSync_statDeniedWithoutManifestComparesSizes(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "same.txt", "a")
+	writeFile(t, src, "grown.txt", "bb")
+	mock := newMockDest()
+	mock.objects["same.txt"] = &ObjectMeta{Size: 1}
+	mock.objects["grown.txt"] = &ObjectMeta{Size: 1}
+
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: noHeadDest{listingDest{mock}}}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(mock.putCalls, []string{"grown.txt"}) {
+		t.Errorf("put %v, want grown.txt", mock.putCalls)
+	}
+}
+
+// deniedDest is a mockDest that denies Stat and cannot list sizes.
+type deniedDest struct {
+	*mockDest
+}
+
+func (deniedDest) Stat(_ context.Context, key string) (*ObjectMeta, error) {
+	return nil, fmt.Errorf("HeadObject %s: %w", key, fs.ErrPermission)
+}
+
+func TestSync_statDeniedWithoutListing(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	_, err := Sync(context.Background(), Options{Src: src, Dst: deniedDest{newMockDest()}})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("err = %v, want the denied Stat", err)
+	}
+}
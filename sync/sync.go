@@ -2,10 +2,16 @@ package sync
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
-	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 )
 
 // Options configures a sync operation.
@@ -14,90 +20,1264 @@ type Options struct {
 	Dst    Destination // destination
 	DryRun bool        // if true, print actions without making changes
 	Delete bool        // if true, remove destination objects absent from Src
+
+	// DeleteExcluded, with Delete, also deletes the objects of paths the
+	// IgnoreFiles of the source exclude, whether or not their files still
+	// exist, as rsync's --delete-excluded does, and the objects whose keys
+	// Keys maps to no path. Without it, Delete leaves both alone. Files
+	// skipped by the size, age and in-flight filters are not excluded in
+	// this sense: their objects are kept while the files exist. TwoWay
+	// refuses it.
+	DeleteExcluded bool
+
+	// Estimate, with DryRun, prints a projection of what the run would
+	// upload, and of what storing the source would cost a month in each
+	// storage class of Dst, instead of each change. See StoragePricer.
+	Estimate bool
+
+	// Itemize prints each upload, copy, link and delete in the style of
+	// rsync --itemize-changes, saying how each file uploaded differs from
+	// the destination's copy, as Event.Item does, and with DryRun a
+	// summary of the changes at the end.
+	Itemize bool
+
+	// FileList, if non-nil, syncs only the files it names, paths relative
+	// to Src, and everything below those that are directories, instead of
+	// walking all of Src, for runs driven by another tool, such as find or
+	// git diff --name-only. With Delete, the files named that are gone from
+	// Src are deleted from Dst, as with DeleteExcluded are those named that
+	// the IgnoreFiles exclude; nothing else is. MaxChangeRatio does not
+	// apply, and features that need every file, such as Manifest, cannot
+	// be used. See ReadFileList.
+	FileList []string
+
+	// SavePlan, if set, is passed what the run would do instead of it
+	// being done, as with DryRun, for ApplyPlan to do in a later run once
+	// it has been reviewed. ApplyPlan, if set, is such a plan, which the
+	// run does exactly, in place of walking the sources and comparing
+	// them to Dst: it fails with ErrStalePlan if a file to upload has
+	// changed since. MaxChangeRatio does not apply to it, and features
+	// that need every file, such as Manifest, cannot be used. Neither can
+	// be used with features that plan more than uploads and deletes, such
+	// as Bundle or DetectRenames.
+	SavePlan  func(*SavedPlan) error
+	ApplyPlan *SavedPlan
+
+	// Sources, if set instead of Src, syncs several directories in one
+	// run, each under a key prefix of its own, such as "docs/", with one
+	// pass over Dst for all of them. Prefixes must not overlap; a missing
+	// final "/" is added. Key layouts apply below the prefix. Objects
+	// outside the prefixes are left alone by Delete. Watch, TwoWay and
+	// ImportState take Src only.
+	Sources []SourceSpec
+
+	// ExpireAfter, if positive, is the age at which lifecycle rules on Dst
+	// expire objects. Delete then leaves objects absent from Src that were
+	// written at least this long ago for those rules to remove, saving a
+	// request per object. Dst must implement WrittenLister.
+	ExpireAfter time.Duration
+
+	// ReportExtraneous, if set, is the path of a file to write the keys of
+	// the destination objects absent from Src to, one per line, whether
+	// or not Delete is set. It is written by every run that lists Dst in
+	// full, dry runs included; nothing is deleted without Delete.
+	ReportExtraneous string
+
+	// PreservePOSIX records each file's permissions, ownership and
+	// extended attributes with the object, so that Restore can reapply
+	// them. Files whose attributes changed are uploaded again.
+	PreservePOSIX bool
+
+	// Sparse uploads only the data of files with holes, such as virtual
+	// machine disk images, and records where the holes are, so that they
+	// are neither stored nor downloaded. Restore recreates the holes.
+	// Holes are detected on Linux, macOS and FreeBSD.
+	Sparse bool
+
+	// Compression compresses each file before uploading it, recording
+	// how with the object so that it is decompressed when read back.
+	// Files are compared by their size before compressing.
+	Compression Compression
+
+	// Transform, if set, rewrites the content of each file as it is
+	// uploaded, for compression, redaction or conversion of one's own.
+	// TransformName identifies it, and is recorded with each object it
+	// rewrote as ObjectMeta.Transform; RestoreOptions.Untransform can
+	// undo it. Files are compared by their size before the transform,
+	// so changing it does not upload them again; use Reupload for that.
+	// Dst must record metadata, and Transform cannot be combined with
+	// Compression, Sparse, Chunk or Bundle.
+	Transform     TransformFunc
+	TransformName string
+
+	// Checksums records the SHA-256 of each file uploaded whole with its
+	// object, and asks S3 to check the upload against a SHA-256 of the
+	// bytes sent, so that corruption on the way is refused rather than
+	// stored. Restore checks the content it downloads against the recorded
+	// hash, as does the checksum comparison, and so Verify. Since the hash
+	// is sent before the content, files are read once more to be hashed
+	// before they are uploaded, unless comparing them hashed them already.
+	Checksums bool
+	// AppendUploads uploads only what was added to the end of files that
+	// only grow, as logs and mbox archives do, on destinations that are
+	// Appenders: S3 copies the rest from the object the file replaces.
+	// A file grew by appending if the first bytes of it, as many as its
+	// object holds, hash to the SHA-256 recorded with the object, so it
+	// needs Checksums. Files stored compressed, sparse, transformed or in
+	// chunks, staged uploads, and objects S3 cannot append to, such as
+	// those under 5 MiB, are uploaded whole.
+	AppendUploads bool
+
+	// VerifyAfterUpload asks Dst for the metadata of each object again
+	// after writing it, bypassing MetaCache, and fails the file, as if its
+	// upload had failed, if the object is missing or its size or recorded
+	// SHA-256 (see Checksums) is not what was sent. It costs a request per
+	// file uploaded. Where Dst denies reading object metadata, there is
+	// nothing to check against but what was sent.
+	VerifyAfterUpload bool
+	// StageUploads uploads each file under StagingPrefix first, checks it
+	// there as VerifyAfterUpload does, if set, and only then moves it to
+	// its key, so that an object cut short or failing the check never
+	// stands in for the file, nor is restored. Dst must implement Renamer,
+	// as local directories and WebDAV servers do, or else Copier, and the
+	// staged object is copied into place and deleted: two more requests
+	// per file. Bundles and chunks are not staged. It cannot be combined
+	// with ObjectLock.
+	StageUploads bool
+
+	// ContentType selects how the Content-Type of uploaded objects is
+	// chosen. The zero value detects it from the extension or content.
+	ContentType ContentTypeMode
+
+	// Tags are attached to every uploaded file and bundle as object tags,
+	// for lifecycle rules and cost allocation reports to select them by.
+	// Only S3 supports them; see CheckS3Tags. Changing the tags does not
+	// upload files again: they apply to files uploaded from then on.
+	Tags map[string]string
+
+	// Metadata sets the HTTP headers and custom metadata of the uploads
+	// of matching files, for buckets that also serve a static site or
+	// artifact downloads; the first rule whose pattern matches a file's
+	// key applies. Like Tags, changing the rules does not upload files
+	// again. A Content-Encoding cannot be combined with Compression.
+	Metadata []MetadataRule
+
+	// Tiers, if set, assign each file a storage class by its age and
+	// path, as StorageTier describes: new files are uploaded in it, and
+	// objects of unchanged files that have since reached an older tier
+	// are moved to its class in place, without being uploaded again. It
+	// complements lifecycle rules, which only go by the age of objects
+	// and their prefixes and tags. Files matching no tier keep the class
+	// of Dst, which must be a Reclassifier. DirCache is not used, as
+	// files reach older tiers without changing.
+	Tiers []StorageTier
+
+	// ObjectLock, if set, locks every uploaded file and bundle so that it
+	// cannot be deleted or overwritten until its retention ends, even by
+	// someone holding the job's credentials. The destination must be a
+	// Locker: an S3 bucket with Object Lock enabled. Renamed files cannot
+	// be copied, so DetectRenames cannot be set too.
+	ObjectLock *ObjectLock
+
+	// Compare decides whether a file already at the destination is up to
+	// date. Nil means ModTimeComparer{}: matching size and mtime.
+	Compare Comparer
+
+	// ModTimeWindow is how far apart modification times may be and still
+	// be equal, for filesystems such as FAT32 and exFAT that keep them to
+	// 2 seconds. It is the Window of the ModTimeComparers of Compare that
+	// have none, and applies to bundled files, Verify and TwoWay, which
+	// compares the local file and the destination's copy in both
+	// directions.
+	ModTimeWindow time.Duration
+
+	// Keys maps the path of each file, relative to Src, to the key it is
+	// stored under. Nil means IdentityKeys{}: the path itself. Restore
+	// must be given the same mapper. With anything but IdentityKeys,
+	// Watch and TwoWay cannot be used.
+	Keys KeyMapper
+
+	// UnicodeForm is the normalization form paths are put in before Keys
+	// maps them, so that the same name written by different systems is
+	// stored under one key. The empty form is NFC. Objects stored under
+	// keys in another form, by earlier runs or other machines, are
+	// deleted with Delete once their files are uploaded under the new
+	// ones. TwoWay keeps names as they are, as keys name the local files
+	// it writes.
+	UnicodeForm UnicodeForm
+
+	// CaseCollisions decides what is done with source files whose paths,
+	// after UnicodeForm, differ only in case, and so cannot both be
+	// restored to a case-insensitive file system. The zero value does not
+	// look for them. With a FileList, only the directories listed are
+	// checked. Watch cannot rename them, and renaming them does not use
+	// DirCache, as a file's key then depends on files in other
+	// directories.
+	CaseCollisions CaseCollisionPolicy
+
+	// MinSize and MaxSize, if positive, skip files smaller or larger than
+	// them in bytes. ModifiedAfter and ModifiedBefore, if set, skip files
+	// last modified before or at or after them. Skipped files are left out
+	// of the run: they are not uploaded and, as long as they exist, their
+	// objects are not deleted. Dry runs list them. TwoWay does not support
+	// them.
+	MinSize, MaxSize              int64
+	ModifiedAfter, ModifiedBefore time.Time
+
+	// SkipInFlight skips files that look to be in the middle of being
+	// written, to keep torn copies out of the backup: files whose names
+	// match InFlightPatterns, base-name patterns in path.Match syntax that
+	// default to DefaultInFlightPatterns; files modified less than
+	// InFlightAge before they are walked; and, on Linux, files a process
+	// has open for writing as the run starts, as far as /proc shows them.
+	// Skipped files are left out of the run as those of the size and age
+	// filters are. Watch and TwoWay do not support it.
+	SkipInFlight     bool
+	InFlightPatterns []string
+	InFlightAge      time.Duration
+
+	// Reupload lists key patterns, in path.Match syntax, of files to upload
+	// again even if the destination's copy looks up to date, for example
+	// because it was found to be corrupt. A pattern also matches the keys
+	// under it, so "photos" covers "photos/2024/a.jpg".
+	Reupload []string
+
+	// WalkConcurrency, if above 1, is how many source directories are
+	// read at once while the walk of Src goes on, for sources on network
+	// filesystems or spinning disks where each read waits. Files are
+	// still checked and uploaded one at a time, in the same order.
+	WalkConcurrency int
+
+	// OnWalkError decides what to do with source files and directories
+	// that cannot be read, whether while walking the source or when a
+	// file is opened to upload it: the zero value, like WalkErrorsFail,
+	// stops the run at the first. With WalkErrorsSkip, the run goes on
+	// without them and lists them in Result.Unreadable; the objects of
+	// the files in them are kept, and the next run reads them again.
+	OnWalkError WalkErrorPolicy
+
+	// StatConcurrency, if above 1, is how many files are looked up at Dst
+	// at once while the walk goes on, rather than each in turn as it is
+	// reached, so that trees of many small files do not wait on a request
+	// for each. Files are still planned in walk order, and requests still
+	// keep to RequestsPerSecond. Dst must allow Stat from several
+	// goroutines at once. The files of a FileList are looked up in turn.
+	StatConcurrency int
+
+	// DirCache, if set, is the path of a local cache of per-directory
+	// signatures. Files in a directory whose signature has not changed
+	// since the last successful run are assumed to be up to date without
+	// asking Dst, so a cache must only ever be used with one Src and Dst.
+	// Changes made to Dst by anything else go unnoticed while it is in use.
+	DirCache string
+
+	// StateCache, if set, is the path of a local cache recording the
+	// source file last synced to each key. A file whose size, mtime and
+	// POSIX attributes still match is assumed to be up to date without
+	// asking Dst. With ChecksumComparer, its content hash must match
+	// too. Like DirCache, a cache must only be used with one Src and Dst.
+	// It is discarded if the destination's manifest was rewritten by
+	// another run.
+	StateCache string
+	// HashCache, if set, is the path of a local cache of the SHA-256 of
+	// source files, by their device and inode (or path, where the platform
+	// has no inodes), size and modification time, so that a file unchanged
+	// since a run hashed it is not read again to hash it: ChecksumComparer
+	// then reads only the files that changed. Unlike the other caches, one
+	// describes Src alone, whatever the destination. HashCacheVerify, from
+	// 0 to 1, is the share of the files the cache has hashes for that are
+	// hashed again anyway, picked at random each run, to catch content that
+	// changed without its size or modification time changing; a warning
+	// names each, and its fresh hash is used. Sync and Watch update the
+	// cache; TwoWay and Verify only read it.
+	HashCache       string
+	HashCacheVerify float64
+
+	// Checkpoint, if positive, saves StateCache this often while a run
+	// checks and uploads files, so that a run that is killed resumes where
+	// it left off: the files it found up to date or uploaded are not
+	// checked again. A run that is canceled, or stops at MaxRequests,
+	// saves it regardless.
+	Checkpoint time.Duration
+
+	// Incremental trusts StateCache alone, for runs frequent enough that
+	// asking Dst about each change costs more than the change itself:
+	// files the cache does not vouch for are uploaded without a Stat, and
+	// with Delete, files it records that are gone from Src are deleted
+	// without listing Dst. Changes made to Dst by anything else go
+	// unnoticed until the next full run, which is made once FullEvery has
+	// passed since the last one, if FullEvery is positive, and whenever
+	// the cache is missing or discarded. StateCache is required, and
+	// ExpireAfter cannot be used.
+	Incremental bool
+	FullEvery   time.Duration
+
+	// Snapshots keeps every run restorable, like Time Machine: the
+	// manifest of each run is copied under SnapshotPrefix, and before an
+	// object is replaced or deleted it is copied under VersionPrefix,
+	// server-side, for RestoreOptions.Snapshot to find. Manifest must be
+	// set and Dst must implement Copier. Bundle cannot be used.
+	Snapshots bool
+
+	// DeleteTo, if non-nil, makes Delete move objects to a trash instead
+	// of deleting them outright: each is copied under TrashPrefix, in a
+	// directory named for the time of the run, and then deleted, so that
+	// a mistaken delete can be undone. PurgeTrash empties the trash of
+	// older runs.
+	DeleteTo *Trash
+
+	// DetectRenames copies a file that would be uploaded from the object
+	// of a file with the same content that is gone from Src, server-side,
+	// as when a file is renamed or moved, instead of uploading it again.
+	// The object copied is found by the content hash StateCache records
+	// for every file while it is set, and only among files of the same
+	// size, modification time, POSIX attributes and, unless ContentType is
+	// ContentTypeNone, extension, so the copy needs no other changes. With
+	// Delete, the old object is then deleted as usual. StateCache is
+	// required and Dst must implement Copier.
+	DetectRenames bool
+
+	// DedupeUploads uploads the content of identical files once a run: a
+	// file with the same content as one uploaded before it in the run is
+	// copied from that file's object, server-side, with metadata of its
+	// own, instead of being sent again. Only files of the same size as
+	// another upload are hashed to find them, while planning; empty files,
+	// chunked files and those DetectRenames copies are left out. A file
+	// whose original failed to upload, or that changed since it was
+	// hashed, is uploaded as usual. Dst must implement MetaCopier, and
+	// Sparse, Transform and ObjectLock cannot be set too.
+	DedupeUploads bool
+
+	// HardLinks uploads the content of files hard linked to each other
+	// once, under the key of the first of them the walk finds, and records
+	// the others in a LinkIndex, so that Restore makes them hard links to
+	// it again. Objects previously uploaded for the others are deleted,
+	// even without Delete. Hard links are detected on Linux, macOS, FreeBSD
+	// and NetBSD. Dst must implement Getter, and Snapshots cannot be used.
+	HardLinks bool
+
+	// KeepEmptyDirs records the empty directories of the source, which
+	// have no objects of their own, in a DirIndex, so that Restore creates
+	// them again. A directory is empty if nothing in it is synced. Dst must
+	// implement Getter.
+	KeepEmptyDirs bool
+
+	// MetaCache, if set, is the path of a local cache of the answers Dst
+	// gave to List and Stat. Answers younger than MetaCacheMaxAge are
+	// reused instead of asking Dst again, so that a dry run, a verify and
+	// a sync of the same destination made in quick succession list it
+	// only once. Unlike the other caches, one may be shared by every
+	// command using the same Dst. Changes made to Dst by anything else
+	// within the window go unnoticed.
+	MetaCache       string
+	MetaCacheMaxAge time.Duration
+
+	// Journal, if set, is the path of a local file recording the progress
+	// of each run: the operations planned and those completed. It is
+	// removed when the run completes, so a journal left behind describes
+	// an interrupted run; see ReadJournal.
+	Journal string
+
+	// Priority is the order in which files are uploaded, key order by
+	// default. Runs stopped by MaxRequests, cancellation or QuotaFill keep
+	// the files uploaded first, so with smallest-first a time-boxed run
+	// uploads as many files as it can, and with newest-first the latest
+	// work is safe soonest.
+	Priority Priority
+
+	// MaxDuration and MaxTransfer, if positive, budget a run: once it has
+	// run for MaxDuration, counted from the start of Sync, or when the next
+	// upload would take the bytes uploaded past MaxTransfer, no more
+	// uploads are started. The one under way finishes, nothing is
+	// deleted, and Sync returns an error wrapping ErrBudget, with the
+	// progress saved as at MaxRequests. The first upload of a run is
+	// always made, so that a file larger than MaxTransfer is not left
+	// behind for good. Dry runs are not budgeted.
+	MaxDuration time.Duration
+	MaxTransfer int64
+
+	// MaxRequests, if positive, caps the requests a run makes to Dst. A
+	// run that reaches it stops early, keeping the uploads it finished and
+	// leaving the rest for the next run, and Sync returns an error wrapping
+	// ErrRequestLimit. Combine it with StateCache so that the next run
+	// does not check the same files again. Retries made by Breaker are not
+	// counted.
+	MaxRequests int
+	// RequestsPerSecond, if positive, spaces requests to Dst out to at
+	// most this rate.
+	RequestsPerSecond float64
+
+	// Bundle, if non-nil, packs files smaller than Bundle.Threshold into
+	// tar archives under BundlePrefix, listed in a BundleIndex, instead of
+	// uploading them one object each. Restore extracts them again. Dst
+	// must implement Getter.
+	Bundle *BundleOptions
+
+	// Chunk, if non-nil, splits files of at least Chunk.Threshold into
+	// chunks at boundaries found in their content, with FastCDC, and
+	// stores each chunk once under ChunkPrefix, recorded in a ChunkIndex;
+	// the object of such a file lists its chunks. Only the chunks a run
+	// has not stored before are uploaded, and those no file refers to any
+	// more are deleted once it completes. Chunks are compressed as set by
+	// Compression. Restore and the checksum comparison reassemble the
+	// files. Dst must implement Getter and cannot be a LocalDestination,
+	// and Snapshots, DetectRenames, DeleteTo and ObjectLock cannot be used.
+	Chunk *ChunkOptions
+
+	// Conflicts settles files changed on both sides in a TwoWay run.
+	Conflicts ConflictPolicy
+
+	// Isolate keeps a JobMarker naming this job, by host and source
+	// directory, at Dst, and looks for other jobs' markers before each
+	// run: at Dst itself, at the prefixes enclosing it in the same bucket
+	// if Dst is Prefixed and, for runs that may delete, under it. Runs
+	// that may delete refuse to start on finding one, failing with
+	// ErrPrefixOverlap; others only warn. Dst must implement Getter.
+	Isolate bool
+
+	// Lock, if set, is held while the run lasts, so that another run
+	// taking it fails with ErrLocked instead of syncing at the same time.
+	// Dry runs do not take it.
+	Lock *RunLock
+
+	// ReadOnly wraps Dst with ReadOnly so that no write can reach it, even
+	// if DryRun is unset.
+	ReadOnly bool
+
+	// Breaker, if non-nil, wraps Dst with WithBreaker so transient failures
+	// are retried and a dead destination stops the run quickly.
+	Breaker *BreakerOptions
+
+	// MaxChangeRatio, if non-zero, is the largest fraction (0–1) of the
+	// existing destination objects a run may replace or delete. Larger
+	// plans fail with ErrTooManyChanges unless Force is set.
+	MaxChangeRatio float64
+	Force          bool
+
+	// MaxDstSize, if positive, is the most bytes Dst may hold after a run,
+	// counting every object, foldersync's own included. Before a plan is
+	// applied, Dst is listed to estimate its size afterwards; the uploads
+	// matching each of QuotaTrim, lowest priority first, are then dropped
+	// until the rest fits, and the run fails with ErrQuotaExceeded if it
+	// still does not. Patterns match keys as Reupload does. Dst is asked
+	// about each object if it does not implement ObjectLister. With
+	// QuotaFill, such a run does not fail: the uploads left are made in
+	// order until the next would not fit, and the rest are skipped, each
+	// reported as over quota, for the next run to try again.
+	MaxDstSize int64
+	QuotaTrim  []string
+	QuotaFill  bool
+
+	// Manifest writes a manifest of the source tree to the destination
+	// after a successful run, signed with SigningKey if it is set.
+	Manifest   bool
+	SigningKey ed25519.PrivateKey
+	// ManifestChecksums records the SHA-256 of every file in the
+	// manifest, so that a Drill can check the files it restores. The
+	// hashes are kept by StateCache, which is required; files it recorded
+	// without one are hashed once, the next time they are looked up.
+	ManifestChecksums bool
+	// VerifyKey, if set, makes runs with Delete check the signature of the
+	// existing manifest first and refuse to run if it does not verify.
+	VerifyKey ed25519.PublicKey
+
+	// PreSync, if set, is called by Sync before it starts, for example to
+	// quiesce a database; if it fails, Sync fails without starting.
+	// PostSync, if set, is called once Sync has finished, successfully or
+	// not, with a summary of the run, for example to send a notification;
+	// its error is returned if the run succeeded. Watch and TwoWay do not
+	// call them.
+	PreSync  func(ctx context.Context) error
+	PostSync func(ctx context.Context, s Summary) error
+
+	// Notify, if set, sends notifications of Sync runs, after PostSync.
+	Notify *Notify
+
+	// Log, if set, receives the account of a run meant to be read, as the
+	// command line prints it: each change as it is made, and notes such as
+	// the request estimate of a dry run. OnEvent, if set, is called with
+	// each change as it is made. Sync also returns the changes in its
+	// Result.
+	Log     io.Writer
+	OnEvent func(Event)
+	// Warnings, if set, receives the warnings of a run, one to a line,
+	// such as of files left out because they could not be read, or of
+	// notifications that could not be sent. The command line prints them
+	// to standard error.
+	Warnings io.Writer
+
+	// Verbose, if positive, adds detail to what Sync prints to Log: at 1,
+	// how long planning and applying the run took and what the plan
+	// holds; at 2 and above, also each file found up to date.
+	Verbose int
+
+	// OnRunEvent, if set, is called with typed events following Sync and
+	// Watch runs as they go, for programs that show their progress: see
+	// RunEvent. It is never called from more than one goroutine at once,
+	// and the run waits for it to return. TwoWay sends only RunComplete,
+	// counting downloads as uploads and deletes of local files as deletes.
+	OnRunEvent func(RunEvent)
+
+	// KeepGoing carries on past files that fail to upload, rather than
+	// stopping at the first, and fails the run once it is done. The
+	// failures are listed in the Result of Sync. Nothing is deleted in a
+	// run with failures, and the failed files are left out of the
+	// manifest and the caches, so that the next run tries them again.
+	KeepGoing bool
+
+	// PerFileTimeout, if positive, fails the upload of a file taking
+	// longer than this with ErrFileTimeout. StallTimeout, if positive,
+	// aborts the upload of a file of which nothing has been read for this
+	// long, as when a connection is wedged, and uploads it again, failing
+	// it with ErrUploadStalled after a few tries. The destination's answer,
+	// once the whole file has been read, is only waited for up to
+	// PerFileTimeout. Both apply to files uploaded whole, not to bundles
+	// and chunks.
+	PerFileTimeout time.Duration
+	StallTimeout   time.Duration
+
+	// Metrics, if set, records each run Sync makes and each batch of
+	// changes Watch syncs. TwoWay does not record its runs.
+	Metrics *Metrics
+
+	// History, if set, is the path of a local file each run Sync makes is
+	// appended to, as a HistoryRun on a JSON line, for Trends to summarize.
+	// Dry runs are not recorded, nor are the changes Watch and TwoWay sync.
+	History string
+
+	// Control, if set, lets Watch be told to sync now, paused and resumed
+	// as it runs, and records its status and latest events. Sync and
+	// TwoWay do not use it.
+	Control *Control
+
+	// ScanSecrets enables the secret scanner: files that look like private
+	// keys, .env files or cloud credentials are flagged during planning.
+	ScanSecrets bool
+	// ConfirmSecrets is called with the files flagged by the scanner and
+	// reports whether they should be uploaded anyway. If nil, flagged files
+	// are excluded from the run.
+	ConfirmSecrets func(matches []SecretMatch) bool
+
+	// Confirm, if set, is called before the plan is applied with each
+	// upload, copy, bundled file and delete it holds, and reports whether
+	// to make it. Those it declines are left out of the run, and checked
+	// again by the next. If it fails, the run fails without changing
+	// anything. It is not called for dry runs or by TwoWay, and Watch
+	// refuses it.
+	Confirm func(e Event) (bool, error)
+
+	// SourceSnapshot, if set, snapshots the filesystem of each source
+	// directory before the run and syncs from the snapshot, deleting it
+	// afterwards, so that files changed during the run are copied as they
+	// were when it began, consistent with each other as after a crash.
+	// Dry runs read the source directories as they are. Watch and TwoWay
+	// refuse it. ZFSSnapshotter and LVMSnapshotter refuse directories with
+	// filesystems mounted below them, which their snapshots would lack.
+	SourceSnapshot Snapshotter
+
+	pacer     *pacer         // set by prepare: counts and paces requests to Dst
+	deadline  time.Time      // set by prepare if MaxDuration is
+	prices    *RequestPrices // set by prepare if Dst is a RequestPricer
+	metaCache *metaCache     // set by prepare if MetaCache is
+	hashCache *hashCache     // set by prepare if HashCache is
+	statDst   Destination    // set by prepare: Dst without the metadata cache, for VerifyAfterUpload
+	twoWay    bool           // set by TwoWay
+	changes   *[]Event       // set by Sync: the changes of its Result
+	events    *eventStream   // set by Sync and Watch if OnRunEvent is
+	// Set by Sync if SourceSnapshot is: the source directories read from
+	// their snapshots, for messages and markers.
+	sourceNames []string
+
+	// Set by prepare for Estimate, if Dst implements StoragePricer and
+	// partSizer.
+	storagePrices []StoragePrice
+	parts         partSizer
+}
+
+// ErrCanceled is returned by a run stopped because its context was
+// canceled, for example on SIGINT. The uploads and deletes finished by then
+// are kept and recorded in the state cache, and the journal describes the
+// rest; the next run picks up where this one stopped. The error is a
+// *CanceledError, which tells how far the run got.
+var ErrCanceled = errors.New("canceled")
+
+// CanceledError is the error of a run stopped by its context. It wraps
+// ErrCanceled and Err.
+type CanceledError struct {
+	Progress       // what the run planned, and finished, before it stopped
+	Planned  bool  // whether it stopped while applying its plan, not making it
+	Err      error // the error it stopped with, such as context.Canceled
+}
+
+func (e *CanceledError) Error() string {
+	if !e.Planned || e.Uploaded+e.Deleted == 0 {
+		return fmt.Sprintf("%v before anything was changed: %v", ErrCanceled, e.Err)
+	}
+	return fmt.Sprintf("%v after uploading %d of %d files and deleting %d of %d objects: %v",
+		ErrCanceled, e.Uploaded, e.Uploads, e.Deleted, e.Deletes, e.Err)
+}
+
+func (e *CanceledError) Unwrap() []error {
+	return []error{ErrCanceled, e.Err}
 }
 
 // Sync copies files from opts.Src to opts.Dst, skipping files that are
-// already up to date (matched by size and modification time).
-func Sync(ctx context.Context, opts Options) error {
-	if err := validateSrc(opts.Src); err != nil {
+// already up to date (by default, matched by size and modification time),
+// and returns what it did.
+func Sync(ctx context.Context, opts Options) (*Result, error) {
+	res := new(Result)
+	if opts.SavePlan != nil {
+		opts.DryRun = true
+	}
+	opts.changes = &res.Changes
+	opts.events = newEventStream(opts.OnRunEvent)
+	if f, ok := opts.Dst.(*FanOut); ok {
+		f.reset()
+		defer func() { res.Destinations = f.Results() }()
+	}
+	release, err := acquireRunLock(ctx, opts)
+	if err != nil {
+		err = classify(err)
+		res.Summary = summarize(opts, nil, time.Now(), err)
+		opts.Notify.send(ctx, res.Summary, opts.Warnings)
+		opts.emit(RunComplete{Summary: res.Summary})
+		return res, err
+	}
+	defer release()
+	err = runHooks(ctx, opts, res, func() (*Plan, error) {
+		opts, err := prepare(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		opts, releaseSnapshots, err := snapshotSources(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseSnapshots()
+		start := time.Now()
+		plan, err := buildPlan(ctx, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, &CanceledError{Err: err}
+			}
+			return nil, err
+		}
+		logPlan(opts, plan, time.Since(start))
+		if opts.SavePlan != nil {
+			if err := savePlan(opts, plan); err != nil {
+				return nil, fmt.Errorf("save plan: %w", err)
+			}
+		}
+		start = time.Now()
+		err = execute(ctx, opts, plan)
+		if opts.Verbose > 0 && !opts.DryRun {
+			opts.logf("applied in %s", FormatDuration(time.Since(start)))
+		}
+		return plan, err
+	})
+	return res, err
+}
+
+// prepare checks opts before a run and wraps opts.Dst as configured.
+func prepare(ctx context.Context, opts Options) (Options, error) {
+	opts, err := checkSources(opts)
+	if err != nil {
+		return opts, err
+	}
+	if _, ok := opts.Dst.(Getter); opts.Bundle != nil && !ok {
+		return opts, fmt.Errorf("bundling: destination cannot read objects back: %w", errors.ErrUnsupported)
+	}
+	if _, ok := opts.Dst.(Getter); opts.HardLinks && !ok {
+		return opts, fmt.Errorf("hard links: destination cannot read objects back: %w", errors.ErrUnsupported)
+	}
+	if _, ok := opts.Dst.(Getter); opts.KeepEmptyDirs && !ok {
+		return opts, fmt.Errorf("empty directories: destination cannot read objects back: %w", errors.ErrUnsupported)
+	}
+	if err := checkInFlightPatterns(opts.InFlightPatterns); err != nil {
+		return opts, err
+	}
+	if err := checkBudget(opts); err != nil {
+		return opts, err
+	}
+	if err := checkTransform(opts); err != nil {
+		return opts, err
+	}
+	if opts.MaxDuration > 0 {
+		opts.deadline = time.Now().Add(opts.MaxDuration)
+	}
+	if opts.HardLinks && opts.Snapshots {
+		return opts, errors.New("hard links cannot be combined with snapshots")
+	}
+	if err := checkIncremental(opts); err != nil {
+		return opts, err
+	}
+	if err := checkDedupe(opts); err != nil {
+		return opts, err
+	}
+	if err := checkRenames(opts); err != nil {
+		return opts, err
+	}
+	if opts.ManifestChecksums && (!opts.Manifest || opts.StateCache == "") {
+		return opts, errors.New("manifest checksums need a manifest and a state cache")
+	}
+	if opts.AppendUploads && !opts.Checksums {
+		return opts, errors.New("append uploads need checksums")
+	}
+	if err := checkObjectLock(ctx, opts); err != nil {
+		return opts, err
+	}
+	if err := checkTrash(opts); err != nil {
+		return opts, err
+	}
+	if err := checkChunks(opts); err != nil {
+		return opts, err
+	}
+	if err := checkFileList(opts); err != nil {
+		return opts, err
+	}
+	if err := checkSavedPlans(opts); err != nil {
+		return opts, err
+	}
+	if err := checkStaging(opts); err != nil {
+		return opts, err
+	}
+	if err := checkTiers(opts); err != nil {
+		return opts, err
+	}
+	if err := checkMetadata(opts); err != nil {
+		return opts, err
+	}
+	if opts.ModTimeWindow < 0 {
+		return opts, errors.New("the mtime window must not be negative")
+	}
+	if opts.ModTimeWindow > 0 {
+		opts.Compare = windowed(opts.Compare, opts.ModTimeWindow)
+	}
+	base := opts.Dst
+	if p, ok := opts.Dst.(RequestPricer); ok {
+		prices := p.RequestPrices()
+		opts.prices = &prices
+	}
+	if p, ok := opts.Dst.(StoragePricer); ok {
+		opts.storagePrices = p.StoragePrices()
+	}
+	if p, ok := opts.Dst.(partSizer); ok {
+		opts.parts = p
+	}
+	opts.Dst = withStatFallback(opts)
+	if opts.Breaker != nil {
+		opts.Dst = WithBreaker(opts.Dst, *opts.Breaker)
+	}
+	if opts.DryRun || opts.MaxRequests > 0 || opts.RequestsPerSecond > 0 {
+		opts.pacer = newPacer(opts.MaxRequests, opts.RequestsPerSecond)
+		opts.Dst = pacedDest{opts.Dst, opts.pacer}
+	}
+	opts.statDst = opts.Dst
+	if opts.HashCacheVerify < 0 || opts.HashCacheVerify > 1 {
+		return opts, fmt.Errorf("hash cache verify share %v is not between 0 and 1", opts.HashCacheVerify)
+	}
+	if opts.HashCache != "" {
+		opts.hashCache = loadHashCache(opts.HashCache, opts.HashCacheVerify, opts.Warnings)
+	}
+	if opts.MetaCache != "" && opts.MetaCacheMaxAge > 0 {
+		opts.metaCache = loadMetaCache(opts.MetaCache, opts.MetaCacheMaxAge, opts.Warnings)
+		opts.Dst = metaCacheDest{opts.Dst, opts.metaCache}
+	}
+	if opts.ReadOnly {
+		opts.Dst = ReadOnly(opts.Dst)
+	}
+	if opts.Isolate {
+		if err := checkIsolation(ctx, opts, base, opts.Delete || opts.twoWay); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Delete && opts.VerifyKey != nil {
+		if err := verifyManifest(ctx, opts.Dst, opts.VerifyKey); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// execute checks plan against the change threshold, applies it, and
+// records the result in the manifest and local caches.
+func execute(ctx context.Context, opts Options, plan *Plan) error {
+	err := executePlan(ctx, opts, plan)
+	if serr := opts.metaCache.save(); serr != nil && err == nil {
+		err = fmt.Errorf("save metadata cache: %w", serr)
+	}
+	if serr := opts.hashCache.save(); serr != nil && err == nil {
+		err = fmt.Errorf("save hash cache: %w", serr)
+	}
+	return err
+}
+
+func executePlan(ctx context.Context, opts Options, plan *Plan) error {
+	if err := checkThreshold(opts, plan); err != nil {
 		return err
 	}
-	if err := syncFiles(ctx, opts); err != nil {
+	if err := checkQuota(ctx, opts, plan); err != nil {
 		return err
 	}
-	if opts.Delete {
-		return deleteExtras(ctx, opts)
+	if opts.DryRun {
+		for _, f := range plan.Filtered {
+			opts.report(Event{Action: "skip", Key: f.Key, Reason: f.skipped})
+		}
+		if !opts.Estimate {
+			if err := applyPlan(ctx, opts, plan); err != nil {
+				return err
+			}
+			if opts.Itemize {
+				printItemSummary(opts, plan)
+			}
+		}
+		if plan.Incomplete {
+			opts.logf("the limit of %d requests would be reached; only the files checked so far are shown", opts.MaxRequests)
+		}
+		printEstimate(opts, plan)
+		if opts.Estimate {
+			printProjection(opts, plan)
+		}
+		return nil
+	}
+	if opts.Confirm != nil {
+		if err := confirmPlan(opts, plan); err != nil {
+			return err
+		}
+	}
+	if opts.Journal != "" {
+		j, err := openJournal(opts.Journal, strings.Join(sourceDirs(opts), ","), plan, opts.Warnings)
+		if err != nil {
+			return fmt.Errorf("open journal: %w", err)
+		}
+		plan.journal = j
+	}
+	if err := commit(ctx, opts, plan); err != nil {
+		plan.journal.close()
+		return err
+	}
+	if err := plan.journal.finish(); err != nil {
+		return fmt.Errorf("remove journal: %w", err)
 	}
 	return nil
 }
 
-func syncFiles(ctx context.Context, opts Options) error {
-	return filepath.WalkDir(opts.Src, func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
-			return err
+// commit applies plan and records the result.
+func commit(ctx context.Context, opts Options, plan *Plan) error {
+	if err := applyPlan(ctx, opts, plan); err != nil {
+		if ctx.Err() != nil {
+			return canceled(plan, err)
 		}
-
-		rel, err := filepath.Rel(opts.Src, path)
-		if err != nil {
+		return err
+	}
+	if plan.Incomplete {
+		// Only the state cache describes a partial run correctly.
+		if err := plan.state.saveProgress(); err != nil {
+			return fmt.Errorf("save state cache: %w", err)
+		}
+		if plan.budget != "" {
+			return fmt.Errorf("%w: %s; the rest is left for the next run", ErrBudget, plan.budget)
+		}
+		return fmt.Errorf("%w after %d requests; the rest is left for the next run", ErrRequestLimit, opts.MaxRequests)
+	}
+	if len(plan.failed) > 0 || len(plan.unreadable) > 0 {
+		failed := make(map[string]bool, len(plan.failed))
+		for _, e := range plan.failed {
+			failed[e.Key] = true
+		}
+		unreadable := make(map[string]bool, len(plan.unreadable))
+		for _, u := range plan.unreadable {
+			unreadable[u.Path] = true
+		}
+		plan.Files = slices.DeleteFunc(plan.Files, func(f File) bool { return failed[f.Key] || unreadable[f.Path] })
+	}
+	if opts.Manifest {
+		m := newManifest(plan.Files, plan.state)
+		if err := WriteManifest(ctx, opts.Dst, m, opts.SigningKey); err != nil {
 			return err
 		}
-		rel = filepath.ToSlash(rel) // S3 keys use forward slashes
+		if plan.state != nil {
+			plan.state.manifest = m.Created.Unix()
+		}
+		if opts.Snapshots {
+			if err := copyObject(ctx, opts.Dst, ManifestKey, snapshotKey(m.Created)); err != nil {
+				return fmt.Errorf("snapshot: %w", err)
+			}
+		}
+	}
+	if plan.state != nil {
+		if !plan.incremental {
+			plan.state.full = time.Now().Unix()
+		}
+		if err := plan.state.save(); err != nil {
+			return fmt.Errorf("save state cache: %w", err)
+		}
+	}
+	if plan.dirs != nil {
+		if err := plan.dirs.save(); err != nil {
+			return fmt.Errorf("save directory cache: %w", err)
+		}
+	}
+	if len(plan.failed) > 0 {
+		return &FailedError{Uploads: len(plan.Uploads), Failed: plan.failed}
+	}
+	return nil
+}
 
-		info, err := d.Info()
+// canceled records the progress of a run whose context was canceled while
+// plan was being applied, stopping it with err, and returns a
+// *CanceledError describing it.
+func canceled(plan *Plan, err error) error {
+	ce := &CanceledError{Progress: progress(plan), Planned: true, Err: err}
+	if serr := plan.state.saveProgress(); serr != nil {
+		return fmt.Errorf("%w; save state cache: %v", ce, serr)
+	}
+	return ce
+}
+
+func applyPlan(ctx context.Context, opts Options, plan *Plan) error {
+	if opts.Chunk != nil && !opts.DryRun {
+		idx, err := ReadChunkIndex(ctx, opts.Dst)
 		if err != nil {
 			return err
 		}
-
-		meta, err := opts.Dst.Stat(ctx, rel)
-		if err != nil {
-			return fmt.Errorf("stat %s: %w", rel, err)
+		plan.chunks = idx
+	}
+	for _, u := range plan.Uploads {
+		opts.emit(FileQueued{Key: u.Key, Size: u.Size})
+	}
+	for _, u := range plan.Uploads {
+		if overBudget(opts, plan, u.Size) {
+			return nil
 		}
-		if meta != nil && meta.ModTime.Equal(info.ModTime().Truncate(1e9)) && meta.Size == info.Size() {
-			return nil // already up to date
+		from, renamed := plan.renamed[u.Key]
+		original, duplicate := plan.duplicates[u.Key]
+		switch {
+		case renamed:
+			opts.report(Event{Action: "copy", Key: u.Key, From: from, Reason: "renamed"})
+		case duplicate && (opts.DryRun || plan.originals[original]):
+			opts.report(Event{Action: "copy", Key: u.Key, From: original, Reason: "duplicate"})
+		case duplicate:
+			opts.report(Event{Action: "upload", Key: u.Key, Reason: original + " was not uploaded", Item: opts.item(plan, u)})
+			duplicate = false
+		default:
+			opts.report(Event{Action: "upload", Key: u.Key, Item: opts.item(plan, u)})
 		}
-
-		fmt.Printf("upload %s\n", rel)
 		if opts.DryRun {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := preserveUpload(ctx, opts, plan, u); err != nil {
+			return err
+		}
+		var err error
+		if renamed {
+			if err = copyObject(ctx, opts.Dst, from, u.Key); errors.Is(err, fs.ErrNotExist) {
+				// Deleted by something else since it was recorded.
+				opts.report(Event{Action: "upload", Key: u.Key, Reason: from + " is gone", Item: opts.item(plan, u)})
+				renamed = false
+			} else if err == nil {
+				err = verifyUpload(ctx, opts, u.Key, u.meta())
+			}
+		}
+		if duplicate {
+			err = copyDuplicate(ctx, opts, u, original)
+			if errors.Is(err, ErrFileChanged) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, errors.ErrUnsupported) {
+				reason := "changed since it was hashed"
+				if !errors.Is(err, ErrFileChanged) {
+					reason = original + " cannot be copied"
+				}
+				opts.report(Event{Action: "upload", Key: u.Key, Reason: reason, Item: opts.item(plan, u)})
+				duplicate = false
+			}
+		}
+		copied := renamed || duplicate
+		planned := u
+		if !copied && opts.Chunk.chunks(u) {
+			err = uploadChunked(ctx, opts, plan.chunks, u)
+		} else if !copied {
+			err = uploadSettled(ctx, opts, plan, &u)
+		}
+		if errors.Is(err, ErrRequestLimit) {
+			plan.Incomplete = true
 			return nil
+		} else if err != nil && opts.OnWalkError == WalkErrorsSkip && readFailed(err, u.Path) && ctx.Err() == nil {
+			opts.emit(FileDone{Key: u.Key, Err: err})
+			plan.skipUnreadable(opts, u.Path, err)
+			plan.forget(opts, u.Key)
+			continue
+		} else if err != nil && opts.KeepGoing && ctx.Err() == nil {
+			opts.emit(FileDone{Key: u.Key, Err: err})
+			plan.failed = append(plan.failed, &FileError{Op: "upload", Key: u.Key, Err: err})
+			plan.forget(opts, u.Key)
+			continue
+		} else if err != nil {
+			opts.emit(FileDone{Key: u.Key, Err: err})
+			return &FileError{Op: "upload", Key: u.Key, Err: err}
+		}
+		if err := plan.journal.done("upload", u.Key); err != nil {
+			return err
+		}
+		if err := plan.state.record(u); err != nil {
+			return err
 		}
+		if err := plan.state.checkpoint(); err != nil {
+			return err
+		}
+		if plan.chunks != nil && !opts.Chunk.chunks(u) {
+			delete(plan.chunks.Files, u.Key)
+		}
+		plan.uploaded++
+		if !copied {
+			plan.uploadedBytes += u.Size
+		}
+		if _, ok := plan.originals[u.Key]; ok && u.Size == planned.Size && u.ModTime.Equal(planned.ModTime) {
+			plan.originals[u.Key] = true
+		}
+		opts.emit(FileDone{Key: u.Key})
+	}
+	if err := applyTransitions(ctx, opts, plan); err != nil {
+		return err
+	}
+	if err := applyBundles(ctx, opts, plan); err != nil {
+		return err
+	}
+	if plan.Incomplete || len(plan.failed) > 0 {
+		return nil
+	}
+	// Before the objects the links stand for are deleted.
+	if err := applyLinks(ctx, opts, plan); err != nil {
+		return err
+	}
+	if err := applyEmptyDirs(ctx, opts, plan); err != nil {
+		return err
+	}
 
-		f, err := os.Open(path)
+	for _, key := range plan.Expiring {
+		opts.report(Event{Action: "expire", Key: key, Reason: "lifecycle rule"})
+	}
+	for _, key := range plan.Deletes {
+		opts.report(Event{Action: "delete", Key: key, Reason: opts.deleteReason()})
+	}
+	if opts.DryRun {
+		return nil
+	}
+	run := time.Now()
+	for _, key := range plan.Deletes {
+		if err := preserveDelete(ctx, opts, plan, key); err != nil {
+			return err
+		}
+		if err := trashDelete(ctx, opts, run, key); err != nil {
+			return err
+		}
+	}
+	if err := applyDeletes(ctx, opts, plan); err != nil {
+		return err
+	}
+	if plan.chunks != nil && plan.uploaded+plan.deleted > 0 {
+		return collectChunks(ctx, opts, plan.chunks)
+	}
+	return nil
+}
+
+// deleteBatchSize is how many keys applyDeletes hands a BatchDeleter at
+// once, the most an S3 DeleteObjects request takes.
+const deleteBatchSize = 1000
+
+// applyDeletes deletes plan.Deletes in batches if opts.Dst implements
+// BatchDeleter, or else one at a time.
+func applyDeletes(ctx context.Context, opts Options, plan *Plan) error {
+	remaining := plan.Deletes
+	for len(remaining) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch := remaining[:min(len(remaining), deleteBatchSize)]
+		deleted, err := deleteBatch(ctx, opts.Dst, batch)
+		if errors.Is(err, errors.ErrUnsupported) {
+			break
+		}
+		for _, key := range deleted {
+			if err := recordDelete(opts, plan, key); err != nil {
+				return err
+			}
+		}
 		if err != nil {
 			return err
 		}
-		defer f.Close()
+		remaining = remaining[len(batch):]
+	}
+	for _, key := range remaining {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := opts.Dst.Delete(ctx, key); err != nil {
+			return &FileError{Op: "delete", Key: key, Err: err}
+		}
+		if err := recordDelete(opts, plan, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		return opts.Dst.Put(ctx, rel, f, info.Size(), info.ModTime())
-	})
+// recordDelete notes that the object at key has been deleted.
+func recordDelete(opts Options, plan *Plan, key string) error {
+	if err := plan.journal.done("delete", key); err != nil {
+		return err
+	}
+	plan.state.forget(key)
+	if plan.chunks != nil {
+		delete(plan.chunks.Files, key)
+	}
+	plan.deleted++
+	opts.emit(DeleteDone{Key: key})
+	return nil
 }
 
-func deleteExtras(ctx context.Context, opts Options) error {
-	keys, err := opts.Dst.List(ctx)
+// upload uploads u. If hash is set, the hash of its content is wanted
+// afterwards, and is taken from the bytes read for the upload if it can
+// be, rather than reading the file again.
+func upload(ctx context.Context, opts Options, u File, hash bool) error {
+	var f *os.File
+	err := retrySource(ctx, opts, func() (err error) { f, err = os.Open(u.Path); return err })
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	for _, key := range keys {
-		localPath := filepath.Join(opts.Src, filepath.FromSlash(key))
-		if _, err := os.Stat(localPath); os.IsNotExist(err) {
-			fmt.Printf("delete %s\n", key)
-			if !opts.DryRun {
-				if err := opts.Dst.Delete(ctx, key); err != nil {
-					return fmt.Errorf("delete %s: %w", key, err)
-				}
-			}
+	meta, err := uploadMeta(opts, u, f)
+	if err != nil {
+		return err
+	}
+	if appended, err := uploadAppended(ctx, opts, u, f, meta); appended || err != nil {
+		return err
+	}
+	// Bodies that can be read at any offset are uploaded in parts read,
+	// and retried, on their own.
+	content, size := uploadBody(f), u.Size
+	if opts.Sparse {
+		extents, err := dataExtents(f, u.Size)
+		if err != nil {
+			return err
+		}
+		if isSparse(extents, u.Size) {
+			meta.Sparse = true
+			sb := sparseBody(f, extents)
+			content, size = sb, sb.Size()
+		}
+	}
+	var hb *hashingBody
+	if hash && !meta.Sparse && !u.hashed() {
+		hb = newHashingBody(content, size)
+		content = hb
+	}
+	ctx, content, stop := opts.watchUpload(ctx, content)
+	content = opts.trackUpload(u.Key, content, size)
+	var body io.Reader = content
+	if opts.Transform != nil {
+		meta.Transform = opts.TransformName
+		tb, err := transformBody(opts.Transform, u.Key, content, size)
+		if err != nil {
+			return stop(err)
+		}
+		defer tb.Close()
+		body = tb
+	}
+	if opts.Compression != "" {
+		meta.Compression = opts.Compression
+		rc := compressBody(opts.Compression, content, size)
+		defer rc.Close()
+		body = rc
+	}
+	if opts.StageUploads {
+		if err := stop(putStaged(ctx, opts, u.Key, body, meta)); err != nil {
+			return err
+		}
+		return settleHash(f, u, hb)
+	}
+	if err := stop(opts.Dst.Put(ctx, u.Key, body, meta)); err != nil {
+		return err
+	}
+	if err := settleHash(f, u, hb); err != nil {
+		return err
+	}
+	return verifyUpload(ctx, opts, u.Key, meta)
+}
+
+// uploadMeta returns the metadata to upload u with, whose content is read
+// from f if its type has to be sniffed.
+func uploadMeta(opts Options, u File, f *os.File) (ObjectMeta, error) {
+	meta := u.meta()
+	meta.Tags, meta.Lock = opts.Tags, opts.ObjectLock
+	applyMetadata(opts.Metadata, u.Key, &meta)
+	var err error
+	if meta.ContentType, err = contentType(opts.ContentType, u.Key, f); err != nil {
+		return meta, err
+	}
+	if opts.Checksums {
+		// Sent before the content, so hashed first, unless comparing the
+		// file has already.
+		sum, err := u.sha256()
+		if err != nil {
+			return meta, err
+		}
+		meta.SHA256 = hex.EncodeToString(sum)
+	}
+	return meta, nil
+}
+
+// settleHash checks that f, uploaded for u, did not change while it was
+// read, and then records the hash hb took of it, if hb is set and read it
+// whole.
+func settleHash(f *os.File, u File, hb *hashingBody) error {
+	if err := checkUnchanged(f, u); err != nil {
+		return err
+	}
+	if hb != nil {
+		if sum, ok := hb.sum(); ok {
+			u.setSHA256(sum)
 		}
 	}
 	return nil
 }
 
+// verifyUpload fails with ErrUploadMismatch unless the object at key
+// matches want, the metadata it was written with, if opts.VerifyAfterUpload
+// is set.
+func verifyUpload(ctx context.Context, opts Options, key string, want ObjectMeta) error {
+	if !opts.VerifyAfterUpload {
+		return nil
+	}
+	dst := opts.statDst
+	if dst == nil {
+		dst = opts.Dst
+	}
+	got, err := dst.Stat(ctx, key)
+	switch {
+	case err != nil:
+		return fmt.Errorf("verify upload: %w", err)
+	case got == nil:
+		return fmt.Errorf("%w: object is missing", ErrUploadMismatch)
+	case got.Size != want.Size:
+		return fmt.Errorf("%w: %d bytes stored, %d sent", ErrUploadMismatch, got.Size, want.Size)
+	case want.SHA256 != "" && got.SHA256 != want.SHA256:
+		return fmt.Errorf("%w: SHA-256 %q recorded, %s sent", ErrUploadMismatch, got.SHA256, want.SHA256)
+	}
+	return nil
+}
+
 func validateSrc(src string) error {
 	info, err := os.Stat(src)
-	if err != nil {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrSourceMissing, err)
+	} else if err != nil {
 		return fmt.Errorf("source: %w", err)
 	}
 	if !info.IsDir() {
-		return fmt.Errorf("source %q is not a directory", src)
+		return fmt.Errorf("%w: %q is not a directory", ErrSourceMissing, src)
 	}
 	return nil
 }
@@ -1,10 +1,17 @@
 package sync
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -12,35 +19,76 @@ import (
 // mockDest is an in-memory Destination for testing.
 type mockDest struct {
 	objects     map[string]*ObjectMeta
+	data        map[string][]byte
 	putCalls    []string
 	deleteCalls []string
+	statCalls   []string
+	copyCalls   []string
+	written     map[string]time.Time // for ListWritten
 }
 
 func newMockDest() *mockDest {
-	return &mockDest{objects: make(map[string]*ObjectMeta)}
+	return &mockDest{
+		objects: make(map[string]*ObjectMeta),
+		data:    make(map[string][]byte),
+		written: make(map[string]time.Time),
+	}
 }
 
-func (m *mockDest) Put(_ context.Context, key string, _ io.Reader, size int64, modTime time.Time) error {
+func (m *mockDest) Put(_ context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
 	m.putCalls = append(m.putCalls, key)
-	m.objects[key] = &ObjectMeta{Size: size, ModTime: modTime.Truncate(time.Second)}
+	m.data[key] = data
+	meta.ModTime = meta.ModTime.Truncate(time.Second)
+	m.objects[key] = &meta
+	m.written[key] = time.Now()
 	return nil
 }
 
 func (m *mockDest) Stat(_ context.Context, key string) (*ObjectMeta, error) {
+	m.statCalls = append(m.statCalls, key)
 	return m.objects[key], nil
 }
 
-func (m *mockDest) List(_ context.Context) ([]string, error) {
-	keys := make([]string, 0, len(m.objects))
+func (m *mockDest) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	data, ok := m.data[key]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *mockDest) Copy(_ context.Context, src, dst string) error {
+	meta, ok := m.objects[src]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	m.copyCalls = append(m.copyCalls, src)
+	copied := *meta
+	m.objects[dst] = &copied
+	m.data[dst] = m.data[src]
+	return nil
+}
+
+func (m *mockDest) List(_ context.Context, fn func(keys []string) error) error {
+	return fn(slices.Sorted(maps.Keys(m.objects)))
+}
+
+func (m *mockDest) ListWritten(_ context.Context) (map[string]time.Time, error) {
+	written := make(map[string]time.Time, len(m.objects))
 	for k := range m.objects {
-		keys = append(keys, k)
+		written[k] = m.written[k]
 	}
-	return keys, nil
+	return written, nil
 }
 
 func (m *mockDest) Delete(_ context.Context, key string) error {
 	m.deleteCalls = append(m.deleteCalls, key)
 	delete(m.objects, key)
+	delete(m.data, key)
 	return nil
 }
 
@@ -67,7 +115,7 @@ func TestSync_uploadsNewFiles(t *testing.T) {
 	writeFile(t, src, "b.txt", "world")
 
 	dst := newMockDest()
-	if err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -86,7 +134,7 @@ func TestSync_skipsUpToDateFiles(t *testing.T) {
 		ModTime: info.ModTime().Truncate(time.Second),
 	}
 
-	if err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -105,7 +153,7 @@ func TestSync_reuploadsWhenMtimeDiffers(t *testing.T) {
 		ModTime: info.ModTime().Truncate(time.Second).Add(-time.Hour),
 	}
 
-	if err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -124,7 +172,7 @@ func TestSync_reuploadsWhenSizeDiffers(t *testing.T) {
 		ModTime: info.ModTime().Truncate(time.Second),
 	}
 
-	if err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -133,6 +181,50 @@ func TestSync_reuploadsWhenSizeDiffers(t *testing.T) {
 	}
 }
 
+func TestSync_reuploadForcesMatchingFiles(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "sub/b.txt", "b")
+	writeFile(t, src, "sub/c.log", "c")
+
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	dst.putCalls = nil
+	opts.Reupload = []string{"sub/*.txt", "a.txt"}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(dst.putCalls)
+	if want := []string{"a.txt", "sub/b.txt"}; !slices.Equal(dst.putCalls, want) {
+		t.Errorf("uploaded %v, want %v", dst.putCalls, want)
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"a.txt", "a.txt", true},
+		{"*.txt", "a.txt", true},
+		{"*.txt", "sub/a.txt", false},
+		{"sub", "sub/a.txt", true},
+		{"sub", "sub/deeper/a.txt", true},
+		{"sub/*", "sub/deeper/a.txt", true},
+		{"sub", "subway/a.txt", false},
+		{"[", "a.txt", false},
+	}
+	for _, tt := range tests {
+		if got := matchKey([]string{tt.pattern}, tt.key); got != tt.want {
+			t.Errorf("matchKey(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
 func TestSync_deleteMode(t *testing.T) {
 	src := t.TempDir()
 	writeFile(t, src, "keep.txt", "keep")
@@ -141,7 +233,7 @@ func TestSync_deleteMode(t *testing.T) {
 	dst.objects["keep.txt"] = &ObjectMeta{}
 	dst.objects["extra.txt"] = &ObjectMeta{}
 
-	if err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true}); err != nil {
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -153,6 +245,166 @@ func TestSync_deleteMode(t *testing.T) {
 	}
 }
 
+func TestSync_deletePagedListing(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a", "c/d", "e"} {
+		writeFile(t, src, name, name)
+	}
+	dst := &pagedDest{mockDest: newMockDest(), failAfter: -1}
+	for _, key := range []string{"a", "b", "c/d", "c/x", "e", "f"} {
+		dst.objects[key] = &ObjectMeta{}
+	}
+
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "c/x", "f"}; !slices.Equal(dst.deleteCalls, want) {
+		t.Errorf("deleted %v, want %v", dst.deleteCalls, want)
+	}
+}
+
+// syncTwiceKeepsAll syncs opts twice with Delete, to a LocalDestination,
+// and fails unless the second run keeps every object of the first.
+func syncTwiceKeepsAll(t *testing.T, opts Options) []string {
+	t.Helper()
+	ctx := context.Background()
+	opts.Dst, opts.Delete = NewLocalDestination(t.TempDir()), true
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := listKeys(ctx, opts.Dst)
+	res, err := Sync(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Deleted != 0 {
+		t.Errorf("second run deleted %d objects: %v", res.Deleted, res.Changes)
+	}
+	if second, _ := listKeys(ctx, opts.Dst); !slices.Equal(second, first) {
+		t.Errorf("second run left %q, want %q", second, first)
+	}
+	return first
+}
+
+func TestSync_deleteLeavesExpiringObjects(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "keep.txt", "keep")
+
+	dst := newMockDest()
+	now := time.Now()
+	for key, age := range map[string]time.Duration{"keep.txt": 60, "old.txt": 40, "recent.txt": 10} {
+		dst.objects[key] = &ObjectMeta{}
+		dst.written[key] = now.Add(-age * 24 * time.Hour)
+	}
+
+	opts := Options{Src: src, Dst: dst, Delete: true, ExpireAfter: 30 * 24 * time.Hour}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(dst.deleteCalls) != 1 || dst.deleteCalls[0] != "recent.txt" {
+		t.Errorf("expected only recent.txt to be deleted, got %v", dst.deleteCalls)
+	}
+	if _, ok := dst.objects["old.txt"]; !ok {
+		t.Error("old.txt should have been left for its lifecycle rule")
+	}
+}
+
+func TestSync_reportExtraneous(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "keep.txt", "keep")
+
+	dst := newMockDest()
+	for _, key := range []string{"keep.txt", "z.txt", "sub/a.txt", ManifestKey} {
+		dst.objects[key] = &ObjectMeta{}
+	}
+
+	report := filepath.Join(t.TempDir(), "extraneous.txt")
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, ReportExtraneous: report}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.deleteCalls) != 0 {
+		t.Errorf("deleted %v without Delete", dst.deleteCalls)
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "sub/a.txt\nz.txt\n"; string(data) != want {
+		t.Errorf("report = %q, want %q", data, want)
+	}
+}
+
+// batchDest is a mockDest that deletes objects in batches, failing to
+// delete the keys in fail.
+type batchDest struct {
+	*mockDest
+	batches [][]string
+	fail    map[string]bool
+}
+
+func (d *batchDest) DeleteBatch(_ context.Context, keys []string) ([]string, error) {
+	d.batches = append(d.batches, keys)
+	var deleted []string
+	var err error
+	for _, key := range keys {
+		if d.fail[key] {
+			err = fmt.Errorf("delete %s: access denied", key)
+			continue
+		}
+		delete(d.objects, key)
+		deleted = append(deleted, key)
+	}
+	return deleted, err
+}
+
+func TestSync_deleteInBatches(t *testing.T) {
+	src := t.TempDir()
+	dst := &batchDest{mockDest: newMockDest()}
+	for i := range 2500 {
+		dst.objects[fmt.Sprintf("old/%04d.txt", i)] = &ObjectMeta{}
+	}
+
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	for _, b := range dst.batches {
+		sizes = append(sizes, len(b))
+	}
+	if !slices.Equal(sizes, []int{1000, 1000, 500}) || len(dst.deleteCalls) != 0 || len(dst.objects) != 0 {
+		t.Errorf("batches of %v, %d single deletes, %d objects left; want 1000, 1000 and 500 in batches", sizes, len(dst.deleteCalls), len(dst.objects))
+	}
+}
+
+func TestSync_deleteBatchFails(t *testing.T) {
+	src := t.TempDir()
+	dst := &batchDest{mockDest: newMockDest(), fail: map[string]bool{"c.txt": true}}
+	for _, key := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		dst.objects[key] = &ObjectMeta{}
+	}
+
+	var got Summary
+	opts := Options{
+		Src: src, Dst: dst, Delete: true,
+		PostSync: func(_ context.Context, s Summary) error { got = s; return nil },
+	}
+	if _, err := Sync(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "c.txt") {
+		t.Fatalf("Sync = %v, want the failed key", err)
+	}
+	if got.Deletes != 4 || got.Deleted != 3 {
+		t.Errorf("summary: %d deletes, %d deleted; want 4 and 3", got.Deletes, got.Deleted)
+	}
+}
+
+func TestSync_expireAfterUnsupported(t *testing.T) {
+	src := t.TempDir()
+	opts := Options{Src: src, Dst: NewLocalDestination(t.TempDir()), Delete: true, ExpireAfter: time.Hour}
+	if _, err := Sync(context.Background(), opts); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("err = %v, want errors.ErrUnsupported", err)
+	}
+}
+
 func TestSync_dryRunSkipsAllWrites(t *testing.T) {
 	src := t.TempDir()
 	writeFile(t, src, "new.txt", "new")
@@ -160,7 +412,7 @@ func TestSync_dryRunSkipsAllWrites(t *testing.T) {
 	dst := newMockDest()
 	dst.objects["stale.txt"] = &ObjectMeta{}
 
-	if err := Sync(context.Background(), Options{Src: src, Dst: dst, DryRun: true, Delete: true}); err != nil {
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, DryRun: true, Delete: true}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -172,13 +424,55 @@ func TestSync_dryRunSkipsAllWrites(t *testing.T) {
 	}
 }
 
+func TestSync_dryRunInKeyOrder(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a/b", "a.txt", "a b", "B.txt", "a/c/d"} {
+		writeFile(t, src, name, name)
+	}
+	dst := newMockDest()
+	dst.objects["z.old"], dst.objects["a.old"] = &ObjectMeta{}, &ObjectMeta{}
+
+	var outputs []string
+	for range 2 {
+		var out bytes.Buffer
+		if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, DryRun: true, Delete: true, Log: &out}); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, out.String())
+	}
+	want := "upload B.txt\nupload a b\nupload a.txt\nupload a/b\nupload a/c/d\ndelete a.old\ndelete z.old\n"
+	if !strings.HasPrefix(outputs[0], want) || outputs[1] != outputs[0] {
+		t.Errorf("dry runs printed\n%s\nand\n%s\nwant both to start\n%s", outputs[0], outputs[1], want)
+	}
+}
+
+func TestSync_tags(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "large.txt", "larger")
+	writeFile(t, src, "small.txt", "s")
+	dst := newMockDest()
+	tags := map[string]string{"backup": "foldersync"}
+	opts := Options{Src: src, Dst: dst, Tags: tags, Bundle: &BundleOptions{Threshold: 4, MaxSize: 1 << 20}}
+
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	// Files and bundles are tagged; foldersync's own objects are not.
+	for key, meta := range dst.objects {
+		want := key == "large.txt" || strings.HasPrefix(key, BundlePrefix) && key != BundleIndexKey
+		if got := meta.Tags["backup"] == "foldersync"; got != want {
+			t.Errorf("%s: tags = %v", key, meta.Tags)
+		}
+	}
+}
+
 func TestSync_nestedDirectories(t *testing.T) {
 	src := t.TempDir()
 	writeFile(t, src, "a/x.txt", "x")
 	writeFile(t, src, "a/b/y.txt", "y")
 
 	dst := newMockDest()
-	if err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -196,7 +490,7 @@ func TestSync_nestedDirectories(t *testing.T) {
 
 func TestSync_invalidSrc(t *testing.T) {
 	dst := newMockDest()
-	err := Sync(context.Background(), Options{Src: "/nonexistent/path", Dst: dst})
+	_, err := Sync(context.Background(), Options{Src: "/nonexistent/path", Dst: dst})
 	if err == nil {
 		t.Error("expected error for nonexistent source, got nil")
 	}
@@ -211,8 +505,117 @@ func TestSync_srcMustBeDirectory(t *testing.T) {
 	t.Cleanup(func() { os.Remove(f.Name()) })
 
 	dst := newMockDest()
-	err = Sync(context.Background(), Options{Src: f.Name(), Dst: dst})
+	_, err = Sync(context.Background(), Options{Src: f.Name(), Dst: dst})
 	if err == nil {
 		t.Error("expected error when src is a file, got nil")
 	}
 }
+
+func TestSync_canceledWhilePlanning(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	dst := newMockDest()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Sync(ctx, Options{Src: src, Dst: dst})
+	var ce *CanceledError
+	if !errors.As(err, &ce) || ce.Planned {
+		t.Fatalf("Sync = %v, want a CanceledError while planning", err)
+	}
+	if len(dst.statCalls) != 0 || len(dst.putCalls) != 0 {
+		t.Errorf("checked %v and uploaded %v after cancellation", dst.statCalls, dst.putCalls)
+	}
+	if want := "canceled before anything was changed: context canceled"; err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
+
+// cancelingDest cancels its run after the first upload.
+type cancelingDest struct {
+	*mockDest
+	cancel context.CancelFunc
+}
+
+func (d cancelingDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	defer d.cancel()
+	return d.mockDest.Put(ctx, key, r, meta)
+}
+
+func TestSync_canceled(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, src, name, name)
+	}
+	dst := newMockDest()
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := Options{Src: src, Dst: cancelingDest{dst, cancel}, StateCache: filepath.Join(t.TempDir(), "state.json")}
+
+	_, err := Sync(ctx, opts)
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Sync = %v, want ErrCanceled", err)
+	}
+	if len(dst.putCalls) != 1 {
+		t.Fatalf("uploaded %v after cancellation", dst.putCalls)
+	}
+	var ce *CanceledError
+	if !errors.As(err, &ce) || !ce.Planned || ce.Uploaded != 1 || ce.Uploads != 3 || ce.Files != 3 {
+		t.Errorf("err = %#v, want 1 of 3 uploads done", ce)
+	}
+
+	// The next run knows what the canceled one finished.
+	opts.Dst = dst
+	dst.putCalls, dst.statCalls = nil, nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 2 || len(dst.statCalls) != 2 {
+		t.Errorf("next run uploaded %v and checked %v, want the other two files", dst.putCalls, dst.statCalls)
+	}
+}
+
+// truncatingDest stores its object for key a byte short, as a faulty
+// destination might while reporting success.
+type truncatingDest struct {
+	*mockDest
+	key string
+}
+
+func (d truncatingDest) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := d.mockDest.Put(ctx, key, r, meta); err != nil || key != d.key {
+		return err
+	}
+	d.data[key] = d.data[key][:len(d.data[key])-1]
+	d.objects[key].Size--
+	return nil
+}
+
+func TestSync_verifyAfterUpload(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "alpha")
+	writeFile(t, src, "b.txt", "bravo")
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: truncatingDest{dst, "b.txt"}}
+
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatalf("Sync without VerifyAfterUpload = %v, want the loss unnoticed", err)
+	}
+
+	// The metadata cache would answer with what was sent.
+	dst.Delete(context.Background(), "b.txt")
+	opts.VerifyAfterUpload = true
+	opts.MetaCache, opts.MetaCacheMaxAge = filepath.Join(t.TempDir(), "meta.json"), time.Hour
+	_, err := Sync(context.Background(), opts)
+	if !errors.Is(err, ErrUploadMismatch) {
+		t.Fatalf("Sync = %v, want ErrUploadMismatch", err)
+	}
+	if want := "4 bytes stored, 5 sent"; !strings.Contains(err.Error(), want) {
+		t.Errorf("err = %q, want it to contain %q", err, want)
+	}
+
+	dst.Delete(context.Background(), "b.txt")
+	opts.Dst, opts.MetaCache = dst, ""
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatalf("Sync of an intact upload = %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooManyChanges is returned when a plan would replace or delete a larger
+// share of the destination than Options.MaxChangeRatio allows.
+var ErrTooManyChanges = errors.New("too many changes planned")
+
+// changeRatio reports how many existing destination objects plan would
+// replace or delete, out of all the existing objects it knows about.
+func changeRatio(plan *Plan) (changed, total int) {
+	for _, f := range plan.Files {
+		if f.Remote != nil {
+			total++
+		}
+	}
+	for _, u := range plan.Uploads {
+		if u.Remote != nil {
+			changed++
+		}
+	}
+	return changed + len(plan.Deletes), total + len(plan.Deletes)
+}
+
+// checkThreshold fails with ErrTooManyChanges if plan exceeds
+// opts.MaxChangeRatio, unless opts.Force is set, in which case it only
+// warns. Mass changes like this usually mean ransomware or a source that
+// failed to mount, and should not propagate to the backup unreviewed.
+func checkThreshold(opts Options, plan *Plan) error {
+	if opts.MaxChangeRatio <= 0 || opts.FileList != nil || opts.ApplyPlan != nil {
+		return nil
+	}
+	changed, total := changeRatio(plan)
+	if total == 0 || float64(changed)/float64(total) <= opts.MaxChangeRatio {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%d of %d destination objects would be replaced or deleted (limit %.0f%%)",
+		changed, total, opts.MaxChangeRatio*100)
+	if opts.Force {
+		opts.warnf("%s", msg)
+		return nil
+	}
+	return fmt.Errorf("%w: %s; rerun with force to proceed", ErrTooManyChanges, msg)
+}
@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSync_thresholdBlocksMassChanges(t *testing.T) {
+	src := t.TempDir()
+	dst := newMockDest()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		info := writeFile(t, src, name, "new content")
+		// Every remote copy is stale, as if the source had been encrypted.
+		dst.objects[name] = &ObjectMeta{Size: info.Size(), ModTime: time.Unix(0, 0)}
+	}
+
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, MaxChangeRatio: 0.2})
+	if !errors.Is(err, ErrTooManyChanges) {
+		t.Fatalf("expected ErrTooManyChanges, got %v", err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("expected no uploads, got %v", dst.putCalls)
+	}
+
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, MaxChangeRatio: 0.2, Force: true}); err != nil {
+		t.Fatalf("expected Force to override the threshold, got %v", err)
+	}
+	if len(dst.putCalls) != 4 {
+		t.Errorf("expected 4 uploads with Force, got %v", dst.putCalls)
+	}
+}
+
+func TestSync_thresholdIgnoresNewFiles(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	writeFile(t, src, "b.txt", "world")
+
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, MaxChangeRatio: 0.2}); err != nil {
+		t.Fatalf("new files should not count as changes, got %v", err)
+	}
+}
+
+func TestSync_thresholdCountsDeletes(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "keep.txt", "keep")
+
+	dst := newMockDest()
+	dst.objects["keep.txt"] = &ObjectMeta{Size: info.Size(), ModTime: info.ModTime().Truncate(time.Second)}
+	dst.objects["x.txt"] = &ObjectMeta{}
+	dst.objects["y.txt"] = &ObjectMeta{}
+
+	_, err := Sync(context.Background(), Options{Src: src, Dst: dst, Delete: true, MaxChangeRatio: 0.5})
+	if !errors.Is(err, ErrTooManyChanges) {
+		t.Fatalf("expected ErrTooManyChanges for 2 of 3 objects deleted, got %v", err)
+	}
+	if len(dst.deleteCalls) != 0 {
+		t.Errorf("expected no deletes, got %v", dst.deleteCalls)
+	}
+}
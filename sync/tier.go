@@ -0,0 +1,148 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StorageTier assigns a storage class to the files matching Pattern that
+// were last modified at least MinAge ago. See Options.Tiers.
+type StorageTier struct {
+	Pattern string // as for CompareRule; empty matches every file
+	MinAge  time.Duration
+	Class   string
+}
+
+// ParseStorageTier parses a tier given on the command line as
+// [pattern=]class:age, with an age accepted by ParseAge: for example
+// "DEEP_ARCHIVE:90d" or "logs/*=GLACIER:30d".
+func ParseStorageTier(s string) (StorageTier, error) {
+	var t StorageTier
+	rest := s
+	if pattern, r, ok := strings.Cut(s, "="); ok {
+		if pattern == "" {
+			return t, fmt.Errorf("tier %q: empty pattern", s)
+		}
+		t.Pattern, rest = pattern, r
+	}
+	class, age, ok := strings.Cut(rest, ":")
+	if !ok || class == "" {
+		return t, fmt.Errorf("tier %q: want [pattern=]class:age", s)
+	}
+	minAge, err := ParseAge(age)
+	if err != nil {
+		return t, fmt.Errorf("tier %q: %w", s, err)
+	}
+	t.Class, t.MinAge = class, minAge
+	return t, nil
+}
+
+// tierClass returns the storage class tiers assign to the file stored
+// under key, last modified at modTime: that of the first tier whose
+// pattern matches it and whose age it has reached, or "" for the
+// destination's default.
+func tierClass(tiers []StorageTier, key string, modTime, now time.Time) string {
+	age := now.Sub(modTime)
+	for _, t := range tiers {
+		if (t.Pattern == "" || matchRule(t.Pattern, key)) && age >= t.MinAge {
+			return t.Class
+		}
+	}
+	return ""
+}
+
+// Reclassifier is implemented by destinations that can move an object to
+// another storage class in place, such as with a copy onto itself.
+type Reclassifier interface {
+	// SetStorageClass moves the object at key, with its metadata, to
+	// class. If key is absent the error wraps fs.ErrNotExist.
+	SetStorageClass(ctx context.Context, key, class string) error
+}
+
+// setStorageClass moves key to class within d, or fails with
+// errors.ErrUnsupported if d cannot.
+func setStorageClass(ctx context.Context, d Destination, key, class string) error {
+	r, ok := d.(Reclassifier)
+	if !ok {
+		return fmt.Errorf("set storage class of %s: %w", key, errors.ErrUnsupported)
+	}
+	return r.SetStorageClass(ctx, key, class)
+}
+
+// checkTiers reports whether opts.Tiers can be used with Dst and the rest
+// of opts.
+func checkTiers(opts Options) error {
+	if len(opts.Tiers) == 0 {
+		return nil
+	}
+	for _, t := range opts.Tiers {
+		if t.Class == "" || t.MinAge < 0 {
+			return fmt.Errorf("tier %+v: want a storage class and an age that is not negative", t)
+		}
+	}
+	switch {
+	case opts.twoWay:
+		return errors.New("storage tiers cannot be used in two-way sync")
+	case opts.Incremental:
+		return errors.New("storage tiers cannot be used in incremental runs, which do not look at the destination")
+	case opts.Chunk != nil:
+		return errors.New("storage tiers cannot be combined with chunking, whose chunks are shared between files")
+	}
+	if _, ok := opts.Dst.(Reclassifier); !ok {
+		return fmt.Errorf("storage tiers: destination has no storage classes to move objects between: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// planTransition adds file, up to date at the destination, to
+// plan.Transitions if its object is not in the storage class opts.Tiers
+// assigns it. Archived objects, which cannot be copied without restoring
+// them and usually got there by a lifecycle rule, are left where they are.
+func planTransition(opts Options, plan *Plan, file File) error {
+	if file.Class == "" || file.Remote.StorageClass == file.Class {
+		return plan.state.record(file)
+	}
+	if file.Remote.Archived {
+		opts.logf("%s is archived in %s; leaving it there rather than moving it to %s", file.Key, file.Remote.StorageClass, file.Class)
+		return plan.state.record(file)
+	}
+	plan.Transitions = append(plan.Transitions, file)
+	return nil
+}
+
+// applyTransitions moves the objects in plan.Transitions to the storage
+// classes of their tiers.
+func applyTransitions(ctx context.Context, opts Options, plan *Plan) error {
+	for _, f := range plan.Transitions {
+		opts.report(Event{Action: "transition", Key: f.Key, Reason: f.Remote.StorageClass + " to " + f.Class})
+		if opts.DryRun {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if overBudget(opts, plan, 0) {
+			return nil
+		}
+		err := setStorageClass(ctx, opts.Dst, f.Key, f.Class)
+		if errors.Is(err, ErrRequestLimit) {
+			plan.Incomplete = true
+			return nil
+		} else if err != nil && opts.KeepGoing && ctx.Err() == nil {
+			plan.failed = append(plan.failed, &FileError{Op: "transition", Key: f.Key, Err: err})
+			continue
+		} else if err != nil {
+			return &FileError{Op: "transition", Key: f.Key, Err: err}
+		}
+		if err := plan.state.record(f); err != nil {
+			return err
+		}
+		if err := plan.state.checkpoint(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// classDest is a mockDest with storage classes.
+type classDest struct {
+	*mockDest
+	moved []string
+}
+
+func (d *classDest) SetStorageClass(_ context.Context, key, class string) error {
+	meta, ok := d.objects[key]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	d.moved = append(d.moved, key)
+	m := *meta
+	m.StorageClass = class
+	d.objects[key] = &m
+	return nil
+}
+
+func TestParseStorageTier(t *testing.T) {
+	for in, want := range map[string]StorageTier{
+		"DEEP_ARCHIVE:90d":   {MinAge: 90 * 24 * time.Hour, Class: "DEEP_ARCHIVE"},
+		"logs/*=GLACIER:36h": {Pattern: "logs/*", MinAge: 36 * time.Hour, Class: "GLACIER"},
+		"GLACIER_IR:0":       {Class: "GLACIER_IR"},
+	} {
+		if got, err := ParseStorageTier(in); err != nil || got != want {
+			t.Errorf("ParseStorageTier(%q) = %+v, %v, want %+v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"DEEP_ARCHIVE", ":90d", "=GLACIER:1d", "GLACIER:-1d", "GLACIER:soon"} {
+		if _, err := ParseStorageTier(in); err == nil {
+			t.Errorf("ParseStorageTier(%q) succeeded", in)
+		}
+	}
+}
+
+func TestTierClass(t *testing.T) {
+	tiers := []StorageTier{
+		{Pattern: "logs/*", Class: "STANDARD"},
+		{MinAge: 90 * 24 * time.Hour, Class: "DEEP_ARCHIVE"},
+		{MinAge: time.Hour, Class: "GLACIER_IR"},
+	}
+	now := time.Now()
+	for _, tc := range []struct {
+		key  string
+		age  time.Duration
+		want string
+	}{
+		{"logs/a.log", 365 * 24 * time.Hour, "STANDARD"},
+		{"a.txt", 100 * 24 * time.Hour, "DEEP_ARCHIVE"},
+		{"a.txt", 2 * time.Hour, "GLACIER_IR"},
+		{"a.txt", time.Minute, ""},
+	} {
+		if got := tierClass(tiers, tc.key, now.Add(-tc.age), now); got != tc.want {
+			t.Errorf("%s, %v old: class %q, want %q", tc.key, tc.age, got, tc.want)
+		}
+	}
+}
+
+func TestSync_tiers(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "new.txt", "new")
+	old := time.Now().Add(-100 * 24 * time.Hour).Truncate(time.Second)
+	for _, name := range []string{"aging.txt", "frozen.txt"} {
+		writeFile(t, src, name, "old")
+		if err := os.Chtimes(filepath.Join(src, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := &classDest{mockDest: newMockDest()}
+	dst.objects["aging.txt"] = &ObjectMeta{Size: 3, ModTime: old, StorageClass: "GLACIER_IR"}
+	dst.objects["frozen.txt"] = &ObjectMeta{Size: 3, ModTime: old, StorageClass: "GLACIER", Archived: true}
+
+	tiers := []StorageTier{{MinAge: 90 * 24 * time.Hour, Class: "DEEP_ARCHIVE"}, {Class: "GLACIER_IR"}}
+	opts := Options{Src: src, Dst: dst, Tiers: tiers, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	ctx := context.Background()
+	res, err := Sync(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(dst.putCalls, []string{"new.txt"}) || dst.objects["new.txt"].StorageClass != "GLACIER_IR" {
+		t.Errorf("uploaded %v, new.txt in %q; want new.txt in GLACIER_IR", dst.putCalls, dst.objects["new.txt"].StorageClass)
+	}
+	if !slices.Equal(dst.moved, []string{"aging.txt"}) || dst.objects["aging.txt"].StorageClass != "DEEP_ARCHIVE" {
+		t.Errorf("moved %v, aging.txt in %q; want aging.txt in DEEP_ARCHIVE", dst.moved, dst.objects["aging.txt"].StorageClass)
+	}
+	if want := (Event{Action: "transition", Key: "aging.txt", Reason: "GLACIER_IR to DEEP_ARCHIVE"}); !slices.Contains(res.Changes, want) {
+		t.Errorf("changes %+v, want %+v", res.Changes, want)
+	}
+
+	// The state cache vouches for the classes as for the files.
+	dst.putCalls, dst.statCalls, dst.moved = nil, nil, nil
+	if _, err := Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls)+len(dst.statCalls)+len(dst.moved) != 0 {
+		t.Errorf("second run: put %v, checked %v, moved %v", dst.putCalls, dst.statCalls, dst.moved)
+	}
+
+	// New tiers check every object again.
+	opts.Tiers, opts.DryRun = []StorageTier{{Class: "STANDARD"}}, true
+	if res, err = Sync(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Changes) != 2 || len(dst.moved) != 0 {
+		t.Errorf("dry run with new tiers: changes %+v, moved %v; want two transitions reported", res.Changes, dst.moved)
+	}
+
+	opts.DryRun, opts.Incremental = false, true
+	if _, err := Sync(ctx, opts); err == nil {
+		t.Error("incremental run with tiers: Sync succeeded")
+	}
+	if _, err := Sync(ctx, Options{Src: src, Dst: newMockDest(), Tiers: tiers}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("destination without storage classes: got %v, want errors.ErrUnsupported", err)
+	}
+}
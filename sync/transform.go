@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// TransformFunc rewrites the content of the file uploaded to key, read
+// from r, returning the content to upload instead and its size, or -1 if
+// it is not known in advance. See Options.Transform.
+type TransformFunc func(key string, r io.Reader) (io.Reader, int64, error)
+
+// transformName matches the names of transforms, which are stored in a
+// metadata header.
+var transformName = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// UntransformFunc undoes the transform named transform, read from r, for
+// the object at key. See RestoreOptions.Untransform.
+type UntransformFunc func(transform, key string, r io.Reader) (io.Reader, error)
+
+// checkTransform reports whether opts.Transform can be used with the rest
+// of opts.
+func checkTransform(opts Options) error {
+	if opts.Transform == nil {
+		if opts.TransformName != "" {
+			return errors.New("a transform name needs a transform")
+		}
+		return nil
+	}
+	switch {
+	case opts.TransformName == "":
+		return errors.New("a transform needs a name to record with the objects")
+	case !transformName.MatchString(opts.TransformName):
+		return fmt.Errorf("transform name %q: want letters, digits, and .-_/", opts.TransformName)
+	case opts.Sparse || opts.Chunk != nil || opts.Bundle != nil:
+		// Each reads the file in pieces of its own.
+		return errors.New("a transform cannot be combined with sparse files, chunking or bundling")
+	case opts.Compression != "":
+		return errors.New("a transform cannot be combined with compression; compress in the transform instead")
+	}
+	if _, ok := opts.Dst.(*LocalDestination); ok {
+		return fmt.Errorf("transform: a directory cannot record the transform of its files: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// transformedBody is the first size bytes of r, as the transform t
+// rewrites them for key. Like compressedBody, it can be read again from
+// the start, which applies t again.
+type transformedBody struct {
+	t    TransformFunc
+	key  string
+	r    io.ReaderAt
+	size int64
+
+	body io.Reader
+	want int64 // size t said body has, or -1
+	read int64
+}
+
+// transformBody applies t to the first size bytes of r, uploaded to key.
+func transformBody(t TransformFunc, key string, r io.ReaderAt, size int64) (*transformedBody, error) {
+	b := &transformedBody{t: t, key: key, r: r, size: size}
+	return b, b.Rewind()
+}
+
+func (b *transformedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if err == io.EOF && b.want >= 0 && b.read != b.want {
+		return n, fmt.Errorf("transform of %s gave %d bytes, not the %d it said", b.key, b.read, b.want)
+	}
+	return n, err
+}
+
+// Rewind applies the transform again, for reading from the start.
+func (b *transformedBody) Rewind() error {
+	b.Close()
+	body, want, err := b.t(b.key, io.NewSectionReader(b.r, 0, b.size))
+	if err != nil {
+		return &FileError{Op: "transform", Key: b.key, Err: err}
+	}
+	b.body, b.want, b.read = body, want, 0
+	return nil
+}
+
+// Close closes the transformed content, if the transform returned a
+// Closer.
+func (b *transformedBody) Close() error {
+	if c, ok := b.body.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// upper uppercases the content of files.
+func upper(_ string, r io.Reader) (io.Reader, int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(bytes.ToUpper(data)), int64(len(data)), nil
+}
+
+func TestSync_transform(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, Transform: upper, TransformName: "upper", Checksums: true}
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(dst.data["a.txt"]); got != "HELLO" {
+		t.Errorf("uploaded %q, want HELLO", got)
+	}
+	if meta := dst.objects["a.txt"]; meta.Transform != "upper" || meta.Size != 5 {
+		t.Errorf("transform, size = %q, %d; want upper, 5", meta.Transform, meta.Size)
+	}
+
+	dst.putCalls = nil
+	if _, err := Sync(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("second run uploaded %v", dst.putCalls)
+	}
+
+	// Without Untransform, the object is restored as it is stored; with
+	// it, as the file was, its checksum checked.
+	out := t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "a.txt")); string(data) != "HELLO" {
+		t.Errorf("restored %q without Untransform, want HELLO", data)
+	}
+	lower := func(transform, _ string, r io.Reader) (io.Reader, error) {
+		if transform != "upper" {
+			t.Errorf("Untransform called for %q", transform)
+		}
+		data, err := io.ReadAll(r)
+		return bytes.NewReader(bytes.ToLower(data)), err
+	}
+	out = t.TempDir()
+	if err := Restore(context.Background(), RestoreOptions{From: dst, To: out, Untransform: lower}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(out, "a.txt")); string(data) != "hello" {
+		t.Errorf("restored %q with Untransform, want hello", data)
+	}
+}
+
+func TestSync_transformInvalid(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	short := func(string, io.Reader) (io.Reader, int64, error) {
+		return strings.NewReader("hi"), 3, nil
+	}
+	for name, opts := range map[string]Options{
+		"no name":     {Transform: upper},
+		"bad name":    {Transform: upper, TransformName: "up per"},
+		"compression": {Transform: upper, TransformName: "upper", Compression: CompressGzip},
+		"wrong size":  {Transform: short, TransformName: "short"},
+	} {
+		opts.Src, opts.Dst = src, newMockDest()
+		if _, err := Sync(context.Background(), opts); err == nil {
+			t.Errorf("%s: synced", name)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// TrashPrefix holds the objects runs with Options.DeleteTo moved instead of
+// deleting them, each under the time of its run, such as
+// ".foldersync/trash/20240301T100000Z/photos/a.jpg".
+const TrashPrefix = metaPrefix + "trash/"
+
+// Trash is where runs with Options.DeleteTo move the objects they delete.
+type Trash struct {
+	// Dst holds the trash, under TrashPrefix. If nil, it is the
+	// destination of the run itself, where objects are copied server-side;
+	// another destination is sent a copy of each object, server-side if
+	// it can copy from the run's destination or else by downloading it.
+	Dst Destination
+}
+
+func trashKey(run time.Time, key string) string {
+	return TrashPrefix + run.UTC().Format(snapshotTime) + "/" + key
+}
+
+// checkTrash reports whether opts.Dst can move objects to opts.DeleteTo.
+func checkTrash(opts Options) error {
+	if opts.DeleteTo == nil {
+		return nil
+	}
+	if opts.DeleteTo.Dst == nil {
+		if _, ok := opts.Dst.(Copier); !ok {
+			return fmt.Errorf("trash: destination cannot copy objects: %w", errors.ErrUnsupported)
+		}
+		return nil
+	}
+	if _, ok := opts.Dst.(Getter); !ok {
+		return fmt.Errorf("trash: destination cannot read objects back: %w", errors.ErrUnsupported)
+	}
+	return nil
+}
+
+// deleteReason is the reason reported for deletes made with opts.
+func (opts Options) deleteReason() string {
+	if opts.DeleteTo != nil {
+		return "moved to trash"
+	}
+	return ""
+}
+
+// trashDelete copies the object at key into opts.DeleteTo, under the time
+// run of the run, before it is deleted.
+func trashDelete(ctx context.Context, opts Options, run time.Time, key string) error {
+	if opts.DeleteTo == nil {
+		return nil
+	}
+	var err error
+	if opts.DeleteTo.Dst == nil {
+		err = copyObject(ctx, opts.Dst, key, trashKey(run, key))
+	} else {
+		err = copyTo(ctx, opts.Dst, opts.DeleteTo.Dst, key, trashKey(run, key))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // already gone
+	}
+	if err != nil {
+		return fmt.Errorf("move %s to trash: %w", key, err)
+	}
+	return nil
+}
+
+// PurgeTrashOptions configures PurgeTrash.
+type PurgeTrashOptions struct {
+	// OlderThan is how long before now objects must have been moved to
+	// the trash to be purged.
+	OlderThan time.Duration
+	// DryRun returns what would be purged without deleting it.
+	DryRun bool
+	// VerifyKey, if set, is the key the manifest at the root of the
+	// destination must be signed with; nothing is purged if it does not
+	// verify.
+	VerifyKey ed25519.PublicKey
+}
+
+// PurgeTrash deletes the objects in the trash at dst that opts selects, and
+// returns their keys.
+func PurgeTrash(ctx context.Context, dst Destination, opts PurgeTrashOptions) ([]string, error) {
+	if opts.VerifyKey != nil {
+		if err := verifyManifest(ctx, dst, opts.VerifyKey); err != nil {
+			return nil, err
+		}
+	}
+	cutoff := time.Now().Add(-opts.OlderThan)
+	var purge []string
+	err := dst.List(ctx, func(keys []string) error {
+		for _, key := range keys {
+			run, _, ok := strings.Cut(strings.TrimPrefix(key, TrashPrefix), "/")
+			if !ok || !strings.HasPrefix(key, TrashPrefix) {
+				continue
+			}
+			t, err := time.Parse(snapshotTime, run)
+			if err == nil && t.Before(cutoff) {
+				purge = append(purge, key)
+			}
+		}
+		return nil
+	})
+	if err != nil || opts.DryRun {
+		return purge, err
+	}
+
+	remaining := purge
+	for len(remaining) > 0 {
+		batch := remaining[:min(len(remaining), deleteBatchSize)]
+		if _, err := deleteBatch(ctx, dst, batch); errors.Is(err, errors.ErrUnsupported) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		remaining = remaining[len(batch):]
+	}
+	for _, key := range remaining {
+		if err := dst.Delete(ctx, key); err != nil {
+			return nil, &FileError{Op: "delete", Key: key, Err: err}
+		}
+	}
+	return purge, nil
+}
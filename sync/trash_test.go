@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trashed returns the keys under TrashPrefix in dst.
+func trashed(dst *mockDest) []string {
+	var keys []string
+	for key := range dst.objects {
+		if strings.HasPrefix(key, TrashPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestSync_deleteTo(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "keep.txt", "keep")
+	ctx := context.Background()
+
+	for _, other := range []*mockDest{nil, newMockDest()} {
+		dst := newMockDest()
+		dst.objects["gone.txt"] = &ObjectMeta{Size: 4}
+		dst.data["gone.txt"] = []byte("gone")
+		trash, holder := &Trash{}, dst
+		if other != nil {
+			trash.Dst, holder = other, other
+		}
+		if _, err := Sync(ctx, Options{Src: src, Dst: dst, Delete: true, DeleteTo: trash}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := dst.objects["gone.txt"]; ok {
+			t.Error("gone.txt was not deleted")
+		}
+		got := trashed(holder)
+		if len(got) != 1 || !strings.HasSuffix(got[0], "/gone.txt") || string(holder.data[got[0]]) != "gone" {
+			t.Errorf("trash holds %v, want gone.txt", got)
+		}
+		if other != nil && len(trashed(dst)) != 0 {
+			t.Errorf("trash at the destination itself holds %v", trashed(dst))
+		}
+
+		// The trash is never deleted as extraneous.
+		if _, err := Sync(ctx, Options{Src: src, Dst: holder, Delete: true}); err != nil {
+			t.Fatal(err)
+		}
+		if len(trashed(holder)) != 1 {
+			t.Errorf("a later run deleted the trash: %v", trashed(holder))
+		}
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	dst := newMockDest()
+	now := time.Now()
+	old, recent := trashKey(now.Add(-40*24*time.Hour), "a.txt"), trashKey(now.Add(-time.Hour), "b.txt")
+	for _, key := range []string{old, recent, "a.txt", TrashPrefix + "notes.txt"} {
+		dst.objects[key] = &ObjectMeta{}
+	}
+	ctx := context.Background()
+
+	got, err := PurgeTrash(ctx, dst, PurgeTrashOptions{OlderThan: 30 * 24 * time.Hour, DryRun: true})
+	if err != nil || !slices.Equal(got, []string{old}) || len(dst.deleteCalls) != 0 {
+		t.Fatalf("dry run purged %v, err %v, deleted %v; want %s listed only", got, err, dst.deleteCalls, old)
+	}
+	if got, err := PurgeTrash(ctx, dst, PurgeTrashOptions{OlderThan: 30 * 24 * time.Hour}); err != nil || !slices.Equal(got, []string{old}) {
+		t.Fatalf("PurgeTrash = %v, %v; want %s", got, err, old)
+	}
+	if _, ok := dst.objects[old]; ok || len(dst.objects) != 3 {
+		t.Errorf("left %d objects, want all but %s", len(dst.objects), old)
+	}
+}
@@ -0,0 +1,454 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrConflict is returned by TwoWay with ConflictFail when a file changed
+// on both sides since the last run.
+var ErrConflict = errors.New("changed on both sides")
+
+// ConflictPolicy decides what TwoWay does with a file that changed on both
+// sides since the last run, or was deleted on one and changed on the other.
+type ConflictPolicy int
+
+const (
+	// ConflictFail stops the run before anything is changed.
+	ConflictFail ConflictPolicy = iota
+	// ConflictNewerWins keeps the copy with the later modification time,
+	// or the destination's if they are equal. A change always wins over a
+	// deletion.
+	ConflictNewerWins
+	// ConflictKeepBoth keeps both copies: the local one is renamed, with
+	// its modification time added to the name, and uploaded under its new
+	// name, and the destination's is downloaded in its place.
+	ConflictKeepBoth
+)
+
+// ParseConflictPolicy parses the names used on the command line: fail,
+// newer-wins and keep-both.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch s {
+	case "fail":
+		return ConflictFail, nil
+	case "newer-wins":
+		return ConflictNewerWins, nil
+	case "keep-both":
+		return ConflictKeepBoth, nil
+	}
+	return 0, fmt.Errorf("unknown conflict policy %q (valid: fail, newer-wins, keep-both)", s)
+}
+
+// twoWayPlan is the set of changes a two-way run intends to make.
+type twoWayPlan struct {
+	Uploads      []File
+	Downloads    []string
+	DeleteRemote []string
+	DeleteLocal  []File
+	Renames      []rename // local copies kept by ConflictKeepBoth
+	Conflicts    []string
+	Unchanged    []File // up to date on both sides
+}
+
+// rename moves a local file to a new key before it is uploaded.
+type rename struct {
+	From File
+	To   string
+}
+
+// TwoWay propagates changes between opts.Src and opts.Dst in both
+// directions, so that two machines can share a folder through the same
+// destination. Files created, modified or deleted on either side since the
+// last run are created, updated or deleted on the other. A file changed on
+// both sides is a conflict, settled by opts.Conflicts.
+//
+// The last run is remembered in opts.StateCache, which is required; keep
+// one per machine. Without it, as on the first run, files that differ
+// between the two sides are all conflicts. Files are compared by size and
+// modification time, to the second or within opts.ModTimeWindow, and by
+// POSIX attributes if opts.PreservePOSIX is set. opts.Dst must implement Getter.
+//
+// Delete, ReportExtraneous, Compare, Reupload, DirCache, Journal, Manifest,
+// Incremental, Snapshots, ScanSecrets, Confirm, HardLinks, KeepEmptyDirs
+// and CaseCollisions do not apply.
+// SourceSnapshot cannot be used, since files are written to Src, nor can
+// MaxDuration, MaxTransfer, Transform or DeleteExcluded. A run stopped by
+// ctx returns a *CanceledError, as Sync does.
+func TwoWay(ctx context.Context, opts Options) (err error) {
+	defer func() { err = classify(err) }()
+	if opts.StateCache == "" {
+		return errors.New("two-way sync needs a state cache to tell which side changed")
+	}
+	if opts.Bundle != nil {
+		return errors.New("two-way sync cannot be combined with bundling")
+	}
+	if mapsKeys(opts.Keys) {
+		return errors.New("two-way sync cannot be combined with a key layout")
+	}
+	if opts.filtering() {
+		// A skipped file would look deleted.
+		return errors.New("two-way sync cannot be combined with size or age filters")
+	}
+	if opts.SkipInFlight {
+		return errors.New("two-way sync cannot skip files in flight, which would look deleted")
+	}
+	if opts.SourceSnapshot != nil {
+		return errors.New("two-way sync cannot read its source from a snapshot")
+	}
+	if opts.Transform != nil {
+		return errors.New("two-way sync cannot transform files, which would come back changed")
+	}
+	if opts.DeleteExcluded {
+		return errors.New("two-way sync cannot delete excluded objects, which it leaves alone on both sides")
+	}
+	if opts.MaxDuration > 0 || opts.MaxTransfer > 0 {
+		// Deletes found by a partial run would be lost.
+		return errors.New("two-way sync cannot stop at a run budget")
+	}
+	opts.twoWay = true
+	opts.UnicodeForm = UnicodeAsIs // keys name the local files it writes
+	opts.events = newEventStream(opts.OnRunEvent)
+	start, planned := time.Now(), false
+	var p Progress
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = &CanceledError{Progress: p, Planned: planned, Err: err}
+		}
+		opts.emit(RunComplete{Summary: Summary{Src: opts.Src, DryRun: opts.DryRun, Progress: p, Duration: time.Since(start), Err: err}})
+	}()
+	release, err := acquireRunLock(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer release()
+	opts, err = prepare(ctx, opts)
+	if err != nil {
+		return err
+	}
+	state := loadStateCache(opts.StateCache, nil, opts.Warnings)
+
+	plan, err := planTwoWay(ctx, opts, state)
+	if err != nil {
+		return err
+	}
+	if len(plan.Conflicts) > 0 && opts.Conflicts == ConflictFail {
+		for _, key := range plan.Conflicts {
+			opts.report(Event{Action: "conflict", Key: key})
+		}
+		return fmt.Errorf("%d files %w since the last run; nothing was changed", len(plan.Conflicts), ErrConflict)
+	}
+	p = plan.progress()
+	planned = true
+	err = applyTwoWay(ctx, opts, plan, state, &p)
+	if serr := opts.metaCache.save(); serr != nil && err == nil {
+		err = fmt.Errorf("save metadata cache: %w", serr)
+	}
+	if err != nil || opts.DryRun {
+		return err
+	}
+	return state.save()
+}
+
+// progress returns what p plans to do, with downloads counted as uploads and
+// deletes of local files along with those of objects.
+func (p *twoWayPlan) progress() Progress {
+	uploads := len(p.Uploads) + len(p.Renames) + len(p.Downloads)
+	return Progress{
+		Files:   len(p.Unchanged) + len(p.Uploads) + len(p.Renames) + len(p.DeleteLocal),
+		Skipped: len(p.Unchanged),
+		Uploads: uploads,
+		Deletes: len(p.DeleteRemote) + len(p.DeleteLocal),
+	}
+}
+
+// planTwoWay compares opts.Src and opts.Dst with the state of the last run.
+func planTwoWay(ctx context.Context, opts Options, state *stateCache) (*twoWayPlan, error) {
+	ignore := newIgnorer(opts.Src)
+	local, err := listLocal(opts, ignore)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := listRemote(ctx, opts.Dst, ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for key := range local {
+		keys[key] = true
+	}
+	for key := range remote {
+		keys[key] = true
+	}
+	for key := range state.old {
+		keys[key] = true
+	}
+
+	plan := &twoWayPlan{}
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		l, r := local[key], remote[key]
+		var last *stateEntry
+		if e, ok := state.old[key]; ok {
+			last = &e
+		}
+		lChanged, rChanged := localChanged(l, last), remoteChanged(opts, r, last)
+
+		switch {
+		case bothMatch(opts, l, r):
+			if l != nil {
+				plan.Unchanged = append(plan.Unchanged, *l)
+			}
+		case !rChanged && l == nil:
+			plan.DeleteRemote = append(plan.DeleteRemote, key)
+		case !rChanged:
+			plan.Uploads = append(plan.Uploads, *l)
+		case !lChanged && r == nil:
+			plan.DeleteLocal = append(plan.DeleteLocal, File{Key: key, Path: localPath(opts, key)})
+		case !lChanged:
+			plan.Downloads = append(plan.Downloads, key)
+		default:
+			plan.Conflicts = append(plan.Conflicts, key)
+			resolveConflict(opts.Conflicts, plan, l, r, key)
+		}
+	}
+	return plan, nil
+}
+
+// resolveConflict adds the changes that settle a conflict on key to plan.
+func resolveConflict(policy ConflictPolicy, plan *twoWayPlan, l *File, r *ObjectMeta, key string) {
+	switch {
+	case policy == ConflictFail:
+	case l == nil:
+		plan.Downloads = append(plan.Downloads, key)
+	case r == nil:
+		plan.Uploads = append(plan.Uploads, *l)
+	case policy == ConflictNewerWins && l.ModTime.Unix() > r.ModTime.Unix():
+		plan.Uploads = append(plan.Uploads, *l)
+	case policy == ConflictNewerWins:
+		plan.Downloads = append(plan.Downloads, key)
+	case policy == ConflictKeepBoth:
+		plan.Renames = append(plan.Renames, rename{From: *l, To: conflictKey(key, l.ModTime)})
+		plan.Downloads = append(plan.Downloads, key)
+	}
+}
+
+// conflictKey returns the key a local copy of key modified at t is kept
+// under, e.g. "notes.conflict-20240301-120000.txt".
+func conflictKey(key string, t time.Time) string {
+	ext := path.Ext(key)
+	if ext == path.Base(key) {
+		ext = "" // a dot file such as ".bashrc" has no extension
+	}
+	return strings.TrimSuffix(key, ext) + ".conflict-" + t.Format("20060102-150405") + ext
+}
+
+// localChanged reports whether f, nil if absent, differs from the state
+// of the last run, nil if the file was not there.
+func localChanged(f *File, last *stateEntry) bool {
+	if f == nil || last == nil {
+		return (f == nil) != (last == nil)
+	}
+	e := newStateEntry(*f)
+	e.SHA256 = last.SHA256
+	return e != *last
+}
+
+// remoteChanged reports whether the destination's copy, nil if absent,
+// differs from the state of the last run. Destinations store modification
+// times to the second, and the local file the state records may keep them
+// to within opts.ModTimeWindow.
+func remoteChanged(opts Options, r *ObjectMeta, last *stateEntry) bool {
+	if r == nil || last == nil {
+		return (r == nil) != (last == nil)
+	}
+	return r.Size != last.Size || !sameModTime(r.ModTime, time.Unix(0, last.ModTime), opts.ModTimeWindow) ||
+		opts.PreservePOSIX && posixString(r.POSIX) != last.POSIX
+}
+
+// bothMatch reports whether the local file and the destination's copy
+// match, or are both absent.
+func bothMatch(opts Options, f *File, r *ObjectMeta) bool {
+	if f == nil || r == nil {
+		return f == nil && r == nil
+	}
+	return f.Size == r.Size && sameModTime(f.ModTime, r.ModTime, opts.ModTimeWindow) &&
+		(!opts.PreservePOSIX || f.POSIX.Equal(r.POSIX))
+}
+
+// listLocal returns the files in opts.Src by key, leaving out those ignore
+// ignores.
+func listLocal(opts Options, ignore *ignorer) (map[string]*File, error) {
+	files := make(map[string]*File)
+	err := filepath.WalkDir(opts.Src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		key, err := sourceKey(opts.Src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && key+"/" == metaPrefix {
+			return filepath.SkipDir
+		}
+		if skip, err := ignore.match(key, d.IsDir()); err != nil || skip {
+			if skip && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		file, err := newFile(opts, path, key, info)
+		if err != nil {
+			return err
+		}
+		files[key] = &file
+		return nil
+	})
+	return files, err
+}
+
+// listRemote returns the metadata of the objects in dst by key, leaving out
+// those stored under paths ignore ignores.
+func listRemote(ctx context.Context, dst Destination, ignore *ignorer) (map[string]*ObjectMeta, error) {
+	objects := make(map[string]*ObjectMeta)
+	err := dst.List(ctx, func(keys []string) error {
+		for _, key := range keys {
+			if strings.HasPrefix(key, metaPrefix) {
+				continue
+			}
+			if skip, err := ignore.ignored(key, false); err != nil {
+				return err
+			} else if skip {
+				continue
+			}
+			meta, err := dst.Stat(ctx, key)
+			if err != nil {
+				return &FileError{Op: "stat", Key: key, Err: err}
+			}
+			if meta != nil {
+				objects[key] = meta
+			}
+		}
+		return nil
+	})
+	return objects, err
+}
+
+func localPath(opts Options, key string) string {
+	return filepath.Join(opts.Src, filepath.FromSlash(key))
+}
+
+// applyTwoWay carries out plan, recording the state of every file that is
+// up to date on both sides afterwards, and counting what it has done in p.
+func applyTwoWay(ctx context.Context, opts Options, plan *twoWayPlan, state *stateCache, p *Progress) error {
+	for _, f := range plan.Unchanged {
+		if err := state.record(f); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range plan.Renames {
+		opts.report(Event{Action: "conflict", Key: r.From.Key, Reason: "keeping the local copy as " + r.To})
+		f := r.From
+		f.Key, f.Path = r.To, localPath(opts, r.To)
+		if !opts.DryRun {
+			if err := os.Rename(r.From.Path, f.Path); err != nil {
+				return err
+			}
+		}
+		plan.Uploads = append(plan.Uploads, f)
+	}
+
+	for _, f := range plan.Uploads {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		opts.report(Event{Action: "upload", Key: f.Key})
+		if opts.DryRun {
+			continue
+		}
+		if err := uploadUnstalled(ctx, opts, f, state.wantsHash(f.Key)); err != nil {
+			return &FileError{Op: "upload", Key: f.Key, Err: err}
+		}
+		p.Uploaded++
+		p.UploadedBytes += f.Size
+		if err := state.record(f); err != nil {
+			return err
+		}
+	}
+
+	to := NewLocalDestination(opts.Src)
+	for _, key := range plan.Downloads {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		opts.report(Event{Action: "download", Key: key})
+		if opts.DryRun {
+			continue
+		}
+		if err := restoreFile(ctx, opts.Dst, to, key, key, nil, nil, transfer{}); err != nil {
+			return &FileError{Op: "download", Key: key, Err: err}
+		}
+		p.Uploaded++
+		path := localPath(opts, key)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		f, err := newFile(opts, path, key, info)
+		if err != nil {
+			return err
+		}
+		if err := state.record(f); err != nil {
+			return err
+		}
+	}
+
+	run := time.Now()
+	for _, key := range plan.DeleteRemote {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		opts.report(Event{Action: "delete", Key: key, Reason: opts.deleteReason()})
+		if opts.DryRun {
+			continue
+		}
+		if err := trashDelete(ctx, opts, run, key); err != nil {
+			return err
+		}
+		if err := opts.Dst.Delete(ctx, key); err != nil {
+			return &FileError{Op: "delete", Key: key, Err: err}
+		}
+		p.Deleted++
+	}
+	for _, f := range plan.DeleteLocal {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		opts.report(Event{Action: "delete-local", Key: f.Key})
+		if opts.DryRun {
+			continue
+		}
+		if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		p.Deleted++
+	}
+	return nil
+}
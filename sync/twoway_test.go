@@ -0,0 +1,222 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// putRemote stores content at key as if another machine had uploaded it,
+// modified at mtime.
+func putRemote(dst *mockDest, key, content string, mtime time.Time) {
+	dst.objects[key] = &ObjectMeta{Size: int64(len(content)), ModTime: mtime.Truncate(time.Second)}
+	dst.data[key] = []byte(content)
+}
+
+func readLocal(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTwoWay_propagatesBothWays(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "local.txt", "from here")
+	writeFile(t, src, "gone.txt", "old")
+	dst := newMockDest()
+	putRemote(dst, "remote.txt", "from there", time.Now().Add(-time.Hour))
+
+	opts := Options{Src: src, Dst: dst, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLocal(t, src, "remote.txt"); got != "from there" {
+		t.Errorf("remote.txt = %q, want it downloaded", got)
+	}
+	if string(dst.data["local.txt"]) != "from here" {
+		t.Errorf("local.txt not uploaded: %v", dst.putCalls)
+	}
+
+	// The other machine edits remote.txt; this one deletes gone.txt.
+	putRemote(dst, "remote.txt", "edited there", time.Now())
+	if err := os.Remove(filepath.Join(src, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	dst.putCalls = nil
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLocal(t, src, "remote.txt"); got != "edited there" {
+		t.Errorf("remote.txt = %q, want the edit downloaded", got)
+	}
+	if _, ok := dst.objects["gone.txt"]; ok {
+		t.Error("gone.txt not deleted from the destination")
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("uploaded unchanged files: %v", dst.putCalls)
+	}
+
+	// And deletes local.txt.
+	delete(dst.objects, "local.txt")
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(src, "local.txt")); !os.IsNotExist(err) {
+		t.Errorf("local.txt not deleted locally: %v", err)
+	}
+}
+
+func TestTwoWay_summary(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "local.txt", "from here")
+	dst := newMockDest()
+	putRemote(dst, "remote.txt", "from there", time.Now().Add(-time.Hour))
+
+	var got []Summary
+	opts := Options{Src: src, Dst: dst, StateCache: filepath.Join(t.TempDir(), "state.json"),
+		OnRunEvent: func(e RunEvent) {
+			if rc, ok := e.(RunComplete); ok {
+				got = append(got, rc.Summary)
+			}
+		}}
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Uploads != 2 || got[0].Uploaded != 2 || got[0].Err != nil {
+		t.Fatalf("summaries = %+v, want one of an upload and a download", got)
+	}
+
+	writeFile(t, src, "new.txt", "new")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := TwoWay(ctx, opts)
+	if !errors.Is(err, ErrCanceled) {
+		t.Errorf("err = %v, want ErrCanceled", err)
+	}
+	if len(got) != 2 || !errors.Is(got[1].Err, ErrCanceled) {
+		t.Errorf("summaries = %+v, want the canceled run's too", got)
+	}
+}
+
+func TestTwoWay_modTimeWindow(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "same")
+	dst := newMockDest()
+	putRemote(dst, "a.txt", "same", info.ModTime().Add(time.Second))
+
+	// Without a state cache, files that differ are conflicts.
+	opts := Options{Src: src, Dst: dst, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if err := TwoWay(context.Background(), opts); !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want ErrConflict", err)
+	}
+	opts.ModTimeWindow = 2 * time.Second
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("uploaded %v within the window", dst.putCalls)
+	}
+}
+
+// changedOnBothSides syncs a.txt, then changes it on both sides, the
+// remote copy modified at remoteTime.
+func changedOnBothSides(t *testing.T, remoteTime time.Time) (Options, *mockDest) {
+	t.Helper()
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "original")
+	dst := newMockDest()
+	opts := Options{Src: src, Dst: dst, StateCache: filepath.Join(t.TempDir(), "state.json")}
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(src, "a.txt")
+	if err := os.WriteFile(path, []byte("local edit"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, local, local); err != nil {
+		t.Fatal(err)
+	}
+	putRemote(dst, "a.txt", "remote edit", remoteTime)
+	dst.putCalls = nil
+	return opts, dst
+}
+
+func TestTwoWay_conflictFails(t *testing.T) {
+	opts, dst := changedOnBothSides(t, time.Now())
+	err := TwoWay(context.Background(), opts)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want ErrConflict", err)
+	}
+	if got := readLocal(t, opts.Src, "a.txt"); got != "local edit" || len(dst.putCalls) != 0 {
+		t.Errorf("conflict changed files: local %q, uploads %v", got, dst.putCalls)
+	}
+}
+
+func TestTwoWay_newerWins(t *testing.T) {
+	opts, dst := changedOnBothSides(t, time.Now())
+	opts.Conflicts = ConflictNewerWins
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if string(dst.data["a.txt"]) != "local edit" {
+		t.Errorf("remote a.txt = %q, want the newer local edit", dst.data["a.txt"])
+	}
+
+	opts, dst = changedOnBothSides(t, time.Now().Add(2*time.Hour))
+	opts.Conflicts = ConflictNewerWins
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLocal(t, opts.Src, "a.txt"); got != "remote edit" {
+		t.Errorf("local a.txt = %q, want the newer remote edit", got)
+	}
+}
+
+func TestTwoWay_keepBoth(t *testing.T) {
+	opts, dst := changedOnBothSides(t, time.Now())
+	opts.Conflicts = ConflictKeepBoth
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLocal(t, opts.Src, "a.txt"); got != "remote edit" {
+		t.Errorf("a.txt = %q, want the remote edit", got)
+	}
+	if len(dst.putCalls) != 1 {
+		t.Fatalf("uploads = %v, want the renamed local copy", dst.putCalls)
+	}
+	kept := dst.putCalls[0]
+	if got := readLocal(t, opts.Src, kept); got != "local edit" || string(dst.data[kept]) != "local edit" {
+		t.Errorf("%s = %q locally, %q remotely; want the local edit on both", kept, got, dst.data[kept])
+	}
+
+	// Both sides now agree.
+	dst.putCalls = nil
+	if err := TwoWay(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.putCalls) != 0 {
+		t.Errorf("second run uploaded %v", dst.putCalls)
+	}
+}
+
+func TestConflictKey(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct{ key, want string }{
+		{"notes.txt", "notes.conflict-20240301-120000.txt"},
+		{"docs/v1.2/README", "docs/v1.2/README.conflict-20240301-120000"},
+		{"home/.bashrc", "home/.bashrc.conflict-20240301-120000"},
+	}
+	for _, tt := range tests {
+		if got := conflictKey(tt.key, at); got != tt.want {
+			t.Errorf("conflictKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package sync
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Mismatch is a difference between the source and the destination found
+// by Verify.
+type Mismatch struct {
+	Key    string
+	Reason string // e.g. "missing" or "size 10, want 12"
+}
+
+func (m Mismatch) String() string {
+	return m.Key + ": " + m.Reason
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Files      int // source files checked
+	Mismatches []Mismatch
+	// Archived lists the keys of the objects that match their files but
+	// whose content was not read, as the comparison would have, because
+	// they are archived. Restoring them would check it.
+	Archived []string
+}
+
+// Verify audits opts.Dst against opts.Src without writing anything: every
+// source file is looked up at the destination and compared as a sync run
+// would compare it, using opts.Compare and opts.PreservePOSIX. With
+// ChecksumComparer, every object is downloaded and hashed, as are those
+// AlwaysUpload would upload regardless, except archived objects, which
+// are compared by their metadata and listed in the report's Archived. With
+// opts.Delete, objects absent from the source are reported too. Objects
+// whose content differs although the file still hashes to the SHA-256
+// recorded with them were corrupted, and are reported as such.
+//
+// Local caches are not consulted, so every file is checked, except for
+// opts.MetaCache if it is set. Differences
+// are returned in the report; the error is only set if the audit itself
+// could not be completed.
+func Verify(ctx context.Context, opts Options) (*VerifyReport, error) {
+	opts, plan, err := auditPlan(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{Files: len(plan.Files)}
+	differ := make(map[string]bool, len(plan.Uploads))
+	for _, f := range plan.Uploads {
+		report.Mismatches = append(report.Mismatches, Mismatch{Key: f.Key, Reason: mismatchReason(opts, f)})
+		differ[f.Key] = true
+	}
+	for _, f := range plan.Files {
+		if f.Remote != nil && f.Remote.Archived && !differ[f.Key] && readsContent(opts.Compare, f.Key) {
+			report.Archived = append(report.Archived, f.Key)
+		}
+	}
+	for _, f := range plan.Bundled {
+		report.Mismatches = append(report.Mismatches, Mismatch{Key: f.Key, Reason: mismatchReason(opts, f)})
+	}
+	for _, key := range extraneous(plan) {
+		report.Mismatches = append(report.Mismatches, Mismatch{Key: key, Reason: "not in source"})
+	}
+	return report, nil
+}
+
+// auditPlan plans a sync run of opts without writing anything, neither to
+// the destination nor to the local caches other than opts.MetaCache, and
+// returns the options it was planned with.
+func auditPlan(ctx context.Context, opts Options) (Options, *Plan, error) {
+	opts.ReadOnly = true
+	opts.DryRun = true
+	opts.VerifyKey = nil
+	opts.DirCache, opts.StateCache, opts.Journal = "", "", ""
+	opts.Reupload = nil
+	opts.ScanSecrets = false
+	opts.Isolate = false
+	opts.Compare = auditComparer(opts.Compare)
+	opts, err := prepare(ctx, opts)
+	if err != nil {
+		return opts, nil, err
+	}
+	plan, err := buildPlan(ctx, opts)
+	if err != nil {
+		return opts, nil, err
+	}
+	if err := opts.metaCache.save(); err != nil {
+		return opts, nil, fmt.Errorf("save metadata cache: %w", err)
+	}
+	return opts, plan, nil
+}
+
+// extraneous returns the keys of the objects and bundled files plan finds
+// absent from the source. Objects of files that have become hard links are
+// not.
+func extraneous(plan *Plan) []string {
+	inSource := make(map[string]bool, len(plan.Files))
+	for _, f := range plan.Files {
+		inSource[f.Key] = true
+	}
+	var keys []string
+	for _, key := range plan.Unbundle {
+		if !inSource[key] {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range plan.Deletes {
+		if _, ok := plan.links[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// mismatchReason describes how the destination's copy of f, which a sync
+// run would upload, differs from it.
+func mismatchReason(opts Options, f File) string {
+	r := f.Remote
+	switch {
+	case r == nil:
+		return "missing"
+	case r.Size != f.Size:
+		return fmt.Sprintf("size %d, want %d", r.Size, f.Size)
+	case opts.PreservePOSIX && !f.POSIX.Equal(r.POSIX):
+		return "permissions, ownership or extended attributes differ"
+	case !sameModTime(r.ModTime, f.ModTime, opts.ModTimeWindow):
+		return fmt.Sprintf("modified %s, want %s", r.ModTime.Format(time.RFC3339), f.ModTime.Format(time.RFC3339))
+	case r.SHA256 != "" && sameSHA256(f.Path, r.SHA256):
+		// The file is as it was uploaded; the object is not.
+		return "content does not match its recorded SHA-256"
+	default:
+		return "content differs"
+	}
+}
+
+// auditComparer replaces AlwaysUpload in c with ChecksumComparer: an audit
+// has to look at the content of the files a sync uploads regardless.
+func auditComparer(c Comparer) Comparer {
+	switch c := c.(type) {
+	case AlwaysUpload:
+		return ChecksumComparer{}
+	case PatternComparer:
+		p := PatternComparer{Default: auditComparer(c.Default)}
+		for _, r := range c.Rules {
+			p.Rules = append(p.Rules, CompareRule{Pattern: r.Pattern, Compare: auditComparer(r.Compare)})
+		}
+		return p
+	}
+	return c
+}
+
+// sameSHA256 reports whether the file at path hashes to the hex-encoded
+// sum.
+func sameSHA256(path, sum string) bool {
+	local, err := fileSHA256(path)
+	return err == nil && hex.EncodeToString(local) == sum
+}
@@ -0,0 +1,124 @@
+package sync
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	src := t.TempDir()
+	ok := writeFile(t, src, "ok.txt", "same")
+	writeFile(t, src, "missing.txt", "x")
+	writeFile(t, src, "short.txt", "longer")
+	stale := writeFile(t, src, "stale.txt", "abc")
+
+	dst := newMockDest()
+	dst.objects["ok.txt"] = &ObjectMeta{Size: ok.Size(), ModTime: ok.ModTime().Truncate(time.Second)}
+	dst.objects["short.txt"] = &ObjectMeta{Size: 3, ModTime: time.Now()}
+	dst.objects["stale.txt"] = &ObjectMeta{Size: stale.Size(), ModTime: stale.ModTime().Add(-time.Hour)}
+	dst.objects["gone.txt"] = &ObjectMeta{Size: 1}
+
+	report, err := Verify(context.Background(), Options{
+		Src:        src,
+		Dst:        dst,
+		Delete:     true,
+		StateCache: filepath.Join(t.TempDir(), "state.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Files != 4 {
+		t.Errorf("checked %d files, want 4", report.Files)
+	}
+	got := make(map[string]string)
+	for _, m := range report.Mismatches {
+		got[m.Key] = m.Reason
+	}
+	want := map[string]string{
+		"missing.txt": "missing",
+		"short.txt":   "size 3, want 6",
+		"stale.txt": fmt.Sprintf("modified %s, want %s",
+			stale.ModTime().Add(-time.Hour).Format(time.RFC3339), stale.ModTime().Format(time.RFC3339)),
+		"gone.txt": "not in source",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("mismatches = %v, want %v", got, want)
+	}
+	if len(dst.putCalls) != 0 || len(dst.deleteCalls) != 0 {
+		t.Errorf("verify wrote to the destination: put %v, delete %v", dst.putCalls, dst.deleteCalls)
+	}
+}
+
+func TestVerify_checksum(t *testing.T) {
+	src := t.TempDir()
+	info := writeFile(t, src, "a.txt", "good")
+
+	dst := newMockDest()
+	dst.objects["a.txt"] = &ObjectMeta{Size: info.Size(), ModTime: info.ModTime()}
+	dst.data["a.txt"] = []byte("evil")
+
+	report, err := Verify(context.Background(), Options{Src: src, Dst: dst, Compare: ChecksumComparer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Reason != "content differs" {
+		t.Errorf("mismatches = %v, want a.txt: content differs", report.Mismatches)
+	}
+
+	// An object whose content no longer matches the hash recorded when it
+	// was uploaded was corrupted, not replaced.
+	sum, err := fileSHA256(filepath.Join(src, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.objects["a.txt"].SHA256 = hex.EncodeToString(sum)
+	report, err = Verify(context.Background(), Options{Src: src, Dst: dst, Compare: ChecksumComparer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0].Reason != "content does not match its recorded SHA-256" {
+		t.Errorf("mismatches = %v, want a.txt: content does not match its recorded SHA-256", report.Mismatches)
+	}
+}
+
+func TestVerify_archived(t *testing.T) {
+	src := t.TempDir()
+	same := writeFile(t, src, "same.txt", "same")
+	changed := writeFile(t, src, "changed.txt", "new!")
+	unrecorded := writeFile(t, src, "unrecorded.txt", "x")
+	sum, err := fileSHA256(filepath.Join(src, "same.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No data: reading any of them would fail the audit.
+	dst := newMockDest()
+	dst.objects["same.txt"] = &ObjectMeta{Size: same.Size(), Archived: true, SHA256: hex.EncodeToString(sum)}
+	dst.objects["changed.txt"] = &ObjectMeta{Size: changed.Size(), Archived: true, SHA256: hex.EncodeToString(sum)}
+	dst.objects["unrecorded.txt"] = &ObjectMeta{Size: unrecorded.Size(), ModTime: unrecorded.ModTime(), Archived: true}
+
+	report, err := Verify(context.Background(), Options{Src: src, Dst: dst, Compare: ChecksumComparer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) != 1 || report.Mismatches[0] != (Mismatch{Key: "changed.txt", Reason: "content differs"}) {
+		t.Errorf("mismatches = %v, want changed.txt: content differs", report.Mismatches)
+	}
+	if want := []string{"same.txt", "unrecorded.txt"}; fmt.Sprint(report.Archived) != fmt.Sprint(want) {
+		t.Errorf("archived = %v, want %v", report.Archived, want)
+	}
+
+	// Comparing by mtime reads nothing, so nothing is left unchecked.
+	report, err = Verify(context.Background(), Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Archived) != 0 {
+		t.Errorf("by mtime: archived = %v, want none", report.Archived)
+	}
+}
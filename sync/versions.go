@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// versioner returns dst as a Versioner, or fails with
+// errors.ErrUnsupported if it keeps no versions.
+func versioner(dst Destination) (Versioner, error) {
+	v, ok := dst.(Versioner)
+	if !ok {
+		return nil, fmt.Errorf("destination keeps no object versions: %w", errors.ErrUnsupported)
+	}
+	return v, nil
+}
+
+// ListVersions returns the versions of the objects in dst whose keys begin
+// with prefix, in key order and, for each key, newest first. A bucket
+// without versioning has a single version of each object, whose ID is
+// "null".
+func ListVersions(ctx context.Context, dst Destination, prefix string) ([]ObjectVersion, error) {
+	v, err := versioner(dst)
+	if err != nil {
+		return nil, err
+	}
+	var all []ObjectVersion
+	err = v.ListVersions(ctx, prefix, func(versions []ObjectVersion) error {
+		all = append(all, versions...)
+		return nil
+	})
+	return all, err
+}
+
+// RestoreVersion makes version id of the object at key in dst its current
+// version again, server-side, so that the next restore reads it. The
+// version replaced is kept as a noncurrent version.
+func RestoreVersion(ctx context.Context, dst Destination, key, id string) error {
+	v, err := versioner(dst)
+	if err != nil {
+		return err
+	}
+	return v.RestoreVersion(ctx, key, id)
+}
+
+// VersionCount is what CountVersions finds of the versions of a key.
+type VersionCount struct {
+	Key            string `json:"key"`
+	Versions       int    `json:"versions"`        // holding content, the current one included
+	DeleteMarkers  int    `json:"delete_markers"`  // left by deletes
+	NoncurrentSize int64  `json:"noncurrent_size"` // bytes held by the versions other than the current one
+	Deleted        bool   `json:"deleted"`         // the current version is a delete marker
+}
+
+// CountVersions counts the versions of each key of versions, as
+// ListVersions returns them, in key order.
+func CountVersions(versions []ObjectVersion) []VersionCount {
+	var counts []VersionCount
+	for _, group := range versionsByKey(versions) {
+		c := VersionCount{Key: group[0].Key}
+		for _, v := range group {
+			switch {
+			case v.DeleteMarker:
+				c.DeleteMarkers++
+				c.Deleted = c.Deleted || v.Latest
+			default:
+				c.Versions++
+				if !v.Latest {
+					c.NoncurrentSize += v.Size
+				}
+			}
+		}
+		counts = append(counts, c)
+	}
+	return counts
+}
+
+// versionsByKey splits versions, in key order, into the versions of each
+// key.
+func versionsByKey(versions []ObjectVersion) [][]ObjectVersion {
+	var groups [][]ObjectVersion
+	for i := 0; i < len(versions); {
+		j := i + 1
+		for j < len(versions) && versions[j].Key == versions[i].Key {
+			j++
+		}
+		groups = append(groups, versions[i:j])
+		i = j
+	}
+	return groups
+}
+
+// PurgeVersionsOptions configures PurgeVersions.
+type PurgeVersionsOptions struct {
+	// Prefix limits the purge to the keys beginning with it.
+	Prefix string
+	// OlderThan is how long a version must have been noncurrent, since a
+	// newer version or a delete replaced it, to be purged. Zero purges
+	// every noncurrent version.
+	OlderThan time.Duration
+	// Keep is how many of the newest noncurrent versions of each key to
+	// keep however old they are.
+	Keep int
+	// DryRun returns what would be purged without deleting it.
+	DryRun bool
+	// VerifyKey, if set, is the key the manifest at the root of the
+	// destination must be signed with; nothing is purged if it does not
+	// verify.
+	VerifyKey ed25519.PublicKey
+}
+
+// PurgeVersions deletes for good the noncurrent versions of the objects in
+// dst that opts selects, and the delete markers that are left with no
+// version behind them, and returns them. The current versions of objects
+// are never purged, but the versions of a deleted object are: its content
+// is gone for good. Versions under Object Lock retention cannot be.
+func PurgeVersions(ctx context.Context, dst Destination, opts PurgeVersionsOptions) ([]ObjectVersion, error) {
+	if opts.VerifyKey != nil {
+		if err := verifyManifest(ctx, dst, opts.VerifyKey); err != nil {
+			return nil, err
+		}
+	}
+	versions, err := ListVersions(ctx, dst, opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-opts.OlderThan)
+	var purge []ObjectVersion
+	for _, group := range versionsByKey(versions) {
+		kept, purged := 0, 0 // noncurrent versions with content kept, and versions purged
+		for i, v := range group {
+			if i == 0 {
+				continue // current
+			}
+			// Noncurrent since the version after it was written.
+			if group[i-1].Written.After(cutoff) || !v.DeleteMarker && kept < opts.Keep {
+				if !v.DeleteMarker {
+					kept++
+				}
+				continue
+			}
+			purge = append(purge, v)
+			purged++
+		}
+		if group[0].DeleteMarker && purged == len(group)-1 {
+			purge = append(purge, group[0])
+		}
+	}
+	if opts.DryRun || len(purge) == 0 {
+		return purge, nil
+	}
+	v, _ := versioner(dst)
+	return v.DeleteVersions(ctx, purge)
+}
@@ -0,0 +1,94 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mockVersions is a mockDest keeping the versions of its objects.
+type mockVersions struct {
+	*mockDest
+	versions []ObjectVersion // in key order, newest first
+	deleted  []ObjectVersion
+}
+
+func (m *mockVersions) ListVersions(ctx context.Context, prefix string, fn func([]ObjectVersion) error) error {
+	return fn(m.versions)
+}
+
+func (m *mockVersions) RestoreVersion(ctx context.Context, key, id string) error {
+	return errors.ErrUnsupported
+}
+
+func (m *mockVersions) DeleteVersions(ctx context.Context, versions []ObjectVersion) ([]ObjectVersion, error) {
+	m.deleted = append(m.deleted, versions...)
+	return versions, nil
+}
+
+func TestPurgeVersions(t *testing.T) {
+	now := time.Now()
+	ago := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	dst := &mockVersions{mockDest: newMockDest(), versions: []ObjectVersion{
+		{Key: "a.txt", VersionID: "a4", Size: 4, Written: ago(1), Latest: true},
+		{Key: "a.txt", VersionID: "a3", Size: 3, Written: ago(20)}, // noncurrent for a day
+		{Key: "a.txt", VersionID: "a2", Size: 2, Written: ago(40)}, // for 20 days
+		{Key: "a.txt", VersionID: "a1", Size: 1, Written: ago(50)}, // for 40 days
+		{Key: "gone.txt", VersionID: "g2", Written: ago(60), Latest: true, DeleteMarker: true},
+		{Key: "gone.txt", VersionID: "g1", Size: 9, Written: ago(90)},
+		{Key: "kept.txt", VersionID: "k1", Size: 5, Written: ago(90), Latest: true},
+	}}
+
+	counts := CountVersions(dst.versions)
+	want := []VersionCount{
+		{Key: "a.txt", Versions: 4, NoncurrentSize: 6},
+		{Key: "gone.txt", Versions: 1, DeleteMarkers: 1, NoncurrentSize: 9, Deleted: true},
+		{Key: "kept.txt", Versions: 1},
+	}
+	if !slices.Equal(counts, want) {
+		t.Errorf("CountVersions = %+v, want %+v", counts, want)
+	}
+
+	ids := func(versions []ObjectVersion) []string {
+		var ids []string
+		for _, v := range versions {
+			ids = append(ids, v.VersionID)
+		}
+		return ids
+	}
+	for _, tc := range []struct {
+		opts PurgeVersionsOptions
+		want []string
+	}{
+		{PurgeVersionsOptions{OlderThan: 10 * 24 * time.Hour}, []string{"a2", "a1", "g1", "g2"}},
+		{PurgeVersionsOptions{OlderThan: 30 * 24 * time.Hour}, []string{"a1", "g1", "g2"}},
+		{PurgeVersionsOptions{Keep: 1}, []string{"a2", "a1"}},
+		{PurgeVersionsOptions{}, []string{"a3", "a2", "a1", "g1", "g2"}},
+	} {
+		tc.opts.DryRun = true
+		purged, err := PurgeVersions(context.Background(), dst, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(purged); !slices.Equal(got, tc.want) {
+			t.Errorf("PurgeVersions(%+v) = %v, want %v", tc.opts, got, tc.want)
+		}
+	}
+	if len(dst.deleted) != 0 {
+		t.Fatalf("dry runs deleted %v", ids(dst.deleted))
+	}
+
+	purged, err := PurgeVersions(context.Background(), dst, PurgeVersionsOptions{Keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(dst.deleted), ids(purged)) || len(purged) != 2 {
+		t.Errorf("deleted %v, want a2 and a1", ids(dst.deleted))
+	}
+
+	if _, err := ListVersions(context.Background(), newMockDest(), ""); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ListVersions of a destination without versions: %v, want errors.ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// walkSource walks the tree at root as filepath.WalkDir does, reading
+// directories ahead with opts.WalkConcurrency goroutines if it is above 1,
+// and reading those that fail again with WalkErrorsRetry.
+func walkSource(opts Options, root string, fn fs.WalkDirFunc) error {
+	retries := 0
+	if opts.OnWalkError == WalkErrorsRetry {
+		retries = walkRetries
+	}
+	if opts.WalkConcurrency <= 1 && retries == 0 {
+		return filepath.WalkDir(root, fn)
+	}
+	w := &walker{fn: fn, sem: make(chan struct{}, max(opts.WalkConcurrency, 1)), retries: retries}
+	return w.walkRoot(root)
+}
+
+// parallelWalk walks the tree at root as filepath.WalkDir does, calling fn
+// in the same lexical order and with the same handling of SkipDir and
+// SkipAll, but reads directories ahead of fn. On entering a directory it
+// starts reading the next of its subdirectories, up to workers of them
+// ahead, with the information of their files; no more than workers
+// directories are read at once. On network filesystems, where each read
+// waits on the server, this keeps the walk from waiting on every one in
+// turn.
+func parallelWalk(root string, workers int, fn fs.WalkDirFunc) error {
+	w := &walker{fn: fn, sem: make(chan struct{}, workers)}
+	return w.walkRoot(root)
+}
+
+// walkRoot walks the tree at root, as parallelWalk describes.
+func (w *walker) walkRoot(root string) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = w.fn(root, nil, err)
+	} else {
+		var pending <-chan listing
+		if info.IsDir() {
+			pending = w.read(root)
+		}
+		err = w.walk(root, fs.FileInfoToDirEntry(info), pending)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+type walker struct {
+	fn      fs.WalkDirFunc
+	sem     chan struct{} // holds a token for each directory being read
+	retries int           // times a directory that fails to be read is read again
+}
+
+// listing is a directory read by a walker.
+type listing struct {
+	entries []fs.DirEntry
+	err     error
+}
+
+// read starts reading dir, once fewer than cap(w.sem) directories are
+// being read, and returns the channel its listing is sent on. The channel
+// is buffered, so that listings the walk skips are dropped.
+func (w *walker) read(dir string) <-chan listing {
+	ch := make(chan listing, 1)
+	go func() {
+		w.sem <- struct{}{}
+		defer func() { <-w.sem }()
+		l := readListing(dir)
+		for retry := 0; l.err != nil && retry < w.retries && !errors.Is(l.err, fs.ErrNotExist); retry++ {
+			time.Sleep(walkBackoff << retry)
+			l = readListing(dir)
+		}
+		ch <- l
+	}()
+	return ch
+}
+
+// readListing reads dir and the information of the files in it. Files
+// whose information cannot be read are left for fn to find out about from
+// their DirEntry, as it would with filepath.WalkDir.
+func readListing(dir string) listing {
+	entries, err := os.ReadDir(dir)
+	for i, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if info, ierr := e.Info(); ierr == nil {
+			entries[i] = fs.FileInfoToDirEntry(info)
+		}
+	}
+	return listing{entries, err}
+}
+
+// walk calls w.fn for path, and if it is a directory, whose listing is
+// sent on pending, for everything below it.
+func (w *walker) walk(path string, d fs.DirEntry, pending <-chan listing) error {
+	if err := w.fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	l := <-pending
+	if l.err != nil {
+		if err := w.fn(path, d, l.err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	subdirs := make([]<-chan listing, len(l.entries))
+	next, ahead := 0, 0 // the next entry to read ahead, and how many are
+	for i, e := range l.entries {
+		for ; next < len(l.entries) && ahead < cap(w.sem); next++ {
+			if l.entries[next].IsDir() {
+				subdirs[next] = w.read(filepath.Join(path, l.entries[next].Name()))
+				ahead++
+			}
+		}
+		if subdirs[i] != nil {
+			ahead--
+		}
+		if err := w.walk(filepath.Join(path, e.Name()), e, subdirs[i]); err != nil {
+			if err == filepath.SkipDir {
+				break // returned for a file: skip the rest of this directory
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParallelWalk(t *testing.T) {
+	root := t.TempDir()
+	for i := range 5 {
+		for j := range 4 {
+			dir := filepath.Join(root, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j))
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, dir, "f.txt", "x")
+			writeFile(t, dir, "g.txt", "x")
+		}
+		writeFile(t, root, fmt.Sprintf("f%d.txt", i), "x")
+	}
+	if err := os.Symlink("d0", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each case returns from the walk function what skip does for a path.
+	for name, skip := range map[string]func(rel string) error{
+		"all":          func(string) error { return nil },
+		"skip dir":     func(rel string) error { return skipIf(rel == "d1/e2" || rel == "d3", fs.SkipDir) },
+		"skip in file": func(rel string) error { return skipIf(rel == "d2/e1/f.txt" || rel == "f1.txt", fs.SkipDir) },
+		"skip all":     func(rel string) error { return skipIf(rel == "d2/e3", fs.SkipAll) },
+	} {
+		walk := func(walker func(fs.WalkDirFunc) error) []string {
+			var got []string
+			err := walker(func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(root, path)
+				got = append(got, fmt.Sprintf("%s %v %d", filepath.ToSlash(rel), d.Type(), info.Size()))
+				return skip(filepath.ToSlash(rel))
+			})
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return got
+		}
+		want := walk(func(fn fs.WalkDirFunc) error { return filepath.WalkDir(root, fn) })
+		for _, workers := range []int{2, 3, 16} {
+			if got := walk(func(fn fs.WalkDirFunc) error { return parallelWalk(root, workers, fn) }); !slices.Equal(got, want) {
+				t.Errorf("%s, %d workers: walked\n%v\nwant\n%v", name, workers, got, want)
+			}
+		}
+	}
+}
+
+func skipIf(cond bool, err error) error {
+	if cond {
+		return err
+	}
+	return nil
+}
+
+func TestParallelWalk_missing(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "gone")
+	var seen error
+	err := parallelWalk(root, 4, func(path string, d fs.DirEntry, err error) error {
+		seen = err
+		return err
+	})
+	if !errors.Is(err, fs.ErrNotExist) || !errors.Is(seen, fs.ErrNotExist) {
+		t.Errorf("walk of a missing root: %v, passed %v; want fs.ErrNotExist", err, seen)
+	}
+}
+
+func TestSync_walkConcurrency(t *testing.T) {
+	src := t.TempDir()
+	for _, dir := range []string{"a", "a/b", "c"} {
+		if err := os.MkdirAll(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(src, dir), "f.txt", dir)
+	}
+	dst := newMockDest()
+	if _, err := Sync(context.Background(), Options{Src: src, Dst: dst, WalkConcurrency: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/b/f.txt", "a/f.txt", "c/f.txt"}; !slices.Equal(dst.putCalls, want) {
+		t.Errorf("uploaded %v, want %v in walk order", dst.putCalls, want)
+	}
+}
@@ -0,0 +1,89 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// WalkErrorPolicy decides what Sync does with the source files and
+// directories it cannot read, such as those its permissions deny, as a
+// system directory holds a few of.
+type WalkErrorPolicy string
+
+const (
+	// WalkErrorsFail stops the run at the first, as by default.
+	WalkErrorsFail WalkErrorPolicy = "fail"
+	// WalkErrorsSkip leaves them out of the run, with a warning to
+	// Options.Warnings, and lists them in Result.Unreadable. Their objects
+	// at the destination are neither uploaded nor deleted.
+	WalkErrorsSkip WalkErrorPolicy = "skip"
+	// WalkErrorsRetry reads them again, up to walkRetries more times and
+	// waiting longer each time, for network filesystems that fail now and
+	// then, and stops the run if they still cannot be read.
+	WalkErrorsRetry WalkErrorPolicy = "retry"
+)
+
+// ParseWalkErrorPolicy parses the names used on the command line: fail,
+// skip and retry.
+func ParseWalkErrorPolicy(s string) (WalkErrorPolicy, error) {
+	switch p := WalkErrorPolicy(s); p {
+	case WalkErrorsFail, WalkErrorsSkip, WalkErrorsRetry:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown walk error policy %q (valid: fail, skip, retry)", s)
+}
+
+// UnreadablePath is a source file or directory that a run with
+// WalkErrorsSkip left out because it could not be read.
+type UnreadablePath struct {
+	Path string // local path
+	Err  error
+}
+
+// walkRetries is how many more times WalkErrorsRetry reads a file or
+// directory, and walkBackoff how long it waits before the first time,
+// doubled each time after.
+const walkRetries = 3
+
+var walkBackoff = time.Second
+
+// retrySource calls read, which reads from the source, and with
+// WalkErrorsRetry calls it again while it fails, up to walkRetries times.
+// Files and directories gone from the source are not read again.
+func retrySource(ctx context.Context, opts Options, read func() error) error {
+	err := read()
+	for retry := 0; err != nil && opts.OnWalkError == WalkErrorsRetry && retry < walkRetries; retry++ {
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(walkBackoff << retry):
+		}
+		err = read()
+	}
+	return err
+}
+
+// skipUnreadable applies opts.OnWalkError to err, the failure to read the
+// source file or directory at path: it returns nil, having added path to
+// plan's unreadable paths, if the policy skips it, and err otherwise.
+func (p *Plan) skipUnreadable(opts Options, path string, err error) error {
+	if opts.OnWalkError != WalkErrorsSkip || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	opts.warnf("skipping %s: %v", path, err)
+	p.unreadable = append(p.unreadable, UnreadablePath{Path: path, Err: err})
+	return nil
+}
+
+// readFailed reports whether err is the failure to read the source file
+// at path, rather than to write its object.
+func readFailed(err error, path string) bool {
+	var pe *fs.PathError
+	return errors.As(err, &pe) && pe.Path == path
+}
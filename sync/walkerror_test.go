@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSync_onWalkError(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "z.txt", "z") // walked after broken
+	// Walked as a file, but cannot be opened to be uploaded.
+	if err := os.Symlink(filepath.Join(src, "missing"), filepath.Join(src, "broken")); err != nil {
+		t.Skip(err)
+	}
+	dst := newMockDest()
+	dst.objects["broken"] = &ObjectMeta{Size: 1}
+	ctx := context.Background()
+
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, Delete: true}); err == nil {
+		t.Fatal("Sync of an unreadable file succeeded, want it to fail")
+	}
+
+	dst.putCalls, dst.deleteCalls = nil, nil
+	var warnings strings.Builder
+	res, err := Sync(ctx, Options{Src: src, Dst: dst, Delete: true, OnWalkError: WalkErrorsSkip, Warnings: &warnings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(warnings.String(), "warning: skipping "+filepath.Join(src, "broken")+": ") {
+		t.Errorf("warnings = %q, want broken skipped", warnings.String())
+	}
+	if len(res.Unreadable) != 1 || res.Unreadable[0].Path != filepath.Join(src, "broken") || res.Summary.Unreadable != 1 {
+		t.Errorf("Unreadable = %v, want broken", res.Unreadable)
+	}
+	if !slices.Equal(dst.putCalls, []string{"z.txt"}) || len(dst.deleteCalls) != 0 {
+		t.Errorf("put %v and deleted %v; want z.txt, and the object of broken kept", dst.putCalls, dst.deleteCalls)
+	}
+
+	if os.Geteuid() == 0 {
+		return // permissions do not deny root
+	}
+	sub := filepath.Join(src, "private")
+	writeFile(t, sub, "secret.txt", "s")
+	if err := os.Chmod(sub, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(sub, 0755)
+	dst.objects["private/secret.txt"] = &ObjectMeta{Size: 1}
+	dst.deleteCalls = nil
+	res, err = Sync(ctx, Options{Src: src, Dst: dst, Delete: true, OnWalkError: WalkErrorsSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Unreadable) != 2 || res.Unreadable[0].Path != sub {
+		t.Errorf("Unreadable = %v, want private and broken", res.Unreadable)
+	}
+	if len(dst.deleteCalls) != 0 {
+		t.Errorf("deleted %v, want the objects of unreadable files kept", dst.deleteCalls)
+	}
+
+	defer func(d time.Duration) { walkBackoff = d }(walkBackoff)
+	walkBackoff = time.Millisecond
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst, OnWalkError: WalkErrorsRetry}); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Sync retrying an unreadable directory: %v, want fs.ErrPermission", err)
+	}
+}
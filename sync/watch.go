@@ -0,0 +1,353 @@
+package sync
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch syncs opts.Src to opts.Dst like Sync, then watches opts.Src and
+// syncs files as they are created, changed or removed, until ctx is done.
+// Changes are collected until none have arrived for debounce, so that a
+// file being written or a directory being copied in is synced once, when
+// it is complete. Watch returns the first error from a sync, or nil once
+// ctx is done. opts.MaxRequests, MaxDuration and MaxTransfer are not
+// supported. With opts.Control set, changes are collected without being
+// synced while it is paused, and the whole tree is synced whenever it
+// asks.
+func Watch(ctx context.Context, opts Options, debounce time.Duration) error {
+	if opts.MaxRequests > 0 {
+		return errors.New("watch: a request limit cannot be used when watching")
+	}
+	if opts.MaxDuration > 0 || opts.MaxTransfer > 0 {
+		return errors.New("watch: a run budget cannot be used when watching")
+	}
+	if opts.Bundle != nil {
+		// Each batch of changes would make an archive of its own.
+		return errors.New("watch: bundling cannot be used when watching")
+	}
+	if mapsKeys(opts.Keys) {
+		return errors.New("watch: a key layout cannot be used when watching")
+	}
+	if opts.ReportExtraneous != "" {
+		return errors.New("watch: extraneous objects cannot be reported when watching")
+	}
+	if opts.Incremental || opts.Snapshots {
+		return errors.New("watch: incremental runs and snapshots cannot be used when watching")
+	}
+	if len(opts.Sources) > 0 {
+		return errors.New("watch: several sources cannot be watched; watch each in a run of its own")
+	}
+	if opts.HardLinks {
+		return errors.New("watch: hard links cannot be preserved when watching")
+	}
+	if opts.SkipInFlight {
+		return errors.New("watch: files in flight cannot be skipped when watching, which waits for changes to settle")
+	}
+	if opts.KeepEmptyDirs {
+		return errors.New("watch: empty directories cannot be kept when watching")
+	}
+	if opts.Confirm != nil {
+		return errors.New("watch: changes cannot be confirmed one by one when watching")
+	}
+	if opts.FileList != nil {
+		return errors.New("watch: a file list cannot be used when watching")
+	}
+	if opts.SourceSnapshot != nil {
+		return errors.New("watch: sources cannot be snapshotted when watching")
+	}
+	if opts.CaseCollisions == CaseCollisionsRename {
+		// Changes are planned without the rest of the tree to rename against.
+		return errors.New("watch: files differing only in case cannot be renamed when watching")
+	}
+	release, err := acquireRunLock(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer release()
+	opts.events = newEventStream(opts.OnRunEvent)
+	opts.OnEvent = opts.Control.onEvent(opts.OnEvent)
+	opts, err = prepare(ctx, opts)
+	if err != nil {
+		return err
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watch: %w", err)
+	}
+	defer fsw.Close()
+
+	w := &watcher{opts: opts, fsw: fsw}
+	opts.Control.setSyncing(true)
+	err = w.syncAll(ctx)
+	opts.Control.setSyncing(false)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	pending := make(map[string]bool)
+	full := false // events were lost; resync everything
+	flush := func() error {
+		opts.Control.setSyncing(true)
+		defer opts.Control.setSyncing(false)
+		var err error
+		if full {
+			err = w.syncAll(ctx)
+		} else {
+			err = w.syncChanged(ctx, slices.Sorted(maps.Keys(pending)))
+		}
+		if err != nil {
+			return err
+		}
+		clear(pending)
+		full = false
+		opts.Control.setPending(0)
+		return nil
+	}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	wake := opts.Control.wakeups()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			pending[ev.Name] = true
+			if filepath.Base(ev.Name) == IgnoreFile {
+				full = true // files may have been ignored or re-included anywhere below
+			}
+			opts.Control.setPending(len(pending))
+			timer.Reset(debounce)
+			continue
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				return fmt.Errorf("watch: %w", err)
+			}
+			full = true
+			timer.Reset(debounce)
+			continue
+		case <-wake:
+		case <-timer.C:
+		}
+		// Debounced, or woken up by opts.Control.
+		syncNow, paused := opts.Control.take()
+		if syncNow {
+			full = true
+		} else if paused || (len(pending) == 0 && !full) {
+			continue
+		}
+		if err := flush(); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+type watcher struct {
+	opts   Options
+	fsw    *fsnotify.Watcher
+	files  map[string]File // every source file, by key
+	state  *stateCache     // as of the last sync, nil unless opts.StateCache is set
+	ignore *ignorer        // as of the last full sync
+}
+
+// syncAll watches the whole source tree and syncs it.
+func (w *watcher) syncAll(ctx context.Context) error {
+	// Watch before planning so that no change slips in between.
+	if err := w.watchTree(w.opts.Src); err != nil {
+		return err
+	}
+	start := time.Now()
+	plan, err := buildPlan(ctx, w.opts)
+	if err == nil {
+		err = execute(ctx, w.opts, plan)
+	}
+	w.finished(plan, start, err)
+	if err != nil {
+		return err
+	}
+	w.files = make(map[string]File, len(plan.Files))
+	w.record(plan)
+	w.state = plan.state
+	w.ignore = plan.ignore
+	return nil
+}
+
+// finished records a sync of plan, started at start and ended with err, in
+// the metrics and the event stream.
+func (w *watcher) finished(plan *Plan, start time.Time, err error) {
+	s := summarize(w.opts, plan, start, err)
+	w.opts.Metrics.record(s)
+	w.opts.Control.finished(s)
+	w.opts.emit(RunComplete{Summary: s})
+}
+
+// syncChanged syncs the files at paths, which are sorted, and everything
+// below those that are directories.
+func (w *watcher) syncChanged(ctx context.Context, paths []string) error {
+	// An unmounted source looks like every file was removed.
+	if err := validateSrc(w.opts.Src); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	plan := &Plan{state: w.state.next(), ignore: w.ignore}
+	removed := 0
+	var walked string // last directory planned in full
+	for _, path := range paths {
+		if walked != "" && strings.HasPrefix(path, walked+string(filepath.Separator)) {
+			continue
+		}
+		key, err := sourceKey(w.opts.Src, path)
+		if err != nil {
+			return err
+		}
+		if key == "." || strings.HasPrefix(key+"/", metaPrefix) {
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err == nil {
+			if skip, err := w.ignore.ignored(key, info.IsDir()); err != nil {
+				return err
+			} else if skip {
+				continue
+			}
+		}
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			removed += w.removed(plan, w.opts.UnicodeForm.normalize(key))
+		case err != nil:
+			return err
+		case info.IsDir():
+			// Created or moved in: watch it, and sync what it holds.
+			if err := w.watchTree(path); err != nil {
+				return err
+			}
+			removed += w.removed(plan, w.opts.UnicodeForm.normalize(key)) // files still in it are kept below
+			if err := planUploads(ctx, w.opts, plan, SourceSpec{Dir: w.opts.Src}, path); err != nil {
+				return err
+			}
+			walked = path
+		case w.opts.filterReason(info.Size(), info.ModTime()) != "":
+			// Modified out of range: no longer synced, but not deleted.
+			delete(w.files, key)
+		default:
+			file, err := newFile(w.opts, path, key, info)
+			if err != nil {
+				return err
+			}
+			if err := planFile(ctx, w.opts, plan, file); err != nil {
+				return err
+			}
+		}
+	}
+	if w.opts.ScanSecrets {
+		if err := screenSecrets(w.opts, plan); err != nil {
+			return err
+		}
+	}
+	if err := planRenames(w.opts, plan); err != nil {
+		return err
+	}
+	if err := planDuplicates(w.opts, plan); err != nil {
+		return err
+	}
+
+	// The threshold and the manifest both need the whole tree.
+	for _, f := range plan.Files {
+		w.files[f.Key] = f
+	}
+	plan.Deletes = slices.DeleteFunc(plan.Deletes, func(key string) bool {
+		_, ok := w.files[key]
+		return ok
+	})
+	// Without uploads, transitions or deletes there is nothing to do,
+	// unless the manifest still lists removed files.
+	if len(plan.Uploads) == 0 && len(plan.Transitions) == 0 && len(plan.Deletes) == 0 && (removed == 0 || !w.opts.Manifest) {
+		return nil
+	}
+	plan.Files = slices.SortedFunc(maps.Values(w.files), func(a, b File) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	slices.Sort(plan.Deletes)
+	err := execute(ctx, w.opts, plan)
+	w.finished(plan, start, err)
+	if err != nil {
+		return err
+	}
+	w.record(plan)
+	w.state = plan.state
+	return nil
+}
+
+// removed forgets the file or directory stored under key, adding its
+// objects to plan.Deletes if opts.Delete is set. It returns the number of
+// files forgotten.
+func (w *watcher) removed(plan *Plan, key string) int {
+	n := 0
+	for k := range w.files {
+		if k == key || strings.HasPrefix(k, key+"/") {
+			delete(w.files, k)
+			if w.opts.Delete {
+				plan.Deletes = append(plan.Deletes, k)
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// record updates w.files once plan has been applied.
+func (w *watcher) record(plan *Plan) {
+	for _, f := range plan.Files {
+		w.files[f.Key] = f
+	}
+	for _, u := range plan.Uploads {
+		w.files[u.Key] = u.upToDate()
+	}
+}
+
+// watchTree adds root and every directory below it to the watcher.
+// Directories removed while this runs are skipped.
+func (w *watcher) watchTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if key, _ := sourceKey(w.opts.Src, path); key+"/" == metaPrefix {
+			return filepath.SkipDir
+		}
+		if err := w.fsw.Add(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
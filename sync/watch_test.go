@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func newTestWatcher(t *testing.T, opts Options) *watcher {
+	t.Helper()
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fsw.Close() })
+	w := &watcher{opts: opts, fsw: fsw}
+	if err := w.syncAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWatcher_syncChangedUploadsOnlyChangedFiles(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+	writeFile(t, src, "b.txt", "b")
+
+	dst := newMockDest()
+	w := newTestWatcher(t, Options{Src: src, Dst: dst})
+	dst.putCalls, dst.statCalls = nil, nil
+
+	writeFile(t, src, "c.txt", "c")
+	writeFile(t, src, "new/d.txt", "d")
+	paths := []string{filepath.Join(src, "c.txt"), filepath.Join(src, "new"), filepath.Join(src, "new/d.txt")}
+	if err := w.syncChanged(context.Background(), paths); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(dst.putCalls)
+	if want := []string{"c.txt", "new/d.txt"}; !slices.Equal(dst.putCalls, want) {
+		t.Errorf("uploads = %v, want %v", dst.putCalls, want)
+	}
+	if len(dst.statCalls) != 2 {
+		t.Errorf("expected only the changed files to be checked, got stat %v", dst.statCalls)
+	}
+}
+
+func TestWatcher_syncChangedDeletesRemovedDirectory(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "keep.txt", "k")
+	writeFile(t, src, "old/x.txt", "x")
+	writeFile(t, src, "old/sub/y.txt", "y")
+
+	dst := newMockDest()
+	w := newTestWatcher(t, Options{Src: src, Dst: dst, Delete: true, Manifest: true})
+
+	if err := os.RemoveAll(filepath.Join(src, "old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.syncChanged(context.Background(), []string{filepath.Join(src, "old")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"old/x.txt", "old/sub/y.txt"} {
+		if _, ok := dst.objects[key]; ok {
+			t.Errorf("%s was not deleted", key)
+		}
+	}
+	m, err := ReadManifest(context.Background(), dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Key != "keep.txt" {
+		t.Errorf("manifest = %+v, want only keep.txt", m.Files)
+	}
+}
+
+func TestWatcher_syncChangedRefusesMissingSource(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "mnt")
+	writeFile(t, src, "a.txt", "a")
+
+	dst := newMockDest()
+	w := newTestWatcher(t, Options{Src: src, Dst: dst, Delete: true})
+
+	if err := os.RemoveAll(src); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.syncChanged(context.Background(), []string{filepath.Join(src, "a.txt")}); err == nil {
+		t.Error("expected an error for a missing source")
+	}
+	if len(dst.deleteCalls) != 0 {
+		t.Errorf("deleted %v after the source disappeared", dst.deleteCalls)
+	}
+}
+
+func TestWatch_syncsNewFiles(t *testing.T) {
+	src := t.TempDir()
+	out := t.TempDir()
+	writeFile(t, src, "a.txt", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, Options{Src: src, Dst: NewLocalDestination(out)}, 20*time.Millisecond)
+	}()
+	waitForFile(t, filepath.Join(out, "a.txt"))
+
+	writeFile(t, src, "dir/b.txt", "b")
+	waitForFile(t, filepath.Join(out, "dir/b.txt"))
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was not synced", path)
+}
@@ -0,0 +1,537 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	gosync "sync"
+)
+
+func init() {
+	Register("webdav", openWebDAVURL)
+	Register("webdavs", openWebDAVURL)
+}
+
+// webdavNS is the XML namespace of the property WebDAVDestination keeps
+// each file's metadata in.
+const webdavNS = "https://github.com/r3dstang/foldersync"
+
+// WebDAVDestination uploads files to a WebDAV server, such as Nextcloud or
+// ownCloud, a file per key under a root collection. Collections are created
+// as files are put in them, and left in place when the files are deleted.
+//
+// The metadata Stat reports back is stored as a property of each file, so
+// the server must support PROPPATCH, as Nextcloud, ownCloud and Apache's
+// mod_dav do. The mtime is also sent in the X-OC-Mtime header, which
+// Nextcloud and ownCloud set the file's own mtime from.
+type WebDAVDestination struct {
+	client   *http.Client
+	root     *url.URL
+	user     string
+	password string
+
+	mu   gosync.Mutex
+	dirs map[string]bool // collections known to exist
+}
+
+// NewWebDAVDestination creates a new WebDAVDestination storing files under
+// the collection at root, an http or https URL. If user is not empty,
+// requests are authenticated with it and password.
+func NewWebDAVDestination(client *http.Client, root *url.URL, user, password string) *WebDAVDestination {
+	r := *root
+	r.User = nil
+	r.Path = strings.TrimSuffix(r.Path, "/")
+	r.RawPath = ""
+	return &WebDAVDestination{client: client, root: &r, user: user, password: password, dirs: make(map[string]bool)}
+}
+
+// openWebDAVURL opens webdav://host/path over http, or webdavs://host/path
+// over https, with the user and password in the URL or in the
+// WEBDAV_USERNAME and WEBDAV_PASSWORD environment variables. For Nextcloud
+// the path is /remote.php/dav/files/<user>/<folder>, and the password
+// should be an app password.
+func openWebDAVURL(ctx context.Context, u *url.URL) (Destination, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s:// URL must have a host", u.Scheme)
+	}
+	if u.Query().Get("storage-class") != "" {
+		return nil, fmt.Errorf("%s:// destinations do not support storage classes", u.Scheme)
+	}
+	user, password := os.Getenv("WEBDAV_USERNAME"), os.Getenv("WEBDAV_PASSWORD")
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+	root := &url.URL{Scheme: "http", Host: u.Host, Path: u.Path}
+	if u.Scheme == "webdavs" {
+		root.Scheme = "https"
+	}
+	return NewWebDAVDestination(&http.Client{}, root, user, password), nil
+}
+
+// url returns the URL of the file or collection at key, "" for the root.
+func (d *WebDAVDestination) url(key string) string {
+	u := *d.root
+	if key != "" {
+		u.Path += "/" + key
+	}
+	return u.String()
+}
+
+// do sends a request with method to the URL of key, and returns the
+// response if its status is one of ok. Otherwise it closes the body and
+// returns a *webdavError.
+func (d *WebDAVDestination) do(ctx context.Context, method, key string, body io.Reader, header http.Header, ok ...int) (*http.Response, error) {
+	req, err := d.newRequest(ctx, method, key, body, header)
+	if err != nil {
+		return nil, err
+	}
+	return d.send(req, key, ok...)
+}
+
+func (d *WebDAVDestination) newRequest(ctx context.Context, method, key string, body io.Reader, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if d.user != "" {
+		req.SetBasicAuth(d.user, d.password)
+	}
+	return req, nil
+}
+
+func (d *WebDAVDestination) send(req *http.Request, key string, ok ...int) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(ok, resp.StatusCode) {
+		resp.Body.Close()
+		return nil, &webdavError{Method: req.Method, Key: key, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, nil
+}
+
+// webdavError is an unexpected response status from a WebDAV server.
+type webdavError struct {
+	Method     string
+	Key        string
+	StatusCode int
+	Status     string
+}
+
+func (e *webdavError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Method, e.Key, e.Status)
+}
+
+// webdavStatus returns the status of the response err reports, or 0 if it
+// is not a *webdavError.
+func webdavStatus(err error) int {
+	var e *webdavError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// checkURLKey rejects keys that would name a file outside the root.
+func checkURLKey(key string) error {
+	if !fs.ValidPath(key) || key == "." {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	return nil
+}
+
+// mkdirs creates the collections key is in that are not known to exist,
+// from the root down.
+func (d *WebDAVDestination) mkdirs(ctx context.Context, key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := 0; i < len(key); i++ {
+		if key[i] != '/' || d.dirs[key[:i]] {
+			continue
+		}
+		// 405 means there is something there already.
+		resp, err := d.do(ctx, "MKCOL", key[:i], nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		d.dirs[key[:i]] = true
+	}
+	return nil
+}
+
+// Put uploads r with PUT, sending its length if r is an io.Seeker, and
+// then stores meta as a property of the file with PROPPATCH.
+func (d *WebDAVDestination) Put(ctx context.Context, key string, r io.Reader, meta ObjectMeta) error {
+	if err := checkURLKey(key); err != nil {
+		return err
+	}
+	if err := d.mkdirs(ctx, key); err != nil {
+		return err
+	}
+	header := make(http.Header)
+	if !meta.ModTime.IsZero() {
+		header.Set("X-OC-Mtime", strconv.FormatInt(meta.ModTime.Unix(), 10))
+	}
+	if meta.ContentType != "" {
+		header.Set("Content-Type", meta.ContentType)
+	}
+	// The client closes request bodies, which are the caller's to close.
+	req, err := d.newRequest(ctx, http.MethodPut, key, io.NopCloser(r), header)
+	if err != nil {
+		return err
+	}
+	if s, ok := r.(io.Seeker); ok {
+		n, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		req.ContentLength = n
+		if n == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	resp, err := d.send(req, key, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return d.setMeta(ctx, key, meta)
+}
+
+// setMeta stores meta as the metadata property of the file at key.
+func (d *WebDAVDestination) setMeta(ctx context.Context, key string, meta ObjectMeta) error {
+	md := make(url.Values)
+	for k, v := range objectMetadata(meta) {
+		md.Set(k, v)
+	}
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propertyupdate xmlns:d="DAV:" xmlns:f="` + webdavNS + `"><d:set><d:prop><f:meta>`)
+	xml.EscapeText(&body, []byte(md.Encode()))
+	body.WriteString(`</f:meta></d:prop></d:set></d:propertyupdate>`)
+
+	header := http.Header{"Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := d.do(ctx, "PROPPATCH", key, &body, header, http.StatusMultiStatus)
+	if err != nil {
+		return fmt.Errorf("store metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return fmt.Errorf("store metadata of %s: %w", key, err)
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !ps.ok() {
+				return fmt.Errorf("store metadata of %s: %s", key, ps.Status)
+			}
+		}
+	}
+	return nil
+}
+
+// webdavPropfind asks for the properties of webdavEntry.
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<d:propfind xmlns:d="DAV:" xmlns:f="` + webdavNS + `"><d:prop>` +
+	`<d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getcontenttype/><f:meta/>` +
+	`</d:prop></d:propfind>`
+
+type webdavMultistatus struct {
+	Responses []webdavResponse `xml:"DAV: response"`
+}
+
+type webdavResponse struct {
+	Href      string           `xml:"DAV: href"`
+	Propstats []webdavPropstat `xml:"DAV: propstat"`
+}
+
+type webdavPropstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ResourceType struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+		ContentLength string `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ContentType   string `xml:"DAV: getcontenttype"`
+		Meta          string `xml:"https://github.com/r3dstang/foldersync meta"`
+	} `xml:"DAV: prop"`
+}
+
+// ok reports whether the server found the properties of ps. Those it did
+// not are reported in a propstat of their own, with a 404 status.
+func (ps webdavPropstat) ok() bool {
+	_, status, _ := strings.Cut(ps.Status, " ")
+	return strings.HasPrefix(status, "200")
+}
+
+// webdavEntry is a file or collection a PROPFIND reported.
+type webdavEntry struct {
+	key        string
+	collection bool
+	meta       ObjectMeta
+}
+
+// propfind lists the entries at key to depth, "0", "1" or "infinity",
+// including the one at key itself.
+func (d *WebDAVDestination) propfind(ctx context.Context, key, depth string) ([]webdavEntry, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := d.do(ctx, "PROPFIND", key, strings.NewReader(webdavPropfind), header, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %w", key, err)
+	}
+
+	entries := make([]webdavEntry, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		k, err := d.hrefKey(r.Href)
+		if err != nil {
+			return nil, fmt.Errorf("PROPFIND %s: %w", key, err)
+		}
+		e := webdavEntry{key: k}
+		var md string
+		for _, ps := range r.Propstats {
+			if !ps.ok() {
+				continue
+			}
+			p := ps.Prop
+			e.collection = e.collection || p.ResourceType.Collection != nil
+			if p.ContentLength != "" {
+				e.meta.Size, _ = strconv.ParseInt(p.ContentLength, 10, 64)
+			}
+			if p.LastModified != "" {
+				e.meta.ModTime, _ = http.ParseTime(p.LastModified)
+			}
+			if p.ContentType != "" {
+				e.meta.ContentType = p.ContentType
+			}
+			if p.Meta != "" {
+				md = p.Meta
+			}
+		}
+		// Files foldersync did not upload have no metadata property, and
+		// are reported with the mtime the server gives them.
+		if q, err := url.ParseQuery(md); err == nil && md != "" {
+			m := make(map[string]string, len(q))
+			for k := range q {
+				m[k] = q.Get(k)
+			}
+			contentType := e.meta.ContentType
+			e.meta = *parseMetadata(e.meta.Size, m)
+			e.meta.ContentType = contentType
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// hrefKey returns the key of the entry at href, a path or URL, or "" for
+// the root.
+func (d *WebDAVDestination) hrefKey(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	p := strings.TrimSuffix(u.Path, "/")
+	if p == d.root.Path {
+		return "", nil
+	}
+	key, ok := strings.CutPrefix(p, d.root.Path+"/")
+	if !ok {
+		return "", fmt.Errorf("%s is not under %s", href, d.root.Path)
+	}
+	return key, nil
+}
+
+// Stat reports the metadata of the file at key, or nil if there is no file
+// there.
+func (d *WebDAVDestination) Stat(ctx context.Context, key string) (*ObjectMeta, error) {
+	if err := checkURLKey(key); err != nil {
+		return nil, err
+	}
+	entries, err := d.propfind(ctx, key, "0")
+	if webdavStatus(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.key == key && !e.collection {
+			return &e.meta, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *WebDAVDestination) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkURLKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := d.do(ctx, http.MethodGet, key, nil, nil, http.StatusOK)
+	if webdavStatus(err) == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Copy implements Copier with the COPY method, which copies the file's
+// properties, and its metadata with them, on the server.
+func (d *WebDAVDestination) Copy(ctx context.Context, src, dst string) error {
+	if err := checkURLKey(src); err != nil {
+		return err
+	}
+	if err := checkURLKey(dst); err != nil {
+		return err
+	}
+	if err := d.mkdirs(ctx, dst); err != nil {
+		return err
+	}
+	header := http.Header{"Destination": {d.url(dst)}, "Overwrite": {"T"}}
+	resp, err := d.do(ctx, "COPY", src, nil, header, http.StatusCreated, http.StatusNoContent)
+	if webdavStatus(err) == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Rename implements Renamer with the MOVE method, which keeps the file's
+// properties.
+func (d *WebDAVDestination) Rename(ctx context.Context, src, dst string) error {
+	if err := checkURLKey(src); err != nil {
+		return err
+	}
+	if err := checkURLKey(dst); err != nil {
+		return err
+	}
+	if err := d.mkdirs(ctx, dst); err != nil {
+		return err
+	}
+	header := http.Header{"Destination": {d.url(dst)}, "Overwrite": {"T"}}
+	resp, err := d.do(ctx, "MOVE", src, nil, header, http.StatusCreated, http.StatusNoContent)
+	if webdavStatus(err) == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List implements Destination a page of up to webdavListPage keys at a
+// time. It lists the whole tree with a single Depth: infinity PROPFIND,
+// or, on servers that refuse those, a collection at a time.
+func (d *WebDAVDestination) List(ctx context.Context, fn func(keys []string) error) error {
+	entries, err := d.listAll(ctx)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.key)
+	}
+	slices.Sort(keys)
+	for page := range slices.Chunk(keys, webdavListPage) {
+		if err := fn(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webdavListPage is the most keys a page of WebDAVDestination.List holds.
+const webdavListPage = 1000
+
+// listAll returns every file under the root.
+func (d *WebDAVDestination) listAll(ctx context.Context) ([]webdavEntry, error) {
+	entries, err := d.propfind(ctx, "", "infinity")
+	switch webdavStatus(err) {
+	case 0:
+	case http.StatusNotFound:
+		return nil, nil // nothing has been uploaded yet
+	case http.StatusForbidden, http.StatusBadRequest, http.StatusNotImplemented:
+		return d.walk(ctx, "")
+	default:
+		return nil, fmt.Errorf("list objects: %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list objects: %w", err)
+	}
+	return slices.DeleteFunc(entries, func(e webdavEntry) bool { return e.collection }), nil
+}
+
+// walk returns every file in the collection at key and the collections in
+// it, listing one collection at a time.
+func (d *WebDAVDestination) walk(ctx context.Context, key string) ([]webdavEntry, error) {
+	entries, err := d.propfind(ctx, key, "1")
+	if webdavStatus(err) == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list objects: %w", err)
+	}
+	var files []webdavEntry
+	for _, e := range entries {
+		switch {
+		case e.key == key:
+		case e.collection:
+			sub, err := d.walk(ctx, e.key)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, sub...)
+		default:
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+// Delete deletes the file at key. Deleting an absent file is not an error.
+func (d *WebDAVDestination) Delete(ctx context.Context, key string) error {
+	if err := checkURLKey(key); err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodDelete, key, nil, nil, http.StatusNoContent, http.StatusOK, http.StatusAccepted)
+	if webdavStatus(err) == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
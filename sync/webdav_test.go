@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// newWebDAVServer serves an in-memory WebDAV tree, with the root collection
+// the destination uses already created, and returns a destination for it.
+// If noInfinity is set the server refuses Depth: infinity listings, as
+// Nextcloud does by default.
+func newWebDAVServer(t *testing.T, noInfinity bool) *WebDAVDestination {
+	t.Helper()
+	fsys := webdav.NewMemFS()
+	if err := fsys.Mkdir(context.Background(), "/backup", 0755); err != nil {
+		t.Fatal(err)
+	}
+	h := &webdav.Handler{FileSystem: fsys, LockSystem: webdav.NewMemLS()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if noInfinity && r.Method == "PROPFIND" && r.Header.Get("Depth") == "infinity" {
+			http.Error(w, "Depth: infinity is not allowed", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	root, err := url.Parse(srv.URL + "/backup/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWebDAVDestination(srv.Client(), root, "", "")
+}
+
+func TestWebDAVDestination_roundTrip(t *testing.T) {
+	dst := newWebDAVServer(t, false)
+	ctx := context.Background()
+	mtime := time.Unix(1700000000, 0)
+	meta := ObjectMeta{Size: 5, ModTime: mtime, Sparse: true, Compression: "gzip", ContentType: "text/plain"}
+	if err := dst.Put(ctx, "a b/c?d.txt", strings.NewReader("hello"), meta); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dst.Stat(ctx, "a b/c?d.txt")
+	if err != nil || got == nil {
+		t.Fatalf("Stat = %v, %v", got, err)
+	}
+	if got.Size != 5 || !got.ModTime.Equal(mtime) || !got.Sparse || got.Compression != "gzip" {
+		t.Errorf("Stat = %+v, want the metadata that was put", got)
+	}
+	r, err := dst.Get(ctx, "a b/c?d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Get = %q, %v, want %q", data, err, "hello")
+	}
+
+	if err := dst.Copy(ctx, "a b/c?d.txt", "e/f.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dst.Stat(ctx, "e/f.txt"); err != nil || got == nil || !got.ModTime.Equal(mtime) {
+		t.Errorf("Stat after Copy = %v, %v, want mtime %v", got, err, mtime)
+	}
+	if err := dst.Copy(ctx, "missing.txt", "g.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Copy of a missing key: got %v, want fs.ErrNotExist", err)
+	}
+	if err := dst.Rename(ctx, "e/f.txt", "h/i.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dst.Stat(ctx, "h/i.txt"); err != nil || got == nil || !got.ModTime.Equal(mtime) {
+		t.Errorf("Stat after Rename = %v, %v, want mtime %v", got, err, mtime)
+	}
+	if got, err := dst.Stat(ctx, "e/f.txt"); err != nil || got != nil {
+		t.Errorf("Stat of the renamed key = %v, %v, want nil", got, err)
+	}
+
+	if err := dst.Delete(ctx, "a b/c?d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dst.Stat(ctx, "a b/c?d.txt"); err != nil || got != nil {
+		t.Errorf("Stat after Delete = %v, %v, want nil", got, err)
+	}
+	if _, err := dst.Get(ctx, "a b/c?d.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get after Delete: got %v, want fs.ErrNotExist", err)
+	}
+	if err := dst.Delete(ctx, "a b/c?d.txt"); err != nil {
+		t.Errorf("deleting an absent key: %v", err)
+	}
+}
+
+func TestWebDAVDestination_rejectsEscapingKeys(t *testing.T) {
+	dst := newWebDAVServer(t, false)
+	err := dst.Put(context.Background(), "../evil.txt", strings.NewReader("x"), ObjectMeta{Size: 1, ModTime: time.Now()})
+	if err == nil {
+		t.Error("expected error for key escaping the root, got nil")
+	}
+}
+
+func TestWebDAVDestination_list(t *testing.T) {
+	for _, noInfinity := range []bool{false, true} {
+		dst := newWebDAVServer(t, noInfinity)
+		ctx := context.Background()
+		for _, key := range []string{"b", "a/c/d", "a-b", "a/b"} {
+			if err := dst.Put(ctx, key, strings.NewReader("x"), ObjectMeta{Size: 1, ModTime: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		keys, err := listKeys(ctx, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a-b", "a/b", "a/c/d", "b"}; !slices.Equal(keys, want) {
+			t.Errorf("noInfinity=%v: listed %v, want %v", noInfinity, keys, want)
+		}
+	}
+}
+
+func TestWebDAVDestination_sync(t *testing.T) {
+	src := t.TempDir()
+	writeFile(t, src, "a.txt", "hello")
+	writeFile(t, src, "sub/b.txt", "world")
+
+	dst := newWebDAVServer(t, false)
+	ctx := context.Background()
+	if _, err := Sync(ctx, Options{Src: src, Dst: dst}); err != nil {
+		t.Fatal(err)
+	}
+	// A second run finds everything up to date.
+	plan, err := buildPlan(ctx, Options{Src: src, Dst: dst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Uploads) != 0 {
+		t.Errorf("expected no uploads on second run, got %v", plan.Uploads)
+	}
+}
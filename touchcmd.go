@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+)
+
+// runTouch implements "foldersync touch -src <dir> -dst <url> <pattern>...".
+// The patterns are saved locally and passed to the next sync run of the same
+// source and destination as sync.Options.Reupload.
+func runTouch(args []string) int {
+	fs := flag.NewFlagSet("touch", flag.ExitOnError)
+	src := fs.String("src", "", "source directory of the sync job (required)")
+	dstURL := fs.String("dst", "", "destination URL of the sync job (required)")
+	var alsoDsts stringsFlag
+	fs.Var(&alsoDsts, "also-dst", "further destination URL of the sync job; repeat for each")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync touch -src <dir> -dst <url> [-also-dst <url>]... <pattern>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *src == "" || *dstURL == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	for _, p := range fs.Args() {
+		if _, err := path.Match(p, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "pattern %q: %v\n", p, err)
+			return 2
+		}
+	}
+
+	file, err := cachePath("touch", *src, append([]string{*dstURL}, alsoDsts...)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "touch list: %v\n", err)
+		return 1
+	}
+	patterns, err := loadTouched(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "touch list: %v\n", err)
+		return 1
+	}
+	for _, p := range fs.Args() {
+		if !slices.Contains(patterns, p) {
+			patterns = append(patterns, p)
+		}
+	}
+	if err := saveTouched(file, patterns); err != nil {
+		fmt.Fprintf(os.Stderr, "touch list: %v\n", err)
+		return 1
+	}
+	fmt.Printf("files matching %d pattern(s) will be uploaded again on the next run\n", len(patterns))
+	return 0
+}
+
+type touchFile struct {
+	Patterns []string `json:"patterns"`
+}
+
+// loadTouched returns the patterns saved in the touch list at file, if any.
+func loadTouched(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t touchFile
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return t.Patterns, nil
+}
+
+// saveTouched replaces the touch list at file with patterns, or removes it
+// if there are none.
+func saveTouched(file string, patterns []string) error {
+	if len(patterns) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(touchFile{Patterns: patterns})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+// clearTouched removes the patterns a successful run has handled from the
+// touch list at file, keeping any added while it ran.
+func clearTouched(file string, done []string) error {
+	if len(done) == 0 {
+		return nil
+	}
+	patterns, err := loadTouched(file)
+	if err != nil {
+		return err
+	}
+	patterns = slices.DeleteFunc(patterns, func(p string) bool {
+		return slices.Contains(done, p)
+	})
+	return saveTouched(file, patterns)
+}
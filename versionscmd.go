@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sandeepkandula/foldersync/sync"
+)
+
+const versionsUsage = `usage: foldersync versions list -dst <url> [-prefix <prefix>] [-json]
+       foldersync versions count -dst <url> [-prefix <prefix>] [-min <n>] [-json]
+       foldersync versions restore -dst <url> -key <key> -version <id>
+       foldersync versions purge -dst <url> -older-than <age> [-keep <n>] [-prefix <prefix>] [-dry-run] [-verify-key <file>]`
+
+// runVersions implements "foldersync versions", which lists, counts,
+// restores and purges the versions a bucket with versioning keeps of the
+// objects a destination's runs replace and delete.
+func runVersions(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, versionsUsage)
+		return 2
+	}
+	switch args[0] {
+	case "list":
+		return runVersionsList(args[1:])
+	case "count":
+		return runVersionsCount(args[1:])
+	case "restore":
+		return runVersionsRestore(args[1:])
+	case "purge":
+		return runVersionsPurge(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, versionsUsage)
+	return 2
+}
+
+func runVersionsList(args []string) int {
+	fs := flag.NewFlagSet("versions list", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	prefix := fs.String("prefix", "", "list only the keys beginning with this, below the destination's prefix")
+	asJSON := fs.Bool("json", false, "print one JSON object per version instead of text")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync versions list -dst <url> [-prefix <prefix>] [-json]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	versions, code := listVersions(ctx, *dstURL, *region, *prefix)
+	if code != 0 {
+		return code
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for _, v := range versions {
+		if *asJSON {
+			enc.Encode(v)
+			continue
+		}
+		var note string
+		switch {
+		case v.DeleteMarker && v.Latest:
+			note = "  deleted"
+		case v.DeleteMarker:
+			note = "  delete marker"
+		case v.Latest:
+			note = "  current"
+		}
+		fmt.Printf("%s  %s  %9s  %s%s\n", v.Written.Format(time.RFC3339), v.VersionID, sync.FormatSize(v.Size), v.Key, note)
+	}
+	return 0
+}
+
+func runVersionsCount(args []string) int {
+	fs := flag.NewFlagSet("versions count", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	prefix := fs.String("prefix", "", "count only the keys beginning with this, below the destination's prefix")
+	minVersions := fs.Int("min", 2, "leave out keys with fewer versions and delete markers than this")
+	asJSON := fs.Bool("json", false, "print one JSON object per key instead of text")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync versions count -dst <url> [-prefix <prefix>] [-min <n>] [-json]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	versions, code := listVersions(ctx, *dstURL, *region, *prefix)
+	if code != 0 {
+		return code
+	}
+	enc := json.NewEncoder(os.Stdout)
+	var keys, total int
+	var noncurrent int64
+	for _, c := range sync.CountVersions(versions) {
+		total += c.Versions + c.DeleteMarkers
+		noncurrent += c.NoncurrentSize
+		if c.Versions+c.DeleteMarkers < *minVersions {
+			continue
+		}
+		keys++
+		switch {
+		case *asJSON:
+			enc.Encode(c)
+		case c.Deleted:
+			fmt.Printf("%5d  %9s  %s (deleted)\n", c.Versions, sync.FormatSize(c.NoncurrentSize), c.Key)
+		default:
+			fmt.Printf("%5d  %9s  %s\n", c.Versions, sync.FormatSize(c.NoncurrentSize), c.Key)
+		}
+	}
+	if !*asJSON {
+		fmt.Printf("%d keys with at least %d versions; %d versions in all, %s of them noncurrent\n",
+			keys, *minVersions, total, sync.FormatSize(noncurrent))
+	}
+	return 0
+}
+
+func runVersionsRestore(args []string) int {
+	fs := flag.NewFlagSet("versions restore", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	key := fs.String("key", "", "key of the object, below the destination's prefix (required)")
+	version := fs.String("version", "", "ID of the version to make current again, from foldersync versions list (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync versions restore -dst <url> -key <key> -version <id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || *key == "" || *version == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	dst, err := openRestoreDst(ctx, *dstURL, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	if err := sync.RestoreVersion(ctx, dst, *key, *version); err != nil {
+		fmt.Fprintf(os.Stderr, "restore version failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("version %s of %s is current again\n", *version, *key)
+	return 0
+}
+
+func runVersionsPurge(args []string) int {
+	fs := flag.NewFlagSet("versions purge", flag.ExitOnError)
+	dstURL := fs.String("dst", "", "destination URL (required)")
+	region := fs.String("region", "", "AWS region for s3:// destinations")
+	prefix := fs.String("prefix", "", "purge only the versions of keys beginning with this, below the destination's prefix")
+	olderThan := fs.String("older-than", "", "purge versions replaced or deleted longer ago than this, e.g. 30d or 12h (required; 0 for all)")
+	keep := fs.Int("keep", 0, "keep this many of the newest noncurrent versions of each key however old")
+	dryRun := fs.Bool("dry-run", false, "print the versions that would be purged without deleting them")
+	verifyKey := fs.String("verify-key", "", "Ed25519 public key (PEM) the manifest at the destination must be signed with before anything is purged")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: foldersync versions purge -dst <url> -older-than <age> [-keep <n>] [-prefix <prefix>] [-dry-run]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *dstURL == "" || *olderThan == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	age, err := sync.ParseAge(*olderThan)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-older-than: %v\n", err)
+		return 2
+	}
+	if *keep < 0 {
+		fmt.Fprintln(os.Stderr, "-keep must not be negative")
+		return 2
+	}
+	opts := sync.PurgeVersionsOptions{Prefix: *prefix, OlderThan: age, Keep: *keep, DryRun: *dryRun}
+	if *verifyKey != "" {
+		if opts.VerifyKey, err = sync.LoadVerifyKey(*verifyKey); err != nil {
+			fmt.Fprintf(os.Stderr, "verify key: %v\n", err)
+			return 1
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	dst, err := openRestoreDst(ctx, *dstURL, *region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return 1
+	}
+	purged, err := sync.PurgeVersions(ctx, dst, opts)
+	var size int64
+	for _, v := range purged {
+		fmt.Printf("purge %s version %s\n", v.Key, v.VersionID)
+		size += v.Size
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "purge versions failed: %v\n", err)
+		return 1
+	}
+	if *dryRun {
+		fmt.Printf("%d version(s) would be purged, %s\n", len(purged), sync.FormatSize(size))
+	} else {
+		fmt.Printf("%d version(s) purged, %s\n", len(purged), sync.FormatSize(size))
+	}
+	return 0
+}
+
+// listVersions returns the versions of the objects at dstURL beginning
+// with prefix, or the exit status of a failure to list them.
+func listVersions(ctx context.Context, dstURL, region, prefix string) ([]sync.ObjectVersion, int) {
+	dst, err := openRestoreDst(ctx, dstURL, region)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destination: %v\n", err)
+		return nil, 1
+	}
+	versions, err := sync.ListVersions(ctx, dst, prefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list versions failed: %v\n", err)
+		return nil, 1
+	}
+	return versions, 0
+}